@@ -16,10 +16,12 @@ package jupiter
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/douyu/jupiter/pkg/server/governor"
 	job "github.com/douyu/jupiter/pkg/worker/xjob"
@@ -37,6 +39,12 @@ import (
 	"github.com/douyu/jupiter/pkg/registry"
 	"github.com/douyu/jupiter/pkg/sentinel"
 	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/server/deprecation"
+	"github.com/douyu/jupiter/pkg/server/maintenance"
+	"github.com/douyu/jupiter/pkg/server/readiness"
+	"github.com/douyu/jupiter/pkg/server/rotation"
+	"github.com/douyu/jupiter/pkg/server/sizeguard"
+	"github.com/douyu/jupiter/pkg/server/weight"
 	"github.com/douyu/jupiter/pkg/signals"
 	"github.com/douyu/jupiter/pkg/trace"
 	"github.com/douyu/jupiter/pkg/trace/jaeger"
@@ -45,7 +53,12 @@ import (
 	"github.com/douyu/jupiter/pkg/util/xdefer"
 	"github.com/douyu/jupiter/pkg/util/xgo"
 	"github.com/douyu/jupiter/pkg/worker"
+	"github.com/douyu/jupiter/pkg/worker/xsupervisor"
+	"github.com/douyu/jupiter/pkg/xegress"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+	"github.com/douyu/jupiter/pkg/xskew"
+	"github.com/douyu/jupiter/pkg/xsystemd"
 	"go.uber.org/automaxprocs/maxprocs"
 	"golang.org/x/sync/errgroup"
 )
@@ -73,6 +86,11 @@ type Application struct {
 	hooks        map[uint32]*xdefer.DeferStack
 	configParser conf.Unmarshaller
 	disableMap   map[Disable]bool
+	leakDrain    time.Duration
+	// serviceDone reports the windows service stopped
+	serviceDone func()
+	// notifier notifies systemd if the application is managed by it
+	notifier *xsystemd.Notifier
 }
 
 //New new a Application
@@ -152,6 +170,14 @@ func (app *Application) startup() (err error) {
 			app.initTracer,
 			app.initSentinel,
 			app.initGovernor,
+			app.initMaintenance,
+			app.initDeprecation,
+			app.initEgress,
+			app.initWeight,
+			app.initRotation,
+			app.initSkew,
+			app.initSupervisor,
+			app.initSystemd,
 		)()
 	})
 	return
@@ -227,6 +253,21 @@ func (app *Application) Job(runner job.Runner) error {
 	return nil
 }
 
+// Depend checks dependencies until the application stops, it's not ready
+// while hard ones are down, see readiness.Dependency
+func (app *Application) Depend(deps ...readiness.Dependency) error {
+	for _, dep := range deps {
+		stop := readiness.Depend(dep)
+		if err := app.RegisterHooks(StageAfterStop, func() error {
+			stop()
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // SetRegistry set customize registry
 func (app *Application) SetRegistry(reg registry.Registry) {
 	app.registerer = reg
@@ -254,6 +295,9 @@ func (app *Application) Run(servers ...server.Server) error {
 	app.cycle.Run(app.startServers)
 	// start workers
 	app.cycle.Run(app.startWorkers)
+	if app.notifier != nil {
+		app.notifier.Ready()
+	}
 
 	//blocking and wait quit
 	if err := <-app.cycle.Wait(); err != nil {
@@ -268,6 +312,9 @@ func (app *Application) Run(servers ...server.Server) error {
 func (app *Application) clean() {
 	_ = xlog.DefaultLogger.Flush()
 	_ = xlog.JupiterLogger.Flush()
+	if app.serviceDone != nil {
+		app.serviceDone()
+	}
 }
 
 // Stop application immediately after necessary cleanup
@@ -276,7 +323,8 @@ func (app *Application) Stop() (err error) {
 		app.runHooks(StageBeforeStop)
 
 		if app.registerer != nil {
-			err = app.registerer.Close()
+			// servers stop at once, there's nothing to drain
+			err = registry.CloseNow(app.registerer)
 			if err != nil {
 				app.logger.Error("stop register close err", xlog.FieldMod(ecode.ModApp), xlog.FieldErr(err))
 			}
@@ -298,6 +346,7 @@ func (app *Application) Stop() (err error) {
 		}
 		<-app.cycle.Done()
 		app.runHooks(StageAfterStop)
+		app.reportLeaks()
 		app.cycle.Close()
 	})
 	return
@@ -333,14 +382,36 @@ func (app *Application) GracefulStop(ctx context.Context) (err error) {
 		}
 		<-app.cycle.Done()
 		app.runHooks(StageAfterStop)
+		app.reportLeaks()
 		app.cycle.Close()
 	})
 	return err
 }
 
+// reportLeaks reports goroutines not exited within drain window after stop
+func (app *Application) reportLeaks() {
+	if app.leakDrain <= 0 {
+		return
+	}
+	for _, leak := range xgo.WaitLeaks(app.leakDrain) {
+		app.logger.Warn("goroutine leak",
+			xlog.FieldMod(ecode.ModApp),
+			xlog.FieldName(leak.Component),
+			xlog.FieldCost(time.Since(leak.Created)),
+			xlog.FieldStack([]byte(leak.Stack)),
+		)
+	}
+}
+
 // waitSignals wait signal
 func (app *Application) waitSignals() {
 	app.logger.Info("init listen signal", xlog.FieldMod(ecode.ModApp), xlog.FieldEvent("init"))
+	// stop and shutdown requests of windows services are signals too
+	done, err := signals.ServeService(pkg.Name())
+	if err != nil {
+		app.logger.Error("serve windows service", xlog.FieldMod(ecode.ModApp), xlog.FieldErr(err))
+	}
+	app.serviceDone = done
 	signals.Shutdown(func(grace bool) { //when get shutdown signal
 		//todo: support timeout
 		if grace {
@@ -364,13 +435,140 @@ func (app *Application) initGovernor() error {
 	return app.Serve(config.Build())
 }
 
+// initMaintenance loads maintenance switch, services are unregistered under
+// maintenance if configured, and registered again once it's off
+func (app *Application) initMaintenance() error {
+	maintenance.Load()
+	maintenance.OnChange(func(enabled bool) {
+		if !maintenance.Deregister() || registry.Deregistered() {
+			return
+		}
+		if !enabled {
+			if err := registry.RegisterServices(context.TODO(), app.registerer, app.registeredInfos()); err != nil {
+				app.logger.Error("maintenance register", xlog.FieldMod(ecode.ModApp), xlog.FieldErr(err))
+			}
+			return
+		}
+		app.smu.RLock()
+		defer app.smu.RUnlock()
+		for _, s := range app.servers {
+			if err := app.registerer.UnregisterService(context.TODO(), s.Info()); err != nil {
+				app.logger.Error("maintenance unregister", xlog.FieldMod(ecode.ModApp), xlog.FieldName(s.Info().Name), xlog.FieldErr(err))
+			}
+		}
+	})
+	return nil
+}
+
+// initDeprecation loads deprecated endpoints and response size thresholds,
+// which are watched on config changes
+func (app *Application) initDeprecation() error {
+	deprecation.Load()
+	sizeguard.Load()
+	return nil
+}
+
+// initEgress loads the egress policy of clients, which is watched on config
+// changes
+func (app *Application) initEgress() error {
+	xegress.Load()
+	return nil
+}
+
+// initWeight registers services again at once with the weight overridden on
+// governor
+func (app *Application) initWeight() error {
+	weight.OnChange(func() {
+		if maintenance.Enabled() && maintenance.Deregister() || registry.Deregistered() {
+			return
+		}
+		if err := registry.RegisterServices(context.TODO(), app.registerer, app.registeredInfos()); err != nil {
+			app.logger.Error("weight register", xlog.FieldMod(ecode.ModApp), xlog.FieldErr(err))
+		}
+	})
+	return nil
+}
+
+// initRotation registers services again with the status set on governor, so
+// that the instance is pulled out of or put back into rotation of consumers
+func (app *Application) initRotation() error {
+	rotation.OnChange(func(status string) {
+		if maintenance.Enabled() && maintenance.Deregister() || registry.Deregistered() {
+			return
+		}
+		if err := registry.RegisterServices(context.TODO(), app.registerer, app.registeredInfos()); err != nil {
+			app.logger.Error("rotation register", xlog.FieldMod(ecode.ModApp), xlog.FieldErr(err))
+		}
+	})
+	return nil
+}
+
+// registeredInfo returns info of s registered with the weight and the status
+// overridden on governor
+func (app *Application) registeredInfo(s server.Server) *server.ServiceInfo {
+	return rotation.Apply(weight.Apply(s.Info()))
+}
+
+// registeredInfos returns infos of all servers as they're registered
+func (app *Application) registeredInfos() []*server.ServiceInfo {
+	app.smu.RLock()
+	defer app.smu.RUnlock()
+	var infos = make([]*server.ServiceInfo, 0, len(app.servers))
+	for _, s := range app.servers {
+		infos = append(infos, app.registeredInfo(s))
+	}
+	return infos
+}
+
+// initSkew checks clock skew on start and periodically if configured
+func (app *Application) initSkew() error {
+	if conf.Get(xskew.ConfigKey) == nil {
+		return nil
+	}
+	checker := xskew.StdConfig().Build()
+	checker.Start()
+	return app.RegisterHooks(StageAfterStop, checker.Stop)
+}
+
+// initSupervisor schedules the supervisor of auxiliary processes if configured
+func (app *Application) initSupervisor() error {
+	if conf.Get(xsupervisor.ConfigKey) == nil {
+		return nil
+	}
+	return app.Schedule(xsupervisor.StdConfig().Build())
+}
+
+// initSystemd sends watchdog heartbeats and notifies systemd of readiness
+// and stopping if the application is started by systemd with notify support
+func (app *Application) initSystemd() error {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return nil
+	}
+	config := xsystemd.DefaultConfig()
+	if conf.Get(xsystemd.ConfigKey) != nil {
+		config = xsystemd.StdConfig()
+	}
+	app.notifier = config.Build()
+	app.notifier.Start()
+	if err := app.RegisterHooks(StageBeforeStop, app.notifier.Stopping); err != nil {
+		return err
+	}
+	return app.RegisterHooks(StageAfterStop, app.notifier.Stop)
+}
+
 func (app *Application) startServers() error {
 	var eg errgroup.Group
+	// all servers are registered at once, so that they're never half-registered
+	if !maintenance.Enabled() || !maintenance.Deregister() {
+		if err := registry.RegisterServices(context.TODO(), app.registerer, app.registeredInfos()); err != nil {
+			app.logger.Error("register services", xlog.FieldMod(ecode.ModApp), xlog.FieldErr(err))
+		}
+	}
+	registry.ServeAdmin(app.registerer, app.registeredInfos)
 	// start multi servers
 	for _, s := range app.servers {
 		s := s
 		eg.Go(func() (err error) {
-			_ = app.registerer.RegisterService(context.TODO(), s.Info())
 			defer app.registerer.UnregisterService(context.TODO(), s.Info())
 			app.logger.Info("start server", xlog.FieldMod(ecode.ModApp), xlog.FieldEvent("init"), xlog.FieldName(s.Info().Name), xlog.FieldAddr(s.Info().Label()), xlog.Any("scheme", s.Info().Scheme))
 			defer app.logger.Info("exit server", xlog.FieldMod(ecode.ModApp), xlog.FieldEvent("exit"), xlog.FieldName(s.Info().Name), xlog.FieldErr(err), xlog.FieldAddr(s.Info().Label()))
@@ -401,11 +599,12 @@ func (app *Application) startJobs() error {
 	var jobs = make([]func(), 0)
 	//warp jobs
 	for name, runner := range app.jobs {
+		name, runner := name, runner
 		jobs = append(jobs, func() {
 			app.logger.Info("job run begin", xlog.FieldName(name))
 			defer app.logger.Info("job run end", xlog.FieldName(name))
 			// runner.Run panic 错误在更上层抛出
-			runner.Run()
+			job.Run(name, runner)
 		})
 	}
 	xgo.Parallel(jobs...)()
@@ -433,6 +632,28 @@ func (app *Application) parseFlags() error {
 		EnvVar:  "JUPITER_CONFIG_WATCH",
 	})
 
+	flag.Register(&flag.BoolFlag{
+		Name:    "config-schema",
+		Usage:   "--config-schema, print json schema of configs of imported components",
+		Default: false,
+		Action: func(string, *flag.FlagSet) {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "    ")
+			_ = encoder.Encode(xschema.JSONSchema(xschema.Components()))
+			os.Exit(0)
+		},
+	})
+
+	flag.Register(&flag.BoolFlag{
+		Name:    "config-sample",
+		Usage:   "--config-sample, print sample config of imported components",
+		Default: false,
+		Action: func(string, *flag.FlagSet) {
+			fmt.Print(xschema.Sample(xschema.Components()))
+			os.Exit(0)
+		},
+	})
+
 	flag.Register(&flag.BoolFlag{
 		Name:    "version",
 		Usage:   "--version, print version",
@@ -462,6 +683,17 @@ func (app *Application) loadConfig() error {
 		if err := conf.LoadFromDataSource(provider, app.configParser); err != nil {
 			app.logger.Panic("data source: load config", xlog.FieldMod(ecode.ModConfig), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err))
 		}
+		// typos of keys are silently ignored by unmarshal, so warn about them
+		for _, err := range xschema.Validate(map[string]interface{}{"jupiter": conf.GetStringMap("jupiter")}) {
+			switch err := err.(type) {
+			case *xschema.UnknownKeyError:
+				app.logger.Warn("data source: unknown config key", xlog.FieldMod(ecode.ModConfig), xlog.FieldKey(err.Key), xlog.String("suggestion", err.Suggestion))
+			case *xschema.DeprecatedKeyError:
+				app.logger.Warn("data source: deprecated config key", xlog.FieldMod(ecode.ModConfig), xlog.FieldKey(err.Key), xlog.String("replacement", err.Replacement))
+			default:
+				app.logger.Warn("data source: invalid config", xlog.FieldMod(ecode.ModConfig), xlog.FieldErr(err))
+			}
+		}
 	} else {
 		app.logger.Info("no config... ", xlog.FieldMod(ecode.ModConfig))
 	}
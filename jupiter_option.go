@@ -1,6 +1,11 @@
 package jupiter
 
-import "github.com/douyu/jupiter/pkg/conf"
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+)
 
 type Option func(a *Application)
 
@@ -29,3 +34,12 @@ func WithDisable(d Disable) Option {
 		a.disableMap[d] = true
 	}
 }
+
+// WithGoroutineLeakCheck tracks goroutines started by the framework,
+// those not exited within drain after stop are reported with their creation stacks
+func WithGoroutineLeakCheck(drain time.Duration) Option {
+	return func(a *Application) {
+		xgo.SetTracking(true)
+		a.leakDrain = drain
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"google.golang.org/grpc"
+)
+
+// ErrBudgetExceeded is returned for non-critical writes once the budget of
+// the window is used up
+var ErrBudgetExceeded = errors.New("etcd budget exceeded")
+
+// BudgetConfig limits etcd usage of the process within each window, so that
+// a runaway client doesn't overload a shared cluster. Critical requests, see
+// WithCritical, are never throttled but counted.
+type BudgetConfig struct {
+	// Requests limits requests per window, unlimited if not positive
+	Requests int64 `json:"requests" toml:"requests"`
+	// WriteBytes limits bytes written per window, unlimited if not positive
+	WriteBytes int64 `json:"writeBytes" toml:"writeBytes"`
+	// Window defaults to 1m
+	Window time.Duration `json:"window" toml:"window"`
+}
+
+var (
+	budgetRequestCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "etcd_budget_requests_total",
+		Labels:    []string{"endpoints", "kind"},
+	}.Build()
+	budgetWriteBytesCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "etcd_budget_write_bytes_total",
+		Labels:    []string{"endpoints"},
+	}.Build()
+	budgetThrottledCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "etcd_budget_throttled_total",
+		Labels:    []string{"endpoints"},
+	}.Build()
+	budgetUsageGauge = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "etcd_budget_usage_ratio",
+		Labels:    []string{"endpoints", "resource"},
+	}.Build()
+)
+
+type criticalKey struct{}
+
+// WithCritical returns a context with which requests are never throttled by
+// the budget, e.g. service registrations
+func WithCritical(ctx context.Context) context.Context {
+	return context.WithValue(ctx, criticalKey{}, true)
+}
+
+func isCritical(ctx context.Context) bool {
+	critical, _ := ctx.Value(criticalKey{}).(bool)
+	return critical
+}
+
+// Enabled reports whether any limit is set
+func (config BudgetConfig) Enabled() bool {
+	return config.Requests > 0 || config.WriteBytes > 0
+}
+
+// BudgetUsage is the usage of the current window
+type BudgetUsage struct {
+	Requests   int64 `json:"requests"`
+	WriteBytes int64 `json:"writeBytes"`
+	Throttled  int64 `json:"throttled"`
+}
+
+type budget struct {
+	config    BudgetConfig
+	endpoints string
+	clock     xtime.Clock
+	logger    *xlog.Logger
+
+	mu    sync.Mutex
+	start time.Time
+	usage BudgetUsage
+}
+
+func newBudget(config BudgetConfig, endpoints []string, logger *xlog.Logger) *budget {
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+	return &budget{
+		config:    config,
+		endpoints: strings.Join(endpoints, ","),
+		clock:     xtime.SystemClock,
+		logger:    logger,
+	}
+}
+
+// admit counts a request writing size bytes, non-critical writes are
+// rejected once either limit of the window is reached
+func (b *budget) admit(write bool, size int64, critical bool) error {
+	b.mu.Lock()
+	now := b.clock.Now()
+	if now.Sub(b.start) >= b.config.Window {
+		if b.usage.Throttled > 0 {
+			b.logger.Warn("etcd budget exceeded", xlog.Int64("requests", b.usage.Requests), xlog.Int64("writeBytes", b.usage.WriteBytes), xlog.Int64("throttled", b.usage.Throttled))
+		}
+		b.start, b.usage = now, BudgetUsage{}
+	}
+	if write && !critical && b.exceeded(size) {
+		b.usage.Throttled++
+		b.mu.Unlock()
+		budgetThrottledCounter.Inc(b.endpoints)
+		return ErrBudgetExceeded
+	}
+	b.usage.Requests++
+	if write {
+		b.usage.WriteBytes += size
+	}
+	usage := b.usage
+	b.mu.Unlock()
+
+	kind := "read"
+	if write {
+		kind = "write"
+		budgetWriteBytesCounter.Add(float64(size), b.endpoints)
+	}
+	budgetRequestCounter.Inc(b.endpoints, kind)
+	if b.config.Requests > 0 {
+		budgetUsageGauge.Set(float64(usage.Requests)/float64(b.config.Requests), b.endpoints, "requests")
+	}
+	if b.config.WriteBytes > 0 {
+		budgetUsageGauge.Set(float64(usage.WriteBytes)/float64(b.config.WriteBytes), b.endpoints, "writeBytes")
+	}
+	return nil
+}
+
+// exceeded reports whether a write of size would exceed the budget, b.mu
+// must be held
+func (b *budget) exceeded(size int64) bool {
+	return (b.config.Requests > 0 && b.usage.Requests >= b.config.Requests) ||
+		(b.config.WriteBytes > 0 && b.usage.WriteBytes+size > b.config.WriteBytes)
+}
+
+// Usage returns the usage of the current window
+func (b *budget) Usage() BudgetUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.clock.Now().Sub(b.start) >= b.config.Window {
+		return BudgetUsage{}
+	}
+	return b.usage
+}
+
+// interceptor admits unary requests, writes are requests of the KV service
+// other than Range
+func (b *budget) interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		write := strings.HasPrefix(method, "/etcdserverpb.KV/") && method != "/etcdserverpb.KV/Range"
+		var size int64
+		if sized, ok := req.(interface{ Size() int }); ok && write {
+			size = int64(sized.Size())
+		}
+		if err := b.admit(write, size, isCritical(ctx)); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func newTestBudget(config BudgetConfig) (*budget, *xtime.MockClock) {
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	b := newBudget(config, []string{"127.0.0.1:2379"}, xlog.DefaultLogger)
+	b.clock = clock
+	return b, clock
+}
+
+func Test_budget_admit(t *testing.T) {
+	b, clock := newTestBudget(BudgetConfig{Requests: 3, WriteBytes: 100})
+
+	assert.Nil(t, b.admit(true, 60, false))
+	// a non-critical write over bytes is throttled, a critical one isn't
+	assert.Equal(t, ErrBudgetExceeded, b.admit(true, 50, false))
+	assert.Nil(t, b.admit(true, 50, true))
+	// reads aren't throttled
+	assert.Nil(t, b.admit(false, 0, false))
+	assert.Equal(t, BudgetUsage{Requests: 3, WriteBytes: 110, Throttled: 1}, b.Usage())
+	assert.Equal(t, ErrBudgetExceeded, b.admit(true, 1, false))
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, BudgetUsage{}, b.Usage())
+	assert.Nil(t, b.admit(true, 1, false))
+	assert.Equal(t, BudgetUsage{Requests: 1, WriteBytes: 1}, b.Usage())
+}
+
+type sizedRequest int
+
+func (r sizedRequest) Size() int { return int(r) }
+
+func Test_budget_interceptor(t *testing.T) {
+	b, _ := newTestBudget(BudgetConfig{WriteBytes: 10})
+	var invoked []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked = append(invoked, method)
+		return nil
+	}
+	intercept := b.interceptor()
+
+	assert.Nil(t, intercept(context.Background(), "/etcdserverpb.KV/Put", sizedRequest(8), nil, nil, invoker))
+	assert.Equal(t, ErrBudgetExceeded, intercept(context.Background(), "/etcdserverpb.KV/Txn", sizedRequest(8), nil, nil, invoker))
+	assert.Nil(t, intercept(WithCritical(context.Background()), "/etcdserverpb.KV/Txn", sizedRequest(8), nil, nil, invoker))
+	assert.Nil(t, intercept(context.Background(), "/etcdserverpb.KV/Range", sizedRequest(100), nil, nil, invoker))
+	assert.Nil(t, intercept(context.Background(), "/etcdserverpb.Lease/LeaseGrant", sizedRequest(100), nil, nil, invoker))
+	assert.Equal(t, []string{"/etcdserverpb.KV/Put", "/etcdserverpb.KV/Txn", "/etcdserverpb.KV/Range", "/etcdserverpb.Lease/LeaseGrant"}, invoked)
+	assert.Equal(t, int64(16), b.Usage().WriteBytes)
+}
@@ -36,6 +36,7 @@ import (
 type Client struct {
 	*clientv3.Client
 	config *Config
+	budget *budget
 }
 
 // New ...
@@ -46,7 +47,6 @@ func newClient(config *Config) *Client {
 		DialKeepAliveTime:    10 * time.Second,
 		DialKeepAliveTimeout: 3 * time.Second,
 		DialOptions: []grpc.DialOption{
-			grpc.WithBlock(),
 			grpc.WithUnaryInterceptor(grpcprom.UnaryClientInterceptor),
 			grpc.WithStreamInterceptor(grpcprom.StreamClientInterceptor),
 		},
@@ -59,6 +59,10 @@ func newClient(config *Config) *Client {
 		config.logger.Panic("client etcd endpoints empty", xlog.FieldMod(ecode.ModClientETCD), xlog.FieldValueAny(config))
 	}
 
+	if config.Block {
+		conf.DialOptions = append(conf.DialOptions, grpc.WithBlock())
+	}
+
 	if !config.Secure {
 		conf.DialOptions = append(conf.DialOptions, grpc.WithInsecure())
 	}
@@ -101,6 +105,12 @@ func newClient(config *Config) *Client {
 		conf.TLS = tlsConfig
 	}
 
+	var b *budget
+	if config.Budget.Enabled() {
+		b = newBudget(config.Budget, config.Endpoints, config.logger)
+		conf.DialOptions = append(conf.DialOptions, grpc.WithChainUnaryInterceptor(b.interceptor()))
+	}
+
 	client, err := clientv3.New(conf)
 
 	if err != nil {
@@ -110,12 +120,22 @@ func newClient(config *Config) *Client {
 	cc := &Client{
 		Client: client,
 		config: config,
+		budget: b,
 	}
 
 	config.logger.Info("dial etcd server")
 	return cc
 }
 
+// BudgetUsage returns usage of the current budget window, which is zero if
+// the budget is disabled
+func (client *Client) BudgetUsage() BudgetUsage {
+	if client.budget == nil {
+		return BudgetUsage{}
+	}
+	return client.budget.Usage()
+}
+
 // GetKeyValue queries etcd key, returns mvccpb.KeyValue
 func (client *Client) GetKeyValue(ctx context.Context, key string) (kv *mvccpb.KeyValue, err error) {
 	rp, err := client.Client.Get(ctx, key)
@@ -21,8 +21,13 @@ import (
 	"github.com/douyu/jupiter/pkg/ecode"
 	"github.com/douyu/jupiter/pkg/util/xtime"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 )
 
+func init() {
+	xschema.RegisterConfig("etcdv3", "jupiter.etcdv3.*", "etcd client", DefaultConfig)
+}
+
 // Config ...
 type (
 	Config struct {
@@ -36,10 +41,16 @@ type (
 		// 连接超时时间
 		ConnectTimeout time.Duration `json:"connectTimeout"`
 		Secure         bool          `json:"secure"`
+		// Block waits for connections on Build, which panics if etcd is
+		// unreachable in ConnectTimeout, or else connections are made
+		// in background and requests fail until they're ready
+		Block bool `json:"block"`
 		// 自动同步member list的间隔
 		AutoSyncInterval time.Duration `json:"autoAsyncInterval"`
 		TTL              int           // 单位：s
 		logger           *xlog.Logger
+		// Budget limits etcd usage of the process, disabled by default
+		Budget BudgetConfig `json:"budget"`
 	}
 )
 
@@ -48,6 +59,7 @@ func DefaultConfig() *Config {
 	return &Config{
 		BasicAuth:      false,
 		ConnectTimeout: xtime.Duration("5s"),
+		Block:          true,
 		Secure:         false,
 		logger:         xlog.JupiterLogger.With(xlog.FieldMod("client.etcd")),
 	}
@@ -17,54 +17,88 @@ package etcdv3
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/coreos/etcd/clientv3"
 	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
 	"github.com/douyu/jupiter/pkg/util/xgo"
 	"github.com/douyu/jupiter/pkg/xlog"
 )
 
+// rewatchBackoff is the jittered backoff of re-establishing a broken watch,
+// so that watchers of all instances don't reconnect at the same time
+var rewatchBackoff = xbackoff.Config{
+	BaseDelay:  100 * time.Millisecond,
+	MaxDelay:   5 * time.Second,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+}
+
+// Resync is the full list of keys with the prefix at Revision, which is sent
+// once events of the watch are lost, e.g. revisions watched are compacted.
+// Consumers should replace their state with KeyValues and ignore events of
+// C not newer than Revision. The watch is resumed after a resync is received,
+// so that events newer than Revision are never sent before it.
+type Resync struct {
+	Revision  int64
+	KeyValues []*mvccpb.KeyValue
+}
+
 // Watch A watch only tells the latest revision
 type Watch struct {
-	revision  int64
-	cancel    context.CancelFunc
-	eventChan chan *clientv3.Event
-	lock      *sync.RWMutex
-	logger    *xlog.Logger
+	revision   int64
+	cancel     context.CancelFunc
+	eventChan  chan *clientv3.Event
+	resyncChan chan Resync
+	lock       *sync.RWMutex
+	logger     *xlog.Logger
 
 	incipientKVs []*mvccpb.KeyValue
 }
 
-// C ...
+// C returns events of the watch, which is closed after the watch is closed
 func (w *Watch) C() chan *clientv3.Event {
 	return w.eventChan
 }
 
+// Resync returns resyncs of the watch, which must be received along with C
+func (w *Watch) Resync() <-chan Resync {
+	return w.resyncChan
+}
+
 // IncipientKeyValues incipient key and values
 func (w *Watch) IncipientKeyValues() []*mvccpb.KeyValue {
 	return w.incipientKVs
 }
 
-// NewWatch ...
-func (client *Client) WatchPrefix(ctx context.Context, prefix string) (*Watch, error) {
-	resp, err := client.Get(ctx, prefix, clientv3.WithPrefix())
+// WatchPrefix watches keys with prefix, opts are applied to the initial
+// read of incipient key values, e.g. clientv3.WithSerializable()
+func (client *Client) WatchPrefix(ctx context.Context, prefix string, opts ...clientv3.OpOption) (*Watch, error) {
+	resp, err := client.Get(ctx, prefix, append([]clientv3.OpOption{clientv3.WithPrefix()}, opts...)...)
 	if err != nil {
 		return nil, err
 	}
 
+	// ctx only bounds the initial read, the watch lasts until it's closed
+	ctx, cancel := context.WithCancel(context.Background())
 	var w = &Watch{
 		revision:     resp.Header.Revision,
+		cancel:       cancel,
 		eventChan:    make(chan *clientv3.Event, 100),
+		resyncChan:   make(chan Resync),
 		incipientKVs: resp.Kvs,
 	}
 
 	xgo.Go(func() {
-		ctx, cancel := context.WithCancel(context.Background())
-		w.cancel = cancel
+		// C is closed once the watch is closed
+		defer close(w.eventChan)
 		rch := client.Client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCreatedNotify(), clientv3.WithRev(w.revision))
-		for {
+		for retries := 0; ; retries++ {
+			var compacted bool
 			for n := range rch {
+				retries = 0
 				if n.CompactRevision > w.revision {
 					w.revision = n.CompactRevision
 				}
@@ -72,6 +106,11 @@ func (client *Client) WatchPrefix(ctx context.Context, prefix string) (*Watch, e
 					w.revision = n.Header.GetRevision()
 				}
 				if err := n.Err(); err != nil {
+					if ctx.Err() != nil {
+						break
+					}
+					// etcd closes the watch after revisions watched are compacted
+					compacted = compacted || n.CompactRevision != 0
 					xlog.Error(ecode.MsgWatchRequestErr, xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldAddr(prefix))
 					continue
 				}
@@ -83,8 +122,18 @@ func (client *Client) WatchPrefix(ctx context.Context, prefix string) (*Watch, e
 					}
 				}
 			}
-			ctx, cancel := context.WithCancel(context.Background())
-			w.cancel = cancel
+			if compacted {
+				// events since the last one received are lost
+				if !w.resync(ctx, client, prefix) {
+					return
+				}
+			} else {
+				select {
+				case <-time.After(rewatchBackoff.Backoff(retries)):
+				case <-ctx.Done():
+					return
+				}
+			}
 			if w.revision > 0 {
 				rch = client.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCreatedNotify(), clientv3.WithRev(w.revision))
 			} else {
@@ -96,7 +145,39 @@ func (client *Client) WatchPrefix(ctx context.Context, prefix string) (*Watch, e
 	return w, nil
 }
 
-// Close close watch
+// resync lists keys with prefix again until it succeeds or ctx is done, the
+// watch is resumed after the revision listed
+func (w *Watch) resync(ctx context.Context, client *Client, prefix string) bool {
+	for retries := 0; ; retries++ {
+		resp, err := client.Get(ctx, prefix, clientv3.WithPrefix())
+		if err == nil {
+			xlog.Warn("watch etcd resync", xlog.FieldAddr(prefix), xlog.Int64("revision", resp.Header.Revision), xlog.Int("keys", len(resp.Kvs)))
+			w.revision = resp.Header.Revision + 1
+			return w.sendResync(ctx, Resync{Revision: resp.Header.Revision, KeyValues: resp.Kvs})
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		xlog.Error("watch etcd resync", xlog.FieldErr(err), xlog.FieldAddr(prefix))
+		select {
+		case <-time.After(rewatchBackoff.Backoff(retries)):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// sendResync blocks until the resync is received or ctx is done
+func (w *Watch) sendResync(ctx context.Context, resync Resync) bool {
+	select {
+	case w.resyncChan <- resync:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Close stops the watch, it's safe to be called multiple times
 func (w *Watch) Close() error {
 	if w.cancel != nil {
 		w.cancel()
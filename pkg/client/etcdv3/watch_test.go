@@ -0,0 +1,66 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Watch_sendResync(t *testing.T) {
+	w := &Watch{resyncChan: make(chan Resync)}
+	sent := make(chan bool)
+	go func() { sent <- w.sendResync(context.Background(), Resync{Revision: 1}) }()
+	// blocks until received
+	select {
+	case <-sent:
+		t.Fatal("resync sent before received")
+	case <-time.After(10 * time.Millisecond):
+	}
+	assert.Equal(t, int64(1), (<-w.Resync()).Revision)
+	assert.True(t, <-sent)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, w.sendResync(ctx, Resync{Revision: 2}))
+}
+
+func Test_Watch_resync(t *testing.T) {
+	config := DefaultConfig()
+	config.Endpoints = []string{"127.0.0.1:2379"}
+	etcdCli := newClient(config)
+
+	ctx := context.TODO()
+	_, err := etcdCli.Delete(ctx, "/test/resync/", clientv3.WithPrefix())
+	assert.Nil(t, err)
+	resp, err := etcdCli.Put(ctx, "/test/resync/a", "1")
+	assert.Nil(t, err)
+
+	w := &Watch{resyncChan: make(chan Resync)}
+	resynced := make(chan bool)
+	go func() { resynced <- w.resync(ctx, etcdCli, "/test/resync/") }()
+	resync := <-w.Resync()
+	assert.True(t, <-resynced)
+	assert.Equal(t, resp.Header.Revision, resync.Revision)
+	assert.Equal(t, []*mvccpb.KeyValue{{Key: []byte("/test/resync/a"), Value: []byte("1"),
+		CreateRevision: resp.Header.Revision, ModRevision: resp.Header.Revision, Version: 1}}, resync.KeyValues)
+	// the watch resumes after the revision listed
+	assert.Equal(t, resp.Header.Revision+1, w.revision)
+}
@@ -17,7 +17,9 @@ package balancer
 import (
 	"errors"
 	"fmt"
+	"reflect"
 
+	"github.com/douyu/jupiter/pkg/util/xoutlier"
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
@@ -48,6 +50,7 @@ func (bb *baseBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions)
 		v2PickerBuilder: bb.v2PickerBuilder,
 
 		subConns: make(map[resolver.Address]balancer.SubConn),
+		addrs:    make(map[resolver.Address]resolver.Address),
 		scStates: make(map[balancer.SubConn]connectivity.State),
 		csEvltr:  &balancer.ConnectivityStateEvaluator{},
 		config:   bb.config,
@@ -73,11 +76,16 @@ type baseBalancer struct {
 	csEvltr *balancer.ConnectivityStateEvaluator
 	state   connectivity.State
 
+	// subConns and addrs are keyed by addresses without attributes, so that
+	// changes of attributes, e.g. weights, update addrs instead of dialing
 	subConns   map[resolver.Address]balancer.SubConn
+	addrs      map[resolver.Address]resolver.Address
 	scStates   map[balancer.SubConn]connectivity.State
 	v2Picker   balancer.V2Picker
 	config     base.Config
 	attributes *attributes.Attributes
+	// detector ejects outliers if the consumer config enables it
+	detector *xoutlier.Detector
 }
 
 // HandleResolvedAddrs ...
@@ -104,9 +112,15 @@ func (b *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
 	addrsSet := make(map[resolver.Address]struct{})
 	fmt.Printf("s.ResolverState.Addresses = %+v\n", s.ResolverState.Addresses)
 	fmt.Printf("s.ResolverState.Attributes = %+v\n", s.ResolverState.Attributes)
+	var changed bool
 	for _, a := range s.ResolverState.Addresses {
-		addrsSet[a] = struct{}{}
-		if _, ok := b.subConns[a]; !ok {
+		key := addressKey(a)
+		addrsSet[key] = struct{}{}
+		if prev, ok := b.addrs[key]; ok && !reflect.DeepEqual(prev.Attributes, a.Attributes) {
+			changed = true
+		}
+		b.addrs[key] = a
+		if _, ok := b.subConns[key]; !ok {
 			// a is a new address (not existing in b.subConns).
 			sc, err := b.cc.NewSubConn(
 				[]resolver.Address{a},
@@ -116,26 +130,42 @@ func (b *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
 				grpclog.Warningf("base.baseBalancer: failed to create new SubConn: %v", err)
 				continue
 			}
-			b.subConns[a] = sc
+			b.subConns[key] = sc
 			b.scStates[sc] = connectivity.Idle
 			sc.Connect()
 		}
 	}
 
-	b.attributes = s.ResolverState.Attributes
-
 	for a, sc := range b.subConns {
 		// a was removed by resolver.
 		if _, ok := addrsSet[a]; !ok {
 			b.cc.RemoveSubConn(sc)
 			delete(b.subConns, a)
+			delete(b.addrs, a)
 			// Keep the state of this sc in b.scStates until sc's state becomes Shutdown.
 			// The entry will be deleted in HandleSubConnStateChange.
 		}
 	}
+
+	// the picker is rebuilt once attributes change, e.g. weights of nodes,
+	// otherwise it's rebuilt on state changes of SubConns
+	if changed || !reflect.DeepEqual(b.attributes, s.ResolverState.Attributes) {
+		b.attributes = s.ResolverState.Attributes
+		b.updateDetector()
+		if b.state == connectivity.Ready {
+			b.regeneratePicker(nil)
+			b.cc.UpdateState(balancer.State{ConnectivityState: b.state, Picker: b.v2Picker})
+		}
+	}
 	return nil
 }
 
+// addressKey returns a without attributes
+func addressKey(a resolver.Address) resolver.Address {
+	a.Attributes = nil
+	return a
+}
+
 // regeneratePicker takes a snapshot of the balancer, and generates a picker
 // from it. The picker is
 //  - errPicker with ErrTransientFailure if the balancer is in TransientFailure,
@@ -158,15 +188,38 @@ func (b *baseBalancer) regeneratePicker(err error) {
 	// Filter out all ready SCs from full subConn map.
 	for addr, sc := range b.subConns {
 		if st, ok := b.scStates[sc]; ok && st == connectivity.Ready {
-			readySCs[sc] = base.SubConnInfo{Address: addr}
+			readySCs[sc] = base.SubConnInfo{Address: b.addrs[addr]}
 		}
 	}
-	b.v2Picker = b.v2PickerBuilder.Build(
-		PickerBuildInfo{
-			ReadySCs:   readySCs,
-			Attributes: b.attributes,
-		},
-	)
+	info := PickerBuildInfo{
+		ReadySCs:   readySCs,
+		Attributes: b.attributes,
+	}
+	if b.detector != nil {
+		b.v2Picker = newOutlierPicker(b.v2PickerBuilder, info, b.detector)
+		return
+	}
+	b.v2Picker = b.v2PickerBuilder.Build(info)
+}
+
+// updateDetector applies the outlier config of the consumer config, state
+// of tracked endpoints is kept across updates
+func (b *baseBalancer) updateDetector() {
+	config, ok := outlierConfig(b.attributes)
+	if !ok {
+		b.detector = nil
+		return
+	}
+	if b.detector == nil {
+		b.detector = config.Build()
+	} else {
+		b.detector.SetConfig(config)
+	}
+	var addrs = make(map[string]struct{}, len(b.addrs))
+	for addr := range b.addrs {
+		addrs[addr.Addr] = struct{}{}
+	}
+	b.detector.Forget(addrs)
 }
 
 // HandleSubConnStateChange ...
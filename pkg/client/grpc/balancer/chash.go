@@ -0,0 +1,174 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"context"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// NameConsistentHash hashes the key of requests onto a ring of nodes, so
+	// requests of the same key go to the same node while it's ready, the key
+	// is set by WithHashKey or the HashKeyMetadata outgoing metadata
+	NameConsistentHash = "chash"
+
+	// HashKeyMetadata is the outgoing metadata carrying the hash key
+	HashKeyMetadata = "x-jupiter-hash-key"
+
+	// hashReplicas is the number of virtual nodes of a node of defaultWeight
+	hashReplicas = 160
+)
+
+func init() {
+	balancer.Register(
+		NewBalancerBuilderV2(NameConsistentHash, &chashPickerBuilder{}, base.Config{HealthCheck: true}),
+	)
+}
+
+type hashKey struct{}
+
+// WithHashKey returns a context routing the calls of it by key with the
+// consistent hash balancer, it takes precedence over HashKeyMetadata
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+// HashKeyFromContext returns the hash key of calls of ctx
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	if key, ok := ctx.Value(hashKey{}).(string); ok {
+		return key, true
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if values := md.Get(HashKeyMetadata); len(values) > 0 {
+			return values[0], true
+		}
+	}
+	return "", false
+}
+
+type chashPickerBuilder struct{}
+
+// Build ...
+func (chashPickerBuilder) Build(info PickerBuildInfo) balancer.V2Picker {
+	if len(info.ReadySCs) == 0 {
+		return NewErrPickerV2(balancer.ErrNoSubConnAvailable)
+	}
+	var weights = make(map[balancer.SubConn]int, len(info.ReadySCs))
+	var addrs = make(map[balancer.SubConn]string, len(info.ReadySCs))
+	for subConn, scInfo := range info.ReadySCs {
+		weights[subConn] = defaultWeight
+		if scInfo.Address.Attributes != nil {
+			if serviceInfo, ok := scInfo.Address.Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo); ok {
+				weights[subConn] = nodeWeight(serviceInfo)
+			}
+		}
+		addrs[subConn] = scInfo.Address.Addr
+	}
+	return newHashRing(weights, addrs)
+}
+
+// hashRing is the ring of virtual nodes sorted by hash, virtual nodes are
+// hashed from the address of nodes, so that the ring is stable across builds
+type hashRing struct {
+	hashes   []uint32
+	subConns []balancer.SubConn
+	all      []balancer.SubConn
+	next     uint32
+}
+
+func newHashRing(weights map[balancer.SubConn]int, addrs map[balancer.SubConn]string) *hashRing {
+	var drained = true
+	for _, weight := range weights {
+		if weight > 0 {
+			drained = false
+			break
+		}
+	}
+
+	type vnode struct {
+		hash    uint32
+		addr    string
+		subConn balancer.SubConn
+	}
+	var vnodes []vnode
+	var ring = &hashRing{}
+	for subConn, weight := range weights {
+		if drained {
+			weight = defaultWeight
+		}
+		if weight <= 0 {
+			continue
+		}
+		ring.all = append(ring.all, subConn)
+		replicas := hashReplicas * weight / defaultWeight
+		if replicas < 1 {
+			replicas = 1
+		}
+		for i := 0; i < replicas; i++ {
+			vnodes = append(vnodes, vnode{
+				hash:    crc32.ChecksumIEEE([]byte(addrs[subConn] + "#" + strconv.Itoa(i))),
+				addr:    addrs[subConn],
+				subConn: subConn,
+			})
+		}
+	}
+	// ties are broken by address, so the owner of a hash doesn't depend on
+	// the order of the map
+	sort.Slice(vnodes, func(i, j int) bool {
+		if vnodes[i].hash != vnodes[j].hash {
+			return vnodes[i].hash < vnodes[j].hash
+		}
+		return vnodes[i].addr < vnodes[j].addr
+	})
+	sort.Slice(ring.all, func(i, j int) bool { return addrs[ring.all[i]] < addrs[ring.all[j]] })
+
+	ring.hashes = make([]uint32, len(vnodes))
+	ring.subConns = make([]balancer.SubConn, len(vnodes))
+	for i, vnode := range vnodes {
+		ring.hashes[i] = vnode.hash
+		ring.subConns[i] = vnode.subConn
+	}
+	return ring
+}
+
+// Pick picks the first virtual node clockwise of the hash of the key,
+// requests without key are picked round-robin
+func (r *hashRing) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	key, ok := HashKeyFromContext(info.Ctx)
+	if !ok {
+		next := atomic.AddUint32(&r.next, 1)
+		return balancer.PickResult{SubConn: r.all[int(next)%len(r.all)]}, nil
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if idx == len(r.hashes) {
+		idx = 0
+	}
+	return balancer.PickResult{SubConn: r.subConns[idx]}, nil
+}
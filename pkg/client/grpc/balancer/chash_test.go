@@ -0,0 +1,92 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+)
+
+func buildCHashPicker(weights map[string]float64) balancer.V2Picker {
+	var readySCs = make(map[balancer.SubConn]base.SubConnInfo)
+	for addr, weight := range weights {
+		readySCs[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: resolver.Address{
+			Addr:       addr,
+			Attributes: attributes.New(constant.KeyServiceInfo, server.ServiceInfo{Address: addr, Weight: weight}),
+		}}
+	}
+	return chashPickerBuilder{}.Build(PickerBuildInfo{ReadySCs: readySCs})
+}
+
+func pickKey(t *testing.T, picker balancer.V2Picker, ctx context.Context) string {
+	result, err := picker.Pick(balancer.PickInfo{FullMethodName: "/demo.Hello/Say", Ctx: ctx})
+	assert.Nil(t, err)
+	return result.SubConn.(*fakeSubConn).addr
+}
+
+func TestCHashPicker(t *testing.T) {
+	picker := buildCHashPicker(map[string]float64{"a:1": 100, "b:1": 100, "c:1": 100})
+
+	var owners = make(map[string]string)
+	var counts = make(map[string]int)
+	for i := 0; i < 300; i++ {
+		key := "user-" + strconv.Itoa(i)
+		owner := pickKey(t, picker, WithHashKey(context.Background(), key))
+		assert.Equal(t, owner, pickKey(t, picker, WithHashKey(context.Background(), key)))
+		owners[key] = owner
+		counts[owner]++
+	}
+	assert.Len(t, counts, 3)
+
+	// keys set by metadata are routed the same
+	md := metadata.AppendToOutgoingContext(context.Background(), HashKeyMetadata, "user-1")
+	assert.Equal(t, owners["user-1"], pickKey(t, picker, md))
+
+	// removing a node only moves the keys it owned
+	picker = buildCHashPicker(map[string]float64{"a:1": 100, "b:1": 100})
+	for key, owner := range owners {
+		if owner != "c:1" {
+			assert.Equal(t, owner, pickKey(t, picker, WithHashKey(context.Background(), key)))
+		}
+	}
+
+	// drained nodes don't own keys
+	picker = buildCHashPicker(map[string]float64{"a:1": 100, "b:1": 0})
+	for key := range owners {
+		assert.Equal(t, "a:1", pickKey(t, picker, WithHashKey(context.Background(), key)))
+	}
+}
+
+func TestCHashPicker_NoKey(t *testing.T) {
+	picker := buildCHashPicker(map[string]float64{"a:1": 100, "b:1": 100})
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		counts[pickKey(t, picker, context.Background())]++
+	}
+	assert.Equal(t, map[string]int{"a:1": 5, "b:1": 5}, counts)
+
+	_, err := chashPickerBuilder{}.Build(PickerBuildInfo{}).Pick(balancer.PickInfo{})
+	assert.Equal(t, balancer.ErrNoSubConnAvailable, err)
+}
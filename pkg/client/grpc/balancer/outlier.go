@@ -0,0 +1,150 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/util/xoutlier"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// outlierConfig returns the outlier config of the consumer config of this
+// app in attributes of the resolver state
+func outlierConfig(attrs *attributes.Attributes) (xoutlier.Config, bool) {
+	if attrs == nil {
+		return xoutlier.Config{}, false
+	}
+	configs, ok := attrs.Value(constant.KeyConsumerConfig).(map[string]registry.ConsumerConfig)
+	if !ok {
+		return xoutlier.Config{}, false
+	}
+	config, ok := registry.LocalConsumerConfig(configs)
+	if !ok || config.Outlier == nil || !config.Outlier.Enable {
+		return xoutlier.Config{}, false
+	}
+	return *config.Outlier, true
+}
+
+// outlierFailed reports whether err counts against the endpoint, errors of
+// callers, e.g. canceled calls or invalid arguments, don't
+func outlierFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// outlierPicker picks with the picker built of ready SubConns not ejected,
+// which is rebuilt once endpoints are ejected or return
+type outlierPicker struct {
+	builder  PickerBuilder
+	info     PickerBuildInfo
+	detector *xoutlier.Detector
+
+	mu       sync.Mutex
+	snapshot xoutlier.Snapshot
+	picker   balancer.V2Picker
+}
+
+func newOutlierPicker(builder PickerBuilder, info PickerBuildInfo, detector *xoutlier.Detector) *outlierPicker {
+	p := &outlierPicker{builder: builder, info: info, detector: detector}
+	p.rebuild()
+	return p
+}
+
+// Pick ...
+func (p *outlierPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	if p.detector.Changed(p.snapshot) {
+		p.rebuild()
+	}
+	picker := p.picker
+	p.mu.Unlock()
+
+	result, err := picker.Pick(info)
+	if err != nil {
+		return result, err
+	}
+	if scInfo, ok := p.info.ReadySCs[result.SubConn]; ok {
+		addr, done := scInfo.Address.Addr, result.Done
+		result.Done = func(di balancer.DoneInfo) {
+			p.detector.Record(addr, outlierFailed(di.Err))
+			if done != nil {
+				done(di)
+			}
+		}
+	}
+	return result, nil
+}
+
+// rebuild builds the picker of SubConns not ejected, ejected ones are kept
+// once more than MaxEjectedPercent of them are ejected, the earliest
+// returning ones first
+func (p *outlierPicker) rebuild() {
+	p.snapshot = p.detector.Snapshot()
+	var ejected []balancer.SubConn
+	for subConn, scInfo := range p.info.ReadySCs {
+		if _, ok := p.snapshot.Ejected[scInfo.Address.Addr]; ok {
+			ejected = append(ejected, subConn)
+		}
+	}
+	maxEjected := len(p.info.ReadySCs) * p.detector.Config().MaxEjectedPercent / 100
+	if maxEjected >= len(p.info.ReadySCs) {
+		maxEjected = len(p.info.ReadySCs) - 1
+	}
+	if maxEjected < 0 {
+		maxEjected = 0
+	}
+	if len(ejected) > maxEjected {
+		addr := func(i int) string { return p.info.ReadySCs[ejected[i]].Address.Addr }
+		sort.Slice(ejected, func(i, j int) bool {
+			ui, uj := p.snapshot.Ejected[addr(i)], p.snapshot.Ejected[addr(j)]
+			if !ui.Equal(uj) {
+				return ui.After(uj)
+			}
+			return addr(i) < addr(j)
+		})
+		ejected = ejected[:maxEjected]
+	}
+	if len(ejected) == 0 {
+		p.picker = p.builder.Build(p.info)
+		return
+	}
+
+	var readySCs = make(map[balancer.SubConn]base.SubConnInfo, len(p.info.ReadySCs))
+	for subConn, scInfo := range p.info.ReadySCs {
+		readySCs[subConn] = scInfo
+	}
+	for _, subConn := range ejected {
+		delete(readySCs, subConn)
+	}
+	p.picker = p.builder.Build(PickerBuildInfo{ReadySCs: readySCs, Attributes: p.info.Attributes})
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg"
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xoutlier"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+func TestOutlierPicker(t *testing.T) {
+	var readySCs = make(map[balancer.SubConn]base.SubConnInfo)
+	for _, addr := range []string{"a:1", "b:1", "c:1"} {
+		readySCs[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: resolver.Address{
+			Addr:       addr,
+			Attributes: attributes.New(constant.KeyServiceInfo, server.ServiceInfo{Address: addr, Weight: 100}),
+		}}
+	}
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	config := xoutlier.DefaultConfig().WithClock(clock)
+	config.ConsecutiveErrors = 2
+	config.MaxEjectedPercent = 40
+	detector := config.Build()
+	picker := newOutlierPicker(swrPickerBuilder{}, PickerBuildInfo{ReadySCs: readySCs}, detector)
+
+	// failures of a:1 and b:1, only one of three is ejected at most
+	var unavailable = status.Error(codes.Unavailable, "unavailable")
+	for i := 0; i < 30; i++ {
+		result, err := picker.Pick(balancer.PickInfo{FullMethodName: "/demo.Hello/Say"})
+		assert.Nil(t, err)
+		switch result.SubConn.(*fakeSubConn).addr {
+		case "a:1":
+			result.Done(balancer.DoneInfo{Err: unavailable})
+		case "b:1":
+			clock.Advance(time.Millisecond)
+			result.Done(balancer.DoneInfo{Err: unavailable})
+		default:
+			result.Done(balancer.DoneInfo{Err: status.Error(codes.InvalidArgument, "bad request")})
+		}
+	}
+	assert.Len(t, detector.Snapshot().Ejected, 2)
+	counts := pickCounts(t, picker, 20)
+	assert.Equal(t, 10, counts["c:1"])
+	assert.Len(t, counts, 2)
+
+	// ejected endpoints return once ejections end
+	clock.Advance(time.Hour)
+	assert.Equal(t, map[string]int{"a:1": 10, "b:1": 10, "c:1": 10}, pickCounts(t, picker, 30))
+}
+
+func TestOutlierConfig(t *testing.T) {
+	_, ok := outlierConfig(nil)
+	assert.False(t, ok)
+
+	outlier := xoutlier.DefaultConfig()
+	outlier.Enable = true
+	outlier.ConsecutiveErrors = 7
+	attrs := attributes.New(constant.KeyConsumerConfig, map[string]registry.ConsumerConfig{
+		"grpc:///consumers/other":         {ID: "other", Outlier: &xoutlier.Config{Enable: true}},
+		"grpc:///consumers/" + pkg.Name(): {ID: pkg.Name(), Outlier: &outlier},
+	})
+	config, ok := outlierConfig(attrs)
+	assert.True(t, ok)
+	assert.Equal(t, 7, config.ConsecutiveErrors)
+
+	assert.True(t, outlierFailed(context.DeadlineExceeded))
+	assert.True(t, outlierFailed(status.Error(codes.DeadlineExceeded, "")))
+	assert.False(t, outlierFailed(status.Error(codes.Canceled, "")))
+	assert.False(t, outlierFailed(nil))
+}
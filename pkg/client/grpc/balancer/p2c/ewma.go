@@ -0,0 +1,49 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p2c
+
+import (
+	"github.com/douyu/jupiter/pkg/util/xp2c/ewma"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+const (
+	// NameEWMA picks the endpoint of lower load of two random ones, the load
+	// is the EWMA latency times inflight requests, so slow endpoints get less
+	// traffic than with round-robin. Import this package to register it.
+	NameEWMA = "p2c"
+)
+
+func newEWMABuilder() balancer.Builder {
+	return ewmaBuilder{}
+}
+
+// ewmaBuilder builds balancers of their own trackers, so that EWMA state of
+// SubConns is kept across pickers of the ClientConn
+type ewmaBuilder struct{}
+
+func (ewmaBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	tracker := ewma.NewTracker()
+	return base.NewBalancerBuilderWithConfig(NameEWMA, &p2cPickerBuilder{newP2c: tracker.New}, base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+func (ewmaBuilder) Name() string {
+	return NameEWMA
+}
+
+func init() {
+	balancer.Register(newEWMABuilder())
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package p2c_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/client/grpc/balancer/p2c"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	testpb "google.golang.org/grpc/test/grpc_testing"
+)
+
+func TestEWMABackends(t *testing.T) {
+	r, cleanup := manual.GenerateAndRegisterManualResolver()
+	defer cleanup()
+
+	test, err := startTestServers(2)
+	assert.Nil(t, err)
+	defer test.cleanup()
+
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.NameEWMA))
+	assert.Nil(t, err)
+	defer cc.Close()
+	testc := testpb.NewTestServiceClient(cc)
+
+	r.UpdateState(resolver.State{Addresses: []resolver.Address{{Addr: test.addresses[0]}, {Addr: test.addresses[1]}}})
+	for i := 0; i < 100; i++ {
+		_, err := testc.EmptyCall(context.Background(), &testpb.Empty{}, grpc.WaitForReady(true))
+		assert.Nil(t, err)
+	}
+
+	r.UpdateState(resolver.State{Addresses: []resolver.Address{{Addr: test.addresses[1]}}})
+	var p peer.Peer
+	assert.Eventually(t, func() bool {
+		_, err := testc.EmptyCall(context.Background(), &testpb.Empty{}, grpc.Peer(&p))
+		return err == nil && p.Addr.String() == test.addresses[1]
+	}, time.Second, time.Millisecond)
+	for i := 0; i < 10; i++ {
+		_, err := testc.EmptyCall(context.Background(), &testpb.Empty{}, grpc.Peer(&p))
+		assert.Nil(t, err)
+		assert.Equal(t, test.addresses[1], p.Addr.String())
+	}
+}
@@ -33,22 +33,24 @@ const (
 
 // newBuilder creates a new balance builder.
 func newBuilder() balancer.Builder {
-	return base.NewBalancerBuilderWithConfig(Name, &p2cPickerBuilder{}, base.Config{HealthCheck: true})
+	return base.NewBalancerBuilderWithConfig(Name, &p2cPickerBuilder{newP2c: leastloaded.New}, base.Config{HealthCheck: true})
 }
 
 func init() {
 	balancer.Register(newBuilder())
 }
 
-type p2cPickerBuilder struct{}
+type p2cPickerBuilder struct {
+	newP2c func() xp2c.P2c
+}
 
-func (*p2cPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
+func (pb *p2cPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
 	grpclog.Infof("p2cPickerBuilder: newPicker called with readySCs: %v", readySCs)
 	if len(readySCs) == 0 {
 		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
 	}
 
-	var p2c = leastloaded.New()
+	var p2c = pb.newP2c()
 
 	for _, sc := range readySCs {
 		p2c.Add(sc)
@@ -16,7 +16,7 @@ package balancer
 
 import (
 	"errors"
-	"fmt"
+	"math"
 	"sync"
 
 	"github.com/douyu/jupiter/pkg/constant"
@@ -52,6 +52,9 @@ func init() {
 	)
 }
 
+// defaultWeight is the weight of nodes without service info
+const defaultWeight = 100
+
 type swrPickerBuilder struct{}
 
 // Build ...
@@ -87,6 +90,9 @@ func (p *swrPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	if bs, ok := p.routeBuckets[info.FullMethodName]; ok {
 		// 根据URI进行流量分组路由
 		buckets = bs
+	} else if bs, ok := p.routeBuckets[""]; ok {
+		// routes without uri apply to all methods
+		buckets = bs
 	}
 
 	sub, ok := buckets.Next().(balancer.SubConn)
@@ -101,69 +107,133 @@ func (p *swrPicker) parseBuildInfo(info PickerBuildInfo) {
 	var hostedSubConns = map[string]balancer.SubConn{}
 	var groupedSubConns = map[string][]balancer.SubConn{}
 
+	var weights = make(map[balancer.SubConn]int, len(info.ReadySCs))
 	for subConn, info := range info.ReadySCs {
-		p.buckets.Add(subConn, 1)
+		weights[subConn] = defaultWeight
+		group := constant.DefaultBalanceGroup
 		if info.Address.Attributes != nil {
 			if serviceInfo, ok := info.Address.Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo); ok {
-				// todo(gorexlv): 分组
-				group := serviceInfo.Group
-				if _, ok := groupedSubConns[group]; !ok {
-					groupedSubConns[group] = make([]balancer.SubConn, 0)
+				weights[subConn] = nodeWeight(serviceInfo)
+				if serviceInfo.Group != "" {
+					group = serviceInfo.Group
 				}
-				groupedSubConns[group] = append(groupedSubConns[group], subConn)
 			}
 		}
-		host := info.Address.Addr
-		hostedSubConns[host] = subConn
-		p.buckets.Add(subConn, 1)
+		groupedSubConns[group] = append(groupedSubConns[group], subConn)
+		hostedSubConns[info.Address.Addr] = subConn
 	}
+	p.addWeighted(weights)
 
 	if info.Attributes == nil {
 		return
 	}
 
-	providerConfig, ok := info.Attributes.Value(constant.KeyProviderConfig).(registry.ProviderConfig)
+	// 路由配置
+	routeConfigs, ok := info.Attributes.Value(constant.KeyRouteConfig).(map[string]registry.RouteConfig)
 	if !ok {
 		return
 	}
-
-	fmt.Printf("providerConfig => %v\n", providerConfig)
-
-	consumerConfigs, ok := info.Attributes.Value(constant.KeyConsumerConfig).(map[string]registry.ConsumerConfig)
-	if !ok {
-		return
+	for uri, upstream := range selectRoutes(routeConfigs, registry.LocalHost()) {
+		if buckets := upstreamBuckets(upstream, hostedSubConns, groupedSubConns); buckets != nil {
+			p.routeBuckets[uri] = buckets
+		}
 	}
+}
 
-	fmt.Printf("consumerConfigs => %v\n", consumerConfigs)
+// upstreamShares is the weight of a share of an upstream, weights of groups
+// are split between their nodes in shares
+const upstreamShares = 1000
 
-	// 路由配置
-	routeConfigs, ok := info.Attributes.Value(constant.KeyRouteConfig).(map[string]registry.RouteConfig)
-	if !ok {
-		return
+// upstreamBuckets returns the buckets of ready SubConns weighted by upstream,
+// it's nil if upstream weighs no ready SubConn, so that the route falls back
+// to the weights of nodes.
+//
+// The weight of a group is split evenly between its nodes, e.g. groups
+// {"red": 2, "green": 1} send 2/3 of requests to red nodes whatever the
+// number of them. Nodes weighted by upstream.nodes take their own weight,
+// which overrides the group they're in, and nodes not weighted are drained.
+func upstreamBuckets(upstream registry.Upstream, hosted map[string]balancer.SubConn, grouped map[string][]balancer.SubConn) *weighted.SW {
+	var weights = make(map[balancer.SubConn]int)
+	for node, weight := range upstream.Nodes {
+		if subConn, ok := hosted[node]; ok && weight > 0 {
+			weights[subConn] = weight * upstreamShares
+		}
 	}
-	for _, config := range routeConfigs {
-		if _, ok := p.routeBuckets[config.URI]; !ok {
-			p.routeBuckets[config.URI] = &weighted.SW{}
+	for group, weight := range upstream.Groups {
+		if weight <= 0 {
+			continue
 		}
-
-		// 基于Group的权重配置, 同一分组下的IP分配同一个权重值
-		for group, weight := range config.Upstream.Groups {
-			sConns, ok := groupedSubConns[group]
-			if !ok {
-				continue
+		var members []balancer.SubConn
+		for _, subConn := range grouped[group] {
+			if _, ok := weights[subConn]; !ok {
+				members = append(members, subConn)
 			}
-
-			for _, sConn := range sConns {
-				p.routeBuckets[config.URI].Add(sConn, weight)
+		}
+		for _, subConn := range members {
+			share := weight * upstreamShares / len(members)
+			if share < 1 {
+				share = 1
 			}
+			weights[subConn] = share
 		}
+	}
+	if len(weights) == 0 {
+		return nil
+	}
+	var buckets = &weighted.SW{}
+	for subConn, weight := range weights {
+		buckets.Add(subConn, weight)
+	}
+	return buckets
+}
 
-		// 基于Node IP的权重配置, 如果配置了对应Node，将会覆盖Group中配置的权重
-		for node, weight := range config.Upstream.Nodes {
-			if sConn, ok := hostedSubConns[node]; ok {
-				p.routeBuckets[config.URI].Add(sConn, weight)
-			}
+// selectRoutes returns upstreams of routes by uri, routes of the host, e.g.
+// "grpc://10.0.0.1/routes/1", override routes of all hosts, e.g.
+// "grpc:///routes/1", and routes of other hosts are skipped
+func selectRoutes(configs map[string]registry.RouteConfig, host string) map[string]registry.Upstream {
+	var upstreams = make(map[string]registry.Upstream)
+	var hosted = make(map[string]bool)
+	for _, config := range configs {
+		if config.Host != "" && config.Host != host {
+			continue
+		}
+		if hosted[config.URI] && config.Host == "" {
+			continue
 		}
+		upstreams[config.URI] = config.Upstream
+		hosted[config.URI] = config.Host != ""
+	}
+	return upstreams
+}
 
+// addWeighted adds SubConns with their weights to buckets, SubConns weighted
+// 0 are drained unless all of them are, then they're picked evenly
+func (p *swrPicker) addWeighted(weights map[balancer.SubConn]int) {
+	var drained = true
+	for _, weight := range weights {
+		if weight > 0 {
+			drained = false
+			break
+		}
+	}
+	for subConn, weight := range weights {
+		if drained {
+			weight = 1
+		}
+		if weight > 0 {
+			p.buckets.Add(subConn, weight)
+		}
+	}
+}
+
+// nodeWeight rounds the registered weight of the node, positive weights are
+// at least 1 so that they're not drained
+func nodeWeight(info server.ServiceInfo) int {
+	if info.Weight <= 0 {
+		return 0
+	}
+	if weight := int(math.Round(info.Weight)); weight > 0 {
+		return weight
 	}
+	return 1
 }
@@ -0,0 +1,131 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package balancer
+
+import (
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type fakeSubConn struct {
+	addr string
+}
+
+func (sc *fakeSubConn) UpdateAddresses([]resolver.Address) {}
+
+func (sc *fakeSubConn) Connect() {}
+
+func buildSWRPicker(weights map[string]float64) balancer.V2Picker {
+	var readySCs = make(map[balancer.SubConn]base.SubConnInfo)
+	for addr, weight := range weights {
+		readySCs[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: resolver.Address{
+			Addr:       addr,
+			Attributes: attributes.New(constant.KeyServiceInfo, server.ServiceInfo{Address: addr, Weight: weight}),
+		}}
+	}
+	return swrPickerBuilder{}.Build(PickerBuildInfo{ReadySCs: readySCs})
+}
+
+func pickCounts(t *testing.T, picker balancer.V2Picker, n int) map[string]int {
+	var counts = make(map[string]int)
+	for i := 0; i < n; i++ {
+		result, err := picker.Pick(balancer.PickInfo{FullMethodName: "/demo.Hello/Say"})
+		assert.Nil(t, err)
+		counts[result.SubConn.(*fakeSubConn).addr]++
+	}
+	return counts
+}
+
+func TestSWRPicker_Weight(t *testing.T) {
+	picker := buildSWRPicker(map[string]float64{"a:1": 300, "b:1": 100, "c:1": 0})
+	assert.Equal(t, map[string]int{"a:1": 30, "b:1": 10}, pickCounts(t, picker, 40))
+
+	// all drained nodes are picked evenly
+	picker = buildSWRPicker(map[string]float64{"a:1": 0, "b:1": 0})
+	assert.Equal(t, map[string]int{"a:1": 5, "b:1": 5}, pickCounts(t, picker, 10))
+}
+
+func TestNodeWeight(t *testing.T) {
+	assert.Equal(t, 0, nodeWeight(server.ServiceInfo{Weight: -1}))
+	assert.Equal(t, 1, nodeWeight(server.ServiceInfo{Weight: 0.2}))
+	assert.Equal(t, 100, nodeWeight(server.ServiceInfo{Weight: 100}))
+}
+
+func buildRoutedPicker(groups map[string]string, routes map[string]registry.RouteConfig) balancer.V2Picker {
+	var readySCs = make(map[balancer.SubConn]base.SubConnInfo)
+	for addr, group := range groups {
+		readySCs[&fakeSubConn{addr: addr}] = base.SubConnInfo{Address: resolver.Address{
+			Addr:       addr,
+			Attributes: attributes.New(constant.KeyServiceInfo, server.ServiceInfo{Address: addr, Weight: 100, Group: group}),
+		}}
+	}
+	return swrPickerBuilder{}.Build(PickerBuildInfo{
+		ReadySCs:   readySCs,
+		Attributes: attributes.New(constant.KeyRouteConfig, routes),
+	})
+}
+
+func TestSWRPicker_Route(t *testing.T) {
+	groups := map[string]string{"a:1": "red", "b:1": "red", "c:1": "green", "d:1": ""}
+	picker := buildRoutedPicker(groups, map[string]registry.RouteConfig{
+		"grpc:///routes/1": {URI: "/demo.Hello/Say", Upstream: registry.Upstream{
+			Groups: map[string]int{"red": 2, "green": 1},
+		}},
+		"grpc:///routes/2": {URI: "/demo.Hello/Other", Upstream: registry.Upstream{
+			Nodes:  map[string]int{"a:1": 1, "d:1": 1},
+			Groups: map[string]int{"red": 2},
+		}},
+		"grpc://10.255.255.1/routes/1": {URI: "/demo.Hello/Say", Host: "10.255.255.1", Upstream: registry.Upstream{
+			Nodes: map[string]int{"d:1": 1},
+		}},
+	})
+
+	// red:green is 2:1 whatever the number of nodes in them
+	assert.Equal(t, map[string]int{"a:1": 30, "b:1": 30, "c:1": 30}, pickCounts(t, picker, 90))
+
+	// nodes override their groups, b:1 takes the whole red weight
+	counts := map[string]int{}
+	for i := 0; i < 40; i++ {
+		result, err := picker.Pick(balancer.PickInfo{FullMethodName: "/demo.Hello/Other"})
+		assert.Nil(t, err)
+		counts[result.SubConn.(*fakeSubConn).addr]++
+	}
+	assert.Equal(t, map[string]int{"a:1": 10, "b:1": 20, "d:1": 10}, counts)
+
+	// routes weighing no ready node fall back to weights of nodes
+	picker = buildRoutedPicker(map[string]string{"a:1": "", "b:1": ""}, map[string]registry.RouteConfig{
+		"grpc:///routes/1": {URI: "/demo.Hello/Say", Upstream: registry.Upstream{Groups: map[string]int{"red": 1}}},
+	})
+	assert.Equal(t, map[string]int{"a:1": 5, "b:1": 5}, pickCounts(t, picker, 10))
+}
+
+func TestSelectRoutes(t *testing.T) {
+	upstreams := selectRoutes(map[string]registry.RouteConfig{
+		"grpc:///routes/1":         {URI: "/a", Upstream: registry.Upstream{Groups: map[string]int{"all": 1}}},
+		"grpc://10.0.0.1/routes/1": {URI: "/a", Host: "10.0.0.1", Upstream: registry.Upstream{Groups: map[string]int{"host": 1}}},
+		"grpc://10.0.0.2/routes/1": {URI: "/b", Host: "10.0.0.2", Upstream: registry.Upstream{Groups: map[string]int{"other": 1}}},
+	}, "10.0.0.1")
+	assert.Equal(t, map[string]registry.Upstream{
+		"/a": {Groups: map[string]int{"host": 1}},
+	}, upstreams)
+}
@@ -16,17 +16,26 @@ package grpc
 
 import (
 	"context"
+	"net"
 	"time"
 
 	"github.com/douyu/jupiter/pkg/ecode"
 
+	"github.com/douyu/jupiter/pkg/xegress"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xtls"
 	"google.golang.org/grpc"
 )
 
+// egressDialer dials addresses allowed by the egress policy
+func egressDialer(ctx context.Context, addr string) (net.Conn, error) {
+	return xegress.Dial(ctx, "tcp", addr)
+}
+
 func newGRPCClient(config *Config) *grpc.ClientConn {
 	var ctx = context.Background()
-	var dialOptions = config.dialOptions
+	// dialers of dial options override the egress one
+	var dialOptions = append([]grpc.DialOption{grpc.WithContextDialer(egressDialer)}, config.dialOptions...)
 	logger := config.logger.With(
 		xlog.FieldMod("client.grpc"),
 		xlog.FieldAddr(config.Address),
@@ -42,6 +51,12 @@ func newGRPCClient(config *Config) *grpc.ClientConn {
 		dialOptions = append(dialOptions, grpc.WithBlock())
 	}
 
+	if config.TLSBundle != "" {
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(xtls.StdConfig(config.TLSBundle).Build().Credentials()))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	}
+
 	if config.KeepAlive != nil {
 		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(*config.KeepAlive))
 	}
@@ -20,12 +20,20 @@ import (
 
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xretry"
+	"github.com/douyu/jupiter/pkg/xbulkhead"
+	"github.com/douyu/jupiter/pkg/xchannelz"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/balancer/roundrobin"
 	"google.golang.org/grpc/keepalive"
 )
 
+func init() {
+	xschema.RegisterConfig("client.grpc", "jupiter.client.*", "gRPC client", DefaultConfig)
+}
+
 // Config ...
 type Config struct {
 	Name         string // config's name
@@ -49,14 +57,30 @@ type Config struct {
 	DisableMetricInterceptor  bool
 	DisableAccessInterceptor  bool
 	AccessInterceptorLevel    string
+	// EnableChannelz 开启channelz, 在governor上查看连接状态, 需要导入pkg/xchannelz/service
+	EnableChannelz bool
+	// Fallbacks are responses of full methods, e.g. "/helloworld.Greeter/SayHello",
+	// returned once calls are rejected by Bulkhead or rate limited by servers.
+	// The client has no circuit breaker itself, calls blocked by the breaker
+	// fall back only if a sentinel interceptor is chained in by WithDialOption
+	Fallbacks map[string]Fallback
+	// LastGoodSize is the max number of last good responses kept for
+	// Fallback.LastGood
+	LastGoodSize int
+	// Bulkhead caps concurrent calls with the bulkhead of the name, configured
+	// by "jupiter.bulkhead.<name>" and shared by clients of the same dependency
+	Bulkhead string
+	// TLSBundle verifies servers with the CA bundle of the name, which is
+	// reloaded once it changes, see xtls, connections are insecure if empty
+	TLSBundle string
+	// Retry retries or hedges calls failing with retryable codes, overridden
+	// by the retry policy of the consumer config in the registry
+	Retry *xretry.Policy
 }
 
 // DefaultConfig ...
 func DefaultConfig() *Config {
 	return &Config{
-		dialOptions: []grpc.DialOption{
-			grpc.WithInsecure(),
-		},
 		logger:                 xlog.JupiterLogger.With(xlog.FieldMod(ecode.ModClientGrpc)),
 		BalancerName:           roundrobin.Name, // round robin by default
 		DialTimeout:            time.Second * 3,
@@ -65,6 +89,7 @@ func DefaultConfig() *Config {
 		OnDialError:            "panic",
 		AccessInterceptorLevel: "info",
 		Block:                  true,
+		LastGoodSize:           1024,
 	}
 }
 
@@ -117,6 +142,12 @@ func (config *Config) Build() *grpc.ClientConn {
 		)
 	}
 
+	// after the timeout one, so that attempts share the deadline of the call
+	// and each of them is traced and logged
+	config.dialOptions = append(config.dialOptions,
+		grpc.WithChainUnaryInterceptor(retryUnaryClientInterceptor(config.Name, config.retryPolicy(), xtime.SystemClock)),
+	)
+
 	if !config.DisableTraceInterceptor {
 		config.dialOptions = append(config.dialOptions,
 			grpc.WithChainUnaryInterceptor(traceUnaryClientInterceptor()),
@@ -135,5 +166,22 @@ func (config *Config) Build() *grpc.ClientConn {
 		)
 	}
 
+	if config.Bulkhead != "" {
+		config.dialOptions = append([]grpc.DialOption{
+			grpc.WithChainUnaryInterceptor(bulkheadUnaryClientInterceptor(xbulkhead.StdConfig(config.Bulkhead).Build())),
+		}, config.dialOptions...)
+	}
+
+	if len(config.Fallbacks) > 0 {
+		// the outermost one, so that it sees rejections of all others
+		config.dialOptions = append([]grpc.DialOption{
+			grpc.WithChainUnaryInterceptor(fallbackUnaryClientInterceptor(config.Name, config.Fallbacks, newLastGoodCache(config.LastGoodSize), config.logger)),
+		}, config.dialOptions...)
+	}
+
+	if config.EnableChannelz {
+		xchannelz.Enable()
+	}
+
 	return newGRPCClient(config)
 }
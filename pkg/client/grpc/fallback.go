@@ -0,0 +1,229 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xbulkhead"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons of rejected calls
+const (
+	RejectBreaker   = "breaker"
+	RejectRateLimit = "ratelimit"
+	RejectBulkhead  = "bulkhead"
+)
+
+var fallbackCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "client_fallback_total",
+	Labels:    []string{"name", "method", "reason", "source"},
+}.Build()
+
+// DefaultLastGoodTTL is Fallback.LastGoodTTL if it's not positive
+const DefaultLastGoodTTL = 5 * time.Minute
+
+// Fallback is the response of a method returned instead of errors of
+// rejected calls, i.e. blocked by the circuit breaker or bulkheads, or rate
+// limited
+type Fallback struct {
+	// JSON is a static response in protobuf JSON, e.g. `{"items":[]}`
+	JSON string
+	// LastGood returns the last successful response of the same request,
+	// which is preferred to JSON, within LastGoodTTL, DefaultLastGoodTTL if
+	// it's not positive
+	LastGood    bool
+	LastGoodTTL time.Duration
+}
+
+// RejectReason returns the reason why the call failed with err is rejected,
+// i.e. blocked by sentinel or bulkheads, or rate limited by the server
+func RejectReason(err error) (string, bool) {
+	if errors.Is(err, xbulkhead.ErrRejected) {
+		return RejectBulkhead, true
+	}
+	var blockErr *base.BlockError
+	if errors.As(err, &blockErr) {
+		if blockErr.BlockType() == base.BlockTypeCircuitBreaking {
+			return RejectBreaker, true
+		}
+		return RejectRateLimit, true
+	}
+	if status.Code(err) == codes.ResourceExhausted {
+		return RejectRateLimit, true
+	}
+	return "", false
+}
+
+// fallbackUnaryClientInterceptor returns fallbacks of methods configured
+// once calls are rejected, interceptors rejecting calls, e.g. sentinel,
+// must be chained after it
+func fallbackUnaryClientInterceptor(name string, fallbacks map[string]Fallback, lastGood *lastGoodCache, logger *xlog.Logger) grpc.UnaryClientInterceptor {
+	var defaulted = make(map[string]Fallback, len(fallbacks))
+	for method, fallback := range fallbacks {
+		if fallback.LastGoodTTL <= 0 {
+			fallback.LastGoodTTL = DefaultLastGoodTTL
+		}
+		defaulted[method] = fallback
+	}
+	fallbacks = defaulted
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		fallback, ok := fallbacks[method]
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		var key string
+		if fallback.LastGood {
+			key = lastGoodKey(method, req)
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			if key != "" {
+				lastGood.set(key, reply, fallback.LastGoodTTL)
+			}
+			return nil
+		}
+		reason, ok := RejectReason(err)
+		if !ok {
+			return err
+		}
+
+		if key != "" && lastGood.get(key, reply) {
+			fallbackCounter.Inc(name, method, reason, "lastgood")
+			return nil
+		}
+		if fallback.JSON != "" {
+			message, ok := reply.(proto.Message)
+			if !ok {
+				return err
+			}
+			message.Reset()
+			if e := jsonpb.UnmarshalString(fallback.JSON, message); e != nil {
+				logger.Error("invalid fallback", xlog.FieldMethod(method), xlog.FieldErr(e))
+				return err
+			}
+			fallbackCounter.Inc(name, method, reason, "json")
+			return nil
+		}
+		fallbackCounter.Inc(name, method, reason, "none")
+		return err
+	}
+}
+
+// lastGoodKey is the method and request in deterministic wire format, empty
+// if req can't be marshaled
+func lastGoodKey(method string, req interface{}) string {
+	message, ok := req.(proto.Message)
+	if !ok {
+		return ""
+	}
+	var buf proto.Buffer
+	buf.SetDeterministic(true)
+	if err := buf.Marshal(message); err != nil {
+		return ""
+	}
+	return method + "\x00" + string(buf.Bytes())
+}
+
+type lastGoodItem struct {
+	key     string
+	val     []byte
+	expires time.Time
+}
+
+// lastGoodCache keeps successful responses in wire format, the least
+// recently used ones are evicted once it's full
+type lastGoodCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	lru      *list.List
+	clock    xtime.Clock
+}
+
+func newLastGoodCache(capacity int) *lastGoodCache {
+	return &lastGoodCache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+		clock:    xtime.SystemClock,
+	}
+}
+
+func (c *lastGoodCache) set(key string, reply interface{}, ttl time.Duration) {
+	message, ok := reply.(proto.Message)
+	if !ok {
+		return
+	}
+	val, err := proto.Marshal(message)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item := &lastGoodItem{key: key, val: val, expires: c.clock.Now().Add(ttl)}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = item
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.lru.PushFront(item)
+	for c.capacity > 0 && c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*lastGoodItem).key)
+	}
+}
+
+// get unmarshals the response of key into reply
+func (c *lastGoodCache) get(key string, reply interface{}) bool {
+	message, ok := reply.(proto.Message)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+	item := elem.Value.(*lastGoodItem)
+	if !c.clock.Now().Before(item.expires) {
+		c.lru.Remove(elem)
+		delete(c.items, key)
+		c.mu.Unlock()
+		return false
+	}
+	c.lru.MoveToFront(elem)
+	c.mu.Unlock()
+
+	message.Reset()
+	return proto.Unmarshal(item.val, message) == nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/douyu/jupiter/pkg/util/xtest/proto/testproto"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xbulkhead"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRejectReason(t *testing.T) {
+	reason, ok := RejectReason(base.NewBlockError(base.BlockTypeCircuitBreaking, "breaker open"))
+	assert.True(t, ok)
+	assert.Equal(t, RejectBreaker, reason)
+	reason, ok = RejectReason(status.Error(codes.ResourceExhausted, "too many requests"))
+	assert.True(t, ok)
+	assert.Equal(t, RejectRateLimit, reason)
+	reason, ok = RejectReason(&xbulkhead.Error{Name: "user", Reason: xbulkhead.RejectFull})
+	assert.True(t, ok)
+	assert.Equal(t, RejectBulkhead, reason)
+	_, ok = RejectReason(status.Error(codes.Unavailable, "unavailable"))
+	assert.False(t, ok)
+}
+
+func TestFallbackUnaryClientInterceptor(t *testing.T) {
+	var invokeErr error
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if invokeErr != nil {
+			return invokeErr
+		}
+		reply.(*testproto.HelloReply).Message = "hello " + req.(*testproto.HelloRequest).Name
+		return nil
+	}
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	lastGood := newLastGoodCache(10)
+	lastGood.clock = clock
+	interceptor := fallbackUnaryClientInterceptor("test", map[string]Fallback{
+		"/hello/SayHello": {JSON: `{"message":"busy"}`, LastGood: true, LastGoodTTL: time.Minute},
+		"/hello/Static":   {JSON: `{"message":"busy"}`},
+	}, lastGood, xlog.DefaultLogger)
+	call := func(method, name string) (*testproto.HelloReply, error) {
+		reply := &testproto.HelloReply{}
+		err := interceptor(context.Background(), method, &testproto.HelloRequest{Name: name}, reply, nil, invoker)
+		return reply, err
+	}
+
+	reply, err := call("/hello/SayHello", "jupiter")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello jupiter", reply.Message)
+
+	invokeErr = status.Error(codes.ResourceExhausted, "too many requests")
+	reply, err = call("/hello/SayHello", "jupiter")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello jupiter", reply.Message)
+	// other requests get the static one
+	reply, err = call("/hello/SayHello", "douyu")
+	assert.Nil(t, err)
+	assert.Equal(t, "busy", reply.Message)
+	reply, err = call("/hello/Static", "jupiter")
+	assert.Nil(t, err)
+	assert.Equal(t, "busy", reply.Message)
+
+	clock.Advance(time.Minute)
+	reply, _ = call("/hello/SayHello", "jupiter")
+	assert.Equal(t, "busy", reply.Message)
+
+	// methods without fallbacks and other errors fail
+	_, err = call("/hello/Other", "jupiter")
+	assert.NotNil(t, err)
+	invokeErr = errors.New("connection refused")
+	_, err = call("/hello/Static", "jupiter")
+	assert.Equal(t, invokeErr, err)
+}
+
+func TestFallbackUnaryClientInterceptor_DefaultTTL(t *testing.T) {
+	var invokeErr error
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		if invokeErr != nil {
+			return invokeErr
+		}
+		reply.(*testproto.HelloReply).Message = "hello"
+		return nil
+	}
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	lastGood := newLastGoodCache(10)
+	lastGood.clock = clock
+	interceptor := fallbackUnaryClientInterceptor("test", map[string]Fallback{
+		"/hello/SayHello": {LastGood: true},
+	}, lastGood, xlog.DefaultLogger)
+	call := func() (*testproto.HelloReply, error) {
+		reply := &testproto.HelloReply{}
+		err := interceptor(context.Background(), "/hello/SayHello", &testproto.HelloRequest{}, reply, nil, invoker)
+		return reply, err
+	}
+
+	_, err := call()
+	assert.Nil(t, err)
+	invokeErr = status.Error(codes.ResourceExhausted, "too many requests")
+	reply, err := call()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", reply.Message)
+
+	clock.Advance(DefaultLastGoodTTL)
+	_, err = call()
+	assert.Equal(t, invokeErr, err)
+}
@@ -16,20 +16,33 @@ package resolver
 
 import (
 	"context"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
 	"github.com/douyu/jupiter/pkg/util/xgo"
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/resolver"
 )
 
-// Register ...
-func Register(name string, reg registry.Registry) {
-	resolver.Register(&baseBuilder{
-		name: name,
+// Register registers the resolver builder of scheme backed by reg, e.g.
+// Register("etcd", etcdv3.StdConfig("wh").Build()) resolves "etcd:///demo".
+// Any registry.Registry works, e.g. consul, kubernetes or static ones.
+func Register(scheme string, reg registry.Registry) {
+	resolver.Register(NewBuilder(scheme, reg))
+}
+
+// NewBuilder returns the resolver builder of scheme backed by reg, which
+// translates endpoints watched by reg.WatchServices into resolver states
+func NewBuilder(scheme string, reg registry.Registry) resolver.Builder {
+	return &baseBuilder{
+		name: scheme,
 		reg:  reg,
-	})
+	}
 }
 
 type baseBuilder struct {
@@ -39,54 +52,137 @@ type baseBuilder struct {
 
 // Build ...
 func (b *baseBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	endpoints, err := b.reg.WatchServices(context.Background(), target.Endpoint, "grpc")
+	// e.g. "etcd:///demo?labels=region=bj,env=prod" subscribes to providers
+	// of demo matching the label selector
+	name, query := target.Endpoint, url.Values{}
+	if idx := strings.IndexByte(name, '?'); idx >= 0 {
+		query, _ = url.ParseQuery(name[idx+1:])
+		name = name[:idx]
+	}
+	ts := getState(b.name, name)
+	ctx, cancel := context.WithCancel(context.Background())
+	if selector := query.Get("labels"); selector != "" {
+		ctx = registry.WithLabelSelector(ctx, selector)
+	}
+	endpoints, err := b.reg.WatchServices(ctx, name, "grpc")
 	if err != nil {
+		cancel()
+		ts.fail(err)
+		ts.close()
 		return nil, err
 	}
 
-	var stop = make(chan struct{})
 	xgo.Go(func() {
+		var attrs nodeAttributes
 		for {
 			select {
-			case endpoint := <-endpoints:
-				var state = resolver.State{
-					Addresses: make([]resolver.Address, 0),
-					Attributes: attributes.New(
-						constant.KeyRouteConfig, endpoint.RouteConfigs, // 路由配置
-						constant.KeyProviderConfig, endpoint.ProviderConfigs, // 服务提供方元信息
-						constant.KeyConsumerConfig, endpoint.ConsumerConfigs, // 服务消费方配置信息
-					),
-				}
-				for _, node := range endpoint.Nodes {
-					var address resolver.Address
-					address.Addr = node.Address
-					address.ServerName = target.Endpoint
-					address.Attributes = attributes.New(constant.KeyServiceInfo, node)
-					state.Addresses = append(state.Addresses, address)
+			case endpoint, ok := <-endpoints:
+				if !ok {
+					return
 				}
+				var state resolver.State
+				state, attrs = newState(name, endpoint, attrs)
+				ts.setConsumers(endpoint.ConsumerConfigs)
 				cc.UpdateState(state)
-			case <-stop:
+				ts.update(len(state.Addresses))
+			case <-ctx.Done():
 				return
 			}
 		}
 	})
 
 	return &baseResolver{
-		stop: stop,
+		cancel: cancel,
+		state:  ts,
 	}, nil
 }
 
+// NewState translates endpoints of service name into the resolver state.
+// Route, provider and consumer configs are attributes of the state, and
+// each address, sorted by Addr, carries its ServiceInfo, weight, balance
+// group and metadata as attributes.
+func NewState(name string, endpoints registry.Endpoints) resolver.State {
+	state, _ := newState(name, endpoints, nil)
+	return state
+}
+
+// nodeAttributes are attributes of addresses by Addr, with the node they're
+// created of
+type nodeAttributes map[string]struct {
+	node  server.ServiceInfo
+	attrs *attributes.Attributes
+}
+
+// newState is NewState reusing attributes of nodes unchanged since prev,
+// addresses are compared as a whole by balancers, e.g. grpc base, and
+// attributes by pointer, so new attributes of the same nodes would make
+// them redial all SubConns on every update
+func newState(name string, endpoints registry.Endpoints, prev nodeAttributes) (resolver.State, nodeAttributes) {
+	var next = make(nodeAttributes, endpoints.Nodes.Len())
+	var state = resolver.State{
+		Addresses: make([]resolver.Address, 0, endpoints.Nodes.Len()),
+		Attributes: attributes.New(
+			constant.KeyRouteConfig, endpoints.RouteConfigs, // 路由配置
+			constant.KeyProviderConfig, endpoints.ProviderConfigs, // 服务提供方元信息
+			constant.KeyConsumerConfig, endpoints.ConsumerConfigs, // 服务消费方配置信息
+		),
+	}
+	endpoints.Nodes.Range(func(_ string, node server.ServiceInfo) bool {
+		group := node.Group
+		if group == "" {
+			group = constant.DefaultBalanceGroup
+		}
+		cached, ok := prev[node.Address]
+		if !ok || !reflect.DeepEqual(cached.node, node) {
+			cached.node = node
+			cached.attrs = attributes.New(
+				constant.KeyServiceInfo, node,
+				constant.KeyWeight, node.Weight,
+				constant.KeyBalanceGroup, group,
+				constant.KeyMetadata, node.Metadata,
+			)
+		}
+		next[node.Address] = cached
+		state.Addresses = append(state.Addresses, resolver.Address{
+			Addr:       node.Address,
+			ServerName: name,
+			Attributes: cached.attrs,
+		})
+		return true
+	})
+	// nodes are ranged in random order, addresses are sorted so that states
+	// of unchanged endpoints are in the same order
+	sort.Slice(state.Addresses, func(i, j int) bool {
+		return state.Addresses[i].Addr < state.Addresses[j].Addr
+	})
+	return state, next
+}
+
+// ServiceInfo returns the ServiceInfo attribute of addr set by NewState
+func ServiceInfo(addr resolver.Address) (server.ServiceInfo, bool) {
+	if addr.Attributes == nil {
+		return server.ServiceInfo{}, false
+	}
+	info, ok := addr.Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo)
+	return info, ok
+}
+
 // Scheme ...
 func (b baseBuilder) Scheme() string {
 	return b.name
 }
 
 type baseResolver struct {
-	stop chan struct{}
+	cancel context.CancelFunc
+	state  *targetState
 }
 
 // ResolveNow ...
-func (b *baseResolver) ResolveNow(options resolver.ResolveNowOptions) {}
+func (b *baseResolver) ResolveNow(options resolver.ResolveNowOptions) { b.state.resolveNow() }
 
 // Close ...
-func (b *baseResolver) Close() { b.stop <- struct{}{} }
+func (b *baseResolver) Close() {
+	// stops watching of the registry
+	b.cancel()
+	b.state.close()
+}
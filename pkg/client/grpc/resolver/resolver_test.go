@@ -14,8 +14,134 @@
 
 package resolver
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/registry/fake"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+type fakeClientConn struct {
+	mu     sync.Mutex
+	states []resolver.State
+}
+
+func (cc *fakeClientConn) UpdateState(state resolver.State) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.states = append(cc.states, state)
+}
+
+func (cc *fakeClientConn) addrs() []string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if len(cc.states) == 0 {
+		return nil
+	}
+	var addrs = make([]string, 0)
+	for _, addr := range cc.states[len(cc.states)-1].Addresses {
+		addrs = append(addrs, addr.Addr)
+	}
+	return addrs
+}
+
+func (cc *fakeClientConn) ReportError(error)                       {}
+func (cc *fakeClientConn) NewAddress(addresses []resolver.Address) {}
+func (cc *fakeClientConn) NewServiceConfig(string)                 {}
+func (cc *fakeClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return nil
+}
 
 func Test_baseResolver(t *testing.T) {
+	reg := fake.New()
+	info := server.ServiceInfo{Name: "demo", Scheme: "grpc", Address: "10.0.0.1:9091", Labels: map[string]string{"zone": "z1"}}
+	assert.Nil(t, reg.RegisterService(context.Background(), &info))
+
+	builder := &baseBuilder{name: "fake", reg: reg}
+	cc := &fakeClientConn{}
+	r, err := builder.Build(resolver.Target{Endpoint: "demo?labels=zone=z1"}, cc, resolver.BuildOptions{})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return len(cc.addrs()) == 1 }, time.Second, time.Millisecond)
+
+	other := info
+	other.Address, other.Labels = "10.0.0.2:9091", map[string]string{"zone": "z2"}
+	reg.Put(other)
+	info.Address = "10.0.0.3:9091"
+	reg.Put(info)
+	assert.Eventually(t, func() bool {
+		addrs := cc.addrs()
+		return len(addrs) == 2 && addrs[0] != "10.0.0.2:9091" && addrs[1] != "10.0.0.2:9091"
+	}, time.Second, time.Millisecond)
+
+	r.Close()
+	assert.Eventually(t, func() bool { return reg.Watches() == 0 }, time.Second, time.Millisecond)
+
+	reg.FailNext(fake.OpWatch, 1, errors.New("unavailable"))
+	_, err = builder.Build(resolver.Target{Endpoint: "demo"}, &fakeClientConn{}, resolver.BuildOptions{})
+	assert.EqualError(t, err, "unavailable")
+}
+
+func TestNewState(t *testing.T) {
+	endpoints := registry.Endpoints{
+		Nodes: registry.NewNodes(map[string]server.ServiceInfo{
+			"10.0.0.2:9091": {Name: "demo", Address: "10.0.0.2:9091", Weight: 50, Group: "canary", Metadata: map[string]string{"version": "v2"}},
+			"10.0.0.1:9091": {Name: "demo", Address: "10.0.0.1:9091", Weight: 100},
+		}),
+		RouteConfigs: map[string]registry.RouteConfig{"r1": {ID: "r1"}},
+	}
+	state := NewState("demo", endpoints)
+	assert.Len(t, state.Addresses, 2)
+	assert.Equal(t, endpoints.RouteConfigs, state.Attributes.Value(constant.KeyRouteConfig))
+
+	first, second := state.Addresses[0], state.Addresses[1]
+	assert.Equal(t, "10.0.0.1:9091", first.Addr)
+	assert.Equal(t, "demo", first.ServerName)
+	assert.Equal(t, float64(100), first.Attributes.Value(constant.KeyWeight))
+	assert.Equal(t, constant.DefaultBalanceGroup, first.Attributes.Value(constant.KeyBalanceGroup))
+
+	assert.Equal(t, "10.0.0.2:9091", second.Addr)
+	assert.Equal(t, float64(50), second.Attributes.Value(constant.KeyWeight))
+	assert.Equal(t, "canary", second.Attributes.Value(constant.KeyBalanceGroup))
+	assert.Equal(t, map[string]string{"version": "v2"}, second.Attributes.Value(constant.KeyMetadata))
+	info, ok := ServiceInfo(second)
+	assert.True(t, ok)
+	assert.Equal(t, "canary", info.Group)
+
+	_, ok = ServiceInfo(resolver.Address{Addr: "10.0.0.3:9091"})
+	assert.False(t, ok)
+	assert.Equal(t, state, NewState("demo", endpoints))
+}
+
+func Test_newState(t *testing.T) {
+	nodes := map[string]server.ServiceInfo{
+		"10.0.0.1:9091": {Name: "demo", Address: "10.0.0.1:9091", Weight: 100, Metadata: map[string]string{"version": "v1"}},
+		"10.0.0.2:9091": {Name: "demo", Address: "10.0.0.2:9091", Weight: 100},
+	}
+	state, attrs := newState("demo", registry.Endpoints{Nodes: registry.NewNodes(nodes)}, nil)
+
+	// addresses of unchanged nodes are equal as a whole, e.g. keys of SubConns
+	nodes["10.0.0.1:9091"] = server.ServiceInfo{Name: "demo", Address: "10.0.0.1:9091", Weight: 100, Metadata: map[string]string{"version": "v1"}}
+	nodes["10.0.0.2:9091"] = server.ServiceInfo{Name: "demo", Address: "10.0.0.2:9091", Weight: 50}
+	next, attrs := newState("demo", registry.Endpoints{Nodes: registry.NewNodes(nodes)}, attrs)
+	assert.True(t, state.Addresses[0] == next.Addresses[0])
+	assert.False(t, state.Addresses[1] == next.Addresses[1])
+	assert.Equal(t, float64(50), next.Addresses[1].Attributes.Value(constant.KeyWeight))
+
+	delete(nodes, "10.0.0.1:9091")
+	_, attrs = newState("demo", registry.Endpoints{Nodes: registry.NewNodes(nodes)}, attrs)
+	assert.Len(t, attrs, 1)
+}
 
+func TestNewBuilder(t *testing.T) {
+	builder := NewBuilder("fake", fake.New())
+	assert.Equal(t, "fake", builder.Scheme())
 }
@@ -0,0 +1,198 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server/governor"
+)
+
+var (
+	resolverEndpointsGauge = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "client_resolver_endpoints",
+		Labels:    []string{"scheme", "target"},
+	}.Build()
+
+	resolverLastUpdateGauge = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "client_resolver_last_update_timestamp",
+		Labels:    []string{"scheme", "target"},
+	}.Build()
+
+	resolverUpdateCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "client_resolver_updates_total",
+		Labels:    []string{"scheme", "target", "code"},
+	}.Build()
+)
+
+// State is the state of a resolver target, it tells whether discovery or
+// the balancer is at fault when a client has no available endpoints
+type State struct {
+	Scheme         string    `json:"scheme"`
+	Target         string    `json:"target"`
+	Endpoints      int       `json:"endpoints"`
+	Updates        uint64    `json:"updates"`
+	LastUpdate     time.Time `json:"lastUpdate"`
+	LastError      string    `json:"lastError,omitempty"`
+	LastErrorTime  time.Time `json:"lastErrorTime,omitempty"`
+	PendingResolve bool      `json:"pendingResolve"`
+}
+
+type targetState struct {
+	mu        sync.Mutex
+	state     State
+	consumers map[string]registry.ConsumerConfig
+	// refs is the number of resolvers sharing the state, guarded by statesMu
+	refs int
+}
+
+var (
+	statesMu sync.Mutex
+	states   = make(map[string]*targetState) // scheme://target => *targetState
+)
+
+// getState returns the state shared by resolvers of the target, each call
+// must be paired with a close
+func getState(scheme, target string) *targetState {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	key := scheme + "://" + target
+	ts, ok := states[key]
+	if !ok {
+		ts = &targetState{state: State{Scheme: scheme, Target: target}}
+		states[key] = ts
+	}
+	ts.refs++
+	return ts
+}
+
+func lookupState(scheme, target string) (*targetState, bool) {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+	ts, ok := states[scheme+"://"+target]
+	return ts, ok
+}
+
+func (ts *targetState) update(endpoints int) {
+	ts.mu.Lock()
+	ts.state.Endpoints = endpoints
+	ts.state.Updates++
+	ts.state.LastUpdate = time.Now()
+	ts.state.PendingResolve = false
+	scheme, target := ts.state.Scheme, ts.state.Target
+	ts.mu.Unlock()
+
+	resolverEndpointsGauge.Set(float64(endpoints), scheme, target)
+	resolverLastUpdateGauge.Set(float64(time.Now().Unix()), scheme, target)
+	resolverUpdateCounter.Inc(scheme, target, "OK")
+}
+
+func (ts *targetState) setConsumers(consumers map[string]registry.ConsumerConfig) {
+	ts.mu.Lock()
+	ts.consumers = consumers
+	ts.mu.Unlock()
+}
+
+// ConsumerConfigs returns the consumer configs last resolved of the target,
+// e.g. ConsumerConfigs("etcd", "demo") of clients of "etcd:///demo"
+func ConsumerConfigs(scheme, target string) (map[string]registry.ConsumerConfig, bool) {
+	ts, ok := lookupState(scheme, target)
+	if !ok {
+		return nil, false
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.consumers, ts.consumers != nil
+}
+
+func (ts *targetState) fail(err error) {
+	ts.mu.Lock()
+	ts.state.LastError = err.Error()
+	ts.state.LastErrorTime = time.Now()
+	scheme, target := ts.state.Scheme, ts.state.Target
+	ts.mu.Unlock()
+
+	resolverUpdateCounter.Inc(scheme, target, "Error")
+}
+
+func (ts *targetState) resolveNow() {
+	ts.mu.Lock()
+	ts.state.PendingResolve = true
+	ts.mu.Unlock()
+}
+
+// close releases the state, which is removed once no resolver shares it,
+// unless its last resolution failed so that the error is still reported
+func (ts *targetState) close() {
+	ts.mu.Lock()
+	scheme, target := ts.state.Scheme, ts.state.Target
+	failed := ts.state.LastErrorTime.After(ts.state.LastUpdate)
+	ts.mu.Unlock()
+
+	statesMu.Lock()
+	ts.refs--
+	if ts.refs > 0 {
+		statesMu.Unlock()
+		return
+	}
+	if !failed {
+		delete(states, scheme+"://"+target)
+	}
+	statesMu.Unlock()
+	resolverEndpointsGauge.Set(0, scheme, target)
+}
+
+// States returns states of all resolver targets, sorted by scheme and target
+func States() []State {
+	statesMu.Lock()
+	var all = make([]*targetState, 0, len(states))
+	for _, ts := range states {
+		all = append(all, ts)
+	}
+	statesMu.Unlock()
+
+	var ret = make([]State, 0, len(all))
+	for _, ts := range all {
+		ts.mu.Lock()
+		ret = append(ret, ts.state)
+		ts.mu.Unlock()
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Scheme != ret[j].Scheme {
+			return ret[i].Scheme < ret[j].Scheme
+		}
+		return ret[i].Target < ret[j].Target
+	})
+	return ret
+}
+
+func init() {
+	governor.HandleFunc("/debug/resolver", func(w http.ResponseWriter, r *http.Request) {
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(States())
+	})
+}
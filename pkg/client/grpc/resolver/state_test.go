@@ -0,0 +1,83 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resolver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testStates returns states of targets of scheme test
+func testStates() []State {
+	var ret []State
+	for _, s := range States() {
+		if s.Scheme == "test" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func TestTargetState(t *testing.T) {
+	ts := getState("test", "demo")
+	ts.resolveNow()
+	ts.fail(errors.New("watch failed"))
+
+	ss := testStates()
+	assert.Len(t, ss, 1)
+	assert.True(t, ss[0].PendingResolve)
+	assert.Equal(t, "watch failed", ss[0].LastError)
+
+	ts.update(3)
+	ss = testStates()
+	assert.False(t, ss[0].PendingResolve)
+	assert.Equal(t, 3, ss[0].Endpoints)
+	assert.Equal(t, uint64(1), ss[0].Updates)
+
+	ts.close()
+	assert.Len(t, testStates(), 0)
+}
+
+func TestTargetState_Shared(t *testing.T) {
+	ts := getState("test", "shared")
+	other := getState("test", "shared")
+	assert.Same(t, ts, other)
+
+	ts.update(2)
+	other.close()
+	// still used by the first resolver
+	if ss := testStates(); assert.Len(t, ss, 1) {
+		assert.Equal(t, 2, ss[0].Endpoints)
+	}
+	ts.close()
+	assert.Len(t, testStates(), 0)
+}
+
+func TestTargetState_KeepError(t *testing.T) {
+	ts := getState("test", "failed")
+	ts.fail(errors.New("watch failed"))
+	ts.close()
+	// the error of a failed build is still reported
+	if ss := testStates(); assert.Len(t, ss, 1) {
+		assert.Equal(t, "watch failed", ss[0].LastError)
+	}
+
+	ts = getState("test", "failed")
+	ts.update(1)
+	ts.close()
+	assert.Len(t, testStates(), 0)
+}
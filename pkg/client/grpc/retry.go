@@ -0,0 +1,173 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/client/grpc/resolver"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/util/xretry"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var retryCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "client_retry_total",
+	Labels:    []string{"name", "method", "kind"},
+}.Build()
+
+// retryPolicy returns the retry policy of the client, the one of the
+// consumer config of the registry overrides Config.Retry
+func (config *Config) retryPolicy() func() (xretry.Policy, bool) {
+	scheme, target := parseTarget(config.Address)
+	return func() (xretry.Policy, bool) {
+		if configs, ok := resolver.ConsumerConfigs(scheme, target); ok {
+			if consumer, ok := registry.LocalConsumerConfig(configs); ok && consumer.Retry != nil {
+				return *consumer.Retry, true
+			}
+		}
+		if config.Retry != nil {
+			return *config.Retry, true
+		}
+		return xretry.Policy{}, false
+	}
+}
+
+// parseTarget returns the scheme and endpoint of targets resolved by
+// registries, e.g. "etcd:///demo?labels=env=prod" is "etcd" and "demo"
+func parseTarget(address string) (string, string) {
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme == "" {
+		return "", address
+	}
+	return u.Scheme, strings.TrimPrefix(u.Path, "/")
+}
+
+// retryUnaryClientInterceptor retries calls failing with retryable codes
+// with backoff, or hedges them, within budgets of methods
+func retryUnaryClientInterceptor(name string, policy func() (xretry.Policy, bool), clock xtime.Clock) grpc.UnaryClientInterceptor {
+	var budgets sync.Map // method => *xretry.Budget
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		p, ok := policy()
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if p = p.ForMethod(method); !p.Enabled() {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		value, ok := budgets.Load(method)
+		if !ok {
+			value, _ = budgets.LoadOrStore(method, xretry.NewBudget(p))
+		}
+		budget := value.(*xretry.Budget)
+		budget.Update(p)
+		budget.Call()
+
+		if _, ok := reply.(proto.Message); ok && p.HedgeDelay > 0 {
+			return hedge(ctx, name, method, req, reply, cc, invoker, opts, p, budget, clock)
+		}
+
+		for retries := 0; ; retries++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || retries >= p.MaxRetries || !p.Retryable(status.Code(err)) {
+				return err
+			}
+			if !budget.Retry() {
+				retryCounter.Inc(name, method, "exhausted")
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-clock.After(p.Backoff.Backoff(retries)):
+			}
+			retryCounter.Inc(name, method, "retry")
+		}
+	}
+}
+
+type hedgeResult struct {
+	reply interface{}
+	err   error
+}
+
+// hedge sends another attempt once previous ones haven't responded in
+// HedgeDelay, or failed with retryable codes, the first success wins and
+// the others are canceled. Attempts decode into replies of their own.
+func hedge(ctx context.Context, name, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption, p xretry.Policy, budget *xretry.Budget, clock xtime.Clock) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var results = make(chan hedgeResult, p.MaxRetries+1)
+	var attempt = func() {
+		attemptReply := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+		err := invoker(ctx, method, req, attemptReply, cc, opts...)
+		results <- hedgeResult{reply: attemptReply, err: err}
+	}
+	go attempt()
+
+	var inflight, sent = 1, 1
+	var lastErr error
+	for {
+		var hedgeTimer <-chan time.Time
+		if sent <= p.MaxRetries {
+			hedgeTimer = clock.After(p.HedgeDelay)
+		}
+		select {
+		case result := <-results:
+			inflight--
+			if result.err == nil {
+				reply.(proto.Message).Reset()
+				proto.Merge(reply.(proto.Message), result.reply.(proto.Message))
+				return nil
+			}
+			lastErr = result.err
+			if !p.Retryable(status.Code(result.err)) {
+				return result.err
+			}
+			if sent > p.MaxRetries || !budget.Retry() {
+				if inflight == 0 {
+					return lastErr
+				}
+				continue
+			}
+			retryCounter.Inc(name, method, "retry")
+		case <-hedgeTimer:
+			if !budget.Retry() {
+				retryCounter.Inc(name, method, "exhausted")
+				continue
+			}
+			retryCounter.Inc(name, method, "hedge")
+		case <-ctx.Done():
+			if lastErr == nil {
+				lastErr = status.FromContextError(ctx.Err()).Err()
+			}
+			return lastErr
+		}
+		inflight++
+		sent++
+		go attempt()
+	}
+}
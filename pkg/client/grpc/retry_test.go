@@ -0,0 +1,132 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xretry"
+	"github.com/douyu/jupiter/pkg/util/xtest/proto/testproto"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseTarget(t *testing.T) {
+	scheme, target := parseTarget("etcd:///demo?labels=env=prod")
+	assert.Equal(t, "etcd", scheme)
+	assert.Equal(t, "demo", target)
+	scheme, target = parseTarget("127.0.0.1:9090")
+	assert.Equal(t, "", scheme)
+	assert.Equal(t, "127.0.0.1:9090", target)
+}
+
+func TestRetryUnaryClientInterceptor(t *testing.T) {
+	policy := xretry.DefaultPolicy()
+	policy.MaxRetries = 2
+	policy.Backoff = xbackoff.Config{BaseDelay: time.Millisecond, Multiplier: 1, MaxDelay: time.Millisecond}
+	policy.Methods = map[string]xretry.Policy{"/hello/Off": {}}
+	interceptor := retryUnaryClientInterceptor("test", func() (xretry.Policy, bool) { return policy, true }, xtime.SystemClock)
+
+	var attempts int
+	var errs []error
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempts++
+		if attempts <= len(errs) {
+			return errs[attempts-1]
+		}
+		return nil
+	}
+	call := func(method string, failures ...error) error {
+		attempts, errs = 0, failures
+		return interceptor(context.Background(), method, &testproto.HelloRequest{}, &struct{}{}, nil, invoker)
+	}
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	// retried until success
+	assert.Nil(t, call("/hello/SayHello", unavailable, unavailable))
+	assert.Equal(t, 3, attempts)
+	// up to MaxRetries
+	assert.Equal(t, unavailable, call("/hello/SayHello", unavailable, unavailable, unavailable))
+	assert.Equal(t, 3, attempts)
+	// other codes fail at once
+	assert.Equal(t, codes.InvalidArgument, status.Code(call("/hello/SayHello", status.Error(codes.InvalidArgument, "invalid"))))
+	assert.Equal(t, 1, attempts)
+	// overrides of methods
+	assert.Equal(t, unavailable, call("/hello/Off", unavailable))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryBudget(t *testing.T) {
+	policy := xretry.DefaultPolicy()
+	policy.MaxRetries = 1
+	policy.BudgetRatio = 0.1
+	policy.BudgetMin = 2
+	policy.Backoff = xbackoff.Config{BaseDelay: time.Millisecond, Multiplier: 1, MaxDelay: time.Millisecond}
+	interceptor := retryUnaryClientInterceptor("test", func() (xretry.Policy, bool) { return policy, true }, xtime.SystemClock)
+
+	var attempts int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempts++
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	for i := 0; i < 5; i++ {
+		_ = interceptor(context.Background(), "/hello/SayHello", nil, &struct{}{}, nil, invoker)
+	}
+	// 5 calls and BudgetMin retries
+	assert.Equal(t, 7, attempts)
+}
+
+func TestRetryHedge(t *testing.T) {
+	policy := xretry.DefaultPolicy()
+	policy.MaxRetries = 1
+	policy.HedgeDelay = 50 * time.Millisecond
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	interceptor := retryUnaryClientInterceptor("test", func() (xretry.Policy, bool) { return policy, true }, clock)
+
+	var attempts = make(chan context.Context, 2)
+	var sent int32
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempts <- ctx
+		if atomic.AddInt32(&sent, 1) == 1 {
+			// the first attempt hangs until canceled
+			<-ctx.Done()
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		reply.(*testproto.HelloReply).Message = "hedged"
+		return nil
+	}
+
+	done := make(chan error)
+	reply := &testproto.HelloReply{Message: "stale"}
+	go func() {
+		done <- interceptor(context.Background(), "/hello/SayHello", &testproto.HelloRequest{}, reply, nil, invoker)
+	}()
+	first := <-attempts
+	clock.BlockUntil(1)
+	clock.Advance(policy.HedgeDelay)
+	<-attempts
+
+	assert.Nil(t, <-done)
+	assert.Equal(t, "hedged", reply.Message)
+	// the losing attempt is canceled
+	<-first.Done()
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("client.oauth2", "jupiter.oauth2.*", "oauth2 client credentials token source", DefaultConfig)
+}
+
+// Auth styles of client credentials
+const (
+	// AuthStyleHeader sends client credentials with HTTP basic auth
+	AuthStyleHeader = "header"
+	// AuthStyleParams sends client credentials in the form
+	AuthStyleParams = "params"
+)
+
+// Config ...
+type Config struct {
+	// TokenURL of the authorization server, discovered from Issuer if empty
+	TokenURL string
+	// Issuer of OIDC, whose /.well-known/openid-configuration tells TokenURL
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// Audience is sent as audience param if not empty, required by some providers
+	Audience string
+	// AuthStyle is header or params
+	AuthStyle string
+	// RefreshBefore refreshes tokens in background this long before expiry
+	RefreshBefore time.Duration
+	// Timeout of each token request
+	Timeout time.Duration
+	// RefreshTimeout bounds a refresh including retries, which is shared by
+	// concurrent callers and goes on after any of them gave up
+	RefreshTimeout time.Duration
+	// Backoff of token request retries
+	Backoff xbackoff.Config
+	// RequireTLS of gRPC credentials, tokens are only sent over TLS if true
+	RequireTLS bool
+
+	logger *xlog.Logger
+	client *http.Client
+	clock  xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		AuthStyle:      AuthStyleHeader,
+		RefreshBefore:  time.Minute,
+		Timeout:        5 * time.Second,
+		RefreshTimeout: 30 * time.Second,
+		Backoff:        xbackoff.DefaultConfig(),
+		logger:         xlog.JupiterLogger.With(xlog.FieldMod("client.oauth2")),
+		clock:          xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.oauth2." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("oauth2 parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// WithHTTPClient replaces the http client of token requests
+func (config *Config) WithHTTPClient(client *http.Client) *Config {
+	config.client = client
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *TokenSource {
+	if config.client == nil {
+		config.client = &http.Client{Timeout: config.Timeout}
+	}
+	if config.TokenURL == "" && config.Issuer == "" {
+		config.logger.Panic("oauth2 token url or issuer required", xlog.String("clientId", config.ClientID))
+	}
+	return newTokenSource(config)
+}
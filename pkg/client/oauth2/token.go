@@ -0,0 +1,258 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oauth2 fetches and caches OAuth2 client credentials tokens, e.g.
+// of OIDC providers, for calling third-party APIs. Tokens are refreshed in
+// background before expiry, failed requests are retried with jittered backoff.
+//
+//	ts := oauth2.StdConfig("partner").Build()
+//	client := &http.Client{Transport: ts.Transport(nil)}
+//	conn := grpc.StdConfig("partner").WithDialOption(ts.DialOption()).Build()
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xcontext"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// Token ...
+type Token struct {
+	AccessToken string
+	TokenType   string
+	Expiry      time.Time
+}
+
+// Type returns the token type, Bearer by default
+func (t *Token) Type() string {
+	if t.TokenType == "" || strings.EqualFold(t.TokenType, "bearer") {
+		return "Bearer"
+	}
+	return t.TokenType
+}
+
+// Error is an error response of the authorization server
+type Error struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Error ...
+func (e *Error) Error() string {
+	return "oauth2: " + e.Code + " " + e.Description
+}
+
+// TokenSource ...
+type TokenSource struct {
+	config *Config
+	group  singleflight.Group
+	// refreshing is 1 while refreshing in background
+	refreshing int32
+
+	mu       sync.Mutex
+	token    *Token
+	tokenURL string
+}
+
+func newTokenSource(config *Config) *TokenSource {
+	return &TokenSource{config: config, tokenURL: config.TokenURL}
+}
+
+// Token returns the cached token, fetching it if absent or expired. Tokens
+// about to expire are still returned while refreshed in background.
+func (ts *TokenSource) Token(ctx context.Context) (*Token, error) {
+	now := ts.config.clock.Now()
+	ts.mu.Lock()
+	token := ts.token
+	ts.mu.Unlock()
+
+	if token != nil && now.Before(token.Expiry) {
+		if !now.Before(token.Expiry.Add(-ts.config.RefreshBefore)) && atomic.CompareAndSwapInt32(&ts.refreshing, 0, 1) {
+			xgo.Go(func() {
+				defer atomic.StoreInt32(&ts.refreshing, 0)
+				if _, err := ts.refresh(context.Background()); err != nil {
+					ts.config.logger.Warn("refresh token", xlog.String("clientId", ts.config.ClientID), xlog.FieldErr(err))
+				}
+			})
+		}
+		return token, nil
+	}
+	return ts.refresh(ctx)
+}
+
+// Invalidate drops the cached token, e.g. after the API rejected it
+func (ts *TokenSource) Invalidate(token *Token) {
+	ts.mu.Lock()
+	if ts.token == token {
+		ts.token = nil
+	}
+	ts.mu.Unlock()
+}
+
+// refresh fetches a token, concurrent calls share one request, which runs
+// on a detached context so that the caller giving up doesn't fail the others
+func (ts *TokenSource) refresh(ctx context.Context) (*Token, error) {
+	ch := ts.group.DoChan("token", func() (interface{}, error) {
+		ctx, cancel := xcontext.DetachWithTimeout(ctx, ts.config.RefreshTimeout)
+		defer cancel()
+		token, err := ts.fetchWithRetry(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ts.mu.Lock()
+		ts.token = token
+		ts.mu.Unlock()
+		return token, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*Token), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchWithRetry retries fetch with backoff, except rejected credentials
+func (ts *TokenSource) fetchWithRetry(ctx context.Context) (*Token, error) {
+	backoff := ts.config.Backoff
+	for retries := 0; ; retries++ {
+		token, err := ts.fetch(ctx)
+		if err == nil {
+			return token, nil
+		}
+		if e, ok := err.(*Error); ok && e.Status < 500 && e.Status != http.StatusTooManyRequests {
+			return nil, err
+		}
+		if backoff.MaxRetries >= 0 && retries >= backoff.MaxRetries {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-ts.config.clock.After(backoff.Backoff(retries)):
+		}
+	}
+}
+
+func (ts *TokenSource) fetch(ctx context.Context) (*Token, error) {
+	tokenURL, err := ts.endpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(ts.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(ts.config.Scopes, " "))
+	}
+	if ts.config.Audience != "" {
+		form.Set("audience", ts.config.Audience)
+	}
+	if ts.config.AuthStyle == AuthStyleParams {
+		form.Set("client_id", ts.config.ClientID)
+		form.Set("client_secret", ts.config.ClientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if ts.config.AuthStyle != AuthStyleParams {
+		req.SetBasicAuth(url.QueryEscape(ts.config.ClientID), url.QueryEscape(ts.config.ClientSecret))
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := ts.do(req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.AccessToken == "" {
+		return nil, errors.New("oauth2: no access_token in response")
+	}
+	token := &Token{AccessToken: resp.AccessToken, TokenType: resp.TokenType}
+	if resp.ExpiresIn > 0 {
+		token.Expiry = ts.config.clock.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	} else {
+		// tokens without expires_in are refreshed hourly
+		token.Expiry = ts.config.clock.Now().Add(time.Hour)
+	}
+	return token, nil
+}
+
+// endpoint returns TokenURL, discovered from the OIDC issuer on first use
+func (ts *TokenSource) endpoint(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	tokenURL := ts.tokenURL
+	ts.mu.Unlock()
+	if tokenURL != "" {
+		return tokenURL, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(ts.config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return "", err
+	}
+	var discovery struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	if err := ts.do(req.WithContext(ctx), &discovery); err != nil {
+		return "", errors.Wrap(err, "oidc discovery")
+	}
+	if discovery.TokenEndpoint == "" {
+		return "", errors.New("oauth2: no token_endpoint in oidc discovery")
+	}
+	ts.mu.Lock()
+	ts.tokenURL = discovery.TokenEndpoint
+	ts.mu.Unlock()
+	return discovery.TokenEndpoint, nil
+}
+
+func (ts *TokenSource) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := ts.config.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e = &Error{Status: resp.StatusCode}
+		if json.Unmarshal(data, e) != nil || e.Code == "" {
+			e.Code, e.Description = http.StatusText(resp.StatusCode), strings.TrimSpace(string(data))
+		}
+		return e
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer issues tokens "t1", "t2"..., failing the first fails requests
+type fakeServer struct {
+	*httptest.Server
+	issued int32
+	fails  int32
+}
+
+func newFakeServer(t *testing.T, fails int32) *fakeServer {
+	fake := &fakeServer{fails: fails}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"token_endpoint": fake.URL + "/token"})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fake.fails, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		id, secret, _ := r.BasicAuth()
+		if id != "app" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(Error{Code: "invalid_client"})
+			return
+		}
+		assert.Equal(t, "client_credentials", r.FormValue("grant_type"))
+		assert.Equal(t, "read write", r.FormValue("scope"))
+		n := atomic.AddInt32(&fake.issued, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("t%d", n),
+			"token_type":   "bearer",
+			"expires_in":   3600,
+		})
+	})
+	fake.Server = httptest.NewServer(mux)
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func newTestConfig(clock xtime.Clock) *Config {
+	config := DefaultConfig()
+	config.ClientID = "app"
+	config.ClientSecret = "secret"
+	config.Scopes = []string{"read", "write"}
+	config.Backoff.BaseDelay = time.Millisecond
+	config.Backoff.Jitter = 0
+	config.clock = clock
+	return config
+}
+
+func TestTokenSource_Token(t *testing.T) {
+	fake := newFakeServer(t, 1)
+	clock := xtime.NewMockClock(time.Now())
+	config := newTestConfig(xtime.SystemClock)
+	config.Issuer = fake.URL
+	ts := config.Build()
+	ts.config.clock = clock
+
+	// retried after the first failure
+	done := make(chan *Token)
+	go func() {
+		token, err := ts.Token(context.Background())
+		assert.Nil(t, err)
+		done <- token
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Millisecond)
+	token := <-done
+	assert.Equal(t, "t1", token.AccessToken)
+	assert.Equal(t, "Bearer", token.Type())
+
+	token, _ = ts.Token(context.Background())
+	assert.Equal(t, "t1", token.AccessToken, "cached")
+
+	// refreshed in background before expiry
+	clock.Advance(time.Hour - 30*time.Second)
+	token, _ = ts.Token(context.Background())
+	assert.Equal(t, "t1", token.AccessToken)
+	assert.Eventually(t, func() bool {
+		token, _ := ts.Token(context.Background())
+		return token.AccessToken == "t2"
+	}, time.Second, time.Millisecond)
+
+	// fetched synchronously after expiry
+	clock.Advance(2 * time.Hour)
+	token, _ = ts.Token(context.Background())
+	assert.Equal(t, "t3", token.AccessToken)
+}
+
+func TestTokenSource_Canceled(t *testing.T) {
+	fake := newFakeServer(t, 1)
+	clock := xtime.NewMockClock(time.Now())
+	config := newTestConfig(clock)
+	config.TokenURL = fake.URL + "/token"
+	ts := config.Build()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := ts.Token(ctx)
+		done <- err
+	}()
+	clock.BlockUntil(1)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	// the refresh goes on after the caller gave up
+	clock.Advance(time.Millisecond)
+	assert.Eventually(t, func() bool {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		return ts.token != nil
+	}, time.Second, time.Millisecond)
+	token, err := ts.Token(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "t1", token.AccessToken)
+}
+
+func TestTokenSource_Rejected(t *testing.T) {
+	fake := newFakeServer(t, 0)
+	config := newTestConfig(xtime.SystemClock)
+	config.TokenURL = fake.URL + "/token"
+	config.ClientSecret = "wrong"
+	_, err := config.Build().Token(context.Background())
+	assert.Equal(t, "invalid_client", err.(*Error).Code)
+}
+
+func TestTransport(t *testing.T) {
+	fake := newFakeServer(t, 0)
+	config := newTestConfig(xtime.SystemClock)
+	config.TokenURL = fake.URL + "/token"
+	ts := config.Build()
+
+	var auths []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		if len(auths) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer api.Close()
+
+	client := &http.Client{Transport: ts.Transport(nil)}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(api.URL)
+		assert.Nil(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, []string{"Bearer t1", "Bearer t2"}, auths, "invalidated after 401")
+
+	md, err := ts.PerRPCCredentials().GetRequestMetadata(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"authorization": "Bearer t2"}, md)
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oauth2
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Transport returns a RoundTripper authorizing requests with tokens,
+// http.DefaultTransport is used if next is nil
+func (ts *TokenSource) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{ts: ts, next: next}
+}
+
+type transport struct {
+	ts   *TokenSource
+	next http.RoundTripper
+}
+
+// RoundTrip ...
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.ts.Token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	// RoundTrippers must not modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", token.Type()+" "+token.AccessToken)
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// e.g. revoked tokens, the next request fetches a new one
+		t.ts.Invalidate(token)
+	}
+	return resp, err
+}
+
+// PerRPCCredentials returns gRPC credentials authorizing calls with tokens
+func (ts *TokenSource) PerRPCCredentials() credentials.PerRPCCredentials {
+	return perRPCCredentials{ts: ts}
+}
+
+// DialOption returns the dial option of PerRPCCredentials
+func (ts *TokenSource) DialOption() grpc.DialOption {
+	return grpc.WithPerRPCCredentials(ts.PerRPCCredentials())
+}
+
+type perRPCCredentials struct {
+	ts *TokenSource
+}
+
+// GetRequestMetadata ...
+func (c perRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.ts.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": token.Type() + " " + token.AccessToken}, nil
+}
+
+// RequireTransportSecurity ...
+func (c perRPCCredentials) RequireTransportSecurity() bool {
+	return c.ts.config.RequireTLS
+}
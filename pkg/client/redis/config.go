@@ -22,8 +22,15 @@ import (
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/util/xtime"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 )
 
+func init() {
+	xschema.RegisterConfig("redis", "jupiter.redis.*", "redis client", DefaultRedisConfig)
+	xschema.RegisterConfig("redis.stub", "jupiter.redis.*.stub", "redis stub client", DefaultRedisConfig)
+	xschema.RegisterConfig("redis.cluster", "jupiter.redis.*.cluster", "redis cluster client", DefaultRedisConfig)
+}
+
 const (
 	//ClusterMode using clusterClient
 	ClusterMode string = "cluster"
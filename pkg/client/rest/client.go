@@ -0,0 +1,196 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rest is an http client of JSON apis with the governance of
+// jupiter clients: timeouts, retries with backoff, bulkheads, tracing,
+// metrics and the aid header. Clients generated by `jupiter client` from
+// OpenAPI documents of services call it.
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/douyu/jupiter/pkg/xbulkhead"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/pkg/errors"
+)
+
+// maxErrorBody caps the body kept by Error
+const maxErrorBody = 4 << 10
+
+// Request ...
+type Request struct {
+	Method string
+	// Route is the path template, e.g. "/v1/users/{id}", which names the
+	// request in metrics and traces
+	Route string
+	// Path is Route with parameters filled, Route if empty
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// Body is sent as JSON unless nil
+	Body interface{}
+}
+
+// Error is a response with a status other than 2xx
+type Error struct {
+	Method     string
+	Route      string
+	StatusCode int
+	Body       []byte
+}
+
+// Error ...
+func (e *Error) Error() string {
+	return fmt.Sprintf("rest: %s %s: %d %s: %s", e.Method, e.Route, e.StatusCode, http.StatusText(e.StatusCode), bytes.TrimSpace(e.Body))
+}
+
+// Client ...
+type Client struct {
+	config *Config
+	client *http.Client
+}
+
+func newClient(config *Config, client *http.Client) *Client {
+	return &Client{config: config, client: client}
+}
+
+// Do sends req, and decodes the JSON response into out unless it's nil.
+// Responses with a status other than 2xx are returned as *Error.
+func (c *Client) Do(ctx context.Context, req Request, out interface{}) (err error) {
+	var body []byte
+	if req.Body != nil {
+		if body, err = json.Marshal(req.Body); err != nil {
+			return errors.Wrap(err, "marshal request")
+		}
+	}
+	if req.Path == "" {
+		req.Path = req.Route
+	}
+	name := req.Method + " " + req.Route
+
+	if !c.config.DisableTrace {
+		span, spanCtx := trace.StartSpanFromContext(ctx, name,
+			trace.TagComponent("http"),
+			trace.TagSpanKind("client"),
+			trace.CustomTag("http.method", req.Method),
+			trace.CustomTag("http.url", req.Path),
+		)
+		ctx = spanCtx
+		defer func() {
+			if err != nil {
+				ext.Error.Set(span, true)
+			}
+			span.Finish()
+		}()
+	}
+
+	for retries := 0; ; retries++ {
+		beg := time.Now()
+		var status int
+		status, err = c.attempt(ctx, req, body, out)
+		if !c.config.DisableMetric {
+			code := http.StatusText(status)
+			if status == 0 {
+				code = "error"
+			}
+			metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, c.config.Name, name, c.config.Addr)
+			metric.ClientHandleCounter.Inc(metric.TypeHTTP, c.config.Name, name, c.config.Addr, code)
+		}
+		if err == nil || retries >= c.config.Retries || !retryable(ctx, req.Method, status, err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-c.config.clock.After(c.config.Backoff.Backoff(retries)):
+		}
+	}
+}
+
+// attempt sends req once, status is 0 if no response is received
+func (c *Client) attempt(ctx context.Context, req Request, body []byte, out interface{}) (int, error) {
+	if c.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.Timeout)
+		defer cancel()
+	}
+	target := strings.TrimSuffix(c.config.Addr, "/") + req.Path
+	if len(req.Query) > 0 {
+		target += "?" + req.Query.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	for key, values := range req.Header {
+		httpReq.Header[key] = values
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if aid := pkg.AppID(); aid != "" {
+		httpReq.Header.Set("AID", aid)
+	}
+	if !c.config.DisableTrace {
+		trace.HeaderInjector(ctx, httpReq.Header)
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxErrorBody))
+		return resp.StatusCode, &Error{Method: req.Method, Route: req.Route, StatusCode: resp.StatusCode, Body: data}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, errors.Wrap(err, "decode response")
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether a failed idempotent request may be sent again,
+// requests rejected by the bulkhead are not
+func retryable(ctx context.Context, method string, status int, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, xbulkhead.ErrRejected) {
+		return false
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+	default:
+		return false
+	}
+	switch status {
+	case 0, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
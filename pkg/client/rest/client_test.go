@@ -0,0 +1,92 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type user struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestClient_Do(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		switch r.URL.Path {
+		case "/v1/users/1":
+			assert.Equal(t, "detail", r.URL.Query().Get("view"))
+			_ = json.NewEncoder(w).Encode(user{ID: 1, Name: "jupiter"})
+		case "/v1/users":
+			var in user
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			in.ID = 2
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(in)
+		case "/flaky":
+			if n%2 == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	config := DefaultConfig()
+	config.Name = "demo"
+	config.Addr = srv.URL
+	config.Retries = 1
+	config.Backoff.BaseDelay = time.Millisecond
+	client := config.Build()
+	ctx := context.Background()
+
+	var out user
+	err := client.Do(ctx, Request{Method: http.MethodGet, Route: "/v1/users/{id}", Path: "/v1/users/1", Query: url.Values{"view": {"detail"}}}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, user{ID: 1, Name: "jupiter"}, out)
+
+	err = client.Do(ctx, Request{Method: http.MethodPost, Route: "/v1/users", Body: user{Name: "new"}}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, user{ID: 2, Name: "new"}, out)
+
+	err = client.Do(ctx, Request{Method: http.MethodGet, Route: "/v1/missing"}, &out)
+	e, ok := err.(*Error)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusNotFound, e.StatusCode)
+	assert.Equal(t, "rest: GET /v1/missing: 404 Not Found: not found", e.Error())
+
+	// GET is retried once, POST is not
+	atomic.StoreInt32(&calls, 0)
+	assert.NoError(t, client.Do(ctx, Request{Method: http.MethodGet, Route: "/flaky"}, nil))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	atomic.StoreInt32(&calls, 0)
+	assert.Error(t, client.Do(ctx, Request{Method: http.MethodPost, Route: "/flaky"}, nil))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
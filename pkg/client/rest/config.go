@@ -0,0 +1,118 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rest
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xbulkhead"
+	"github.com/douyu/jupiter/pkg/xegress"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+	"github.com/douyu/jupiter/pkg/xtls"
+)
+
+func init() {
+	xschema.RegisterConfig("client.rest", "jupiter.rest.*", "http client of JSON apis, used by clients generated by jupiter client", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Name of the service, used by metrics
+	Name string
+	// Addr of the service, e.g. http://127.0.0.1:9091
+	Addr string
+	// Timeout of each attempt
+	Timeout time.Duration
+	// Retries of idempotent requests failing to connect or responding 502,
+	// 503 or 504, with Backoff between them
+	Retries int
+	Backoff xbackoff.Config
+	// Bulkhead caps concurrent requests with the bulkhead of the name, see xbulkhead
+	Bulkhead string
+	// TLSBundle verifies servers with the CA bundle of the name, which is
+	// reloaded once it changes, see xtls
+	TLSBundle string
+	// DisableTrace disable tracing, false by default
+	DisableTrace bool
+	// DisableMetric disable metrics, false by default
+	DisableMetric bool
+
+	logger    *xlog.Logger
+	clock     xtime.Clock
+	transport http.RoundTripper
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Timeout: 3 * time.Second,
+		Backoff: xbackoff.DefaultConfig(),
+		logger:  xlog.JupiterLogger.With(xlog.FieldMod("client.rest")),
+		clock:   xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.rest." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("rest client parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// WithTransport replaces http.DefaultTransport, e.g. for TLS
+func (config *Config) WithTransport(transport http.RoundTripper) *Config {
+	config.transport = transport
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Client {
+	if config.Addr == "" {
+		config.logger.Panic("rest client without addr", xlog.FieldName(config.Name))
+	}
+	var transport = config.transport
+	if transport == nil && config.TLSBundle != "" {
+		transport = xtls.StdConfig(config.TLSBundle).Build().Transport()
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	transport = xegress.NewTransport(transport)
+	if config.Bulkhead != "" {
+		transport = xbulkhead.NewTransport(xbulkhead.StdConfig(config.Bulkhead).Build(), transport)
+	}
+	if !config.DisableMetric {
+		transport = metric.NewTransport(config.Name, transport)
+	}
+	return newClient(config, &http.Client{Transport: transport})
+}
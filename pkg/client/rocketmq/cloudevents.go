@@ -0,0 +1,264 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CloudEvents 1.0 envelope of messages, in binary mode the attributes are
+// message properties prefixed with CE_ and the body is the data, in structured
+// mode the body is the JSON event with content-type application/cloudevents+json.
+
+// CloudEvents modes
+const (
+	ModeBinary     = "binary"
+	ModeStructured = "structured"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsJSON        = "application/cloudevents+json"
+	cloudEventsPrefix      = "CE_"
+	propertyContentType    = "content-type"
+	// extTraceParent is the W3C traceparent of Distributed Tracing extension
+	extTraceParent = "traceparent"
+	extTraceState  = "tracestate"
+)
+
+// ErrInvalidCloudEvent ...
+var ErrInvalidCloudEvent = errors.New("invalid cloud event")
+
+// CloudEvent ...
+type CloudEvent struct {
+	ID              string
+	Source          string
+	SpecVersion     string
+	Type            string
+	DataContentType string
+	DataSchema      string
+	Subject         string
+	Time            time.Time
+	// Extensions are string valued, names are lowercase alphanumeric
+	Extensions map[string]string
+	Data       []byte
+}
+
+// NewCloudEvent creates an event with random id and current time
+func NewCloudEvent(typ, source string, data []byte) *CloudEvent {
+	var id = make([]byte, 16)
+	_, _ = rand.Read(id)
+	return &CloudEvent{
+		ID:          hex.EncodeToString(id),
+		Source:      source,
+		SpecVersion: cloudEventsSpecVersion,
+		Type:        typ,
+		Time:        time.Now(),
+		Extensions:  make(map[string]string),
+		Data:        data,
+	}
+}
+
+// Validate checks required attributes
+func (e *CloudEvent) Validate() error {
+	switch {
+	case e.ID == "":
+		return errors.Wrap(ErrInvalidCloudEvent, "id required")
+	case e.Source == "":
+		return errors.Wrap(ErrInvalidCloudEvent, "source required")
+	case e.SpecVersion != cloudEventsSpecVersion:
+		return errors.Wrapf(ErrInvalidCloudEvent, "specversion %q", e.SpecVersion)
+	case e.Type == "":
+		return errors.Wrap(ErrInvalidCloudEvent, "type required")
+	}
+	for name := range e.Extensions {
+		if !validExtensionName(name) {
+			return errors.Wrapf(ErrInvalidCloudEvent, "extension name %q", name)
+		}
+	}
+	return nil
+}
+
+func validExtensionName(name string) bool {
+	if name == "" || len(name) > 20 {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// attributes returns the context attributes by name
+func (e *CloudEvent) attributes() map[string]string {
+	attrs := make(map[string]string, 8+len(e.Extensions))
+	for name, val := range e.Extensions {
+		attrs[name] = val
+	}
+	attrs["id"] = e.ID
+	attrs["source"] = e.Source
+	attrs["specversion"] = e.SpecVersion
+	attrs["type"] = e.Type
+	for name, val := range map[string]string{
+		"datacontenttype": e.DataContentType,
+		"dataschema":      e.DataSchema,
+		"subject":         e.Subject,
+	} {
+		if val != "" {
+			attrs[name] = val
+		}
+	}
+	if !e.Time.IsZero() {
+		attrs["time"] = e.Time.UTC().Format(time.RFC3339Nano)
+	}
+	return attrs
+}
+
+// setAttribute sets the attribute by name, unknown ones are extensions
+func (e *CloudEvent) setAttribute(name, val string) error {
+	switch name {
+	case "id":
+		e.ID = val
+	case "source":
+		e.Source = val
+	case "specversion":
+		e.SpecVersion = val
+	case "type":
+		e.Type = val
+	case "datacontenttype":
+		e.DataContentType = val
+	case "dataschema":
+		e.DataSchema = val
+	case "subject":
+		e.Subject = val
+	case "time":
+		t, err := time.Parse(time.RFC3339Nano, val)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidCloudEvent, "time %q", val)
+		}
+		e.Time = t
+	default:
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		e.Extensions[name] = val
+	}
+	return nil
+}
+
+// encodeCloudEvent returns the properties and body of the event in mode
+func encodeCloudEvent(e *CloudEvent, mode string) (map[string]string, []byte, error) {
+	if err := e.Validate(); err != nil {
+		return nil, nil, err
+	}
+	attrs := e.attributes()
+	if mode == ModeBinary {
+		props := make(map[string]string, len(attrs))
+		for name, val := range attrs {
+			props[cloudEventsPrefix+name] = val
+		}
+		return props, e.Data, nil
+	}
+
+	var doc = make(map[string]interface{}, len(attrs)+1)
+	for name, val := range attrs {
+		doc[name] = val
+	}
+	if len(e.Data) > 0 {
+		if isJSONContentType(e.DataContentType) && json.Valid(e.Data) {
+			doc["data"] = json.RawMessage(e.Data)
+		} else {
+			doc["data_base64"] = e.Data
+		}
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return map[string]string{propertyContentType: cloudEventsJSON}, body, nil
+}
+
+// decodeCloudEvent decodes the event from properties and body in either mode
+func decodeCloudEvent(props map[string]string, body []byte) (*CloudEvent, error) {
+	var e = &CloudEvent{}
+	if strings.HasPrefix(props[propertyContentType], cloudEventsJSON) {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, errors.Wrap(ErrInvalidCloudEvent, err.Error())
+		}
+		for name, raw := range doc {
+			switch name {
+			case "data":
+				e.Data = []byte(raw)
+				// string data of non-JSON content type is carried as JSON string
+				var s string
+				if !isJSONContentType(contentTypeOf(doc)) && json.Unmarshal(raw, &s) == nil {
+					e.Data = []byte(s)
+				}
+			case "data_base64":
+				if err := json.Unmarshal(raw, &e.Data); err != nil {
+					return nil, errors.Wrap(ErrInvalidCloudEvent, "data_base64")
+				}
+			default:
+				var val string
+				if err := json.Unmarshal(raw, &val); err != nil {
+					// non-string extensions are kept in JSON
+					val = string(raw)
+				}
+				if err := e.setAttribute(name, val); err != nil {
+					return nil, err
+				}
+			}
+		}
+	} else {
+		for key, val := range props {
+			if !strings.HasPrefix(key, cloudEventsPrefix) {
+				continue
+			}
+			if err := e.setAttribute(strings.ToLower(key[len(cloudEventsPrefix):]), val); err != nil {
+				return nil, err
+			}
+		}
+		e.Data = body
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func contentTypeOf(doc map[string]json.RawMessage) string {
+	var contentType string
+	_ = json.Unmarshal(doc["datacontenttype"], &contentType)
+	return contentType
+}
+
+// isJSONContentType reports whether data of the content type is JSON, which
+// is the default if absent
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEvent(contentType string, data string) *CloudEvent {
+	event := NewCloudEvent("com.douyu.order.created", "/order", []byte(data))
+	event.DataContentType = contentType
+	event.Subject = "123"
+	event.Time = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	event.Extensions[extTraceParent] = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	return event
+}
+
+func TestCloudEvent_Binary(t *testing.T) {
+	event := newTestEvent("application/json", `{"id":1}`)
+	props, body, err := encodeCloudEvent(event, ModeBinary)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"id":1}`, string(body))
+	assert.Equal(t, "1.0", props["CE_specversion"])
+	assert.Equal(t, "com.douyu.order.created", props["CE_type"])
+	assert.Equal(t, "2020-01-01T00:00:00Z", props["CE_time"])
+	assert.Equal(t, event.Extensions[extTraceParent], props["CE_traceparent"])
+
+	props["KEYS"] = "unrelated"
+	decoded, err := decodeCloudEvent(props, body)
+	assert.Nil(t, err)
+	assert.Equal(t, event, decoded)
+}
+
+func TestCloudEvent_Structured(t *testing.T) {
+	for _, event := range []*CloudEvent{
+		newTestEvent("application/json", `{"id":1}`),
+		newTestEvent("", `[1,2]`),
+		newTestEvent("application/octet-stream", "\x00\x01"),
+	} {
+		props, body, err := encodeCloudEvent(event, ModeStructured)
+		assert.Nil(t, err)
+		assert.Equal(t, cloudEventsJSON, props[propertyContentType])
+		assert.True(t, json.Valid(body))
+
+		decoded, err := decodeCloudEvent(props, body)
+		assert.Nil(t, err)
+		assert.Equal(t, event, decoded)
+	}
+
+	// text data of other producers
+	decoded, err := decodeCloudEvent(map[string]string{propertyContentType: cloudEventsJSON + "; charset=utf-8"},
+		[]byte(`{"specversion":"1.0","id":"1","source":"/s","type":"t","datacontenttype":"text/plain","data":"hello","count":3}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(decoded.Data))
+	assert.Equal(t, "3", decoded.Extensions["count"])
+}
+
+func TestCloudEvent_Invalid(t *testing.T) {
+	event := newTestEvent("", "")
+	event.Type = ""
+	_, _, err := encodeCloudEvent(event, ModeBinary)
+	assert.Equal(t, ErrInvalidCloudEvent, errors.Cause(err))
+
+	event = newTestEvent("", "")
+	event.Extensions["Bad-Name"] = "x"
+	_, _, err = encodeCloudEvent(event, ModeStructured)
+	assert.Equal(t, ErrInvalidCloudEvent, errors.Cause(err))
+
+	_, err = decodeCloudEvent(map[string]string{"CE_id": "1"}, nil)
+	assert.Equal(t, ErrInvalidCloudEvent, errors.Cause(err))
+}
@@ -16,6 +16,7 @@ package rocketmq
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/apache/rocketmq-client-go"
@@ -23,6 +24,19 @@ import (
 	"github.com/apache/rocketmq-client-go/primitive"
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+func init() {
+	xschema.RegisterConfig("rocketmq.consumer", "jupiter.rocketmq.*.consumer", "rocketmq push consumer", DefaultConsumerConfig)
+}
+
+const (
+	// MessageModelClustering consumes a message by one consumer of the group
+	MessageModelClustering = "clustering"
+	// MessageModelBroadcasting consumes a message by every consumer of the group
+	MessageModelBroadcasting = "broadcasting"
 )
 
 // ConsumerConfig consumer config
@@ -37,6 +51,11 @@ type ConsumerConfig struct {
 	Rate            float64       `json:"rate" toml:"rate"`
 	Capacity        int64         `json:"capacity" toml:"capacity"`
 	WaitMaxDuration time.Duration `json:"waitMaxDuration" toml:"waitMaxDuration"`
+	// DisableTrace disables spans of consumed messages
+	DisableTrace bool `json:"disableTrace" toml:"disableTrace"`
+	// MessageModel is "clustering" by default, messages are consumed by one
+	// consumer of the group, or "broadcasting", by every consumer of it
+	MessageModel string `json:"messageModel" toml:"messageModel"`
 
 	subscribers  map[string]func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error)
 	interceptors []primitive.Interceptor
@@ -67,8 +86,26 @@ func DefaultConsumerConfig() ConsumerConfig {
 	}
 }
 
-// WithSubscribe ...
+// WithSubscribe subscribes topic with f, each message is handled in a root
+// span following from the producing span unless DisableTrace, pass ctx of
+// f to client calls so that they're traced as children
 func (config *ConsumerConfig) WithSubscribe(topic string, f func(context.Context, *primitive.MessageExt) error) *ConsumerConfig {
+	if config.DisableTrace {
+		return config.subscribe(topic, f)
+	}
+	return config.subscribe(topic, func(ctx context.Context, msg *primitive.MessageExt) (err error) {
+		span, ctx := startConsumeSpan(ctx, msg)
+		defer func() {
+			if err != nil {
+				ext.Error.Set(span, true)
+			}
+			span.Finish()
+		}()
+		return f(ctx, msg)
+	})
+}
+
+func (config *ConsumerConfig) subscribe(topic string, f func(context.Context, *primitive.MessageExt) error) *ConsumerConfig {
 	if config.subscribers == nil {
 		config.subscribers = make(map[string]func(context.Context, ...*primitive.MessageExt) (consumer.ConsumeResult, error))
 	}
@@ -103,12 +140,21 @@ func (config *ConsumerConfig) WithInterceptor(fs ...primitive.Interceptor) *Cons
 
 // Build ...
 func (config *ConsumerConfig) Build() (rocketmq.PushConsumer, error) {
-	// 初始化 PushConsumer
-	client, err := rocketmq.NewPushConsumer(
+	var opts = []consumer.Option{
 		consumer.WithGroupName(config.Group),
 		consumer.WithNameServer(config.Addr),
 		consumer.WithInterceptor(config.interceptors...),
-	)
+	}
+	switch config.MessageModel {
+	case "", MessageModelClustering:
+	case MessageModelBroadcasting:
+		opts = append(opts, consumer.WithConsumerModel(consumer.BroadCasting))
+	default:
+		return nil, fmt.Errorf("unknown message model %q", config.MessageModel)
+	}
+
+	// 初始化 PushConsumer
+	client, err := rocketmq.NewPushConsumer(opts...)
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,119 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/apache/rocketmq-client-go/primitive"
+	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/opentracing/opentracing-go"
+)
+
+// NewCloudEventMessage encodes event as a message of topic in mode, trace
+// context of ctx is propagated as traceparent unless the event carries one
+func NewCloudEventMessage(ctx context.Context, topic string, event *CloudEvent, mode string) (*primitive.Message, error) {
+	if _, ok := event.Extensions[extTraceParent]; !ok {
+		if tp := traceParent(ctx); tp != "" {
+			if event.Extensions == nil {
+				event.Extensions = make(map[string]string)
+			}
+			event.Extensions[extTraceParent] = tp
+		}
+	}
+	props, body, err := encodeCloudEvent(event, mode)
+	if err != nil {
+		return nil, err
+	}
+	msg := primitive.NewMessage(topic, body)
+	for key, val := range props {
+		msg.WithProperty(key, val)
+	}
+	return msg, nil
+}
+
+// CloudEventFromMessage decodes the event of message in either mode
+func CloudEventFromMessage(msg *primitive.MessageExt) (*CloudEvent, error) {
+	return decodeCloudEvent(msg.GetProperties(), msg.Body)
+}
+
+// StartSpanFromCloudEvent starts a span child of the traceparent of event
+func StartSpanFromCloudEvent(ctx context.Context, event *CloudEvent, op string) (opentracing.Span, context.Context) {
+	return trace.StartSpanFromContext(ctx, op,
+		spanContextOption(event.Extensions[extTraceParent]),
+		trace.TagComponent("rocketmq"),
+		trace.TagSpanKind("consumer"),
+		trace.CustomTag("cloudevents.type", event.Type),
+		trace.CustomTag("cloudevents.source", event.Source),
+	)
+}
+
+// WithCloudEventSubscribe subscribes topic with handler of CloudEvents, each
+// event is handled in a span following its traceparent
+func (config *ConsumerConfig) WithCloudEventSubscribe(topic string, f func(context.Context, *CloudEvent) error) *ConsumerConfig {
+	// events carry their trace context as traceparent instead of properties
+	return config.subscribe(topic, func(ctx context.Context, msg *primitive.MessageExt) error {
+		event, err := CloudEventFromMessage(msg)
+		if err != nil {
+			// retrying can't fix malformed events
+			xlog.Error("decode cloud event", xlog.FieldErr(err), xlog.String("topic", msg.Topic), xlog.String("msgId", msg.MsgId))
+			return nil
+		}
+		span, ctx := StartSpanFromCloudEvent(ctx, event, topic)
+		defer span.Finish()
+		return f(ctx, event)
+	})
+}
+
+// traceParent converts the jaeger span context of ctx, "traceid:spanid:parentid:flags",
+// to W3C traceparent "00-traceid-spanid-flags"
+func traceParent(ctx context.Context) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	stringer, ok := span.Context().(fmt.Stringer)
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(stringer.String(), ":")
+	if len(parts) != 4 || len(parts[0]) > 32 || len(parts[1]) > 16 {
+		return ""
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", zeroPad(parts[0], 32), zeroPad(parts[1], 16), flags&1)
+}
+
+// spanContextOption converts W3C traceparent back to jaeger span context
+func spanContextOption(tp string) opentracing.StartSpanOption {
+	parts := strings.Split(tp, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return trace.NullStartSpanOption{}
+	}
+	return trace.HeaderExtractor(map[string][]string{
+		"uber-trace-id": {parts[1] + ":" + parts[2] + ":0:" + parts[3]},
+	})
+}
+
+func zeroPad(s string, n int) string {
+	return strings.Repeat("0", n-len(s)) + s
+}
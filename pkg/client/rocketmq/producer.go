@@ -22,8 +22,13 @@ import (
 	"github.com/apache/rocketmq-client-go/producer"
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 )
 
+func init() {
+	xschema.RegisterConfig("rocketmq.producer", "jupiter.rocketmq.*.producer", "rocketmq producer", DefaultProducerConfig)
+}
+
 // ProducerConfig producer config
 type ProducerConfig struct {
 	Addr        []string      `json:"addr" toml:"addr"`
@@ -32,6 +37,9 @@ type ProducerConfig struct {
 	Retry       int           `json:"retry" toml:"retry"`
 	DialTimeout time.Duration `json:"dialTimeout" toml:"dialTimeout"`
 	RwTimeout   time.Duration `json:"rwTimeout" toml:"rwTimeout"`
+	// DisableTrace disables spans of sent messages and propagation of trace
+	// context in message properties
+	DisableTrace bool `json:"disableTrace" toml:"disableTrace"`
 
 	interceptors []primitive.Interceptor
 }
@@ -67,7 +75,7 @@ func (config ProducerConfig) Build() (rocketmq.Producer, error) {
 	client, err := rocketmq.NewProducer(
 		producer.WithNameServer(config.Addr),
 		producer.WithRetry(config.Retry),
-		producer.WithInterceptor(),
+		producer.WithInterceptor(config.buildInterceptors()...),
 	)
 	if err != nil {
 		return nil, err
@@ -80,6 +88,14 @@ func (config ProducerConfig) Build() (rocketmq.Producer, error) {
 	return client, err
 }
 
+func (config ProducerConfig) buildInterceptors() []primitive.Interceptor {
+	var interceptors = make([]primitive.Interceptor, 0, len(config.interceptors)+1)
+	if !config.DisableTrace {
+		interceptors = append(interceptors, producerTraceInterceptor)
+	}
+	return append(interceptors, config.interceptors...)
+}
+
 // WithInterceptor ...
 func (config *ProducerConfig) WithInterceptor(fs ...primitive.Interceptor) *ProducerConfig {
 	if config.interceptors == nil {
@@ -0,0 +1,373 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apache/rocketmq-client-go"
+	"github.com/apache/rocketmq-client-go/consumer"
+	"github.com/apache/rocketmq-client-go/primitive"
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("rocketmq.replay", "jupiter.rocketmq.*.replay", "rocketmq message replay of a time or offset range", DefaultReplayConfig)
+}
+
+// ReplayConfig replays messages of a topic within a time or offset range
+// into a handler or another topic, e.g. backfills after consumer bugs
+type ReplayConfig struct {
+	Addr          []string `json:"addr" toml:"addr"`
+	Topic         string   `json:"topic" toml:"topic"`
+	SubExpression string   `json:"subExpression" toml:"subExpression"`
+	// Group is the consumer group of replay, which is derived from the range
+	// by default, so that instances running the same replay share queues of
+	// the topic instead of each replaying all of them. A new group consumes
+	// from the beginning of the topic, a group is resumed from its offsets.
+	Group string `json:"group" toml:"group"`
+	// Start and End bound store time of messages in RFC3339, e.g.
+	// "2020-09-01T10:00:00+08:00", End is exclusive
+	Start string `json:"start" toml:"start"`
+	End   string `json:"end" toml:"end"`
+	// StartOffset and EndOffset bound offsets of messages in each queue,
+	// EndOffset is exclusive and ignored if it's not positive
+	StartOffset int64 `json:"startOffset" toml:"startOffset"`
+	EndOffset   int64 `json:"endOffset" toml:"endOffset"`
+	// Rate limits messages replayed per second, unlimited if not positive
+	Rate float64 `json:"rate" toml:"rate"`
+	// IdleTimeout ends replay once no message in or before the range is
+	// received for it
+	IdleTimeout time.Duration `json:"idleTimeout" toml:"idleTimeout"`
+	// TargetTopic is the topic messages are replayed into if no handler is set
+	TargetTopic string `json:"targetTopic" toml:"targetTopic"`
+	// Backoff of retrying messages failed to be replayed, which are counted
+	// as failed and logged once retries run out. They're never retried by
+	// the broker, whose retries have new timestamps and offsets.
+	Backoff xbackoff.Config `json:"backoff" toml:"backoff"`
+
+	handler func(context.Context, *primitive.MessageExt) error
+	clock   xtime.Clock
+}
+
+// StdReplayConfig ...
+func StdReplayConfig(name string) ReplayConfig {
+	return RawReplayConfig("jupiter.rocketmq." + name + ".replay")
+}
+
+// RawReplayConfig ...
+func RawReplayConfig(key string) ReplayConfig {
+	var config = DefaultReplayConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Panic("unmarshal config", xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultReplayConfig ...
+func DefaultReplayConfig() ReplayConfig {
+	return ReplayConfig{
+		IdleTimeout: time.Second * 30,
+		Backoff:     xbackoff.DefaultConfig(),
+		clock:       xtime.SystemClock,
+	}
+}
+
+// WithHandler replays messages into fn, messages fn fails on are retried
+// with Backoff
+func (config *ReplayConfig) WithHandler(fn func(context.Context, *primitive.MessageExt) error) *ReplayConfig {
+	config.handler = fn
+	return config
+}
+
+// Build ...
+func (config ReplayConfig) Build() (*Replayer, error) {
+	if config.Topic == "" {
+		return nil, errors.New("replay: no topic")
+	}
+	window, err := newReplayWindow(config.Start, config.End, config.StartOffset, config.EndOffset)
+	if err != nil {
+		return nil, err
+	}
+	if config.handler == nil && config.TargetTopic == "" {
+		return nil, errors.New("replay: neither handler nor target topic is set")
+	}
+	if config.clock == nil {
+		config.clock = xtime.SystemClock
+	}
+	if config.Group == "" {
+		config.Group = config.defaultGroup()
+	}
+	return &Replayer{
+		config: config,
+		window: window,
+		pacer:  newPacer(config.Rate, config.clock),
+		logger: xlog.JupiterLogger.With(xlog.FieldMod("rocketmq.replay"), xlog.String("topic", config.Topic), xlog.String("group", config.Group)),
+	}, nil
+}
+
+// defaultGroup is the same for the same replay
+func (config ReplayConfig) defaultGroup() string {
+	hash := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s|%s|%s|%d|%d|%s", config.SubExpression,
+		config.Start, config.End, config.StartOffset, config.EndOffset, config.TargetTopic)))
+	return fmt.Sprintf("%s_replay_%08x", config.Topic, hash)
+}
+
+// ReplayStats counts messages of a replay
+type ReplayStats struct {
+	Replayed int64 `json:"replayed"`
+	Skipped  int64 `json:"skipped"`
+	Failed   int64 `json:"failed"`
+}
+
+// Replayer replays messages, it's a job.Runner so that it can be run in job
+// mode, e.g. --job=replay
+type Replayer struct {
+	config ReplayConfig
+	window *replayWindow
+	pacer  *pacer
+	logger *xlog.Logger
+
+	stats  ReplayStats
+	active int64 // unix nano of the last message in or before the range
+}
+
+// Run replays messages until all of the range are replayed
+func (r *Replayer) Run() {
+	stats, err := r.Replay(context.Background())
+	if err != nil {
+		r.logger.Error("replay", xlog.FieldErr(err), xlog.Any("stats", stats))
+		return
+	}
+	r.logger.Info("replay done", xlog.Any("stats", stats))
+}
+
+// Replay consumes the topic from the beginning, messages before the range
+// are skipped without limiting since the client can't start consuming at a
+// timestamp. Replay returns once no message in or before the range is
+// received for IdleTimeout, or ctx is done.
+func (r *Replayer) Replay(ctx context.Context) (ReplayStats, error) {
+	handler := r.config.handler
+	if handler == nil {
+		producer, err := ProducerConfig{Addr: r.config.Addr, Group: r.config.Group, Retry: 3}.Build()
+		if err != nil {
+			return r.Stats(), err
+		}
+		defer producer.Shutdown()
+		handler = forwardTo(producer, r.config.TargetTopic)
+	}
+
+	client, err := rocketmq.NewPushConsumer(
+		consumer.WithGroupName(r.config.Group),
+		consumer.WithNameServer(r.config.Addr),
+		consumer.WithConsumeFromWhere(consumer.ConsumeFromFirstOffset),
+	)
+	if err != nil {
+		return r.Stats(), err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	selector := consumer.MessageSelector{}
+	if r.config.SubExpression != "" {
+		selector = consumer.MessageSelector{Type: consumer.TAG, Expression: r.config.SubExpression}
+	}
+	err = client.Subscribe(r.config.Topic, selector, func(_ context.Context, msgs ...*primitive.MessageExt) (consumer.ConsumeResult, error) {
+		for _, msg := range msgs {
+			r.consume(ctx, handler, msg)
+		}
+		return consumer.ConsumeSuccess, nil
+	})
+	if err != nil {
+		return r.Stats(), err
+	}
+
+	r.touch()
+	if err := client.Start(); err != nil {
+		return r.Stats(), err
+	}
+	defer client.Shutdown()
+	r.logger.Info("replay started", xlog.String("start", r.config.Start), xlog.String("end", r.config.End))
+
+	ticker := r.config.clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if r.idle() >= r.config.IdleTimeout {
+				return r.Stats(), nil
+			}
+		case <-ctx.Done():
+			return r.Stats(), ctx.Err()
+		}
+	}
+}
+
+// Stats returns counts of messages so far
+func (r *Replayer) Stats() ReplayStats {
+	return ReplayStats{
+		Replayed: atomic.LoadInt64(&r.stats.Replayed),
+		Skipped:  atomic.LoadInt64(&r.stats.Skipped),
+		Failed:   atomic.LoadInt64(&r.stats.Failed),
+	}
+}
+
+// consume replays msg in the range, it's retried with Backoff and counted as
+// failed once retries run out or ctx is done
+func (r *Replayer) consume(ctx context.Context, handler func(context.Context, *primitive.MessageExt) error, msg *primitive.MessageExt) {
+	switch r.window.locate(msg.QueueOffset, time.Unix(0, msg.StoreTimestamp*int64(time.Millisecond))) {
+	case replayBefore:
+		r.touch()
+		atomic.AddInt64(&r.stats.Skipped, 1)
+		return
+	case replayAfter:
+		atomic.AddInt64(&r.stats.Skipped, 1)
+		return
+	}
+	r.touch()
+	err := r.pacer.wait(ctx)
+	if err == nil {
+		err = xbackoff.Retry(ctx, r.config.Backoff, r.config.clock, func() error {
+			// retries keep the replay from being idle
+			r.touch()
+			return handler(ctx, msg)
+		})
+	}
+	if err != nil {
+		atomic.AddInt64(&r.stats.Failed, 1)
+		r.logger.Error("replay message", xlog.FieldErr(err), xlog.String("msgId", msg.MsgId),
+			xlog.Int64("queueOffset", msg.QueueOffset), xlog.Int64("storeTimestamp", msg.StoreTimestamp))
+		return
+	}
+	atomic.AddInt64(&r.stats.Replayed, 1)
+}
+
+func (r *Replayer) touch() {
+	atomic.StoreInt64(&r.active, r.config.clock.Now().UnixNano())
+}
+
+func (r *Replayer) idle() time.Duration {
+	return r.config.clock.Since(time.Unix(0, atomic.LoadInt64(&r.active)))
+}
+
+// forwardTo returns a handler sending messages to topic with their
+// properties, e.g. keys and tags
+func forwardTo(producer rocketmq.Producer, topic string) func(context.Context, *primitive.MessageExt) error {
+	return func(ctx context.Context, msg *primitive.MessageExt) error {
+		forwarded := primitive.NewMessage(topic, msg.Body)
+		for key, val := range msg.GetProperties() {
+			forwarded.WithProperty(key, val)
+		}
+		_, err := producer.SendSync(ctx, forwarded)
+		return err
+	}
+}
+
+const (
+	replayIn = iota
+	replayBefore
+	replayAfter
+)
+
+// replayWindow locates messages relative to the time and offset range
+type replayWindow struct {
+	start, end             time.Time
+	startOffset, endOffset int64
+}
+
+func newReplayWindow(start, end string, startOffset, endOffset int64) (*replayWindow, error) {
+	var window = &replayWindow{startOffset: startOffset, endOffset: endOffset}
+	var err error
+	if start != "" {
+		if window.start, err = time.Parse(time.RFC3339, start); err != nil {
+			return nil, fmt.Errorf("replay: invalid start: %w", err)
+		}
+	}
+	if end != "" {
+		if window.end, err = time.Parse(time.RFC3339, end); err != nil {
+			return nil, fmt.Errorf("replay: invalid end: %w", err)
+		}
+		if !window.start.IsZero() && !window.end.After(window.start) {
+			return nil, errors.New("replay: end isn't after start")
+		}
+	}
+	if endOffset > 0 && endOffset <= startOffset {
+		return nil, errors.New("replay: endOffset isn't after startOffset")
+	}
+	return window, nil
+}
+
+// locate returns whether the message at offset of its queue stored at
+// stored is before, in or after the range
+func (w *replayWindow) locate(offset int64, stored time.Time) int {
+	if offset < w.startOffset || (!w.start.IsZero() && stored.Before(w.start)) {
+		return replayBefore
+	}
+	if (w.endOffset > 0 && offset >= w.endOffset) || (!w.end.IsZero() && !stored.Before(w.end)) {
+		return replayAfter
+	}
+	return replayIn
+}
+
+// pacer spaces calls of wait evenly at rate per second
+type pacer struct {
+	interval time.Duration
+	clock    xtime.Clock
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newPacer(rate float64, clock xtime.Clock) *pacer {
+	var p = &pacer{clock: clock}
+	if rate > 0 {
+		p.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return p
+}
+
+// wait blocks until the next slot or ctx is done
+func (p *pacer) wait(ctx context.Context) error {
+	if p.interval <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	now := p.clock.Now()
+	if p.next.Before(now) {
+		p.next = now
+	}
+	delay := p.next.Sub(now)
+	p.next = p.next.Add(p.interval)
+	p.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-p.clock.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
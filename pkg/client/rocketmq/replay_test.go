@@ -0,0 +1,115 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/apache/rocketmq-client-go/primitive"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayWindow(t *testing.T) {
+	window, err := newReplayWindow("2020-09-01T10:00:00+08:00", "2020-09-01T11:00:00+08:00", 10, 0)
+	assert.Nil(t, err)
+	start := time.Date(2020, 9, 1, 2, 0, 0, 0, time.UTC)
+	assert.Equal(t, replayBefore, window.locate(20, start.Add(-time.Second)))
+	assert.Equal(t, replayBefore, window.locate(9, start))
+	assert.Equal(t, replayIn, window.locate(10, start))
+	assert.Equal(t, replayIn, window.locate(1000, start.Add(time.Hour-time.Second)))
+	assert.Equal(t, replayAfter, window.locate(1000, start.Add(time.Hour)))
+
+	window, err = newReplayWindow("", "", 10, 20)
+	assert.Nil(t, err)
+	assert.Equal(t, replayIn, window.locate(19, start))
+	assert.Equal(t, replayAfter, window.locate(20, start))
+
+	for _, invalid := range [][2]string{{"yesterday", ""}, {"", "today"}, {"2020-09-01T11:00:00Z", "2020-09-01T10:00:00Z"}} {
+		_, err = newReplayWindow(invalid[0], invalid[1], 0, 0)
+		assert.NotNil(t, err, invalid)
+	}
+	_, err = newReplayWindow("", "", 20, 10)
+	assert.NotNil(t, err)
+}
+
+func TestPacer(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	p := newPacer(10, clock)
+	assert.Nil(t, p.wait(context.Background()))
+
+	done := make(chan error)
+	go func() { done <- p.wait(context.Background()) }()
+	select {
+	case <-done:
+		t.Fatal("wait returned before the next slot")
+	case <-time.After(time.Millisecond * 50):
+	}
+	clock.Advance(time.Millisecond * 100)
+	assert.Nil(t, <-done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, p.wait(ctx))
+
+	assert.Nil(t, newPacer(0, clock).wait(ctx))
+}
+
+func TestReplayer_consume(t *testing.T) {
+	config := DefaultReplayConfig()
+	config.Topic = "orders"
+	config.Start = "2020-09-01T10:00:00Z"
+	config.End = "2020-09-01T11:00:00Z"
+	config.Backoff = xbackoff.Config{MaxRetries: 2}
+	config.clock = xtime.NewMockClock(time.Date(2020, 9, 2, 0, 0, 0, 0, time.UTC))
+	var replayed []string
+	var failures int
+	replayer, err := config.WithHandler(func(_ context.Context, msg *primitive.MessageExt) error {
+		if msg.MsgId == "x" {
+			failures++
+			return errors.New("unavailable")
+		}
+		replayed = append(replayed, msg.MsgId)
+		return nil
+	}).Build()
+	assert.Nil(t, err)
+	// instances running the same replay share the group
+	other, _ := config.Build()
+	assert.Equal(t, other.config.Group, replayer.config.Group)
+	config.End = "2020-09-01T12:00:00Z"
+	other, _ = config.Build()
+	assert.NotEqual(t, other.config.Group, replayer.config.Group)
+
+	// retries without delay
+	replayer.config.clock = xtime.SystemClock
+	for _, id := range []string{"a", "b", "c", "x"} {
+		stored := map[string]string{"a": "2020-09-01T09:59:59Z", "b": "2020-09-01T10:30:00Z", "c": "2020-09-01T11:00:00Z", "x": "2020-09-01T10:40:00Z"}[id]
+		ts, _ := time.Parse(time.RFC3339, stored)
+		msg := &primitive.MessageExt{MsgId: id, StoreTimestamp: ts.UnixNano() / int64(time.Millisecond)}
+		replayer.consume(context.Background(), replayer.config.handler, msg)
+	}
+	assert.Equal(t, []string{"b"}, replayed)
+	// retried in place instead of by the broker
+	assert.Equal(t, 3, failures)
+	assert.Equal(t, ReplayStats{Replayed: 1, Skipped: 2, Failed: 1}, replayer.Stats())
+
+	config.handler = nil
+	_, err = config.Build()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+
+	"github.com/apache/rocketmq-client-go/primitive"
+	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// producerTraceInterceptor sends messages in spans, whose contexts are
+// injected into message properties, so that consumers can follow them
+func producerTraceInterceptor(ctx context.Context, req, reply interface{}, next primitive.Invoker) error {
+	msg, ok := req.(*primitive.Message)
+	if !ok {
+		return next(ctx, req, reply)
+	}
+	span, ctx := trace.StartSpanFromContext(ctx, "rocketmq produce "+msg.Topic,
+		trace.TagComponent("rocketmq"),
+		trace.TagSpanKind("producer"),
+		trace.CustomTag("message_bus.destination", msg.Topic),
+	)
+	defer span.Finish()
+	trace.MessageInjector(ctx, msg.WithProperty)
+	err := next(ctx, req, reply)
+	if err != nil {
+		ext.Error.Set(span, true)
+	}
+	return err
+}
+
+// startConsumeSpan starts the root span of consuming msg, which follows from
+// the producing span in message properties
+func startConsumeSpan(ctx context.Context, msg *primitive.MessageExt) (opentracing.Span, context.Context) {
+	return trace.StartRootSpan(ctx, "rocketmq consume "+msg.Topic,
+		trace.MessageExtractor(msg.GetProperties()),
+		trace.TagComponent("rocketmq"),
+		trace.TagSpanKind("consumer"),
+		trace.CustomTag("message_bus.destination", msg.Topic),
+		trace.CustomTag("message_id", msg.MsgId),
+	)
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rocketmq
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apache/rocketmq-client-go/primitive"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracePropagation(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	parent, ctx := opentracing.StartSpanFromContext(context.Background(), "handler")
+	// the consumer receives the message sent by the producer
+	msg := &primitive.MessageExt{MsgId: "m1"}
+	msg.Topic, msg.Body = "orders", []byte("{}")
+	err := producerTraceInterceptor(ctx, &msg.Message, &primitive.SendResult{}, func(ctx context.Context, req, reply interface{}) error {
+		return errors.New("broker unavailable")
+	})
+	assert.EqualError(t, err, "broker unavailable")
+	parent.Finish()
+
+	spans := tracer.FinishedSpans()
+	assert.Len(t, spans, 2)
+	produced := spans[0]
+	assert.Equal(t, "rocketmq produce orders", produced.OperationName)
+	assert.Equal(t, parent.(*mocktracer.MockSpan).SpanContext.SpanID, produced.ParentID)
+	assert.Equal(t, true, produced.Tag("error"))
+
+	var config = DefaultConsumerConfig()
+	config.WithSubscribe("orders", func(ctx context.Context, msg *primitive.MessageExt) error {
+		span, _ := opentracing.StartSpanFromContext(ctx, "call")
+		span.Finish()
+		return nil
+	})
+	result, err := config.subscribers["orders"](context.Background(), msg)
+	assert.Nil(t, err)
+	assert.Equal(t, "success", consumeResultStr(result))
+
+	spans = tracer.FinishedSpans()
+	assert.Len(t, spans, 4)
+	call, consumed := spans[2], spans[3]
+	assert.Equal(t, "rocketmq consume orders", consumed.OperationName)
+	assert.Equal(t, produced.SpanContext.TraceID, consumed.SpanContext.TraceID)
+	assert.Equal(t, produced.SpanContext.SpanID, consumed.ParentID)
+	assert.Equal(t, "m1", consumed.Tag("message_id"))
+	assert.Equal(t, consumed.SpanContext.SpanID, call.ParentID)
+}
@@ -0,0 +1,272 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schemaregistry is a client of Confluent-compatible schema registry
+// for MQ payloads. Producers register or validate schemas of subjects and
+// prefix payloads with schema ids, consumers resolve schemas by the ids.
+// Schemas are immutable once registered, so they are cached forever.
+package schemaregistry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// Schema types
+const (
+	TypeAvro     = "AVRO"
+	TypeJSON     = "JSON"
+	TypeProtobuf = "PROTOBUF"
+)
+
+var (
+	// ErrIncompatible is returned if a schema breaks compatibility of its subject
+	ErrIncompatible = errors.New("schema incompatible")
+	// ErrNotRegistered is returned if the schema is not registered and AutoRegister is off
+	ErrNotRegistered = errors.New("schema not registered")
+)
+
+// Error is an error response of the registry
+type Error struct {
+	Status  int    `json:"-"`
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+// Error ...
+func (e *Error) Error() string {
+	return fmt.Sprintf("schema registry: %d %s", e.Code, e.Message)
+}
+
+// error codes of the registry
+const (
+	codeSubjectNotFound = 40401
+	codeSchemaNotFound  = 40403
+)
+
+func isNotFound(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && (e.Code == codeSubjectNotFound || e.Code == codeSchemaNotFound)
+}
+
+// Schema ...
+type Schema struct {
+	ID      int    `json:"id,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Version int    `json:"version,omitempty"`
+	// Type is AVRO if empty
+	Type   string `json:"schemaType,omitempty"`
+	Schema string `json:"schema"`
+}
+
+// Client ...
+type Client struct {
+	config *Config
+	group  singleflight.Group
+
+	mu sync.RWMutex
+	// byID caches schemas by id
+	byID map[int]*Schema
+	// ids caches ids by subject and schema
+	ids map[string]int
+}
+
+func newClient(config *Config) *Client {
+	return &Client{
+		config: config,
+		byID:   make(map[int]*Schema),
+		ids:    make(map[string]int),
+	}
+}
+
+// SchemaByID resolves the schema by id, used by consumers
+func (c *Client) SchemaByID(ctx context.Context, id int) (*Schema, error) {
+	c.mu.RLock()
+	schema, ok := c.byID[id]
+	c.mu.RUnlock()
+	if ok {
+		return schema, nil
+	}
+
+	val, err, _ := c.group.Do(fmt.Sprintf("id:%d", id), func() (interface{}, error) {
+		var schema Schema
+		if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, &schema); err != nil {
+			return nil, err
+		}
+		schema.ID = id
+		c.mu.Lock()
+		c.byID[id] = &schema
+		c.mu.Unlock()
+		return &schema, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(*Schema), nil
+}
+
+// Latest returns the latest version of subject
+func (c *Client) Latest(ctx context.Context, subject string) (*Schema, error) {
+	var schema Schema
+	if err := c.do(ctx, http.MethodGet, "/subjects/"+url.PathEscape(subject)+"/versions/latest", nil, &schema); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// Register registers schema under subject and returns its id, registering an
+// existing schema returns its id as well. Incompatible schemas are rejected
+// with ErrIncompatible if CheckCompatibility is on.
+func (c *Client) Register(ctx context.Context, subject string, schema Schema) (int, error) {
+	return c.resolveID(ctx, subject, schema, func() (int, error) {
+		if c.config.CheckCompatibility {
+			ok, err := c.Compatible(ctx, subject, schema)
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				return 0, errors.Wrapf(ErrIncompatible, "subject %s", subject)
+			}
+		}
+		var resp struct {
+			ID int `json:"id"`
+		}
+		if err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", request(schema), &resp); err != nil {
+			return 0, err
+		}
+		c.config.logger.Info("register schema", xlog.String("subject", subject), xlog.Int("id", resp.ID))
+		return resp.ID, nil
+	})
+}
+
+// Lookup returns the id of schema registered under subject, ErrNotRegistered if not
+func (c *Client) Lookup(ctx context.Context, subject string, schema Schema) (int, error) {
+	return c.resolveID(ctx, subject, schema, func() (int, error) {
+		var resp Schema
+		err := c.do(ctx, http.MethodPost, "/subjects/"+url.PathEscape(subject), request(schema), &resp)
+		if isNotFound(err) {
+			return 0, errors.Wrapf(ErrNotRegistered, "subject %s", subject)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return resp.ID, nil
+	})
+}
+
+// Compatible checks schema against the latest version of subject,
+// schemas of new subjects are always compatible
+func (c *Client) Compatible(ctx context.Context, subject string, schema Schema) (bool, error) {
+	var resp struct {
+		IsCompatible bool `json:"is_compatible"`
+	}
+	err := c.do(ctx, http.MethodPost, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest", request(schema), &resp)
+	if isNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return resp.IsCompatible, nil
+}
+
+// resolveID returns the cached id of schema, or resolves it with fn
+func (c *Client) resolveID(ctx context.Context, subject string, schema Schema, fn func() (int, error)) (int, error) {
+	key := subject + "\x00" + schema.Type + "\x00" + schema.Schema
+	c.mu.RLock()
+	id, ok := c.ids[key]
+	c.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	val, err, _ := c.group.Do("subject:"+key, func() (interface{}, error) {
+		id, err := fn()
+		if err != nil {
+			return 0, err
+		}
+		schema.ID, schema.Subject = id, subject
+		c.mu.Lock()
+		c.ids[key] = id
+		if _, ok := c.byID[id]; !ok {
+			c.byID[id] = &schema
+		}
+		c.mu.Unlock()
+		return id, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return val.(int), nil
+}
+
+// request is the body of registering, looking up and checking schemas
+func request(schema Schema) interface{} {
+	return Schema{Type: schema.Type, Schema: schema.Schema}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.config.Addr, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", contentType)
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+
+	resp, err := c.config.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s", method, path)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "%s %s", method, path)
+	}
+	if resp.StatusCode >= 300 {
+		var e = &Error{Status: resp.StatusCode}
+		if json.Unmarshal(data, e) != nil || e.Code == 0 {
+			e.Code, e.Message = resp.StatusCode, strings.TrimSpace(string(data))
+		}
+		return e
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry serves a subset of the registry API, schemas containing
+// "breaking" are incompatible with existing versions
+type fakeRegistry struct {
+	mu       sync.Mutex
+	schemas  []string
+	subjects map[string][]int
+	requests int32
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&f.requests, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var req Schema
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	notFound := func(code int) {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(Error{Code: code, Message: "not found"})
+	}
+	find := func(subject, schema string) int {
+		for _, id := range f.subjects[subject] {
+			if f.schemas[id-1] == schema {
+				return id
+			}
+		}
+		return 0
+	}
+
+	switch {
+	case parts[0] == "schemas":
+		id, _ := strconv.Atoi(parts[2])
+		if id < 1 || id > len(f.schemas) {
+			notFound(codeSchemaNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Schema{Schema: f.schemas[id-1]})
+	case parts[0] == "compatibility":
+		if len(f.subjects[parts[2]]) == 0 {
+			notFound(codeSubjectNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]bool{"is_compatible": !strings.Contains(req.Schema, "breaking")})
+	case len(parts) == 3 && r.Method == http.MethodPost:
+		id := find(parts[1], req.Schema)
+		if id == 0 {
+			f.schemas = append(f.schemas, req.Schema)
+			id = len(f.schemas)
+			f.subjects[parts[1]] = append(f.subjects[parts[1]], id)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]int{"id": id})
+	case len(parts) == 2:
+		id := find(parts[1], req.Schema)
+		if id == 0 {
+			notFound(codeSchemaNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Schema{ID: id, Subject: parts[1], Schema: req.Schema})
+	case len(parts) == 4:
+		ids := f.subjects[parts[1]]
+		if len(ids) == 0 {
+			notFound(codeSubjectNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Schema{ID: ids[len(ids)-1], Subject: parts[1], Version: len(ids), Schema: f.schemas[ids[len(ids)-1]-1]})
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func newTestClient(t *testing.T) (*Client, *fakeRegistry) {
+	fake := &fakeRegistry{subjects: make(map[string][]int)}
+	ts := httptest.NewServer(fake)
+	t.Cleanup(ts.Close)
+	config := DefaultConfig()
+	config.Addr = ts.URL
+	return config.Build(), fake
+}
+
+func TestClient_RegisterAndResolve(t *testing.T) {
+	client, fake := newTestClient(t)
+	ctx := context.Background()
+	v1 := Schema{Schema: `{"type":"record","name":"user","fields":[]}`}
+
+	id, err := client.Register(ctx, "user-value", v1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, id)
+	requests := atomic.LoadInt32(&fake.requests)
+	id, err = client.Register(ctx, "user-value", v1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, id)
+	assert.Equal(t, requests, atomic.LoadInt32(&fake.requests), "cached")
+
+	_, err = client.Register(ctx, "user-value", Schema{Schema: `{"breaking":true}`})
+	assert.Equal(t, ErrIncompatible, errors.Cause(err))
+
+	latest, err := client.Latest(ctx, "user-value")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, latest.Version)
+
+	// resolved by another client, as consumers do
+	config := DefaultConfig()
+	config.Addr = client.config.Addr
+	consumer := config.Build()
+	schema, err := consumer.SchemaByID(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, v1.Schema, schema.Schema)
+
+	_, err = consumer.SchemaByID(ctx, 42)
+	assert.True(t, isNotFound(err))
+}
+
+func TestSerde(t *testing.T) {
+	client, _ := newTestClient(t)
+	ctx := context.Background()
+	schema := Schema{Type: TypeJSON, Schema: `{"type":"object"}`}
+
+	client.config.AutoRegister = false
+	_, err := client.NewSerializer("order-value", schema).Serialize(ctx, []byte(`{}`))
+	assert.Equal(t, ErrNotRegistered, errors.Cause(err))
+
+	client.config.AutoRegister = true
+	data, err := client.NewSerializer("order-value", schema).Serialize(ctx, []byte(`{"id":1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 1}, data[:5])
+
+	resolved, payload, err := client.Deserialize(ctx, data)
+	assert.Nil(t, err)
+	assert.Equal(t, schema.Schema, resolved.Schema)
+	assert.Equal(t, `{"id":1}`, string(payload))
+
+	_, _, err = client.Deserialize(ctx, []byte{1, 2})
+	assert.Equal(t, ErrInvalidPayload, err)
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("schemaregistry", "jupiter.schemaregistry.*", "schema registry client", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Addr 注册中心地址, e.g. http://127.0.0.1:8081
+	Addr string `json:"addr" toml:"addr"`
+	// Username/Password basic auth, optional
+	Username string `json:"username" toml:"username"`
+	Password string `json:"password" toml:"password"`
+	// Timeout of each request
+	Timeout time.Duration `json:"timeout" toml:"timeout"`
+	// AutoRegister registers schemas of producers, otherwise schemas must be
+	// registered in advance and are only looked up
+	AutoRegister bool `json:"autoRegister" toml:"autoRegister"`
+	// CheckCompatibility checks schemas against the latest version before registering
+	CheckCompatibility bool `json:"checkCompatibility" toml:"checkCompatibility"`
+
+	logger *xlog.Logger
+	client *http.Client
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Timeout:            3 * time.Second,
+		AutoRegister:       true,
+		CheckCompatibility: true,
+		logger:             xlog.JupiterLogger.With(xlog.FieldMod("client.schemaregistry")),
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.schemaregistry." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("schemaregistry parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// WithHTTPClient replaces the default http client, e.g. for TLS
+func (config *Config) WithHTTPClient(client *http.Client) *Config {
+	config.client = client
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Client {
+	if config.client == nil {
+		config.client = &http.Client{Timeout: config.Timeout}
+	}
+	return newClient(config)
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schemaregistry
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// magicByte leads payloads in Confluent wire format:
+// magic byte, 4 bytes big-endian schema id, then the encoded payload
+const magicByte = 0
+
+// ErrInvalidPayload is returned for payloads not in wire format
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// Encode prefixes payload with schema id in wire format
+func Encode(id int, payload []byte) []byte {
+	data := make([]byte, 5+len(payload))
+	data[0] = magicByte
+	binary.BigEndian.PutUint32(data[1:5], uint32(id))
+	copy(data[5:], payload)
+	return data
+}
+
+// Decode splits data in wire format into schema id and payload
+func Decode(data []byte) (int, []byte, error) {
+	if len(data) < 5 || data[0] != magicByte {
+		return 0, nil, ErrInvalidPayload
+	}
+	return int(binary.BigEndian.Uint32(data[1:5])), data[5:], nil
+}
+
+// Serializer encodes payloads of a subject for producers
+type Serializer struct {
+	client  *Client
+	subject string
+	schema  Schema
+}
+
+// NewSerializer ...
+func (c *Client) NewSerializer(subject string, schema Schema) *Serializer {
+	return &Serializer{client: c, subject: subject, schema: schema}
+}
+
+// Serialize registers or looks up the schema on first use depending on
+// AutoRegister, and prefixes payload with its id
+func (s *Serializer) Serialize(ctx context.Context, payload []byte) ([]byte, error) {
+	var id int
+	var err error
+	if s.client.config.AutoRegister {
+		id, err = s.client.Register(ctx, s.subject, s.schema)
+	} else {
+		id, err = s.client.Lookup(ctx, s.subject, s.schema)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Encode(id, payload), nil
+}
+
+// Deserialize resolves the schema of data in wire format for consumers,
+// and returns it with the payload
+func (c *Client) Deserialize(ctx context.Context, data []byte) (*Schema, []byte, error) {
+	id, payload, err := Decode(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	schema, err := c.SchemaByID(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return schema, payload, nil
+}
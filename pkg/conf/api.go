@@ -16,6 +16,7 @@ package conf
 
 import (
 	"io"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 )
@@ -59,7 +60,8 @@ func Reset() {
 	defaultConfiguration = New()
 }
 
-// Traverse ...
+// Traverse returns leaves of config by keys joined with sep, values of
+// secrets are Redacted, see SecretSource
 func Traverse(sep string) map[string]interface{} {
 	return defaultConfiguration.traverse(sep)
 }
@@ -78,3 +80,18 @@ func Get(key string) interface{} {
 func Set(key string, val interface{}) {
 	defaultConfiguration.Set(key, val)
 }
+
+// Override sets key to val temporarily for ttl, see Configuration.Override
+func Override(key string, val interface{}, ttl time.Duration) error {
+	return defaultConfiguration.Override(key, val, ttl)
+}
+
+// Revert drops the override of key
+func Revert(key string) bool {
+	return defaultConfiguration.Revert(key)
+}
+
+// Overrides returns overrides in effect
+func Overrides() []OverrideEntry {
+	return defaultConfiguration.Overrides()
+}
@@ -21,6 +21,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/douyu/jupiter/pkg/util/xcast"
@@ -30,15 +31,22 @@ import (
 )
 
 // Configuration provides configuration for application.
+// Reads are served from an immutable snapshot without locking, updates
+// copy the current snapshot, modify the copy and swap it atomically.
 type Configuration struct {
-	mu       sync.RWMutex
-	override map[string]interface{}
+	// mu serializes updates and guards callbacks
+	mu       sync.Mutex
 	keyDelim string
+	snapshot atomic.Value // *snapshot
+	// base is the tree without overrides
+	base      map[string]interface{}
+	overrides map[string]*override
 
-	keyMap    *sync.Map
 	onChanges []func(*Configuration)
 
 	watchers map[string][]func(*Configuration)
+	// secrets are redacted in traverse
+	secrets atomic.Value // *secrets
 }
 
 const (
@@ -47,26 +55,42 @@ const (
 
 // New constructs a new Configuration with provider.
 func New() *Configuration {
-	return &Configuration{
-		override:  make(map[string]interface{}),
-		keyDelim:  defaultKeyDelim,
-		keyMap:    &sync.Map{},
+	return newConfiguration(newSnapshot(make(map[string]interface{}), defaultKeyDelim))
+}
+
+func newConfiguration(snap *snapshot) *Configuration {
+	c := &Configuration{
+		keyDelim:  snap.keyDelim,
+		base:      snap.tree,
+		overrides: make(map[string]*override),
 		onChanges: make([]func(*Configuration), 0),
 		watchers:  make(map[string][]func(*Configuration)),
 	}
+	c.snapshot.Store(snap)
+	return c
+}
+
+func (c *Configuration) load() *snapshot {
+	return c.snapshot.Load().(*snapshot)
 }
 
 // SetKeyDelim set keyDelim of a defaultConfiguration instance.
 func (c *Configuration) SetKeyDelim(delim string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.keyDelim = delim
+	c.snapshot.Store(newSnapshot(c.overlay(c.base), delim))
 }
 
 // Sub returns new Configuration instance representing a sub tree of this instance.
 func (c *Configuration) Sub(key string) *Configuration {
-	return &Configuration{
-		keyDelim: c.keyDelim,
-		override: c.GetStringMap(key),
-	}
+	return newConfiguration(newSnapshot(c.GetStringMap(key), c.keyDelim))
+}
+
+// Snapshot returns a read-only view of current configuration, later updates
+// are invisible to it, so a group of reads from it is always consistent.
+func (c *Configuration) Snapshot() *Configuration {
+	return newConfiguration(c.load())
 }
 
 // WriteConfig ...
@@ -77,6 +101,8 @@ func (c *Configuration) WriteConfig() error {
 
 // OnChange 注册change回调函数
 func (c *Configuration) OnChange(fn func(*Configuration)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.onChanges = append(c.onChanges, fn)
 }
 
@@ -87,15 +113,24 @@ func (c *Configuration) LoadFromDataSource(ds DataSource, unmarshaller Unmarshal
 		return err
 	}
 
-	if err := c.Load(content, unmarshaller); err != nil {
+	local := false
+	if source, ok := ds.(LocalSource); ok {
+		local = source.Local()
+	}
+	c.loadSecrets(ds, unmarshaller)
+	if err := c.loadContent(content, unmarshaller, local); err != nil {
 		return err
 	}
 
 	go func() {
 		for range ds.IsConfigChanged() {
 			if content, err := ds.ReadConfig(); err == nil {
-				_ = c.Load(content, unmarshaller)
-				for _, change := range c.onChanges {
+				c.loadSecrets(ds, unmarshaller)
+				_ = c.loadContent(content, unmarshaller, local)
+				c.mu.Lock()
+				onChanges := c.onChanges
+				c.mu.Unlock()
+				for _, change := range onChanges {
 					change(c)
 				}
 			}
@@ -105,12 +140,19 @@ func (c *Configuration) LoadFromDataSource(ds DataSource, unmarshaller Unmarshal
 	return nil
 }
 
-// Load ...
+// Load loads content, which is trusted as local configs, see LocalSource
 func (c *Configuration) Load(content []byte, unmarshal Unmarshaller) error {
+	return c.loadContent(content, unmarshal, true)
+}
+
+func (c *Configuration) loadContent(content []byte, unmarshal Unmarshaller, local bool) error {
 	configuration := make(map[string]interface{})
 	if err := unmarshal(content, &configuration); err != nil {
 		return err
 	}
+	if err := interpolate(configuration, local); err != nil {
+		return err
+	}
 	return c.apply(configuration)
 }
 
@@ -124,25 +166,42 @@ func (c *Configuration) LoadFromReader(reader io.Reader, unmarshaller Unmarshall
 }
 
 func (c *Configuration) apply(conf map[string]interface{}) error {
+	return c.update(func(tree map[string]interface{}) {
+		xmap.MergeStringMap(tree, deepCopyMap(conf))
+	})
+}
+
+// update applies fn to a copy of base tree and publishes the result
+// as a new snapshot.
+func (c *Configuration) update(fn func(tree map[string]interface{})) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	var changes = make(map[string]interface{})
+	tree := deepCopyMap(c.base)
+	fn(tree)
+	c.base = tree
+	c.publish()
+	return nil
+}
 
-	xmap.MergeStringMap(c.override, conf)
-	for k, v := range c.traverse(c.keyDelim) {
-		orig, ok := c.keyMap.Load(k)
+// publish overlays overrides on base as a new snapshot and notifies
+// watchers of changed keys, it's called with mu held.
+func (c *Configuration) publish() {
+	prev := c.load()
+	next := newSnapshot(c.overlay(c.base), c.keyDelim)
+	c.snapshot.Store(next)
+
+	var changes = make(map[string]interface{})
+	for k, v := range next.leaves {
+		orig, ok := prev.leaves[k]
 		if ok && !reflect.DeepEqual(orig, v) {
 			changes[k] = v
 		}
-		c.keyMap.Store(k, v)
 	}
 
 	if len(changes) > 0 {
 		c.notifyChanges(changes)
 	}
-
-	return nil
 }
 
 func (c *Configuration) notifyChanges(changes map[string]interface{}) {
@@ -167,12 +226,12 @@ func (c *Configuration) notifyChanges(changes map[string]interface{}) {
 
 // Set ...
 func (c *Configuration) Set(key string, val interface{}) error {
-	paths := strings.Split(key, c.keyDelim)
-	lastKey := paths[len(paths)-1]
-	m := deepSearch(c.override, paths[:len(paths)-1])
-	m[lastKey] = val
-	return c.apply(m)
-	// c.keyMap.Store(key, val)
+	return c.update(func(tree map[string]interface{}) {
+		paths := strings.Split(key, c.keyDelim)
+		lastKey := paths[len(paths)-1]
+		m := deepSearch(tree, paths[:len(paths)-1])
+		m[lastKey] = deepCopy(val)
+	})
 }
 
 func deepSearch(m map[string]interface{}, path []string) map[string]interface{} {
@@ -194,9 +253,10 @@ func deepSearch(m map[string]interface{}, path []string) map[string]interface{}
 	return m
 }
 
-// Get returns the value associated with the key
+// Get returns the value associated with the key, maps and slices are
+// copied so that modifying them doesn't affect the configuration.
 func (c *Configuration) Get(key string) interface{} {
-	return c.find(key)
+	return deepCopy(c.find(key))
 }
 
 // GetString returns the value associated with the key as a string with default defaultConfiguration.
@@ -363,9 +423,7 @@ func (c *Configuration) UnmarshalKey(key string, rawVal interface{}, opts ...Get
 		return err
 	}
 	if key == "" {
-		c.mu.RLock()
-		defer c.mu.RUnlock()
-		return decoder.Decode(c.override)
+		return decoder.Decode(deepCopyMap(c.load().tree))
 	}
 
 	value := c.Get(key)
@@ -377,18 +435,7 @@ func (c *Configuration) UnmarshalKey(key string, rawVal interface{}, opts ...Get
 }
 
 func (c *Configuration) find(key string) interface{} {
-	dd, ok := c.keyMap.Load(key)
-	if ok {
-		return dd
-	}
-
-	paths := strings.Split(key, c.keyDelim)
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	m := xmap.DeepSearchInMap(c.override, paths[:len(paths)-1]...)
-	dd = m[paths[len(paths)-1]]
-	c.keyMap.Store(key, dd)
-	return dd
+	return c.load().find(key)
 }
 
 func lookup(prefix string, target map[string]interface{}, data map[string]interface{}, sep string) {
@@ -407,6 +454,14 @@ func lookup(prefix string, target map[string]interface{}, data map[string]interf
 
 func (c *Configuration) traverse(sep string) map[string]interface{} {
 	data := make(map[string]interface{})
-	lookup("", c.override, data, sep)
+	lookup("", c.load().tree, data, sep)
+	s, _ := c.secrets.Load().(*secrets)
+	for k, v := range data {
+		if s.redacted(v) {
+			data[k] = Redacted
+			continue
+		}
+		data[k] = deepCopy(v)
+	}
 	return data
 }
@@ -0,0 +1,178 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+const testContent = `
+[jupiter.server.grpc]
+	port = 9091
+	labels = ["a", "b"]
+`
+
+func newTestConfiguration(t *testing.T) *Configuration {
+	c := New()
+	assert.Nil(t, c.LoadFromReader(bytes.NewBufferString(testContent), toml.Unmarshal))
+	return c
+}
+
+func TestConfiguration_Set(t *testing.T) {
+	c := newTestConfiguration(t)
+	assert.Nil(t, c.Set("jupiter.server.grpc.host", "127.0.0.1"))
+
+	assert.Equal(t, "127.0.0.1", c.GetString("jupiter.server.grpc.host"))
+	assert.Equal(t, 9091, c.GetInt("jupiter.server.grpc.port"))
+	// Set only touches the given path
+	assert.Nil(t, c.Get("host"))
+}
+
+func TestConfiguration_ReadsAreCopies(t *testing.T) {
+	c := newTestConfiguration(t)
+
+	grpc := c.GetStringMap("jupiter.server.grpc")
+	grpc["port"] = 1
+	labels := c.GetSlice("jupiter.server.grpc.labels")
+	labels[0] = "z"
+
+	assert.Equal(t, 9091, c.GetInt("jupiter.server.grpc.port"))
+	assert.Equal(t, []string{"a", "b"}, c.GetStringSlice("jupiter.server.grpc.labels"))
+}
+
+func TestConfiguration_Snapshot(t *testing.T) {
+	c := newTestConfiguration(t)
+	snap := c.Snapshot()
+	sub := c.Sub("jupiter.server")
+
+	assert.Nil(t, c.Set("jupiter.server.grpc.port", 9092))
+	assert.Equal(t, 9092, c.GetInt("jupiter.server.grpc.port"))
+	assert.Equal(t, 9091, snap.GetInt("jupiter.server.grpc.port"))
+	assert.Equal(t, 9091, sub.GetInt("grpc.port"))
+}
+
+func TestConfiguration_ConcurrentUpdate(t *testing.T) {
+	c := newTestConfiguration(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = c.apply(map[string]interface{}{
+					"jupiter": map[string]interface{}{
+						"server": map[string]interface{}{
+							"grpc": map[string]interface{}{"port": i*1000 + j, "host": fmt.Sprint(j)},
+						},
+					},
+				})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				var config struct {
+					Port int
+					Host string
+				}
+				assert.Nil(t, c.UnmarshalKey("jupiter.server.grpc", &config))
+				assert.Equal(t, []string{"a", "b"}, c.GetStringSlice("jupiter.server.grpc.labels"))
+				_ = c.traverse(".")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConfiguration_Override(t *testing.T) {
+	mc := xtime.NewMockClock(time.Now())
+	clock = mc
+	defer func() { clock = xtime.SystemClock }()
+
+	c := newTestConfiguration(t)
+	var changes int32
+	c.OnChange(func(*Configuration) { atomic.AddInt32(&changes, 1) })
+
+	assert.Equal(t, ErrInvalidTTL, c.Override("jupiter.server.grpc.port", 1, 0))
+	assert.Nil(t, c.Override("jupiter.server.grpc.port", 9092, time.Minute))
+	assert.Nil(t, c.Override("jupiter.server.grpc.timeout", "1s", 2*time.Minute))
+	assert.Equal(t, 9092, c.GetInt("jupiter.server.grpc.port"))
+	assert.Equal(t, time.Second, c.GetDuration("jupiter.server.grpc.timeout"))
+
+	// overrides survive reloads
+	assert.Nil(t, c.LoadFromReader(bytes.NewBufferString(testContent+"\thost = \"127.0.0.1\"\n"), toml.Unmarshal))
+	assert.Equal(t, 9092, c.GetInt("jupiter.server.grpc.port"))
+	assert.Equal(t, "127.0.0.1", c.GetString("jupiter.server.grpc.host"))
+
+	overrides := c.Overrides()
+	assert.Len(t, overrides, 2)
+	assert.Equal(t, "jupiter.server.grpc.port", overrides[0].Key)
+	assert.Equal(t, mc.Now().Add(time.Minute), overrides[0].ExpireAt)
+
+	mc.Advance(time.Minute)
+	assert.Equal(t, 9091, c.GetInt("jupiter.server.grpc.port"), "reverted once expired")
+	assert.True(t, c.Revert("jupiter.server.grpc.timeout"))
+	assert.False(t, c.Revert("jupiter.server.grpc.timeout"))
+	assert.Nil(t, c.Get("jupiter.server.grpc.timeout"))
+	assert.Empty(t, c.Overrides())
+	assert.EqualValues(t, 4, atomic.LoadInt32(&changes))
+
+	mc.Advance(time.Hour)
+	assert.EqualValues(t, 4, atomic.LoadInt32(&changes), "reverted override never expires")
+}
+
+type secretDataSource struct {
+	content string
+	secrets []string
+}
+
+func (ds *secretDataSource) ReadConfig() ([]byte, error)      { return []byte(ds.content), nil }
+func (ds *secretDataSource) IsConfigChanged() <-chan struct{} { return nil }
+func (ds *secretDataSource) Close() error                     { return nil }
+func (ds *secretDataSource) Secrets() [][]byte {
+	var ret [][]byte
+	for _, s := range ds.secrets {
+		ret = append(ret, []byte(s))
+	}
+	return ret
+}
+
+func TestConfiguration_TraverseRedactsSecrets(t *testing.T) {
+	table := "[jupiter.mysql.main]\ndsn = \"root:secret@tcp(db)/app\"\nport = 3306\n"
+	c := New()
+	assert.Nil(t, c.LoadFromDataSource(&secretDataSource{
+		content: "[jupiter.redis]\npassword = 'p@ss'\nurl = 'redis://:p@ss@cache'\naddr = 'cache'\n" + table,
+		secrets: []string{"p@ss", table},
+	}, toml.Unmarshal))
+
+	data := c.traverse(".")
+	assert.Equal(t, Redacted, data["jupiter.redis.password"])
+	assert.Equal(t, Redacted, data["jupiter.redis.url"])
+	assert.Equal(t, "cache", data["jupiter.redis.addr"])
+	assert.Equal(t, Redacted, data["jupiter.mysql.main.dsn"])
+	assert.Equal(t, Redacted, data["jupiter.mysql.main.port"])
+	// reads aren't redacted
+	assert.Equal(t, "p@ss", c.GetString("jupiter.redis.password"))
+}
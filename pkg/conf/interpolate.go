@@ -0,0 +1,192 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// interpolate expands expressions in string values of tree at load time:
+//
+//	${NAME}          env NAME, which must be set
+//	${NAME:default}  env NAME, or default if it's unset
+//	${file(path)}    content of file, without the trailing newline, only in
+//	                 configs of local sources, see LocalSource
+//	${base64(text)}  base64 decoded text
+//
+// Expressions nest, e.g. ${base64(${TOKEN_B64})}, and "$${" is a literal "${",
+// in expressions as well. Values stay strings, errors of all keys are
+// reported together.
+func interpolate(tree map[string]interface{}, local bool) error {
+	var in = interpolator{local: local}
+	var errs []string
+	var walk func(prefix string, v interface{}) interface{}
+	walk = func(prefix string, v interface{}) interface{} {
+		switch vv := v.(type) {
+		case string:
+			s, err := in.expand(vv)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", prefix, err))
+				return vv
+			}
+			return s
+		case map[string]interface{}:
+			for k, val := range vv {
+				vv[k] = walk(join(prefix, k), val)
+			}
+		case []interface{}:
+			for i, val := range vv {
+				vv[i] = walk(fmt.Sprintf("%s[%d]", prefix, i), val)
+			}
+		case []map[string]interface{}:
+			for i, val := range vv {
+				walk(fmt.Sprintf("%s[%d]", prefix, i), val)
+			}
+		}
+		return v
+	}
+	walk("", tree)
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return errors.Errorf("interpolate config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func join(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + defaultKeyDelim + key
+}
+
+// LocalSource is implemented by data sources of local configs, e.g. files,
+// only whose configs can read files by ${file(path)}, so that configs of
+// remote sources can't read files of the host
+type LocalSource interface {
+	Local() bool
+}
+
+type interpolator struct {
+	local bool
+}
+
+// expand expands all expressions of s
+func (in interpolator) expand(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var buf strings.Builder
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(s[i:], "$${") {
+			buf.WriteString("${")
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(s[i:], "${") {
+			buf.WriteByte(s[i])
+			i++
+			continue
+		}
+		end := closing(s, i+2)
+		if end < 0 {
+			return "", errors.Errorf("unclosed expression %q", s[i:])
+		}
+		val, err := in.evaluate(s[i+2 : end])
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(val)
+		i = end + 1
+	}
+	return buf.String(), nil
+}
+
+// closing returns the index of '}' closing the expression starting at i,
+// escaped "$${" opens no expression
+func closing(s string, i int) int {
+	depth := 0
+	for ; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "$${"):
+			i += 2
+		case strings.HasPrefix(s[i:], "${"):
+			depth++
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// evaluate evaluates expression without "${" and "}"
+func (in interpolator) evaluate(expr string) (string, error) {
+	for name, fn := range functions {
+		if strings.HasPrefix(expr, name+"(") && strings.HasSuffix(expr, ")") {
+			if name == "file" && !in.local {
+				return "", errors.New("file() is only allowed in configs of local sources")
+			}
+			arg, err := in.expand(expr[len(name)+1 : len(expr)-1])
+			if err != nil {
+				return "", err
+			}
+			return fn(arg)
+		}
+	}
+
+	name, def, hasDefault := expr, "", false
+	if idx := strings.IndexByte(expr, ':'); idx >= 0 {
+		name, def, hasDefault = expr[:idx], expr[idx+1:], true
+	}
+	if name == "" {
+		return "", errors.Errorf("empty env name in ${%s}", expr)
+	}
+	if val, ok := os.LookupEnv(name); ok {
+		return val, nil
+	}
+	if !hasDefault {
+		return "", errors.Errorf("env %s not set", name)
+	}
+	return in.expand(def)
+}
+
+var functions = map[string]func(arg string) (string, error){
+	"file": func(path string) (string, error) {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrap(err, "file()")
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	},
+	"base64": func(text string) (string, error) {
+		content, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+		if err != nil {
+			return "", errors.Wrap(err, "base64()")
+		}
+		return string(content), nil
+	},
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfiguration_LoadInterpolate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "password"), []byte("s3cret\n"), 0600))
+
+	os.Setenv("TEST_CONF_HOST", "10.0.0.1")
+	os.Setenv("TEST_CONF_DIR", dir)
+	os.Setenv("TEST_CONF_TOKEN", "dG9rZW4=")
+	defer os.Unsetenv("TEST_CONF_HOST")
+	defer os.Unsetenv("TEST_CONF_DIR")
+	defer os.Unsetenv("TEST_CONF_TOKEN")
+
+	c := New()
+	assert.Nil(t, c.LoadFromReader(bytes.NewBufferString(`
+[jupiter.mysql]
+	dsn = "root:${file(${TEST_CONF_DIR}/password)}@tcp(${TEST_CONF_HOST}:${TEST_CONF_PORT:3306})/app"
+	token = "${base64(${TEST_CONF_TOKEN})}"
+	hosts = ["${TEST_CONF_HOST}", "${TEST_CONF_BACKUP:${TEST_CONF_HOST}}"]
+	literal = "$${TEST_CONF_HOST}"
+	escaped = "${TEST_CONF_MISSING:$${}"
+	port = 3306
+`), toml.Unmarshal))
+	assert.Equal(t, "root:s3cret@tcp(10.0.0.1:3306)/app", c.GetString("jupiter.mysql.dsn"))
+	assert.Equal(t, "token", c.GetString("jupiter.mysql.token"))
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.1"}, c.GetStringSlice("jupiter.mysql.hosts"))
+	assert.Equal(t, "${TEST_CONF_HOST}", c.GetString("jupiter.mysql.literal"))
+	assert.Equal(t, "${", c.GetString("jupiter.mysql.escaped"))
+	assert.Equal(t, 3306, c.GetInt("jupiter.mysql.port"))
+
+	err = New().LoadFromReader(bytes.NewBufferString(`
+[app]
+	a = "${TEST_CONF_MISSING}"
+	b = "${file(/nonexistent)}"
+	c = "${base64(!)}"
+	d = "${TEST_CONF_HOST"
+`), toml.Unmarshal)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "app.a: env TEST_CONF_MISSING not set")
+	assert.Contains(t, err.Error(), "app.b: file()")
+	assert.Contains(t, err.Error(), "app.c: base64()")
+	assert.Contains(t, err.Error(), "app.d: unclosed expression")
+}
+
+type memDataSource struct {
+	content string
+	local   bool
+}
+
+func (m memDataSource) ReadConfig() ([]byte, error)      { return []byte(m.content), nil }
+func (m memDataSource) IsConfigChanged() <-chan struct{} { return nil }
+func (m memDataSource) Close() error                     { return nil }
+
+type localDataSource struct{ memDataSource }
+
+func (l localDataSource) Local() bool { return true }
+
+func TestConfiguration_LoadInterpolateRemote(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conf")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "password")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("s3cret\n"), 0600))
+	content := `password = "${file(` + path + `)}"`
+
+	// configs of remote sources can't read local files
+	err = New().LoadFromDataSource(memDataSource{content: content}, toml.Unmarshal)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "only allowed in configs of local sources")
+
+	c := New()
+	assert.Nil(t, c.LoadFromDataSource(localDataSource{memDataSource{content: content}}, toml.Unmarshal))
+	assert.Equal(t, "s3cret", c.GetString("password"))
+}
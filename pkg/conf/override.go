@@ -0,0 +1,134 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidTTL is returned for overrides without a positive ttl
+var ErrInvalidTTL = errors.New("override ttl must be positive")
+
+// clock is replaced by a mock clock in tests
+var clock xtime.Clock = xtime.SystemClock
+
+// OverrideEntry is a temporary value of a key on top of loaded config
+type OverrideEntry struct {
+	Key      string      `json:"key"`
+	Value    interface{} `json:"value"`
+	ExpireAt time.Time   `json:"expireAt"`
+}
+
+type override struct {
+	OverrideEntry
+	path  []string
+	timer xtime.ClockTimer
+}
+
+// Override sets key to val in memory for ttl, e.g. trying a mitigation
+// timeout during incidents. The value survives reloads of data source and
+// is reverted once ttl elapses or on Revert, it's never persisted.
+func (c *Configuration) Override(key string, val interface{}, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	c.mu.Lock()
+	if prev, ok := c.overrides[key]; ok {
+		prev.timer.Stop()
+	}
+	o := &override{
+		OverrideEntry: OverrideEntry{Key: key, Value: deepCopy(val), ExpireAt: clock.Now().Add(ttl)},
+		path:          strings.Split(key, c.keyDelim),
+	}
+	o.timer = clock.AfterFunc(ttl, func() { c.expire(o) })
+	c.overrides[key] = o
+	c.publish()
+	c.mu.Unlock()
+
+	c.changed()
+	return nil
+}
+
+// Revert drops the override of key, returns false if there's none
+func (c *Configuration) Revert(key string) bool {
+	c.mu.Lock()
+	o, ok := c.overrides[key]
+	if ok {
+		o.timer.Stop()
+		delete(c.overrides, key)
+		c.publish()
+	}
+	c.mu.Unlock()
+
+	if ok {
+		c.changed()
+	}
+	return ok
+}
+
+// Overrides returns overrides in effect sorted by key
+func (c *Configuration) Overrides() []OverrideEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret = make([]OverrideEntry, 0, len(c.overrides))
+	for _, o := range c.overrides {
+		ret = append(ret, OverrideEntry{Key: o.Key, Value: deepCopy(o.Value), ExpireAt: o.ExpireAt})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret
+}
+
+func (c *Configuration) expire(o *override) {
+	c.mu.Lock()
+	// the override may have been replaced or reverted meanwhile
+	ok := c.overrides[o.Key] == o
+	if ok {
+		delete(c.overrides, o.Key)
+		c.publish()
+	}
+	c.mu.Unlock()
+
+	if ok {
+		c.changed()
+	}
+}
+
+// changed calls callbacks registered by OnChange
+func (c *Configuration) changed() {
+	c.mu.Lock()
+	onChanges := c.onChanges
+	c.mu.Unlock()
+	for _, change := range onChanges {
+		change(c)
+	}
+}
+
+// overlay returns tree with overrides applied, it's called with mu held
+func (c *Configuration) overlay(tree map[string]interface{}) map[string]interface{} {
+	if len(c.overrides) == 0 {
+		return tree
+	}
+	tree = deepCopyMap(tree)
+	for _, o := range c.overrides {
+		m := deepSearch(tree, o.path[:len(o.path)-1])
+		m[o.path[len(o.path)-1]] = deepCopy(o.Value)
+	}
+	return tree
+}
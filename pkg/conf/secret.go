@@ -0,0 +1,88 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Redacted replaces values of secrets in dumps of config, e.g. governor /configs
+const Redacted = "******"
+
+// SecretSource is implemented by data sources decrypting secrets of config,
+// e.g. "ENC[...]" blocks of etcd, Secrets returns plaintexts of them last
+// read, so that dumps of config redact them
+type SecretSource interface {
+	Secrets() [][]byte
+}
+
+// secrets are plaintexts of blocks, and values of blocks of whole tables
+type secrets struct {
+	blocks []string
+	values map[string]struct{}
+}
+
+func (c *Configuration) loadSecrets(ds DataSource, unmarshal Unmarshaller) {
+	source, ok := ds.(SecretSource)
+	if !ok {
+		return
+	}
+	var s = &secrets{values: make(map[string]struct{})}
+	for _, block := range source.Secrets() {
+		if len(block) == 0 {
+			continue
+		}
+		s.blocks = append(s.blocks, string(block))
+		s.values[string(block)] = struct{}{}
+		// a table, e.g. "[jupiter.mysql.main]\ndsn = ..."
+		var table = make(map[string]interface{})
+		if err := unmarshal(block, &table); err == nil {
+			var leaves = make(map[string]interface{})
+			lookup("", table, leaves, c.keyDelim)
+			for _, v := range leaves {
+				s.values[fmt.Sprint(v)] = struct{}{}
+			}
+		}
+	}
+	c.secrets.Store(s)
+}
+
+// redacted reports whether value is or contains a secret
+func (s *secrets) redacted(value interface{}) bool {
+	if s == nil {
+		return false
+	}
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			if s.redacted(v) {
+				return true
+			}
+		}
+		return false
+	}
+	if _, ok := s.values[fmt.Sprint(value)]; ok {
+		return true
+	}
+	// e.g. "root:ENC[...]@tcp(db)/app"
+	if str, ok := value.(string); ok {
+		for _, block := range s.blocks {
+			if strings.Contains(str, block) {
+				return true
+			}
+		}
+	}
+	return false
+}
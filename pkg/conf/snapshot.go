@@ -0,0 +1,110 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conf
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/util/xcast"
+)
+
+// snapshot is an immutable view of the configuration. It's never modified
+// once published, updates build a new snapshot and swap it atomically, so
+// readers need no lock and never observe a half applied update.
+type snapshot struct {
+	keyDelim string
+	// tree is the nested configuration
+	tree map[string]interface{}
+	// leaves is the flattened tree, keyed by full path
+	leaves map[string]interface{}
+	// branches caches lookups of non-leaf keys
+	branches sync.Map
+}
+
+func newSnapshot(tree map[string]interface{}, keyDelim string) *snapshot {
+	leaves := make(map[string]interface{})
+	lookup("", tree, leaves, keyDelim)
+	return &snapshot{
+		keyDelim: keyDelim,
+		tree:     tree,
+		leaves:   leaves,
+	}
+}
+
+// find returns the value of key, the value is shared with the snapshot and
+// must not be modified.
+func (s *snapshot) find(key string) interface{} {
+	if val, ok := s.leaves[key]; ok {
+		return val
+	}
+	if val, ok := s.branches.Load(key); ok {
+		return val
+	}
+
+	var val interface{} = s.tree
+	for _, path := range strings.Split(key, s.keyDelim) {
+		m, err := xcast.ToStringMapE(val)
+		if err != nil {
+			val = nil
+			break
+		}
+		if val = m[path]; val == nil {
+			break
+		}
+	}
+	s.branches.Store(key, val)
+	return val
+}
+
+// deepCopy copies maps and slices of v recursively, scalar values are shared.
+func deepCopy(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		return deepCopyMap(vv)
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(vv))
+		for k, val := range vv {
+			m[fmt.Sprintf("%v", k)] = deepCopy(val)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(vv))
+		for i, val := range vv {
+			s[i] = deepCopy(val)
+		}
+		return s
+	case []map[string]interface{}:
+		s := make([]map[string]interface{}, len(vv))
+		for i, val := range vv {
+			s[i] = deepCopyMap(val)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+func deepCopyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return make(map[string]interface{})
+	}
+	ret := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		ret[k] = deepCopy(v)
+	}
+	return ret
+}
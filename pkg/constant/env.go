@@ -33,6 +33,10 @@ const (
 	EnvAppZone     = "APP_ZONE"
 	EnvAppHost     = "APP_HOST"
 	EnvAppInstance = "APP_INSTANCE" // application unique instance id.
+
+	// EnvConfigMasterKey holds base64 master keys of encrypted config,
+	// separated by comma, the first one encrypts and all decrypt
+	EnvConfigMasterKey = "CONFIG_MASTER_KEY"
 )
 
 const (
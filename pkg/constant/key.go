@@ -29,4 +29,10 @@ const (
 
 	// KeyServiceInfo
 	KeyServiceInfo = "__service_info_"
+
+	// KeyWeight is the weight of a resolved address, float64
+	KeyWeight = "__weight_"
+
+	// KeyMetadata is the metadata of a resolved address, map[string]string
+	KeyMetadata = "__metadata_"
 )
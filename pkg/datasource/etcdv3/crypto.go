@@ -0,0 +1,219 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Encrypted config blocks are "ENC[<base64 envelope>]", which can be a whole
+// value of etcd or any part of it, e.g. a quoted password or a toml table.
+//
+// An envelope is version(1) | master key id(8) | wrapped data key(60) |
+// nonce(12) | sealed block, the random data key is sealed with the master
+// key by AES-GCM, and the block is sealed with the data key by AES-GCM.
+const (
+	envelopeVersion = 1
+	keyIDSize       = 8
+	dataKeySize     = 32
+	nonceSize       = 12
+	wrappedKeySize  = nonceSize + dataKeySize + 16
+	headerSize      = 1 + keyIDSize + wrappedKeySize + nonceSize
+)
+
+var encryptedBlock = regexp.MustCompile(`ENC\[([A-Za-z0-9+/=]+)\]`)
+
+var (
+	// ErrNoMasterKey is returned for encrypted config without a master key
+	ErrNoMasterKey = errors.New("encrypted config without master key")
+	// ErrUnknownMasterKey is returned for blocks encrypted by a master key not in keyring
+	ErrUnknownMasterKey = errors.New("unknown master key")
+)
+
+// Keyring holds master keys of encrypted config. The first key encrypts,
+// all keys decrypt, so that master keys can be rotated.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a keyring of master keys of 16, 24 or 32 bytes
+func NewKeyring(masterKeys ...[]byte) (*Keyring, error) {
+	if len(masterKeys) == 0 {
+		return nil, ErrNoMasterKey
+	}
+	var keyring = &Keyring{keys: make(map[string]cipher.AEAD)}
+	for i, key := range masterKeys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "master key %d", i)
+		}
+		id := keyID(key)
+		if i == 0 {
+			keyring.primary = id
+		}
+		keyring.keys[id] = aead
+	}
+	return keyring, nil
+}
+
+// ParseKeyring returns a keyring of base64 master keys separated by comma or newline
+func ParseKeyring(text string) (*Keyring, error) {
+	var keys [][]byte
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		key, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode master key")
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(keys...)
+}
+
+// LoadKeyring reads base64 master keys from file, one key per line
+func LoadKeyring(path string) (*Keyring, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeyring(string(content))
+}
+
+// Encrypt seals plaintext into a block of "ENC[...]" with the first master key
+func (k *Keyring) Encrypt(plaintext []byte) (string, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	var buf = bytes.NewBuffer(make([]byte, 0, headerSize+len(plaintext)+16))
+	buf.WriteByte(envelopeVersion)
+	buf.WriteString(k.primary)
+	wrapped, err := seal(k.keys[k.primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+	buf.Write(wrapped)
+	sealed, err := seal(dataAEAD, plaintext)
+	if err != nil {
+		return "", err
+	}
+	buf.Write(sealed)
+	return "ENC[" + base64.StdEncoding.EncodeToString(buf.Bytes()) + "]", nil
+}
+
+// Decrypt replaces every encrypted block in data with its plaintext
+func (k *Keyring) Decrypt(data []byte) ([]byte, error) {
+	ret, _, err := k.decrypt(data)
+	return ret, err
+}
+
+// decrypt is Decrypt also returning plaintexts of blocks
+func (k *Keyring) decrypt(data []byte) ([]byte, [][]byte, error) {
+	var err error
+	var plaintexts [][]byte
+	ret := encryptedBlock.ReplaceAllFunc(data, func(block []byte) []byte {
+		if err != nil {
+			return nil
+		}
+		var plaintext []byte
+		plaintext, err = k.open(encryptedBlock.FindSubmatch(block)[1])
+		plaintexts = append(plaintexts, plaintext)
+		return plaintext
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ret, plaintexts, nil
+}
+
+func (k *Keyring) open(encoded []byte) ([]byte, error) {
+	envelope := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(envelope, encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode encrypted block")
+	}
+	envelope = envelope[:n]
+	if len(envelope) < headerSize || envelope[0] != envelopeVersion {
+		return nil, errors.New("malformed encrypted block")
+	}
+
+	id := string(envelope[1 : 1+keyIDSize])
+	masterAEAD, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownMasterKey
+	}
+	dataKey, err := open(masterAEAD, envelope[1+keyIDSize:1+keyIDSize+wrappedKeySize])
+	if err != nil {
+		return nil, errors.Wrap(err, "unwrap data key")
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(dataAEAD, envelope[1+keyIDSize+wrappedKeySize:])
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt block")
+	}
+	return plaintext, nil
+}
+
+// keyID identifies a master key without revealing it
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return string(sum[:keyIDSize])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce | ciphertext
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize, nonceSize+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < nonceSize {
+		return nil, errors.New("sealed data too short")
+	}
+	return aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+}
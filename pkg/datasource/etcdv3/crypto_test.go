@@ -0,0 +1,84 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyring(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+	oldRing, err := NewKeyring(oldKey)
+	assert.Nil(t, err)
+	password, err := oldRing.Encrypt([]byte("p@ss\"word"))
+	assert.Nil(t, err)
+	table, err := oldRing.Encrypt([]byte("[jupiter.mysql.main]\ndsn = \"root:secret@tcp(db)/app\"\n"))
+	assert.Nil(t, err)
+	assert.NotContains(t, password+table, "secret")
+
+	config := fmt.Sprintf("[jupiter.redis]\npassword = '%s'\n%s", password, table)
+
+	// rotated keyring still decrypts blocks of old key
+	ring, err := ParseKeyring(base64.StdEncoding.EncodeToString(newKey) + ",\n" + base64.StdEncoding.EncodeToString(oldKey))
+	assert.Nil(t, err)
+	plaintext, err := ring.Decrypt([]byte(config))
+	assert.Nil(t, err)
+	assert.Equal(t, "[jupiter.redis]\npassword = 'p@ss\"word'\n[jupiter.mysql.main]\ndsn = \"root:secret@tcp(db)/app\"\n", string(plaintext))
+
+	block, err := ring.Encrypt([]byte("new"))
+	assert.Nil(t, err)
+	_, err = oldRing.Decrypt([]byte(block))
+	assert.Equal(t, ErrUnknownMasterKey, err)
+
+	// tampered blocks are rejected
+	envelope, _ := base64.StdEncoding.DecodeString(password[4 : len(password)-1])
+	envelope[len(envelope)-1] ^= 1
+	_, err = ring.Decrypt([]byte("ENC[" + base64.StdEncoding.EncodeToString(envelope) + "]"))
+	assert.NotNil(t, err)
+
+	_, err = NewKeyring([]byte("short"))
+	assert.NotNil(t, err)
+	_, err = ParseKeyring("")
+	assert.Equal(t, ErrNoMasterKey, err)
+}
+
+func TestDataSource_decrypt(t *testing.T) {
+	ds := &etcdv3DataSource{}
+	value, err := ds.decrypt([]byte("plain = 1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "plain = 1", string(value))
+	_, err = ds.decrypt([]byte("secret = 'ENC[AQ==]'"))
+	assert.Equal(t, ErrNoMasterKey, err)
+}
+
+func TestDataSource_secrets(t *testing.T) {
+	ring, err := NewKeyring(bytes.Repeat([]byte{1}, 32))
+	assert.Nil(t, err)
+	password, err := ring.Encrypt([]byte("p@ss"))
+	assert.Nil(t, err)
+
+	ds := &etcdv3DataSource{keyring: ring}
+	_, err = ds.decrypt([]byte("password = '" + password + "'"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("p@ss")}, ds.Secrets())
+	_, err = ds.decrypt([]byte("plain = 1"))
+	assert.Nil(t, err)
+	assert.Empty(t, ds.Secrets())
+}
@@ -16,6 +16,7 @@ package etcdv3
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
@@ -37,6 +38,11 @@ type etcdv3DataSource struct {
 	// closed util.AtomicBool
 
 	logger *xlog.Logger
+	// keyring decrypts encrypted blocks of config
+	keyring *Keyring
+	// secrets are plaintexts of blocks last decrypted
+	mu      sync.Mutex
+	secrets [][]byte
 
 	changed chan struct{}
 }
@@ -52,6 +58,18 @@ func NewDataSource(client *etcdv3.Client, key string) conf.DataSource {
 	return ds
 }
 
+// NewEncryptedDataSource new a etcdv3DataSource instance decrypting blocks
+// of "ENC[...]" in config with keyring, see Keyring.Encrypt.
+func NewEncryptedDataSource(client *etcdv3.Client, key string, keyring *Keyring) conf.DataSource {
+	ds := &etcdv3DataSource{
+		client:      client,
+		propertyKey: key,
+		keyring:     keyring,
+	}
+	xgo.Go(ds.watch)
+	return ds
+}
+
 // ReadConfig ...
 func (s *etcdv3DataSource) ReadConfig() ([]byte, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -64,7 +82,32 @@ func (s *etcdv3DataSource) ReadConfig() ([]byte, error) {
 		return nil, errors.New("empty response")
 	}
 	s.lastUpdatedRevision = resp.Header.GetRevision()
-	return resp.Kvs[0].Value, nil
+	return s.decrypt(resp.Kvs[0].Value)
+}
+
+func (s *etcdv3DataSource) decrypt(value []byte) ([]byte, error) {
+	var ret, secrets = value, [][]byte(nil)
+	if encryptedBlock.Match(value) {
+		if s.keyring == nil {
+			return nil, ErrNoMasterKey
+		}
+		var err error
+		if ret, secrets, err = s.keyring.decrypt(value); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	s.secrets = secrets
+	s.mu.Unlock()
+	return ret, nil
+}
+
+// Secrets returns plaintexts of encrypted blocks, which are redacted in
+// dumps of config, see conf.SecretSource
+func (s *etcdv3DataSource) Secrets() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.secrets
 }
 
 // IsConfigChanged ...
@@ -1,12 +1,15 @@
 package etcdv3
 
 import (
+	"net/url"
+	"os"
+
 	"github.com/douyu/jupiter/pkg/client/etcdv3"
 	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/datasource/manager"
 	"github.com/douyu/jupiter/pkg/flag"
 	"github.com/douyu/jupiter/pkg/xlog"
-	"net/url"
 )
 
 // DataSourceEtcdv3 defines etcdv3 scheme
@@ -22,7 +25,8 @@ func init() {
 			return nil
 		}
 		// configAddr is a string in this format:
-		// etcdv3://ip:port?basicAuth=true&username=XXX&password=XXX&key=XXX&certFile=XXX&keyFile=XXX&caCert=XXX&secure=XXX
+		// etcdv3://ip:port?basicAuth=true&username=XXX&password=XXX&key=XXX&certFile=XXX&keyFile=XXX&caCert=XXX&secure=XXX&masterKeyFile=XXX
+		// master keys of encrypted config are read from masterKeyFile, or env CONFIG_MASTER_KEY
 
 		urlObj, err := url.Parse(configAddr)
 		if err != nil {
@@ -42,6 +46,25 @@ func init() {
 		etcdConf.CaCert = urlObj.Query().Get("caCert")
 		etcdConf.UserName = urlObj.Query().Get("username")
 		etcdConf.Password = urlObj.Query().Get("password")
+		keyring, err := loadKeyring(urlObj.Query().Get("masterKeyFile"))
+		if err != nil {
+			xlog.Panic("load config master key error", xlog.FieldErr(err))
+			return nil
+		}
+		if keyring != nil {
+			return NewEncryptedDataSource(etcdConf.Build(), urlObj.Query().Get("key"), keyring)
+		}
 		return NewDataSource(etcdConf.Build(), urlObj.Query().Get("key"))
 	})
 }
+
+// loadKeyring returns nil if no master key is provided
+func loadKeyring(path string) (*Keyring, error) {
+	if path != "" {
+		return LoadKeyring(path)
+	}
+	if keys := os.Getenv(constant.EnvConfigMasterKey); keys != "" {
+		return ParseKeyring(keys)
+	}
+	return nil, nil
+}
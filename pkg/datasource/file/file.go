@@ -61,6 +61,12 @@ func (fp *fileDataSource) Close() error {
 	return nil
 }
 
+// Local reports configs of files are local, which can read other files by
+// ${file(path)}
+func (fp *fileDataSource) Local() bool {
+	return true
+}
+
 // IsConfigChanged ...
 func (fp *fileDataSource) IsConfigChanged() <-chan struct{} {
 	return fp.changed
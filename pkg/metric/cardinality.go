@@ -0,0 +1,216 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+const (
+	// OverflowLabelValue replaces every label value of a series once its metric
+	// exceeds the cardinality limit
+	OverflowLabelValue = "other"
+)
+
+// DefaultMaxCardinality is the default cap of unique label value combinations per metric,
+// set MaxCardinality of opts to override it, negative means unlimited
+var DefaultMaxCardinality = 10000
+
+var (
+	guardsMu sync.Mutex
+	guards   = make([]*cardinalityGuard, 0)
+)
+
+// cardinalityGuard caps unique label value combinations of a metric,
+// combinations beyond the limit are folded into a single "other" series
+type cardinalityGuard struct {
+	name  string
+	limit int
+
+	mu     sync.RWMutex
+	series map[uint64]struct{}
+
+	dropped uint64
+	warned  uint32
+}
+
+func newCardinalityGuard(name string, limit int) *cardinalityGuard {
+	if limit == 0 {
+		limit = DefaultMaxCardinality
+	}
+	guard := &cardinalityGuard{
+		name:   name,
+		limit:  limit,
+		series: make(map[uint64]struct{}),
+	}
+	guardsMu.Lock()
+	guards = append(guards, guard)
+	guardsMu.Unlock()
+	return guard
+}
+
+// check returns labels as is if the combination is known or still under limit,
+// otherwise returns overflow label values.
+func (guard *cardinalityGuard) check(seed uint64, labels []string) []string {
+	if guard == nil || guard.limit < 0 || guard.admit(seed, labels) {
+		return labels
+	}
+	guard.overflow(labels)
+	return overflowLabels(len(labels))
+}
+
+// curry returns values to curry the leading labels with, followed by remaining
+// labels. Children fold overflowing series into values followed by overflow
+// label values, so the combination is counted once values are curried, and
+// values are folded as well once the limit is reached.
+func (guard *cardinalityGuard) curry(seed uint64, values []string, remaining int) []string {
+	if guard == nil || guard.limit < 0 || remaining < 0 {
+		return values
+	}
+	var labels = make([]string, 0, len(values)+remaining)
+	labels = append(append(labels, values...), overflowLabels(remaining)...)
+	if guard.admit(seed, labels) {
+		return values
+	}
+	guard.overflow(labels)
+	return overflowLabels(len(values))
+}
+
+// admit reports whether the combination is known or still under limit
+func (guard *cardinalityGuard) admit(seed uint64, labels []string) bool {
+	hash := hashLabels(seed, labels)
+
+	guard.mu.RLock()
+	_, ok := guard.series[hash]
+	size := len(guard.series)
+	guard.mu.RUnlock()
+	if ok {
+		return true
+	}
+
+	if size < guard.limit {
+		guard.mu.Lock()
+		defer guard.mu.Unlock()
+		if len(guard.series) < guard.limit {
+			guard.series[hash] = struct{}{}
+			return true
+		}
+	}
+	return false
+}
+
+func (guard *cardinalityGuard) overflow(labels []string) {
+	atomic.AddUint64(&guard.dropped, 1)
+	if atomic.CompareAndSwapUint32(&guard.warned, 0, 1) {
+		xlog.JupiterLogger.Warn("metric label cardinality exceeded, fold into overflow series",
+			xlog.FieldMod("metric"),
+			xlog.FieldName(guard.name),
+			xlog.Int("limit", guard.limit),
+			xlog.Any("labels", labels),
+		)
+	}
+}
+
+var overflowLabelsCache sync.Map
+
+func overflowLabels(n int) []string {
+	if labels, ok := overflowLabelsCache.Load(n); ok {
+		return labels.([]string)
+	}
+	labels := make([]string, n)
+	for i := range labels {
+		labels[i] = OverflowLabelValue
+	}
+	overflowLabelsCache.Store(n, labels)
+	return labels
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// hashLabels is fnv-1a over label values, values are separated by 0xff
+// which never shows up in utf-8 strings
+func hashLabels(seed uint64, labels []string) uint64 {
+	hash := seed
+	if hash == 0 {
+		hash = fnvOffset64
+	}
+	for _, label := range labels {
+		for i := 0; i < len(label); i++ {
+			hash ^= uint64(label[i])
+			hash *= fnvPrime64
+		}
+		hash ^= 0xff
+		hash *= fnvPrime64
+	}
+	return hash
+}
+
+// CardinalityStat ...
+type CardinalityStat struct {
+	Name    string `json:"name"`
+	Series  int    `json:"series"`
+	Limit   int    `json:"limit"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// CardinalityStats returns stats of all metrics, top offenders first
+func CardinalityStats() []CardinalityStat {
+	guardsMu.Lock()
+	var stats = make([]CardinalityStat, 0, len(guards))
+	for _, guard := range guards {
+		guard.mu.RLock()
+		stats = append(stats, CardinalityStat{
+			Name:    guard.name,
+			Series:  len(guard.series),
+			Limit:   guard.limit,
+			Dropped: atomic.LoadUint64(&guard.dropped),
+		})
+		guard.mu.RUnlock()
+	}
+	guardsMu.Unlock()
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Dropped != stats[j].Dropped {
+			return stats[i].Dropped > stats[j].Dropped
+		}
+		return stats[i].Series > stats[j].Series
+	})
+	return stats
+}
+
+func init() {
+	governor.HandleFunc("/metrics/cardinality", func(w http.ResponseWriter, r *http.Request) {
+		stats := CardinalityStats()
+		if n, err := strconv.Atoi(r.URL.Query().Get("top")); err == nil && n >= 0 && n < len(stats) {
+			stats = stats[:n]
+		}
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(stats)
+	})
+}
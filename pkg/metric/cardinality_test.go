@@ -0,0 +1,79 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCardinalityGuard(t *testing.T) {
+	counter := CounterVecOpts{
+		Namespace:      DefaultNamespace,
+		Name:           "test_cardinality_total",
+		Labels:         []string{"method", "peer"},
+		MaxCardinality: 3,
+	}.Build()
+
+	for i := 0; i < 10; i++ {
+		counter.Inc("/hello", fmt.Sprintf("peer-%d", i))
+	}
+	// known combinations are still accepted once the limit is reached
+	counter.Inc("/hello", "peer-0")
+
+	assert.Equal(t, 4, testutil.CollectAndCount(counter))
+	assert.Equal(t, float64(2), testutil.ToFloat64(counter.WithLabelValues("/hello", "peer-0")))
+	assert.Equal(t, float64(7), testutil.ToFloat64(counter.WithLabelValues(OverflowLabelValue, OverflowLabelValue)))
+
+	var found bool
+	for _, stat := range CardinalityStats() {
+		if stat.Name == "jupiter_test_cardinality_total" {
+			found = true
+			assert.Equal(t, 3, stat.Series)
+			assert.Equal(t, uint64(7), stat.Dropped)
+		}
+	}
+	assert.True(t, found)
+	assert.Equal(t, "jupiter_test_cardinality_total", CardinalityStats()[0].Name)
+}
+
+func TestCardinalityGuardCurry(t *testing.T) {
+	counter := CounterVecOpts{
+		Namespace:      DefaultNamespace,
+		Name:           "test_cardinality_curry_total",
+		Labels:         []string{"method", "peer"},
+		MaxCardinality: 2,
+	}.Build()
+
+	// the overflow series of /a is counted once it's curried, new prefixes
+	// are folded as well once the limit is reached
+	a := counter.Curry("/a")
+	a.Inc("peer-0")
+	a.Inc("peer-1")
+	counter.Curry("/b").Inc("peer-0")
+	counter.Curry("/c").Inc("peer-0")
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter.WithLabelValues("/a", "peer-0")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(counter.WithLabelValues("/a", OverflowLabelValue)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(counter.WithLabelValues(OverflowLabelValue, OverflowLabelValue)))
+	assert.Equal(t, 3, testutil.CollectAndCount(counter))
+}
+
+func TestHashLabels(t *testing.T) {
+	assert.NotEqual(t, hashLabels(0, []string{"ab", "c"}), hashLabels(0, []string{"a", "bc"}))
+	assert.Equal(t, hashLabels(hashLabels(0, []string{"a"}), []string{"b"}), hashLabels(0, []string{"a", "b"}))
+}
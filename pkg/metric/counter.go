@@ -25,6 +25,8 @@ type CounterVecOpts struct {
 	Name      string
 	Help      string
 	Labels    []string
+	// MaxCardinality caps unique label value combinations, DefaultMaxCardinality if zero
+	MaxCardinality int
 }
 
 // Build ...
@@ -39,6 +41,8 @@ func (opts CounterVecOpts) Build() *counterVec {
 	prometheus.MustRegister(vec)
 	return &counterVec{
 		CounterVec: vec,
+		labels:     opts.Labels,
+		guard:      newCardinalityGuard(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.MaxCardinality),
 	}
 }
 
@@ -54,14 +58,44 @@ func NewCounterVec(name string, labels []string) *counterVec {
 
 type counterVec struct {
 	*prometheus.CounterVec
+	labels []string
+	guard  *cardinalityGuard
+	seed   uint64
 }
 
 // Inc ...
 func (counter *counterVec) Inc(labels ...string) {
-	counter.WithLabelValues(labels...).Inc()
+	counter.WithLabelValues(counter.guard.check(counter.seed, labels)...).Inc()
 }
 
 // Add ...
 func (counter *counterVec) Add(v float64, labels ...string) {
-	counter.WithLabelValues(labels...).Add(v)
+	counter.WithLabelValues(counter.guard.check(counter.seed, labels)...).Add(v)
+}
+
+// Curry binds the leading labels with values and returns the partial vec,
+// hot paths can keep the result and only resolve the remaining labels per request
+func (counter *counterVec) Curry(values ...string) *counterVec {
+	values = counter.guard.curry(counter.seed, values, len(counter.labels)-len(values))
+	curried, err := counter.CurryWith(curryLabels(counter.labels, values))
+	if err != nil {
+		panic(err)
+	}
+	return &counterVec{
+		CounterVec: curried,
+		labels:     counter.labels[len(values):],
+		guard:      counter.guard,
+		seed:       hashLabels(counter.seed, values),
+	}
+}
+
+func curryLabels(names []string, values []string) prometheus.Labels {
+	if len(values) > len(names) {
+		panic("metric: too many label values to curry")
+	}
+	labels := make(prometheus.Labels, len(values))
+	for i, value := range values {
+		labels[names[i]] = value
+	}
+	return labels
 }
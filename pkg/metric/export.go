@@ -0,0 +1,207 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Family is a metric family in the structured JSON snapshot, for tooling
+// which can't scrape the Prometheus format
+type Family struct {
+	Name    string   `json:"name"`
+	Help    string   `json:"help"`
+	Type    string   `json:"type"`
+	Metrics []Sample `json:"metrics"`
+}
+
+// Sample is a series of a family, Value is set for counters, gauges and
+// untyped metrics, Count and Sum with Buckets or Quantiles for histograms
+// and summaries
+type Sample struct {
+	Labels      map[string]string `json:"labels"`
+	Value       *Float            `json:"value,omitempty"`
+	Count       *uint64           `json:"count,omitempty"`
+	Sum         *Float            `json:"sum,omitempty"`
+	Buckets     []Bucket          `json:"buckets,omitempty"`
+	Quantiles   []Quantile        `json:"quantiles,omitempty"`
+	TimestampMs int64             `json:"timestampMs,omitempty"`
+}
+
+// Bucket is a cumulative histogram bucket
+type Bucket struct {
+	UpperBound Float  `json:"upperBound"`
+	Count      uint64 `json:"count"`
+}
+
+// Quantile is a summary quantile
+type Quantile struct {
+	Quantile Float `json:"quantile"`
+	Value    Float `json:"value"`
+}
+
+// Float is encoded as a JSON number, or as "NaN", "+Inf" and "-Inf"
+// which JSON numbers can't represent
+type Float float64
+
+// MarshalJSON ...
+func (f Float) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+	return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+}
+
+// Gather returns metric families of the default registry whose names start
+// with any of prefixes, all families without prefixes
+func Gather(prefixes ...string) ([]*dto.MetricFamily, error) {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if len(prefixes) == 0 {
+		return mfs, err
+	}
+	var ret = mfs[:0]
+	for _, mf := range mfs {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(mf.GetName(), prefix) {
+				ret = append(ret, mf)
+				break
+			}
+		}
+	}
+	return ret, err
+}
+
+// WriteOpenMetrics writes mfs in the OpenMetrics text format
+func WriteOpenMetrics(w io.Writer, mfs []*dto.MetricFamily) error {
+	for _, mf := range mfs {
+		if _, err := expfmt.MetricFamilyToOpenMetrics(w, mf); err != nil {
+			return err
+		}
+	}
+	_, err := expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+// Families converts mfs to the structured JSON snapshot
+func Families(mfs []*dto.MetricFamily) []Family {
+	var families = make([]Family, 0, len(mfs))
+	for _, mf := range mfs {
+		family := Family{
+			Name:    mf.GetName(),
+			Help:    mf.GetHelp(),
+			Type:    strings.ToLower(mf.GetType().String()),
+			Metrics: make([]Sample, 0, len(mf.GetMetric())),
+		}
+		for _, m := range mf.GetMetric() {
+			family.Metrics = append(family.Metrics, newSample(mf.GetType(), m))
+		}
+		families = append(families, family)
+	}
+	return families
+}
+
+func newSample(typ dto.MetricType, m *dto.Metric) Sample {
+	var sample = Sample{
+		Labels:      make(map[string]string, len(m.GetLabel())),
+		TimestampMs: m.GetTimestampMs(),
+	}
+	for _, label := range m.GetLabel() {
+		sample.Labels[label.GetName()] = label.GetValue()
+	}
+	value := func(v float64) *Float {
+		f := Float(v)
+		return &f
+	}
+	switch typ {
+	case dto.MetricType_COUNTER:
+		sample.Value = value(m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		sample.Value = value(m.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		sample.Value = value(m.GetUntyped().GetValue())
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		count := h.GetSampleCount()
+		sample.Count, sample.Sum = &count, value(h.GetSampleSum())
+		for _, b := range h.GetBucket() {
+			sample.Buckets = append(sample.Buckets, Bucket{UpperBound: Float(b.GetUpperBound()), Count: b.GetCumulativeCount()})
+		}
+		// the +Inf bucket is implicit in the protobuf format
+		sample.Buckets = append(sample.Buckets, Bucket{UpperBound: Float(math.Inf(1)), Count: count})
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		count := s.GetSampleCount()
+		sample.Count, sample.Sum = &count, value(s.GetSampleSum())
+		for _, q := range s.GetQuantile() {
+			sample.Quantiles = append(sample.Quantiles, Quantile{Quantile: Float(q.GetQuantile()), Value: Float(q.GetValue())})
+		}
+	}
+	return sample
+}
+
+// prefixes returns prefix query params of r, which are repeatable or comma
+// separated, e.g. ?prefix=jupiter_server_,go_
+func prefixes(r *http.Request) []string {
+	var ret []string
+	for _, value := range r.URL.Query()["prefix"] {
+		for _, prefix := range strings.Split(value, ",") {
+			if prefix = strings.TrimSpace(prefix); prefix != "" {
+				ret = append(ret, prefix)
+			}
+		}
+	}
+	return ret
+}
+
+func init() {
+	governor.HandleFunc("/metrics/openmetrics", func(w http.ResponseWriter, r *http.Request) {
+		mfs, err := Gather(prefixes(r)...)
+		if err != nil && len(mfs) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", string(expfmt.FmtOpenMetrics))
+		_ = WriteOpenMetrics(w, mfs)
+	})
+	governor.HandleFunc("/metrics/json", func(w http.ResponseWriter, r *http.Request) {
+		mfs, err := Gather(prefixes(r)...)
+		if err != nil && len(mfs) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(Families(mfs))
+	})
+}
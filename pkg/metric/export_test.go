@@ -0,0 +1,93 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	exportCounter = CounterVecOpts{
+		Namespace: "export_test",
+		Name:      "requests_total",
+		Help:      "requests",
+		Labels:    []string{"method"},
+	}.Build()
+	exportHistogram = HistogramVecOpts{
+		Namespace: "export_test",
+		Name:      "latency_seconds",
+		Help:      "latency",
+		Labels:    []string{"method"},
+		Buckets:   []float64{0.1, 1},
+	}.Build()
+)
+
+func TestGather(t *testing.T) {
+	exportCounter.Inc("get")
+	mfs, err := Gather("export_test_requests", "not_exist_")
+	assert.Nil(t, err)
+	assert.Len(t, mfs, 1)
+	assert.Equal(t, "export_test_requests_total", mfs[0].GetName())
+
+	all, err := Gather()
+	assert.Nil(t, err)
+	assert.True(t, len(all) > 1)
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	exportCounter.Inc("put")
+	mfs, err := Gather("export_test_requests")
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WriteOpenMetrics(&buf, mfs))
+	assert.Contains(t, buf.String(), "# TYPE export_test_requests counter\n")
+	assert.Contains(t, buf.String(), `export_test_requests_total{method="put"} 1.0`)
+	assert.Contains(t, buf.String(), "# EOF\n")
+}
+
+func TestFamilies(t *testing.T) {
+	exportHistogram.Observe(0.5, "get")
+	exportHistogram.Observe(2, "get")
+	mfs, err := Gather("export_test_latency")
+	assert.Nil(t, err)
+
+	families := Families(mfs)
+	assert.Len(t, families, 1)
+	assert.Equal(t, "histogram", families[0].Type)
+	assert.Equal(t, "latency", families[0].Help)
+	sample := families[0].Metrics[0]
+	assert.Equal(t, map[string]string{"method": "get"}, sample.Labels)
+	assert.Nil(t, sample.Value)
+	assert.Equal(t, uint64(2), *sample.Count)
+	assert.Equal(t, Float(2.5), *sample.Sum)
+	assert.Equal(t, []Bucket{{0.1, 0}, {1, 1}, {Float(math.Inf(1)), 2}}, sample.Buckets)
+
+	data, err := json.Marshal(sample.Buckets)
+	assert.Nil(t, err)
+	assert.Equal(t, `[{"upperBound":0.1,"count":0},{"upperBound":1,"count":1},{"upperBound":"+Inf","count":2}]`, string(data))
+}
+
+func TestPrefixes(t *testing.T) {
+	r := httptest.NewRequest("GET", "/metrics/json?prefix=go_,%20jupiter_&prefix=process_&prefix=", nil)
+	assert.Equal(t, []string{"go_", "jupiter_", "process_"}, prefixes(r))
+	assert.Nil(t, prefixes(httptest.NewRequest("GET", "/metrics/json", nil)))
+}
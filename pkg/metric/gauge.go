@@ -23,10 +23,13 @@ type GaugeVecOpts struct {
 	Name      string
 	Help      string
 	Labels    []string
+	// MaxCardinality caps unique label value combinations, DefaultMaxCardinality if zero
+	MaxCardinality int
 }
 
 type gaugeVec struct {
 	*prometheus.GaugeVec
+	guard *cardinalityGuard
 }
 
 // Build ...
@@ -41,6 +44,7 @@ func (opts GaugeVecOpts) Build() *gaugeVec {
 	prometheus.MustRegister(vec)
 	return &gaugeVec{
 		GaugeVec: vec,
+		guard:    newCardinalityGuard(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.MaxCardinality),
 	}
 }
 
@@ -56,15 +60,15 @@ func NewGaugeVec(name string, labels []string) *gaugeVec {
 
 // Inc ...
 func (gv *gaugeVec) Inc(labels ...string) {
-	gv.WithLabelValues(labels...).Inc()
+	gv.WithLabelValues(gv.guard.check(0, labels)...).Inc()
 }
 
 // Add ...
 func (gv *gaugeVec) Add(v float64, labels ...string) {
-	gv.WithLabelValues(labels...).Add(v)
+	gv.WithLabelValues(gv.guard.check(0, labels)...).Add(v)
 }
 
 // Set ...
 func (gv *gaugeVec) Set(v float64, labels ...string) {
-	gv.WithLabelValues(labels...).Set(v)
+	gv.WithLabelValues(gv.guard.check(0, labels)...).Set(v)
 }
@@ -24,10 +24,14 @@ type HistogramVecOpts struct {
 	Help      string
 	Labels    []string
 	Buckets   []float64
+	// MaxCardinality caps unique label value combinations, DefaultMaxCardinality if zero
+	MaxCardinality int
 }
 
 type histogramVec struct {
 	*prometheus.HistogramVec
+	labels []string
+	guard  *cardinalityGuard
 }
 
 // Build ...
@@ -43,10 +47,37 @@ func (opts HistogramVecOpts) Build() *histogramVec {
 	prometheus.MustRegister(vec)
 	return &histogramVec{
 		HistogramVec: vec,
+		labels:       opts.Labels,
+		guard:        newCardinalityGuard(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.MaxCardinality),
 	}
 }
 
 // Observe ...
 func (histogram *histogramVec) Observe(v float64, labels ...string) {
-	histogram.WithLabelValues(labels...).Observe(v)
+	histogram.WithLabelValues(histogram.guard.check(0, labels)...).Observe(v)
+}
+
+// Curry binds the leading labels with values and returns the partial observer vec
+func (histogram *histogramVec) Curry(values ...string) *observerVec {
+	values = histogram.guard.curry(0, values, len(histogram.labels)-len(values))
+	curried, err := histogram.CurryWith(curryLabels(histogram.labels, values))
+	if err != nil {
+		panic(err)
+	}
+	return &observerVec{
+		ObserverVec: curried,
+		guard:       histogram.guard,
+		seed:        hashLabels(0, values),
+	}
+}
+
+type observerVec struct {
+	prometheus.ObserverVec
+	guard *cardinalityGuard
+	seed  uint64
+}
+
+// Observe ...
+func (ov *observerVec) Observe(v float64, labels ...string) {
+	ov.WithLabelValues(ov.guard.check(ov.seed, labels)...).Observe(v)
 }
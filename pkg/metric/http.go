@@ -0,0 +1,145 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+var (
+	// HTTPClientTraceHistogram observes duration of http client phases: dns, connect, tls, ttfb
+	HTTPClientTraceHistogram = HistogramVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_http_trace_seconds",
+		Labels:    []string{"target", "phase"},
+	}.Build()
+
+	// HTTPClientConnCounter counts connections got by http client, reused or not
+	HTTPClientConnCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_http_conn_total",
+		Labels:    []string{"target", "reused"},
+	}.Build()
+
+	// HTTPServerConnGauge gauges connections of http server by state
+	HTTPServerConnGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_http_conns",
+		Labels:    []string{"server", "state"},
+	}.Build()
+)
+
+// NewTransport wraps next with httptrace, exports dns, connect, tls and
+// time-to-first-byte durations of each request labeled by target.
+// http.DefaultTransport is used if next is nil.
+func NewTransport(target string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &traceTransport{target: target, next: next}
+}
+
+type traceTransport struct {
+	target string
+	next   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		start  = time.Now()
+		phases = &tracePhases{starts: make(map[string]time.Time)}
+	)
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { phases.start("dns") },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.observe("dns", phases.done("dns"))
+		},
+		// addresses may be dialed in parallel, and dials may finish after
+		// the round trip, hence the starts are kept per address
+		ConnectStart: func(network, addr string) { phases.start("connect " + network + addr) },
+		ConnectDone: func(network, addr string, err error) {
+			if since := phases.done("connect " + network + addr); err == nil {
+				t.observe("connect", since)
+			}
+		},
+		TLSHandshakeStart: func() { phases.start("tls") },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if since := phases.done("tls"); err == nil {
+				t.observe("tls", since)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				HTTPClientConnCounter.Inc(t.target, "true")
+			} else {
+				HTTPClientConnCounter.Inc(t.target, "false")
+			}
+		},
+		GotFirstResponseByte: func() { t.observe("ttfb", start) },
+	}
+	return t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+func (t *traceTransport) observe(phase string, since time.Time) {
+	if since.IsZero() {
+		return
+	}
+	HTTPClientTraceHistogram.Observe(time.Since(since).Seconds(), t.target, phase)
+}
+
+// tracePhases keeps start times of phases of a round trip, whose hooks
+// are called from dialing goroutines concurrently
+type tracePhases struct {
+	mu     sync.Mutex
+	starts map[string]time.Time
+}
+
+func (p *tracePhases) start(phase string) {
+	p.mu.Lock()
+	p.starts[phase] = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *tracePhases) done(phase string) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	since := p.starts[phase]
+	delete(p.starts, phase)
+	return since
+}
+
+// ConnStateHook returns a hook for http.Server.ConnState,
+// which gauges connections of server by state
+func ConnStateHook(server string) func(net.Conn, http.ConnState) {
+	var states sync.Map // net.Conn => http.ConnState
+	return func(conn net.Conn, state http.ConnState) {
+		if prev, ok := states.Load(conn); ok {
+			HTTPServerConnGauge.Add(-1, server, prev.(http.ConnState).String())
+		}
+		switch state {
+		case http.StateClosed, http.StateHijacked:
+			states.Delete(conn)
+		default:
+			states.Store(conn, state)
+			HTTPServerConnGauge.Inc(server, state.String())
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPTrace(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	ts.Config.ConnState = ConnStateHook("test")
+	ts.Start()
+	defer ts.Close()
+
+	newConns := testutil.ToFloat64(HTTPClientConnCounter.WithLabelValues("test", "false"))
+	reusedConns := testutil.ToFloat64(HTTPClientConnCounter.WithLabelValues("test", "true"))
+	client := &http.Client{Transport: NewTransport("test", nil)}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		assert.Nil(t, err)
+		_, _ = ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	assert.Equal(t, newConns+1, testutil.ToFloat64(HTTPClientConnCounter.WithLabelValues("test", "false")))
+	assert.Equal(t, reusedConns+1, testutil.ToFloat64(HTTPClientConnCounter.WithLabelValues("test", "true")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(HTTPServerConnGauge.WithLabelValues("test", "idle"))+
+		testutil.ToFloat64(HTTPServerConnGauge.WithLabelValues("test", "active")))
+}
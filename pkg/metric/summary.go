@@ -23,10 +23,13 @@ type SummaryVecOpts struct {
 	Name      string
 	Help      string
 	Labels    []string
+	// MaxCardinality caps unique label value combinations, DefaultMaxCardinality if zero
+	MaxCardinality int
 }
 
 type summaryVec struct {
 	*prometheus.SummaryVec
+	guard *cardinalityGuard
 }
 
 // Build ...
@@ -41,10 +44,11 @@ func (opts SummaryVecOpts) Build() *summaryVec {
 	prometheus.MustRegister(vec)
 	return &summaryVec{
 		SummaryVec: vec,
+		guard:      newCardinalityGuard(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.MaxCardinality),
 	}
 }
 
 // Observe ...
 func (summary *summaryVec) Observe(v float64, labels ...string) {
-	summary.WithLabelValues(labels...).Observe(v)
+	summary.WithLabelValues(summary.guard.check(0, labels)...).Observe(v)
 }
@@ -0,0 +1,171 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// RegisteredKey is a key put by the process
+type RegisteredKey struct {
+	Key     string `json:"key"`
+	LeaseID int64  `json:"leaseId,omitempty"`
+	// TTL is the remaining seconds of the lease, -1 if it has expired
+	TTL int64 `json:"ttl,omitempty"`
+}
+
+// KeyInspector is implemented by registries reporting keys put by the
+// process, e.g. etcd registry with leases of keys
+type KeyInspector interface {
+	RegisteredKeys(ctx context.Context) ([]RegisteredKey, error)
+}
+
+// ErrNotInspector is returned by RegisteredKeys of decorators whose underlying
+// registry is not a KeyInspector
+var ErrNotInspector = errors.New("registry doesn't report keys")
+
+// adminTimeout is the timeout of requests of governor /registry/*
+var adminTimeout = 10 * time.Second
+
+var admin struct {
+	sync.Mutex
+	reg          Registry
+	services     func() []*server.ServiceInfo
+	deregistered bool
+}
+
+func init() {
+	governor.HandleFunc("/registry/services", handleServices)
+	governor.HandleFunc("/registry/deregister", handleDeregister)
+	governor.HandleFunc("/registry/status", handleStatus)
+}
+
+// ServeAdmin exposes registrations of the process on governor for debugging
+// stuck registrations, services returns services as they're registered:
+//
+//	GET /registry/services lists services registered
+//	POST /registry/deregister unregisters them, until DELETE registers them again
+//	GET /registry/status reports keys and their leases if reg is a KeyInspector
+func ServeAdmin(reg Registry, services func() []*server.ServiceInfo) {
+	admin.Lock()
+	admin.reg, admin.services, admin.deregistered = reg, services, false
+	admin.Unlock()
+}
+
+// adminTarget returns the registry and services served, ok is false if
+// ServeAdmin is not called
+func adminTarget(w http.ResponseWriter) (reg Registry, services []*server.ServiceInfo, deregistered bool, ok bool) {
+	admin.Lock()
+	reg, fn, deregistered := admin.reg, admin.services, admin.deregistered
+	admin.Unlock()
+	if reg == nil {
+		http.Error(w, "no registry", http.StatusNotFound)
+		return nil, nil, false, false
+	}
+	return reg, fn(), deregistered, true
+}
+
+func handleServices(w http.ResponseWriter, r *http.Request) {
+	_, services, deregistered, ok := adminTarget(w)
+	if !ok {
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"deregistered": deregistered,
+		"services":     services,
+	})
+}
+
+func handleDeregister(w http.ResponseWriter, r *http.Request) {
+	reg, services, _, ok := adminTarget(w)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+	defer cancel()
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		for _, info := range services {
+			if e := reg.UnregisterService(ctx, info); e != nil {
+				err = e
+			}
+		}
+		setDeregistered(true)
+	case http.MethodDelete:
+		if err = RegisterServices(ctx, reg, services); err == nil {
+			setDeregistered(false)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		xlog.JupiterLogger.Error("registry admin", xlog.FieldMod("registry.admin"), xlog.String("method", r.Method), xlog.FieldErr(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handleServices(w, r)
+}
+
+// Deregistered reports whether services are unregistered on governor, in
+// which case they mustn't be registered again until it's undone there, e.g.
+// on changes of weight
+func Deregistered() bool {
+	admin.Lock()
+	defer admin.Unlock()
+	return admin.deregistered
+}
+
+func setDeregistered(deregistered bool) {
+	admin.Lock()
+	admin.deregistered = deregistered
+	admin.Unlock()
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	reg, services, deregistered, ok := adminTarget(w)
+	if !ok {
+		return
+	}
+	var status = map[string]interface{}{
+		"deregistered": deregistered,
+		"services":     len(services),
+	}
+	if inspector, ok := reg.(KeyInspector); ok {
+		ctx, cancel := context.WithTimeout(r.Context(), adminTimeout)
+		defer cancel()
+		keys, err := inspector.RegisteredKeys(ctx)
+		switch err {
+		case nil:
+			status["keys"] = keys
+		case ErrNotInspector:
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveGovernor(method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestServeAdmin(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, serveGovernor(http.MethodGet, "/registry/services").Code)
+
+	reg := &failingRegistry{fails: 10}
+	infos := []*server.ServiceInfo{{Name: "demo", Scheme: "grpc", Address: "127.0.0.1:9091"}}
+	ServeAdmin(reg, func() []*server.ServiceInfo { return infos })
+	defer ServeAdmin(nil, nil)
+
+	w := serveGovernor(http.MethodGet, "/registry/services")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"deregistered":false`)
+	assert.Contains(t, w.Body.String(), `"address":"127.0.0.1:9091"`)
+
+	w = serveGovernor(http.MethodPost, "/registry/deregister")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"127.0.0.1:9091"}, reg.unregistered)
+	assert.True(t, Deregistered())
+	assert.JSONEq(t, `{"deregistered":true,"services":1}`, serveGovernor(http.MethodGet, "/registry/status").Body.String())
+
+	w = serveGovernor(http.MethodDelete, "/registry/deregister")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"127.0.0.1:9091"}, reg.registered)
+	assert.Contains(t, w.Body.String(), `"deregistered":false`)
+	assert.False(t, Deregistered())
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serveGovernor(http.MethodGet, "/registry/deregister").Code)
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache decorates a registry with a TTL cache of ListServices for
+// one-shot callers like CLI tools and jobs, which look up a service a few
+// times and shouldn't establish a watch or hit etcd on every call.
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"golang.org/x/sync/singleflight"
+)
+
+// Config ...
+type Config struct {
+	// TTL is how long a listing is fresh
+	TTL time.Duration
+	// StaleTTL is how long an expired listing is still served while it's
+	// refreshed in background (stale-while-revalidate), 0 disables it
+	StaleTTL time.Duration
+	// ListTimeout bounds a call to the underlying registry, which is shared
+	// by concurrent misses and isn't canceled with any of their contexts
+	ListTimeout time.Duration
+
+	clock xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() Config {
+	return Config{
+		TTL:         10 * time.Second,
+		StaleTTL:    time.Minute,
+		ListTimeout: 3 * time.Second,
+		clock:       xtime.SystemClock,
+	}
+}
+
+type entry struct {
+	services []*server.ServiceInfo
+	expireAt time.Time
+}
+
+type cachedRegistry struct {
+	registry.Registry
+	config Config
+
+	mu      sync.RWMutex
+	entries map[string]*entry
+	group   singleflight.Group
+}
+
+// New returns reg with ListServices cached
+func New(reg registry.Registry, config Config) registry.Registry {
+	if config.clock == nil {
+		config.clock = xtime.SystemClock
+	}
+	if config.ListTimeout <= 0 {
+		config.ListTimeout = DefaultConfig().ListTimeout
+	}
+	return &cachedRegistry{
+		Registry: reg,
+		config:   config,
+		entries:  make(map[string]*entry),
+	}
+}
+
+// ListServices returns the cached listing if it's fresh, a stale listing is
+// returned as well and refreshed in background, otherwise the underlying
+// registry is called. Concurrent misses of the same key share one call.
+// Callers get their own copies of the services.
+func (c *cachedRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	key := scheme + "://" + name
+	now := c.config.clock.Now()
+
+	c.mu.RLock()
+	e, ok := c.entries[key]
+	c.mu.RUnlock()
+	if ok {
+		if now.Before(e.expireAt) {
+			return copyServices(e.services), nil
+		}
+		if now.Before(e.expireAt.Add(c.config.StaleTTL)) {
+			xgo.Go(func() {
+				if _, err := c.refresh(context.Background(), key, name, scheme); err != nil {
+					xlog.Warn("refresh services", xlog.FieldErr(err), xlog.String("name", name))
+				}
+			})
+			return copyServices(e.services), nil
+		}
+	}
+	return c.refresh(ctx, key, name, scheme)
+}
+
+// refresh lists services of key on a context of its own, since the call is
+// shared, ctx only bounds the wait of the caller
+func (c *cachedRegistry) refresh(ctx context.Context, key, name, scheme string) ([]*server.ServiceInfo, error) {
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), c.config.ListTimeout)
+		defer cancel()
+		services, err := c.Registry.ListServices(ctx, name, scheme)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.entries[key] = &entry{
+			services: services,
+			expireAt: c.config.clock.Now().Add(c.config.TTL),
+		}
+		c.mu.Unlock()
+		return services, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return copyServices(res.Val.([]*server.ServiceInfo)), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// copyServices copies services deeply, so callers can't modify the cache
+func copyServices(services []*server.ServiceInfo) []*server.ServiceInfo {
+	var out = make([]*server.ServiceInfo, len(services))
+	for i, service := range services {
+		info := *service
+		info.Metadata = copyLabels(service.Metadata)
+		info.Labels = copyLabels(service.Labels)
+		if service.Services != nil {
+			info.Services = make(map[string]*server.Service, len(service.Services))
+			for k, v := range service.Services {
+				if v == nil {
+					info.Services[k] = nil
+					continue
+				}
+				s := *v
+				s.Labels = copyLabels(v.Labels)
+				s.Methods = append([]string(nil), v.Methods...)
+				info.Services[k] = &s
+			}
+		}
+		out[i] = &info
+	}
+	return out
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	var out = make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingRegistry struct {
+	registry.Nop
+	calls int32
+	err   error
+}
+
+func (r *countingRegistry) ListServices(context.Context, string, string) ([]*server.ServiceInfo, error) {
+	err := r.err
+	n := atomic.AddInt32(&r.calls, 1)
+	if err != nil {
+		return nil, err
+	}
+	return []*server.ServiceInfo{{Name: "demo", Weight: float64(n)}}, nil
+}
+
+func TestCachedRegistry(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	backend := &countingRegistry{}
+	reg := New(backend, Config{TTL: time.Second, StaleTTL: time.Second, clock: clock})
+
+	services, err := reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, float64(1), services[0].Weight)
+
+	// fresh
+	services, _ = reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Equal(t, float64(1), services[0].Weight)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&backend.calls))
+
+	// stale, served and refreshed in background
+	clock.Advance(1500 * time.Millisecond)
+	services, _ = reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Equal(t, float64(1), services[0].Weight)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&backend.calls) == 2 }, time.Second, time.Millisecond)
+
+	// expired beyond stale ttl
+	clock.Advance(5 * time.Second)
+	backend.err = errors.New("unavailable")
+	_, err = reg.ListServices(context.Background(), "demo", "grpc")
+	assert.EqualError(t, err, "unavailable")
+}
+
+type blockingRegistry struct {
+	registry.Nop
+	release chan struct{}
+}
+
+func (r *blockingRegistry) ListServices(ctx context.Context, name string, _ string) ([]*server.ServiceInfo, error) {
+	select {
+	case <-r.release:
+		return []*server.ServiceInfo{{Name: name, Metadata: map[string]string{"k": "v"}}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestCachedRegistry_Shared(t *testing.T) {
+	backend := &blockingRegistry{release: make(chan struct{})}
+	reg := New(backend, DefaultConfig())
+
+	first, cancel := context.WithCancel(context.Background())
+	var errs = make(chan error, 1)
+	go func() {
+		_, err := reg.ListServices(first, "demo", "grpc")
+		errs <- err
+	}()
+	var services = make(chan []*server.ServiceInfo, 1)
+	go func() {
+		list, err := reg.ListServices(context.Background(), "demo", "grpc")
+		assert.Nil(t, err)
+		services <- list
+	}()
+
+	// the first caller leaving doesn't fail the shared call
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+	close(backend.release)
+	list := <-services
+	assert.Equal(t, "demo", list[0].Name)
+
+	list[0].Name = "modified"
+	list[0].Metadata["k"] = "modified"
+	cached, err := reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, "demo", cached[0].Name)
+	assert.Equal(t, "v", cached[0].Metadata["k"])
+}
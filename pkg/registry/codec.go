@@ -0,0 +1,142 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/server"
+)
+
+// Codec encodes services as registry values, e.g. protobuf values shrink
+// values of large clusters and are easy to write by services in other
+// languages. JSON is the default codec. Values are prefixed with Magic of
+// their codecs, so that readers detect codecs by themselves, Magic must be
+// unique among codecs, JSON values have no magic but start with '{'. The
+// magic of protobuf is "\x00pb" and the one of msgpack is "\x00mp", neither
+// plain message starts with 0x00 or '{', so readers configured with a codec
+// accept values written without the magic as well, see UnmarshalServiceWith.
+type Codec interface {
+	Name() string
+	Magic() []byte
+	Marshal(info *server.ServiceInfo) ([]byte, error)
+	Unmarshal(data []byte, info *server.ServiceInfo) error
+}
+
+var codecs sync.Map // name => Codec
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(protobufCodec{})
+	RegisterCodec(msgpackCodec{})
+}
+
+// RegisterCodec registers a codec
+func RegisterCodec(c Codec) {
+	codecs.Store(c.Name(), c)
+}
+
+// GetCodec returns the codec registered with name
+func GetCodec(name string) (Codec, bool) {
+	c, ok := codecs.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return c.(Codec), true
+}
+
+// MarshalService encodes info with the named codec prefixed with its magic,
+// JSON if name is empty
+func MarshalService(info *server.ServiceInfo, name string) ([]byte, error) {
+	if name == "" {
+		name = "json"
+	}
+	c, ok := GetCodec(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+	data, err := c.Marshal(info)
+	if err != nil || len(c.Magic()) == 0 {
+		return data, err
+	}
+	return append(append(make([]byte, 0, len(c.Magic())+len(data)), c.Magic()...), data...), nil
+}
+
+// UnmarshalService decodes data encoded by MarshalService into info with the
+// codec detected by its magic, so that readers decode values of any codec,
+// e.g. during migration of writers
+func UnmarshalService(data []byte, info *server.ServiceInfo) error {
+	if len(data) > 0 && data[0] == '{' {
+		return jsonCodec{}.Unmarshal(data, info)
+	}
+	var c Codec
+	codecs.Range(func(_, v interface{}) bool {
+		if magic := v.(Codec).Magic(); len(magic) > 0 && bytes.HasPrefix(data, magic) {
+			c = v.(Codec)
+			return false
+		}
+		return true
+	})
+	if c == nil {
+		return errors.New("unknown codec of value")
+	}
+	return c.Unmarshal(data[len(c.Magic()):], info)
+}
+
+// UnmarshalServiceWith decodes data like UnmarshalService, values without
+// magic are decoded by the named codec, so that services in other languages
+// can write plain messages, e.g. ones of api/serviceinfo.proto
+func UnmarshalServiceWith(data []byte, name string, info *server.ServiceInfo) error {
+	c, ok := GetCodec(name)
+	if !ok || len(c.Magic()) == 0 || hasMagic(data) {
+		return UnmarshalService(data, info)
+	}
+	return c.Unmarshal(data, info)
+}
+
+// hasMagic reports whether data is a JSON value or prefixed with the magic of
+// a codec
+func hasMagic(data []byte) bool {
+	if len(data) > 0 && data[0] == '{' {
+		return true
+	}
+	var found bool
+	codecs.Range(func(_, v interface{}) bool {
+		magic := v.(Codec).Magic()
+		found = len(magic) > 0 && bytes.HasPrefix(data, magic)
+		return !found
+	})
+	return found
+}
+
+type jsonCodec struct{}
+
+// Name ...
+func (jsonCodec) Name() string { return "json" }
+
+// Magic ...
+func (jsonCodec) Magic() []byte { return nil }
+
+// Marshal ...
+func (jsonCodec) Marshal(info *server.ServiceInfo) ([]byte, error) { return json.Marshal(info) }
+
+// Unmarshal ...
+func (jsonCodec) Unmarshal(data []byte, info *server.ServiceInfo) error {
+	return json.Unmarshal(data, info)
+}
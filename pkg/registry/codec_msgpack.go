@@ -0,0 +1,275 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/douyu/jupiter/pkg/server"
+)
+
+// errMsgpackShort is returned if a msgpack value is truncated
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+// msgpackCodec encodes services as msgpack maps with the same keys as JSON
+// values, so that they are readable by any msgpack library
+type msgpackCodec struct{}
+
+// Name ...
+func (msgpackCodec) Name() string { return "msgpack" }
+
+// Magic ...
+func (msgpackCodec) Magic() []byte { return []byte("\x00mp") }
+
+// Marshal ...
+func (msgpackCodec) Marshal(info *server.ServiceInfo) ([]byte, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, v)
+}
+
+// Unmarshal ...
+func (msgpackCodec) Unmarshal(data []byte, info *server.ServiceInfo) error {
+	v, rest, err := consumeMsgpack(data)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("msgpack: %d trailing bytes", len(rest))
+	}
+	data, err = json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	*info = server.ServiceInfo{}
+	return json.Unmarshal(data, info)
+}
+
+func appendMsgpack(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		return appendUint(b, math.Float64bits(f), 8), nil
+	case string:
+		b = appendMsgpackHeader(b, len(v), 0xa0, 32, 0xd9)
+		return append(b, v...), nil
+	case []interface{}:
+		b = appendMsgpackHeader(b, len(v), 0x90, 16, 0xdc)
+		var err error
+		for _, elem := range v {
+			if b, err = appendMsgpack(b, elem); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendMsgpackHeader(b, len(v), 0x80, 16, 0xde)
+		var keys = make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var err error
+		for _, key := range keys {
+			b, _ = appendMsgpack(b, key)
+			if b, err = appendMsgpack(b, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(b, byte(i))
+	case i >= -32 && i < 0:
+		return append(b, byte(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return appendUint(append(b, 0xd2), uint64(i), 4)
+	}
+	return appendUint(append(b, 0xd3), uint64(i), 8)
+}
+
+// appendMsgpackHeader appends the header of a string, array or map of n
+// elements, fix is the format of small ones and wide the 8 or 16 bits one
+func appendMsgpackHeader(b []byte, n int, fix byte, fixLimit int, wide byte) []byte {
+	switch {
+	case n < fixLimit:
+		return append(b, fix|byte(n))
+	case wide == 0xd9 && n <= math.MaxUint8:
+		return append(b, wide, byte(n))
+	case wide == 0xd9 && n <= math.MaxUint16:
+		return appendUint(append(b, wide+1), uint64(n), 2)
+	case wide == 0xd9:
+		return appendUint(append(b, wide+2), uint64(n), 4)
+	case n <= math.MaxUint16:
+		return appendUint(append(b, wide), uint64(n), 2)
+	}
+	return appendUint(append(b, wide+1), uint64(n), 4)
+}
+
+func appendUint(b []byte, v uint64, size int) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[8-size:]...)
+}
+
+// consumeMsgpack decodes the first value of b, integers are decoded as
+// int64 or uint64, binaries as strings, maps must have string keys
+func consumeMsgpack(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errMsgpackShort
+	}
+	c, b := b[0], b[1:]
+	switch {
+	case c <= 0x7f:
+		return int64(c), b, nil
+	case c >= 0xe0:
+		return int64(int8(c)), b, nil
+	case c >= 0x80 && c <= 0x8f:
+		return consumeMsgpackMap(b, int(c&0x0f))
+	case c >= 0x90 && c <= 0x9f:
+		return consumeMsgpackArray(b, int(c&0x0f))
+	case c >= 0xa0 && c <= 0xbf:
+		return consumeMsgpackString(b, int(c&0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	case 0xc4, 0xd9:
+		return consumeMsgpackSized(b, 1, consumeMsgpackString)
+	case 0xc5, 0xda:
+		return consumeMsgpackSized(b, 2, consumeMsgpackString)
+	case 0xc6, 0xdb:
+		return consumeMsgpackSized(b, 4, consumeMsgpackString)
+	case 0xdc:
+		return consumeMsgpackSized(b, 2, consumeMsgpackArray)
+	case 0xdd:
+		return consumeMsgpackSized(b, 4, consumeMsgpackArray)
+	case 0xde:
+		return consumeMsgpackSized(b, 2, consumeMsgpackMap)
+	case 0xdf:
+		return consumeMsgpackSized(b, 4, consumeMsgpackMap)
+	case 0xca:
+		v, b, err := consumeUint(b, 4)
+		return float64(math.Float32frombits(uint32(v))), b, err
+	case 0xcb:
+		v, b, err := consumeUint(b, 8)
+		return math.Float64frombits(v), b, err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return consumeUint(b, 1<<(c-0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		v, b, err := consumeUint(b, size)
+		// sign extends v
+		shift := uint(64 - 8*size)
+		return int64(v<<shift) >> shift, b, err
+	}
+	return nil, nil, fmt.Errorf("msgpack: unsupported format 0x%x", c)
+}
+
+func consumeUint(b []byte, size int) (uint64, []byte, error) {
+	if len(b) < size {
+		return 0, nil, errMsgpackShort
+	}
+	var buf [8]byte
+	copy(buf[8-size:], b[:size])
+	return binary.BigEndian.Uint64(buf[:]), b[size:], nil
+}
+
+func consumeMsgpackSized(b []byte, size int, consume func([]byte, int) (interface{}, []byte, error)) (interface{}, []byte, error) {
+	n, b, err := consumeUint(b, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n > uint64(len(b)) {
+		// every element takes one byte at least
+		return nil, nil, errMsgpackShort
+	}
+	return consume(b, int(n))
+}
+
+func consumeMsgpackString(b []byte, n int) (interface{}, []byte, error) {
+	if len(b) < n {
+		return nil, nil, errMsgpackShort
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+func consumeMsgpackArray(b []byte, n int) (interface{}, []byte, error) {
+	var v = make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		elem, rest, err := consumeMsgpack(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, b = append(v, elem), rest
+	}
+	return v, b, nil
+}
+
+func consumeMsgpackMap(b []byte, n int) (interface{}, []byte, error) {
+	var v = make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, rest, err := consumeMsgpack(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		s, ok := key.(string)
+		if !ok {
+			return nil, nil, fmt.Errorf("msgpack: map key of type %T", key)
+		}
+		if v[s], b, err = consumeMsgpack(rest); err != nil {
+			return nil, nil, err
+		}
+	}
+	return v, b, nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"math"
+	"sort"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufCodec encodes services as the ServiceInfo message of
+// api/serviceinfo.proto, so that services in other languages can read and
+// write them with generated code
+type protobufCodec struct{}
+
+// Name ...
+func (protobufCodec) Name() string { return "protobuf" }
+
+// Magic ...
+func (protobufCodec) Magic() []byte { return []byte("\x00pb") }
+
+// Marshal ...
+func (protobufCodec) Marshal(info *server.ServiceInfo) ([]byte, error) {
+	var b []byte
+	b = appendProtoString(b, 1, info.Name)
+	b = appendProtoString(b, 2, info.AppID)
+	b = appendProtoString(b, 3, info.Scheme)
+	b = appendProtoString(b, 4, info.Address)
+	if info.Weight != 0 {
+		b = protowire.AppendTag(b, 5, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(info.Weight))
+	}
+	b = appendProtoBool(b, 6, info.Enable)
+	b = appendProtoBool(b, 7, info.Healthy)
+	b = appendProtoStringMap(b, 8, info.Metadata)
+	b = appendProtoString(b, 9, info.Region)
+	b = appendProtoString(b, 10, info.Zone)
+	if info.Kind != 0 {
+		b = protowire.AppendTag(b, 11, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(info.Kind))
+	}
+	b = appendProtoString(b, 12, info.Deployment)
+	b = appendProtoString(b, 13, info.Group)
+	for _, key := range sortedKeys(len(info.Services), func(fn func(string)) {
+		for key := range info.Services {
+			fn(key)
+		}
+	}) {
+		var svc []byte
+		if s := info.Services[key]; s != nil {
+			svc = appendProtoString(svc, 1, s.Namespace)
+			svc = appendProtoString(svc, 2, s.Name)
+			svc = appendProtoStringMap(svc, 3, s.Labels)
+			for _, method := range s.Methods {
+				svc = protowire.AppendTag(svc, 4, protowire.BytesType)
+				svc = protowire.AppendString(svc, method)
+			}
+		}
+		entry := appendProtoString(nil, 1, key)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, svc)
+		b = protowire.AppendTag(b, 14, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	b = appendProtoStringMap(b, 15, info.Labels)
+	b = appendProtoString(b, 16, info.Status)
+	return b, nil
+}
+
+// Unmarshal ...
+func (protobufCodec) Unmarshal(data []byte, info *server.ServiceInfo) error {
+	*info = server.ServiceInfo{}
+	return consumeProtoFields(data, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 5 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			info.Weight = math.Float64frombits(v)
+			return n, nil
+		case (num == 6 || num == 7 || num == 11) && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			switch num {
+			case 6:
+				info.Enable = v != 0
+			case 7:
+				info.Healthy = v != 0
+			default:
+				info.Kind = constant.ServiceKind(v)
+			}
+			return n, nil
+		case typ != protowire.BytesType:
+			return -1, nil
+		}
+
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		var err error
+		switch num {
+		case 1:
+			info.Name = string(v)
+		case 2:
+			info.AppID = string(v)
+		case 3:
+			info.Scheme = string(v)
+		case 4:
+			info.Address = string(v)
+		case 8:
+			info.Metadata, err = consumeProtoStringEntry(info.Metadata, v)
+		case 9:
+			info.Region = string(v)
+		case 10:
+			info.Zone = string(v)
+		case 12:
+			info.Deployment = string(v)
+		case 13:
+			info.Group = string(v)
+		case 14:
+			err = consumeProtoServiceEntry(info, v)
+		case 15:
+			info.Labels, err = consumeProtoStringEntry(info.Labels, v)
+		case 16:
+			info.Status = string(v)
+		}
+		return n, err
+	})
+}
+
+// consumeProtoFields calls fn with each field of the message b, fn returns
+// the length of the value it consumed, or -1 to skip the value
+func consumeProtoFields(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		m, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if m == -1 {
+			m = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+		b = b[m:]
+	}
+	return nil
+}
+
+// consumeProtoEntry returns key and value of the map entry b
+func consumeProtoEntry(b []byte) (key string, value []byte, err error) {
+	err = consumeProtoFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if typ != protowire.BytesType {
+			return -1, nil
+		}
+		v, n := protowire.ConsumeBytes(b)
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			value = v
+		}
+		return n, nil
+	})
+	return
+}
+
+func consumeProtoStringEntry(m map[string]string, b []byte) (map[string]string, error) {
+	key, value, err := consumeProtoEntry(b)
+	if err != nil {
+		return m, err
+	}
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m[key] = string(value)
+	return m, nil
+}
+
+func consumeProtoServiceEntry(info *server.ServiceInfo, b []byte) error {
+	key, value, err := consumeProtoEntry(b)
+	if err != nil {
+		return err
+	}
+	var svc server.Service
+	err = consumeProtoFields(value, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if typ != protowire.BytesType {
+			return -1, nil
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		var err error
+		switch num {
+		case 1:
+			svc.Namespace = string(v)
+		case 2:
+			svc.Name = string(v)
+		case 3:
+			svc.Labels, err = consumeProtoStringEntry(svc.Labels, v)
+		case 4:
+			svc.Methods = append(svc.Methods, string(v))
+		}
+		return n, err
+	})
+	if err != nil {
+		return err
+	}
+	if info.Services == nil {
+		info.Services = make(map[string]*server.Service)
+	}
+	info.Services[key] = &svc
+	return nil
+}
+
+func appendProtoString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendProtoBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+// appendProtoStringMap appends entries of m in key order, so that the same
+// info is always encoded the same
+func appendProtoStringMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	for _, key := range sortedKeys(len(m), func(fn func(string)) {
+		for key := range m {
+			fn(key)
+		}
+	}) {
+		entry := appendProtoString(nil, 1, key)
+		entry = appendProtoString(entry, 2, m[key])
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+func sortedKeys(n int, each func(fn func(string))) []string {
+	var keys = make([]string, 0, n)
+	each(func(key string) { keys = append(keys, key) })
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecs(t *testing.T) {
+	info := &server.ServiceInfo{
+		Name:     "demo",
+		AppID:    "1001",
+		Scheme:   "grpc",
+		Address:  "127.0.0.1:9091",
+		Weight:   0.5,
+		Enable:   true,
+		Healthy:  true,
+		Metadata: map[string]string{"version": "v1", "pid": "42"},
+		Region:   "cn",
+		Zone:     "sh",
+		Kind:     constant.ServiceProvider,
+		Group:    "blue",
+		Services: map[string]*server.Service{
+			"helloworld.Greeter": {Namespace: "helloworld", Name: "Greeter", Methods: []string{"SayHello", "SayBye"}},
+		},
+		Labels: map[string]string{"env": "prod"},
+		Status: server.StatusMaintenance,
+	}
+	value, err := MarshalService(info, "json")
+	assert.Nil(t, err)
+
+	for _, name := range []string{"json", "protobuf", "msgpack"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := MarshalService(info, name)
+			assert.Nil(t, err)
+			if name != "json" {
+				assert.Less(t, len(data), len(value))
+			}
+
+			// codecs are detected by magic of values
+			var got server.ServiceInfo
+			assert.Nil(t, UnmarshalService(data, &got))
+			assert.Equal(t, info, &got)
+			assert.Equal(t, info, GetService(string(data)))
+
+			assert.NotNil(t, UnmarshalService(data[:len(data)-1], &got))
+		})
+	}
+
+	_, err = MarshalService(info, "xml")
+	assert.NotNil(t, err)
+
+	var got server.ServiceInfo
+	assert.NotNil(t, UnmarshalService([]byte("\x00xx"), &got))
+	assert.NotNil(t, UnmarshalService(nil, &got))
+}
+
+func TestUnmarshalServiceWith(t *testing.T) {
+	info := &server.ServiceInfo{Name: "demo", Scheme: "grpc", Address: "127.0.0.1:9091", Weight: 1}
+	for _, name := range []string{"protobuf", "msgpack"} {
+		t.Run(name, func(t *testing.T) {
+			c, _ := GetCodec(name)
+			plain, err := c.Marshal(info)
+			assert.Nil(t, err)
+			data, err := MarshalService(info, name)
+			assert.Nil(t, err)
+			js, err := MarshalService(info, "json")
+			assert.Nil(t, err)
+
+			// values without magic are decoded by the configured codec only
+			var got server.ServiceInfo
+			assert.NotNil(t, UnmarshalService(plain, &got))
+			for _, value := range [][]byte{plain, data, js} {
+				got = server.ServiceInfo{}
+				assert.Nil(t, UnmarshalServiceWith(value, name, &got))
+				assert.Equal(t, info, &got)
+			}
+			assert.NotNil(t, UnmarshalServiceWith(plain, "json", &got))
+		})
+	}
+}
+
+func TestMsgpackValues(t *testing.T) {
+	long := string(make([]byte, 300))
+	for _, c := range []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{nil, nil},
+		{true, true},
+		{json.Number("-1"), int64(-1)},
+		{json.Number("-100"), int64(-100)},
+		{json.Number("300"), int64(300)},
+		{json.Number("1099511627776"), int64(1 << 40)},
+		{json.Number("1.5"), 1.5},
+		{long, long},
+		{[]interface{}{json.Number("1"), "a"}, []interface{}{int64(1), "a"}},
+	} {
+		b, err := appendMsgpack(nil, c.in)
+		assert.Nil(t, err)
+		got, rest, err := consumeMsgpack(b)
+		assert.Nil(t, err)
+		assert.Empty(t, rest)
+		assert.Equal(t, c.want, got)
+	}
+}
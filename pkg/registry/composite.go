@@ -0,0 +1,309 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"golang.org/x/sync/errgroup"
+)
+
+// CompositeMode is how a composite registry combines its sources
+type CompositeMode string
+
+const (
+	// CompositeMerge watches the union of all sources, nodes of sources with
+	// higher priority win if they're registered to several sources
+	CompositeMerge CompositeMode = "merge"
+	// CompositeFailover watches the source with the highest priority which
+	// has nodes, e.g. falls back to the old registry during a migration until
+	// services are registered to the new one
+	CompositeFailover CompositeMode = "failover"
+)
+
+// CompositeSource is a registry wrapped by a composite registry
+type CompositeSource struct {
+	// Name of the source in logs, e.g. "etcd"
+	Name     string
+	Registry Registry
+	// Priority of the source, lower values are preferred
+	Priority int
+}
+
+// Composite wraps several registries, e.g. etcd as the primary one and
+// consul as the secondary one, services are registered to all of them and
+// watched with the mode of it
+type Composite struct {
+	mode    CompositeMode
+	sources []CompositeSource
+	logger  *xlog.Logger
+}
+
+// NewComposite ...
+func NewComposite(mode CompositeMode, sources ...CompositeSource) *Composite {
+	var ordered = make([]CompositeSource, len(sources))
+	copy(ordered, sources)
+	for i := range ordered {
+		if ordered[i].Name == "" {
+			ordered[i].Name = fmt.Sprintf("#%d", i)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Priority < ordered[j].Priority
+	})
+	return &Composite{
+		mode:    mode,
+		sources: ordered,
+		logger:  xlog.JupiterLogger.With(xlog.FieldMod("registry.composite")),
+	}
+}
+
+// RegisterService registers the service to all sources
+func (c *Composite) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	return c.each(func(source CompositeSource) error {
+		return source.Registry.RegisterService(ctx, info)
+	})
+}
+
+// RegisterServices registers the services to all sources, atomically within
+// each source that supports it
+func (c *Composite) RegisterServices(ctx context.Context, infos []*server.ServiceInfo) error {
+	return c.each(func(source CompositeSource) error {
+		return RegisterServices(ctx, source.Registry, infos)
+	})
+}
+
+// UnregisterService unregisters the service from all sources
+func (c *Composite) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	return c.each(func(source CompositeSource) error {
+		return source.Registry.UnregisterService(ctx, info)
+	})
+}
+
+// Close closes all sources
+func (c *Composite) Close() error {
+	return c.each(func(source CompositeSource) error {
+		return source.Registry.Close()
+	})
+}
+
+// CloseNow closes all sources without waiting for consumers
+func (c *Composite) CloseNow() error {
+	return c.each(func(source CompositeSource) error {
+		return CloseNow(source.Registry)
+	})
+}
+
+func (c *Composite) each(fn func(CompositeSource) error) error {
+	var eg errgroup.Group
+	for _, source := range c.sources {
+		source := source
+		eg.Go(func() error {
+			if err := fn(source); err != nil {
+				return fmt.Errorf("registry %s: %w", source.Name, err)
+			}
+			return nil
+		})
+	}
+	return eg.Wait()
+}
+
+// ListServices lists services of sources, it fails only if all sources fail
+func (c *Composite) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	var results = make([][]*server.ServiceInfo, len(c.sources))
+	var errs = make([]error, len(c.sources))
+	var eg errgroup.Group
+	for i, source := range c.sources {
+		i, source := i, source
+		eg.Go(func() error {
+			results[i], errs[i] = source.Registry.ListServices(ctx, name, scheme)
+			return nil
+		})
+	}
+	_ = eg.Wait()
+
+	var services = make([]*server.ServiceInfo, 0)
+	var seen = make(map[string]struct{})
+	var lastErr error
+	var succeeded bool
+	for i, infos := range results {
+		if errs[i] != nil {
+			c.logger.Warn("list services", xlog.FieldErr(errs[i]), xlog.String("source", c.sources[i].Name), xlog.FieldName(name))
+			lastErr = fmt.Errorf("registry %s: %w", c.sources[i].Name, errs[i])
+			continue
+		}
+		succeeded = true
+		if c.mode == CompositeFailover {
+			if len(infos) == 0 {
+				continue
+			}
+			return infos, nil
+		}
+		for _, info := range infos {
+			if _, ok := seen[info.Label()]; ok {
+				continue
+			}
+			seen[info.Label()] = struct{}{}
+			services = append(services, info)
+		}
+	}
+	if !succeeded && lastErr != nil {
+		return nil, lastErr
+	}
+	return services, nil
+}
+
+// errNoWatch is returned if no source could be watched
+var errNoWatch = errors.New("no registry to watch")
+
+type compositeUpdate struct {
+	idx       int
+	endpoints Endpoints
+}
+
+// WatchServices watches all sources and combines their endpoints with the
+// mode of c, sources failing to be watched are skipped
+func (c *Composite) WatchServices(ctx context.Context, name string, scheme string) (chan Endpoints, error) {
+	var snapshots = make([]*Endpoints, len(c.sources))
+	var updates = make(chan compositeUpdate, 10)
+	var watched int
+	var lastErr = errNoWatch
+	for i, source := range c.sources {
+		ch, err := source.Registry.WatchServices(ctx, name, scheme)
+		if err != nil {
+			c.logger.Warn("watch services", xlog.FieldErr(err), xlog.String("source", source.Name), xlog.FieldName(name), xlog.String("scheme", scheme))
+			lastErr = fmt.Errorf("registry %s: %w", source.Name, err)
+			continue
+		}
+		watched++
+		// registries send the initial snapshot before returning
+		select {
+		case endpoints := <-ch:
+			snapshots[i] = &endpoints
+		default:
+		}
+		i := i
+		xgo.Go(func() {
+			for {
+				select {
+				case endpoints, ok := <-ch:
+					if !ok {
+						return
+					}
+					select {
+					case updates <- compositeUpdate{idx: i, endpoints: endpoints}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+	if watched == 0 {
+		return nil, lastErr
+	}
+
+	var store = NewEndpointsStore()
+	var selected = c.selected(snapshots)
+	store.Set(c.combine(snapshots, selected))
+
+	xgo.Go(func() {
+		defer store.Close()
+		for {
+			select {
+			case update := <-updates:
+				snapshots[update.idx] = &update.endpoints
+				prev := selected
+				selected = c.selected(snapshots)
+				if c.mode == CompositeFailover {
+					if prev != selected {
+						c.logger.Info("registry failover", xlog.FieldName(name), xlog.String("scheme", scheme), xlog.String("from", c.sourceName(prev)), xlog.String("to", c.sourceName(selected)))
+					} else if update.idx != selected {
+						continue
+					}
+				}
+				store.Set(c.combine(snapshots, selected))
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return StreamEndpoints(ctx, store), nil
+}
+
+// selected returns the index of the source with the highest priority which
+// has nodes, or else the one which has reported, -1 if none has reported
+func (c *Composite) selected(snapshots []*Endpoints) int {
+	var reported = -1
+	for i, snapshot := range snapshots {
+		if snapshot == nil {
+			continue
+		}
+		if snapshot.Nodes.Len() > 0 {
+			return i
+		}
+		if reported < 0 {
+			reported = i
+		}
+	}
+	return reported
+}
+
+func (c *Composite) sourceName(idx int) string {
+	if idx < 0 {
+		return ""
+	}
+	return c.sources[idx].Name
+}
+
+func (c *Composite) combine(snapshots []*Endpoints, selected int) Endpoints {
+	if c.mode == CompositeFailover && selected >= 0 {
+		return *snapshots[selected]
+	}
+	var out = Endpoints{
+		RouteConfigs:    make(map[string]RouteConfig),
+		ConsumerConfigs: make(map[string]ConsumerConfig),
+		ProviderConfigs: make(map[string]ProviderConfig),
+	}
+	return out.Update(func(tx *EndpointsTx) {
+		// sources with higher priority overwrite the others
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			if snapshots[i] == nil {
+				continue
+			}
+			snapshots[i].Nodes.Range(func(addr string, info server.ServiceInfo) bool {
+				tx.SetNode(addr, info)
+				return true
+			})
+			for key, config := range snapshots[i].RouteConfigs {
+				tx.SetRouteConfig(key, config)
+			}
+			for key, config := range snapshots[i].ConsumerConfigs {
+				tx.SetConsumerConfig(key, config)
+			}
+			for key, config := range snapshots[i].ProviderConfigs {
+				tx.SetProviderConfig(key, config)
+			}
+		}
+	})
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRegistry struct {
+	Nop
+	services   []*server.ServiceInfo
+	err        error
+	watch      chan Endpoints
+	registered []*server.ServiceInfo
+}
+
+func newFakeRegistry(addrs ...string) *fakeRegistry {
+	reg := &fakeRegistry{watch: make(chan Endpoints, 10)}
+	for _, addr := range addrs {
+		reg.services = append(reg.services, &server.ServiceInfo{Name: "svc", Scheme: "grpc", Address: addr})
+	}
+	reg.push(addrs...)
+	return reg
+}
+
+func (reg *fakeRegistry) push(addrs ...string) {
+	var nodes = make(map[string]server.ServiceInfo)
+	for _, addr := range addrs {
+		nodes[addr] = server.ServiceInfo{Name: "svc", Scheme: "grpc", Address: addr}
+	}
+	reg.watch <- Endpoints{Nodes: NewNodes(nodes)}
+}
+
+func (reg *fakeRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	reg.registered = append(reg.registered, info)
+	return reg.err
+}
+
+func (reg *fakeRegistry) ListServices(context.Context, string, string) ([]*server.ServiceInfo, error) {
+	return reg.services, reg.err
+}
+
+func (reg *fakeRegistry) WatchServices(context.Context, string, string) (chan Endpoints, error) {
+	if reg.err != nil {
+		return nil, reg.err
+	}
+	return reg.watch, nil
+}
+
+func nodeAddrs(endpoints Endpoints) []string {
+	var addrs []string
+	endpoints.Nodes.Range(func(addr string, info server.ServiceInfo) bool {
+		addrs = append(addrs, addr)
+		return true
+	})
+	return addrs
+}
+
+func recvEndpoints(t *testing.T, ch chan Endpoints) Endpoints {
+	select {
+	case endpoints := <-ch:
+		return endpoints
+	case <-time.After(time.Second):
+		t.Fatal("no endpoints")
+		return Endpoints{}
+	}
+}
+
+// waitEndpoints receives endpoints until nodes of them are sorted addrs, updates of
+// sources are forwarded by separate goroutines and may be reordered
+func waitEndpoints(t *testing.T, ch chan Endpoints, addrs ...string) {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case endpoints := <-ch:
+			got := nodeAddrs(endpoints)
+			sort.Strings(got)
+			if reflect.DeepEqual(got, addrs) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no endpoints of %v", addrs)
+		}
+	}
+}
+
+func TestComposite_Register(t *testing.T) {
+	primary, secondary := newFakeRegistry(), newFakeRegistry()
+	secondary.err = errors.New("unavailable")
+	c := NewComposite(CompositeFailover, CompositeSource{Name: "etcd", Registry: primary}, CompositeSource{Name: "consul", Registry: secondary, Priority: 1})
+
+	info := &server.ServiceInfo{Name: "svc", Address: "127.0.0.1:9091"}
+	err := c.RegisterService(context.Background(), info)
+	assert.EqualError(t, err, "registry consul: unavailable")
+	assert.Equal(t, []*server.ServiceInfo{info}, primary.registered)
+	assert.Equal(t, []*server.ServiceInfo{info}, secondary.registered)
+}
+
+func TestComposite_ListServices(t *testing.T) {
+	primary := newFakeRegistry("10.0.0.1:80")
+	secondary := newFakeRegistry("10.0.0.1:80", "10.0.0.2:80")
+	sources := []CompositeSource{{Name: "consul", Registry: secondary, Priority: 1}, {Name: "etcd", Registry: primary}}
+
+	services, err := NewComposite(CompositeMerge, sources...).ListServices(context.Background(), "svc", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 2)
+	assert.Same(t, primary.services[0], services[0])
+
+	services, err = NewComposite(CompositeFailover, sources...).ListServices(context.Background(), "svc", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, primary.services, services)
+
+	// fails over to the secondary one
+	primary.err = errors.New("unavailable")
+	services, err = NewComposite(CompositeFailover, sources...).ListServices(context.Background(), "svc", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, secondary.services, services)
+
+	secondary.err = errors.New("unavailable")
+	_, err = NewComposite(CompositeMerge, sources...).ListServices(context.Background(), "svc", "grpc")
+	assert.NotNil(t, err)
+}
+
+func TestComposite_WatchMerge(t *testing.T) {
+	primary := newFakeRegistry("10.0.0.1:80")
+	secondary := newFakeRegistry("10.0.0.2:80")
+	c := NewComposite(CompositeMerge, CompositeSource{Registry: primary}, CompositeSource{Registry: secondary, Priority: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := c.WatchServices(ctx, "svc", "grpc")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, nodeAddrs(recvEndpoints(t, ch)))
+
+	secondary.push("10.0.0.2:80", "10.0.0.3:80")
+	assert.ElementsMatch(t, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80"}, nodeAddrs(recvEndpoints(t, ch)))
+}
+
+func TestComposite_WatchFailover(t *testing.T) {
+	primary := newFakeRegistry("10.0.0.1:80")
+	secondary := newFakeRegistry("10.0.0.2:80")
+	broken := newFakeRegistry()
+	broken.err = errors.New("unavailable")
+	c := NewComposite(CompositeFailover,
+		CompositeSource{Name: "consul", Registry: secondary, Priority: 1},
+		CompositeSource{Name: "etcd", Registry: primary},
+		CompositeSource{Name: "zk", Registry: broken, Priority: 2},
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := c.WatchServices(ctx, "svc", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1:80"}, nodeAddrs(recvEndpoints(t, ch)))
+
+	// updates of the standby source are not sent
+	secondary.push("10.0.0.2:80", "10.0.0.3:80")
+	primary.push("10.0.0.1:80", "10.0.0.4:80")
+	assert.ElementsMatch(t, []string{"10.0.0.1:80", "10.0.0.4:80"}, nodeAddrs(recvEndpoints(t, ch)))
+
+	// fails over once the primary one has no nodes, and back once it has
+	primary.push()
+	waitEndpoints(t, ch, "10.0.0.2:80", "10.0.0.3:80")
+	primary.push("10.0.0.1:80")
+	waitEndpoints(t, ch, "10.0.0.1:80")
+
+	_, err = NewComposite(CompositeFailover, CompositeSource{Registry: broken}).WatchServices(ctx, "svc", "grpc")
+	assert.NotNil(t, err)
+}
+
+type failingRegistry struct {
+	Nop
+	fails        int
+	registered   []string
+	unregistered []string
+}
+
+func (reg *failingRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	if len(reg.registered) == reg.fails {
+		return errors.New("register")
+	}
+	reg.registered = append(reg.registered, info.Address)
+	return nil
+}
+
+func (reg *failingRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	reg.unregistered = append(reg.unregistered, info.Address)
+	return nil
+}
+
+func TestRegisterServices(t *testing.T) {
+	infos := []*server.ServiceInfo{{Address: "127.0.0.1:1"}, {Address: "127.0.0.1:2"}}
+
+	reg := &failingRegistry{fails: 2}
+	assert.Nil(t, RegisterServices(context.Background(), reg, infos))
+	assert.Equal(t, []string{"127.0.0.1:1", "127.0.0.1:2"}, reg.registered)
+	assert.Empty(t, reg.unregistered)
+
+	// the registered one is rolled back
+	reg = &failingRegistry{fails: 1}
+	assert.NotNil(t, RegisterServices(context.Background(), reg, infos))
+	assert.Equal(t, []string{"127.0.0.1:1"}, reg.unregistered)
+
+	composite := NewComposite(CompositeMerge, CompositeSource{Name: "a", Registry: &failingRegistry{fails: 2}})
+	assert.Nil(t, RegisterServices(context.Background(), composite, infos))
+}
+
+type drainingRegistry struct {
+	Nop
+	closed, closedNow bool
+}
+
+func (reg *drainingRegistry) Close() error {
+	reg.closed = true
+	return nil
+}
+
+func (reg *drainingRegistry) CloseNow() error {
+	reg.closedNow = true
+	return nil
+}
+
+func TestCloseNow(t *testing.T) {
+	reg := &drainingRegistry{}
+	composite := NewComposite(CompositeMerge, CompositeSource{Name: "a", Registry: reg}, CompositeSource{Name: "b", Registry: Nop{}})
+	assert.Nil(t, CloseNow(composite))
+	assert.True(t, reg.closedNow)
+	assert.False(t, reg.closed)
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Compressor compresses large registry values. The output of Compress must
+// start with Magic, so that readers detect compressed values by themselves
+// and old readers keep working with uncompressed ones.
+type Compressor interface {
+	Name() string
+	Magic() []byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var compressors sync.Map // name => Compressor
+
+func init() {
+	RegisterCompressor(gzipCompressor{})
+	RegisterCompressor(snappyCompressor{})
+}
+
+// RegisterCompressor registers a compressor, whose name is referred by
+// configs of registries, e.g. "gzip" or "snappy".
+func RegisterCompressor(c Compressor) {
+	compressors.Store(c.Name(), c)
+}
+
+// GetCompressor returns the compressor registered with name
+func GetCompressor(name string) (Compressor, bool) {
+	c, ok := compressors.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return c.(Compressor), true
+}
+
+// EncodeValue compresses data with the named compressor if it's no shorter
+// than threshold, data is returned as is if name is empty.
+func EncodeValue(data []byte, name string, threshold int) ([]byte, error) {
+	if name == "" || len(data) < threshold {
+		return data, nil
+	}
+	c, ok := GetCompressor(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown compressor %q", name)
+	}
+	return c.Compress(data)
+}
+
+// DecodeValue decompresses data encoded by EncodeValue, uncompressed json is
+// returned as is.
+func DecodeValue(data []byte) ([]byte, error) {
+	if len(data) == 0 || data[0] == '{' || data[0] == '[' {
+		return data, nil
+	}
+	var ret []byte
+	var err error
+	var found bool
+	compressors.Range(func(_, v interface{}) bool {
+		c := v.(Compressor)
+		if !bytes.HasPrefix(data, c.Magic()) {
+			return true
+		}
+		found = true
+		ret, err = c.Decompress(data)
+		return false
+	})
+	if !found {
+		return data, nil
+	}
+	return ret, err
+}
+
+type gzipCompressor struct{}
+
+// Name ...
+func (gzipCompressor) Name() string { return "gzip" }
+
+// Magic ...
+func (gzipCompressor) Magic() []byte { return []byte{0x1f, 0x8b} }
+
+// Compress ...
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress ...
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// snappyCompressor uses the framing format, whose stream identifier serves
+// as magic. It's faster than gzip at the cost of ratio.
+type snappyCompressor struct{}
+
+// snappyMagic is the stream identifier chunk of the framing format
+var snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+
+// Name ...
+func (snappyCompressor) Name() string { return "snappy" }
+
+// Magic ...
+func (snappyCompressor) Magic() []byte { return snappyMagic }
+
+// Compress ...
+func (snappyCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := snappy.NewBufferedWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress ...
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	return ioutil.ReadAll(snappy.NewReader(bytes.NewReader(data)))
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeValue(t *testing.T) {
+	info := &server.ServiceInfo{
+		Name:     "demo",
+		Address:  "127.0.0.1:9091",
+		Metadata: map[string]string{"desc": string(bytes.Repeat([]byte("metadata"), 128))},
+	}
+	data := []byte(GetServiceValue(info))
+
+	// below threshold
+	val, err := EncodeValue(data, "gzip", len(data)+1)
+	assert.Nil(t, err)
+	assert.Equal(t, data, val)
+
+	val, err = EncodeValue(data, "gzip", 1024)
+	assert.Nil(t, err)
+	assert.True(t, len(val) < len(data))
+
+	decoded, err := DecodeValue(val)
+	assert.Nil(t, err)
+	assert.Equal(t, info, GetService(string(decoded)))
+
+	// uncompressed values pass through
+	decoded, err = DecodeValue(data)
+	assert.Nil(t, err)
+	assert.Equal(t, data, decoded)
+
+	_, err = EncodeValue(data, "unknown", 0)
+	assert.NotNil(t, err)
+}
+
+func TestEncodeValue_Snappy(t *testing.T) {
+	data := []byte(`{"name":"demo","metadata":{"desc":"` + string(bytes.Repeat([]byte("metadata"), 128)) + `"}}`)
+	val, err := EncodeValue(data, "snappy", 1024)
+	assert.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(val, snappyMagic))
+	assert.True(t, len(val) < len(data))
+
+	decoded, err := DecodeValue(val)
+	assert.Nil(t, err)
+	assert.Equal(t, data, decoded)
+}
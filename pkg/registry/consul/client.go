@@ -0,0 +1,138 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// client is a minimal client of the consul agent http api
+type client struct {
+	config *Config
+	http   *http.Client
+}
+
+// agentService is the body of /v1/agent/service/register
+type agentService struct {
+	ID      string
+	Name    string
+	Tags    []string          `json:",omitempty"`
+	Address string            `json:",omitempty"`
+	Port    int               `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Check   *agentCheck       `json:",omitempty"`
+}
+
+type agentCheck struct {
+	CheckID                        string `json:",omitempty"`
+	TTL                            string `json:",omitempty"`
+	TCP                            string `json:",omitempty"`
+	GRPC                           string `json:",omitempty"`
+	Interval                       string `json:",omitempty"`
+	DeregisterCriticalServiceAfter string `json:",omitempty"`
+}
+
+// serviceEntry is an entry of /v1/health/service/:name
+type serviceEntry struct {
+	Service struct {
+		ID      string
+		Service string
+		Tags    []string
+		Address string
+		Port    int
+		Meta    map[string]string
+	}
+}
+
+func (c *client) register(ctx context.Context, service *agentService) error {
+	return c.do(ctx, http.MethodPut, "/v1/agent/service/register", nil, service, nil)
+}
+
+func (c *client) deregister(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(id), nil, nil, nil)
+}
+
+func (c *client) passTTL(ctx context.Context, checkID string) error {
+	return c.do(ctx, http.MethodPut, "/v1/agent/check/pass/"+url.PathEscape(checkID), nil, nil, nil)
+}
+
+// healthService lists passing instances of service with tag, it blocks
+// until the index changes or wait elapses if index is positive
+func (c *client) healthService(ctx context.Context, service, tag string, index uint64, wait time.Duration) ([]serviceEntry, uint64, error) {
+	query := url.Values{"passing": {"true"}}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", fmt.Sprintf("%dms", wait.Milliseconds()))
+	}
+	var entries []serviceEntry
+	var header http.Header
+	err := c.do(ctx, http.MethodGet, "/v1/health/service/"+url.PathEscape(service), query, nil, func(resp *http.Response) error {
+		header = resp.Header
+		return json.NewDecoder(resp.Body).Decode(&entries)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	newIndex, _ := strconv.ParseUint(header.Get("X-Consul-Index"), 10, 64)
+	return entries, newIndex, nil
+}
+
+func (c *client) do(ctx context.Context, method, path string, query url.Values, in interface{}, out func(*http.Response) error) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.config.Datacenter != "" {
+		query.Set("dc", c.config.Datacenter)
+	}
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	u := url.URL{Scheme: c.config.Scheme, Host: c.config.Address, Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.config.Token != "" {
+		req.Header.Set("X-Consul-Token", c.config.Token)
+	}
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("consul %s %s: %d %s", method, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		return out(resp)
+	}
+	return nil
+}
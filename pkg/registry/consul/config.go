@@ -0,0 +1,132 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/registry/naming"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("registry.consul", "jupiter.registry.*", "consul registry", DefaultConfig)
+}
+
+const (
+	// CheckTTL keeps services passing with heartbeats from the registry,
+	// the counterpart of etcd leases
+	CheckTTL = "ttl"
+	// CheckAuto lets consul agents probe services, gRPC health for grpc
+	// services and TCP for the others
+	CheckAuto = "auto"
+)
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.registry." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Panic("unmarshal key", xlog.FieldMod("registry.consul"), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Address:         "127.0.0.1:8500",
+		Scheme:          "http",
+		Timeout:         time.Second * 3,
+		Check:           CheckTTL,
+		CheckTTL:        time.Second * 15,
+		CheckInterval:   time.Second * 10,
+		DeregisterAfter: time.Minute,
+		WaitTime:        time.Second * 30,
+		Backoff:         xbackoff.DefaultConfig(),
+		logger:          xlog.JupiterLogger.With(xlog.FieldMod("registry.consul")),
+		clock:           xtime.SystemClock,
+	}
+}
+
+// Config ...
+type Config struct {
+	// Address of consul agent
+	Address string
+	// Scheme of consul agent, http or https
+	Scheme     string
+	Token      string
+	Datacenter string
+	// Timeout of requests other than blocking queries
+	Timeout time.Duration
+	// Check is the health check of services, "ttl" or "auto"
+	Check string
+	// CheckTTL of "ttl" checks, heartbeats are sent every third of it
+	CheckTTL time.Duration
+	// CheckInterval of "auto" checks
+	CheckInterval time.Duration
+	// DeregisterAfter removes services critical for so long
+	DeregisterAfter time.Duration
+	// WaitTime of blocking queries watching services
+	WaitTime time.Duration
+	// Backoff of registration retries and watch errors
+	Backoff xbackoff.Config
+	// NameSuffix scopes service names by environment, e.g. "gray" registers
+	// and subscribes "app.gray" for "app"
+	NameSuffix string
+	// Aliases maps a service name to its alias names, services register under
+	// all names of an alias group and clients subscribe to the whole group
+	Aliases map[string][]string
+
+	logger *xlog.Logger
+	clock  xtime.Clock
+}
+
+// Build ...
+func (config Config) Build() registry.Registry {
+	if config.Check != CheckTTL && config.Check != CheckAuto {
+		config.logger.Panic("unknown consul check", xlog.String("check", config.Check))
+	}
+	var reg registry.Registry = newConsulRegistry(&config)
+	if strategy := config.naming(); strategy != nil {
+		reg = naming.New(reg, strategy)
+	}
+	return reg
+}
+
+func (config *Config) naming() naming.Strategy {
+	var strategies []naming.Strategy
+	if len(config.Aliases) > 0 {
+		strategies = append(strategies, naming.Alias(config.Aliases))
+	}
+	if config.NameSuffix != "" {
+		strategies = append(strategies, naming.EnvSuffix(config.NameSuffix))
+	}
+	if len(strategies) == 0 {
+		return nil
+	}
+	return naming.Chain(strategies...)
+}
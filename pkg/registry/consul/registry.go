@@ -0,0 +1,404 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// metaPrefix prefixes keys of ServiceInfo.Metadata in consul service meta
+const metaPrefix = "md_"
+
+// labelPrefix prefixes keys of ServiceInfo.Labels in consul service meta
+const labelPrefix = "label_"
+
+type consulRegistry struct {
+	*Config
+	client *client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	services map[string]*registration
+}
+
+type registration struct {
+	service *agentService
+	cancel  context.CancelFunc
+}
+
+var _ registry.Registry = &consulRegistry{}
+
+func newConsulRegistry(config *Config) *consulRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &consulRegistry{
+		Config:   config,
+		client:   &client{config: config, http: &http.Client{}},
+		ctx:      ctx,
+		cancel:   cancel,
+		services: make(map[string]*registration),
+	}
+}
+
+// RegisterService registers service to the consul agent, failed
+// registration is retried with jittered backoff
+func (reg *consulRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	service, err := reg.toService(info)
+	if err != nil {
+		return err
+	}
+	if err := reg.register(ctx, service); err != nil {
+		reg.logger.Error("register service", xlog.FieldErr(err), xlog.FieldKey(service.ID), xlog.FieldValueAny(info))
+		return err
+	}
+
+	hbCtx, cancel := context.WithCancel(reg.ctx)
+	reg.mu.Lock()
+	if prev, ok := reg.services[service.ID]; ok {
+		prev.cancel()
+	}
+	reg.services[service.ID] = &registration{service: service, cancel: cancel}
+	reg.mu.Unlock()
+
+	if reg.Check == CheckTTL {
+		xgo.Go(func() { reg.heartbeat(hbCtx, service) })
+	}
+	reg.logger.Info("register service", xlog.FieldKey(service.ID), xlog.FieldValueAny(info))
+	return nil
+}
+
+func (reg *consulRegistry) register(ctx context.Context, service *agentService) (err error) {
+	start := time.Now()
+	defer func() { registry.ObserveOperation("consul", "register", start, err) }()
+	return xbackoff.Retry(ctx, reg.Backoff, reg.clock, func() error {
+		reqCtx, cancel := context.WithTimeout(ctx, reg.Timeout)
+		defer cancel()
+		if err := reg.client.register(reqCtx, service); err != nil {
+			return err
+		}
+		if reg.Check == CheckTTL {
+			return reg.client.passTTL(reqCtx, service.Check.CheckID)
+		}
+		return nil
+	})
+}
+
+// heartbeat keeps the ttl check passing, the service is registered again if
+// the agent lost it, e.g. after a restart of the agent
+func (reg *consulRegistry) heartbeat(ctx context.Context, service *agentService) {
+	ticker := reg.clock.NewTicker(reg.CheckTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, reg.Timeout)
+		err := reg.client.passTTL(reqCtx, service.Check.CheckID)
+		cancel()
+		if ctx.Err() != nil {
+			continue
+		}
+		registry.ObserveLeaseRenewal("consul", err)
+		if err == nil {
+			continue
+		}
+		reg.logger.Warn("consul ttl heartbeat", xlog.FieldErr(err), xlog.FieldKey(service.ID))
+		if err := reg.register(ctx, service); err != nil && ctx.Err() == nil {
+			reg.logger.Error("register service again", xlog.FieldErr(err), xlog.FieldKey(service.ID))
+		}
+	}
+}
+
+// UnregisterService deregisters service from the consul agent
+func (reg *consulRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	return reg.unregister(ctx, serviceID(info))
+}
+
+func (reg *consulRegistry) unregister(ctx context.Context, id string) (err error) {
+	start := time.Now()
+	defer func() { registry.ObserveOperation("consul", "unregister", start, err) }()
+	reg.mu.Lock()
+	if r, ok := reg.services[id]; ok {
+		r.cancel()
+		delete(reg.services, id)
+	}
+	reg.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, reg.Timeout)
+	defer cancel()
+	return reg.client.deregister(ctx, id)
+}
+
+// ListServices lists passing services of name and scheme
+func (reg *consulRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, reg.Timeout)
+	defer cancel()
+	entries, _, err := reg.client.healthService(ctx, name, scheme, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	var services = make([]*server.ServiceInfo, 0, len(entries))
+	for _, entry := range entries {
+		info := toServiceInfo(entry, scheme)
+		if info.Kind != constant.ServiceProvider {
+			continue
+		}
+		services = append(services, &info)
+	}
+	return registry.FilterServices(ctx, services)
+}
+
+// WatchServices watches passing services of name and scheme with blocking
+// queries. Only nodes are watched, route and provider configs of etcd
+// configurators are not supported by consul.
+func (reg *consulRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	store, err := reg.WatchSnapshots(ctx, name, scheme)
+	if err != nil {
+		return nil, err
+	}
+	return registry.StreamEndpoints(ctx, store), nil
+}
+
+// WatchSnapshots watches passing services of name and scheme into a store,
+// which is closed once ctx is done or the registry is closed
+func (reg *consulRegistry) WatchSnapshots(ctx context.Context, name string, scheme string) (*registry.EndpointsStore, error) {
+	if _, err := registry.LabelSelectorFromContext(ctx); err != nil {
+		return nil, err
+	}
+	entries, index, err := reg.client.healthService(ctx, name, scheme, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var store = registry.NewEndpointsStore()
+	store.Update(func(tx *registry.EndpointsTx) {
+		updateNodes(tx, nil, scheme, entries)
+	})
+
+	xgo.Go(func() {
+		defer store.Close()
+		ctx, cancel := reg.watchContext(ctx)
+		defer cancel()
+		var retries int
+		for ctx.Err() == nil {
+			entries, newIndex, err := reg.client.healthService(ctx, name, scheme, index, reg.WaitTime)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				reg.logger.Error("watch services", xlog.FieldErr(err), xlog.String("name", name), xlog.String("scheme", scheme))
+				select {
+				case <-reg.clock.After(reg.Backoff.Backoff(retries)):
+				case <-ctx.Done():
+				}
+				retries++
+				continue
+			}
+			retries = 0
+			// the index may go backwards, e.g. after a restart of consul servers
+			if newIndex < index {
+				newIndex = 0
+			}
+			if newIndex == index {
+				continue
+			}
+			index = newIndex
+
+			// 基于上一版本生成新快照, 未变更的部分共享
+			store.Update(func(tx *registry.EndpointsTx) {
+				updateNodes(tx, tx.PrevNodes(), scheme, entries)
+			})
+		}
+	})
+	return registry.FilterStore(ctx, store)
+}
+
+// watchContext is done once ctx is done or the registry is closed
+func (reg *consulRegistry) watchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	xgo.Go(func() {
+		select {
+		case <-reg.ctx.Done():
+		case <-watchCtx.Done():
+		}
+		cancel()
+	})
+	return watchCtx, cancel
+}
+
+// Close deregisters all services registered by the registry
+func (reg *consulRegistry) Close() error {
+	reg.mu.Lock()
+	var ids = make([]string, 0, len(reg.services))
+	for id := range reg.services {
+		ids = append(ids, id)
+	}
+	reg.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := reg.unregister(context.Background(), id); err != nil {
+				reg.logger.Error("unregister service", xlog.FieldErr(err), xlog.FieldKey(id))
+			} else {
+				reg.logger.Info("unregister service", xlog.FieldKey(id))
+			}
+		}(id)
+	}
+	wg.Wait()
+	reg.cancel()
+	return nil
+}
+
+// updateNodes replaces nodes of tx with entries
+func updateNodes(tx *registry.EndpointsTx, prev *registry.Nodes, scheme string, entries []serviceEntry) {
+	var seen = make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		info := toServiceInfo(entry, scheme)
+		if info.Kind != constant.ServiceProvider {
+			continue
+		}
+		tx.SetNode(info.Label(), info)
+		seen[info.Label()] = struct{}{}
+	}
+	if prev == nil {
+		return
+	}
+	prev.Range(func(addr string, _ server.ServiceInfo) bool {
+		if _, ok := seen[addr]; !ok {
+			tx.DeleteNode(addr)
+		}
+		return true
+	})
+}
+
+func serviceID(info *server.ServiceInfo) string {
+	return fmt.Sprintf("%s-%s-%s", info.Name, info.Scheme, info.Address)
+}
+
+// toService maps info to a consul service, fields of info are kept in meta
+func (reg *consulRegistry) toService(info *server.ServiceInfo) (*agentService, error) {
+	host, portStr, err := net.SplitHostPort(info.Address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta = map[string]string{
+		"scheme":     info.Scheme,
+		"appId":      info.AppID,
+		"weight":     strconv.FormatFloat(info.Weight, 'f', -1, 64),
+		"enable":     strconv.FormatBool(info.Enable),
+		"healthy":    strconv.FormatBool(info.Healthy),
+		"region":     info.Region,
+		"zone":       info.Zone,
+		"kind":       strconv.Itoa(int(info.Kind)),
+		"deployment": info.Deployment,
+		"group":      info.Group,
+		"status":     info.Status,
+	}
+	for k, v := range info.Metadata {
+		meta[metaPrefix+k] = v
+	}
+	for k, v := range info.Labels {
+		meta[labelPrefix+k] = v
+	}
+
+	service := &agentService{
+		ID:      serviceID(info),
+		Name:    info.Name,
+		Tags:    []string{info.Scheme, info.Kind.String()},
+		Address: host,
+		Port:    port,
+		Meta:    meta,
+	}
+	check := &agentCheck{DeregisterCriticalServiceAfter: reg.DeregisterAfter.String()}
+	switch {
+	case reg.Check == CheckTTL:
+		check.CheckID = "service:" + service.ID
+		check.TTL = reg.CheckTTL.String()
+	case info.Scheme == "grpc":
+		check.GRPC = info.Address
+		check.Interval = reg.CheckInterval.String()
+	default:
+		check.TCP = info.Address
+		check.Interval = reg.CheckInterval.String()
+	}
+	service.Check = check
+	return service, nil
+}
+
+// toServiceInfo maps a consul service of scheme back to info
+func toServiceInfo(entry serviceEntry, scheme string) server.ServiceInfo {
+	service := entry.Service
+	meta := service.Meta
+	info := server.ServiceInfo{
+		Name:       service.Service,
+		AppID:      meta["appId"],
+		Scheme:     scheme,
+		Address:    net.JoinHostPort(service.Address, strconv.Itoa(service.Port)),
+		Region:     meta["region"],
+		Zone:       meta["zone"],
+		Deployment: meta["deployment"],
+		Group:      meta["group"],
+		Status:     meta["status"],
+		Enable:     meta["enable"] != "false",
+		Healthy:    meta["healthy"] != "false",
+		Metadata:   make(map[string]string),
+	}
+	// services registered without weights have the default one
+	info.Weight = 100
+	if weight, err := strconv.ParseFloat(meta["weight"], 64); err == nil {
+		info.Weight = weight
+	}
+	// services registered by others are treated as providers
+	info.Kind = constant.ServiceProvider
+	if kind, err := strconv.Atoi(meta["kind"]); err == nil {
+		info.Kind = constant.ServiceKind(kind)
+	}
+	for k, v := range meta {
+		if strings.HasPrefix(k, metaPrefix) {
+			info.Metadata[strings.TrimPrefix(k, metaPrefix)] = v
+		}
+		if strings.HasPrefix(k, labelPrefix) {
+			if info.Labels == nil {
+				info.Labels = make(map[string]string)
+			}
+			info.Labels[strings.TrimPrefix(k, labelPrefix)] = v
+		}
+	}
+	return info
+}
@@ -0,0 +1,211 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAgent serves the consul agent api used by the registry
+type fakeAgent struct {
+	*httptest.Server
+	mu       sync.Mutex
+	changed  chan struct{}
+	index    uint64
+	services map[string]*agentService
+	passes   map[string]int
+}
+
+func newFakeAgent(t *testing.T) *fakeAgent {
+	agent := &fakeAgent{
+		changed:  make(chan struct{}),
+		index:    1,
+		services: make(map[string]*agentService),
+		passes:   make(map[string]int),
+	}
+	agent.Server = httptest.NewServer(http.HandlerFunc(agent.serve))
+	t.Cleanup(agent.Close)
+	return agent
+}
+
+func (a *fakeAgent) serve(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	switch path := r.URL.Path; {
+	case path == "/v1/agent/service/register":
+		var service agentService
+		_ = json.NewDecoder(r.Body).Decode(&service)
+		a.services[service.ID] = &service
+		a.bump()
+	case strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		delete(a.services, strings.TrimPrefix(path, "/v1/agent/service/deregister/"))
+		a.bump()
+	case strings.HasPrefix(path, "/v1/agent/check/pass/service:"):
+		id := strings.TrimPrefix(path, "/v1/agent/check/pass/service:")
+		if _, ok := a.services[id]; !ok {
+			a.mu.Unlock()
+			http.Error(w, "unknown check", http.StatusNotFound)
+			return
+		}
+		a.passes[id]++
+	case strings.HasPrefix(path, "/v1/health/service/"):
+		index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		for index == a.index {
+			changed := a.changed
+			a.mu.Unlock()
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			a.mu.Lock()
+		}
+		var entries = make([]serviceEntry, 0)
+		for _, service := range a.services {
+			if service.Name != strings.TrimPrefix(path, "/v1/health/service/") || service.Tags[0] != r.URL.Query().Get("tag") {
+				continue
+			}
+			var entry serviceEntry
+			entry.Service.ID, entry.Service.Service = service.ID, service.Name
+			entry.Service.Tags, entry.Service.Meta = service.Tags, service.Meta
+			entry.Service.Address, entry.Service.Port = service.Address, service.Port
+			entries = append(entries, entry)
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(a.index, 10))
+		_ = json.NewEncoder(w).Encode(entries)
+	}
+	a.mu.Unlock()
+}
+
+// bump is called with mu held
+func (a *fakeAgent) bump() {
+	a.index++
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+func (a *fakeAgent) lose(id string) {
+	a.mu.Lock()
+	delete(a.services, id)
+	a.bump()
+	a.mu.Unlock()
+}
+
+func (a *fakeAgent) passed(id string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.passes[id]
+}
+
+func newTestRegistry(agent *fakeAgent, clock xtime.Clock) *consulRegistry {
+	config := DefaultConfig()
+	config.Address = strings.TrimPrefix(agent.URL, "http://")
+	config.clock = clock
+	return newConsulRegistry(config)
+}
+
+func newInfo(addr string) *server.ServiceInfo {
+	return &server.ServiceInfo{
+		Name:     "user",
+		Scheme:   "grpc",
+		Address:  addr,
+		Weight:   100,
+		Enable:   true,
+		Healthy:  true,
+		Kind:     constant.ServiceProvider,
+		Zone:     "z1",
+		Metadata: map[string]string{"version": "v1"},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	agent := newFakeAgent(t)
+	clock := xtime.NewMockClock(time.Now())
+	reg := newTestRegistry(agent, clock)
+	ctx := context.Background()
+
+	assert.Nil(t, reg.RegisterService(ctx, newInfo("10.0.0.1:9091")))
+	governor := newInfo("10.0.0.1:9990")
+	governor.Kind = constant.ServiceGovernor
+	assert.Nil(t, reg.RegisterService(ctx, governor))
+
+	services, err := reg.ListServices(ctx, "user", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1, "governors are not providers")
+	assert.Equal(t, newInfo("10.0.0.1:9091"), services[0])
+	agent.mu.Lock()
+	assert.Equal(t, "15s", agent.services[serviceID(services[0])].Check.TTL)
+	agent.mu.Unlock()
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err := reg.WatchServices(watchCtx, "user", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, (<-ch).Nodes.Len())
+
+	assert.Nil(t, reg.RegisterService(ctx, newInfo("10.0.0.2:9091")))
+	endpoints := <-ch
+	assert.Equal(t, 2, endpoints.Nodes.Len())
+	info, ok := endpoints.Nodes.Get("grpc://10.0.0.2:9091")
+	assert.True(t, ok)
+	assert.Equal(t, "v1", info.Metadata["version"])
+
+	assert.Nil(t, reg.UnregisterService(ctx, newInfo("10.0.0.1:9091")))
+	endpoints = <-ch
+	assert.Equal(t, 1, endpoints.Nodes.Len())
+	_, ok = endpoints.Nodes.Get("grpc://10.0.0.1:9091")
+	assert.False(t, ok)
+
+	assert.Nil(t, reg.Close())
+	agent.mu.Lock()
+	assert.Empty(t, agent.services)
+	agent.mu.Unlock()
+}
+
+func TestRegistry_Heartbeat(t *testing.T) {
+	agent := newFakeAgent(t)
+	clock := xtime.NewMockClock(time.Now())
+	reg := newTestRegistry(agent, clock)
+	defer reg.Close()
+
+	info := newInfo("10.0.0.1:9091")
+	id := serviceID(info)
+	assert.Nil(t, reg.RegisterService(context.Background(), info))
+	assert.Equal(t, 1, agent.passed(id))
+
+	clock.BlockUntil(1)
+	clock.Advance(5 * time.Second)
+	assert.Eventually(t, func() bool { return agent.passed(id) == 2 }, time.Second, time.Millisecond)
+
+	// registered again once the agent lost it
+	agent.lose(id)
+	clock.Advance(5 * time.Second)
+	assert.Eventually(t, func() bool { return agent.passed(id) == 3 }, time.Second, time.Millisecond)
+	services, err := reg.ListServices(context.Background(), "user", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1)
+}
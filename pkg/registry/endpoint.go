@@ -16,14 +16,24 @@ package registry
 
 import (
 	"encoding/json"
+	"sync"
 
+	"github.com/douyu/jupiter/pkg"
 	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xnet"
+	"github.com/douyu/jupiter/pkg/util/xoutlier"
+	"github.com/douyu/jupiter/pkg/util/xretry"
 )
 
-// Endpoints ...
+// Endpoints is an immutable snapshot of a service, it's shared between
+// the resolver and balancers without locking. Use Update to derive a new
+// snapshot, the maps must not be modified in place.
 type Endpoints struct {
 	// 服务节点列表
-	Nodes map[string]server.ServiceInfo
+	// Nodes was a map[string]server.ServiceInfo before it became a sharded
+	// immutable set, read nodes with Nodes.Get, Nodes.Range and Nodes.Len,
+	// Nodes.Map or NodeMap returns a copy as the map.
+	Nodes *Nodes
 
 	// 路由配置
 	RouteConfigs map[string]RouteConfig
@@ -33,6 +43,110 @@ type Endpoints struct {
 
 	// 服务元信息
 	ProviderConfigs map[string]ProviderConfig
+
+	// Resync is set on snapshots rebuilt from a full list of the registry
+	// after changes were lost, e.g. watched revisions of etcd were compacted,
+	// readers of stores skipping it get it on the next version they read
+	Resync bool
+}
+
+// NodeMap returns a copy of nodes keyed by address, i.e. what Nodes was
+// before it became a *Nodes, code reading the map can switch to it
+func (in Endpoints) NodeMap() map[string]server.ServiceInfo {
+	return in.Nodes.Map()
+}
+
+// Update returns a new snapshot with the changes made by fn, unchanged
+// parts are shared with in. Resync of in is not kept.
+func (in Endpoints) Update(fn func(tx *EndpointsTx)) Endpoints {
+	tx := &EndpointsTx{out: in}
+	tx.out.Resync = false
+	in.Nodes.Update(func(nodes *NodesTx) {
+		tx.nodes = nodes
+		fn(tx)
+		tx.out.Nodes = nodes.nodes
+	})
+	return tx.out
+}
+
+// EndpointsTx collects changes of an Endpoints update, config maps are
+// copied on first write.
+type EndpointsTx struct {
+	out   Endpoints
+	nodes *NodesTx
+
+	routeCopied, consumerCopied, providerCopied bool
+}
+
+// PrevNodes returns nodes of the snapshot being updated, without changes
+// made by tx
+func (tx *EndpointsTx) PrevNodes() *Nodes {
+	return tx.out.Nodes
+}
+
+// SetNode ...
+func (tx *EndpointsTx) SetNode(addr string, info server.ServiceInfo) {
+	tx.nodes.Set(addr, info)
+}
+
+// DeleteNode ...
+func (tx *EndpointsTx) DeleteNode(addr string) {
+	tx.nodes.Delete(addr)
+}
+
+// SetRouteConfig ...
+func (tx *EndpointsTx) SetRouteConfig(key string, config RouteConfig) {
+	tx.routeConfigs()[key] = config
+}
+
+// DeleteRouteConfig ...
+func (tx *EndpointsTx) DeleteRouteConfig(key string) {
+	if _, ok := tx.out.RouteConfigs[key]; ok {
+		delete(tx.routeConfigs(), key)
+	}
+}
+
+// SetConsumerConfig ...
+func (tx *EndpointsTx) SetConsumerConfig(key string, config ConsumerConfig) {
+	tx.consumerConfigs()[key] = config
+}
+
+// SetProviderConfig ...
+func (tx *EndpointsTx) SetProviderConfig(key string, config ProviderConfig) {
+	tx.providerConfigs()[key] = config
+}
+
+func (tx *EndpointsTx) routeConfigs() map[string]RouteConfig {
+	if !tx.routeCopied {
+		m := make(map[string]RouteConfig, len(tx.out.RouteConfigs)+1)
+		for k, v := range tx.out.RouteConfigs {
+			m[k] = v
+		}
+		tx.out.RouteConfigs, tx.routeCopied = m, true
+	}
+	return tx.out.RouteConfigs
+}
+
+func (tx *EndpointsTx) consumerConfigs() map[string]ConsumerConfig {
+	if !tx.consumerCopied {
+		m := make(map[string]ConsumerConfig, len(tx.out.ConsumerConfigs)+1)
+		for k, v := range tx.out.ConsumerConfigs {
+			m[k] = v
+		}
+		tx.out.ConsumerConfigs, tx.consumerCopied = m, true
+	}
+	return tx.out.ConsumerConfigs
+}
+
+func (tx *EndpointsTx) providerConfigs() map[string]ProviderConfig {
+	if !tx.providerCopied {
+		m := make(map[string]ProviderConfig, len(tx.out.ProviderConfigs)+1)
+		for k, v := range tx.out.ProviderConfigs {
+			m[k] = v
+		}
+		tx.out.ProviderConfigs, tx.providerCopied = m, true
+	}
+	return tx.out.ProviderConfigs
 }
 
 // ProviderConfig config of provider
@@ -55,6 +169,46 @@ type ConsumerConfig struct {
 	ID     string `json:"id"`
 	Scheme string `json:"scheme"`
 	Host   string `json:"host"`
+
+	// Outlier ejects endpoints of high error rates from balancers
+	Outlier *xoutlier.Config `json:"outlier,omitempty"`
+	// Retry overrides the retry policy of clients
+	Retry *xretry.Policy `json:"retry,omitempty"`
+}
+
+// LocalConsumerConfig returns the consumer config of this app and host
+func LocalConsumerConfig(configs map[string]ConsumerConfig) (ConsumerConfig, bool) {
+	return SelectConsumerConfig(configs, pkg.Name(), LocalHost())
+}
+
+var (
+	localHostOnce sync.Once
+	localHostIP   string
+)
+
+// LocalHost returns the ip which configs of hosts are matched with
+func LocalHost() string {
+	localHostOnce.Do(func() {
+		localHostIP, _ = xnet.GetLocalIP()
+	})
+	return localHostIP
+}
+
+// SelectConsumerConfig returns the consumer config of app, e.g.
+// "grpc:///consumers/<app>", which is overridden by the one of the host,
+// e.g. "grpc://10.0.0.1/consumers/<app>"
+func SelectConsumerConfig(configs map[string]ConsumerConfig, app, host string) (ConsumerConfig, bool) {
+	var selected ConsumerConfig
+	var found bool
+	for _, config := range configs {
+		if config.ID != app || (config.Host != "" && config.Host != host) {
+			continue
+		}
+		if !found || config.Host != "" {
+			selected, found = config, true
+		}
+	}
+	return selected, found
 }
 
 // RouteConfig ...
@@ -79,3 +233,20 @@ type Upstream struct {
 	Nodes  map[string]int `json:"nodes"`
 	Groups map[string]int `json:"groups"`
 }
+
+// UnmarshalJSON accepts "group" as an alias of "groups"
+func (upstream *Upstream) UnmarshalJSON(data []byte) error {
+	type plain Upstream
+	var v struct {
+		plain
+		Group map[string]int `json:"group"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*upstream = Upstream(v.plain)
+	if upstream.Groups == nil {
+		upstream.Groups = v.Group
+	}
+	return nil
+}
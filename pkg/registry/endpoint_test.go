@@ -0,0 +1,54 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstream_UnmarshalJSON(t *testing.T) {
+	var config RouteConfig
+	assert.Nil(t, json.Unmarshal([]byte(`{"uri":"/hello","upstream":{"nodes":{"127.0.0.1:1980":1},"group":{"red":2,"green":1}}}`), &config))
+	assert.Equal(t, Upstream{
+		Nodes:  map[string]int{"127.0.0.1:1980": 1},
+		Groups: map[string]int{"red": 2, "green": 1},
+	}, config.Upstream)
+
+	var upstream Upstream
+	assert.Nil(t, json.Unmarshal([]byte(`{"groups":{"red":1}}`), &upstream))
+	assert.Equal(t, map[string]int{"red": 1}, upstream.Groups)
+}
+
+func TestSelectConsumerConfig(t *testing.T) {
+	configs := map[string]ConsumerConfig{
+		"grpc:///consumers/demo":         {ID: "demo"},
+		"grpc://10.0.0.1/consumers/demo": {ID: "demo", Host: "10.0.0.1"},
+		"grpc://10.0.0.2/consumers/demo": {ID: "demo", Host: "10.0.0.2"},
+		"grpc:///consumers/other":        {ID: "other"},
+	}
+	config, ok := SelectConsumerConfig(configs, "demo", "10.0.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1", config.Host)
+
+	config, ok = SelectConsumerConfig(configs, "demo", "10.0.0.3")
+	assert.True(t, ok)
+	assert.Equal(t, ConsumerConfig{ID: "demo"}, config)
+
+	_, ok = SelectConsumerConfig(configs, "unknown", "10.0.0.1")
+	assert.False(t, ok)
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/douyu/jupiter/pkg"
+	"github.com/douyu/jupiter/pkg/client/etcdv3"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// ErrACLNotConfined is returned by VerifyACL if keys of other apps are
+// writable with the credentials too
+var ErrACLNotConfined = errors.New("etcd credentials aren't confined to keys of the app")
+
+// ACL is the etcd role confining credentials of an app to its own keys.
+// Services of Names are writable only under /<Prefix>/<name>/providers/ and
+// governors/, as well as audit and prometheus keys of them, while all keys
+// under /<Prefix>/ are readable for discovery.
+type ACL struct {
+	// Prefix includes the tenant if any, e.g. "jupiter/tenants/payment"
+	Prefix string
+	Names  []string
+}
+
+// Permission is a key range granted to a role
+type Permission struct {
+	Key      string
+	RangeEnd string
+	Type     clientv3.PermissionType
+}
+
+// ACL returns the ACL of app registered with config, names of app are
+// mapped by NameSuffix and Aliases
+func (config *Config) ACL(app string) ACL {
+	var names = []string{app}
+	if strategy := config.naming(); strategy != nil {
+		names = strategy.RegisterNames(app)
+	}
+	return ACL{Prefix: tenantPrefix(config.Prefix, config.Tenant), Names: names}
+}
+
+// Role returns the name of the role, e.g. "jupiter.tenants.payment.app"
+func (acl ACL) Role() string {
+	return strings.Replace(acl.Prefix, "/", ".", -1) + "." + acl.Names[0]
+}
+
+// Permissions returns key ranges granted to the role
+func (acl ACL) Permissions() []Permission {
+	root := "/" + acl.Prefix + "/"
+	var perms = []Permission{{Key: root, RangeEnd: clientv3.GetPrefixRangeEnd(root), Type: clientv3.PermissionType(clientv3.PermRead)}}
+	for _, key := range acl.writableKeys() {
+		perms = append(perms, Permission{Key: key, RangeEnd: clientv3.GetPrefixRangeEnd(key), Type: clientv3.PermissionType(clientv3.PermReadWrite)})
+	}
+	return perms
+}
+
+// writableKeys returns prefixes of keys written by the registry for Names
+func (acl ACL) writableKeys() []string {
+	var keys []string
+	for _, name := range acl.Names {
+		keys = append(keys,
+			fmt.Sprintf("/%s/%s/providers/", acl.Prefix, name),
+			fmt.Sprintf("/%s/%s/governors/", acl.Prefix, name),
+			fmt.Sprintf("/%s/audit/%s/", acl.Prefix, name),
+			fmt.Sprintf("/prometheus/job/%s/", name),
+		)
+	}
+	return keys
+}
+
+// SetupACL creates the role of acl and grants it to user, which is created
+// with password unless it exists. It must be called with root credentials,
+// and it's safe to be called again after names are added.
+func SetupACL(ctx context.Context, client *etcdv3.Client, acl ACL, user, password string) error {
+	role := acl.Role()
+	if _, err := client.RoleAdd(ctx, role); err != nil && err != rpctypes.ErrRoleAlreadyExist {
+		return fmt.Errorf("add role %s: %w", role, err)
+	}
+	for _, perm := range acl.Permissions() {
+		if _, err := client.RoleGrantPermission(ctx, role, perm.Key, perm.RangeEnd, perm.Type); err != nil {
+			return fmt.Errorf("grant %s to role %s: %w", perm.Key, role, err)
+		}
+	}
+	if _, err := client.UserAdd(ctx, user, password); err != nil && err != rpctypes.ErrUserAlreadyExist {
+		return fmt.Errorf("add user %s: %w", user, err)
+	}
+	if _, err := client.UserGrantRole(ctx, user, role); err != nil {
+		return fmt.Errorf("grant role %s to user %s: %w", role, user, err)
+	}
+	return nil
+}
+
+// VerifyACL checks that the credentials of client can write keys of acl,
+// and returns ErrACLNotConfined if keys of another app are writable too.
+// Nothing is written, writes are probed by transactions never succeeding,
+// whose permissions are checked anyway.
+func VerifyACL(ctx context.Context, client *etcdv3.Client, acl ACL) error {
+	for _, prefix := range acl.writableKeys() {
+		if err := probeWrite(ctx, client, prefix+"acl-probe"); err != nil {
+			return fmt.Errorf("etcd credentials can't write %s: %w", prefix, err)
+		}
+	}
+	foreign := fmt.Sprintf("/%s/%s.acl-probe/providers/acl-probe", acl.Prefix, acl.Names[0])
+	switch err := probeWrite(ctx, client, foreign); err {
+	case nil:
+		return ErrACLNotConfined
+	case rpctypes.ErrPermissionDenied:
+		return nil
+	default:
+		return err
+	}
+}
+
+// verifyACL panics if the app can't write its keys, and warns if the
+// credentials aren't confined to them
+func (reg *etcdv3Registry) verifyACL() {
+	ctx, cancel := reg.withTimeout(context.Background(), opList)
+	defer cancel()
+	acl := reg.ACL(pkg.Name())
+	switch err := VerifyACL(ctx, reg.client, acl); err {
+	case nil:
+		reg.logger.Info("etcd acl verified", xlog.String("role", acl.Role()))
+	case ErrACLNotConfined:
+		reg.logger.Warn("etcd acl not confined", xlog.String("role", acl.Role()), xlog.FieldErr(err))
+	default:
+		reg.logger.Panic("verify etcd acl", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.String("role", acl.Role()), xlog.FieldErr(err))
+	}
+}
+
+func probeWrite(ctx context.Context, client *etcdv3.Client, key string) error {
+	// versions are never negative, so the put isn't applied
+	_, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.Version(key), "<", 0)).
+		Then(clientv3.OpPut(key, "")).
+		Commit()
+	return err
+}
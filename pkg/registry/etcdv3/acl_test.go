@@ -0,0 +1,46 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_ACL(t *testing.T) {
+	config := DefaultConfig()
+	config.Tenant = "payment"
+	config.NameSuffix = "gray"
+	acl := config.ACL("app")
+	assert.Equal(t, ACL{Prefix: "jupiter/tenants/payment", Names: []string{"app.gray"}}, acl)
+	assert.Equal(t, "jupiter.tenants.payment.app.gray", acl.Role())
+
+	perms := acl.Permissions()
+	assert.Equal(t, Permission{Key: "/jupiter/tenants/payment/", RangeEnd: "/jupiter/tenants/payment0", Type: clientv3.PermissionType(clientv3.PermRead)}, perms[0])
+	var writable []string
+	for _, perm := range perms[1:] {
+		assert.Equal(t, clientv3.PermissionType(clientv3.PermReadWrite), perm.Type)
+		assert.Equal(t, clientv3.GetPrefixRangeEnd(perm.Key), perm.RangeEnd)
+		writable = append(writable, perm.Key)
+	}
+	assert.Equal(t, []string{
+		"/jupiter/tenants/payment/app.gray/providers/",
+		"/jupiter/tenants/payment/app.gray/governors/",
+		"/jupiter/tenants/payment/audit/app.gray/",
+		"/prometheus/job/app.gray/",
+	}, writable)
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"sort"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/douyu/jupiter/pkg/registry"
+)
+
+// RegisteredKeys returns keys put by the registry and remaining TTLs of
+// their leases, keys without TTL are put without leases
+func (reg *etcdv3Registry) RegisteredKeys(ctx context.Context) ([]registry.RegisteredKey, error) {
+	var keys = make([]registry.RegisteredKey, 0)
+	reg.kvs.Range(func(k, _ interface{}) bool {
+		key := registry.RegisteredKey{Key: k.(string)}
+		if lease, ok := reg.leases.leaseOf(key.Key); ok {
+			key.LeaseID = int64(lease)
+		}
+		keys = append(keys, key)
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+
+	// keys share a few leases
+	var ttls = make(map[int64]int64)
+	for idx, key := range keys {
+		if key.LeaseID == 0 {
+			continue
+		}
+		ttl, ok := ttls[key.LeaseID]
+		if !ok {
+			resp, err := reg.client.TimeToLive(ctx, clientv3.LeaseID(key.LeaseID))
+			if err != nil {
+				return nil, err
+			}
+			ttl = resp.TTL
+			ttls[key.LeaseID] = ttl
+		}
+		keys[idx].TTL = ttl
+	}
+	return keys, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/douyu/jupiter/pkg/server/governor"
+)
+
+// auditKey returns the key of record, sorted by time under the prefix of app
+func (reg *etcdv3Registry) auditKey(record *governor.AuditRecord) string {
+	return fmt.Sprintf("/%s/audit/%s/%020d-%s", reg.keyPrefix(context.Background()), record.App, record.Time.UnixNano(), record.Host)
+}
+
+// Audit puts record under /<prefix>/audit/<app>/, which expires after AuditTTL
+func (reg *etcdv3Registry) Audit(ctx context.Context, record *governor.AuditRecord) error {
+	val, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	var opts []clientv3.OpOption
+	if reg.AuditTTL > 0 {
+		grantCtx, cancel := reg.withTimeout(ctx, opGrant)
+		lease, err := reg.client.Grant(grantCtx, int64(reg.AuditTTL.Seconds()))
+		cancel()
+		if err = reg.observe(opGrant, err); err != nil {
+			return err
+		}
+		opts = append(opts, clientv3.WithLease(lease.ID))
+	}
+
+	putCtx, cancel := reg.withTimeout(ctx, opRegister)
+	defer cancel()
+	_, err = reg.client.Put(putCtx, reg.auditKey(record), string(val), opts...)
+	return reg.observe(opRegister, err)
+}
+
+// ListAudits returns audit records of app in time order, the latest limit ones if limit is positive
+func (reg *etcdv3Registry) ListAudits(ctx context.Context, app string, limit int) ([]*governor.AuditRecord, error) {
+	opts := append(reg.readOptions(ctx), clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend))
+	if limit > 0 {
+		opts = append(opts, clientv3.WithLimit(int64(limit)))
+	}
+	getCtx, cancel := reg.withTimeout(ctx, opList)
+	defer cancel()
+	resp, err := reg.client.Get(getCtx, fmt.Sprintf("/%s/audit/%s/", reg.keyPrefix(ctx), app), opts...)
+	if err = reg.observe(opList, err); err != nil {
+		return nil, err
+	}
+
+	var records = make([]*governor.AuditRecord, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		var record governor.AuditRecord
+		if err := json.Unmarshal(kv.Value, &record); err != nil {
+			return nil, err
+		}
+		records[len(resp.Kvs)-1-i] = &record
+	}
+	return records, nil
+}
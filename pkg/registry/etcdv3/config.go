@@ -15,16 +15,50 @@
 package etcdv3
 
 import (
+	"context"
+	"strings"
 	"time"
 
 	"github.com/douyu/jupiter/pkg/ecode"
 	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/registry/naming"
+	"github.com/douyu/jupiter/pkg/registry/snapshot"
 
 	"github.com/douyu/jupiter/pkg/client/etcdv3"
 	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 )
 
+func init() {
+	xschema.RegisterConfig("registry.etcdv3", "jupiter.registry.*", "etcd registry", DefaultConfig)
+}
+
+const (
+	// ConsistencyLinearizable reads through the raft leader, always up to date
+	ConsistencyLinearizable = "linearizable"
+	// ConsistencySerializable reads from the local member, faster but may be stale
+	ConsistencySerializable = "serializable"
+)
+
+type consistencyKey struct{}
+
+// WithReadConsistency overrides ReadConsistency of config for reads with ctx,
+// e.g. strict reads for admin operations on a registry tolerating stale reads
+func WithReadConsistency(ctx context.Context, consistency string) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, consistency)
+}
+
+type tenantKey struct{}
+
+// WithTenant overrides Tenant of config for services listed and watched with
+// ctx, e.g. calling services of another business unit
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
 // StdConfig ...
 func StdConfig(name string) *Config {
 	return RawConfig("jupiter.registry." + name)
@@ -49,26 +83,131 @@ func DefaultConfig() *Config {
 	return &Config{
 		Config:      etcdv3.DefaultConfig(),
 		ReadTimeout: time.Second * 3,
-		Prefix:      "jupiter",
-		logger:      xlog.JupiterLogger,
-		ServiceTTL:  0,
+		// lease grants tolerate longer waits than other operations
+		GrantTimeout:      time.Second * 10,
+		KeepaliveTimeout:  time.Second * 5,
+		Prefix:            "jupiter",
+		logger:            xlog.JupiterLogger,
+		clock:             xtime.SystemClock,
+		ServiceTTL:        0,
+		LeaseShards:       1,
+		Backoff:           xbackoff.DefaultConfig(),
+		CompressThreshold: 4096,
+		Codec:             "json",
+		ListPageSize:      500,
+		ReadConsistency:   ConsistencyLinearizable,
+		AuditTTL:          time.Hour * 24 * 7,
+		Snapshot:          snapshot.DefaultConfig(),
 	}
 }
 
 // Config ...
 type Config struct {
 	*etcdv3.Config
-	ReadTimeout time.Duration
-	ConfigKey   string
-	Prefix      string
-	ServiceTTL  time.Duration
-	logger      *xlog.Logger
+	// ReadTimeout is the default timeout of operations without a specific one
+	ReadTimeout       time.Duration
+	RegisterTimeout   time.Duration
+	UnregisterTimeout time.Duration
+	ListTimeout       time.Duration
+	GrantTimeout      time.Duration
+	// KeepaliveTimeout is the timeout of putting keys again with a regranted lease
+	KeepaliveTimeout time.Duration
+	ConfigKey        string
+	Prefix           string
+	ServiceTTL       time.Duration
+	// Tenant isolates keys of business units sharing a cluster, services are
+	// registered and subscribed under /<Prefix>/tenants/<Tenant>/<app>/ if it's set,
+	// or else under /<Prefix>/<app>/ as before. It must not contain "/".
+	Tenant string
+	// DeregisterDelay is how long Close waits after keys of services are
+	// deleted, so that watchers drop the instance before servers stop
+	// accepting connections, 0 means no wait, it's skipped if the application
+	// stops immediately
+	DeregisterDelay time.Duration
+	// LeaseShards is the number of leases shared by registered keys
+	LeaseShards int
+	// Backoff of registration retries, Backoff.Jitter also randomizes
+	// lease TTL to spread keepalive requests of instances
+	Backoff xbackoff.Config
+	// Compressor compresses values no shorter than CompressThreshold
+	// bytes, "gzip" or "snappy", empty means no compression
+	Compressor        string
+	CompressThreshold int
+	// Codec encodes values of registered services, "json", "protobuf" or
+	// "msgpack", values of any codec are decoded by their magic, and values
+	// without magic, e.g. plain protobuf messages of api/serviceinfo.proto
+	// written by services in other languages, are decoded by Codec
+	Codec string
+	// ListPageSize is the max number of services fetched per request of
+	// ListServices, non-positive means no limit
+	ListPageSize int
+	// ReadConsistency of ListServices and initial fill of WatchServices,
+	// "linearizable" or "serializable"
+	ReadConsistency string
+	// NameSuffix scopes service names by environment, e.g. "gray" registers
+	// and subscribes "app.gray" for "app"
+	NameSuffix string
+	// Aliases maps a service name to its alias names, services register under
+	// all names of an alias group and clients subscribe to the whole group
+	Aliases map[string][]string
+	// Audit pushes records of governor requests mutating state, e.g.
+	// switching maintenance mode, to /<Prefix>[/tenants/<Tenant>]/audit/<app>/, which expire
+	// after AuditTTL
+	Audit    bool
+	AuditTTL time.Duration
+	// VerifyACL checks on Build that the credentials can write keys of the
+	// app, see ACL, and panics if they can't
+	VerifyACL bool
+	// Snapshot persists watched services to Snapshot.Dir, which are served
+	// if etcd is unreachable when watching starts, the etcd client doesn't
+	// block on Build then
+	Snapshot snapshot.Config
+	logger   *xlog.Logger
+	// clock drives keepalive retries and delays, replaced in tests
+	clock xtime.Clock
 }
 
 // Build ...
 func (config Config) Build() registry.Registry {
+	if strings.Contains(config.Tenant, "/") {
+		xlog.Panic("invalid tenant", xlog.FieldMod("registry.etcd"), xlog.String("tenant", config.Tenant))
+	}
+	if _, ok := registry.GetCodec(config.Codec); !ok && config.Codec != "" {
+		xlog.Panic("unknown codec", xlog.FieldMod("registry.etcd"), xlog.String("codec", config.Codec))
+	}
 	if config.ConfigKey != "" {
 		config.Config = etcdv3.RawConfig(config.ConfigKey)
 	}
-	return newETCDRegistry(&config)
+	if config.Snapshot.Dir != "" {
+		// snapshots are served instead of panicking if etcd is down
+		etcdConfig := *config.Config
+		etcdConfig.Block = false
+		config.Config = &etcdConfig
+	}
+	etcdReg := newETCDRegistry(&config)
+	if config.VerifyACL {
+		etcdReg.verifyACL()
+	}
+	var reg registry.Registry = etcdReg
+	if config.Snapshot.Dir != "" {
+		reg = snapshot.New(reg, config.Snapshot)
+	}
+	if strategy := config.naming(); strategy != nil {
+		reg = naming.New(reg, strategy)
+	}
+	return reg
+}
+
+func (config *Config) naming() naming.Strategy {
+	var strategies []naming.Strategy
+	if len(config.Aliases) > 0 {
+		strategies = append(strategies, naming.Alias(config.Aliases))
+	}
+	if config.NameSuffix != "" {
+		strategies = append(strategies, naming.EnvSuffix(config.NameSuffix))
+	}
+	if len(strategies) == 0 {
+		return nil
+	}
+	return naming.Chain(strategies...)
 }
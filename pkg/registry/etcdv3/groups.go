@@ -0,0 +1,124 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+)
+
+// groupsStore keeps the latest version of groups maintained by watchGroups
+// like registry.EndpointsStore, so that slow readers skip intermediate
+// versions instead of blocking the etcd watch
+type groupsStore struct {
+	mu      sync.Mutex
+	latest  map[string]registry.Endpoints
+	version uint64
+	// resyncs counts versions set after resyncs
+	resyncs uint64
+	changed chan struct{}
+	closed  bool
+}
+
+func newGroupsStore() *groupsStore {
+	return &groupsStore{changed: make(chan struct{})}
+}
+
+// set replaces the latest version, it's ignored once the store is closed
+func (s *groupsStore) set(groups map[string]registry.Endpoints) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.latest = groups
+	s.version++
+	if resynced(groups) {
+		s.resyncs++
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// close stops the store, readers wake up with the final version
+func (s *groupsStore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.changed)
+}
+
+// follow returns the latest version and a channel closed once it changes,
+// groups are marked Resync if any version since the one of *resyncs was a
+// resync
+func (s *groupsStore) follow(resyncs *uint64) (map[string]registry.Endpoints, uint64, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latest := s.latest
+	if s.resyncs != *resyncs {
+		*resyncs = s.resyncs
+		if !resynced(latest) {
+			marked := make(map[string]registry.Endpoints, len(latest))
+			for group, endpoints := range latest {
+				endpoints.Resync = true
+				marked[group] = endpoints
+			}
+			latest = marked
+		}
+	}
+	return latest, s.version, s.changed
+}
+
+// stream calls send with the current version before it returns, and then
+// with the latest version after each change until the store is closed or
+// ctx is done, done is called once streaming stops
+func (s *groupsStore) stream(ctx context.Context, send func(groups map[string]registry.Endpoints), done func()) {
+	var resyncs uint64
+	groups, version, changed := s.follow(&resyncs)
+	if version > 0 {
+		send(groups)
+	}
+	xgo.Go(func() {
+		defer done()
+		for {
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+			var next uint64
+			groups, next, changed = s.follow(&resyncs)
+			if next == version {
+				// closed
+				return
+			}
+			version = next
+			send(groups)
+		}
+	})
+}
+
+func resynced(groups map[string]registry.Endpoints) bool {
+	for _, endpoints := range groups {
+		return endpoints.Resync
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_groupsStore(t *testing.T) {
+	store := newGroupsStore()
+	store.set(map[string]registry.Endpoints{"grpc": {}})
+
+	var addresses = make(chan map[string]registry.Endpoints, 1)
+	store.stream(context.Background(), func(groups map[string]registry.Endpoints) {
+		addresses <- groups
+	}, func() { close(addresses) })
+	assert.Len(t, <-addresses, 1)
+
+	// the reader doesn't block writers and gets the latest version, versions
+	// after a skipped resync are marked Resync
+	store.set(map[string]registry.Endpoints{"grpc": {Resync: true}})
+	store.set(map[string]registry.Endpoints{"grpc": {}, "http": {}})
+	store.set(map[string]registry.Endpoints{"grpc": {}, "http": {}, "xgovernor": {}})
+	store.close()
+
+	var last map[string]registry.Endpoints
+	var resync bool
+	for groups := range addresses {
+		last = groups
+		resync = resync || groups["grpc"].Resync
+	}
+	assert.Len(t, last, 3)
+	assert.True(t, resync)
+}
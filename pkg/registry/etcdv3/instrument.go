@@ -0,0 +1,51 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+const (
+	// registryType labels metrics of the registry
+	registryType = "etcd"
+	// opWatch is instrumented along with operations of timeout.go
+	opWatch = "watch"
+)
+
+// instrument starts a span of op on service, the returned finish records
+// metrics of op and finishes the span with the error of op
+func instrument(ctx context.Context, op string, service string) (context.Context, func(err error)) {
+	start := time.Now()
+	span, ctx := trace.StartSpanFromContext(
+		ctx,
+		"registry.etcd."+op,
+		trace.TagComponent("registry.etcd"),
+		trace.CustomTag("service", service),
+	)
+	return ctx, func(err error) {
+		registry.ObserveOperation(registryType, op, start, err)
+		if err != nil {
+			ext.Error.Set(span, true)
+			span.LogFields(trace.String("event", "error"), trace.String("message", err.Error()))
+		}
+		span.Finish()
+	}
+}
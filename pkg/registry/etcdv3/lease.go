@@ -0,0 +1,343 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/douyu/jupiter/pkg/client/etcdv3"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// errLeaseLost counts leases expired while keys were attached as failed
+// renewals
+var errLeaseLost = errors.New("lease lost")
+
+// errLeaseManagerClosed is returned when granting leases after Close
+var errLeaseManagerClosed = errors.New("lease manager closed")
+
+// leaseManager shares a small pool of leases among all keys registered by
+// a registry instance. Each lease is kept alive by one session, so the
+// number of keepalive goroutines and etcd lease requests doesn't grow with
+// the number of keys. Keys attached to a lease are put again with a new
+// lease once the old one expires.
+type leaseManager struct {
+	client  *etcdv3.Client
+	config  *Config
+	ttl     int
+	logger  *xlog.Logger
+	clock   xtime.Clock
+	backoff xbackoff.Config
+
+	mu     sync.Mutex
+	shards []*leaseShard
+	// owners are shards keys are attached to
+	owners map[string]*leaseShard
+	closed bool
+}
+
+type leaseShard struct {
+	// grantMu serializes grants of the shard, so that concurrent callers
+	// share one lease, it's never held with lm.mu
+	grantMu sync.Mutex
+	// restoreMu serializes restoring keys of the shard with releasing them,
+	// so that released keys aren't put again, it's held before lm.mu
+	restoreMu sync.Mutex
+	sess      *concurrency.Session
+	// keys attached to the lease of sess
+	keys map[string]string
+}
+
+func newLeaseManager(client *etcdv3.Client, config *Config) *leaseManager {
+	shards := config.LeaseShards
+	if shards <= 0 {
+		shards = 1
+	}
+	if config.clock == nil {
+		config.clock = xtime.SystemClock
+	}
+	lm := &leaseManager{
+		client:  client,
+		config:  config,
+		ttl:     int(config.ServiceTTL.Seconds()),
+		logger:  config.logger,
+		clock:   config.clock,
+		backoff: config.Backoff,
+		shards:  make([]*leaseShard, shards),
+		owners:  make(map[string]*leaseShard),
+	}
+	for i := range lm.shards {
+		lm.shards[i] = &leaseShard{keys: make(map[string]string)}
+	}
+	return lm
+}
+
+// grant returns the lease key should be attached to
+func (lm *leaseManager) grant(key string) (clientv3.LeaseID, error) {
+	sess, err := lm.session(lm.shardOf(key))
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	return sess.Lease(), nil
+}
+
+// attach records key put with the lease of grant(key)
+func (lm *leaseManager) attach(key, val string) {
+	lm.mu.Lock()
+	lm.attachTo(lm.shardOf(key), key, val)
+	lm.mu.Unlock()
+}
+
+// grantShared returns the lease all keys should be attached to, so that
+// they expire together
+func (lm *leaseManager) grantShared(keys []string) (clientv3.LeaseID, error) {
+	return lm.grant(keys[0])
+}
+
+// attachShared records kvs put with the lease of grantShared
+func (lm *leaseManager) attachShared(keys []string, vals []string) {
+	lm.mu.Lock()
+	shard := lm.shardOf(keys[0])
+	for idx, key := range keys {
+		lm.attachTo(shard, key, vals[idx])
+	}
+	lm.mu.Unlock()
+}
+
+// attachTo moves key to shard, lm.mu must be held
+func (lm *leaseManager) attachTo(shard *leaseShard, key, val string) {
+	if owner, ok := lm.owners[key]; ok && owner != shard {
+		delete(owner.keys, key)
+	}
+	shard.keys[key] = val
+	lm.owners[key] = shard
+}
+
+// release detaches key and deletes it with del, the keepalive restoring keys
+// of the shard doesn't put key again in between
+func (lm *leaseManager) release(key string, del func() error) error {
+	for {
+		lm.mu.Lock()
+		owner, ok := lm.owners[key]
+		lm.mu.Unlock()
+		if !ok {
+			return del()
+		}
+		owner.restoreMu.Lock()
+		lm.mu.Lock()
+		if lm.owners[key] != owner {
+			// moved to another shard meanwhile
+			lm.mu.Unlock()
+			owner.restoreMu.Unlock()
+			continue
+		}
+		delete(owner.keys, key)
+		delete(lm.owners, key)
+		lm.mu.Unlock()
+		err := del()
+		owner.restoreMu.Unlock()
+		return err
+	}
+}
+
+// leaseOf returns the lease key is attached to
+func (lm *leaseManager) leaseOf(key string) (clientv3.LeaseID, bool) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	owner, ok := lm.owners[key]
+	if !ok || owner.sess == nil {
+		return clientv3.NoLease, false
+	}
+	return owner.sess.Lease(), true
+}
+
+// close revokes all leases, keys attached to them are deleted by etcd
+func (lm *leaseManager) close() error {
+	lm.mu.Lock()
+	lm.closed = true
+	var sessions []*concurrency.Session
+	for _, shard := range lm.shards {
+		if shard.sess != nil {
+			sessions = append(sessions, shard.sess)
+			shard.sess = nil
+		}
+	}
+	lm.mu.Unlock()
+
+	// revoking may wait for the session TTL while etcd is down, lm.mu isn't
+	// held so that other operations aren't blocked meanwhile
+	var err error
+	for _, sess := range sessions {
+		if e := sess.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (lm *leaseManager) shardOf(key string) *leaseShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return lm.shards[h.Sum32()%uint32(len(lm.shards))]
+}
+
+// session returns the session of shard, creating it if absent. lm.mu must
+// not be held, so that keys of other shards aren't blocked by the grant.
+func (lm *leaseManager) session(shard *leaseShard) (*concurrency.Session, error) {
+	shard.grantMu.Lock()
+	defer shard.grantMu.Unlock()
+	lm.mu.Lock()
+	closed, current := lm.closed, shard.sess
+	lm.mu.Unlock()
+	if closed {
+		return nil, errLeaseManagerClosed
+	}
+	if current != nil {
+		return current, nil
+	}
+	// grant with a timeout, the session keeps the lease alive afterwards
+	ctx, cancel := lm.config.withTimeout(context.Background(), opGrant)
+	resp, err := lm.client.Grant(ctx, int64(lm.jitteredTTL()))
+	cancel()
+	if err = lm.config.observe(opGrant, err); err != nil {
+		return nil, err
+	}
+	sess, err := concurrency.NewSession(lm.client.Client, concurrency.WithLease(resp.ID))
+	if err != nil {
+		return nil, err
+	}
+	lm.mu.Lock()
+	if lm.closed {
+		lm.mu.Unlock()
+		// revokes the lease granted during close
+		_ = sess.Close()
+		return nil, errLeaseManagerClosed
+	}
+	shard.sess = sess
+	lm.mu.Unlock()
+	xgo.Go(func() { lm.keepalive(shard, sess) })
+	return sess, nil
+}
+
+// jitteredTTL extends ttl by up to backoff.Jitter, keepalive requests are
+// sent every ttl/3, so instances started together refresh at different times
+func (lm *leaseManager) jitteredTTL() int {
+	jitter := xbackoff.Config{Jitter: lm.backoff.Jitter}
+	ttl := time.Duration(lm.ttl) * time.Second
+	if extra := jitter.Jittered(ttl) - ttl; extra > 0 {
+		ttl += extra
+	}
+	return int(ttl.Seconds())
+}
+
+// keepalive waits for the lease of sess to expire, e.g. etcd restarted or
+// the keepalive stream broke during a partition, then grants a new lease and
+// puts the attached keys again with exponential backoff. Re-registrations
+// are counted by lib_handle_total{type="registry.etcd",method="reregister"}.
+func (lm *leaseManager) keepalive(shard *leaseShard, sess *concurrency.Session) {
+	<-sess.Done()
+	lm.mu.Lock()
+	// the session is closed, or given up by the keepalive restoring keys
+	if lm.closed || shard.sess != sess {
+		lm.mu.Unlock()
+		return
+	}
+	shard.sess = nil
+	lm.mu.Unlock()
+
+	lm.logger.Warn("lease lost, re-registering", xlog.Int64("lease", int64(sess.Lease())))
+	registry.ObserveLeaseRenewal(registryType, errLeaseLost)
+	for retries := 0; ; retries++ {
+		// reuses the session created by grant in the meantime
+		next, err := lm.session(shard)
+		if err == errLeaseManagerClosed {
+			return
+		}
+		if err == nil {
+			var restored int
+			if restored, err = lm.restore(shard, next.Lease()); err == nil {
+				lm.logger.Warn("lease expired, regranted", xlog.Int("keys", restored), xlog.Int64("lease", int64(next.Lease())), xlog.Int("retries", retries))
+				lm.observeReregister(nil)
+				return
+			}
+			lm.logger.Error("restore keys", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err))
+			// gives up next, so that its keepalive doesn't restore keys too
+			lm.mu.Lock()
+			if shard.sess == next {
+				shard.sess = nil
+			}
+			lm.mu.Unlock()
+			_ = next.Close()
+		} else {
+			lm.logger.Error("regrant lease", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err))
+		}
+		lm.observeReregister(err)
+		lm.clock.Sleep(lm.backoff.Backoff(retries))
+	}
+}
+
+// restore puts keys attached to shard again with lease and returns the
+// number of keys put, keys released meanwhile are skipped
+func (lm *leaseManager) restore(shard *leaseShard, lease clientv3.LeaseID) (int, error) {
+	shard.restoreMu.Lock()
+	defer shard.restoreMu.Unlock()
+	lm.mu.Lock()
+	var keys = make(map[string]string, len(shard.keys))
+	for k, v := range shard.keys {
+		keys[k] = v
+	}
+	lm.mu.Unlock()
+
+	var restored int
+	for key, val := range keys {
+		// moved to another shard by a registration since the copy
+		lm.mu.Lock()
+		attached := lm.owners[key] == shard && shard.keys[key] == val
+		lm.mu.Unlock()
+		if !attached {
+			continue
+		}
+		ctx, cancel := lm.config.withTimeout(etcdv3.WithCritical(context.Background()), opKeepalive)
+		_, err := lm.client.Put(ctx, key, val, clientv3.WithLease(lease))
+		cancel()
+		if err = lm.config.observe(opKeepalive, err); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+func (lm *leaseManager) observeReregister(err error) {
+	code := "OK"
+	if err != nil {
+		code = "Error"
+	}
+	metric.LibHandleCounter.Inc("registry.etcd", "reregister", strings.Join(lm.config.Endpoints, ","), code)
+	registry.ObserveLeaseRenewal(registryType, err)
+}
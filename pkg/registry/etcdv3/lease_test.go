@@ -0,0 +1,94 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_leaseManager(t *testing.T) {
+	lm := newLeaseManager(nil, &Config{ServiceTTL: 10 * time.Second, logger: xlog.DefaultLogger})
+	assert.Equal(t, 1, len(lm.shards))
+	assert.Equal(t, 10, lm.ttl)
+	assert.Equal(t, 10, lm.jitteredTTL())
+
+	lm.backoff.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		ttl := lm.jitteredTTL()
+		assert.True(t, ttl >= 10 && ttl <= 15, ttl)
+	}
+
+	lm.attach("/jupiter/a", "1")
+	lm.attach("/jupiter/b", "2")
+	assert.Nil(t, lm.release("/jupiter/a", func() error { return nil }))
+	assert.Equal(t, map[string]string{"/jupiter/b": "2"}, lm.shards[0].keys)
+
+	// keys aren't deleted while the shard is being restored
+	var deleted = make(chan struct{})
+	lm.shards[0].restoreMu.Lock()
+	go func() {
+		_ = lm.release("/jupiter/b", func() error {
+			close(deleted)
+			return nil
+		})
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("deleted during restore")
+	case <-time.After(time.Millisecond * 50):
+	}
+	lm.shards[0].restoreMu.Unlock()
+	<-deleted
+	assert.Empty(t, lm.shards[0].keys)
+
+	assert.Nil(t, lm.close())
+	_, err := lm.grant("/jupiter/b")
+	assert.Equal(t, errLeaseManagerClosed, err)
+}
+
+func Test_leaseManager_shardOf(t *testing.T) {
+	lm := newLeaseManager(nil, &Config{ServiceTTL: time.Second, LeaseShards: 4, logger: xlog.DefaultLogger})
+	var used = make(map[*leaseShard]bool)
+	for _, key := range []string{"/a", "/b", "/c", "/d", "/e", "/f", "/g", "/h"} {
+		shard := lm.shardOf(key)
+		assert.Equal(t, shard, lm.shardOf(key))
+		used[shard] = true
+	}
+	assert.True(t, len(used) > 1)
+}
+
+func Test_leaseManager_attachShared(t *testing.T) {
+	lm := newLeaseManager(nil, &Config{ServiceTTL: time.Second, LeaseShards: 4, logger: xlog.DefaultLogger})
+	keys := []string{"/a", "/b", "/c", "/d", "/e", "/f", "/g", "/h"}
+	for _, key := range keys {
+		lm.attach(key, "old")
+	}
+	lm.attachShared(keys, []string{"1", "2", "3", "4", "5", "6", "7", "8"})
+	shard := lm.shardOf(keys[0])
+	assert.Equal(t, len(keys), len(shard.keys))
+	assert.Equal(t, "8", shard.keys["/h"])
+	for _, other := range lm.shards {
+		if other != shard {
+			assert.Empty(t, other.keys)
+		}
+	}
+
+	assert.Nil(t, lm.release("/h", func() error { return nil }))
+	assert.Equal(t, len(keys)-1, len(shard.keys))
+}
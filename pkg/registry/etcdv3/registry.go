@@ -20,11 +20,10 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"path"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/coreos/etcd/clientv3/concurrency"
 	"github.com/douyu/jupiter/pkg"
 	"github.com/douyu/jupiter/pkg/constant"
 
@@ -34,7 +33,10 @@ import (
 	"github.com/douyu/jupiter/pkg/ecode"
 	"github.com/douyu/jupiter/pkg/registry"
 	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
 	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/util/xtime"
 	"github.com/douyu/jupiter/pkg/xlog"
 )
 
@@ -42,167 +44,444 @@ type etcdv3Registry struct {
 	client *etcdv3.Client
 	kvs    sync.Map
 	*Config
-	cancel   context.CancelFunc
-	rmu      *sync.RWMutex
-	sessions map[string]*concurrency.Session
+	cancel context.CancelFunc
+	leases *leaseManager
+	// watches are closed with the registry
+	watches sync.Map
 }
 
+var (
+	_ registry.ServiceStreamer = &etcdv3Registry{}
+	_ registry.SchemeWatcher   = &etcdv3Registry{}
+	_ registry.PrefixWatcher   = &etcdv3Registry{}
+	_ registry.BatchRegisterer = &etcdv3Registry{}
+	_ registry.Drainer         = &etcdv3Registry{}
+	_ registry.KeyInspector    = &etcdv3Registry{}
+)
+
 func newETCDRegistry(config *Config) *etcdv3Registry {
 	if config.logger == nil {
 		config.logger = xlog.JupiterLogger
 	}
+	if config.clock == nil {
+		config.clock = xtime.SystemClock
+	}
 	config.logger = config.logger.With(xlog.FieldMod(ecode.ModRegistryETCD), xlog.FieldAddrAny(config.Config.Endpoints))
 	reg := &etcdv3Registry{
-		client:   config.Config.Build(),
-		Config:   config,
-		kvs:      sync.Map{},
-		rmu:      &sync.RWMutex{},
-		sessions: make(map[string]*concurrency.Session),
+		client: config.Config.Build(),
+		Config: config,
+		kvs:    sync.Map{},
+	}
+	reg.leases = newLeaseManager(reg.client, config)
+	if config.Audit {
+		governor.RegisterAuditor(reg)
 	}
 	return reg
 }
 
 // RegisterService register service to registry
-func (reg *etcdv3Registry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
-	err := reg.registerBiz(ctx, info)
+// failed registration is retried with jittered backoff, so that instances
+// restarting at the same time don't hit etcd in lockstep
+func (reg *etcdv3Registry) RegisterService(ctx context.Context, info *server.ServiceInfo) (err error) {
+	ctx, finish := instrument(ctx, opRegister, info.Label())
+	defer func() { finish(err) }()
+	err = xbackoff.Retry(ctx, reg.Backoff, reg.clock, func() error {
+		return reg.registerBiz(ctx, info)
+	})
 	if err != nil {
 		return err
 	}
-	return reg.registerMetric(ctx, info)
+	return xbackoff.Retry(ctx, reg.Backoff, reg.clock, func() error {
+		return reg.registerMetric(ctx, info)
+	})
+}
+
+// RegisterServices registers services in one transaction, so that all or
+// none of them are registered, and the keys share a single lease
+func (reg *etcdv3Registry) RegisterServices(ctx context.Context, infos []*server.ServiceInfo) (err error) {
+	if len(infos) == 0 {
+		return nil
+	}
+	ctx, finish := instrument(ctx, opRegister, infos[0].Name)
+	defer func() { finish(err) }()
+	return xbackoff.Retry(ctx, reg.Backoff, reg.clock, func() error {
+		return reg.registerBatch(ctx, infos)
+	})
 }
 
 // UnregisterService unregister service from registry
-func (reg *etcdv3Registry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+func (reg *etcdv3Registry) UnregisterService(ctx context.Context, info *server.ServiceInfo) (err error) {
+	ctx, finish := instrument(ctx, opUnregister, info.Label())
+	defer func() { finish(err) }()
 	return reg.unregister(ctx, reg.registerKey(info))
 }
 
 // ListServices list service registered in registry with name `name`
 func (reg *etcdv3Registry) ListServices(ctx context.Context, name string, scheme string) (services []*server.ServiceInfo, err error) {
-	target := fmt.Sprintf("/%s/%s/providers/%s://", reg.Prefix, name, scheme)
-	getResp, getErr := reg.client.Get(ctx, target, clientv3.WithPrefix())
-	if getErr != nil {
-		reg.logger.Error(ecode.MsgWatchRequestErr, xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(getErr), xlog.FieldAddr(target))
-		return nil, getErr
+	err = reg.StreamServices(ctx, name, scheme, func(page []*server.ServiceInfo) error {
+		services = append(services, page...)
+		return nil
+	})
+	return
+}
+
+// StreamServices lists services page by page, fn is called with each page
+// as soon as it's fetched. All pages are read at the revision of the first
+// page, so the result is a consistent snapshot.
+func (reg *etcdv3Registry) StreamServices(ctx context.Context, name string, scheme string, fn func([]*server.ServiceInfo) error) error {
+	filter, err := registry.ServiceFilterFromContext(ctx)
+	if err != nil {
+		return err
 	}
+	target := fmt.Sprintf("/%s/%s/providers/%s://", reg.keyPrefix(ctx), name, scheme)
+	end := clientv3.GetPrefixRangeEnd(target)
+	key := target
+	var rev int64
+
+	for {
+		opts := append(reg.readOptions(ctx), clientv3.WithRange(end))
+		if reg.ListPageSize > 0 {
+			opts = append(opts, clientv3.WithLimit(int64(reg.ListPageSize)))
+		}
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+		getCtx, cancel := reg.withTimeout(ctx, opList)
+		getResp, getErr := reg.client.Get(getCtx, key, opts...)
+		cancel()
+		if getErr = reg.observe(opList, getErr); getErr != nil {
+			reg.logger.Error(ecode.MsgWatchRequestErr, xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(getErr), xlog.FieldAddr(target))
+			return getErr
+		}
+		rev = getResp.Header.Revision
 
-	for _, kv := range getResp.Kvs {
-		var service server.ServiceInfo
-		if err := json.Unmarshal(kv.Value, &service); err != nil {
-			reg.logger.Warnf("invalid service", xlog.FieldErr(err))
-			continue
+		var services = make([]*server.ServiceInfo, 0, len(getResp.Kvs))
+		for _, kv := range getResp.Kvs {
+			var service server.ServiceInfo
+			value, err := registry.DecodeValue(kv.Value)
+			if err != nil {
+				reg.logger.Warn("invalid service", xlog.FieldErr(err))
+				continue
+			}
+			if err := registry.UnmarshalServiceWith(value, reg.Codec, &service); err != nil {
+				reg.logger.Warn("invalid service", xlog.FieldErr(err))
+				continue
+			}
+			if !filter.Matches(service) {
+				continue
+			}
+			services = append(services, &service)
+		}
+		if len(services) > 0 {
+			if err := fn(services); err != nil {
+				return err
+			}
 		}
-		services = append(services, &service)
-	}
 
-	return
+		if !getResp.More || len(getResp.Kvs) == 0 {
+			return nil
+		}
+		// continue after the last key
+		key = string(getResp.Kvs[len(getResp.Kvs)-1].Key) + "\x00"
+	}
 }
 
-// WatchServices watch service change event, then return address list
+// WatchServices watch service change event, then return address list.
+// Watching stops once ctx is done or the registry is closed, and the
+// returned channel is closed then
 func (reg *etcdv3Registry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
-	prefix := fmt.Sprintf("/%s/%s/", reg.Prefix, name)
-	watch, err := reg.client.WatchPrefix(context.Background(), prefix)
+	store, err := reg.WatchSnapshots(ctx, name, scheme)
 	if err != nil {
 		return nil, err
 	}
+	return registry.StreamEndpoints(ctx, store), nil
+}
 
-	var addresses = make(chan registry.Endpoints, 10)
-	var al = &registry.Endpoints{
-		Nodes:           make(map[string]server.ServiceInfo),
-		RouteConfigs:    make(map[string]registry.RouteConfig),
-		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
-		ProviderConfigs: make(map[string]registry.ProviderConfig),
+// WatchSnapshots watches services of name and scheme into a store, which is
+// closed once ctx is done or the registry is closed
+func (reg *etcdv3Registry) WatchSnapshots(ctx context.Context, name string, scheme string) (*registry.EndpointsStore, error) {
+	if _, err := registry.LabelSelectorFromContext(ctx); err != nil {
+		return nil, err
 	}
-
-	for _, kv := range watch.IncipientKeyValues() {
-		updateAddrList(al, prefix, scheme, kv)
+	prefix := fmt.Sprintf("/%s/%s/", reg.keyPrefix(ctx), name)
+	spanCtx, finish := instrument(ctx, opWatch, name)
+	watch, err := reg.watchPrefix(spanCtx, prefix)
+	finish(err)
+	if err != nil {
+		return nil, err
 	}
 
-	addresses <- *al
+	var store = registry.NewEndpointsStore()
+	store.Update(func(tx *registry.EndpointsTx) {
+		reg.updateAddrList(tx, prefix, scheme, watch.IncipientKeyValues()...)
+	})
 
 	xgo.Go(func() {
-		for event := range watch.C() {
-			al2 := reg.cloneEndPoints(al)
-			switch event.Type {
-			case mvccpb.PUT:
-				updateAddrList(al2, prefix, scheme, event.Kv)
-			case mvccpb.DELETE:
-				deleteAddrList(al2, prefix, scheme, event.Kv)
-			}
+		defer store.Close()
+		reg.consume(ctx, watch, func(event *clientv3.Event) {
+			// 基于上一版本生成新快照, 未变更的部分共享
+			store.Update(func(tx *registry.EndpointsTx) {
+				switch event.Type {
+				case mvccpb.PUT:
+					reg.updateAddrList(tx, prefix, scheme, event.Kv)
+				case mvccpb.DELETE:
+					deleteAddrList(tx, prefix, scheme, event.Kv)
+				}
+			})
+		}, func(resync etcdv3.Resync) {
+			resynced := registry.NewEndpointsStore().Update(func(tx *registry.EndpointsTx) {
+				reg.updateAddrList(tx, prefix, scheme, resync.KeyValues...)
+			})
+			resynced.Resync = true
+			store.Set(resynced)
+		})
+	})
 
-			select {
-			case addresses <- *al2:
-			default:
-				xlog.Warnf("invalid")
+	return registry.FilterStore(ctx, store)
+}
+
+// watchPrefix watches keys with prefix, the watch is closed with the registry
+func (reg *etcdv3Registry) watchPrefix(ctx context.Context, prefix string) (*etcdv3.Watch, error) {
+	// the initial read is bounded, so that snapshots are served if etcd is down
+	getCtx, cancel := reg.withTimeout(ctx, opList)
+	defer cancel()
+	watch, err := reg.client.WatchPrefix(getCtx, prefix, reg.readOptions(ctx)...)
+	if err != nil {
+		return nil, err
+	}
+	reg.watches.Store(watch, struct{}{})
+	registry.ObserveWatch(registryType, 1)
+	return watch, nil
+}
+
+// consume calls handle with events of watch and resync with resyncs of it
+// until ctx is done or the watch is closed, the watch is closed before it
+// returns. Events older than the last resync are skipped.
+func (reg *etcdv3Registry) consume(ctx context.Context, watch *etcdv3.Watch, handle func(event *clientv3.Event), resync func(etcdv3.Resync)) {
+	defer registry.ObserveWatch(registryType, -1)
+	defer reg.watches.Delete(watch)
+	defer watch.Close()
+	var resynced int64
+	for {
+		select {
+		case event, ok := <-watch.C():
+			if !ok {
+				return
 			}
+			if event.Kv.ModRevision <= resynced {
+				continue
+			}
+			handle(event)
+		case r := <-watch.Resync():
+			reg.logger.Warn("resync watch", xlog.Int64("revision", r.Revision), xlog.Int("keys", len(r.KeyValues)))
+			resynced = r.Revision
+			resync(r)
+		case <-ctx.Done():
+			return
 		}
-	})
+	}
+}
 
+// WatchSchemes watches services of all schemes with one watcher, endpoints
+// are grouped by scheme, slow readers skip to the latest version
+func (reg *etcdv3Registry) WatchSchemes(ctx context.Context, name string) (chan registry.SchemeEndpoints, error) {
+	prefix := fmt.Sprintf("/%s/%s/", reg.keyPrefix(ctx), name)
+	var addresses = make(chan registry.SchemeEndpoints, 1)
+	var store = newGroupsStore()
+	err := reg.watchGroups(ctx, prefix, func(kv *mvccpb.KeyValue, groups map[string]registry.Endpoints) []watchTarget {
+		if scheme := schemeOf(prefix, kv); scheme != "" {
+			return []watchTarget{{group: scheme, prefix: prefix, scheme: scheme}}
+		}
+		if !isIPPort(strings.TrimPrefix(string(kv.Key), prefix)) {
+			return nil
+		}
+		// 删除ip:port形式的键时无法得知scheme
+		var targets []watchTarget
+		for scheme := range groups {
+			targets = append(targets, watchTarget{group: scheme, prefix: prefix, scheme: scheme})
+		}
+		return targets
+	}, store.set, store.close)
+	if err != nil {
+		return nil, err
+	}
+	store.stream(ctx, func(groups map[string]registry.Endpoints) {
+		select {
+		case addresses <- groups:
+		case <-ctx.Done():
+		}
+	}, func() { close(addresses) })
 	return addresses, nil
 }
 
-func (reg *etcdv3Registry) cloneEndPoints(src *registry.Endpoints) *registry.Endpoints {
-	dst := &registry.Endpoints{
-		Nodes:           make(map[string]server.ServiceInfo),
-		RouteConfigs:    make(map[string]registry.RouteConfig),
-		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
-		ProviderConfigs: make(map[string]registry.ProviderConfig),
+// WatchServicesByPrefix watches all services whose name matches pattern,
+// e.g. "payment-*", endpoints are grouped by service name, slow readers
+// skip to the latest version
+func (reg *etcdv3Registry) WatchServicesByPrefix(ctx context.Context, pattern string, scheme string) (chan registry.ServiceEndpoints, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
 	}
-	for k, v := range src.Nodes {
-		dst.Nodes[k] = v
+	root := fmt.Sprintf("/%s/", reg.keyPrefix(ctx))
+	// 只watch模式中第一个通配符之前的前缀
+	literal := pattern
+	if idx := strings.IndexAny(pattern, "*?[\\"); idx >= 0 {
+		literal = pattern[:idx]
 	}
-	for k, v := range src.RouteConfigs {
-		dst.RouteConfigs[k] = v
+
+	var addresses = make(chan registry.ServiceEndpoints, 1)
+	var store = newGroupsStore()
+	err := reg.watchGroups(ctx, root+literal, func(kv *mvccpb.KeyValue, _ map[string]registry.Endpoints) []watchTarget {
+		name := strings.SplitN(strings.TrimPrefix(string(kv.Key), root), "/", 2)[0]
+		if ok, _ := path.Match(pattern, name); !ok {
+			return nil
+		}
+		return []watchTarget{{group: name, prefix: root + name + "/", scheme: scheme}}
+	}, store.set, store.close)
+	if err != nil {
+		return nil, err
 	}
-	for k, v := range src.ConsumerConfigs {
-		dst.ConsumerConfigs[k] = v
+	store.stream(ctx, func(groups map[string]registry.Endpoints) {
+		select {
+		case addresses <- groups:
+		case <-ctx.Done():
+		}
+	}, func() { close(addresses) })
+	return addresses, nil
+}
+
+// watchTarget is the group a key belongs to and how to parse the key
+type watchTarget struct {
+	group  string
+	prefix string
+	scheme string
+}
+
+// watchGroups watches keys with prefix and maintains endpoints of each group
+// returned by targets, emit is called with a new version of all groups after
+// each change, it must not block the watch. Unchanged groups are shared
+// between versions. done is called once watching stops.
+func (reg *etcdv3Registry) watchGroups(ctx context.Context, prefix string,
+	targets func(kv *mvccpb.KeyValue, groups map[string]registry.Endpoints) []watchTarget,
+	emit func(groups map[string]registry.Endpoints), done func()) error {
+	watch, err := reg.watchPrefix(ctx, prefix)
+	if err != nil {
+		return err
 	}
-	for k, v := range src.ProviderConfigs {
-		dst.ProviderConfigs[k] = v
+
+	list := func(kvs []*mvccpb.KeyValue) map[string]registry.Endpoints {
+		var groups = make(map[string]registry.Endpoints)
+		for _, kv := range kvs {
+			for _, target := range targets(kv, groups) {
+				groups[target.group] = groups[target.group].Update(func(tx *registry.EndpointsTx) {
+					reg.updateAddrList(tx, target.prefix, target.scheme, kv)
+				})
+			}
+		}
+		return groups
 	}
-	return dst
+	var groups = list(watch.IncipientKeyValues())
+	emit(groups)
+
+	xgo.Go(func() {
+		defer done()
+		reg.consume(ctx, watch, func(event *clientv3.Event) {
+			changed := targets(event.Kv, groups)
+			if len(changed) == 0 {
+				return
+			}
+			// 只复制分组索引, 未变更分组的endpoints共享
+			next := make(map[string]registry.Endpoints, len(groups)+1)
+			for group, endpoints := range groups {
+				next[group] = endpoints
+			}
+			for _, target := range changed {
+				endpoints := next[target.group].Update(func(tx *registry.EndpointsTx) {
+					switch event.Type {
+					case mvccpb.PUT:
+						reg.updateAddrList(tx, target.prefix, target.scheme, event.Kv)
+					case mvccpb.DELETE:
+						deleteAddrList(tx, target.prefix, target.scheme, event.Kv)
+					}
+				})
+				if endpoints.Nodes.Len() == 0 && len(endpoints.RouteConfigs) == 0 &&
+					len(endpoints.ProviderConfigs) == 0 && len(endpoints.ConsumerConfigs) == 0 {
+					delete(next, target.group)
+					continue
+				}
+				next[target.group] = endpoints
+			}
+			groups = next
+			emit(groups)
+		}, func(resync etcdv3.Resync) {
+			groups = list(resync.KeyValues)
+			var resynced = make(map[string]registry.Endpoints, len(groups))
+			for group, endpoints := range groups {
+				endpoints.Resync = true
+				resynced[group] = endpoints
+			}
+			emit(resynced)
+		})
+	})
+	return nil
 }
 
 func (reg *etcdv3Registry) unregister(ctx context.Context, key string) error {
-	if _, ok := ctx.Deadline(); !ok {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, reg.ReadTimeout)
-		defer cancel()
-	}
+	ctx, cancel := reg.withTimeout(etcdv3.WithCritical(ctx), opUnregister)
+	defer cancel()
 
-	if err := reg.delSession(key); err != nil {
+	err := reg.leases.release(key, func() error {
+		_, err := reg.client.Delete(ctx, key)
 		return err
-	}
-
-	_, err := reg.client.Delete(ctx, key)
-	if err == nil {
+	})
+	if err = reg.observe(opUnregister, err); err == nil {
 		reg.kvs.Delete(key)
 	}
 	return err
 }
 
-// Close ...
+// Close unregisters services and waits DeregisterDelay for consumers to
+// drop them before it returns
 func (reg *etcdv3Registry) Close() error {
+	return reg.close(true)
+}
+
+// CloseNow unregisters services without waiting for consumers
+func (reg *etcdv3Registry) CloseNow() error {
+	return reg.close(false)
+}
+
+func (reg *etcdv3Registry) close(drain bool) error {
+	governor.UnregisterAuditor(reg)
 	if reg.cancel != nil {
 		reg.cancel()
 	}
+	reg.watches.Range(func(watch, _ interface{}) bool {
+		_ = watch.(*etcdv3.Watch).Close()
+		return true
+	})
 	var wg sync.WaitGroup
+	var registered bool
 	reg.kvs.Range(func(k, v interface{}) bool {
+		registered = true
 		wg.Add(1)
 		go func(k interface{}) {
 			defer wg.Done()
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-			err := reg.unregister(ctx, k.(string))
+			err := reg.unregister(context.Background(), k.(string))
 			if err != nil {
 				reg.logger.Error("unregister service", xlog.FieldErrKind(ecode.ErrKindRequestErr), xlog.FieldErr(err), xlog.FieldErr(err), xlog.FieldKeyAny(k), xlog.FieldValueAny(v))
 			} else {
 				reg.logger.Info("unregister service", xlog.FieldKeyAny(k), xlog.FieldValueAny(v))
 			}
-			cancel()
 		}(k)
 		return true
 	})
 	wg.Wait()
-	return nil
+	// servers keep serving until consumers have seen the keys deleted
+	if drain && registered && reg.DeregisterDelay > 0 {
+		reg.logger.Info("drain after unregister", xlog.Duration("delay", reg.DeregisterDelay))
+		<-reg.clock.After(reg.DeregisterDelay)
+	}
+	return reg.leases.close()
 }
 
 func (reg *etcdv3Registry) registerMetric(ctx context.Context, info *server.ServiceInfo) error {
@@ -212,110 +491,156 @@ func (reg *etcdv3Registry) registerMetric(ctx context.Context, info *server.Serv
 
 	metric := "/prometheus/job/%s/%s"
 
-	if _, ok := ctx.Deadline(); !ok {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, reg.ReadTimeout)
-		defer cancel()
-	}
+	ctx, cancel := reg.withTimeout(etcdv3.WithCritical(ctx), opRegister)
+	defer cancel()
 
 	key := fmt.Sprintf(metric, info.Name, pkg.HostName())
 	val := info.Address
 
 	opOptions := make([]clientv3.OpOption, 0)
-	// opOptions = append(opOptions, clientv3.WithSerializable())
-	if ttl := reg.Config.ServiceTTL.Seconds(); ttl > 0 {
-		//todo ctx without timeout for same as service life?
-		sess, err := reg.getSession(key, concurrency.WithTTL(int(ttl)))
+	if reg.Config.ServiceTTL > 0 {
+		lease, err := reg.leases.grant(key)
 		if err != nil {
 			return err
 		}
-		opOptions = append(opOptions, clientv3.WithLease(sess.Lease()))
+		opOptions = append(opOptions, clientv3.WithLease(lease))
 	}
 	_, err := reg.client.Put(ctx, key, val, opOptions...)
-	if err != nil {
+	if err = reg.observe(opRegister, err); err != nil {
 		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKeyAny(key), xlog.FieldValueAny(info))
 		return err
 	}
 
+	if reg.Config.ServiceTTL > 0 {
+		reg.leases.attach(key, val)
+	}
 	reg.logger.Info("register service", xlog.FieldKeyAny(key), xlog.FieldValueAny(val))
 	reg.kvs.Store(key, val)
 	return nil
 
 }
 func (reg *etcdv3Registry) registerBiz(ctx context.Context, info *server.ServiceInfo) error {
-	var readCtx context.Context
-	var readCancel context.CancelFunc
-	if _, ok := ctx.Deadline(); !ok {
-		readCtx, readCancel = context.WithTimeout(ctx, reg.ReadTimeout)
-		defer readCancel()
-	}
+	ctx, cancel := reg.withTimeout(etcdv3.WithCritical(ctx), opRegister)
+	defer cancel()
 
 	key := reg.registerKey(info)
-	val := reg.registerValue(info)
+	val, err := reg.registerValue(info)
+	if err != nil {
+		return err
+	}
 
 	opOptions := make([]clientv3.OpOption, 0)
-	// opOptions = append(opOptions, clientv3.WithSerializable())
-	if ttl := reg.Config.ServiceTTL.Seconds(); ttl > 0 {
-		//todo ctx without timeout for same as service life?
-		sess, err := reg.getSession(key, concurrency.WithTTL(int(ttl)))
+	if reg.Config.ServiceTTL > 0 {
+		lease, err := reg.leases.grant(key)
 		if err != nil {
 			return err
 		}
-		opOptions = append(opOptions, clientv3.WithLease(sess.Lease()))
+		opOptions = append(opOptions, clientv3.WithLease(lease))
 	}
-	_, err := reg.client.Put(readCtx, key, val, opOptions...)
-	if err != nil {
+	_, err = reg.client.Put(ctx, key, val, opOptions...)
+	if err = reg.observe(opRegister, err); err != nil {
 		reg.logger.Error("register service", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.FieldKeyAny(key), xlog.FieldValueAny(info))
 		return err
 	}
+	if reg.Config.ServiceTTL > 0 {
+		reg.leases.attach(key, val)
+	}
 	reg.logger.Info("register service", xlog.FieldKeyAny(key), xlog.FieldValueAny(val))
 	reg.kvs.Store(key, val)
 	return nil
 }
 
-func (reg *etcdv3Registry) getSession(k string, opts ...concurrency.SessionOption) (*concurrency.Session, error) {
-	reg.rmu.RLock()
-	sess, ok := reg.sessions[k]
-	reg.rmu.RUnlock()
-	if ok {
-		return sess, nil
+func (reg *etcdv3Registry) registerBatch(ctx context.Context, infos []*server.ServiceInfo) error {
+	ctx, cancel := reg.withTimeout(etcdv3.WithCritical(ctx), opRegister)
+	defer cancel()
+
+	var keys, vals []string
+	for _, info := range infos {
+		val, err := reg.registerValue(info)
+		if err != nil {
+			return err
+		}
+		keys, vals = append(keys, reg.registerKey(info)), append(vals, val)
+		if info.Kind == constant.ServiceGovernor {
+			keys, vals = append(keys, fmt.Sprintf("/prometheus/job/%s/%s", info.Name, pkg.HostName())), append(vals, info.Address)
+		}
 	}
-	sess, err := concurrency.NewSession(reg.client.Client)
-	if err != nil {
-		return sess, err
-	}
-	reg.rmu.Lock()
-	reg.sessions[k] = sess
-	reg.rmu.Unlock()
-	return sess, nil
-}
-
-func (reg *etcdv3Registry) delSession(k string) error {
-	if ttl := reg.Config.ServiceTTL.Seconds(); ttl > 0 {
-		reg.rmu.RLock()
-		sess, ok := reg.sessions[k]
-		reg.rmu.RUnlock()
-		if ok {
-			reg.rmu.Lock()
-			delete(reg.sessions, k)
-			reg.rmu.Unlock()
-			if err := sess.Close(); err != nil {
-				return err
-			}
+
+	opOptions := make([]clientv3.OpOption, 0)
+	if reg.Config.ServiceTTL > 0 {
+		lease, err := reg.leases.grantShared(keys)
+		if err != nil {
+			return err
 		}
+		opOptions = append(opOptions, clientv3.WithLease(lease))
+	}
+	var ops = make([]clientv3.Op, 0, len(keys))
+	for idx, key := range keys {
+		ops = append(ops, clientv3.OpPut(key, vals[idx], opOptions...))
+	}
+	_, err := reg.client.Txn(ctx).Then(ops...).Commit()
+	if err = reg.observe(opRegister, err); err != nil {
+		reg.logger.Error("register services", xlog.FieldErrKind(ecode.ErrKindRegisterErr), xlog.FieldErr(err), xlog.Any("keys", keys))
+		return err
+	}
+	if reg.Config.ServiceTTL > 0 {
+		reg.leases.attachShared(keys, vals)
+	}
+	for idx, key := range keys {
+		reg.logger.Info("register service", xlog.FieldKeyAny(key), xlog.FieldValueAny(vals[idx]))
+		reg.kvs.Store(key, vals[idx])
 	}
 	return nil
 }
 
+// readOptions returns the options of read requests by the consistency of
+// ctx, falls back to ReadConsistency of config
+func (reg *etcdv3Registry) readOptions(ctx context.Context) []clientv3.OpOption {
+	consistency, ok := ctx.Value(consistencyKey{}).(string)
+	if !ok {
+		consistency = reg.ReadConsistency
+	}
+	if consistency == ConsistencySerializable {
+		return []clientv3.OpOption{clientv3.WithSerializable()}
+	}
+	return []clientv3.OpOption{}
+}
+
 func (reg *etcdv3Registry) registerKey(info *server.ServiceInfo) string {
-	return registry.GetServiceKey(reg.Prefix, info)
+	return registry.GetServiceKey(reg.keyPrefix(context.Background()), info)
+}
+
+// keyPrefix returns the key prefix of the tenant of ctx, or Tenant of config
+// if ctx has none, see tenantPrefix
+func (reg *etcdv3Registry) keyPrefix(ctx context.Context) string {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	if !ok {
+		tenant = reg.Tenant
+	}
+	return tenantPrefix(reg.Prefix, tenant)
+}
+
+// tenantPrefix returns prefix followed by the segment of tenants and the
+// tenant, so that tenants never collide with apps of the default tenant,
+// whose keys are right under prefix
+func tenantPrefix(prefix, tenant string) string {
+	if tenant == "" {
+		return prefix
+	}
+	return prefix + "/tenants/" + tenant
 }
 
-func (reg *etcdv3Registry) registerValue(info *server.ServiceInfo) string {
-	return registry.GetServiceValue(info)
+// registerValue returns info encoded with the codec, compressed if it's large
+func (reg *etcdv3Registry) registerValue(info *server.ServiceInfo) (string, error) {
+	data, err := registry.MarshalService(info, reg.Codec)
+	if err != nil {
+		return "", err
+	}
+	val, err := registry.EncodeValue(data, reg.Compressor, reg.CompressThreshold)
+	return string(val), err
 }
 
-func deleteAddrList(al *registry.Endpoints, prefix, scheme string, kvs ...*mvccpb.KeyValue) {
+func deleteAddrList(tx *registry.EndpointsTx, prefix, scheme string, kvs ...*mvccpb.KeyValue) {
 	for _, kv := range kvs {
 		var addr = strings.TrimPrefix(string(kv.Key), prefix)
 		if strings.HasPrefix(addr, "providers/"+scheme) {
@@ -329,7 +654,7 @@ func deleteAddrList(al *registry.Endpoints, prefix, scheme string, kvs ...*mvccp
 				xlog.Error("parse uri", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(string(kv.Key)))
 				continue
 			}
-			delete(al.Nodes, uri.String())
+			tx.DeleteNode(uri.String())
 		}
 
 		if strings.HasPrefix(addr, "configurators/"+scheme) {
@@ -343,20 +668,25 @@ func deleteAddrList(al *registry.Endpoints, prefix, scheme string, kvs ...*mvccp
 				xlog.Error("parse uri", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(string(kv.Key)))
 				continue
 			}
-			delete(al.RouteConfigs, uri.String())
+			tx.DeleteRouteConfig(uri.String())
 		}
 
 		if isIPPort(addr) {
 			// 直接删除addr 因为Delete操作的value值为空
-			delete(al.Nodes, addr)
-			delete(al.RouteConfigs, addr)
+			tx.DeleteNode(addr)
+			tx.DeleteRouteConfig(addr)
 		}
 	}
 }
 
-func updateAddrList(al *registry.Endpoints, prefix, scheme string, kvs ...*mvccpb.KeyValue) {
+func (reg *etcdv3Registry) updateAddrList(tx *registry.EndpointsTx, prefix, scheme string, kvs ...*mvccpb.KeyValue) {
 	for _, kv := range kvs {
 		var addr = strings.TrimPrefix(string(kv.Key), prefix)
+		value, err := registry.DecodeValue(kv.Value)
+		if err != nil {
+			xlog.Error("decode value", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(string(kv.Key)))
+			continue
+		}
 		switch {
 		// 解析服务注册键
 		case strings.HasPrefix(addr, "providers/"+scheme):
@@ -367,11 +697,11 @@ func updateAddrList(al *registry.Endpoints, prefix, scheme string, kvs ...*mvccp
 				continue
 			}
 			var serviceInfo server.ServiceInfo
-			if err := json.Unmarshal(kv.Value, &serviceInfo); err != nil {
+			if err := registry.UnmarshalServiceWith(value, reg.Codec, &serviceInfo); err != nil {
 				xlog.Error("parse uri", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(string(kv.Key)))
 				continue
 			}
-			al.Nodes[uri.String()] = serviceInfo
+			tx.SetNode(uri.String(), serviceInfo)
 		case strings.HasPrefix(addr, "configurators/"+scheme):
 			addr = strings.TrimPrefix(addr, "configurators/")
 
@@ -383,43 +713,59 @@ func updateAddrList(al *registry.Endpoints, prefix, scheme string, kvs ...*mvccp
 
 			if strings.HasPrefix(uri.Path, "/routes/") { // 路由配置
 				var routeConfig registry.RouteConfig
-				if err := json.Unmarshal(kv.Value, &routeConfig); err != nil {
+				if err := json.Unmarshal(value, &routeConfig); err != nil {
 					xlog.Error("parse uri", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(string(kv.Key)))
 					continue
 				}
 				routeConfig.ID = strings.TrimPrefix(uri.Path, "/routes/")
 				routeConfig.Scheme = uri.Scheme
 				routeConfig.Host = uri.Host
-				al.RouteConfigs[uri.String()] = routeConfig
+				tx.SetRouteConfig(uri.String(), routeConfig)
 			}
 
 			if strings.HasPrefix(uri.Path, "/providers/") {
 				var providerConfig registry.ProviderConfig
-				if err := json.Unmarshal(kv.Value, &providerConfig); err != nil {
+				if err := json.Unmarshal(value, &providerConfig); err != nil {
 					xlog.Error("parse uri", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(string(kv.Key)))
 					continue
 				}
 				providerConfig.ID = strings.TrimPrefix(uri.Path, "/providers/")
 				providerConfig.Scheme = uri.Scheme
 				providerConfig.Host = uri.Host
-				al.ProviderConfigs[uri.String()] = providerConfig
+				tx.SetProviderConfig(uri.String(), providerConfig)
 			}
 
 			if strings.HasPrefix(uri.Path, "/consumers/") {
 				var consumerConfig registry.ConsumerConfig
-				if err := json.Unmarshal(kv.Value, &consumerConfig); err != nil {
+				if err := json.Unmarshal(value, &consumerConfig); err != nil {
 					xlog.Error("parse uri", xlog.FieldErrKind(ecode.ErrKindUriErr), xlog.FieldErr(err), xlog.FieldKey(string(kv.Key)))
 					continue
 				}
 				consumerConfig.ID = strings.TrimPrefix(uri.Path, "/consumers/")
 				consumerConfig.Scheme = uri.Scheme
 				consumerConfig.Host = uri.Host
-				al.ConsumerConfigs[uri.String()] = consumerConfig
+				tx.SetConsumerConfig(uri.String(), consumerConfig)
 			}
 		}
 	}
 }
 
+// schemeOf returns the scheme of a providers/configurators key
+func schemeOf(prefix string, kv *mvccpb.KeyValue) string {
+	var addr = strings.TrimPrefix(string(kv.Key), prefix)
+	for _, kind := range []string{"providers/", "configurators/"} {
+		if !strings.HasPrefix(addr, kind) {
+			continue
+		}
+		uri, err := url.Parse(strings.TrimPrefix(addr, kind))
+		if err != nil {
+			return ""
+		}
+		return uri.Scheme
+	}
+	return ""
+}
+
 func isIPPort(addr string) bool {
 	_, _, err := net.SplitHostPort(addr)
 	return err == nil
@@ -463,6 +809,11 @@ val:
 key: /jupiter/main/configurator/grpc:///consumers/client-demo
 val:
 {
-
+	"outlier": { // 异常节点摘除
+		"enable": true,
+		"consecutiveErrors": 5,
+		"baseEjection": "30s",
+		"maxEjection": "5m"
+	}
 }
 */
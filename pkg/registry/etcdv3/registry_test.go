@@ -18,9 +18,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/douyu/jupiter/pkg/constant"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/douyu/jupiter/pkg/client/etcdv3"
 	"github.com/douyu/jupiter/pkg/registry"
 	"github.com/douyu/jupiter/pkg/server"
@@ -131,3 +134,245 @@ func Test_etcdv3registry_UpdateAddressList(t *testing.T) {
 	_ = reg.Close()
 	time.Sleep(time.Second * 1)
 }
+
+func Test_etcdv3Registry_StreamServices(t *testing.T) {
+	etcdConfig := etcdv3.DefaultConfig()
+	etcdConfig.Endpoints = []string{"127.0.0.1:2379"}
+	reg := newETCDRegistry(&Config{
+		Config:       etcdConfig,
+		ReadTimeout:  time.Second * 10,
+		Prefix:       "jupiter",
+		ListPageSize: 2,
+		logger:       xlog.DefaultLogger,
+	})
+	defer reg.Close()
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, reg.RegisterService(context.Background(), &server.ServiceInfo{
+			Name:    "service_stream",
+			Scheme:  "grpc",
+			Address: fmt.Sprintf("10.10.10.1:%d", 9091+i),
+			Kind:    constant.ServiceProvider,
+		}))
+	}
+
+	var pages []int
+	assert.Nil(t, reg.StreamServices(context.Background(), "service_stream", "grpc", func(page []*server.ServiceInfo) error {
+		pages = append(pages, len(page))
+		return nil
+	}))
+	assert.Equal(t, []int{2, 2, 1}, pages)
+
+	services, err := reg.ListServices(context.Background(), "service_stream", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(services))
+}
+
+func Test_etcdv3Registry_readOptions(t *testing.T) {
+	reg := &etcdv3Registry{Config: &Config{ReadConsistency: ConsistencySerializable}}
+	assert.Equal(t, 1, len(reg.readOptions(context.Background())))
+
+	ctx := WithReadConsistency(context.Background(), ConsistencyLinearizable)
+	assert.Equal(t, 0, len(reg.readOptions(ctx)))
+}
+
+func Test_etcdv3Registry_keyPrefix(t *testing.T) {
+	reg := &etcdv3Registry{Config: &Config{Prefix: "jupiter"}}
+	info := &server.ServiceInfo{Name: "app", Scheme: "grpc", Address: "127.0.0.1:9091", Kind: constant.ServiceProvider}
+	assert.Equal(t, "/jupiter/app/providers/grpc://127.0.0.1:9091", reg.registerKey(info))
+
+	reg.Tenant = "payment"
+	assert.Equal(t, "/jupiter/tenants/payment/app/providers/grpc://127.0.0.1:9091", reg.registerKey(info))
+	assert.Equal(t, "jupiter/tenants/payment", reg.keyPrefix(context.Background()))
+	assert.Equal(t, "jupiter/tenants/live", reg.keyPrefix(WithTenant(context.Background(), "live")))
+	assert.Equal(t, "jupiter", reg.keyPrefix(WithTenant(context.Background(), "")))
+}
+
+func Test_schemeOf(t *testing.T) {
+	prefix := "/jupiter/service_1/"
+	for key, scheme := range map[string]string{
+		"/jupiter/service_1/providers/grpc://10.10.10.1:9091": "grpc",
+		"/jupiter/service_1/providers/http://10.10.10.1:9092": "http",
+		"/jupiter/service_1/configurators/grpc:///routes/1":   "grpc",
+		"/jupiter/service_1/10.10.10.1:9091":                  "",
+		"/jupiter/service_1/consumers/grpc://10.10.10.1:9091": "",
+	} {
+		assert.Equal(t, scheme, schemeOf(prefix, &mvccpb.KeyValue{Key: []byte(key)}), key)
+	}
+}
+
+func Test_etcdv3Registry_WatchServicesByPrefix(t *testing.T) {
+	etcdConfig := etcdv3.DefaultConfig()
+	etcdConfig.Endpoints = []string{"127.0.0.1:2379"}
+	reg := newETCDRegistry(&Config{
+		Config:      etcdConfig,
+		ReadTimeout: time.Second * 10,
+		Prefix:      "jupiter",
+		logger:      xlog.DefaultLogger,
+	})
+	defer reg.Close()
+
+	for _, name := range []string{"payment-1", "payment-2", "order"} {
+		assert.Nil(t, reg.RegisterService(context.Background(), &server.ServiceInfo{
+			Name:    name,
+			Scheme:  "grpc",
+			Address: "10.10.10.1:9091",
+			Kind:    constant.ServiceProvider,
+		}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	services, err := reg.WatchServicesByPrefix(ctx, "payment-*", "grpc")
+	assert.Nil(t, err)
+	endpoints := <-services
+	assert.Equal(t, 2, len(endpoints))
+	assert.Equal(t, 1, endpoints["payment-1"].Nodes.Len())
+}
+
+func Test_etcdv3Registry_WatchServicesCancel(t *testing.T) {
+	etcdConfig := etcdv3.DefaultConfig()
+	etcdConfig.Endpoints = []string{"127.0.0.1:2379"}
+	reg := newETCDRegistry(&Config{
+		Config:      etcdConfig,
+		ReadTimeout: time.Second * 10,
+		Prefix:      "jupiter",
+		logger:      xlog.DefaultLogger,
+	})
+
+	closed := func(ch chan registry.Endpoints) bool {
+		for {
+			select {
+			case _, ok := <-ch:
+				if !ok {
+					return true
+				}
+			case <-time.After(time.Second * 3):
+				return false
+			}
+		}
+	}
+
+	// canceling ctx stops the watch
+	ctx, cancel := context.WithCancel(context.Background())
+	services, err := reg.WatchServices(ctx, "service_1", "grpc")
+	assert.Nil(t, err)
+	cancel()
+	assert.True(t, closed(services))
+
+	// closing the registry stops all watches
+	services, err = reg.WatchServices(context.Background(), "service_1", "grpc")
+	assert.Nil(t, err)
+	assert.Nil(t, reg.Close())
+	assert.True(t, closed(services))
+}
+
+func Test_etcdv3Registry_DeregisterDelay(t *testing.T) {
+	etcdConfig := etcdv3.DefaultConfig()
+	etcdConfig.Endpoints = []string{"127.0.0.1:2379"}
+	reg := newETCDRegistry(&Config{
+		Config:          etcdConfig,
+		ReadTimeout:     time.Second * 10,
+		Prefix:          "jupiter",
+		DeregisterDelay: time.Second,
+		logger:          xlog.DefaultLogger,
+	})
+	watcher := newETCDRegistry(&Config{
+		Config:      etcdConfig,
+		ReadTimeout: time.Second * 10,
+		Prefix:      "jupiter",
+		logger:      xlog.DefaultLogger,
+	})
+	defer watcher.Close()
+
+	info := &server.ServiceInfo{
+		Name:     "service_drain",
+		Scheme:   "grpc",
+		Address:  "10.10.10.1:9093",
+		Enable:   true,
+		Healthy:  true,
+		Metadata: map[string]string{},
+	}
+	assert.Nil(t, reg.RegisterService(context.Background(), info))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	endpoints, err := watcher.WatchServices(ctx, "service_drain", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, (<-endpoints).Nodes.Len())
+
+	// the watcher drops the instance before Close returns
+	closed := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		assert.Nil(t, reg.Close())
+		closed <- time.Since(start)
+	}()
+	select {
+	case msg := <-endpoints:
+		assert.Equal(t, 0, msg.Nodes.Len())
+	case <-closed:
+		t.Fatal("closed before the watcher dropped the instance")
+	}
+	assert.True(t, <-closed >= time.Second)
+}
+
+func Test_etcdv3Registry_RegisterServices(t *testing.T) {
+	etcdConfig := etcdv3.DefaultConfig()
+	etcdConfig.Endpoints = []string{"127.0.0.1:2379"}
+	reg := newETCDRegistry(&Config{
+		Config:      etcdConfig,
+		ReadTimeout: time.Second * 10,
+		Prefix:      "jupiter",
+		ServiceTTL:  time.Second * 10,
+		LeaseShards: 4,
+		logger:      xlog.DefaultLogger,
+	})
+
+	infos := []*server.ServiceInfo{
+		{Name: "service_batch", Scheme: "grpc", Address: "10.10.10.1:9094", Enable: true, Metadata: map[string]string{}},
+		{Name: "service_batch", Scheme: "http", Address: "10.10.10.1:9095", Enable: true, Metadata: map[string]string{}},
+	}
+	assert.Nil(t, reg.RegisterServices(context.Background(), infos))
+
+	var leases = make(map[int64]bool)
+	for _, info := range infos {
+		resp, err := reg.client.Get(context.Background(), reg.registerKey(info))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(resp.Kvs))
+		leases[resp.Kvs[0].Lease] = true
+	}
+	// both keys are attached to one lease
+	assert.Equal(t, 1, len(leases))
+
+	assert.Nil(t, reg.Close())
+	services, err := newETCDRegistry(&Config{
+		Config:      etcdConfig,
+		ReadTimeout: time.Second * 10,
+		Prefix:      "jupiter",
+		logger:      xlog.DefaultLogger,
+	}).ListServices(context.Background(), "service_batch", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(services))
+}
+
+func Test_etcdv3Registry_unreachable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	config := DefaultConfig()
+	config.Endpoints = []string{"127.0.0.1:1"}
+	config.ListTimeout = 100 * time.Millisecond
+	config.Snapshot.Dir = dir
+	config.logger = xlog.DefaultLogger
+
+	// doesn't block nor panic with snapshots
+	reg := config.Build()
+	defer reg.Close()
+
+	// the initial read is bounded, so that snapshots can be served
+	start := time.Now()
+	_, err = reg.WatchServices(context.Background(), "app", "grpc")
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
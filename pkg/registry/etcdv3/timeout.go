@@ -0,0 +1,78 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcdv3
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// registry operations with distinct timeouts
+const (
+	opRegister   = "register"
+	opUnregister = "unregister"
+	opList       = "list"
+	opGrant      = "grant"
+	opKeepalive  = "keepalive"
+)
+
+// timeout returns the timeout of op, falls back to ReadTimeout if it's not set
+func (config *Config) timeout(op string) time.Duration {
+	var timeout time.Duration
+	switch op {
+	case opRegister:
+		timeout = config.RegisterTimeout
+	case opUnregister:
+		timeout = config.UnregisterTimeout
+	case opList:
+		timeout = config.ListTimeout
+	case opGrant:
+		timeout = config.GrantTimeout
+	case opKeepalive:
+		timeout = config.KeepaliveTimeout
+	}
+	if timeout <= 0 {
+		timeout = config.ReadTimeout
+	}
+	return timeout
+}
+
+// withTimeout bounds ctx with the timeout of op unless ctx has a deadline
+func (config *Config) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	if timeout := config.timeout(op); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return ctx, func() {}
+}
+
+// observe counts timeouts of op, err is returned as is
+func (config *Config) observe(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded {
+		metric.LibHandleCounter.Inc("registry.etcd", op, strings.Join(config.Endpoints, ","), "timeout")
+	}
+	return err
+}
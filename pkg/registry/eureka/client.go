@@ -0,0 +1,293 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errNotFound is returned for unknown instances, e.g. heartbeats of instances
+// evicted by eureka
+var errNotFound = errors.New("instance not found")
+
+// statusUp is the status of instances serving traffic
+const statusUp = "UP"
+
+// action types of instances in deltas
+const (
+	actionAdded    = "ADDED"
+	actionModified = "MODIFIED"
+	actionDeleted  = "DELETED"
+)
+
+// client is a minimal client of the eureka rest api
+type client struct {
+	config *Config
+	http   *http.Client
+
+	mu      sync.Mutex
+	current int
+}
+
+// instance is an instance info of eureka in json
+type instance struct {
+	InstanceID       string            `json:"instanceId"`
+	HostName         string            `json:"hostName"`
+	App              string            `json:"app"`
+	IPAddr           string            `json:"ipAddr"`
+	Status           string            `json:"status"`
+	Port             port              `json:"port"`
+	SecurePort       port              `json:"securePort"`
+	VipAddress       string            `json:"vipAddress,omitempty"`
+	SecureVipAddress string            `json:"secureVipAddress,omitempty"`
+	DataCenterInfo   dataCenterInfo    `json:"dataCenterInfo"`
+	LeaseInfo        *leaseInfo        `json:"leaseInfo,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	ActionType       string            `json:"actionType,omitempty"`
+}
+
+type port struct {
+	Port    int
+	Enabled bool
+}
+
+type portJSON struct {
+	Port    json.RawMessage `json:"$"`
+	Enabled string          `json:"@enabled"`
+}
+
+// MarshalJSON ...
+func (p port) MarshalJSON() ([]byte, error) {
+	return json.Marshal(portJSON{Port: json.RawMessage(strconv.Itoa(p.Port)), Enabled: strconv.FormatBool(p.Enabled)})
+}
+
+// UnmarshalJSON accepts ports in numbers and strings, older servers encode
+// them as strings
+func (p *port) UnmarshalJSON(data []byte) error {
+	var raw portJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw.Port) > 0 {
+		n, err := strconv.Atoi(strings.Trim(string(raw.Port), `"`))
+		if err != nil {
+			return fmt.Errorf("invalid port %s", raw.Port)
+		}
+		p.Port = n
+	}
+	p.Enabled = raw.Enabled == "true"
+	return nil
+}
+
+type dataCenterInfo struct {
+	Class string `json:"@class"`
+	Name  string `json:"name"`
+}
+
+type leaseInfo struct {
+	RenewalIntervalInSecs int `json:"renewalIntervalInSecs"`
+	DurationInSecs        int `json:"durationInSecs"`
+}
+
+// instanceList decodes single instances encoded as objects by older servers
+type instanceList []instance
+
+// UnmarshalJSON ...
+func (l *instanceList) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+		var one instance
+		if err := json.Unmarshal(data, &one); err != nil {
+			return err
+		}
+		*l = instanceList{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]instance)(l))
+}
+
+type application struct {
+	Name      string       `json:"name"`
+	Instances instanceList `json:"instance"`
+}
+
+// applicationList decodes single applications encoded as objects by older
+// servers
+type applicationList []application
+
+// UnmarshalJSON ...
+func (l *applicationList) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+		var one application
+		if err := json.Unmarshal(data, &one); err != nil {
+			return err
+		}
+		*l = applicationList{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]application)(l))
+}
+
+type applications struct {
+	HashCode     string          `json:"apps__hashcode"`
+	Applications applicationList `json:"application"`
+}
+
+func (c *client) register(ctx context.Context, ins *instance) error {
+	return c.do(ctx, http.MethodPost, "/apps/"+url.PathEscape(ins.App), nil, map[string]*instance{"instance": ins}, nil)
+}
+
+func (c *client) deregister(ctx context.Context, app, id string) error {
+	err := c.do(ctx, http.MethodDelete, "/apps/"+url.PathEscape(app)+"/"+url.PathEscape(id), nil, nil, nil)
+	if err == errNotFound {
+		return nil
+	}
+	return err
+}
+
+// heartbeat renews the lease of instance, errNotFound is returned if eureka
+// doesn't know it any more
+func (c *client) heartbeat(ctx context.Context, app, id string) error {
+	query := url.Values{"status": {statusUp}}
+	return c.do(ctx, http.MethodPut, "/apps/"+url.PathEscape(app)+"/"+url.PathEscape(id), query, nil, nil)
+}
+
+// application fetches instances of app
+func (c *client) application(ctx context.Context, app string) ([]instance, error) {
+	var out struct {
+		Application application `json:"application"`
+	}
+	err := c.do(ctx, http.MethodGet, "/apps/"+url.PathEscape(app), nil, nil, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&out)
+	})
+	if err == errNotFound {
+		return nil, nil
+	}
+	return out.Application.Instances, err
+}
+
+// applications fetches the full registry, or the recent changes of it if
+// delta is true
+func (c *client) applications(ctx context.Context, delta bool) (*applications, error) {
+	path := "/apps/"
+	if delta {
+		path = "/apps/delta"
+	}
+	var out struct {
+		Applications applications `json:"applications"`
+	}
+	err := c.do(ctx, http.MethodGet, path, nil, nil, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &out.Applications, nil
+}
+
+// do sends the request to the current server, the next servers are tried
+// in order if it fails
+func (c *client) do(ctx context.Context, method, path string, query url.Values, in interface{}, out func(*http.Response) error) (err error) {
+	var body []byte
+	if in != nil {
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	current := c.current
+	c.mu.Unlock()
+	for i := 0; i < len(c.config.ServiceURLs); i++ {
+		idx := (current + i) % len(c.config.ServiceURLs)
+		var retry bool
+		if retry, err = c.doURL(ctx, c.config.ServiceURLs[idx], method, path, query, body, out); !retry {
+			if i > 0 {
+				c.mu.Lock()
+				c.current = idx
+				c.mu.Unlock()
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// doURL sends the request to server of base, it's worth trying other servers
+// if retry is true
+func (c *client) doURL(ctx context.Context, base, method, path string, query url.Values, body []byte, out func(*http.Response) error) (retry bool, err error) {
+	u := strings.TrimSuffix(base, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, errNotFound
+	case resp.StatusCode >= 300:
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode >= 500, fmt.Errorf("eureka %s %s: %d %s", method, path, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		return false, out(resp)
+	}
+	return false, nil
+}
+
+// hashCode is the reconcile hash code of instances of apps computed the way
+// of eureka, e.g. "DOWN_1_UP_3_", deltas are applied correctly if it equals
+// the one of the server
+func hashCode(apps map[string]map[string]instance) string {
+	var counts = make(map[string]int)
+	for _, instances := range apps {
+		for _, ins := range instances {
+			counts[ins.Status]++
+		}
+	}
+	var statuses = make([]string, 0, len(counts))
+	for status := range counts {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	var sb strings.Builder
+	for _, status := range statuses {
+		fmt.Fprintf(&sb, "%s_%d_", status, counts[status])
+	}
+	return sb.String()
+}
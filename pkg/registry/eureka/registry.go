@@ -0,0 +1,580 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// metaPrefix prefixes fields of ServiceInfo kept in eureka metadata,
+// metadata of ServiceInfo is kept as is to interoperate with Spring Cloud
+const metaPrefix = "jupiter."
+
+// labelPrefix prefixes keys of ServiceInfo.Labels in eureka metadata
+const labelPrefix = metaPrefix + "label."
+
+// zoneKey is the metadata key of zones used by Spring Cloud
+const zoneKey = "zone"
+
+type eurekaRegistry struct {
+	*Config
+	client *client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	instances map[string]*registration
+
+	// fetchMu guards the first fetch of the registry
+	fetchMu  sync.Mutex
+	fetching bool
+
+	// cacheMu guards the local copy of the registry, instances are keyed by
+	// app and instance id, versions of apps are bumped on changes
+	cacheMu  sync.RWMutex
+	apps     map[string]map[string]instance
+	versions map[string]uint64
+	changed  chan struct{}
+}
+
+type registration struct {
+	instance *instance
+	cancel   context.CancelFunc
+}
+
+var _ registry.Registry = &eurekaRegistry{}
+
+func newEurekaRegistry(config *Config) *eurekaRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &eurekaRegistry{
+		Config:    config,
+		client:    &client{config: config, http: &http.Client{}},
+		ctx:       ctx,
+		cancel:    cancel,
+		instances: make(map[string]*registration),
+		apps:      make(map[string]map[string]instance),
+		versions:  make(map[string]uint64),
+		changed:   make(chan struct{}),
+	}
+}
+
+// RegisterService registers service as an instance of eureka, failed
+// registration is retried with jittered backoff
+func (reg *eurekaRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	ins, err := reg.toInstance(info)
+	if err != nil {
+		return err
+	}
+	if err := reg.register(ctx, ins); err != nil {
+		reg.logger.Error("register service", xlog.FieldErr(err), xlog.FieldKey(ins.InstanceID), xlog.FieldValueAny(info))
+		return err
+	}
+
+	hbCtx, cancel := context.WithCancel(reg.ctx)
+	reg.mu.Lock()
+	if prev, ok := reg.instances[ins.InstanceID]; ok {
+		prev.cancel()
+	}
+	reg.instances[ins.InstanceID] = &registration{instance: ins, cancel: cancel}
+	reg.mu.Unlock()
+
+	xgo.Go(func() { reg.heartbeat(hbCtx, ins) })
+	reg.logger.Info("register service", xlog.FieldKey(ins.InstanceID), xlog.FieldValueAny(info))
+	return nil
+}
+
+func (reg *eurekaRegistry) register(ctx context.Context, ins *instance) (err error) {
+	start := time.Now()
+	defer func() { registry.ObserveOperation("eureka", "register", start, err) }()
+	return xbackoff.Retry(ctx, reg.Backoff, reg.clock, func() error {
+		reqCtx, cancel := context.WithTimeout(ctx, reg.Timeout)
+		defer cancel()
+		return reg.client.register(reqCtx, ins)
+	})
+}
+
+// heartbeat renews the lease of instance, the instance is registered again
+// if eureka lost it, e.g. after it was evicted
+func (reg *eurekaRegistry) heartbeat(ctx context.Context, ins *instance) {
+	ticker := reg.clock.NewTicker(reg.RenewalInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		reqCtx, cancel := context.WithTimeout(ctx, reg.Timeout)
+		err := reg.client.heartbeat(reqCtx, ins.App, ins.InstanceID)
+		cancel()
+		if ctx.Err() != nil {
+			continue
+		}
+		registry.ObserveLeaseRenewal("eureka", err)
+		if err == nil {
+			continue
+		}
+		reg.logger.Warn("eureka heartbeat", xlog.FieldErr(err), xlog.FieldKey(ins.InstanceID))
+		if err != errNotFound {
+			continue
+		}
+		if err := reg.register(ctx, ins); err != nil && ctx.Err() == nil {
+			reg.logger.Error("register service again", xlog.FieldErr(err), xlog.FieldKey(ins.InstanceID))
+		}
+	}
+}
+
+// UnregisterService deregisters service from eureka
+func (reg *eurekaRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	return reg.unregister(ctx, appName(info.Name), instanceID(info))
+}
+
+func (reg *eurekaRegistry) unregister(ctx context.Context, app, id string) (err error) {
+	start := time.Now()
+	defer func() { registry.ObserveOperation("eureka", "unregister", start, err) }()
+	reg.mu.Lock()
+	if r, ok := reg.instances[id]; ok {
+		r.cancel()
+		delete(reg.instances, id)
+	}
+	reg.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, reg.Timeout)
+	defer cancel()
+	return reg.client.deregister(ctx, app, id)
+}
+
+// ListServices lists providers of name and scheme which are up
+func (reg *eurekaRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, reg.Timeout)
+	defer cancel()
+	instances, err := reg.client.application(ctx, appName(name))
+	if err != nil {
+		return nil, err
+	}
+	var services = make([]*server.ServiceInfo, 0, len(instances))
+	for _, ins := range instances {
+		info, ok := toServiceInfo(ins, name, scheme)
+		if !ok {
+			continue
+		}
+		services = append(services, &info)
+	}
+	return registry.FilterServices(ctx, services)
+}
+
+// WatchServices watches providers of name and scheme which are up. The
+// registry is fetched once and kept up to date with delta fetches every
+// FetchInterval, shared by all watchers. Only nodes are watched, route and
+// provider configs of etcd configurators are not supported by eureka.
+func (reg *eurekaRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	store, err := reg.WatchSnapshots(ctx, name, scheme)
+	if err != nil {
+		return nil, err
+	}
+	return registry.StreamEndpoints(ctx, store), nil
+}
+
+// WatchSnapshots watches providers of name and scheme which are up into a
+// store, which is closed once ctx is done or the registry is closed
+func (reg *eurekaRegistry) WatchSnapshots(ctx context.Context, name string, scheme string) (*registry.EndpointsStore, error) {
+	if _, err := registry.LabelSelectorFromContext(ctx); err != nil {
+		return nil, err
+	}
+	if err := reg.startFetching(ctx); err != nil {
+		return nil, err
+	}
+
+	var app = appName(name)
+	var store = registry.NewEndpointsStore()
+	instances, version, changed := reg.lookup(app)
+	var notify = func() {
+		// 基于上一版本生成新快照, 未变更的部分共享
+		store.Update(func(tx *registry.EndpointsTx) {
+			updateNodes(tx, tx.PrevNodes(), name, scheme, instances)
+		})
+	}
+	notify()
+
+	xgo.Go(func() {
+		defer store.Close()
+		ctx, cancel := reg.watchContext(ctx)
+		defer cancel()
+		for {
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+			var newVersion uint64
+			instances, newVersion, changed = reg.lookup(app)
+			if newVersion == version {
+				continue
+			}
+			version = newVersion
+			notify()
+		}
+	})
+	return registry.FilterStore(ctx, store)
+}
+
+// startFetching fetches the full registry and starts fetching changes of it
+// in background, once
+func (reg *eurekaRegistry) startFetching(ctx context.Context) error {
+	reg.fetchMu.Lock()
+	defer reg.fetchMu.Unlock()
+	if reg.fetching {
+		return nil
+	}
+	if err := reg.fetch(ctx, false); err != nil {
+		return err
+	}
+	reg.fetching = true
+	xgo.Go(reg.fetchLoop)
+	return nil
+}
+
+func (reg *eurekaRegistry) fetchLoop() {
+	ticker := reg.clock.NewTicker(reg.FetchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-reg.ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		if err := reg.fetch(reg.ctx, !reg.DisableDelta); err != nil && reg.ctx.Err() == nil {
+			reg.logger.Error("fetch registry", xlog.FieldErr(err), xlog.Any("delta", !reg.DisableDelta))
+		}
+	}
+}
+
+// fetch fetches the full registry, or applies the changes of it if delta is
+// true, the full registry is fetched again if the local copy diverged
+func (reg *eurekaRegistry) fetch(ctx context.Context, delta bool) (err error) {
+	start := time.Now()
+	defer func() { registry.ObserveOperation("eureka", "fetch", start, err) }()
+	reqCtx, cancel := context.WithTimeout(ctx, reg.Timeout)
+	defer cancel()
+	apps, err := reg.client.applications(reqCtx, delta)
+	if err != nil {
+		return err
+	}
+	if !delta {
+		reg.replace(apps)
+		return nil
+	}
+	if reg.applyDelta(apps) {
+		return nil
+	}
+	reg.logger.Warn("reconcile registry", xlog.String("hashcode", apps.HashCode))
+	if apps, err = reg.client.applications(reqCtx, false); err != nil {
+		return err
+	}
+	reg.replace(apps)
+	return nil
+}
+
+// replace replaces the local copy of the registry with apps
+func (reg *eurekaRegistry) replace(apps *applications) {
+	var fetched = make(map[string]map[string]instance, len(apps.Applications))
+	for _, app := range apps.Applications {
+		var instances = make(map[string]instance, len(app.Instances))
+		for _, ins := range app.Instances {
+			ins.ActionType = ""
+			instances[ins.InstanceID] = ins
+		}
+		fetched[app.Name] = instances
+	}
+
+	reg.cacheMu.Lock()
+	defer reg.cacheMu.Unlock()
+	var changed bool
+	for name, instances := range fetched {
+		if !reflect.DeepEqual(instances, reg.apps[name]) {
+			reg.versions[name]++
+			changed = true
+		}
+	}
+	for name := range reg.apps {
+		if _, ok := fetched[name]; !ok {
+			reg.versions[name]++
+			changed = true
+		}
+	}
+	reg.apps = fetched
+	if changed {
+		reg.broadcast()
+	}
+}
+
+// applyDelta applies changes of the registry to the local copy, false is
+// returned if the local copy diverged from the one of the server
+func (reg *eurekaRegistry) applyDelta(delta *applications) bool {
+	reg.cacheMu.Lock()
+	defer reg.cacheMu.Unlock()
+	var changed bool
+	for _, app := range delta.Applications {
+		for _, ins := range app.Instances {
+			instances, ok := reg.apps[app.Name]
+			if !ok {
+				instances = make(map[string]instance)
+				reg.apps[app.Name] = instances
+			}
+			// deltas of the last minutes are replayed on every fetch
+			prev, exists := instances[ins.InstanceID]
+			action := ins.ActionType
+			ins.ActionType = ""
+			switch {
+			case (action == actionAdded || action == actionModified) && !reflect.DeepEqual(prev, ins):
+				instances[ins.InstanceID] = ins
+			case action == actionDeleted && exists:
+				delete(instances, ins.InstanceID)
+			default:
+				continue
+			}
+			reg.versions[app.Name]++
+			changed = true
+		}
+	}
+	for name, instances := range reg.apps {
+		if len(instances) == 0 {
+			delete(reg.apps, name)
+		}
+	}
+	if changed {
+		reg.broadcast()
+	}
+	return delta.HashCode == "" || delta.HashCode == hashCode(reg.apps)
+}
+
+// broadcast wakes up watchers, cacheMu must be held
+func (reg *eurekaRegistry) broadcast() {
+	close(reg.changed)
+	reg.changed = make(chan struct{})
+}
+
+// lookup returns instances and the version of app in the local copy, changed
+// is closed on the next change of the registry
+func (reg *eurekaRegistry) lookup(app string) (instances []instance, version uint64, changed <-chan struct{}) {
+	reg.cacheMu.RLock()
+	defer reg.cacheMu.RUnlock()
+	instances = make([]instance, 0, len(reg.apps[app]))
+	for _, ins := range reg.apps[app] {
+		instances = append(instances, ins)
+	}
+	return instances, reg.versions[app], reg.changed
+}
+
+// watchContext is done once ctx is done or the registry is closed
+func (reg *eurekaRegistry) watchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	xgo.Go(func() {
+		select {
+		case <-reg.ctx.Done():
+		case <-watchCtx.Done():
+		}
+		cancel()
+	})
+	return watchCtx, cancel
+}
+
+// Close deregisters all instances registered by the registry
+func (reg *eurekaRegistry) Close() error {
+	reg.mu.Lock()
+	var registrations = make([]*instance, 0, len(reg.instances))
+	for _, r := range reg.instances {
+		registrations = append(registrations, r.instance)
+	}
+	reg.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ins := range registrations {
+		wg.Add(1)
+		go func(ins *instance) {
+			defer wg.Done()
+			if err := reg.unregister(context.Background(), ins.App, ins.InstanceID); err != nil {
+				reg.logger.Error("unregister service", xlog.FieldErr(err), xlog.FieldKey(ins.InstanceID))
+			} else {
+				reg.logger.Info("unregister service", xlog.FieldKey(ins.InstanceID))
+			}
+		}(ins)
+	}
+	wg.Wait()
+	reg.cancel()
+	return nil
+}
+
+// updateNodes replaces nodes of tx with instances
+func updateNodes(tx *registry.EndpointsTx, prev *registry.Nodes, name, scheme string, instances []instance) {
+	var seen = make(map[string]struct{}, len(instances))
+	for _, ins := range instances {
+		info, ok := toServiceInfo(ins, name, scheme)
+		if !ok {
+			continue
+		}
+		tx.SetNode(info.Label(), info)
+		seen[info.Label()] = struct{}{}
+	}
+	if prev == nil {
+		return
+	}
+	prev.Range(func(addr string, _ server.ServiceInfo) bool {
+		if _, ok := seen[addr]; !ok {
+			tx.DeleteNode(addr)
+		}
+		return true
+	})
+}
+
+// appName is the name of apps in eureka, which are upper cased
+func appName(name string) string {
+	return strings.ToUpper(name)
+}
+
+// instanceID follows the default of Spring Cloud, "host:name:port"
+func instanceID(info *server.ServiceInfo) string {
+	host, port, err := net.SplitHostPort(info.Address)
+	if err != nil {
+		return info.Name + ":" + info.Address
+	}
+	return host + ":" + info.Name + ":" + port
+}
+
+// toInstance maps info to an eureka instance, fields of info are kept in
+// metadata
+func (reg *eurekaRegistry) toInstance(info *server.ServiceInfo) (*instance, error) {
+	host, portStr, err := net.SplitHostPort(info.Address)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta = make(map[string]string, len(info.Metadata)+len(info.Labels)+10)
+	for k, v := range info.Metadata {
+		meta[k] = v
+	}
+	for k, v := range info.Labels {
+		meta[labelPrefix+k] = v
+	}
+	for k, v := range map[string]string{
+		"scheme":     info.Scheme,
+		"appId":      info.AppID,
+		"weight":     strconv.FormatFloat(info.Weight, 'f', -1, 64),
+		"enable":     strconv.FormatBool(info.Enable),
+		"healthy":    strconv.FormatBool(info.Healthy),
+		"region":     info.Region,
+		"kind":       strconv.Itoa(int(info.Kind)),
+		"deployment": info.Deployment,
+		"group":      info.Group,
+		"status":     info.Status,
+	} {
+		meta[metaPrefix+k] = v
+	}
+	if info.Zone != "" {
+		meta[zoneKey] = info.Zone
+	}
+
+	return &instance{
+		InstanceID:       instanceID(info),
+		HostName:         host,
+		App:              appName(info.Name),
+		IPAddr:           host,
+		Status:           statusUp,
+		Port:             port{Port: portNum, Enabled: true},
+		SecurePort:       port{Port: 443},
+		VipAddress:       info.Name,
+		SecureVipAddress: info.Name,
+		DataCenterInfo:   dataCenterInfo{Class: "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo", Name: "MyOwn"},
+		LeaseInfo: &leaseInfo{
+			RenewalIntervalInSecs: int(reg.RenewalInterval / time.Second),
+			DurationInSecs:        int(reg.LeaseDuration / time.Second),
+		},
+		Metadata: meta,
+	}, nil
+}
+
+// toServiceInfo maps an instance of name back to info, ok is false unless
+// it's a provider of scheme which is up. Instances registered by others,
+// e.g. Spring Cloud services, are http providers.
+func toServiceInfo(ins instance, name, scheme string) (info server.ServiceInfo, ok bool) {
+	meta := ins.Metadata
+	if ins.Status != statusUp {
+		return info, false
+	}
+	if s := meta[metaPrefix+"scheme"]; s != scheme && (s != "" || scheme != "http") {
+		return info, false
+	}
+	portNum := ins.Port.Port
+	if !ins.Port.Enabled && ins.SecurePort.Enabled {
+		portNum = ins.SecurePort.Port
+	}
+	info = server.ServiceInfo{
+		Name:       name,
+		AppID:      meta[metaPrefix+"appId"],
+		Scheme:     scheme,
+		Address:    net.JoinHostPort(ins.IPAddr, strconv.Itoa(portNum)),
+		Region:     meta[metaPrefix+"region"],
+		Zone:       meta[zoneKey],
+		Deployment: meta[metaPrefix+"deployment"],
+		Group:      meta[metaPrefix+"group"],
+		Status:     meta[metaPrefix+"status"],
+		Enable:     meta[metaPrefix+"enable"] != "false",
+		Healthy:    meta[metaPrefix+"healthy"] != "false",
+		Weight:     100,
+		Kind:       constant.ServiceProvider,
+		Metadata:   make(map[string]string),
+	}
+	if weight, err := strconv.ParseFloat(meta[metaPrefix+"weight"], 64); err == nil {
+		info.Weight = weight
+	}
+	if kind, err := strconv.Atoi(meta[metaPrefix+"kind"]); err == nil {
+		info.Kind = constant.ServiceKind(kind)
+	}
+	if info.Kind != constant.ServiceProvider {
+		return info, false
+	}
+	for k, v := range meta {
+		switch {
+		case strings.HasPrefix(k, labelPrefix):
+			if info.Labels == nil {
+				info.Labels = make(map[string]string)
+			}
+			info.Labels[strings.TrimPrefix(k, labelPrefix)] = v
+		case strings.HasPrefix(k, metaPrefix), k == zoneKey, k == "@class":
+			// fields of info, or the class of empty metadata of eureka
+		default:
+			info.Metadata[k] = v
+		}
+	}
+	return info, true
+}
@@ -0,0 +1,309 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eureka
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServer serves the eureka rest api used by the registry
+type fakeServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	apps     map[string]map[string]instance
+	deltas   []instance
+	renewals map[string]int
+	fetches  map[string]int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{
+		apps:     make(map[string]map[string]instance),
+		renewals: make(map[string]int),
+		fetches:  make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/eureka/apps/"), "/")
+	switch {
+	case r.Method == http.MethodGet && parts[0] == "":
+		s.fetches["full"]++
+		s.write(w, "applications", s.applications(s.all()))
+	case r.Method == http.MethodGet && parts[0] == "delta":
+		s.fetches["delta"]++
+		s.write(w, "applications", s.applications(s.deltas))
+		s.deltas = nil
+	case r.Method == http.MethodGet:
+		if _, ok := s.apps[parts[0]]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		var instances []instance
+		for _, ins := range s.apps[parts[0]] {
+			instances = append(instances, ins)
+		}
+		s.write(w, "application", application{Name: parts[0], Instances: instances})
+	case r.Method == http.MethodPost:
+		var body struct{ Instance instance }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		s.put(body.Instance)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		if _, ok := s.apps[parts[0]][parts[1]]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		s.renewals[parts[1]]++
+	case r.Method == http.MethodDelete:
+		ins, ok := s.apps[parts[0]][parts[1]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		s.drop(parts[0], parts[1])
+		ins.ActionType = actionDeleted
+		s.deltas = append(s.deltas, ins)
+	}
+}
+
+func (s *fakeServer) write(w http.ResponseWriter, key string, value interface{}) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{key: value})
+}
+
+// put is called with mu held
+func (s *fakeServer) put(ins instance) {
+	if s.apps[ins.App] == nil {
+		s.apps[ins.App] = make(map[string]instance)
+	}
+	s.apps[ins.App][ins.InstanceID] = ins
+	ins.ActionType = actionAdded
+	s.deltas = append(s.deltas, ins)
+}
+
+// drop is called with mu held, the change is not recorded in deltas
+func (s *fakeServer) drop(app, id string) {
+	delete(s.apps[app], id)
+	if len(s.apps[app]) == 0 {
+		delete(s.apps, app)
+	}
+}
+
+func (s *fakeServer) all() []instance {
+	var all []instance
+	for _, instances := range s.apps {
+		for _, ins := range instances {
+			all = append(all, ins)
+		}
+	}
+	return all
+}
+
+func (s *fakeServer) applications(instances []instance) applications {
+	var byApp = make(map[string][]instance)
+	for _, ins := range instances {
+		byApp[ins.App] = append(byApp[ins.App], ins)
+	}
+	apps := applications{HashCode: hashCode(s.apps)}
+	for name, instances := range byApp {
+		apps.Applications = append(apps.Applications, application{Name: name, Instances: instances})
+	}
+	return apps
+}
+
+func (s *fakeServer) lose(app, id string) {
+	s.mu.Lock()
+	s.drop(app, id)
+	s.mu.Unlock()
+}
+
+func (s *fakeServer) count(m map[string]int, key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return m[key]
+}
+
+func newTestRegistry(s *fakeServer, clock xtime.Clock) *eurekaRegistry {
+	config := DefaultConfig()
+	config.ServiceURLs = []string{s.URL + "/eureka"}
+	config.clock = clock
+	return newEurekaRegistry(config)
+}
+
+func newInfo(addr string) *server.ServiceInfo {
+	return &server.ServiceInfo{
+		Name:     "user",
+		Scheme:   "grpc",
+		Address:  addr,
+		Weight:   100,
+		Enable:   true,
+		Healthy:  true,
+		Kind:     constant.ServiceProvider,
+		Zone:     "z1",
+		Metadata: map[string]string{"version": "v1"},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	s := newFakeServer(t)
+	clock := xtime.NewMockClock(time.Now())
+	reg := newTestRegistry(s, clock)
+	ctx := context.Background()
+
+	assert.Nil(t, reg.RegisterService(ctx, newInfo("10.0.0.1:9091")))
+	governor := newInfo("10.0.0.1:9990")
+	governor.Kind = constant.ServiceGovernor
+	assert.Nil(t, reg.RegisterService(ctx, governor))
+
+	services, err := reg.ListServices(ctx, "user", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 1, "governors are not providers")
+	assert.Equal(t, newInfo("10.0.0.1:9091"), services[0])
+	s.mu.Lock()
+	ins := s.apps["USER"]["10.0.0.1:user:9091"]
+	s.mu.Unlock()
+	assert.Equal(t, 90, ins.LeaseInfo.DurationInSecs)
+	assert.Equal(t, "z1", ins.Metadata["zone"])
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err := reg.WatchServices(watchCtx, "user", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, (<-ch).Nodes.Len())
+
+	// changes are fetched with deltas every FetchInterval
+	assert.Nil(t, reg.RegisterService(ctx, newInfo("10.0.0.2:9091")))
+	clock.BlockUntil(4)
+	clock.Advance(reg.FetchInterval)
+	endpoints := <-ch
+	assert.Equal(t, 2, endpoints.Nodes.Len())
+	info, ok := endpoints.Nodes.Get("grpc://10.0.0.2:9091")
+	assert.True(t, ok)
+	assert.Equal(t, "v1", info.Metadata["version"])
+
+	assert.Nil(t, reg.UnregisterService(ctx, newInfo("10.0.0.1:9091")))
+	clock.Advance(reg.FetchInterval)
+	endpoints = <-ch
+	assert.Equal(t, 1, endpoints.Nodes.Len())
+	_, ok = endpoints.Nodes.Get("grpc://10.0.0.1:9091")
+	assert.False(t, ok)
+	assert.Equal(t, 1, s.count(s.fetches, "full"))
+	assert.Equal(t, 2, s.count(s.fetches, "delta"))
+
+	assert.Nil(t, reg.Close())
+	s.mu.Lock()
+	assert.Empty(t, s.apps)
+	s.mu.Unlock()
+}
+
+func TestRegistry_Heartbeat(t *testing.T) {
+	s := newFakeServer(t)
+	clock := xtime.NewMockClock(time.Now())
+	reg := newTestRegistry(s, clock)
+	defer reg.Close()
+
+	info := newInfo("10.0.0.1:9091")
+	id := instanceID(info)
+	assert.Nil(t, reg.RegisterService(context.Background(), info))
+
+	clock.BlockUntil(1)
+	clock.Advance(reg.RenewalInterval)
+	assert.Eventually(t, func() bool { return s.count(s.renewals, id) == 1 }, time.Second, time.Millisecond)
+
+	// registered again once eureka lost it
+	s.lose("USER", id)
+	clock.Advance(reg.RenewalInterval)
+	assert.Eventually(t, func() bool {
+		services, err := reg.ListServices(context.Background(), "user", "grpc")
+		return err == nil && len(services) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestRegistry_Reconcile(t *testing.T) {
+	s := newFakeServer(t)
+	clock := xtime.NewMockClock(time.Now())
+	reg := newTestRegistry(s, clock)
+	reg.RenewalInterval = time.Hour
+	defer reg.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, reg.RegisterService(ctx, newInfo("10.0.0.1:9091")))
+	assert.Nil(t, reg.RegisterService(ctx, newInfo("10.0.0.2:9091")))
+	ch, err := reg.WatchServices(ctx, "user", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, (<-ch).Nodes.Len())
+
+	// the change is missing in deltas, hash codes diverge
+	s.lose("USER", "10.0.0.2:user:9091")
+	clock.BlockUntil(3)
+	clock.Advance(reg.FetchInterval)
+	endpoints := <-ch
+	assert.Equal(t, 1, endpoints.Nodes.Len())
+	assert.Equal(t, 2, s.count(s.fetches, "full"))
+}
+
+func Test_toServiceInfo(t *testing.T) {
+	// an instance of Spring Cloud encoded by older servers
+	var out struct {
+		Applications applications `json:"applications"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(`{"applications": {"apps__hashcode": "UP_1_", "application": {"name": "ORDER", "instance": {
+		"instanceId": "10.0.0.3:order:8080", "app": "ORDER", "ipAddr": "10.0.0.3", "status": "UP",
+		"port": {"$": "8080", "@enabled": "true"}, "securePort": {"$": 443, "@enabled": "false"},
+		"metadata": {"zone": "z2", "management.port": "8081"}}}}}`), &out))
+	assert.Len(t, out.Applications.Applications, 1)
+	instances := out.Applications.Applications[0].Instances
+	assert.Len(t, instances, 1)
+	assert.Equal(t, "UP_1_", hashCode(map[string]map[string]instance{"ORDER": {"x": instances[0]}}))
+
+	info, ok := toServiceInfo(instances[0], "order", "http")
+	assert.True(t, ok)
+	assert.Equal(t, server.ServiceInfo{
+		Name:     "order",
+		Scheme:   "http",
+		Address:  "10.0.0.3:8080",
+		Zone:     "z2",
+		Weight:   100,
+		Enable:   true,
+		Healthy:  true,
+		Kind:     constant.ServiceProvider,
+		Metadata: map[string]string{"management.port": "8081"},
+	}, info)
+	_, ok = toServiceInfo(instances[0], "order", "grpc")
+	assert.False(t, ok)
+
+	instances[0].Status = "DOWN"
+	_, ok = toServiceInfo(instances[0], "order", "http")
+	assert.False(t, ok)
+	assert.Equal(t, "DOWN_1_UP_1_", hashCode(map[string]map[string]instance{"ORDER": {"x": instances[0]}, "USER": {"y": {Status: statusUp}}}))
+}
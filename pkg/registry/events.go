@@ -0,0 +1,83 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+)
+
+// NodeEvent is a change of a node of a watched service
+type NodeEvent struct {
+	// Event is EventAdd, EventUpdate or EventDelete
+	Event
+	Address string
+	// Node is the latest node, or the deleted one of EventDelete
+	Node server.ServiceInfo
+}
+
+// EventWatcher is implemented by registries which can watch changes of nodes
+// natively, instead of diffing endpoints of WatchServices.
+type EventWatcher interface {
+	WatchServiceEvents(ctx context.Context, name string, scheme string) (chan NodeEvent, error)
+}
+
+// WatchServiceEvents watches changes of nodes of a service, nodes existing
+// when watching starts are sent as EventAdd. Unlike versions of endpoints
+// of WatchServices, which slow consumers skip, events are never skipped,
+// consumers should keep up with them. The returned channel is closed once
+// ctx is done or watching stops.
+func WatchServiceEvents(ctx context.Context, reg Registry, name string, scheme string) (chan NodeEvent, error) {
+	if watcher, ok := reg.(EventWatcher); ok {
+		return watcher.WatchServiceEvents(ctx, name, scheme)
+	}
+	watch, err := reg.WatchServices(ctx, name, scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	var events = make(chan NodeEvent, 10)
+	xgo.Go(func() {
+		defer close(events)
+		var prev *Nodes
+		for {
+			select {
+			case endpoints, ok := <-watch:
+				if !ok {
+					return
+				}
+				var canceled bool
+				endpoints.Nodes.Diff(prev, func(event NodeEvent) bool {
+					select {
+					case events <- event:
+						return true
+					case <-ctx.Done():
+						canceled = true
+						return false
+					}
+				})
+				if canceled {
+					return
+				}
+				prev = endpoints.Nodes
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return events, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func recvEvent(t *testing.T, ch chan NodeEvent) NodeEvent {
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+		return NodeEvent{}
+	}
+}
+
+func TestWatchServiceEvents(t *testing.T) {
+	reg := newFakeRegistry("127.0.0.1:80")
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := WatchServiceEvents(ctx, reg, "svc", "grpc")
+	assert.Nil(t, err)
+
+	node := func(addr string) server.ServiceInfo {
+		return server.ServiceInfo{Name: "svc", Scheme: "grpc", Address: addr}
+	}
+	assert.Equal(t, NodeEvent{Event: EventAdd, Address: "127.0.0.1:80", Node: node("127.0.0.1:80")}, recvEvent(t, events))
+
+	reg.push("127.0.0.1:81")
+	var got = make(map[string]Event)
+	for i := 0; i < 2; i++ {
+		event := recvEvent(t, events)
+		got[event.Address] = event.Event
+	}
+	assert.Equal(t, map[string]Event{"127.0.0.1:80": EventDelete, "127.0.0.1:81": EventAdd}, got)
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("events not closed")
+	}
+}
@@ -0,0 +1,431 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fake provides an in-memory registry for tests of resolvers,
+// balancers and others depending on registries, without a real etcd.
+//
+// Latency and failures can be injected per operation, changes are recorded
+// as events which can be replayed to another registry, and watches can be
+// broken to test recovery of watchers.
+package fake
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+)
+
+// Op is an operation of the registry
+type Op string
+
+// Operations of the registry, OpNotify is the delivery of an update to
+// each watcher
+const (
+	OpRegister   Op = "register"
+	OpUnregister Op = "unregister"
+	OpList       Op = "list"
+	OpWatch      Op = "watch"
+	OpNotify     Op = "notify"
+)
+
+// ErrClosed is returned for watches of closed registries
+var ErrClosed = errors.New("registry closed")
+
+type fault struct {
+	err error
+	// times the fault is left, forever if negative
+	times int
+}
+
+type serviceKey struct {
+	name   string
+	scheme string
+}
+
+// Registry is an in-memory registry, the zero value isn't usable, see New
+type Registry struct {
+	clock xtime.Clock
+
+	mu       sync.Mutex
+	services map[serviceKey]map[string]server.ServiceInfo
+	watchers map[*watcher]struct{}
+	latency  map[Op]time.Duration
+	faults   map[Op]*fault
+	calls    map[Op]int
+	events   []registry.NodeEvent
+	closed   bool
+}
+
+var (
+	_ registry.Registry        = &Registry{}
+	_ registry.BatchRegisterer = &Registry{}
+)
+
+// New returns an empty registry
+func New() *Registry {
+	return &Registry{
+		clock:    xtime.SystemClock,
+		services: make(map[serviceKey]map[string]server.ServiceInfo),
+		watchers: make(map[*watcher]struct{}),
+		latency:  make(map[Op]time.Duration),
+		faults:   make(map[Op]*fault),
+		calls:    make(map[Op]int),
+	}
+}
+
+// WithClock sets the clock of latency, e.g. a xtime.MockClock
+func (reg *Registry) WithClock(clock xtime.Clock) *Registry {
+	reg.clock = clock
+	return reg
+}
+
+// SetLatency delays each call of op for d, or each update delivered to
+// watchers for OpNotify
+func (reg *Registry) SetLatency(op Op, d time.Duration) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.latency[op] = d
+}
+
+// FailWith fails all calls of op with err until it's called with nil
+func (reg *Registry) FailWith(op Op, err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if err == nil {
+		delete(reg.faults, op)
+		return
+	}
+	reg.faults[op] = &fault{err: err, times: -1}
+}
+
+// FailNext fails the next n calls of op with err
+func (reg *Registry) FailNext(op Op, n int, err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.faults[op] = &fault{err: err, times: n}
+}
+
+// Calls returns the number of calls of op, failed ones included
+func (reg *Registry) Calls(op Op) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.calls[op]
+}
+
+// call counts the call of op, waits for its latency and returns its fault
+func (reg *Registry) call(ctx context.Context, op Op) error {
+	reg.mu.Lock()
+	reg.calls[op]++
+	latency := reg.latency[op]
+	var err error
+	if f, ok := reg.faults[op]; ok {
+		err = f.err
+		if f.times > 0 {
+			if f.times--; f.times == 0 {
+				delete(reg.faults, op)
+			}
+		}
+	}
+	reg.mu.Unlock()
+	if waitErr := reg.wait(ctx, latency); waitErr != nil {
+		return waitErr
+	}
+	return err
+}
+
+func (reg *Registry) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-reg.clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RegisterService ...
+func (reg *Registry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	if err := reg.call(ctx, OpRegister); err != nil {
+		return err
+	}
+	reg.Put(*info)
+	return nil
+}
+
+// RegisterServices registers infos atomically
+func (reg *Registry) RegisterServices(ctx context.Context, infos []*server.ServiceInfo) error {
+	if err := reg.call(ctx, OpRegister); err != nil {
+		return err
+	}
+	var services = make([]server.ServiceInfo, 0, len(infos))
+	for _, info := range infos {
+		services = append(services, *info)
+	}
+	reg.Put(services...)
+	return nil
+}
+
+// UnregisterService ...
+func (reg *Registry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	if err := reg.call(ctx, OpUnregister); err != nil {
+		return err
+	}
+	reg.Delete(*info)
+	return nil
+}
+
+// ListServices lists services of name and scheme
+func (reg *Registry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	if err := reg.call(ctx, OpList); err != nil {
+		return nil, err
+	}
+	reg.mu.Lock()
+	var services = make([]*server.ServiceInfo, 0, len(reg.services[serviceKey{name, scheme}]))
+	for _, info := range reg.services[serviceKey{name, scheme}] {
+		info := info
+		services = append(services, &info)
+	}
+	reg.mu.Unlock()
+	return registry.FilterServices(ctx, services)
+}
+
+// WatchServices watches services of name and scheme, updates are never
+// dropped and delivered in order to each watcher
+func (reg *Registry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	if _, err := registry.LabelSelectorFromContext(ctx); err != nil {
+		return nil, err
+	}
+	if err := reg.call(ctx, OpWatch); err != nil {
+		return nil, err
+	}
+
+	w := &watcher{
+		reg:     reg,
+		key:     serviceKey{name, scheme},
+		out:     make(chan registry.Endpoints, 10),
+		notify:  make(chan struct{}, 1),
+		dropped: make(chan struct{}),
+		al: registry.Endpoints{
+			RouteConfigs:    make(map[string]registry.RouteConfig),
+			ConsumerConfigs: make(map[string]registry.ConsumerConfig),
+			ProviderConfigs: make(map[string]registry.ProviderConfig),
+		},
+	}
+	reg.mu.Lock()
+	if reg.closed {
+		reg.mu.Unlock()
+		return nil, ErrClosed
+	}
+	w.al = w.al.Update(func(tx *registry.EndpointsTx) {
+		for addr, info := range reg.services[w.key] {
+			tx.SetNode(addr, info)
+		}
+	})
+	w.enqueue(w.al)
+	reg.watchers[w] = struct{}{}
+	reg.mu.Unlock()
+
+	go w.run(ctx)
+	return registry.FilterEndpoints(ctx, w.out)
+}
+
+// Put adds or updates services as if they were changed by others, bypassing
+// latency and failures
+func (reg *Registry) Put(infos ...server.ServiceInfo) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, info := range infos {
+		key := serviceKey{info.Name, info.Scheme}
+		if reg.services[key] == nil {
+			reg.services[key] = make(map[string]server.ServiceInfo)
+		}
+		event := registry.EventAdd
+		if _, ok := reg.services[key][info.Label()]; ok {
+			event = registry.EventUpdate
+		}
+		reg.services[key][info.Label()] = info
+		reg.apply(registry.NodeEvent{Event: event, Address: info.Label(), Node: info})
+	}
+}
+
+// Delete removes services as if they were removed by others, bypassing
+// latency and failures
+func (reg *Registry) Delete(infos ...server.ServiceInfo) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, info := range infos {
+		key := serviceKey{info.Name, info.Scheme}
+		prev, ok := reg.services[key][info.Label()]
+		if !ok {
+			continue
+		}
+		delete(reg.services[key], info.Label())
+		reg.apply(registry.NodeEvent{Event: registry.EventDelete, Address: info.Label(), Node: prev})
+	}
+}
+
+// apply records event and notifies watchers of its service, mu must be held
+func (reg *Registry) apply(event registry.NodeEvent) {
+	reg.events = append(reg.events, event)
+	key := serviceKey{event.Node.Name, event.Node.Scheme}
+	for w := range reg.watchers {
+		if w.key != key {
+			continue
+		}
+		w.al = w.al.Update(func(tx *registry.EndpointsTx) {
+			if event.Event == registry.EventDelete {
+				tx.DeleteNode(event.Address)
+			} else {
+				tx.SetNode(event.Address, event.Node)
+			}
+		})
+		w.enqueue(w.al)
+	}
+}
+
+// Events returns changes of services in order
+func (reg *Registry) Events() []registry.NodeEvent {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return append([]registry.NodeEvent(nil), reg.events...)
+}
+
+// Replay applies events in order, e.g. recorded by Events of another
+// registry, waiting for interval between them. It stops once ctx is done.
+func (reg *Registry) Replay(ctx context.Context, events []registry.NodeEvent, interval time.Duration) error {
+	for idx, event := range events {
+		if idx > 0 {
+			if err := reg.wait(ctx, interval); err != nil {
+				return err
+			}
+		}
+		if event.Event == registry.EventDelete {
+			reg.Delete(event.Node)
+		} else {
+			reg.Put(event.Node)
+		}
+	}
+	return nil
+}
+
+// DropWatches closes channels of all watches, as if they were broken, e.g.
+// by a restart of the registry
+func (reg *Registry) DropWatches() {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for w := range reg.watchers {
+		w.drop()
+	}
+}
+
+// Watches returns the number of running watches
+func (reg *Registry) Watches() int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return len(reg.watchers)
+}
+
+// Close stops all watches
+func (reg *Registry) Close() error {
+	reg.DropWatches()
+	reg.mu.Lock()
+	reg.closed = true
+	reg.mu.Unlock()
+	return nil
+}
+
+// watcher delivers snapshots of a service in order, without blocking the
+// registry
+type watcher struct {
+	reg *Registry
+	key serviceKey
+	// al is the latest snapshot, guarded by mu of the registry
+	al registry.Endpoints
+
+	mu       sync.Mutex
+	queue    []registry.Endpoints
+	notify   chan struct{}
+	dropped  chan struct{}
+	dropOnce sync.Once
+	out      chan registry.Endpoints
+}
+
+func (w *watcher) enqueue(endpoints registry.Endpoints) {
+	w.mu.Lock()
+	w.queue = append(w.queue, endpoints)
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) drop() {
+	w.dropOnce.Do(func() { close(w.dropped) })
+}
+
+func (w *watcher) run(ctx context.Context) {
+	defer func() {
+		w.reg.mu.Lock()
+		delete(w.reg.watchers, w)
+		w.reg.mu.Unlock()
+		close(w.out)
+	}()
+	for {
+		w.mu.Lock()
+		var next *registry.Endpoints
+		if len(w.queue) > 0 {
+			next = &w.queue[0]
+			w.queue = w.queue[1:]
+		}
+		w.mu.Unlock()
+		if next == nil {
+			select {
+			case <-w.notify:
+				continue
+			case <-w.dropped:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		w.reg.mu.Lock()
+		latency := w.reg.latency[OpNotify]
+		w.reg.mu.Unlock()
+		if latency > 0 {
+			select {
+			case <-w.reg.clock.After(latency):
+			case <-w.dropped:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case w.out <- *next:
+		case <-w.dropped:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func newInfo(addr string) *server.ServiceInfo {
+	return &server.ServiceInfo{Name: "svc", Scheme: "grpc", Address: addr, Labels: map[string]string{"zone": "z1"}}
+}
+
+func recv(t *testing.T, ch chan registry.Endpoints) registry.Endpoints {
+	select {
+	case endpoints := <-ch:
+		return endpoints
+	case <-time.After(time.Second):
+		t.Fatal("no endpoints")
+		return registry.Endpoints{}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	reg := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.Nil(t, reg.RegisterService(ctx, newInfo("10.0.0.1:9091")))
+	ch, err := reg.WatchServices(ctx, "svc", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, recv(t, ch).Nodes.Len())
+
+	// updates are delivered in order without being dropped
+	for _, addr := range []string{"10.0.0.2:9091", "10.0.0.3:9091"} {
+		assert.Nil(t, reg.RegisterService(ctx, newInfo(addr)))
+	}
+	assert.Nil(t, reg.UnregisterService(ctx, newInfo("10.0.0.1:9091")))
+	assert.Equal(t, 2, recv(t, ch).Nodes.Len())
+	assert.Equal(t, 3, recv(t, ch).Nodes.Len())
+	endpoints := recv(t, ch)
+	assert.Equal(t, 2, endpoints.Nodes.Len())
+	_, ok := endpoints.Nodes.Get("grpc://10.0.0.1:9091")
+	assert.False(t, ok)
+
+	other := newInfo("10.0.0.4:9091")
+	other.Labels = map[string]string{"zone": "z2"}
+	reg.Put(*other)
+	services, err := reg.ListServices(registry.WithLabelSelector(ctx, "zone=z2"), "svc", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, []*server.ServiceInfo{other}, services)
+	assert.Equal(t, 1, reg.Calls(OpList))
+
+	events := reg.Events()
+	assert.Len(t, events, 5)
+	assert.Equal(t, registry.EventDelete, events[3].Event)
+
+	// replayed to another registry
+	replayed := New()
+	assert.Nil(t, replayed.Replay(ctx, events, 0))
+	services, err = replayed.ListServices(ctx, "svc", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 3)
+	assert.Equal(t, events, replayed.Events())
+}
+
+func TestRegistry_Faults(t *testing.T) {
+	reg := New()
+	ctx := context.Background()
+	unavailable := errors.New("unavailable")
+
+	reg.FailNext(OpRegister, 2, unavailable)
+	assert.Equal(t, unavailable, reg.RegisterService(ctx, newInfo("10.0.0.1:9091")))
+	assert.Equal(t, unavailable, reg.RegisterServices(ctx, []*server.ServiceInfo{newInfo("10.0.0.1:9091")}))
+	assert.Nil(t, reg.RegisterService(ctx, newInfo("10.0.0.1:9091")))
+	assert.Equal(t, 3, reg.Calls(OpRegister))
+
+	reg.FailWith(OpWatch, unavailable)
+	for i := 0; i < 3; i++ {
+		_, err := reg.WatchServices(ctx, "svc", "grpc")
+		assert.Equal(t, unavailable, err)
+	}
+	reg.FailWith(OpWatch, nil)
+	ch, err := reg.WatchServices(ctx, "svc", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, recv(t, ch).Nodes.Len())
+
+	// broken watches are closed
+	reg.DropWatches()
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Eventually(t, func() bool { return reg.Watches() == 0 }, time.Second, time.Millisecond)
+
+	assert.Nil(t, reg.Close())
+	_, err = reg.WatchServices(ctx, "svc", "grpc")
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestRegistry_Latency(t *testing.T) {
+	clock := xtime.NewMockClock(time.Now())
+	reg := New().WithClock(clock)
+	ctx := context.Background()
+
+	reg.SetLatency(OpList, time.Second)
+	listed := make(chan error)
+	go func() {
+		_, err := reg.ListServices(ctx, "svc", "grpc")
+		listed <- err
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	assert.Nil(t, <-listed)
+
+	// updates reach watchers late
+	reg.SetLatency(OpNotify, time.Second)
+	ch, err := reg.WatchServices(ctx, "svc", "grpc")
+	assert.Nil(t, err)
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	assert.Equal(t, 0, recv(t, ch).Nodes.Len())
+
+	reg.Put(*newInfo("10.0.0.1:9091"))
+	clock.BlockUntil(1)
+	select {
+	case <-ch:
+		t.Fatal("delivered before latency")
+	default:
+	}
+	clock.Advance(time.Second)
+	assert.Equal(t, 1, recv(t, ch).Nodes.Len())
+
+	// calls waiting for latency stop with their contexts
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	_, err = reg.ListServices(timeoutCtx, "svc", "grpc")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Nil(t, reg.Close())
+}
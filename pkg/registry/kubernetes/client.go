@@ -0,0 +1,211 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// errGone is returned by watches if the resource version is too old, the
+// resource must be listed again
+var errGone = errors.New("resource version too old")
+
+// client is a minimal client of the kubernetes api reading endpoints of services
+type client struct {
+	config *Config
+	http   *http.Client
+}
+
+type objectMeta struct {
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// object is an Endpoints or EndpointSlice, their fields don't collide
+type object struct {
+	Metadata objectMeta `json:"metadata"`
+
+	// fields of Endpoints
+	Subsets []endpointSubset `json:"subsets"`
+
+	// fields of EndpointSlice
+	Endpoints []endpoint     `json:"endpoints"`
+	Ports     []endpointPort `json:"ports"`
+}
+
+type endpointSubset struct {
+	Addresses []endpointAddress `json:"addresses"`
+	Ports     []endpointPort    `json:"ports"`
+}
+
+type endpointAddress struct {
+	IP        string     `json:"ip"`
+	NodeName  *string    `json:"nodeName"`
+	TargetRef *targetRef `json:"targetRef"`
+}
+
+type endpoint struct {
+	Addresses  []string `json:"addresses"`
+	Conditions struct {
+		// nil means ready
+		Ready *bool `json:"ready"`
+	} `json:"conditions"`
+	NodeName  *string    `json:"nodeName"`
+	Zone      *string    `json:"zone"`
+	TargetRef *targetRef `json:"targetRef"`
+	// Topology of discovery.k8s.io/v1beta1
+	Topology map[string]string `json:"topology"`
+}
+
+type endpointPort struct {
+	Name *string `json:"name"`
+	Port *int    `json:"port"`
+}
+
+type targetRef struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+type objectList struct {
+	Metadata objectMeta `json:"metadata"`
+	Items    []object   `json:"items"`
+}
+
+// status is the object of ERROR watch events
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type watchEvent struct {
+	// ADDED, MODIFIED, DELETED, BOOKMARK or ERROR
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// path returns the path of objects of config.Resource
+func (c *client) path() string {
+	if c.config.Resource == ResourceEndpoints {
+		return "/api/v1/namespaces/" + url.PathEscape(c.config.Namespace) + "/endpoints"
+	}
+	return "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(c.config.Namespace) + "/endpointslices"
+}
+
+// selector selects objects of service, an Endpoints is named after its
+// service while EndpointSlices are labeled with it
+func (c *client) selector(service string) url.Values {
+	if c.config.Resource == ResourceEndpoints {
+		return url.Values{"fieldSelector": {"metadata.name=" + service}}
+	}
+	return url.Values{"labelSelector": {"kubernetes.io/service-name=" + service}}
+}
+
+// list lists objects of service
+func (c *client) list(ctx context.Context, service string) (*objectList, error) {
+	var list objectList
+	err := c.do(ctx, c.selector(service), func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&list)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// watch watches objects of service changed since resourceVersion, fn is
+// called with each event until the watch is closed by the api server or
+// fn returns an error
+func (c *client) watch(ctx context.Context, service string, resourceVersion string, fn func(typ string, obj object) error) error {
+	query := c.selector(service)
+	query.Set("watch", "true")
+	query.Set("resourceVersion", resourceVersion)
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("timeoutSeconds", strconv.Itoa(int(c.config.WatchTimeout.Seconds())))
+	return c.do(ctx, query, func(resp *http.Response) error {
+		decoder := json.NewDecoder(resp.Body)
+		for {
+			var event watchEvent
+			if err := decoder.Decode(&event); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				// the api server closes watches after timeoutSeconds
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+			if event.Type == "ERROR" {
+				var s status
+				_ = json.Unmarshal(event.Object, &s)
+				if s.Code == http.StatusGone {
+					return errGone
+				}
+				return fmt.Errorf("kubernetes watch %s: %d %s", c.path(), s.Code, s.Message)
+			}
+			var obj object
+			if err := json.Unmarshal(event.Object, &obj); err != nil {
+				return err
+			}
+			if err := fn(event.Type, obj); err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func (c *client) do(ctx context.Context, query url.Values, out func(*http.Response) error) error {
+	u := strings.TrimSuffix(c.config.Host, "/") + c.path() + "?" + query.Encode()
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	token := c.config.Token
+	if token == "" && c.config.TokenFile != "" {
+		// tokens of service accounts are rotated, so read it every time
+		if data, err := ioutil.ReadFile(c.config.TokenFile); err == nil {
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return errGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("kubernetes GET %s: %d %s", c.path(), resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return out(resp)
+}
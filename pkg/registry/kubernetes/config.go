@@ -0,0 +1,184 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/registry/naming"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("registry.kubernetes", "jupiter.registry.*", "kubernetes registry", DefaultConfig)
+}
+
+const (
+	// ResourceEndpointSlices resolves services by discovery.k8s.io/v1 EndpointSlices
+	ResourceEndpointSlices = "endpointslices"
+	// ResourceEndpoints resolves services by core/v1 Endpoints, for clusters
+	// without EndpointSlices
+	ResourceEndpoints = "endpoints"
+)
+
+// files of the service account mounted into pods
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	tokenFile         = serviceAccountDir + "token"
+	caFile            = serviceAccountDir + "ca.crt"
+	namespaceFile     = serviceAccountDir + "namespace"
+)
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.registry." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Panic("unmarshal key", xlog.FieldMod("registry.kubernetes"), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig returns the config of the in-cluster service account
+func DefaultConfig() *Config {
+	return &Config{
+		TokenFile:    tokenFile,
+		CAFile:       caFile,
+		Resource:     ResourceEndpointSlices,
+		Timeout:      time.Second * 3,
+		WatchTimeout: time.Minute * 5,
+		Backoff:      xbackoff.DefaultConfig(),
+		logger:       xlog.JupiterLogger.With(xlog.FieldMod("registry.kubernetes")),
+		clock:        xtime.SystemClock,
+	}
+}
+
+// Config ...
+type Config struct {
+	// Host of the api server, e.g. "https://10.0.0.1:443", the in-cluster
+	// address from KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT by default
+	Host string
+	// Token authenticates requests, read from TokenFile before each request
+	// if empty since tokens of service accounts are rotated
+	Token     string
+	TokenFile string
+	// CAFile verifies the api server, the system roots are used if it doesn't exist
+	CAFile string
+	// Namespace of services, the namespace of the pod by default
+	Namespace string
+	// Resource resolving services, "endpointslices" or "endpoints"
+	Resource string
+	// Ports maps schemes to names of service ports, a scheme is the port
+	// name by default, e.g. the "grpc" port of a service serves grpc
+	Ports map[string]string
+	// Timeout of requests other than watches
+	Timeout time.Duration
+	// WatchTimeout closes watches periodically, they're resumed from the
+	// latest resource version
+	WatchTimeout time.Duration
+	// Backoff of watch errors
+	Backoff xbackoff.Config
+	// NameSuffix scopes service names by environment, e.g. "gray" subscribes
+	// "app.gray" for "app"
+	NameSuffix string
+	// Aliases maps a service name to its alias names, clients subscribe to
+	// the whole group
+	Aliases map[string][]string
+
+	logger *xlog.Logger
+	clock  xtime.Clock
+}
+
+// Build ...
+func (config Config) Build() registry.Registry {
+	if config.Resource != ResourceEndpointSlices && config.Resource != ResourceEndpoints {
+		config.logger.Panic("unknown kubernetes resource", xlog.String("resource", config.Resource))
+	}
+	if config.Host == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			config.logger.Panic("kubernetes api server unknown, not running in cluster")
+		}
+		config.Host = "https://" + net.JoinHostPort(host, port)
+	}
+	if config.Namespace == "" {
+		config.Namespace = "default"
+		if ns, err := ioutil.ReadFile(namespaceFile); err == nil {
+			config.Namespace = strings.TrimSpace(string(ns))
+		}
+	}
+	transport, err := config.transport()
+	if err != nil {
+		config.logger.Panic("kubernetes ca", xlog.FieldErr(err), xlog.String("file", config.CAFile))
+	}
+	var reg registry.Registry = newKubernetesRegistry(&config, &http.Client{Transport: transport})
+	if strategy := config.naming(); strategy != nil {
+		reg = naming.New(reg, strategy)
+	}
+	return reg
+}
+
+// transport trusts CAFile if it exists
+func (config *Config) transport() (http.RoundTripper, error) {
+	if config.CAFile == "" {
+		return http.DefaultTransport, nil
+	}
+	pem, err := ioutil.ReadFile(config.CAFile)
+	if os.IsNotExist(err) {
+		return http.DefaultTransport, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate in %s", config.CAFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return transport, nil
+}
+
+func (config *Config) naming() naming.Strategy {
+	var strategies []naming.Strategy
+	if len(config.Aliases) > 0 {
+		strategies = append(strategies, naming.Alias(config.Aliases))
+	}
+	if config.NameSuffix != "" {
+		strategies = append(strategies, naming.EnvSuffix(config.NameSuffix))
+	}
+	if len(strategies) == 0 {
+		return nil
+	}
+	return naming.Chain(strategies...)
+}
@@ -0,0 +1,298 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+type kubernetesRegistry struct {
+	*Config
+	client *client
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var _ registry.Registry = &kubernetesRegistry{}
+
+func newKubernetesRegistry(config *Config, httpClient *http.Client) *kubernetesRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &kubernetesRegistry{
+		Config: config,
+		client: &client{config: config, http: httpClient},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// RegisterService is a no-op, endpoints of pods are maintained by kubernetes
+// according to readiness probes
+func (reg *kubernetesRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	reg.logger.Info("register service, maintained by kubernetes", xlog.FieldName(info.Name), xlog.FieldAddr(info.Address))
+	return nil
+}
+
+// UnregisterService is a no-op, pods leave endpoints once they're terminating
+func (reg *kubernetesRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	return nil
+}
+
+// ListServices lists ready endpoints of service name serving scheme
+func (reg *kubernetesRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, reg.Timeout)
+	defer cancel()
+	list, err := reg.client.list(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var services = make([]*server.ServiceInfo, 0)
+	for _, obj := range list.Items {
+		for _, info := range reg.toServiceInfos(obj, name, scheme) {
+			info := info
+			services = append(services, &info)
+		}
+	}
+	return registry.FilterServices(ctx, services)
+}
+
+// WatchServices watches ready endpoints of service name serving scheme.
+// Only nodes are watched, route and provider configs of etcd configurators
+// are not supported by kubernetes.
+func (reg *kubernetesRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	store, err := reg.WatchSnapshots(ctx, name, scheme)
+	if err != nil {
+		return nil, err
+	}
+	return registry.StreamEndpoints(ctx, store), nil
+}
+
+// WatchSnapshots watches ready endpoints of service name serving scheme
+// into a store, which is closed once ctx is done or the registry is closed
+func (reg *kubernetesRegistry) WatchSnapshots(ctx context.Context, name string, scheme string) (*registry.EndpointsStore, error) {
+	if _, err := registry.LabelSelectorFromContext(ctx); err != nil {
+		return nil, err
+	}
+	listCtx, cancel := context.WithTimeout(ctx, reg.Timeout)
+	list, err := reg.client.list(listCtx, name)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	var store = registry.NewEndpointsStore()
+	// objects of the service keyed by name, a service may have several EndpointSlices
+	var objects = make(map[string]object, len(list.Items))
+	for _, obj := range list.Items {
+		objects[obj.Metadata.Name] = obj
+	}
+	var resourceVersion = list.Metadata.ResourceVersion
+	var notify = func() {
+		// 基于上一版本生成新快照, 未变更的部分共享
+		store.Update(func(tx *registry.EndpointsTx) {
+			reg.updateNodes(tx, tx.PrevNodes(), objects, name, scheme)
+		})
+	}
+	notify()
+
+	xgo.Go(func() {
+		defer store.Close()
+		ctx, cancel := reg.watchContext(ctx)
+		defer cancel()
+		var retries int
+		for ctx.Err() == nil {
+			err := reg.client.watch(ctx, name, resourceVersion, func(typ string, obj object) error {
+				resourceVersion = obj.Metadata.ResourceVersion
+				switch typ {
+				case "ADDED", "MODIFIED":
+					objects[obj.Metadata.Name] = obj
+				case "DELETED":
+					delete(objects, obj.Metadata.Name)
+				default:
+					// BOOKMARK only advances the resource version
+					return nil
+				}
+				retries = 0
+				notify()
+				return nil
+			})
+			if ctx.Err() != nil {
+				return
+			}
+			if err == errGone {
+				// events were compacted, start over from a fresh list
+				listCtx, cancel := context.WithTimeout(ctx, reg.Timeout)
+				var list *objectList
+				list, err = reg.client.list(listCtx, name)
+				cancel()
+				if err == nil {
+					objects = make(map[string]object, len(list.Items))
+					for _, obj := range list.Items {
+						objects[obj.Metadata.Name] = obj
+					}
+					resourceVersion = list.Metadata.ResourceVersion
+					retries = 0
+					notify()
+					continue
+				}
+			}
+			if err == nil {
+				// closed after WatchTimeout, resume from the latest version
+				continue
+			}
+			reg.logger.Error("watch services", xlog.FieldErr(err), xlog.String("name", name), xlog.String("scheme", scheme))
+			select {
+			case <-reg.clock.After(reg.Backoff.Backoff(retries)):
+			case <-ctx.Done():
+			}
+			retries++
+		}
+	})
+	return registry.FilterStore(ctx, store)
+}
+
+// watchContext is done once ctx is done or the registry is closed
+func (reg *kubernetesRegistry) watchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	xgo.Go(func() {
+		select {
+		case <-reg.ctx.Done():
+		case <-watchCtx.Done():
+		}
+		cancel()
+	})
+	return watchCtx, cancel
+}
+
+// Close stops watches
+func (reg *kubernetesRegistry) Close() error {
+	reg.cancel()
+	return nil
+}
+
+// updateNodes replaces nodes of tx with endpoints of objects
+func (reg *kubernetesRegistry) updateNodes(tx *registry.EndpointsTx, prev *registry.Nodes, objects map[string]object, name, scheme string) {
+	var seen = make(map[string]struct{})
+	for _, obj := range objects {
+		for _, info := range reg.toServiceInfos(obj, name, scheme) {
+			tx.SetNode(info.Label(), info)
+			seen[info.Label()] = struct{}{}
+		}
+	}
+	if prev == nil {
+		return
+	}
+	prev.Range(func(addr string, _ server.ServiceInfo) bool {
+		if _, ok := seen[addr]; !ok {
+			tx.DeleteNode(addr)
+		}
+		return true
+	})
+}
+
+// toServiceInfos maps ready endpoints of obj serving scheme to services
+func (reg *kubernetesRegistry) toServiceInfos(obj object, name, scheme string) []server.ServiceInfo {
+	var infos []server.ServiceInfo
+	var newInfo = func(ip string, port int) server.ServiceInfo {
+		return server.ServiceInfo{
+			Name:     name,
+			Scheme:   scheme,
+			Address:  net.JoinHostPort(ip, strconv.Itoa(port)),
+			Weight:   100,
+			Enable:   true,
+			Healthy:  true,
+			Kind:     constant.ServiceProvider,
+			Metadata: make(map[string]string),
+		}
+	}
+	// Endpoints
+	for _, subset := range obj.Subsets {
+		port, ok := reg.port(subset.Ports, scheme)
+		if !ok {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			info := newInfo(addr.IP, port)
+			setRefs(&info, addr.NodeName, addr.TargetRef)
+			infos = append(infos, info)
+		}
+	}
+	// EndpointSlice
+	port, ok := reg.port(obj.Ports, scheme)
+	if !ok {
+		return infos
+	}
+	for _, ep := range obj.Endpoints {
+		if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+			continue
+		}
+		var nodeName = ep.NodeName
+		if nodeName == nil {
+			if hostname, ok := ep.Topology["kubernetes.io/hostname"]; ok {
+				nodeName = &hostname
+			}
+		}
+		for _, addr := range ep.Addresses {
+			info := newInfo(addr, port)
+			setRefs(&info, nodeName, ep.TargetRef)
+			if ep.Zone != nil {
+				info.Zone = *ep.Zone
+			} else {
+				info.Zone = ep.Topology["topology.kubernetes.io/zone"]
+			}
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// port returns the port serving scheme, which is the port named by Ports
+// or scheme, or the only port of a service
+func (reg *kubernetesRegistry) port(ports []endpointPort, scheme string) (int, bool) {
+	var portName = scheme
+	if name, ok := reg.Ports[scheme]; ok {
+		portName = name
+	}
+	for _, port := range ports {
+		if port.Port == nil {
+			continue
+		}
+		if port.Name != nil && *port.Name == portName {
+			return *port.Port, true
+		}
+		if len(ports) == 1 && (port.Name == nil || *port.Name == "") {
+			return *port.Port, true
+		}
+	}
+	return 0, false
+}
+
+// setRefs keeps the node and pod of endpoints in metadata
+func setRefs(info *server.ServiceInfo, nodeName *string, ref *targetRef) {
+	if nodeName != nil {
+		info.Metadata["node"] = *nodeName
+	}
+	if ref != nil && ref.Kind == "Pod" {
+		info.Metadata["pod"] = ref.Name
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEvent struct {
+	version int
+	typ     string
+	obj     map[string]interface{}
+}
+
+// fakeAPIServer serves list and watch of endpoints used by the registry
+type fakeAPIServer struct {
+	*httptest.Server
+	t         *testing.T
+	mu        sync.Mutex
+	changed   chan struct{}
+	version   int
+	compacted int
+	objects   map[string]map[string]interface{}
+	events    []fakeEvent
+	queries   []string
+}
+
+func newFakeAPIServer(t *testing.T, path string) *fakeAPIServer {
+	s := &fakeAPIServer{t: t, changed: make(chan struct{}), version: 1, objects: make(map[string]map[string]interface{})}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, path, r.URL.Path)
+		assert.Equal(t, "Bearer token-1", r.Header.Get("Authorization"))
+		s.mu.Lock()
+		s.queries = append(s.queries, r.URL.RawQuery)
+		s.mu.Unlock()
+		if r.URL.Query().Get("watch") == "true" {
+			s.watch(w, r)
+			return
+		}
+		s.mu.Lock()
+		var items = make([]map[string]interface{}, 0, len(s.objects))
+		for _, obj := range s.objects {
+			items = append(items, obj)
+		}
+		version := s.version
+		s.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": strconv.Itoa(version)},
+			"items":    items,
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakeAPIServer) watch(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.Atoi(r.URL.Query().Get("resourceVersion"))
+	encoder := json.NewEncoder(w)
+	for {
+		s.mu.Lock()
+		if since < s.compacted {
+			s.mu.Unlock()
+			_ = encoder.Encode(map[string]interface{}{"type": "ERROR", "object": map[string]interface{}{"code": 410, "message": "too old resource version"}})
+			return
+		}
+		var events []fakeEvent
+		for _, event := range s.events {
+			if event.version > since {
+				events = append(events, event)
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		for _, event := range events {
+			_ = encoder.Encode(map[string]interface{}{"type": event.typ, "object": event.obj})
+			since = event.version
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// put adds or modifies obj, its version is set
+func (s *fakeAPIServer) put(obj map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := obj["metadata"].(map[string]interface{})["name"].(string)
+	typ := "MODIFIED"
+	if _, ok := s.objects[name]; !ok {
+		typ = "ADDED"
+	}
+	s.commit(typ, name, obj)
+	s.objects[name] = obj
+}
+
+func (s *fakeAPIServer) delete(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commit("DELETED", name, s.objects[name])
+	delete(s.objects, name)
+}
+
+// compact drops events after putting objs silently, watches from older
+// versions get 410
+func (s *fakeAPIServer) compact(objs ...map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commit("", "", map[string]interface{}{})
+	for _, obj := range objs {
+		s.objects[obj["metadata"].(map[string]interface{})["name"].(string)] = obj
+	}
+	s.compacted = s.version
+	s.events = nil
+}
+
+// commit is called with mu held
+func (s *fakeAPIServer) commit(typ, name string, obj map[string]interface{}) {
+	s.version++
+	obj["metadata"] = map[string]interface{}{"name": name, "resourceVersion": strconv.Itoa(s.version)}
+	s.events = append(s.events, fakeEvent{version: s.version, typ: typ, obj: obj})
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func slice(name string, ready []bool, ports ...interface{}) map[string]interface{} {
+	var endpoints = make([]interface{}, 0, len(ready))
+	for i, ok := range ready {
+		endpoints = append(endpoints, map[string]interface{}{
+			"addresses":  []string{"10.0." + name + "." + strconv.Itoa(i+1)},
+			"conditions": map[string]interface{}{"ready": ok},
+			"nodeName":   "node-" + strconv.Itoa(i+1),
+			"zone":       "zone-a",
+			"targetRef":  map[string]interface{}{"kind": "Pod", "name": "pod-" + name + "-" + strconv.Itoa(i+1)},
+		})
+	}
+	return map[string]interface{}{
+		"metadata":  map[string]interface{}{"name": "demo-" + name},
+		"endpoints": endpoints,
+		"ports":     ports,
+	}
+}
+
+func port(name string, port int) map[string]interface{} {
+	return map[string]interface{}{"name": name, "port": port, "protocol": "TCP"}
+}
+
+func newTestRegistry(t *testing.T, host, resource string, clock xtime.Clock) *kubernetesRegistry {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("token-1\n"), 0600))
+	config := DefaultConfig()
+	config.Host = host
+	config.TokenFile = tokenFile
+	config.Namespace = "prod"
+	config.Resource = resource
+	config.Ports = map[string]string{"http": "web"}
+	config.clock = clock
+	reg := newKubernetesRegistry(config, http.DefaultClient)
+	t.Cleanup(func() { _ = reg.Close() })
+	return reg
+}
+
+func addresses(services []*server.ServiceInfo) []string {
+	var ret = make([]string, 0, len(services))
+	for _, service := range services {
+		ret = append(ret, service.Address)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func nodes(endpoints registry.Endpoints) []string {
+	var ret = make([]string, 0)
+	endpoints.Nodes.Range(func(_ string, info server.ServiceInfo) bool {
+		ret = append(ret, info.Address)
+		return true
+	})
+	sort.Strings(ret)
+	return ret
+}
+
+func TestListServices(t *testing.T) {
+	apiServer := newFakeAPIServer(t, "/apis/discovery.k8s.io/v1/namespaces/prod/endpointslices")
+	apiServer.put(slice("1", []bool{true, false}, port("grpc", 9090), port("web", 8080)))
+	apiServer.put(slice("2", []bool{true}, port("grpc", 9090)))
+	reg := newTestRegistry(t, apiServer.URL, ResourceEndpointSlices, xtime.SystemClock)
+
+	services, err := reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.1.1:9090", "10.0.2.1:9090"}, addresses(services))
+	assert.Equal(t, "labelSelector=kubernetes.io%2Fservice-name%3Ddemo", apiServer.queries[0])
+
+	services, err = reg.ListServices(context.Background(), "demo", "http")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.1.1:8080"}, addresses(services))
+	assert.Equal(t, "demo", services[0].Name)
+	assert.Equal(t, "http", services[0].Scheme)
+	assert.Equal(t, "zone-a", services[0].Zone)
+	assert.True(t, services[0].Enable)
+	assert.Equal(t, map[string]string{"node": "node-1", "pod": "pod-1-1"}, services[0].Metadata)
+
+	// registration is maintained by kubernetes
+	assert.Nil(t, reg.RegisterService(context.Background(), services[0]))
+	assert.Nil(t, reg.UnregisterService(context.Background(), services[0]))
+}
+
+func TestListServicesOfEndpoints(t *testing.T) {
+	apiServer := newFakeAPIServer(t, "/api/v1/namespaces/prod/endpoints")
+	apiServer.put(map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "demo"},
+		"subsets": []interface{}{map[string]interface{}{
+			"addresses":         []interface{}{map[string]interface{}{"ip": "10.0.0.1", "nodeName": "node-1"}},
+			"notReadyAddresses": []interface{}{map[string]interface{}{"ip": "10.0.0.2"}},
+			"ports":             []interface{}{map[string]interface{}{"port": 9090}},
+		}},
+	})
+	reg := newTestRegistry(t, apiServer.URL, ResourceEndpoints, xtime.SystemClock)
+
+	// the only unnamed port serves any scheme
+	services, err := reg.ListServices(context.Background(), "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1:9090"}, addresses(services))
+	assert.Equal(t, map[string]string{"node": "node-1"}, services[0].Metadata)
+	assert.Equal(t, "fieldSelector=metadata.name%3Ddemo", apiServer.queries[0])
+}
+
+func TestWatchServices(t *testing.T) {
+	apiServer := newFakeAPIServer(t, "/apis/discovery.k8s.io/v1/namespaces/prod/endpointslices")
+	apiServer.put(slice("1", []bool{true, false}, port("grpc", 9090)))
+	clock := xtime.NewMockClock(time.Now())
+	reg := newTestRegistry(t, apiServer.URL, ResourceEndpointSlices, clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.WatchServices(ctx, "demo", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.1.1:9090"}, nodes(<-ch))
+
+	// an endpoint becomes ready
+	apiServer.put(slice("1", []bool{true, true}, port("grpc", 9090)))
+	assert.Equal(t, []string{"10.0.1.1:9090", "10.0.1.2:9090"}, nodes(<-ch))
+
+	// a new slice
+	apiServer.put(slice("2", []bool{true}, port("grpc", 9090)))
+	assert.Equal(t, []string{"10.0.1.1:9090", "10.0.1.2:9090", "10.0.2.1:9090"}, nodes(<-ch))
+
+	apiServer.delete("demo-1")
+	assert.Equal(t, []string{"10.0.2.1:9090"}, nodes(<-ch))
+
+	// watches of compacted versions list again
+	apiServer.compact(slice("3", []bool{true}, port("grpc", 9090)))
+	assert.Equal(t, []string{"10.0.2.1:9090", "10.0.3.1:9090"}, nodes(<-ch))
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+)
+
+var (
+	operationCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "registry_operation_total",
+		Labels:    []string{"registry", "op", "code"},
+	}.Build()
+	operationHistogram = metric.HistogramVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "registry_operation_seconds",
+		Labels:    []string{"registry", "op"},
+	}.Build()
+	leaseRenewCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "registry_lease_renew_total",
+		Labels:    []string{"registry", "code"},
+	}.Build()
+	watchGauge = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "registry_watches",
+		Labels:    []string{"registry"},
+	}.Build()
+)
+
+// ObserveOperation counts op of registry, e.g. "register" of "etcd", which
+// started at start and returned err, alerts on failed registrations are
+// based on registry_operation_total{code!="OK"}
+func ObserveOperation(registry, op string, start time.Time, err error) {
+	operationCounter.Inc(registry, op, metricCode(err))
+	operationHistogram.Observe(time.Since(start).Seconds(), registry, op)
+}
+
+// ObserveLeaseRenewal counts renewals of leases keeping registered services
+// alive, failed ones would drop services silently
+func ObserveLeaseRenewal(registry string, err error) {
+	leaseRenewCounter.Inc(registry, metricCode(err))
+}
+
+// ObserveWatch adds delta to the number of active watches of registry
+func ObserveWatch(registry string, delta int) {
+	watchGauge.Add(float64(delta), registry)
+}
+
+func metricCode(err error) string {
+	switch {
+	case err == nil:
+		return "OK"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "Timeout"
+	default:
+		return "Error"
+	}
+}
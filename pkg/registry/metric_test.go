@@ -0,0 +1,43 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveOperation(t *testing.T) {
+	ObserveOperation("test", "register", time.Now(), nil)
+	ObserveOperation("test", "register", time.Now(), errors.New("unavailable"))
+	ObserveOperation("test", "register", time.Now(), fmt.Errorf("put: %w", context.DeadlineExceeded))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(operationCounter.WithLabelValues("test", "register", "OK")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(operationCounter.WithLabelValues("test", "register", "Error")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(operationCounter.WithLabelValues("test", "register", "Timeout")))
+
+	ObserveLeaseRenewal("test", errors.New("lease lost"))
+	assert.Equal(t, float64(1), testutil.ToFloat64(leaseRenewCounter.WithLabelValues("test", "Error")))
+
+	ObserveWatch("test", 2)
+	ObserveWatch("test", -1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(watchGauge.WithLabelValues("test")))
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package naming decorates a registry with a naming strategy, so the same
+// binary registers under environment scoped names (app.gray, app.canary)
+// or legacy alias names, and clients subscribe to alias groups transparently.
+package naming
+
+import (
+	"sort"
+)
+
+// Strategy maps a service name to the names used in registry
+type Strategy interface {
+	// RegisterNames returns the names a service registers under
+	RegisterNames(name string) []string
+	// WatchNames returns the names a client subscribes to, the first
+	// name takes precedence when the same node shows up under several names
+	WatchNames(name string) []string
+}
+
+// EnvSuffix registers and subscribes name+"."+env, name is kept as is if
+// env is empty.
+func EnvSuffix(env string) Strategy {
+	return envSuffix(env)
+}
+
+type envSuffix string
+
+// RegisterNames ...
+func (env envSuffix) RegisterNames(name string) []string {
+	return env.WatchNames(name)
+}
+
+// WatchNames ...
+func (env envSuffix) WatchNames(name string) []string {
+	if env == "" {
+		return []string{name}
+	}
+	return []string{name + "." + string(env)}
+}
+
+// Alias registers name under its alias names as well, clients subscribing
+// to any name of an alias group receive nodes of the whole group.
+// aliases maps a name to its alias names, e.g. a renamed service maps
+// the new name to its legacy name during migration.
+func Alias(aliases map[string][]string) Strategy {
+	return alias(aliases)
+}
+
+type alias map[string][]string
+
+// RegisterNames ...
+func (a alias) RegisterNames(name string) []string {
+	return a.group(name)
+}
+
+// WatchNames ...
+func (a alias) WatchNames(name string) []string {
+	return a.group(name)
+}
+
+// group returns name followed by all names sharing an alias with it
+func (a alias) group(name string) []string {
+	var names = []string{name}
+	var seen = map[string]bool{name: true}
+	for i := 0; i < len(names); i++ {
+		var next []string
+		next = append(next, a[names[i]]...)
+		for primary, aliases := range a {
+			for _, alias := range aliases {
+				if alias == names[i] {
+					next = append(next, primary)
+				}
+			}
+		}
+		sort.Strings(next)
+		for _, n := range next {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
+// Chain applies strategies in order, each strategy maps the names
+// returned by the previous one.
+func Chain(strategies ...Strategy) Strategy {
+	return chain(strategies)
+}
+
+type chain []Strategy
+
+// RegisterNames ...
+func (c chain) RegisterNames(name string) []string {
+	return c.apply(name, Strategy.RegisterNames)
+}
+
+// WatchNames ...
+func (c chain) WatchNames(name string) []string {
+	return c.apply(name, Strategy.WatchNames)
+}
+
+func (c chain) apply(name string, fn func(Strategy, string) []string) []string {
+	var names = []string{name}
+	for _, strategy := range c {
+		var next []string
+		var seen = make(map[string]bool)
+		for _, n := range names {
+			for _, mapped := range fn(strategy, n) {
+				if !seen[mapped] {
+					seen[mapped] = true
+					next = append(next, mapped)
+				}
+			}
+		}
+		names = next
+	}
+	return names
+}
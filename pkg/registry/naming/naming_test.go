@@ -0,0 +1,201 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package naming
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrategy(t *testing.T) {
+	assert.Equal(t, []string{"app.gray"}, EnvSuffix("gray").RegisterNames("app"))
+	assert.Equal(t, []string{"app"}, EnvSuffix("").WatchNames("app"))
+
+	aliases := Alias(map[string][]string{"user": {"legacy.user", "old.user"}})
+	assert.Equal(t, []string{"user", "legacy.user", "old.user"}, aliases.RegisterNames("user"))
+	assert.Equal(t, []string{"legacy.user", "user", "old.user"}, aliases.WatchNames("legacy.user"))
+	assert.Equal(t, []string{"order"}, aliases.WatchNames("order"))
+
+	chained := Chain(aliases, EnvSuffix("canary"))
+	assert.Equal(t, []string{"user.canary", "legacy.user.canary", "old.user.canary"}, chained.RegisterNames("user"))
+}
+
+type memRegistry struct {
+	registry.Nop
+	mu       sync.Mutex
+	services map[string][]*server.ServiceInfo
+}
+
+func (m *memRegistry) RegisterService(_ context.Context, info *server.ServiceInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.services[info.Name] = append(m.services[info.Name], info)
+	return nil
+}
+
+func (m *memRegistry) ListServices(_ context.Context, name string, _ string) ([]*server.ServiceInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.services[name], nil
+}
+
+func (m *memRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	services, _ := m.ListServices(ctx, name, scheme)
+	var ch = make(chan registry.Endpoints, 1)
+	ch <- registry.Endpoints{}.Update(func(tx *registry.EndpointsTx) {
+		for _, info := range services {
+			tx.SetNode(info.Address, *info)
+		}
+	})
+	return ch, nil
+}
+
+func TestNamingRegistry(t *testing.T) {
+	mem := &memRegistry{services: make(map[string][]*server.ServiceInfo)}
+	reg := New(mem, Alias(map[string][]string{"user": {"legacy.user"}}))
+
+	assert.Nil(t, reg.RegisterService(context.Background(), &server.ServiceInfo{Name: "user", Address: "10.0.0.1:80"}))
+	assert.Nil(t, mem.RegisterService(context.Background(), &server.ServiceInfo{Name: "legacy.user", Address: "10.0.0.2:80"}))
+	assert.Equal(t, "legacy.user", mem.services["legacy.user"][0].Name)
+
+	services, err := reg.ListServices(context.Background(), "legacy.user", "grpc")
+	assert.Nil(t, err)
+	var addrs []string
+	for _, service := range services {
+		addrs = append(addrs, service.Address)
+	}
+	sort.Strings(addrs)
+	assert.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, addrs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.WatchServices(ctx, "user", "grpc")
+	assert.Nil(t, err)
+	endpoints := <-ch
+	assert.Equal(t, 2, endpoints.Nodes.Len())
+	info, _ := endpoints.Nodes.Get("10.0.0.1:80")
+	assert.Equal(t, "user", info.Name)
+}
+
+type batchRegistry struct {
+	registry.Nop
+	batches [][]string
+}
+
+func (b *batchRegistry) RegisterServices(_ context.Context, infos []*server.ServiceInfo) error {
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	b.batches = append(b.batches, names)
+	return nil
+}
+
+func TestNamingRegistry_RegisterServices(t *testing.T) {
+	batch := &batchRegistry{}
+	reg := New(batch, EnvSuffix("gray"))
+	infos := []*server.ServiceInfo{{Name: "user", Scheme: "grpc"}, {Name: "user", Scheme: "http"}}
+	assert.Nil(t, registry.RegisterServices(context.Background(), reg, infos))
+	assert.Equal(t, [][]string{{"user.gray", "user.gray"}}, batch.batches)
+	assert.Equal(t, "user", infos[0].Name)
+}
+
+type inspectedRegistry struct {
+	registry.Nop
+}
+
+func (inspectedRegistry) RegisteredKeys(context.Context) ([]registry.RegisteredKey, error) {
+	return []registry.RegisteredKey{{Key: "/jupiter/user/providers/grpc://10.0.0.1:80", LeaseID: 1}}, nil
+}
+
+func TestNamingRegistry_RegisteredKeys(t *testing.T) {
+	inspector, ok := New(inspectedRegistry{}, EnvSuffix("")).(registry.KeyInspector)
+	assert.True(t, ok)
+	keys, err := inspector.RegisteredKeys(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, keys, 1)
+
+	_, err = New(registry.Nop{}, EnvSuffix("")).(registry.KeyInspector).RegisteredKeys(context.Background())
+	assert.Equal(t, registry.ErrNotInspector, err)
+}
+
+type streamRegistry struct {
+	*memRegistry
+}
+
+func (s streamRegistry) StreamServices(ctx context.Context, name string, scheme string, fn func([]*server.ServiceInfo) error) error {
+	services, _ := s.ListServices(ctx, name, scheme)
+	for _, service := range services {
+		if err := fn([]*server.ServiceInfo{service}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestNamingRegistry_Optional(t *testing.T) {
+	mem := &memRegistry{services: make(map[string][]*server.ServiceInfo)}
+	aliases := Alias(map[string][]string{"user": {"legacy.user"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, New(mem, aliases).RegisterService(ctx, &server.ServiceInfo{Name: "user", Address: "10.0.0.1:80"}))
+	assert.Nil(t, mem.RegisterService(ctx, &server.ServiceInfo{Name: "legacy.user", Address: "10.0.0.2:80"}))
+
+	for _, reg := range []registry.Registry{New(mem, aliases), New(streamRegistry{mem}, aliases)} {
+		var addrs []string
+		err := reg.(registry.ServiceStreamer).StreamServices(ctx, "user", "grpc", func(services []*server.ServiceInfo) error {
+			for _, service := range services {
+				addrs = append(addrs, service.Address)
+			}
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80"}, addrs)
+
+		store, err := registry.WatchSnapshots(ctx, reg, "legacy.user", "grpc")
+		assert.Nil(t, err)
+		<-store.Changed(0)
+		endpoints, _ := store.Latest()
+		assert.Equal(t, 2, endpoints.Nodes.Len())
+
+		_, err = reg.(registry.PrefixWatcher).WatchServicesByPrefix(ctx, "user*", "grpc")
+		assert.Equal(t, errPrefixWatcher, err)
+	}
+}
+
+func Test_mergeSchemes(t *testing.T) {
+	node := func(name, addr string) registry.Endpoints {
+		return registry.Endpoints{}.Update(func(tx *registry.EndpointsTx) {
+			tx.SetNode(addr, server.ServiceInfo{Name: name, Address: addr})
+		})
+	}
+	merged := mergeSchemes([]registry.SchemeEndpoints{
+		{"grpc": node("user", "10.0.0.1:80"), "http": node("user", "10.0.0.1:8080")},
+		{"grpc": node("legacy.user", "10.0.0.1:80")},
+		{"grpc": node("legacy.user", "10.0.0.2:80")},
+	})
+
+	assert.Equal(t, 2, len(merged))
+	assert.Equal(t, 2, merged["grpc"].Nodes.Len())
+	assert.Equal(t, 1, merged["http"].Nodes.Len())
+	info, _ := merged["grpc"].Nodes.Get("10.0.0.1:80")
+	assert.Equal(t, "user", info.Name)
+}
@@ -0,0 +1,348 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package naming
+
+import (
+	"context"
+	"errors"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"golang.org/x/sync/errgroup"
+)
+
+var errSchemeWatcher = errors.New("registry doesn't support watching schemes")
+
+// errPrefixWatcher is returned by WatchServicesByPrefix, since a pattern
+// can't be mapped by the strategy the way a name is
+var errPrefixWatcher = errors.New("naming registry doesn't support watching by prefix")
+
+var (
+	_ registry.ServiceStreamer = &namingRegistry{}
+	_ registry.SnapshotWatcher = &namingRegistry{}
+	_ registry.PrefixWatcher   = &namingRegistry{}
+)
+
+type namingRegistry struct {
+	registry.Registry
+	strategy Strategy
+}
+
+// New returns reg decorated with strategy
+func New(reg registry.Registry, strategy Strategy) registry.Registry {
+	return &namingRegistry{
+		Registry: reg,
+		strategy: strategy,
+	}
+}
+
+// RegisterService registers info under every name of the strategy
+func (n *namingRegistry) RegisterService(ctx context.Context, info *server.ServiceInfo) error {
+	return n.each(info, func(info *server.ServiceInfo) error {
+		return n.Registry.RegisterService(ctx, info)
+	})
+}
+
+// UnregisterService ...
+func (n *namingRegistry) UnregisterService(ctx context.Context, info *server.ServiceInfo) error {
+	return n.each(info, func(info *server.ServiceInfo) error {
+		return n.Registry.UnregisterService(ctx, info)
+	})
+}
+
+// RegisterServices registers infos under every name of the strategy, all at
+// once if the underlying registry is a registry.BatchRegisterer
+func (n *namingRegistry) RegisterServices(ctx context.Context, infos []*server.ServiceInfo) error {
+	var named = make([]*server.ServiceInfo, 0, len(infos))
+	for _, info := range infos {
+		for _, name := range n.strategy.RegisterNames(info.Name) {
+			info := *info
+			info.Name = name
+			named = append(named, &info)
+		}
+	}
+	return registry.RegisterServices(ctx, n.Registry, named)
+}
+
+// CloseNow closes the underlying registry without waiting for consumers
+func (n *namingRegistry) CloseNow() error {
+	return registry.CloseNow(n.Registry)
+}
+
+func (n *namingRegistry) each(info *server.ServiceInfo, fn func(*server.ServiceInfo) error) error {
+	var eg errgroup.Group
+	for _, name := range n.strategy.RegisterNames(info.Name) {
+		named := *info
+		named.Name = name
+		eg.Go(func() error {
+			return fn(&named)
+		})
+	}
+	return eg.Wait()
+}
+
+// ListServices lists services of all names, nodes of the first name win
+func (n *namingRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	var names = n.strategy.WatchNames(name)
+	var lists = make([][]*server.ServiceInfo, len(names))
+	var eg errgroup.Group
+	for i, name := range names {
+		i, name := i, name
+		eg.Go(func() (err error) {
+			lists[i], err = n.Registry.ListServices(ctx, name, scheme)
+			return
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	var services = make([]*server.ServiceInfo, 0)
+	var seen = make(map[string]bool)
+	for _, list := range lists {
+		for _, service := range list {
+			if !seen[service.Address] {
+				seen[service.Address] = true
+				services = append(services, service)
+			}
+		}
+	}
+	return services, nil
+}
+
+// StreamServices streams services of all names in order, nodes of the first
+// name win. Names are listed in a single page each if the underlying
+// registry isn't a registry.ServiceStreamer.
+func (n *namingRegistry) StreamServices(ctx context.Context, name string, scheme string, fn func([]*server.ServiceInfo) error) error {
+	var seen = make(map[string]bool)
+	var page = func(services []*server.ServiceInfo) error {
+		var unseen = make([]*server.ServiceInfo, 0, len(services))
+		for _, service := range services {
+			if !seen[service.Address] {
+				seen[service.Address] = true
+				unseen = append(unseen, service)
+			}
+		}
+		if len(unseen) == 0 {
+			return nil
+		}
+		return fn(unseen)
+	}
+	streamer, ok := n.Registry.(registry.ServiceStreamer)
+	for _, name := range n.strategy.WatchNames(name) {
+		if ok {
+			if err := streamer.StreamServices(ctx, name, scheme, page); err != nil {
+				return err
+			}
+			continue
+		}
+		services, err := n.Registry.ListServices(ctx, name, scheme)
+		if err != nil {
+			return err
+		}
+		if err := page(services); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WatchSnapshots watches endpoints of all names into a store, the snapshots
+// of the underlying registry are used as is if there's a single name.
+func (n *namingRegistry) WatchSnapshots(ctx context.Context, name string, scheme string) (*registry.EndpointsStore, error) {
+	var names = n.strategy.WatchNames(name)
+	if len(names) == 1 {
+		return registry.WatchSnapshots(ctx, n.Registry, names[0], scheme)
+	}
+	// hide WatchSnapshots of n, so the merged versions of WatchServices
+	// are stored
+	return registry.WatchSnapshots(ctx, struct{ registry.Registry }{n}, name, scheme)
+}
+
+// WatchServicesByPrefix isn't supported, patterns match the names in
+// registry which the strategy can't map back
+func (n *namingRegistry) WatchServicesByPrefix(ctx context.Context, pattern string, scheme string) (chan registry.ServiceEndpoints, error) {
+	return nil, errPrefixWatcher
+}
+
+// WatchServices watches all names and merges their endpoints, the merged
+// endpoints are sent once every name has reported its initial endpoints.
+func (n *namingRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	var names = n.strategy.WatchNames(name)
+	if len(names) == 1 {
+		return n.Registry.WatchServices(ctx, names[0], scheme)
+	}
+
+	type update struct {
+		idx       int
+		endpoints registry.Endpoints
+	}
+	var updates = make(chan update)
+	for i, name := range names {
+		ch, err := n.Registry.WatchServices(ctx, name, scheme)
+		if err != nil {
+			return nil, err
+		}
+		i := i
+		xgo.Go(func() {
+			for endpoints := range ch {
+				select {
+				case updates <- update{idx: i, endpoints: endpoints}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+
+	var merged = make(chan registry.Endpoints, 10)
+	xgo.Go(func() {
+		defer close(merged)
+		var latest = make([]*registry.Endpoints, len(names))
+		var ready int
+		for {
+			select {
+			case u := <-updates:
+				if latest[u.idx] == nil {
+					ready++
+				}
+				latest[u.idx] = &u.endpoints
+				if ready < len(names) {
+					continue
+				}
+				select {
+				case merged <- merge(latest):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return merged, nil
+}
+
+// merge merges endpoints, earlier ones take precedence
+func merge(all []*registry.Endpoints) registry.Endpoints {
+	var out registry.Endpoints
+	return out.Update(func(tx *registry.EndpointsTx) {
+		for i := len(all) - 1; i >= 0; i-- {
+			endpoints := all[i]
+			endpoints.Nodes.Range(func(addr string, info server.ServiceInfo) bool {
+				tx.SetNode(addr, info)
+				return true
+			})
+			for k, v := range endpoints.RouteConfigs {
+				tx.SetRouteConfig(k, v)
+			}
+			for k, v := range endpoints.ConsumerConfigs {
+				tx.SetConsumerConfig(k, v)
+			}
+			for k, v := range endpoints.ProviderConfigs {
+				tx.SetProviderConfig(k, v)
+			}
+		}
+	})
+}
+
+// RegisteredKeys reports keys of the underlying registry, which must
+// implement registry.KeyInspector
+func (n *namingRegistry) RegisteredKeys(ctx context.Context) ([]registry.RegisteredKey, error) {
+	inspector, ok := n.Registry.(registry.KeyInspector)
+	if !ok {
+		return nil, registry.ErrNotInspector
+	}
+	return inspector.RegisteredKeys(ctx)
+}
+
+// WatchSchemes watches all schemes of all names, the underlying registry
+// must implement registry.SchemeWatcher
+func (n *namingRegistry) WatchSchemes(ctx context.Context, name string) (chan registry.SchemeEndpoints, error) {
+	watcher, ok := n.Registry.(registry.SchemeWatcher)
+	if !ok {
+		return nil, errSchemeWatcher
+	}
+	var names = n.strategy.WatchNames(name)
+	if len(names) == 1 {
+		return watcher.WatchSchemes(ctx, names[0])
+	}
+
+	type update struct {
+		idx       int
+		endpoints registry.SchemeEndpoints
+	}
+	var updates = make(chan update)
+	for i, name := range names {
+		ch, err := watcher.WatchSchemes(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		i := i
+		xgo.Go(func() {
+			for endpoints := range ch {
+				select {
+				case updates <- update{idx: i, endpoints: endpoints}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		})
+	}
+
+	var merged = make(chan registry.SchemeEndpoints, 10)
+	xgo.Go(func() {
+		var latest = make([]registry.SchemeEndpoints, len(names))
+		var reported = make([]bool, len(names))
+		var ready int
+		for {
+			select {
+			case u := <-updates:
+				if !reported[u.idx] {
+					reported[u.idx] = true
+					ready++
+				}
+				latest[u.idx] = u.endpoints
+				if ready < len(names) {
+					continue
+				}
+				select {
+				case merged <- mergeSchemes(latest):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return merged, nil
+}
+
+func mergeSchemes(all []registry.SchemeEndpoints) registry.SchemeEndpoints {
+	var schemes = make(map[string][]*registry.Endpoints)
+	for _, endpoints := range all {
+		for scheme := range endpoints {
+			e := endpoints[scheme]
+			schemes[scheme] = append(schemes[scheme], &e)
+		}
+	}
+	var out = make(registry.SchemeEndpoints, len(schemes))
+	for scheme, endpoints := range schemes {
+		out[scheme] = merge(endpoints)
+	}
+	return out
+}
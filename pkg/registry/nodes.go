@@ -0,0 +1,185 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"hash/fnv"
+	"reflect"
+
+	"github.com/douyu/jupiter/pkg/server"
+)
+
+// nodeShards must be power of 2
+const nodeShards = 256
+
+// Nodes is an immutable set of service nodes keyed by address.
+// Nodes are split into shards, an update copies the shard table and the
+// shards it touches only, the other shards are shared with the previous
+// version. So a watch event on a large cluster allocates O(n/nodeShards)
+// instead of cloning the whole set, and readers holding an old version
+// never need a lock. A nil *Nodes is an empty set.
+type Nodes struct {
+	size   int
+	shards [nodeShards]map[string]server.ServiceInfo
+}
+
+// NewNodes returns nodes containing all entries of m
+func NewNodes(m map[string]server.ServiceInfo) *Nodes {
+	var nodes *Nodes
+	return nodes.Update(func(tx *NodesTx) {
+		for addr, info := range m {
+			tx.Set(addr, info)
+		}
+	})
+}
+
+// Len returns the number of nodes
+func (n *Nodes) Len() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// Get returns the node of addr
+func (n *Nodes) Get(addr string) (server.ServiceInfo, bool) {
+	if n == nil {
+		return server.ServiceInfo{}, false
+	}
+	info, ok := n.shards[shardOf(addr)][addr]
+	return info, ok
+}
+
+// Range calls fn for each node until fn returns false, the order is unspecified
+func (n *Nodes) Range(fn func(addr string, info server.ServiceInfo) bool) {
+	if n == nil {
+		return
+	}
+	for _, shard := range n.shards {
+		for addr, info := range shard {
+			if !fn(addr, info) {
+				return
+			}
+		}
+	}
+}
+
+// Map returns a copy of nodes as a map
+func (n *Nodes) Map() map[string]server.ServiceInfo {
+	m := make(map[string]server.ServiceInfo, n.Len())
+	n.Range(func(addr string, info server.ServiceInfo) bool {
+		m[addr] = info
+		return true
+	})
+	return m
+}
+
+// Diff calls fn with events changing prev into n until fn returns false.
+// Shards shared by both versions are skipped, so diffing versions derived
+// from each other costs O(changed shards).
+func (n *Nodes) Diff(prev *Nodes, fn func(event NodeEvent) bool) {
+	var prevShards, shards [nodeShards]map[string]server.ServiceInfo
+	if prev != nil {
+		prevShards = prev.shards
+	}
+	if n != nil {
+		shards = n.shards
+	}
+	for idx := range shards {
+		prevShard, shard := prevShards[idx], shards[idx]
+		if reflect.ValueOf(prevShard).Pointer() == reflect.ValueOf(shard).Pointer() {
+			continue
+		}
+		for addr, info := range shard {
+			old, ok := prevShard[addr]
+			switch {
+			case !ok:
+				if !fn(NodeEvent{Event: EventAdd, Address: addr, Node: info}) {
+					return
+				}
+			case !reflect.DeepEqual(old, info):
+				if !fn(NodeEvent{Event: EventUpdate, Address: addr, Node: info}) {
+					return
+				}
+			}
+		}
+		for addr, info := range prevShard {
+			if _, ok := shard[addr]; !ok {
+				if !fn(NodeEvent{Event: EventDelete, Address: addr, Node: info}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Update returns a new version of nodes with the changes made by fn,
+// n itself is never modified.
+func (n *Nodes) Update(fn func(tx *NodesTx)) *Nodes {
+	next := &Nodes{}
+	if n != nil {
+		*next = *n
+	}
+	tx := &NodesTx{nodes: next}
+	fn(tx)
+	return next
+}
+
+// NodesTx collects changes of a Nodes update, each shard is copied at most
+// once per transaction.
+type NodesTx struct {
+	nodes *Nodes
+	dirty [nodeShards / 64]uint64
+}
+
+// Set adds or replaces the node of addr
+func (tx *NodesTx) Set(addr string, info server.ServiceInfo) {
+	shard := tx.shard(shardOf(addr))
+	if _, ok := shard[addr]; !ok {
+		tx.nodes.size++
+	}
+	shard[addr] = info
+}
+
+// Delete removes the node of addr
+func (tx *NodesTx) Delete(addr string) {
+	idx := shardOf(addr)
+	if _, ok := tx.nodes.shards[idx][addr]; !ok {
+		return
+	}
+	delete(tx.shard(idx), addr)
+	tx.nodes.size--
+}
+
+// shard returns a writable shard, copying it on first write
+func (tx *NodesTx) shard(idx int) map[string]server.ServiceInfo {
+	if tx.dirty[idx/64]&(1<<uint(idx%64)) != 0 {
+		return tx.nodes.shards[idx]
+	}
+	prev := tx.nodes.shards[idx]
+	shard := make(map[string]server.ServiceInfo, len(prev)+1)
+	for addr, info := range prev {
+		shard[addr] = info
+	}
+	tx.nodes.shards[idx] = shard
+	tx.dirty[idx/64] |= 1 << uint(idx%64)
+	return shard
+}
+
+func shardOf(addr string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(addr))
+	return int(h.Sum32() & (nodeShards - 1))
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodes(t *testing.T) {
+	var empty *Nodes
+	assert.Equal(t, 0, empty.Len())
+
+	v1 := NewNodes(map[string]server.ServiceInfo{
+		"127.0.0.1:80": {Name: "a"},
+		"127.0.0.1:81": {Name: "b"},
+	})
+	v2 := v1.Update(func(tx *NodesTx) {
+		tx.Set("127.0.0.1:82", server.ServiceInfo{Name: "c"})
+		tx.Set("127.0.0.1:80", server.ServiceInfo{Name: "a2"})
+		tx.Delete("127.0.0.1:81")
+		tx.Delete("127.0.0.1:83")
+	})
+
+	// v1 is untouched
+	assert.Equal(t, 2, v1.Len())
+	info, ok := v1.Get("127.0.0.1:80")
+	assert.True(t, ok)
+	assert.Equal(t, "a", info.Name)
+
+	assert.Equal(t, 2, v2.Len())
+	assert.Equal(t, map[string]server.ServiceInfo{
+		"127.0.0.1:80": {Name: "a2"},
+		"127.0.0.1:82": {Name: "c"},
+	}, v2.Map())
+	_, ok = v2.Get("127.0.0.1:81")
+	assert.False(t, ok)
+}
+
+func TestNodes_Diff(t *testing.T) {
+	v1 := NewNodes(map[string]server.ServiceInfo{
+		"127.0.0.1:80": {Name: "a"},
+		"127.0.0.1:81": {Name: "b"},
+		"127.0.0.1:82": {Name: "c"},
+	})
+	v2 := v1.Update(func(tx *NodesTx) {
+		tx.Set("127.0.0.1:80", server.ServiceInfo{Name: "a2"})
+		tx.Set("127.0.0.1:81", server.ServiceInfo{Name: "b"})
+		tx.Delete("127.0.0.1:82")
+		tx.Set("127.0.0.1:83", server.ServiceInfo{Name: "d"})
+	})
+
+	diff := func(n, prev *Nodes) map[string]NodeEvent {
+		var events = make(map[string]NodeEvent)
+		n.Diff(prev, func(event NodeEvent) bool {
+			events[event.Address] = event
+			return true
+		})
+		return events
+	}
+	assert.Equal(t, map[string]NodeEvent{
+		"127.0.0.1:80": {Event: EventUpdate, Address: "127.0.0.1:80", Node: server.ServiceInfo{Name: "a2"}},
+		"127.0.0.1:82": {Event: EventDelete, Address: "127.0.0.1:82", Node: server.ServiceInfo{Name: "c"}},
+		"127.0.0.1:83": {Event: EventAdd, Address: "127.0.0.1:83", Node: server.ServiceInfo{Name: "d"}},
+	}, diff(v2, v1))
+	assert.Len(t, diff(v1, nil), 3)
+	assert.Empty(t, diff(v2, v2))
+
+	var calls int
+	v2.Diff(nil, func(NodeEvent) bool {
+		calls++
+		return false
+	})
+	assert.Equal(t, 1, calls)
+}
+
+func TestEndpoints_Update(t *testing.T) {
+	v1 := Endpoints{RouteConfigs: map[string]RouteConfig{"r1": {ID: "1"}}}
+	v2 := v1.Update(func(tx *EndpointsTx) {
+		tx.SetNode("127.0.0.1:80", server.ServiceInfo{Name: "a"})
+		tx.SetRouteConfig("r2", RouteConfig{ID: "2"})
+		tx.DeleteRouteConfig("r1")
+		tx.SetProviderConfig("p1", ProviderConfig{ID: "p"})
+	})
+
+	assert.Equal(t, 0, v1.Nodes.Len())
+	assert.Equal(t, map[string]RouteConfig{"r1": {ID: "1"}}, v1.RouteConfigs)
+	assert.Nil(t, v1.ProviderConfigs)
+
+	assert.Equal(t, 1, v2.Nodes.Len())
+	assert.Equal(t, map[string]RouteConfig{"r2": {ID: "2"}}, v2.RouteConfigs)
+	assert.Len(t, v2.ProviderConfigs, 1)
+	assert.Nil(t, v2.ConsumerConfigs)
+}
+
+func BenchmarkNodes_Update(b *testing.B) {
+	nodes := make(map[string]server.ServiceInfo, 10000)
+	for i := 0; i < 10000; i++ {
+		nodes[fmt.Sprintf("10.0.%d.%d:80", i/256, i%256)] = server.ServiceInfo{Name: "demo"}
+	}
+	set := NewNodes(nodes)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set = set.Update(func(tx *NodesTx) {
+			tx.Set("10.0.0.1:80", server.ServiceInfo{Name: "demo", Weight: float64(i)})
+		})
+	}
+}
+
+func TestEndpoints_NodeMap(t *testing.T) {
+	assert.Empty(t, Endpoints{}.NodeMap())
+	m := map[string]server.ServiceInfo{"127.0.0.1:80": {Name: "a"}}
+	endpoints := Endpoints{Nodes: NewNodes(m)}
+	assert.Equal(t, m, endpoints.NodeMap())
+}
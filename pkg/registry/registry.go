@@ -33,8 +33,24 @@ const (
 	EventUpdate
 	// EventDelete ...
 	EventDelete
+	// EventAdd ...
+	EventAdd
 )
 
+// String ...
+func (event Event) String() string {
+	switch event {
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	case EventAdd:
+		return "add"
+	default:
+		return "unknown"
+	}
+}
+
 // Kind ...
 type Kind uint8
 
@@ -105,6 +121,71 @@ type Registry interface {
 	io.Closer
 }
 
+// ServiceStreamer is implemented by registries which can list services
+// incrementally, fn is called with each page of services as it's fetched,
+// listing stops if fn returns an error.
+type ServiceStreamer interface {
+	StreamServices(ctx context.Context, name string, scheme string, fn func([]*server.ServiceInfo) error) error
+}
+
+// SchemeEndpoints is endpoints of a service keyed by scheme
+type SchemeEndpoints map[string]Endpoints
+
+// SchemeWatcher is implemented by registries which can watch all schemes of
+// a service (e.g. grpc and http) with a single watcher.
+type SchemeWatcher interface {
+	WatchSchemes(ctx context.Context, name string) (chan SchemeEndpoints, error)
+}
+
+// ServiceEndpoints is endpoints of services keyed by service name
+type ServiceEndpoints map[string]Endpoints
+
+// PrefixWatcher is implemented by registries which can watch a family of
+// services whose names match a pattern, e.g. "payment-*", in one stream.
+type PrefixWatcher interface {
+	WatchServicesByPrefix(ctx context.Context, pattern string, scheme string) (chan ServiceEndpoints, error)
+}
+
+// BatchRegisterer is implemented by registries which can register several
+// services atomically, e.g. grpc and http services of an application, so
+// that they are never half-registered.
+type BatchRegisterer interface {
+	RegisterServices(ctx context.Context, infos []*server.ServiceInfo) error
+}
+
+// RegisterServices registers infos with reg, atomically if reg is a
+// BatchRegisterer, or else one by one, and the registered ones are
+// unregistered again once one of them fails.
+func RegisterServices(ctx context.Context, reg Registry, infos []*server.ServiceInfo) error {
+	if batch, ok := reg.(BatchRegisterer); ok {
+		return batch.RegisterServices(ctx, infos)
+	}
+	for idx, info := range infos {
+		if err := reg.RegisterService(ctx, info); err != nil {
+			for _, registered := range infos[:idx] {
+				_ = reg.UnregisterService(ctx, registered)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Drainer is implemented by registries which wait on Close for consumers to
+// drop deregistered services, CloseNow closes without waiting.
+type Drainer interface {
+	CloseNow() error
+}
+
+// CloseNow closes reg without waiting for consumers if reg is a Drainer,
+// or else it's the same as Close
+func CloseNow(reg Registry) error {
+	if drainer, ok := reg.(Drainer); ok {
+		return drainer.CloseNow()
+	}
+	return reg.Close()
+}
+
 //GetServiceKey ..
 func GetServiceKey(prefix string, s *server.ServiceInfo) string {
 	return fmt.Sprintf("/%s/%s/%s/%s://%s", prefix, s.Name, s.Kind.String(), s.Scheme, s.Address)
@@ -119,7 +200,8 @@ func GetServiceValue(s *server.ServiceInfo) string {
 //GetService ..
 func GetService(s string) *server.ServiceInfo {
 	var si server.ServiceInfo
-	json.Unmarshal([]byte(s), &si)
+	data, _ := DecodeValue([]byte(s))
+	_ = UnmarshalService(data, &si)
 	return &si
 }
 
@@ -0,0 +1,260 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+)
+
+// LabelSelector matches labels of services, it's parsed from comma separated
+// requirements, all of which must be met:
+//
+//	key=value, key==value  label equals value
+//	key!=value             label is missing or doesn't equal value
+//	key                    label exists
+//	!key                   label is missing
+type LabelSelector []LabelRequirement
+
+// LabelRequirement is a requirement of a LabelSelector
+type LabelRequirement struct {
+	Key string
+	// Op is one of "=", "!=", "exists" and "!exists"
+	Op    string
+	Value string
+}
+
+// ParseLabelSelector parses selector, e.g. "region=bj,env=prod"
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var ret LabelSelector
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		var req LabelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			req = LabelRequirement{Key: kv[0], Op: "!=", Value: kv[1]}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(strings.Replace(part, "==", "=", 1), "=", 2)
+			req = LabelRequirement{Key: kv[0], Op: "=", Value: kv[1]}
+		case strings.HasPrefix(part, "!"):
+			req = LabelRequirement{Key: part[1:], Op: "!exists"}
+		default:
+			req = LabelRequirement{Key: part, Op: "exists"}
+		}
+		req.Key, req.Value = strings.TrimSpace(req.Key), strings.TrimSpace(req.Value)
+		if req.Key == "" || strings.ContainsAny(req.Key, "=!") || strings.ContainsAny(req.Value, "=!") {
+			return nil, fmt.Errorf("invalid label requirement %q", part)
+		}
+		ret = append(ret, req)
+	}
+	return ret, nil
+}
+
+// Matches reports whether labels meet all requirements of s
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range s {
+		value, ok := labels[req.Key]
+		switch req.Op {
+		case "=":
+			if !ok || value != req.Value {
+				return false
+			}
+		case "!=":
+			if ok && value == req.Value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// MatchesService reports whether labels of info meet s, region, zone,
+// deployment and group of info are matched as labels too unless info has
+// labels of the same keys.
+func (s LabelSelector) MatchesService(info server.ServiceInfo) bool {
+	if len(s) == 0 {
+		return true
+	}
+	var labels = make(map[string]string, len(info.Labels)+4)
+	for key, value := range map[string]string{
+		"region":     info.Region,
+		"zone":       info.Zone,
+		"deployment": info.Deployment,
+		"group":      info.Group,
+	} {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+	for key, value := range info.Labels {
+		labels[key] = value
+	}
+	return s.Matches(labels)
+}
+
+// String ...
+func (s LabelSelector) String() string {
+	var parts = make([]string, 0, len(s))
+	for _, req := range s {
+		switch req.Op {
+		case "exists":
+			parts = append(parts, req.Key)
+		case "!exists":
+			parts = append(parts, "!"+req.Key)
+		default:
+			parts = append(parts, req.Key+req.Op+req.Value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+type labelSelectorKey struct{}
+
+// WithLabelSelector returns a context with which ListServices and
+// WatchServices of registries only return services matching selector, see
+// LabelSelector for the syntax
+func WithLabelSelector(ctx context.Context, selector string) context.Context {
+	return context.WithValue(ctx, labelSelectorKey{}, selector)
+}
+
+// LabelSelectorFromContext returns the selector set by WithLabelSelector,
+// which is empty and matches everything if none is set
+func LabelSelectorFromContext(ctx context.Context) (LabelSelector, error) {
+	selector, _ := ctx.Value(labelSelectorKey{}).(string)
+	return ParseLabelSelector(selector)
+}
+
+// FilterServices returns services matching the filter of ctx, it's called
+// by registries in ListServices
+func FilterServices(ctx context.Context, services []*server.ServiceInfo) ([]*server.ServiceInfo, error) {
+	filter, err := ServiceFilterFromContext(ctx)
+	if err != nil {
+		return services, err
+	}
+	var ret = make([]*server.ServiceInfo, 0, len(services))
+	for _, info := range services {
+		if filter.Matches(*info) {
+			ret = append(ret, info)
+		}
+	}
+	return ret, nil
+}
+
+// FilterEndpoints returns a channel of endpoints of watch with nodes matching
+// the filter of ctx, it's called by registries in WatchServices. Only nodes
+// changed between versions of watch are matched again.
+func FilterEndpoints(ctx context.Context, watch chan Endpoints) (chan Endpoints, error) {
+	filter, err := ServiceFilterFromContext(ctx)
+	if err != nil {
+		return watch, err
+	}
+
+	var filtered = make(chan Endpoints, cap(watch))
+	var apply = filterNodes(filter)
+	xgo.Go(func() {
+		defer close(filtered)
+		for {
+			select {
+			case endpoints, ok := <-watch:
+				if !ok {
+					return
+				}
+				select {
+				case filtered <- apply(endpoints):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return filtered, nil
+}
+
+// FilterStore returns a store following versions of store with nodes
+// matching the filter of ctx, it's called by registries in WatchSnapshots.
+// The returned store is closed with store or once ctx is done.
+func FilterStore(ctx context.Context, store *EndpointsStore) (*EndpointsStore, error) {
+	filter, err := ServiceFilterFromContext(ctx)
+	if err != nil {
+		return store, err
+	}
+
+	var filtered = NewEndpointsStore()
+	var apply = filterNodes(filter)
+	var resyncs uint64
+	endpoints, version := store.follow(&resyncs)
+	if version > 0 {
+		filtered.Set(apply(endpoints))
+	}
+	xgo.Go(func() {
+		defer filtered.Close()
+		for {
+			select {
+			case <-store.Changed(version):
+			case <-ctx.Done():
+				return
+			}
+			var next uint64
+			endpoints, next = store.follow(&resyncs)
+			if next == version {
+				// closed
+				return
+			}
+			version = next
+			filtered.Set(apply(endpoints))
+		}
+	})
+	return filtered, nil
+}
+
+// filterNodes returns a function replacing nodes of versions of endpoints
+// with those matching filter, only nodes changed since the previous version
+// are matched again
+func filterNodes(filter ServiceFilter) func(Endpoints) Endpoints {
+	var prev, nodes *Nodes
+	return func(endpoints Endpoints) Endpoints {
+		nodes = nodes.Update(func(tx *NodesTx) {
+			endpoints.Nodes.Diff(prev, func(event NodeEvent) bool {
+				if event.Event != EventDelete && filter.Matches(event.Node) {
+					tx.Set(event.Address, event.Node)
+				} else {
+					tx.Delete(event.Address)
+				}
+				return true
+			})
+		})
+		prev = endpoints.Nodes
+		endpoints.Nodes = nodes
+		return endpoints
+	}
+}
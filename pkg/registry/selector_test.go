@@ -0,0 +1,90 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	selector, err := ParseLabelSelector("region=bj, env==prod,canary!=true,gray,!debug")
+	assert.Nil(t, err)
+	assert.Equal(t, LabelSelector{
+		{Key: "region", Op: "=", Value: "bj"},
+		{Key: "env", Op: "=", Value: "prod"},
+		{Key: "canary", Op: "!=", Value: "true"},
+		{Key: "gray", Op: "exists"},
+		{Key: "debug", Op: "!exists"},
+	}, selector)
+	assert.Equal(t, "region=bj,env=prod,canary!=true,gray,!debug", selector.String())
+
+	assert.True(t, selector.Matches(map[string]string{"region": "bj", "env": "prod", "gray": ""}))
+	assert.False(t, selector.Matches(map[string]string{"region": "bj", "env": "prod", "gray": "", "canary": "true"}))
+	assert.False(t, selector.Matches(map[string]string{"region": "bj", "env": "prod", "gray": "", "debug": "1"}))
+	assert.False(t, selector.Matches(map[string]string{"region": "bj", "env": "prod"}))
+
+	selector, err = ParseLabelSelector("")
+	assert.Nil(t, err)
+	assert.True(t, selector.Matches(nil))
+
+	for _, invalid := range []string{"=bj", "region=b=j", "!", "region!=b!j"} {
+		_, err = ParseLabelSelector(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestLabelSelector_MatchesService(t *testing.T) {
+	selector, _ := ParseLabelSelector("region=bj,env=prod")
+	assert.True(t, selector.MatchesService(server.ServiceInfo{Region: "bj", Labels: map[string]string{"env": "prod"}}))
+	// labels override fields
+	assert.False(t, selector.MatchesService(server.ServiceInfo{Region: "bj", Labels: map[string]string{"env": "prod", "region": "sh"}}))
+	assert.False(t, selector.MatchesService(server.ServiceInfo{Region: "bj"}))
+}
+
+func TestFilterEndpoints(t *testing.T) {
+	ctx, cancel := context.WithCancel(WithLabelSelector(context.Background(), "env=prod"))
+	defer cancel()
+
+	var watch = make(chan Endpoints, 10)
+	filtered, err := FilterEndpoints(ctx, watch)
+	assert.Nil(t, err)
+
+	v1 := Endpoints{Nodes: NewNodes(map[string]server.ServiceInfo{
+		"127.0.0.1:80": {Address: "127.0.0.1:80", Labels: map[string]string{"env": "prod"}},
+		"127.0.0.1:81": {Address: "127.0.0.1:81", Labels: map[string]string{"env": "dev"}},
+	})}
+	watch <- v1
+	assert.Equal(t, map[string]server.ServiceInfo{
+		"127.0.0.1:80": {Address: "127.0.0.1:80", Labels: map[string]string{"env": "prod"}},
+	}, recvEndpoints(t, filtered).Nodes.Map())
+
+	// nodes leaving or joining the selection
+	watch <- v1.Update(func(tx *EndpointsTx) {
+		tx.SetNode("127.0.0.1:80", server.ServiceInfo{Address: "127.0.0.1:80", Labels: map[string]string{"env": "dev"}})
+		tx.SetNode("127.0.0.1:81", server.ServiceInfo{Address: "127.0.0.1:81", Labels: map[string]string{"env": "prod"}})
+	})
+	assert.Equal(t, []string{"127.0.0.1:81"}, nodeAddrs(recvEndpoints(t, filtered)))
+
+	close(watch)
+	_, ok := <-filtered
+	assert.False(t, ok)
+
+	_, err = FilterEndpoints(WithLabelSelector(context.Background(), "=prod"), watch)
+	assert.NotNil(t, err)
+}
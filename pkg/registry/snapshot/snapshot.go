@@ -0,0 +1,242 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package snapshot decorates a registry with snapshots of watched services
+// persisted to disk, the last known endpoints are served as the incipient
+// ones if the registry is unreachable when watching starts, e.g. etcd is down
+// while the application restarts, and watching is retried in background.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// Config ...
+type Config struct {
+	// Dir of snapshot files, snapshots are disabled if empty
+	Dir string
+	// TTL is how long a snapshot is served since it's saved, older ones are
+	// ignored, 0 means no limit
+	TTL time.Duration
+	// Backoff of retries to watch the registry while a snapshot is served
+	Backoff xbackoff.Config
+
+	clock xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() Config {
+	return Config{
+		TTL:     24 * time.Hour,
+		Backoff: xbackoff.DefaultConfig(),
+		clock:   xtime.SystemClock,
+	}
+}
+
+// file is the format of snapshot files
+type file struct {
+	SavedAt         time.Time                          `json:"savedAt"`
+	Nodes           map[string]server.ServiceInfo      `json:"nodes"`
+	RouteConfigs    map[string]registry.RouteConfig    `json:"routeConfigs"`
+	ConsumerConfigs map[string]registry.ConsumerConfig `json:"consumerConfigs"`
+	ProviderConfigs map[string]registry.ProviderConfig `json:"providerConfigs"`
+}
+
+type snapshotRegistry struct {
+	registry.Registry
+	config Config
+	logger *xlog.Logger
+}
+
+// New returns reg with WatchServices falling back to snapshots
+func New(reg registry.Registry, config Config) registry.Registry {
+	if config.clock == nil {
+		config.clock = xtime.SystemClock
+	}
+	return &snapshotRegistry{
+		Registry: reg,
+		config:   config,
+		logger:   xlog.JupiterLogger.With(xlog.FieldMod("registry.snapshot")),
+	}
+}
+
+// RegisterServices registers infos with the underlying registry, atomically
+// if it's a registry.BatchRegisterer
+func (s *snapshotRegistry) RegisterServices(ctx context.Context, infos []*server.ServiceInfo) error {
+	return registry.RegisterServices(ctx, s.Registry, infos)
+}
+
+// CloseNow closes the underlying registry without waiting for consumers
+func (s *snapshotRegistry) CloseNow() error {
+	return registry.CloseNow(s.Registry)
+}
+
+// RegisteredKeys reports keys of the underlying registry, which must
+// implement registry.KeyInspector
+func (s *snapshotRegistry) RegisteredKeys(ctx context.Context) ([]registry.RegisteredKey, error) {
+	inspector, ok := s.Registry.(registry.KeyInspector)
+	if !ok {
+		return nil, registry.ErrNotInspector
+	}
+	return inspector.RegisteredKeys(ctx)
+}
+
+// WatchServices watches the underlying registry and saves endpoints once
+// they change, the snapshot is sent instead if the registry fails and it's
+// not older than TTL, then watching is retried until ctx is done
+func (s *snapshotRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	path := s.path(name, scheme)
+	watch, err := s.Registry.WatchServices(ctx, name, scheme)
+	if err == nil {
+		var addresses = make(chan registry.Endpoints, 10)
+		xgo.Go(func() {
+			defer close(addresses)
+			s.forward(ctx, path, watch, addresses)
+		})
+		return addresses, nil
+	}
+
+	endpoints, savedAt, loadErr := s.load(path)
+	if loadErr != nil {
+		if !os.IsNotExist(loadErr) {
+			s.logger.Warn("load snapshot", xlog.FieldErr(loadErr), xlog.FieldName(name), xlog.String("path", path))
+		}
+		return nil, err
+	}
+	if s.config.TTL > 0 && s.config.clock.Since(savedAt) > s.config.TTL {
+		s.logger.Warn("snapshot expired", xlog.FieldName(name), xlog.String("path", path), xlog.Any("savedAt", savedAt))
+		return nil, err
+	}
+	s.logger.Warn("watch services, serve snapshot", xlog.FieldErr(err), xlog.FieldName(name), xlog.String("scheme", scheme), xlog.Any("savedAt", savedAt))
+
+	var addresses = make(chan registry.Endpoints, 10)
+	addresses <- endpoints
+	xgo.Go(func() {
+		defer close(addresses)
+		for retries := 0; ; retries++ {
+			select {
+			case <-s.config.clock.After(s.config.Backoff.Backoff(retries)):
+			case <-ctx.Done():
+				return
+			}
+			watch, err := s.Registry.WatchServices(ctx, name, scheme)
+			if err != nil {
+				s.logger.Warn("watch services", xlog.FieldErr(err), xlog.FieldName(name), xlog.String("scheme", scheme), xlog.Int("retries", retries))
+				continue
+			}
+			s.logger.Info("watch services recovered", xlog.FieldName(name), xlog.String("scheme", scheme))
+			s.forward(ctx, path, watch, addresses)
+			return
+		}
+	})
+	return addresses, nil
+}
+
+// forward sends endpoints from watch to addresses and saves them, until ctx
+// is done or watch is closed
+func (s *snapshotRegistry) forward(ctx context.Context, path string, watch, addresses chan registry.Endpoints) {
+	for {
+		select {
+		case endpoints, ok := <-watch:
+			if !ok {
+				return
+			}
+			select {
+			case addresses <- endpoints:
+			case <-ctx.Done():
+				return
+			}
+			if err := s.save(path, endpoints); err != nil {
+				s.logger.Warn("save snapshot", xlog.FieldErr(err), xlog.String("path", path))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *snapshotRegistry) path(name, scheme string) string {
+	return filepath.Join(s.config.Dir, url.PathEscape(scheme+"_"+name)+".json")
+}
+
+// save writes the snapshot to a temp file and renames it, so that it's never
+// read half written
+func (s *snapshotRegistry) save(path string, endpoints registry.Endpoints) error {
+	data, err := json.Marshal(file{
+		SavedAt:         s.config.clock.Now(),
+		Nodes:           endpoints.Nodes.Map(),
+		RouteConfigs:    endpoints.RouteConfigs,
+		ConsumerConfigs: endpoints.ConsumerConfigs,
+		ProviderConfigs: endpoints.ProviderConfigs,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.config.Dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.config.Dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *snapshotRegistry) load(path string) (registry.Endpoints, time.Time, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return registry.Endpoints{}, time.Time{}, err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return registry.Endpoints{}, time.Time{}, err
+	}
+	var endpoints = registry.Endpoints{
+		Nodes:           registry.NewNodes(f.Nodes),
+		RouteConfigs:    f.RouteConfigs,
+		ConsumerConfigs: f.ConsumerConfigs,
+		ProviderConfigs: f.ProviderConfigs,
+	}
+	if endpoints.RouteConfigs == nil {
+		endpoints.RouteConfigs = make(map[string]registry.RouteConfig)
+	}
+	if endpoints.ConsumerConfigs == nil {
+		endpoints.ConsumerConfigs = make(map[string]registry.ConsumerConfig)
+	}
+	if endpoints.ProviderConfigs == nil {
+		endpoints.ProviderConfigs = make(map[string]registry.ProviderConfig)
+	}
+	return endpoints, f.SavedAt, nil
+}
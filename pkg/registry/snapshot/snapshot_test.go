@@ -0,0 +1,149 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeRegistry struct {
+	registry.Nop
+	mu    sync.Mutex
+	err   error
+	watch chan registry.Endpoints
+}
+
+func (reg *fakeRegistry) setErr(err error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.err = err
+}
+
+func (reg *fakeRegistry) WatchServices(context.Context, string, string) (chan registry.Endpoints, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.err != nil {
+		return nil, reg.err
+	}
+	return reg.watch, nil
+}
+
+func endpointsOf(addrs ...string) registry.Endpoints {
+	var nodes = make(map[string]server.ServiceInfo)
+	for _, addr := range addrs {
+		nodes["grpc://"+addr] = server.ServiceInfo{Name: "svc", Scheme: "grpc", Address: addr}
+	}
+	return registry.Endpoints{
+		Nodes:        registry.NewNodes(nodes),
+		RouteConfigs: map[string]registry.RouteConfig{"r": {ID: "r", Deployment: "gray"}},
+	}
+}
+
+func recv(t *testing.T, ch chan registry.Endpoints) registry.Endpoints {
+	select {
+	case endpoints := <-ch:
+		return endpoints
+	case <-time.After(time.Second):
+		t.Fatal("no endpoints")
+		return registry.Endpoints{}
+	}
+}
+
+func newTestRegistry(t *testing.T, reg registry.Registry, clock xtime.Clock) (registry.Registry, func()) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.Nil(t, err)
+	config := DefaultConfig()
+	config.Dir = dir
+	config.TTL = time.Hour
+	config.Backoff = xbackoff.Config{BaseDelay: time.Second}
+	config.clock = clock
+	return New(reg, config), func() { os.RemoveAll(dir) }
+}
+
+func TestSnapshot_Watch(t *testing.T) {
+	clock := xtime.NewMockClock(time.Now())
+	fake := &fakeRegistry{watch: make(chan registry.Endpoints, 10)}
+	reg, cleanup := newTestRegistry(t, fake, clock)
+	defer cleanup()
+
+	// no snapshot yet
+	fake.setErr(errors.New("unavailable"))
+	_, err := reg.WatchServices(context.Background(), "svc", "grpc")
+	assert.EqualError(t, err, "unavailable")
+
+	fake.setErr(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := reg.WatchServices(ctx, "svc", "grpc")
+	assert.Nil(t, err)
+	fake.watch <- endpointsOf("10.0.0.1:80", "10.0.0.2:80")
+	assert.Equal(t, 2, recv(t, ch).Nodes.Len())
+	snapshot := reg.(*snapshotRegistry).path("svc", "grpc")
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(snapshot)
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+	cancel()
+	fake.watch = make(chan registry.Endpoints, 10)
+
+	// the snapshot is served while the registry is unreachable
+	fake.setErr(errors.New("unavailable"))
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	ch, err = reg.WatchServices(ctx, "svc", "grpc")
+	assert.Nil(t, err)
+	endpoints := recv(t, ch)
+	info, ok := endpoints.Nodes.Get("grpc://10.0.0.1:80")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1:80", info.Address)
+	assert.Equal(t, 2, endpoints.Nodes.Len())
+	assert.Equal(t, "gray", endpoints.RouteConfigs["r"].Deployment)
+
+	// and replaced once watching recovers
+	clock.BlockUntil(1)
+	fake.setErr(nil)
+	clock.Advance(time.Second)
+	fake.watch <- endpointsOf("10.0.0.3:80")
+	endpoints = recv(t, ch)
+	assert.Equal(t, 1, endpoints.Nodes.Len())
+	_, ok = endpoints.Nodes.Get("grpc://10.0.0.3:80")
+	assert.True(t, ok)
+}
+
+func TestSnapshot_Expired(t *testing.T) {
+	clock := xtime.NewMockClock(time.Now())
+	fake := &fakeRegistry{watch: make(chan registry.Endpoints, 10)}
+	reg, cleanup := newTestRegistry(t, fake, clock)
+	defer cleanup()
+
+	path := reg.(*snapshotRegistry).path("svc", "grpc")
+	assert.Nil(t, reg.(*snapshotRegistry).save(path, endpointsOf("10.0.0.1:80")))
+	clock.Advance(time.Hour * 2)
+
+	fake.setErr(errors.New("unavailable"))
+	_, err := reg.WatchServices(context.Background(), "svc", "grpc")
+	assert.EqualError(t, err, "unavailable")
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package static
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("registry.static", "jupiter.registry.*", "static and dns registry", DefaultConfig)
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.registry." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		xlog.Panic("unmarshal key", xlog.FieldMod("registry.static"), xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err), xlog.String("key", key), xlog.Any("config", config))
+	}
+	return config
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Services:        make(map[string]Service),
+		RefreshInterval: time.Second * 30,
+		Timeout:         time.Second * 3,
+		logger:          xlog.JupiterLogger.With(xlog.FieldMod("registry.static")),
+		clock:           xtime.SystemClock,
+		resolver:        net.DefaultResolver,
+	}
+}
+
+// Config ...
+type Config struct {
+	// Services keyed by service name
+	Services map[string]Service
+	// RefreshInterval of resolving host names and SRV records
+	RefreshInterval time.Duration
+	// Timeout of dns lookups
+	Timeout time.Duration
+
+	logger   *xlog.Logger
+	clock    xtime.Clock
+	resolver resolver
+}
+
+// Service is the endpoints of a service
+type Service struct {
+	// Addresses are "ip:port" or "host:port", hosts are resolved to all
+	// their A/AAAA records
+	Addresses []string
+	// SRV is the name of SRV records, e.g. "_grpc._tcp.demo.example.com",
+	// targets of records are resolved like hosts of Addresses
+	SRV string
+	// Scheme served by endpoints, e.g. "grpc", empty means any scheme
+	Scheme string
+	// Weight of endpoints, 100 if zero. Endpoints of SRV records use
+	// weights of records unless they're zero.
+	Weight float64
+	Zone   string
+	// Metadata of endpoints
+	Metadata map[string]string
+	// Labels of endpoints, matched by label selectors
+	Labels map[string]string
+}
+
+// resolver is implemented by *net.Resolver
+type resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// Build ...
+func (config Config) Build() registry.Registry {
+	if config.RefreshInterval <= 0 {
+		config.logger.Panic("invalid refresh interval", xlog.Duration("interval", config.RefreshInterval))
+	}
+	return newStaticRegistry(&config)
+}
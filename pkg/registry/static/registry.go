@@ -0,0 +1,272 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package static is the registry of services configured statically or
+// resolved from dns, for environments without a registry server.
+package static
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/pkg/errors"
+)
+
+// ErrServiceNotFound is returned for services not configured
+var ErrServiceNotFound = errors.New("service not configured")
+
+type staticRegistry struct {
+	*Config
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var _ registry.Registry = &staticRegistry{}
+
+func newStaticRegistry(config *Config) *staticRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &staticRegistry{
+		Config: config,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// RegisterService is a no-op, services are configured statically
+func (reg *staticRegistry) RegisterService(context.Context, *server.ServiceInfo) error {
+	return nil
+}
+
+// UnregisterService is a no-op, services are configured statically
+func (reg *staticRegistry) UnregisterService(context.Context, *server.ServiceInfo) error {
+	return nil
+}
+
+// ListServices resolves endpoints of service name serving scheme
+func (reg *staticRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	service, ok := reg.Services[name]
+	if !ok {
+		return nil, errors.Wrap(ErrServiceNotFound, name)
+	}
+	infos, err := reg.resolve(ctx, name, scheme, service)
+	if err != nil {
+		return nil, err
+	}
+	var services = make([]*server.ServiceInfo, 0, len(infos))
+	for _, info := range infos {
+		info := info
+		services = append(services, &info)
+	}
+	return registry.FilterServices(ctx, services)
+}
+
+// WatchServices resolves endpoints of service name serving scheme every
+// RefreshInterval, endpoints are kept if resolving fails. Only nodes are
+// watched, route and provider configs are not supported.
+func (reg *staticRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	store, err := reg.WatchSnapshots(ctx, name, scheme)
+	if err != nil {
+		return nil, err
+	}
+	return registry.StreamEndpoints(ctx, store), nil
+}
+
+// WatchSnapshots resolves endpoints of service name serving scheme into a
+// store every RefreshInterval, the store is closed once ctx is done or the
+// registry is closed
+func (reg *staticRegistry) WatchSnapshots(ctx context.Context, name string, scheme string) (*registry.EndpointsStore, error) {
+	if _, err := registry.LabelSelectorFromContext(ctx); err != nil {
+		return nil, err
+	}
+	service, ok := reg.Services[name]
+	if !ok {
+		return nil, errors.Wrap(ErrServiceNotFound, name)
+	}
+	infos, err := reg.resolve(ctx, name, scheme, service)
+	if err != nil {
+		return nil, err
+	}
+
+	var store = registry.NewEndpointsStore()
+	store.Update(func(tx *registry.EndpointsTx) {
+		updateNodes(tx, nil, infos)
+	})
+	if !service.dynamic() {
+		return registry.FilterStore(ctx, store)
+	}
+
+	xgo.Go(func() {
+		defer store.Close()
+		ticker := reg.clock.NewTicker(reg.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reg.ctx.Done():
+				return
+			case <-ticker.C():
+			}
+			newInfos, err := reg.resolve(ctx, name, scheme, service)
+			if err != nil {
+				if ctx.Err() == nil {
+					reg.logger.Error("resolve services", xlog.FieldErr(err), xlog.String("name", name), xlog.String("scheme", scheme))
+				}
+				continue
+			}
+			if reflect.DeepEqual(newInfos, infos) {
+				continue
+			}
+			infos = newInfos
+
+			// 基于上一版本生成新快照, 未变更的部分共享
+			store.Update(func(tx *registry.EndpointsTx) {
+				updateNodes(tx, tx.PrevNodes(), infos)
+			})
+		}
+	})
+	return registry.FilterStore(ctx, store)
+}
+
+// Close stops watches
+func (reg *staticRegistry) Close() error {
+	reg.cancel()
+	return nil
+}
+
+// updateNodes replaces nodes of tx with infos
+func updateNodes(tx *registry.EndpointsTx, prev *registry.Nodes, infos map[string]server.ServiceInfo) {
+	for addr, info := range infos {
+		tx.SetNode(addr, info)
+	}
+	if prev == nil {
+		return
+	}
+	prev.Range(func(addr string, _ server.ServiceInfo) bool {
+		if _, ok := infos[addr]; !ok {
+			tx.DeleteNode(addr)
+		}
+		return true
+	})
+}
+
+// dynamic reports whether endpoints of service are resolved from dns
+func (service Service) dynamic() bool {
+	if service.SRV != "" {
+		return true
+	}
+	for _, addr := range service.Addresses {
+		if host, _, err := net.SplitHostPort(addr); err != nil || net.ParseIP(host) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns endpoints of service serving scheme keyed by label, it
+// fails if any lookup fails so that partial results don't drop endpoints
+func (reg *staticRegistry) resolve(ctx context.Context, name, scheme string, service Service) (map[string]server.ServiceInfo, error) {
+	var infos = make(map[string]server.ServiceInfo)
+	if service.Scheme != "" && scheme != "" && service.Scheme != scheme {
+		return infos, nil
+	}
+	var add = func(ips []string, port string, weight float64) {
+		for _, ip := range ips {
+			info := reg.newInfo(name, scheme, service, net.JoinHostPort(ip, port), weight)
+			infos[info.Label()] = info
+		}
+	}
+	for _, addr := range service.Addresses {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		ips, err := reg.lookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		add(ips, port, service.Weight)
+	}
+	if service.SRV == "" {
+		return infos, nil
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, reg.Timeout)
+	_, records, err := reg.resolver.LookupSRV(lookupCtx, "", "", service.SRV)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		ips, err := reg.lookupHost(ctx, strings.TrimSuffix(record.Target, "."))
+		if err != nil {
+			return nil, err
+		}
+		var weight = service.Weight
+		if record.Weight > 0 {
+			weight = float64(record.Weight)
+		}
+		add(ips, strconv.Itoa(int(record.Port)), weight)
+	}
+	return infos, nil
+}
+
+// lookupHost returns host itself if it's an ip
+func (reg *staticRegistry) lookupHost(ctx context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, reg.Timeout)
+	defer cancel()
+	return reg.resolver.LookupHost(ctx, host)
+}
+
+func (reg *staticRegistry) newInfo(name, scheme string, service Service, addr string, weight float64) server.ServiceInfo {
+	if weight == 0 {
+		weight = 100
+	}
+	if scheme == "" {
+		scheme = service.Scheme
+	}
+	var metadata = make(map[string]string, len(service.Metadata))
+	for k, v := range service.Metadata {
+		metadata[k] = v
+	}
+	var labels map[string]string
+	if len(service.Labels) > 0 {
+		labels = make(map[string]string, len(service.Labels))
+		for k, v := range service.Labels {
+			labels[k] = v
+		}
+	}
+	return server.ServiceInfo{
+		Name:     name,
+		Scheme:   scheme,
+		Address:  addr,
+		Weight:   weight,
+		Enable:   true,
+		Healthy:  true,
+		Zone:     service.Zone,
+		Kind:     constant.ServiceProvider,
+		Metadata: metadata,
+		Labels:   labels,
+	}
+}
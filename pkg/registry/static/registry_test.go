@@ -0,0 +1,174 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package static
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/registry"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeResolver struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+	err   error
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.hosts[host], nil
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return name, r.srvs[name], nil
+}
+
+func (r *fakeResolver) set(fn func()) {
+	r.mu.Lock()
+	fn()
+	r.mu.Unlock()
+}
+
+func newTestRegistry(resolver *fakeResolver, clock xtime.Clock) *staticRegistry {
+	config := DefaultConfig()
+	config.Services = map[string]Service{
+		"static": {Addresses: []string{"10.0.0.1:9090", "10.0.0.2:9090"}, Scheme: "grpc", Zone: "z1", Metadata: map[string]string{"env": "test"}},
+		"dns":    {Addresses: []string{"demo.local:8080"}},
+		"srv":    {SRV: "_grpc._tcp.demo.local", Weight: 50},
+	}
+	config.clock = clock
+	config.resolver = resolver
+	return newStaticRegistry(config)
+}
+
+func addresses(services []*server.ServiceInfo) []string {
+	var ret = make([]string, 0, len(services))
+	for _, service := range services {
+		ret = append(ret, service.Address)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func nodes(endpoints registry.Endpoints) map[string]float64 {
+	var ret = make(map[string]float64)
+	endpoints.Nodes.Range(func(_ string, info server.ServiceInfo) bool {
+		ret[info.Address] = info.Weight
+		return true
+	})
+	return ret
+}
+
+func TestListServices(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]string{"demo.local": {"10.0.1.1", "10.0.1.2"}, "a.demo.local": {"10.0.2.1"}},
+		srvs:  map[string][]*net.SRV{"_grpc._tcp.demo.local": {{Target: "a.demo.local.", Port: 9091, Weight: 10}, {Target: "10.0.2.2", Port: 9092}}},
+	}
+	reg := newTestRegistry(resolver, xtime.SystemClock)
+	defer reg.Close()
+
+	services, err := reg.ListServices(context.Background(), "static", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.0.1:9090", "10.0.0.2:9090"}, addresses(services))
+	assert.Equal(t, "static", services[0].Name)
+	assert.Equal(t, "grpc", services[0].Scheme)
+	assert.Equal(t, "z1", services[0].Zone)
+	assert.Equal(t, float64(100), services[0].Weight)
+	assert.Equal(t, map[string]string{"env": "test"}, services[0].Metadata)
+
+	// endpoints of other schemes
+	services, err = reg.ListServices(context.Background(), "static", "http")
+	assert.Nil(t, err)
+	assert.Empty(t, services)
+
+	services, err = reg.ListServices(context.Background(), "dns", "http")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.1.1:8080", "10.0.1.2:8080"}, addresses(services))
+
+	services, err = reg.ListServices(context.Background(), "srv", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"10.0.2.1:9091", "10.0.2.2:9092"}, addresses(services))
+
+	_, err = reg.ListServices(context.Background(), "unknown", "grpc")
+	assert.Equal(t, ErrServiceNotFound, pkgerrors.Cause(err))
+
+	// label selectors
+	services, err = reg.ListServices(registry.WithLabelSelector(context.Background(), "zone=z1"), "static", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, services, 2)
+	services, err = reg.ListServices(registry.WithLabelSelector(context.Background(), "zone=z2"), "static", "grpc")
+	assert.Nil(t, err)
+	assert.Empty(t, services)
+	_, err = reg.ListServices(registry.WithLabelSelector(context.Background(), "zone=z1=z2"), "static", "grpc")
+	assert.NotNil(t, err)
+}
+
+func TestWatchServices(t *testing.T) {
+	resolver := &fakeResolver{
+		srvs: map[string][]*net.SRV{"_grpc._tcp.demo.local": {{Target: "10.0.2.1", Port: 9091, Weight: 10}}},
+	}
+	clock := xtime.NewMockClock(time.Now())
+	reg := newTestRegistry(resolver, clock)
+	defer reg.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := reg.WatchServices(ctx, "srv", "grpc")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"10.0.2.1:9091": 10}, nodes(<-ch))
+
+	resolver.set(func() {
+		resolver.srvs["_grpc._tcp.demo.local"] = append(resolver.srvs["_grpc._tcp.demo.local"], &net.SRV{Target: "10.0.2.2", Port: 9092})
+	})
+	clock.BlockUntil(1)
+	clock.Advance(reg.RefreshInterval)
+	assert.Equal(t, map[string]float64{"10.0.2.1:9091": 10, "10.0.2.2:9092": 50}, nodes(<-ch))
+
+	// endpoints are kept if resolving fails
+	resolver.set(func() { resolver.err = errors.New("no such host") })
+	clock.Advance(reg.RefreshInterval)
+	resolver.set(func() {
+		resolver.err = nil
+		resolver.srvs["_grpc._tcp.demo.local"] = resolver.srvs["_grpc._tcp.demo.local"][1:]
+	})
+	clock.Advance(reg.RefreshInterval)
+	assert.Equal(t, map[string]float64{"10.0.2.2:9092": 50}, nodes(<-ch))
+	assert.Len(t, ch, 0)
+
+	// static services are not refreshed
+	ch, err = reg.WatchServices(ctx, "static", "grpc")
+	assert.Nil(t, err)
+	assert.Len(t, nodes(<-ch), 2)
+}
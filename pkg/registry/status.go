@@ -0,0 +1,88 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/douyu/jupiter/pkg/server"
+)
+
+type statusesKey struct{}
+
+// WithStatuses returns a context with which ListServices and WatchServices
+// of registries return services of statuses, or of any status if none is
+// given. Only UP services are returned by default.
+func WithStatuses(ctx context.Context, statuses ...string) context.Context {
+	return context.WithValue(ctx, statusesKey{}, statuses)
+}
+
+// ServiceFilter matches services with the label selector and statuses of a
+// context
+type ServiceFilter struct {
+	selector LabelSelector
+	// statuses is nil if only available services are matched
+	statuses map[string]bool
+}
+
+// ServiceFilterFromContext returns the filter of ctx, see WithLabelSelector
+// and WithStatuses
+func ServiceFilterFromContext(ctx context.Context) (ServiceFilter, error) {
+	selector, err := LabelSelectorFromContext(ctx)
+	if err != nil {
+		return ServiceFilter{}, err
+	}
+	var filter = ServiceFilter{selector: selector}
+	if statuses, ok := ctx.Value(statusesKey{}).([]string); ok {
+		filter.statuses = make(map[string]bool, len(statuses))
+		for _, status := range statuses {
+			filter.statuses[status] = true
+		}
+	}
+	return filter, nil
+}
+
+// Matches reports whether info matches the selector and statuses of f
+func (f ServiceFilter) Matches(info server.ServiceInfo) bool {
+	switch {
+	case f.statuses == nil:
+		if !info.Available() {
+			return false
+		}
+	case len(f.statuses) > 0:
+		status := info.Status
+		if status == "" {
+			status = server.StatusUp
+		}
+		if !f.statuses[status] {
+			return false
+		}
+	}
+	return f.selector.MatchesService(info)
+}
+
+// SetStatus registers info again with status, e.g. DOWN pulls the instance
+// out of rotation of consumers without stopping it. info is not modified.
+func SetStatus(ctx context.Context, reg Registry, info *server.ServiceInfo, status string) error {
+	switch status {
+	case server.StatusUp, server.StatusDown, server.StatusMaintenance:
+	default:
+		return fmt.Errorf("invalid status: %q", status)
+	}
+	var copied = *info
+	copied.Status = status
+	return reg.RegisterService(ctx, &copied)
+}
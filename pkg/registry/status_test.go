@@ -0,0 +1,72 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceFilter_Matches(t *testing.T) {
+	up := server.ServiceInfo{Address: "127.0.0.1:1", Region: "bj"}
+	down := server.ServiceInfo{Address: "127.0.0.1:2", Region: "bj", Status: server.StatusDown}
+	maintenance := server.ServiceInfo{Address: "127.0.0.1:3", Region: "sh", Status: server.StatusMaintenance}
+
+	filter, err := ServiceFilterFromContext(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, filter.Matches(up))
+	assert.False(t, filter.Matches(down))
+	assert.False(t, filter.Matches(maintenance))
+
+	filter, _ = ServiceFilterFromContext(WithStatuses(context.Background()))
+	assert.True(t, filter.Matches(up))
+	assert.True(t, filter.Matches(down))
+	assert.True(t, filter.Matches(maintenance))
+
+	ctx := WithStatuses(WithLabelSelector(context.Background(), "region=bj"), server.StatusUp, server.StatusDown)
+	filter, _ = ServiceFilterFromContext(ctx)
+	assert.True(t, filter.Matches(up))
+	assert.True(t, filter.Matches(down))
+	assert.False(t, filter.Matches(maintenance))
+
+	_, err = ServiceFilterFromContext(WithLabelSelector(context.Background(), "=bj"))
+	assert.NotNil(t, err)
+}
+
+func TestFilterServices_Status(t *testing.T) {
+	services := []*server.ServiceInfo{
+		{Address: "127.0.0.1:1"},
+		{Address: "127.0.0.1:2", Status: server.StatusDown},
+	}
+	ret, err := FilterServices(context.Background(), services)
+	assert.Nil(t, err)
+	assert.Equal(t, services[:1], ret)
+}
+
+func TestSetStatus(t *testing.T) {
+	reg := newFakeRegistry()
+	info := &server.ServiceInfo{Name: "svc", Address: "127.0.0.1:1"}
+
+	assert.NotNil(t, SetStatus(context.Background(), reg, info, "OFF"))
+	assert.Empty(t, reg.registered)
+
+	assert.Nil(t, SetStatus(context.Background(), reg, info, server.StatusDown))
+	assert.Len(t, reg.registered, 1)
+	assert.Equal(t, server.StatusDown, reg.registered[0].Status)
+	assert.Equal(t, "", info.Status)
+}
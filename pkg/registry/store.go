@@ -0,0 +1,198 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/util/xgo"
+)
+
+var closedChan = make(chan struct{})
+
+func init() {
+	close(closedChan)
+}
+
+// EndpointsStore keeps the latest endpoints snapshot of a watched service
+// with a version increased on each change. Writers never block nor drop
+// updates, readers get the latest snapshot once Changed fires, so slow
+// readers skip intermediate versions but never miss the final one.
+type EndpointsStore struct {
+	mu      sync.RWMutex
+	latest  Endpoints
+	version uint64
+	// resyncs counts versions set with Resync, so that readers skipping
+	// them still tell a resync happened
+	resyncs uint64
+	changed chan struct{}
+	closed  bool
+}
+
+// NewEndpointsStore returns an empty store of version 0
+func NewEndpointsStore() *EndpointsStore {
+	return &EndpointsStore{
+		latest: Endpoints{
+			RouteConfigs:    make(map[string]RouteConfig),
+			ConsumerConfigs: make(map[string]ConsumerConfig),
+			ProviderConfigs: make(map[string]ProviderConfig),
+		},
+		changed: make(chan struct{}),
+	}
+}
+
+// Latest returns the latest snapshot and its version
+func (s *EndpointsStore) Latest() (Endpoints, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latest, s.version
+}
+
+// follow returns the latest snapshot and its version, Resync is set if any
+// version set since the one of *resyncs was a resync
+func (s *EndpointsStore) follow(resyncs *uint64) (Endpoints, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	latest := s.latest
+	if s.resyncs != *resyncs {
+		latest.Resync = true
+		*resyncs = s.resyncs
+	}
+	return latest, s.version
+}
+
+// Changed returns a channel closed once the version is beyond version or
+// the store is closed, i.e. Latest returns version again
+func (s *EndpointsStore) Changed(version uint64) <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.version == version {
+		return s.changed
+	}
+	return closedChan
+}
+
+// Set replaces the latest snapshot, it's ignored once the store is closed
+func (s *EndpointsStore) Set(endpoints Endpoints) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(endpoints)
+}
+
+// Update replaces the latest snapshot with the one derived by fn, see
+// Endpoints.Update, and returns it
+func (s *EndpointsStore) Update(fn func(tx *EndpointsTx)) Endpoints {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(s.latest.Update(fn))
+	return s.latest
+}
+
+func (s *EndpointsStore) set(endpoints Endpoints) {
+	if s.closed {
+		return
+	}
+	s.latest = endpoints
+	s.version++
+	if endpoints.Resync {
+		s.resyncs++
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Close stops the store, readers wake up with the final version
+func (s *EndpointsStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.changed)
+}
+
+// SnapshotWatcher is implemented by registries which keep endpoints of
+// watched services in stores, the store is closed once ctx is done or
+// watching stops.
+type SnapshotWatcher interface {
+	WatchSnapshots(ctx context.Context, name string, scheme string) (*EndpointsStore, error)
+}
+
+// WatchSnapshots watches endpoints of a service into a store, versions sent
+// by WatchServices are stored if reg doesn't implement SnapshotWatcher
+func WatchSnapshots(ctx context.Context, reg Registry, name string, scheme string) (*EndpointsStore, error) {
+	if watcher, ok := reg.(SnapshotWatcher); ok {
+		return watcher.WatchSnapshots(ctx, name, scheme)
+	}
+	watch, err := reg.WatchServices(ctx, name, scheme)
+	if err != nil {
+		return nil, err
+	}
+	var store = NewEndpointsStore()
+	xgo.Go(func() {
+		defer store.Close()
+		for {
+			select {
+			case endpoints, ok := <-watch:
+				if !ok {
+					return
+				}
+				store.Set(endpoints)
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return store, nil
+}
+
+// StreamEndpoints returns a channel of versions of store for WatchServices.
+// The latest version is sent if the receiver is behind, and the final one
+// is always sent before the channel is closed with the store. The current
+// version is sent before it returns unless the store is empty. Resync is
+// set on the version sent after any skipped resync.
+func StreamEndpoints(ctx context.Context, store *EndpointsStore) chan Endpoints {
+	var addresses = make(chan Endpoints, 1)
+	var resyncs uint64
+	endpoints, version := store.follow(&resyncs)
+	if version > 0 {
+		addresses <- endpoints
+	}
+	xgo.Go(func() {
+		defer close(addresses)
+		for {
+			select {
+			case <-store.Changed(version):
+			case <-ctx.Done():
+				return
+			}
+			var next uint64
+			endpoints, next = store.follow(&resyncs)
+			if next == version {
+				// closed
+				return
+			}
+			version = next
+			select {
+			case addresses <- endpoints:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return addresses
+}
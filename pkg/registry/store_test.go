@@ -0,0 +1,172 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointsStore(t *testing.T) {
+	store := NewEndpointsStore()
+	_, version := store.Latest()
+	assert.Equal(t, uint64(0), version)
+	changed := store.Changed(0)
+
+	store.Update(func(tx *EndpointsTx) {
+		tx.SetNode("127.0.0.1:9091", server.ServiceInfo{Address: "127.0.0.1:9091"})
+	})
+	assertClosed(t, changed)
+	endpoints, version := store.Latest()
+	assert.Equal(t, uint64(1), version)
+	assert.Equal(t, 1, endpoints.Nodes.Len())
+	assertClosed(t, store.Changed(0))
+
+	changed = store.Changed(version)
+	select {
+	case <-changed:
+		t.Fatal("changed without update")
+	default:
+	}
+	store.Close()
+	assertClosed(t, changed)
+	store.Set(Endpoints{})
+	_, version = store.Latest()
+	assert.Equal(t, uint64(1), version)
+}
+
+func TestStreamEndpoints(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewEndpointsStore()
+	store.Update(func(tx *EndpointsTx) {
+		tx.SetNode("127.0.0.1:0", server.ServiceInfo{Address: "127.0.0.1:0"})
+	})
+	watch := StreamEndpoints(ctx, store)
+	// the current version is sent before returning
+	assert.Len(t, watch, 1)
+
+	// updates are never dropped nor block the writer, slow readers get the latest
+	for i := 1; i <= 100; i++ {
+		addr := "127.0.0.1:" + strconv.Itoa(i)
+		store.Update(func(tx *EndpointsTx) {
+			tx.SetNode(addr, server.ServiceInfo{Address: addr})
+		})
+	}
+	store.Close()
+
+	var last Endpoints
+	for endpoints := range watch {
+		last = endpoints
+	}
+	assert.Equal(t, 101, last.Nodes.Len())
+}
+
+func TestStreamEndpoints_Resync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewEndpointsStore()
+	store.Update(func(tx *EndpointsTx) {})
+	watch := StreamEndpoints(ctx, store)
+	assert.False(t, (<-watch).Resync)
+
+	// the resync is skipped by the reader, the next version read tells it
+	resynced := NewEndpointsStore().Update(func(tx *EndpointsTx) {
+		tx.SetNode("127.0.0.1:9091", server.ServiceInfo{Address: "127.0.0.1:9091"})
+	})
+	resynced.Resync = true
+	store.Set(resynced)
+	endpoints := store.Update(func(tx *EndpointsTx) {
+		tx.SetNode("127.0.0.1:9092", server.ServiceInfo{Address: "127.0.0.1:9092"})
+	})
+	assert.False(t, endpoints.Resync)
+	store.Close()
+
+	var last Endpoints
+	for endpoints := range watch {
+		last = endpoints
+	}
+	assert.True(t, last.Resync)
+	assert.Equal(t, 2, last.Nodes.Len())
+}
+
+func TestWatchSnapshots(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch := make(chan Endpoints, 1)
+	store, err := WatchSnapshots(ctx, watchOnly(watch), "demo", "grpc")
+	assert.NoError(t, err)
+
+	watch <- Endpoints{Nodes: NewNodes(map[string]server.ServiceInfo{"127.0.0.1:9091": {}})}
+	<-store.Changed(0)
+	endpoints, version := store.Latest()
+	assert.Equal(t, uint64(1), version)
+	assert.Equal(t, 1, endpoints.Nodes.Len())
+
+	close(watch)
+	assertClosed(t, store.Changed(version))
+	_, version = store.Latest()
+	assert.Equal(t, uint64(1), version)
+}
+
+func TestFilterStore(t *testing.T) {
+	ctx := WithLabelSelector(context.Background(), "region=bj")
+	store := NewEndpointsStore()
+	store.Update(func(tx *EndpointsTx) {
+		tx.SetNode("127.0.0.1:9091", server.ServiceInfo{Address: "127.0.0.1:9091", Labels: map[string]string{"region": "bj"}})
+		tx.SetNode("127.0.0.1:9092", server.ServiceInfo{Address: "127.0.0.1:9092", Labels: map[string]string{"region": "sh"}})
+	})
+	filtered, err := FilterStore(ctx, store)
+	assert.NoError(t, err)
+	endpoints, version := filtered.Latest()
+	assert.Equal(t, 1, endpoints.Nodes.Len())
+
+	store.Update(func(tx *EndpointsTx) {
+		tx.DeleteNode("127.0.0.1:9091")
+	})
+	<-filtered.Changed(version)
+	endpoints, version = filtered.Latest()
+	assert.Equal(t, 0, endpoints.Nodes.Len())
+
+	store.Close()
+	assertClosed(t, filtered.Changed(version))
+}
+
+func assertClosed(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("not closed")
+	}
+}
+
+// watchOnly is a registry watching endpoints of ch
+type watchOnly chan Endpoints
+
+func (w watchOnly) RegisterService(context.Context, *server.ServiceInfo) error   { return nil }
+func (w watchOnly) UnregisterService(context.Context, *server.ServiceInfo) error { return nil }
+func (w watchOnly) ListServices(context.Context, string, string) ([]*server.ServiceInfo, error) {
+	return nil, nil
+}
+func (w watchOnly) WatchServices(context.Context, string, string) (chan Endpoints, error) {
+	return w, nil
+}
+func (w watchOnly) Close() error { return nil }
@@ -18,15 +18,24 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"sync"
 
 	sentinel "github.com/alibaba/sentinel-golang/api"
+	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
 	"github.com/alibaba/sentinel-golang/core/flow"
 	"github.com/douyu/jupiter/pkg"
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/constant"
+	"github.com/douyu/jupiter/pkg/server/degrade"
+	"github.com/douyu/jupiter/pkg/util/xtime"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 )
 
+func init() {
+	xschema.RegisterConfig("sentinel", "jupiter.reliability.sentinel", "sentinel flow control", DefaultConfig)
+}
+
 // RawConfig ...
 func RawConfig(key string) *Config {
 	var config = DefaultConfig()
@@ -42,6 +51,15 @@ type Config struct {
 	LogPath       string           `json:"logPath"`
 	FlowRules     []*flow.FlowRule `json:"rules"`
 	FlowRulesFile string           `json:"flowRulesFile"`
+	// BreakerDegradeLevel is the degrade level raised by open breakers,
+	// "none" disables it
+	BreakerDegradeLevel string `json:"breakerDegradeLevel"`
+	// OverloadDegradeLevel is the degrade level raised while system rules
+	// block requests, "none" disables it
+	OverloadDegradeLevel string `json:"overloadDegradeLevel"`
+
+	// clock times the quiet period of overload, replaced in tests
+	clock xtime.Clock
 }
 
 // DefaultConfig returns default config for sentinel
@@ -50,7 +68,39 @@ func DefaultConfig() *Config {
 		AppName:   pkg.Name(),
 		LogPath:   "/tmp/log",
 		FlowRules: make([]*flow.FlowRule, 0),
+
+		BreakerDegradeLevel:  "partial",
+		OverloadDegradeLevel: "partial",
+		clock:                xtime.SystemClock,
+	}
+}
+
+var degradeOnce sync.Once
+
+// initDegrade raises degrade levels on open breakers and overload, once
+// per process since listeners and slots of sentinel are global
+func (config *Config) initDegrade() error {
+	breakerLevel, err := degrade.ParseLevel(config.BreakerDegradeLevel)
+	if err != nil {
+		return err
+	}
+	overloadLevel, err := degrade.ParseLevel(config.OverloadDegradeLevel)
+	if err != nil {
+		return err
 	}
+	clock := config.clock
+	if clock == nil {
+		clock = xtime.SystemClock
+	}
+	degradeOnce.Do(func() {
+		if breakerLevel != degrade.LevelNone {
+			circuitbreaker.RegisterStateChangeListeners(breakerListener{level: breakerLevel})
+		}
+		if overloadLevel != degrade.LevelNone {
+			sentinel.GlobalSlotChain().AddStatSlotLast(&overloadSlot{level: overloadLevel, clock: clock})
+		}
+	})
+	return nil
 }
 
 // InitSentinelCoreComponent init sentinel core component
@@ -79,5 +129,9 @@ func (config *Config) InitSentinelCoreComponent() error {
 		_, _ = flow.LoadRules(config.FlowRules)
 	}
 
+	if err := config.initDegrade(); err != nil {
+		return err
+	}
+
 	return sentinel.InitDefault()
 }
@@ -0,0 +1,99 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sentinel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
+	"github.com/douyu/jupiter/pkg/server/degrade"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+)
+
+// sourceOverload is the degrade source of system rules blocking requests
+const sourceOverload = "overload"
+
+// overloadQuiet is how long no request is blocked by system rules before
+// the overload is over
+const overloadQuiet = 10 * time.Second
+
+// breakerListener raises the degradation level of "breaker:<resource>"
+// while the breaker of the resource is not closed
+type breakerListener struct {
+	level degrade.Level
+}
+
+func breakerSource(rule circuitbreaker.Rule) string {
+	return "breaker:" + rule.ResourceName()
+}
+
+// OnTransformToClosed ...
+func (l breakerListener) OnTransformToClosed(_ circuitbreaker.State, rule circuitbreaker.Rule) {
+	degrade.Set(breakerSource(rule), degrade.LevelNone)
+}
+
+// OnTransformToOpen ...
+func (l breakerListener) OnTransformToOpen(_ circuitbreaker.State, rule circuitbreaker.Rule, _ interface{}) {
+	degrade.Set(breakerSource(rule), l.level)
+}
+
+// OnTransformToHalfOpen keeps the level until probes close the breaker
+func (l breakerListener) OnTransformToHalfOpen(circuitbreaker.State, circuitbreaker.Rule) {}
+
+// overloadSlot raises the degradation level of "overload" while system
+// rules block requests, i.e. the application is overloaded, until no
+// request is blocked by them for overloadQuiet
+type overloadSlot struct {
+	level degrade.Level
+	clock xtime.Clock
+
+	mu      sync.Mutex
+	active  bool
+	blocked time.Time
+}
+
+// OnEntryPassed ...
+func (s *overloadSlot) OnEntryPassed(*base.EntryContext) {}
+
+// OnEntryBlocked ...
+func (s *overloadSlot) OnEntryBlocked(_ *base.EntryContext, blockError *base.BlockError) {
+	if blockError.BlockType() != base.BlockTypeSystemFlow {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked = s.clock.Now()
+	if !s.active {
+		s.active = true
+		degrade.Set(sourceOverload, s.level)
+		s.clock.AfterFunc(overloadQuiet, s.expire)
+	}
+}
+
+// OnCompleted ...
+func (s *overloadSlot) OnCompleted(*base.EntryContext) {}
+
+func (s *overloadSlot) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if quiet := s.clock.Since(s.blocked); quiet < overloadQuiet {
+		s.clock.AfterFunc(overloadQuiet-quiet, s.expire)
+		return
+	}
+	s.active = false
+	degrade.Set(sourceOverload, degrade.LevelNone)
+}
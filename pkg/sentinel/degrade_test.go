@@ -0,0 +1,58 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sentinel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alibaba/sentinel-golang/core/base"
+	"github.com/alibaba/sentinel-golang/core/circuitbreaker"
+	"github.com/douyu/jupiter/pkg/server/degrade"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerListener(t *testing.T) {
+	rule := circuitbreaker.NewErrorCountRule("redis", 1000, 1000, 10, 5)
+	listener := breakerListener{level: degrade.LevelPartial}
+
+	listener.OnTransformToOpen(circuitbreaker.Closed, rule, nil)
+	assert.Equal(t, degrade.LevelPartial, degrade.Sources()["breaker:redis"])
+	listener.OnTransformToHalfOpen(circuitbreaker.Open, rule)
+	assert.Equal(t, degrade.LevelPartial, degrade.Sources()["breaker:redis"])
+	listener.OnTransformToClosed(circuitbreaker.HalfOpen, rule)
+	assert.NotContains(t, degrade.Sources(), "breaker:redis")
+}
+
+func TestOverloadSlot(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	slot := &overloadSlot{level: degrade.LevelSevere, clock: clock}
+
+	slot.OnEntryBlocked(nil, base.NewBlockError(base.BlockTypeFlow, "flow"))
+	assert.NotContains(t, degrade.Sources(), sourceOverload)
+
+	slot.OnEntryBlocked(nil, base.NewBlockError(base.BlockTypeSystemFlow, "load"))
+	assert.Equal(t, degrade.LevelSevere, degrade.Sources()[sourceOverload])
+
+	// blocked again before the quiet period is over
+	clock.Advance(overloadQuiet / 2)
+	slot.OnEntryBlocked(nil, base.NewBlockError(base.BlockTypeSystemFlow, "load"))
+	clock.Advance(overloadQuiet / 2)
+	assert.Equal(t, degrade.LevelSevere, degrade.Sources()[sourceOverload])
+
+	clock.Advance(overloadQuiet / 2)
+	assert.NotContains(t, degrade.Sources(), sourceOverload)
+}
@@ -0,0 +1,348 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apikey authenticates requests of external-facing services with api
+// keys declared in config or looked up from a store, and limits the rate and
+// quota of each key.
+package apikey
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// ErrMissingKey is returned for requests without keys
+	ErrMissingKey = errors.New("api key missing")
+	// ErrInvalidKey is returned for unknown keys
+	ErrInvalidKey = errors.New("api key invalid")
+	// ErrKeyDisabled is returned for disabled keys
+	ErrKeyDisabled = errors.New("api key disabled")
+	// ErrKeyExpired is returned for expired keys
+	ErrKeyExpired = errors.New("api key expired")
+)
+
+var requestCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "apikey_request_total",
+	Labels:    []string{"name", "key", "result"},
+}.Build()
+
+type contextKey struct{}
+
+// NewContext returns a context carrying key
+func NewContext(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key authenticated by Handler
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
+
+type cacheEntry struct {
+	key    *Key
+	err    error
+	expire time.Time
+}
+
+// Manager authenticates and limits api keys
+type Manager struct {
+	config *Config
+	static staticStore
+	group  singleflight.Group
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+	// misses caches unknown keys apart from cache, so that random keys
+	// can't evict the valid ones
+	misses   map[string]cacheEntry
+	limiters map[string]*limiter
+}
+
+func newManager(config *Config) *Manager {
+	m := &Manager{
+		config:   config,
+		static:   newStaticStore(config.Keys),
+		cache:    make(map[string]cacheEntry),
+		misses:   make(map[string]cacheEntry),
+		limiters: make(map[string]*limiter),
+	}
+	managers.Store(config.Name, m)
+	return m
+}
+
+// Authenticate returns the key of plain, which is valid and enabled
+func (m *Manager) Authenticate(ctx context.Context, plain string) (*Key, error) {
+	if plain == "" {
+		return nil, ErrMissingKey
+	}
+	key, err := m.lookup(ctx, HashKey(plain))
+	if err == ErrKeyNotFound {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "lookup api key")
+	}
+	if key.Disabled {
+		return key, ErrKeyDisabled
+	}
+	if !key.ExpiresAt.IsZero() && !m.config.clock.Now().Before(key.ExpiresAt) {
+		return key, ErrKeyExpired
+	}
+	return key, nil
+}
+
+// Invalidate drops the cached key of plain, e.g. once it's revoked in store
+func (m *Manager) Invalidate(plain string) {
+	hash := HashKey(plain)
+	m.mu.Lock()
+	delete(m.cache, hash)
+	delete(m.misses, hash)
+	m.mu.Unlock()
+}
+
+func (m *Manager) lookup(ctx context.Context, hash string) (*Key, error) {
+	if key, err := m.static.Lookup(ctx, hash); err == nil || m.config.store == nil {
+		return key, err
+	}
+
+	now := m.config.clock.Now()
+	m.mu.Lock()
+	entry, ok := m.cache[hash]
+	if !ok {
+		entry, ok = m.misses[hash]
+	}
+	m.mu.Unlock()
+	if ok && now.Before(entry.expire) {
+		return entry.key, entry.err
+	}
+
+	v, err, _ := m.group.Do(hash, func() (interface{}, error) {
+		key, err := m.config.store.Lookup(ctx, hash)
+		switch err {
+		case nil:
+			m.remember(hash, cacheEntry{key: key, expire: now.Add(m.config.CacheTTL)})
+		case ErrKeyNotFound:
+			m.forget(hash, cacheEntry{err: err, expire: now.Add(m.config.NegativeTTL)})
+		}
+		return key, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Key), nil
+}
+
+// remember caches a valid key, evicting the one expiring first when full
+func (m *Manager) remember(hash string, entry cacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.misses, hash)
+	if _, ok := m.cache[hash]; !ok && len(m.cache) >= m.config.CacheSize {
+		m.purge(m.cache)
+		if len(m.cache) >= m.config.CacheSize {
+			var oldest string
+			for h, e := range m.cache {
+				if oldest == "" || e.expire.Before(m.cache[oldest].expire) {
+					oldest = h
+				}
+			}
+			delete(m.cache, oldest)
+		}
+	}
+	m.cache[hash] = entry
+}
+
+// forget caches an unknown key, never evicting valid ones
+func (m *Manager) forget(hash string, entry cacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.misses) >= m.config.CacheSize {
+		m.purge(m.misses)
+		// still full of live misses, start over rather than tracking recency
+		if len(m.misses) >= m.config.CacheSize {
+			m.misses = make(map[string]cacheEntry)
+		}
+	}
+	m.misses[hash] = entry
+}
+
+func (m *Manager) purge(cache map[string]cacheEntry) {
+	now := m.config.clock.Now()
+	for h, e := range cache {
+		if !now.Before(e.expire) {
+			delete(cache, h)
+		}
+	}
+}
+
+// Allow takes a request from the rate and quota of key
+func (m *Manager) Allow(key *Key) (Decision, error) {
+	m.mu.Lock()
+	l, ok := m.limiters[key.ID]
+	if !ok {
+		l = &limiter{usage: Usage{ID: key.ID, Name: key.Name}}
+		m.limiters[key.ID] = l
+	}
+	m.mu.Unlock()
+
+	var rate, burst, quota = m.config.Rate, m.config.Burst, m.config.Quota
+	if key.Rate > 0 {
+		rate, burst = key.Rate, key.Burst
+	}
+	if key.Quota > 0 {
+		quota = key.Quota
+	}
+	return l.allow(m.config.clock.Now(), rate, burst, quota, m.config.QuotaPeriod)
+}
+
+// Usages returns the usage of keys seen
+func (m *Manager) Usages() []Usage {
+	m.mu.Lock()
+	var limiters = make([]*limiter, 0, len(m.limiters))
+	for _, l := range m.limiters {
+		limiters = append(limiters, l)
+	}
+	m.mu.Unlock()
+
+	var ret = make([]Usage, 0, len(limiters))
+	for _, l := range limiters {
+		ret = append(ret, l.snapshot())
+	}
+	return ret
+}
+
+// Handler authenticates requests with keys in header or query, rejecting
+// missing or invalid ones with 401, disabled ones with 403 and those over
+// rate or quota with 429. The key is attached to request context, see FromContext.
+// Echo users can apply it with echo.WrapMiddleware.
+func (m *Manager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range m.config.Skip {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		key, err := m.Authenticate(r.Context(), m.extract(r))
+		if err != nil {
+			var id string
+			if key != nil {
+				id = key.ID
+			}
+			m.reject(w, id, err)
+			return
+		}
+
+		decision, err := m.Allow(key)
+		setHeaders(w.Header(), decision)
+		if err != nil {
+			m.reject(w, key.ID, err)
+			return
+		}
+		requestCounter.Inc(m.config.Name, key.ID, "ok")
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), key)))
+	})
+}
+
+func (m *Manager) extract(r *http.Request) string {
+	if plain := r.Header.Get(m.config.Header); plain != "" {
+		return plain
+	}
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "ApiKey ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	if m.config.Query != "" {
+		return r.URL.Query().Get(m.config.Query)
+	}
+	return ""
+}
+
+func (m *Manager) reject(w http.ResponseWriter, id string, err error) {
+	var code int
+	var result string
+	switch errors.Cause(err) {
+	case ErrMissingKey:
+		code, result = http.StatusUnauthorized, "missing"
+	case ErrInvalidKey:
+		code, result = http.StatusUnauthorized, "invalid"
+	case ErrKeyExpired:
+		code, result = http.StatusUnauthorized, "expired"
+	case ErrKeyDisabled:
+		code, result = http.StatusForbidden, "disabled"
+	case ErrRateLimited:
+		code, result = http.StatusTooManyRequests, "rate_limited"
+	case ErrQuotaExceeded:
+		code, result = http.StatusTooManyRequests, "quota_exceeded"
+	default:
+		m.config.logger.Error("authenticate api key", xlog.FieldErr(err))
+		code, result = http.StatusServiceUnavailable, "error"
+	}
+	requestCounter.Inc(m.config.Name, id, result)
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", "ApiKey")
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func setHeaders(header http.Header, decision Decision) {
+	if decision.Limit > 0 {
+		header.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+	}
+	if decision.QuotaLimit > 0 {
+		header.Set("X-Quota-Limit", strconv.FormatInt(decision.QuotaLimit, 10))
+		header.Set("X-Quota-Remaining", strconv.FormatInt(decision.QuotaRemaining, 10))
+		header.Set("X-Quota-Reset", strconv.FormatInt(decision.QuotaReset.Unix(), 10))
+	}
+	if decision.RetryAfter > 0 {
+		header.Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+	}
+}
+
+var managers sync.Map
+
+func init() {
+	// e.g. /debug/apikeys?name=openapi
+	governor.HandleFunc("/debug/apikeys", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var ret = make(map[string][]Usage)
+		managers.Range(func(key, value interface{}) bool {
+			if name := query.Get("name"); name == "" || name == key.(string) {
+				ret[key.(string)] = value.(*Manager).Usages()
+			}
+			return true
+		})
+		encoder := json.NewEncoder(w)
+		if query.Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(ret)
+	})
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	keys    map[string]*Key
+	lookups int32
+}
+
+func (s *fakeStore) Lookup(_ context.Context, hash string) (*Key, error) {
+	atomic.AddInt32(&s.lookups, 1)
+	if key, ok := s.keys[hash]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+func newTestManager(clock xtime.Clock, store Store) *Manager {
+	config := DefaultConfig()
+	config.Name = "test"
+	config.Query = "api_key"
+	config.Skip = []string{"/health"}
+	config.Keys = []Key{
+		{ID: "static", Key: "s3cret", Rate: 1, Burst: 2},
+		{ID: "off", Key: "off", Disabled: true},
+	}
+	config.Quota = 3
+	config.clock = clock
+	return config.WithStore(store).Build()
+}
+
+func TestManager_Authenticate(t *testing.T) {
+	clock := xtime.NewMockClock(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	store := &fakeStore{keys: map[string]*Key{
+		HashKey("db"):  {ID: "db"},
+		HashKey("old"): {ID: "old", ExpiresAt: clock.Now().Add(time.Hour)},
+	}}
+	m := newTestManager(clock, store)
+	ctx := context.Background()
+
+	key, err := m.Authenticate(ctx, "s3cret")
+	assert.Nil(t, err)
+	assert.Equal(t, "static", key.ID)
+	assert.Empty(t, key.Key, "plain key dropped")
+
+	_, err = m.Authenticate(ctx, "")
+	assert.Equal(t, ErrMissingKey, err)
+	_, err = m.Authenticate(ctx, "off")
+	assert.Equal(t, ErrKeyDisabled, err)
+
+	for i := 0; i < 3; i++ {
+		key, err = m.Authenticate(ctx, "db")
+		assert.Nil(t, err)
+		assert.Equal(t, "db", key.ID)
+		_, err = m.Authenticate(ctx, "unknown")
+		assert.Equal(t, ErrInvalidKey, err)
+	}
+	assert.EqualValues(t, 2, atomic.LoadInt32(&store.lookups), "cached")
+
+	clock.Advance(time.Hour)
+	_, err = m.Authenticate(ctx, "old")
+	assert.Equal(t, ErrKeyExpired, err)
+	_, err = m.Authenticate(ctx, "unknown")
+	assert.Equal(t, ErrInvalidKey, err)
+	assert.EqualValues(t, 4, atomic.LoadInt32(&store.lookups), "negative entry expired")
+}
+
+func TestManager_CacheSize(t *testing.T) {
+	clock := xtime.NewMockClock(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	store := &fakeStore{keys: map[string]*Key{
+		HashKey("a"): {ID: "a"},
+		HashKey("b"): {ID: "b"},
+	}}
+	m := newTestManager(clock, store)
+	m.config.CacheSize = 2
+	ctx := context.Background()
+
+	for _, plain := range []string{"a", "b"} {
+		_, err := m.Authenticate(ctx, plain)
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := m.Authenticate(ctx, fmt.Sprintf("random-%d", i))
+		assert.Equal(t, ErrInvalidKey, err)
+	}
+	assert.Len(t, m.cache, 2)
+	assert.True(t, len(m.misses) <= 2)
+
+	lookups := atomic.LoadInt32(&store.lookups)
+	for _, plain := range []string{"a", "b"} {
+		_, err := m.Authenticate(ctx, plain)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, lookups, atomic.LoadInt32(&store.lookups), "valid keys kept")
+}
+
+func TestManager_Handler(t *testing.T) {
+	clock := xtime.NewMockClock(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	m := newTestManager(clock, nil)
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := FromContext(r.Context()); ok {
+			_, _ = w.Write([]byte(key.ID))
+		}
+	}))
+	serve := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/health").Code)
+	rec := serve("/api")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "ApiKey", rec.Header().Get("WWW-Authenticate"))
+	assert.Equal(t, http.StatusForbidden, serve("/api", "X-API-Key", "off").Code)
+
+	// burst of 2 at 1 rps
+	rec = serve("/api", "X-API-Key", "s3cret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "static", rec.Body.String())
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "2", rec.Header().Get("X-Quota-Remaining"))
+	assert.Equal(t, http.StatusOK, serve("/api?api_key=s3cret").Code)
+	rec = serve("/api", "Authorization", "ApiKey s3cret")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	// quota of 3 per day
+	clock.Advance(time.Second)
+	assert.Equal(t, http.StatusOK, serve("/api", "X-API-Key", "s3cret").Code)
+	clock.Advance(time.Second)
+	rec = serve("/api", "X-API-Key", "s3cret")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("X-Quota-Remaining"))
+	assert.Equal(t, "86398", rec.Header().Get("Retry-After"))
+
+	clock.Advance(24 * time.Hour)
+	assert.Equal(t, http.StatusOK, serve("/api", "X-API-Key", "s3cret").Code)
+
+	usages := m.Usages()
+	assert.Len(t, usages, 1)
+	assert.EqualValues(t, 6, usages[0].Requests)
+	assert.EqualValues(t, 2, usages[0].Rejected)
+	assert.EqualValues(t, 1, usages[0].QuotaUsed)
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikey
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("server.apikey", "jupiter.apikey.*", "api key authentication and limits", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Name labels metrics and usage on governor
+	Name string
+	// Header carrying the key, "Authorization: ApiKey <key>" is also accepted
+	Header string
+	// Query parameter carrying the key, disabled if empty
+	Query string
+	// Keys are static keys declared in config source
+	Keys []Key
+	// Skip are path prefixes served without keys, e.g. health checks
+	Skip []string
+	// CacheTTL of keys loaded from store, NegativeTTL of unknown keys
+	CacheTTL    time.Duration
+	NegativeTTL time.Duration
+	// CacheSize caps the keys cached, valid and unknown ones apart
+	CacheSize int
+	// Rate in requests per second and Burst of keys without their own,
+	// unlimited if zero
+	Rate  float64
+	Burst int
+	// Quota in requests per QuotaPeriod of keys without their own, unlimited if zero
+	Quota       int64
+	QuotaPeriod time.Duration
+
+	store  Store
+	logger *xlog.Logger
+	clock  xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Header:      "X-API-Key",
+		CacheTTL:    time.Minute,
+		NegativeTTL: 10 * time.Second,
+		CacheSize:   10000,
+		QuotaPeriod: 24 * time.Hour,
+		logger:      xlog.JupiterLogger.With(xlog.FieldMod("server.apikey")),
+		clock:       xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.apikey." + name)
+	if config.Name == "" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("apikey parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// WithStore looks up keys not declared in config from store, e.g. NewGormStore
+func (config *Config) WithStore(store Store) *Config {
+	config.store = store
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Manager {
+	if config.Name == "" {
+		config.Name = "default"
+	}
+	if config.QuotaPeriod <= 0 {
+		config.QuotaPeriod = 24 * time.Hour
+	}
+	if config.CacheSize <= 0 {
+		config.CacheSize = 10000
+	}
+	for i := range config.Keys {
+		key := &config.Keys[i]
+		if key.ID == "" || (key.Key == "" && key.Hash == "") {
+			config.logger.Panic("apikey id and key or hash required", xlog.Int("index", i))
+		}
+	}
+	return newManager(config)
+}
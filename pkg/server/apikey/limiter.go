@@ -0,0 +1,117 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikey
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrRateLimited is returned when a key exceeds its rate
+	ErrRateLimited = errors.New("api key rate limited")
+	// ErrQuotaExceeded is returned when a key exhausts its quota of current period
+	ErrQuotaExceeded = errors.New("api key quota exceeded")
+)
+
+// Decision is the result of Allow, which is also sent as X-RateLimit-* headers
+type Decision struct {
+	// Limit and Remaining of burst, zero if rate is unlimited
+	Limit     int
+	Remaining int
+	// QuotaLimit, QuotaRemaining and QuotaReset of current period, zero if quota is unlimited
+	QuotaLimit     int64
+	QuotaRemaining int64
+	QuotaReset     time.Time
+	// RetryAfter is set for rejected requests
+	RetryAfter time.Duration
+}
+
+// Usage is the usage of a key
+type Usage struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Requests  int64     `json:"requests"`
+	Rejected  int64     `json:"rejected"`
+	QuotaUsed int64     `json:"quotaUsed"`
+	Period    time.Time `json:"period"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// limiter is a token bucket and a quota counter of a key
+type limiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	usage  Usage
+}
+
+func (l *limiter) allow(now time.Time, rate float64, burst int, quota int64, period time.Duration) (Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var decision Decision
+	l.usage.Requests++
+	l.usage.LastSeen = now
+
+	if start := now.Truncate(period); start.After(l.usage.Period) {
+		l.usage.Period, l.usage.QuotaUsed = start, 0
+	}
+	if quota > 0 {
+		decision.QuotaLimit = quota
+		decision.QuotaReset = l.usage.Period.Add(period)
+		if l.usage.QuotaUsed >= quota {
+			l.usage.Rejected++
+			decision.RetryAfter = decision.QuotaReset.Sub(now)
+			return decision, ErrQuotaExceeded
+		}
+		decision.QuotaRemaining = quota - l.usage.QuotaUsed
+	}
+
+	if rate > 0 {
+		if burst <= 0 {
+			burst = int(math.Ceil(rate))
+		}
+		if l.last.IsZero() {
+			l.tokens = float64(burst)
+		} else if elapsed := now.Sub(l.last); elapsed > 0 {
+			l.tokens = math.Min(float64(burst), l.tokens+elapsed.Seconds()*rate)
+		}
+		l.last = now
+		decision.Limit = burst
+		if l.tokens < 1 {
+			l.usage.Rejected++
+			decision.RetryAfter = time.Duration((1 - l.tokens) / rate * float64(time.Second))
+			return decision, ErrRateLimited
+		}
+		l.tokens--
+		decision.Remaining = int(l.tokens)
+	}
+
+	l.usage.QuotaUsed++
+	if quota > 0 {
+		decision.QuotaRemaining--
+	}
+	return decision, nil
+}
+
+func (l *limiter) snapshot() Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.usage
+}
@@ -0,0 +1,151 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/store/gorm"
+	"github.com/pkg/errors"
+)
+
+// ErrKeyNotFound is returned by stores for unknown keys
+var ErrKeyNotFound = errors.New("api key not found")
+
+// Key is the identity of an api key
+type Key struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Key is the plain key, only for keys declared in config
+	Key string `json:"-"`
+	// Hash is the hex sha256 of the key, see HashKey
+	Hash string `json:"-"`
+	// Rate, Burst and Quota override the defaults of config if positive
+	Rate     float64 `json:"rate"`
+	Burst    int     `json:"burst"`
+	Quota    int64   `json:"quota"`
+	Disabled bool    `json:"disabled"`
+	// ExpiresAt is ignored if zero
+	ExpiresAt time.Time         `json:"expiresAt"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// HashKey returns the hash under which keys are stored, so that stores never see plain keys
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store looks up keys by hash
+type Store interface {
+	Lookup(ctx context.Context, hash string) (*Key, error)
+}
+
+// staticStore holds keys declared in config
+type staticStore map[string]*Key
+
+func newStaticStore(keys []Key) staticStore {
+	var store = make(staticStore, len(keys))
+	for i := range keys {
+		key := keys[i]
+		if key.Hash == "" {
+			key.Hash = HashKey(key.Key)
+		}
+		key.Key = ""
+		store[key.Hash] = &key
+	}
+	return store
+}
+
+// Lookup ...
+func (s staticStore) Lookup(_ context.Context, hash string) (*Key, error) {
+	if key, ok := s[hash]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// KeyRecord is a row of the api key table
+type KeyRecord struct {
+	ID        uint      `gorm:"primary_key"`
+	KeyID     string    `gorm:"type:varchar(64);unique_index"`
+	Name      string    `gorm:"type:varchar(128)"`
+	Hash      string    `gorm:"type:char(64);unique_index"`
+	Rate      float64   `gorm:"type:double"`
+	Burst     int       `gorm:"type:int"`
+	Quota     int64     `gorm:"type:bigint"`
+	Disabled  bool      `gorm:"type:tinyint(1)"`
+	ExpiresAt time.Time `gorm:"type:datetime"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TableName ...
+func (KeyRecord) TableName() string {
+	return "api_keys"
+}
+
+// GormStore looks up keys from table api_keys
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore ...
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+// Migrate creates or updates the table
+func (g *GormStore) Migrate() error {
+	return g.db.AutoMigrate(&KeyRecord{}).Error
+}
+
+// Lookup ...
+func (g *GormStore) Lookup(ctx context.Context, hash string) (*Key, error) {
+	var record KeyRecord
+	if err := gorm.WithContext(ctx, g.db).Where("hash = ?", hash).First(&record).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, ErrKeyNotFound
+		}
+		return nil, err
+	}
+	return &Key{
+		ID:        record.KeyID,
+		Name:      record.Name,
+		Hash:      record.Hash,
+		Rate:      record.Rate,
+		Burst:     record.Burst,
+		Quota:     record.Quota,
+		Disabled:  record.Disabled,
+		ExpiresAt: record.ExpiresAt,
+	}, nil
+}
+
+// Create saves a key of plain key, which is only hashed into the table
+func (g *GormStore) Create(ctx context.Context, key *Key, plain string) error {
+	return gorm.WithContext(ctx, g.db).Create(&KeyRecord{
+		KeyID:     key.ID,
+		Name:      key.Name,
+		Hash:      HashKey(plain),
+		Rate:      key.Rate,
+		Burst:     key.Burst,
+		Quota:     key.Quota,
+		Disabled:  key.Disabled,
+		ExpiresAt: key.ExpiresAt,
+	}).Error
+}
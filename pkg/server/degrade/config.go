@@ -0,0 +1,109 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package degrade
+
+import (
+	"net/http"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("server.degrade", "jupiter.degrade.*", "fallback responses of degraded routes", DefaultConfig)
+}
+
+// Fallback is the static response of routes served while they're degraded
+type Fallback struct {
+	// Paths are path prefixes of routes, e.g. "/api/recommend"
+	Paths []string
+	// Dependencies of routes, the fallback is served if any of them is down
+	Dependencies []string
+	// Level is the lowest level of the application serving the fallback,
+	// e.g. "severe", empty means only dependencies are checked
+	Level string
+	// Status code of the response, 200 by default
+	Status int
+	// ContentType of the response, "application/json; charset=utf-8" by default
+	ContentType string
+	Body        string
+
+	level Level
+}
+
+// Config ...
+type Config struct {
+	// Name labels metrics
+	Name      string
+	Fallbacks []Fallback
+
+	logger *xlog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		logger: logger,
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.degrade." + name)
+	if config.Name == "" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("degrade parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// Build panics if levels of fallbacks are invalid
+func (config *Config) Build() *Degrader {
+	if config.Name == "" {
+		config.Name = "default"
+	}
+	var fallbacks = make([]Fallback, len(config.Fallbacks))
+	for i, fallback := range config.Fallbacks {
+		if fallback.Level != "" {
+			level, err := ParseLevel(fallback.Level)
+			if err != nil || level == LevelNone {
+				config.logger.Panic("degrade invalid fallback level", xlog.String("level", fallback.Level), xlog.Any("paths", fallback.Paths))
+			}
+			fallback.level = level
+		}
+		if fallback.Status == 0 {
+			fallback.Status = http.StatusOK
+		}
+		if fallback.ContentType == "" {
+			fallback.ContentType = "application/json; charset=utf-8"
+		}
+		fallbacks[i] = fallback
+	}
+	return &Degrader{config: config, fallbacks: fallbacks}
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package degrade tracks the degradation level of the application, which
+// is raised by sources like breakers, overload protection and health of
+// dependencies. Handlers query it with Current to skip optional work, and
+// Handler serves static fallback responses of configured routes while the
+// application is degraded or their dependencies are down.
+package degrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/server/readiness"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// Level of degradation
+type Level int
+
+const (
+	// LevelNone means the application works normally
+	LevelNone Level = iota
+	// LevelPartial means optional features should be skipped, e.g. some
+	// soft dependencies are down
+	LevelPartial
+	// LevelSevere means only critical work should be done, e.g. some hard
+	// dependencies are down
+	LevelSevere
+)
+
+// sourceDependencies is the source of the level derived from dependencies
+const sourceDependencies = "dependencies"
+
+var levelNames = []string{"none", "partial", "severe"}
+
+// String ...
+func (l Level) String() string {
+	if l < LevelNone || l > LevelSevere {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses names of levels, e.g. "partial"
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelNone, fmt.Errorf("invalid degrade level %q", name)
+}
+
+// MarshalText ...
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+var levelGauge = metric.GaugeVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "degrade_level",
+	Labels:    []string{"source"},
+}.Build()
+
+var (
+	mu     sync.RWMutex
+	levels = make(map[string]Level)
+
+	logger = xlog.JupiterLogger.With(xlog.FieldMod("degrade"))
+)
+
+func init() {
+	governor.HandleFunc("/degrade", handle)
+}
+
+// handle reports levels, POST /degrade?level=partial degrades the
+// application manually until DELETE /degrade
+func handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		level, err := ParseLevel(r.URL.Query().Get("level"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		Set("governor", level)
+	case http.MethodDelete:
+		Set("governor", LevelNone)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"level":   Current(),
+		"sources": Sources(),
+	})
+}
+
+// Set sets the level raised by source, e.g. "breaker:redis", LevelNone
+// clears it
+func Set(source string, level Level) {
+	mu.Lock()
+	prev, ok := levels[source]
+	if level == LevelNone {
+		delete(levels, source)
+	} else {
+		levels[source] = level
+	}
+	mu.Unlock()
+
+	levelGauge.Set(float64(level), source)
+	if prev != level && (ok || level != LevelNone) {
+		logger.Info("degrade level changed", xlog.String("source", source), xlog.String("from", prev.String()), xlog.String("to", level.String()))
+	}
+}
+
+// Current returns the highest level of sources, including the one derived
+// from dependencies, see readiness.Depend
+func Current() Level {
+	var current = dependencyLevel()
+	mu.RLock()
+	for _, level := range levels {
+		if level > current {
+			current = level
+		}
+	}
+	mu.RUnlock()
+	return current
+}
+
+// Degraded reports whether the current level is at least level
+func Degraded(level Level) bool {
+	return Current() >= level
+}
+
+// Sources returns levels of sources which are degraded
+func Sources() map[string]Level {
+	mu.RLock()
+	var ret = make(map[string]Level, len(levels)+1)
+	for source, level := range levels {
+		ret[source] = level
+	}
+	mu.RUnlock()
+	if level := dependencyLevel(); level != LevelNone {
+		ret[sourceDependencies] = level
+	}
+	return ret
+}
+
+// dependencyLevel is severe if any hard dependency is down, partial if any
+// soft one is
+func dependencyLevel() Level {
+	switch readiness.DependencyStatus() {
+	case readiness.StatusDown:
+		return LevelSevere
+	case readiness.StatusDegraded:
+		return LevelPartial
+	}
+	return LevelNone
+}
+
+// DependencyDown reports whether the dependency of name is down, unknown
+// ones are up
+func DependencyDown(name string) bool {
+	state, ok := readiness.Lookup(name)
+	return ok && !state.Up
+}
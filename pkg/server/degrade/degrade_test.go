@@ -0,0 +1,90 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package degrade
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/server/readiness"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevel(t *testing.T) {
+	assert.Equal(t, LevelNone, Current())
+	Set("breaker:redis", LevelPartial)
+	Set("overload", LevelSevere)
+	assert.Equal(t, LevelSevere, Current())
+	assert.True(t, Degraded(LevelPartial))
+	Set("overload", LevelNone)
+	assert.Equal(t, map[string]Level{"breaker:redis": LevelPartial}, Sources())
+
+	w := httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/degrade?level=severe", nil))
+	assert.JSONEq(t, `{"level":"severe","sources":{"breaker:redis":"partial","governor":"severe"}}`, w.Body.String())
+	w = httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/degrade?level=high", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	governor.DefaultServeMux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/degrade", nil))
+
+	Set("breaker:redis", LevelNone)
+	assert.False(t, Degraded(LevelPartial))
+	_, err := ParseLevel("high")
+	assert.NotNil(t, err)
+}
+
+func TestDegrader_Handler(t *testing.T) {
+	config := DefaultConfig()
+	config.Fallbacks = []Fallback{
+		{Paths: []string{"/recommend"}, Dependencies: []string{"ranker"}, Body: `{"items":[]}`},
+		{Paths: []string{"/search", "/suggest"}, Level: "severe", Status: http.StatusServiceUnavailable, ContentType: "text/plain", Body: "busy"},
+	}
+	d := config.Build()
+	handler := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	assert.Equal(t, "ok", serve("/recommend/home").Body.String())
+
+	stop := readiness.Depend(readiness.Dependency{Name: "ranker", Check: func(ctx context.Context) error {
+		return errors.New("refused")
+	}})
+	defer stop()
+	w := serve("/recommend/home")
+	assert.Equal(t, `{"items":[]}`, w.Body.String())
+	assert.Equal(t, "true", w.Header().Get("X-Degraded"))
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	// soft dependencies down degrade the application partially
+	assert.Equal(t, LevelPartial, Current())
+	assert.Equal(t, "ok", serve("/suggest").Body.String())
+
+	Set("overload", LevelSevere)
+	defer Set("overload", LevelNone)
+	w = serve("/suggest")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "busy", w.Body.String())
+
+	config.Fallbacks[0].Level = "high"
+	assert.Panics(t, func() { config.Build() })
+}
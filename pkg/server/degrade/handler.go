@@ -0,0 +1,90 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package degrade
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/metric"
+)
+
+var fallbackCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "degrade_fallback_total",
+	Labels:    []string{"name", "path"},
+}.Build()
+
+// Degrader serves fallback responses of degraded routes
+type Degrader struct {
+	config    *Config
+	fallbacks []Fallback
+}
+
+// Fallback returns the fallback of path and its prefix matching path, if
+// the route is degraded now
+func (d *Degrader) Fallback(path string) (*Fallback, string, bool) {
+	var current = LevelNone
+	var leveled bool
+	for i := range d.fallbacks {
+		fallback := &d.fallbacks[i]
+		prefix, ok := fallback.match(path)
+		if !ok {
+			continue
+		}
+		if fallback.level != LevelNone {
+			if !leveled {
+				current, leveled = Current(), true
+			}
+			if current >= fallback.level {
+				return fallback, prefix, true
+			}
+		}
+		for _, dep := range fallback.Dependencies {
+			if DependencyDown(dep) {
+				return fallback, prefix, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// match returns the path prefix of f matching path
+func (f *Fallback) match(path string) (string, bool) {
+	for _, prefix := range f.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// Handler serves fallback responses of degraded routes instead of next,
+// the response carries the header X-Degraded: true. Echo users can apply it
+// with echo.WrapMiddleware.
+func (d *Degrader) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallback, prefix, ok := d.Fallback(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		fallbackCounter.Inc(d.config.Name, prefix)
+		w.Header().Set("Content-Type", fallback.ContentType)
+		w.Header().Set("X-Degraded", "true")
+		w.WriteHeader(fallback.Status)
+		_, _ = w.Write([]byte(fallback.Body))
+	})
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package deprecation marks grpc methods and http routes deprecated by config
+// key "jupiter.deprecation". Servers tell callers of deprecated endpoints with
+// Deprecation, Sunset and Warning headers (or grpc metadata), log each caller
+// once per LogInterval, and count calls of callers, which are served on
+// governor /debug/deprecations, so that it's known when they're safe to remove.
+package deprecation
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+	"github.com/pkg/errors"
+)
+
+// ConfigKey ...
+const ConfigKey = "jupiter.deprecation"
+
+// maxCallers caps callers tracked per endpoint
+const maxCallers = 1000
+
+// Endpoint is a deprecated grpc method or http route
+type Endpoint struct {
+	// Route is a grpc full method, e.g. "/helloworld.Greeter/SayHello", or
+	// an http route, e.g. "GET /v1/users/:id" or "/v1/users/:id" of all
+	// methods. A trailing "*" matches any suffix.
+	Route string
+	// Sunset is the date after which the endpoint may be removed, e.g. "2021-06-30"
+	Sunset string
+	// Message tells callers what to use instead
+	Message string
+	// Link to the migration guide
+	Link string
+
+	sunset time.Time
+}
+
+// Config ...
+type Config struct {
+	Endpoints []Endpoint
+	// LogInterval of logging each caller of an endpoint, 10m by default
+	LogInterval time.Duration
+}
+
+// DefaultConfig ...
+func DefaultConfig() Config {
+	return Config{
+		LogInterval: time.Minute * 10,
+	}
+}
+
+// Usage is the calls of an endpoint by a caller
+type Usage struct {
+	Caller   string    `json:"caller"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"lastSeen"`
+
+	lastLogged time.Time
+}
+
+// EndpointUsage is the usage of a deprecated endpoint
+type EndpointUsage struct {
+	Route   string  `json:"route"`
+	Sunset  string  `json:"sunset,omitempty"`
+	Count   int64   `json:"count"`
+	Callers []Usage `json:"callers"`
+}
+
+var requestCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "deprecated_request_total",
+	Labels:    []string{"route"},
+}.Build()
+
+var (
+	current atomic.Value // Config
+	mu      sync.Mutex
+	usages  = make(map[string]map[string]*Usage)
+
+	clock  xtime.Clock = xtime.SystemClock
+	logger             = xlog.JupiterLogger.With(xlog.FieldMod("deprecation"))
+)
+
+func init() {
+	current.Store(DefaultConfig())
+	xschema.RegisterConfig("deprecation", ConfigKey, "deprecated methods and routes", DefaultConfig)
+
+	governor.HandleFunc("/debug/deprecations", func(w http.ResponseWriter, r *http.Request) {
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(Usages())
+	})
+}
+
+// Load reads deprecated endpoints from config and watches config changes
+func Load() {
+	reload()
+	conf.OnChange(func(*conf.Configuration) { reload() })
+}
+
+func reload() {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(ConfigKey, &config); err != nil && errors.Cause(err) != conf.ErrInvalidKey {
+		logger.Error("parse deprecation config", xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err))
+		return
+	}
+	Set(config)
+}
+
+// Set replaces deprecated endpoints with those of config
+func Set(config Config) {
+	var endpoints = make([]Endpoint, 0, len(config.Endpoints))
+	for _, endpoint := range config.Endpoints {
+		if endpoint.Sunset != "" {
+			sunset, err := time.Parse("2006-01-02", endpoint.Sunset)
+			if err != nil {
+				logger.Error("parse sunset of deprecated endpoint", xlog.FieldErr(err), xlog.String("route", endpoint.Route))
+			}
+			endpoint.sunset = sunset
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	config.Endpoints = endpoints
+	current.Store(config)
+}
+
+// Lookup returns the deprecated endpoint of a grpc method, whose httpMethod
+// is empty, or an http route
+func Lookup(httpMethod, route string) (*Endpoint, bool) {
+	config := current.Load().(Config)
+	for i := range config.Endpoints {
+		endpoint := &config.Endpoints[i]
+		pattern := endpoint.Route
+		if idx := strings.IndexByte(pattern, ' '); idx >= 0 {
+			if !strings.EqualFold(pattern[:idx], httpMethod) {
+				continue
+			}
+			pattern = strings.TrimSpace(pattern[idx+1:])
+		}
+		if pattern == route || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(route, strings.TrimSuffix(pattern, "*"))) {
+			return endpoint, true
+		}
+	}
+	return nil, false
+}
+
+// Headers returns headers telling callers endpoint is deprecated, keys are
+// canonical http header keys, grpc servers lower them for metadata
+func (endpoint *Endpoint) Headers() map[string]string {
+	var headers = map[string]string{"Deprecation": "true"}
+	if !endpoint.sunset.IsZero() {
+		headers["Sunset"] = endpoint.sunset.UTC().Format(http.TimeFormat)
+	}
+	if endpoint.Link != "" {
+		headers["Link"] = "<" + endpoint.Link + `>; rel="deprecation"`
+	}
+	msg := endpoint.Message
+	if msg == "" {
+		msg = endpoint.Route + " is deprecated"
+	}
+	headers["Warning"] = "299 - " + strconv.Quote(msg)
+	return headers
+}
+
+// Observe counts a call of endpoint by caller, e.g. app id or ip of peers,
+// and logs each caller once per LogInterval
+func Observe(endpoint *Endpoint, caller string) {
+	requestCounter.Inc(endpoint.Route)
+	now := clock.Now()
+
+	mu.Lock()
+	callers, ok := usages[endpoint.Route]
+	if !ok {
+		callers = make(map[string]*Usage)
+		usages[endpoint.Route] = callers
+	}
+	usage, ok := callers[caller]
+	if !ok {
+		if len(callers) >= maxCallers {
+			caller = "other"
+			usage = callers[caller]
+		}
+		if usage == nil {
+			usage = &Usage{Caller: caller}
+			callers[caller] = usage
+		}
+	}
+	usage.Count++
+	usage.LastSeen = now
+	var log bool
+	if now.Sub(usage.lastLogged) >= current.Load().(Config).LogInterval {
+		usage.lastLogged = now
+		log = true
+	}
+	count := usage.Count
+	mu.Unlock()
+
+	if log {
+		logger.Warn("deprecated endpoint called",
+			xlog.String("route", endpoint.Route),
+			xlog.String("caller", caller),
+			xlog.Int64("count", count),
+			xlog.String("sunset", endpoint.Sunset),
+		)
+	}
+}
+
+// Usages returns usages of endpoints called, including those no longer deprecated
+func Usages() []EndpointUsage {
+	config := current.Load().(Config)
+	var sunsets = make(map[string]string, len(config.Endpoints))
+	for _, endpoint := range config.Endpoints {
+		sunsets[endpoint.Route] = endpoint.Sunset
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var ret = make([]EndpointUsage, 0, len(usages))
+	for route, callers := range usages {
+		usage := EndpointUsage{Route: route, Sunset: sunsets[route], Callers: make([]Usage, 0, len(callers))}
+		for _, caller := range callers {
+			usage.Count += caller.Count
+			usage.Callers = append(usage.Callers, *caller)
+		}
+		sort.Slice(usage.Callers, func(i, j int) bool { return usage.Callers[i].Count > usage.Callers[j].Count })
+		ret = append(ret, usage)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Route < ret[j].Route })
+	return ret
+}
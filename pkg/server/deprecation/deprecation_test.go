@@ -0,0 +1,74 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deprecation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	Set(Config{Endpoints: []Endpoint{
+		{Route: "/helloworld.Greeter/SayHello", Sunset: "2021-06-30", Message: "use SayHelloV2", Link: "https://example.com/migration"},
+		{Route: "GET /v1/users/:id"},
+		{Route: "/v1/legacy/*"},
+	}})
+	defer Set(DefaultConfig())
+
+	endpoint, ok := Lookup("", "/helloworld.Greeter/SayHello")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{
+		"Deprecation": "true",
+		"Sunset":      "Wed, 30 Jun 2021 00:00:00 GMT",
+		"Link":        `<https://example.com/migration>; rel="deprecation"`,
+		"Warning":     `299 - "use SayHelloV2"`,
+	}, endpoint.Headers())
+
+	_, ok = Lookup("GET", "/v1/users/:id")
+	assert.True(t, ok)
+	_, ok = Lookup("DELETE", "/v1/users/:id")
+	assert.False(t, ok)
+	endpoint, ok = Lookup("POST", "/v1/legacy/orders")
+	assert.True(t, ok)
+	assert.Equal(t, `299 - "/v1/legacy/* is deprecated"`, endpoint.Headers()["Warning"])
+	_, ok = Lookup("", "/helloworld.Greeter/SayHelloV2")
+	assert.False(t, ok)
+}
+
+func TestObserve(t *testing.T) {
+	mock := xtime.NewMockClock(time.Unix(1600000000, 0))
+	clock = mock
+	defer func() { clock = xtime.SystemClock }()
+	Set(Config{LogInterval: time.Minute, Endpoints: []Endpoint{{Route: "/helloworld.Greeter/SayHello", Sunset: "2021-06-30"}}})
+	defer Set(DefaultConfig())
+
+	endpoint, _ := Lookup("", "/helloworld.Greeter/SayHello")
+	Observe(endpoint, "app-a")
+	mock.Advance(time.Second)
+	Observe(endpoint, "app-a")
+	Observe(endpoint, "app-b")
+
+	usages := Usages()
+	assert.Len(t, usages, 1)
+	assert.Equal(t, "/helloworld.Greeter/SayHello", usages[0].Route)
+	assert.Equal(t, "2021-06-30", usages[0].Sunset)
+	assert.Equal(t, int64(3), usages[0].Count)
+	assert.Equal(t, "app-a", usages[0].Callers[0].Caller)
+	assert.Equal(t, int64(2), usages[0].Callers[0].Count)
+	assert.Equal(t, mock.Now(), usages[0].Callers[0].LastSeen)
+}
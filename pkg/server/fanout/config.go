@@ -0,0 +1,115 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("server.fanout", "jupiter.fanout.*", "fan-out of mq messages to websocket and sse clients", DefaultConfig)
+}
+
+const (
+	// PolicyDropOldest drops the oldest queued message of a slow client
+	PolicyDropOldest = "dropOldest"
+	// PolicyDisconnect disconnects a slow client
+	PolicyDisconnect = "disconnect"
+)
+
+// Config ...
+type Config struct {
+	// Name labels metrics
+	Name string
+	// Topics clients may subscribe to, any topic if empty
+	Topics []string
+	// BufferSize is the number of messages queued per client
+	BufferSize int
+	// SlowConsumer is the policy once the buffer of a client is full,
+	// "dropOldest" or "disconnect"
+	SlowConsumer string
+	// MaxClients caps connected clients, 0 means no limit
+	MaxClients int
+	// Heartbeat is the interval of pings keeping idle connections alive
+	Heartbeat time.Duration
+	// WriteTimeout of each message written to websocket clients
+	WriteTimeout time.Duration
+
+	authorize Authorizer
+	logger    *xlog.Logger
+}
+
+// Authorizer returns the subscription of a client request, which is
+// rejected with 403 on errors. By default clients subscribe to topics of
+// query "topic", and only receive messages without keys.
+type Authorizer func(r *http.Request) (Subscription, error)
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Name:         "default",
+		BufferSize:   64,
+		SlowConsumer: PolicyDropOldest,
+		Heartbeat:    time.Second * 15,
+		WriteTimeout: time.Second * 10,
+		logger:       xlog.JupiterLogger.With(xlog.FieldMod("server.fanout")),
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.fanout." + name)
+	if config.Name == "default" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("fanout parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// WithAuthorizer subscribes clients with authorize, e.g. to keys of the
+// authenticated user
+func (config *Config) WithAuthorizer(authorize Authorizer) *Config {
+	config.authorize = authorize
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Hub {
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1
+	}
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = DefaultConfig().Heartbeat
+	}
+	return newHub(config)
+}
@@ -0,0 +1,337 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fanout pushes messages consumed from MQ topics to connected
+// WebSocket and SSE clients, e.g. for real-time notification services.
+// Each client has a bounded queue, once it's full the oldest message is
+// dropped or the client is disconnected, so that slow clients never block
+// consuming or other clients.
+package fanout
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+)
+
+var (
+	// ErrSlowConsumer is the error of clients disconnected as their queues are full
+	ErrSlowConsumer = errors.New("slow consumer")
+	// ErrTooManyClients is returned if MaxClients is reached
+	ErrTooManyClients = errors.New("too many clients")
+	// ErrClosed is the error of clients disconnected as the hub is closed
+	ErrClosed = errors.New("hub closed")
+)
+
+var clientGauge = metric.GaugeVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "fanout_clients",
+	Labels:    []string{"name", "transport"},
+}.Build()
+
+var messageCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "fanout_message_total",
+	Labels:    []string{"name", "topic", "result"},
+}.Build()
+
+// Message is pushed to clients subscribing its topic
+type Message struct {
+	Topic string
+	ID    string
+	// Keys route the message to clients subscribing any of them, e.g. ids
+	// of users notified, messages without keys are sent to all clients
+	Keys []string
+	Data []byte
+}
+
+// Subscription is what a client receives
+type Subscription struct {
+	Topics []string
+	// Keys of keyed messages received, e.g. the id of the user
+	Keys []string
+}
+
+type topicClients struct {
+	all   map[*Client]struct{}
+	byKey map[string]map[*Client]struct{}
+}
+
+// Hub dispatches published messages to clients
+type Hub struct {
+	config *Config
+	topics map[string]bool
+
+	mu      sync.RWMutex
+	clients map[string]*topicClients
+	count   int
+	closed  bool
+}
+
+func newHub(config *Config) *Hub {
+	h := &Hub{
+		config:  config,
+		topics:  make(map[string]bool, len(config.Topics)),
+		clients: make(map[string]*topicClients),
+	}
+	for _, topic := range config.Topics {
+		h.topics[topic] = true
+	}
+	return h
+}
+
+// Publish queues msg to clients subscribing it, returns the number of them
+func (h *Hub) Publish(msg Message) int {
+	var targets = make(map[*Client]struct{})
+	h.mu.RLock()
+	if tc, ok := h.clients[msg.Topic]; ok {
+		if len(msg.Keys) == 0 {
+			for c := range tc.all {
+				targets[c] = struct{}{}
+			}
+		}
+		for _, key := range msg.Keys {
+			for c := range tc.byKey[key] {
+				targets[c] = struct{}{}
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	// clients are pushed without the lock, as slow ones are removed by push
+	var n int
+	for c := range targets {
+		if c.push(msg) {
+			n++
+		}
+	}
+	return n
+}
+
+// Subscribe connects a client receiving messages of sub
+func (h *Hub) Subscribe(sub Subscription) (*Client, error) {
+	c := &Client{
+		hub:   h,
+		sub:   sub,
+		queue: make(chan Message, h.config.BufferSize),
+		done:  make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrClosed
+	}
+	if h.config.MaxClients > 0 && h.count >= h.config.MaxClients {
+		return nil, ErrTooManyClients
+	}
+	h.count++
+	for _, topic := range sub.Topics {
+		tc, ok := h.clients[topic]
+		if !ok {
+			tc = &topicClients{all: make(map[*Client]struct{}), byKey: make(map[string]map[*Client]struct{})}
+			h.clients[topic] = tc
+		}
+		tc.all[c] = struct{}{}
+		for _, key := range sub.Keys {
+			if tc.byKey[key] == nil {
+				tc.byKey[key] = make(map[*Client]struct{})
+			}
+			tc.byKey[key][c] = struct{}{}
+		}
+	}
+	return c, nil
+}
+
+func (h *Hub) remove(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count--
+	for _, topic := range c.sub.Topics {
+		tc, ok := h.clients[topic]
+		if !ok {
+			continue
+		}
+		delete(tc.all, c)
+		for _, key := range c.sub.Keys {
+			delete(tc.byKey[key], c)
+			if len(tc.byKey[key]) == 0 {
+				delete(tc.byKey, key)
+			}
+		}
+		if len(tc.all) == 0 {
+			delete(h.clients, topic)
+		}
+	}
+}
+
+// Clients returns the number of connected clients
+func (h *Hub) Clients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.count
+}
+
+// Close disconnects all clients, later subscriptions fail with ErrClosed
+func (h *Hub) Close() {
+	var clients = make(map[*Client]struct{})
+	h.mu.Lock()
+	h.closed = true
+	for _, tc := range h.clients {
+		for c := range tc.all {
+			clients[c] = struct{}{}
+		}
+	}
+	h.mu.Unlock()
+	for c := range clients {
+		c.close(ErrClosed)
+	}
+}
+
+// authorize returns the subscription of r, topics not in Topics are refused
+func (h *Hub) authorize(r *http.Request) (Subscription, error) {
+	var sub Subscription
+	if h.config.authorize != nil {
+		var err error
+		if sub, err = h.config.authorize(r); err != nil {
+			return sub, err
+		}
+	} else {
+		sub.Topics = r.URL.Query()["topic"]
+	}
+	if len(sub.Topics) == 0 {
+		return sub, errors.New("no topic subscribed")
+	}
+	for _, topic := range sub.Topics {
+		if len(h.topics) > 0 && !h.topics[topic] {
+			return sub, errors.New("topic not allowed: " + topic)
+		}
+	}
+	return sub, nil
+}
+
+// Client is a connected subscriber
+type Client struct {
+	hub   *Hub
+	sub   Subscription
+	queue chan Message
+
+	mu     sync.Mutex
+	done   chan struct{}
+	closed bool
+	err    error
+}
+
+// push queues msg with the slow consumer policy applied, false if it's not
+// queued
+func (c *Client) push(msg Message) bool {
+	var name = c.hub.config.Name
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+	select {
+	case c.queue <- msg:
+		c.mu.Unlock()
+		return true
+	default:
+	}
+	if c.hub.config.SlowConsumer == PolicyDisconnect {
+		c.mu.Unlock()
+		messageCounter.Inc(name, msg.Topic, "disconnected")
+		c.close(ErrSlowConsumer)
+		return false
+	}
+	// publishers are serialized by mu, so the queue has room once the
+	// oldest message is dropped
+	select {
+	case dropped := <-c.queue:
+		messageCounter.Inc(name, dropped.Topic, "dropped")
+	default:
+	}
+	select {
+	case c.queue <- msg:
+	default:
+	}
+	c.mu.Unlock()
+	return true
+}
+
+// Messages returns queued messages
+func (c *Client) Messages() <-chan Message {
+	return c.queue
+}
+
+// Done is closed once the client is closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client is closed, e.g. ErrSlowConsumer
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Close disconnects the client
+func (c *Client) Close() {
+	c.close(nil)
+}
+
+func (c *Client) close(err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed, c.err = true, err
+	close(c.done)
+	c.mu.Unlock()
+	c.hub.remove(c)
+}
+
+// serve writes queued messages with write and pings idle connections, until
+// ctx is done, the client is closed or writing fails
+func (c *Client) serve(ctx context.Context, transport string, write func(Message) error, ping func() error) error {
+	var name = c.hub.config.Name
+	clientGauge.Inc(name, transport)
+	defer clientGauge.Add(-1, name, transport)
+
+	ticker := time.NewTicker(c.hub.config.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-c.queue:
+			if err := write(msg); err != nil {
+				messageCounter.Inc(name, msg.Topic, "failed")
+				return err
+			}
+			messageCounter.Inc(name, msg.Topic, "delivered")
+		case <-ticker.C:
+			if err := ping(); err != nil {
+				return err
+			}
+		case <-c.done:
+			return c.Err()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/client/rocketmq"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHub(policy string) *Hub {
+	config := DefaultConfig()
+	config.Name = "test"
+	config.Topics = []string{"notice", "order"}
+	config.BufferSize = 2
+	config.SlowConsumer = policy
+	config.MaxClients = 3
+	return config.Build()
+}
+
+func drain(c *Client) []string {
+	var ids []string
+	for {
+		select {
+		case msg := <-c.Messages():
+			ids = append(ids, msg.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestHub_Publish(t *testing.T) {
+	hub := newTestHub(PolicyDropOldest)
+	defer hub.Close()
+
+	all, err := hub.Subscribe(Subscription{Topics: []string{"notice"}})
+	assert.Nil(t, err)
+	user, err := hub.Subscribe(Subscription{Topics: []string{"notice", "order"}, Keys: []string{"u1"}})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, hub.Publish(Message{Topic: "notice", ID: "1"}))
+	assert.Equal(t, 1, hub.Publish(Message{Topic: "order", ID: "2", Keys: []string{"u1", "u2"}}))
+	assert.Equal(t, 0, hub.Publish(Message{Topic: "order", ID: "3", Keys: []string{"u2"}}))
+	assert.Equal(t, []string{"1"}, drain(all))
+	assert.Equal(t, []string{"1", "2"}, drain(user))
+
+	// the oldest messages are dropped for slow clients
+	for _, id := range []string{"4", "5", "6"} {
+		hub.Publish(Message{Topic: "notice", ID: id})
+	}
+	assert.Equal(t, []string{"5", "6"}, drain(all))
+
+	_, err = hub.Subscribe(Subscription{Topics: []string{"order"}})
+	assert.Nil(t, err)
+	_, err = hub.Subscribe(Subscription{Topics: []string{"order"}})
+	assert.Equal(t, ErrTooManyClients, err)
+
+	user.Close()
+	assert.Equal(t, 2, hub.Clients())
+	assert.Equal(t, 1, hub.Publish(Message{Topic: "notice", ID: "7"}))
+
+	hub.Close()
+	<-all.Done()
+	assert.Equal(t, ErrClosed, all.Err())
+	assert.Equal(t, 0, hub.Clients())
+}
+
+func TestHub_Disconnect(t *testing.T) {
+	hub := newTestHub(PolicyDisconnect)
+	defer hub.Close()
+
+	slow, err := hub.Subscribe(Subscription{Topics: []string{"notice"}})
+	assert.Nil(t, err)
+	for _, id := range []string{"1", "2", "3"} {
+		hub.Publish(Message{Topic: "notice", ID: id})
+	}
+	<-slow.Done()
+	assert.Equal(t, ErrSlowConsumer, slow.Err())
+	assert.Equal(t, 0, hub.Clients())
+}
+
+func TestHub_SSEHandler(t *testing.T) {
+	hub := newTestHub(PolicyDropOldest)
+	defer hub.Close()
+	server := httptest.NewServer(hub.SSEHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?topic=secret")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "?topic=notice")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	assert.Eventually(t, func() bool { return hub.Clients() == 1 }, time.Second, time.Millisecond)
+	hub.Publish(Message{Topic: "notice", ID: "1", Data: []byte("hello\nworld")})
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 5 {
+		line, err := reader.ReadString('\n')
+		assert.Nil(t, err)
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	assert.Equal(t, []string{"id: 1", "event: notice", "data: hello", "data: world", ""}, lines)
+}
+
+func TestHub_SubscribeRocketMQ(t *testing.T) {
+	h := newTestHub(PolicyDropOldest)
+	config := rocketmq.DefaultConsumerConfig()
+	h.SubscribeRocketMQ(&config, "notice")
+	// every instance gets all messages
+	assert.Equal(t, rocketmq.MessageModelBroadcasting, config.MessageModel)
+}
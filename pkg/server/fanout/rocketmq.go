@@ -0,0 +1,43 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"strings"
+
+	"github.com/apache/rocketmq-client-go/primitive"
+	"github.com/douyu/jupiter/pkg/client/rocketmq"
+)
+
+// SubscribeRocketMQ publishes messages of topics consumed by config, keys
+// of messages route them to clients subscribing the keys. Clients connect to
+// any instance, so config consumes in broadcasting mode to deliver messages
+// to every instance.
+func (h *Hub) SubscribeRocketMQ(config *rocketmq.ConsumerConfig, topics ...string) *rocketmq.ConsumerConfig {
+	config.MessageModel = rocketmq.MessageModelBroadcasting
+	for _, topic := range topics {
+		config.WithSubscribe(topic, func(_ context.Context, msg *primitive.MessageExt) error {
+			h.Publish(Message{
+				Topic: msg.Topic,
+				ID:    msg.MsgId,
+				Keys:  strings.Fields(msg.GetKeys()),
+				Data:  msg.Body,
+			})
+			return nil
+		})
+	}
+	return config
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+)
+
+// SSEHandler streams messages to clients as server-sent events, named by
+// topics of messages
+func (h *Hub) SSEHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		client, ok := h.connect(w, r)
+		if !ok {
+			return
+		}
+		defer client.Close()
+
+		header := w.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		header.Set("Connection", "keep-alive")
+		// disables buffering of nginx
+		header.Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		err := client.serve(r.Context(), "sse", func(msg Message) error {
+			if _, err := w.Write(encodeEvent(msg)); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		}, func() error {
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return err
+			}
+			flusher.Flush()
+			return nil
+		})
+		if err == ErrSlowConsumer || err == ErrClosed {
+			_, _ = w.Write([]byte("event: error\ndata: " + err.Error() + "\n\n"))
+			flusher.Flush()
+		}
+	})
+}
+
+// connect authorizes and subscribes the client of r, false if it's refused
+// and the response is written
+func (h *Hub) connect(w http.ResponseWriter, r *http.Request) (*Client, bool) {
+	sub, err := h.authorize(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil, false
+	}
+	client, err := h.Subscribe(sub)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return client, true
+}
+
+// encodeEvent encodes msg as an event, lines of data are sent as data fields
+func encodeEvent(msg Message) []byte {
+	var buf bytes.Buffer
+	if msg.ID != "" {
+		buf.WriteString("id: " + msg.ID + "\n")
+	}
+	buf.WriteString("event: " + msg.Topic + "\n")
+	scanner := bufio.NewScanner(bytes.NewReader(msg.Data))
+	scanner.Buffer(nil, len(msg.Data)+1)
+	var lines int
+	for scanner.Scan() {
+		buf.WriteString("data: ")
+		buf.Write(scanner.Bytes())
+		buf.WriteByte('\n')
+		lines++
+	}
+	if lines == 0 {
+		buf.WriteString("data: \n")
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
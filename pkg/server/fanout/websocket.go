@@ -0,0 +1,94 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fanout
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/gorilla/websocket"
+)
+
+// envelope is the json text message of websocket clients
+type envelope struct {
+	Topic string `json:"topic"`
+	ID    string `json:"id,omitempty"`
+	// Data is embedded if it's json, or else a string
+	Data interface{} `json:"data"`
+}
+
+// WebSocketHandler pushes messages to clients as json text messages, the
+// default upgrader is used if upgrader is nil
+func (h *Hub) WebSocketHandler(upgrader *websocket.Upgrader) http.Handler {
+	if upgrader == nil {
+		upgrader = &websocket.Upgrader{}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, ok := h.connect(w, r)
+		if !ok {
+			return
+		}
+		defer client.Close()
+		// the upgrader responds errors itself
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// reads are discarded, so that control frames are handled and closed
+		// connections are noticed
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		xgo.Go(func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		})
+
+		deadline := func() time.Time {
+			if h.config.WriteTimeout <= 0 {
+				return time.Time{}
+			}
+			return time.Now().Add(h.config.WriteTimeout)
+		}
+		err = client.serve(ctx, "websocket", func(msg Message) error {
+			_ = conn.SetWriteDeadline(deadline())
+			return conn.WriteJSON(newEnvelope(msg))
+		}, func() error {
+			return conn.WriteControl(websocket.PingMessage, nil, deadline())
+		})
+		switch err {
+		case ErrSlowConsumer:
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()), deadline())
+		case ErrClosed:
+			_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, err.Error()), deadline())
+		}
+	})
+}
+
+func newEnvelope(msg Message) envelope {
+	var data interface{} = string(msg.Data)
+	if json.Valid(msg.Data) {
+		data = json.RawMessage(msg.Data)
+	}
+	return envelope{Topic: msg.Topic, ID: msg.ID, Data: data}
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"net"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("server.geoip", "jupiter.geoip.*", "client ip geolocation", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Name labels metrics
+	Name string
+	// Path of the database file, opened by OpenCSV unless WithOpener
+	Path string
+	// Watch reloads the database once the file changes
+	Watch bool
+	// TrustedProxies are IPs or CIDRs whose X-Forwarded-For and X-Real-IP
+	// headers are trusted, client IPs are peer addresses if empty
+	TrustedProxies []string
+	// MaxLabelValues caps distinct values of each metric label, values seen
+	// after that are counted as "other"
+	MaxLabelValues int
+
+	opener Opener
+	logger *xlog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Watch:          true,
+		MaxLabelValues: 50,
+		opener:         OpenCSV,
+		logger:         xlog.JupiterLogger.With(xlog.FieldMod("server.geoip")),
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.geoip." + name)
+	if config.Name == "" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("geoip parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// WithOpener opens the database with opener, e.g. a maxmind reader
+func (config *Config) WithOpener(opener Opener) *Config {
+	config.opener = opener
+	return config
+}
+
+// Build opens the database, panics if it fails
+func (config *Config) Build() *Resolver {
+	if config.Name == "" {
+		config.Name = "default"
+	}
+	if config.MaxLabelValues <= 0 {
+		config.MaxLabelValues = 50
+	}
+	trusted, err := parseNets(config.TrustedProxies)
+	if err != nil {
+		config.logger.Panic("geoip parse trusted proxies", xlog.FieldErr(err), xlog.Any("trustedProxies", config.TrustedProxies))
+	}
+	r, err := newResolver(config, trusted)
+	if err != nil {
+		config.logger.Panic("geoip open database", xlog.FieldErr(err), xlog.String("path", config.Path))
+	}
+	return r
+}
+
+func parseNets(cidrs []string) ([]*net.IPNet, error) {
+	var nets = make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// csvRange is a range of IPs in 16-byte form and their location
+type csvRange struct {
+	start, end net.IP
+	location   Location
+}
+
+type csvDatabase []csvRange
+
+// OpenCSV opens a database of IP ranges, each line of the file is
+// "start,end,country,region,city,isp", lines starting with '#' are comments.
+// Ranges are inclusive and must not overlap, IPv4 and IPv6 can be mixed.
+func OpenCSV(path string) (Database, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCSV(f)
+}
+
+func parseCSV(r io.Reader) (Database, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 6
+	reader.TrimLeadingSpace = true
+
+	var db csvDatabase
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, end := net.ParseIP(strings.TrimSpace(record[0])), net.ParseIP(strings.TrimSpace(record[1]))
+		if start == nil || end == nil || bytes.Compare(start.To16(), end.To16()) > 0 {
+			return nil, fmt.Errorf("invalid range %s-%s", record[0], record[1])
+		}
+		db = append(db, csvRange{
+			start: start.To16(),
+			end:   end.To16(),
+			location: Location{
+				Country: record[2],
+				Region:  record[3],
+				City:    record[4],
+				ISP:     record[5],
+			},
+		})
+	}
+	sort.Slice(db, func(i, j int) bool { return bytes.Compare(db[i].start, db[j].start) < 0 })
+	for i := 1; i < len(db); i++ {
+		if bytes.Compare(db[i].start, db[i-1].end) <= 0 {
+			return nil, fmt.Errorf("overlapped ranges %s-%s and %s-%s", db[i-1].start, db[i-1].end, db[i].start, db[i].end)
+		}
+	}
+	return db, nil
+}
+
+// Lookup ...
+func (db csvDatabase) Lookup(ip net.IP) (Location, bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return Location{}, false
+	}
+	// the last range starting no later than ip
+	idx := sort.Search(len(db), func(i int) bool { return bytes.Compare(db[i].start, ip) > 0 }) - 1
+	if idx < 0 || bytes.Compare(ip, db[idx].end) > 0 {
+		return Location{}, false
+	}
+	return db[idx].location, true
+}
+
+// Close ...
+func (db csvDatabase) Close() error { return nil }
@@ -0,0 +1,282 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package geoip resolves client IPs of requests to locations with a pluggable
+// database, which is reloaded once its file changes, and attaches them to
+// request context, logs and metrics.
+package geoip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/fsnotify/fsnotify"
+)
+
+// closeDelay is how long replaced databases stay open for in-flight lookups
+const closeDelay = time.Minute
+
+var requestCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "geoip_request_total",
+	Labels:    []string{"name", "country", "region", "isp"},
+}.Build()
+
+// Location of an IP, empty fields are unknown
+type Location struct {
+	IP      string `json:"ip"`
+	Country string `json:"country"`
+	Region  string `json:"region"`
+	City    string `json:"city"`
+	ISP     string `json:"isp"`
+}
+
+// Fields returns log fields of location
+func (l Location) Fields() []xlog.Field {
+	return []xlog.Field{
+		xlog.String("clientIP", l.IP),
+		xlog.String("country", l.Country),
+		xlog.String("region", l.Region),
+		xlog.String("city", l.City),
+		xlog.String("isp", l.ISP),
+	}
+}
+
+// Database resolves IPs to locations
+type Database interface {
+	Lookup(ip net.IP) (Location, bool)
+	Close() error
+}
+
+// Opener opens the database file at path
+type Opener func(path string) (Database, error)
+
+type contextKey struct{}
+
+// NewContext returns a context carrying location
+func NewContext(ctx context.Context, location Location) context.Context {
+	return context.WithValue(ctx, contextKey{}, location)
+}
+
+// FromContext returns the location of the client resolved by the middleware
+func FromContext(ctx context.Context) (Location, bool) {
+	location, ok := ctx.Value(contextKey{}).(Location)
+	return location, ok
+}
+
+// Fields returns log fields of the location in ctx, nil if there's none
+func Fields(ctx context.Context) []xlog.Field {
+	if location, ok := FromContext(ctx); ok {
+		return location.Fields()
+	}
+	return nil
+}
+
+// Resolver resolves client IPs of requests
+type Resolver struct {
+	config  *Config
+	trusted []*net.IPNet
+	db      atomic.Value
+	watcher *fsnotify.Watcher
+
+	mu     sync.Mutex
+	labels map[string]map[string]struct{}
+}
+
+// dbHolder keeps the dynamic type stored in atomic.Value unchanged
+type dbHolder struct{ Database }
+
+func newResolver(config *Config, trusted []*net.IPNet) (*Resolver, error) {
+	r := &Resolver{
+		config:  config,
+		trusted: trusted,
+		labels:  make(map[string]map[string]struct{}),
+	}
+	db, err := config.opener(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	r.db.Store(dbHolder{db})
+	if config.Watch {
+		if err := r.watch(); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Reload opens the database again, the current one is kept if it fails
+func (r *Resolver) Reload() error {
+	db, err := r.config.opener(r.config.Path)
+	if err != nil {
+		return err
+	}
+	prev := r.db.Load().(dbHolder)
+	r.db.Store(dbHolder{db})
+	time.AfterFunc(closeDelay, func() { _ = prev.Close() })
+	return nil
+}
+
+func (r *Resolver) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// watch the directory, so that files replaced by rename are noticed
+	path, _ := filepath.Abs(r.config.Path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+	r.watcher = watcher
+	xgo.Go(func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				const writeOrCreateMask = fsnotify.Write | fsnotify.Create
+				if event.Op&writeOrCreateMask == 0 || filepath.Clean(event.Name) != path {
+					continue
+				}
+				// a file being written may be incomplete, the next event reloads it again
+				if err := r.Reload(); err != nil {
+					r.config.logger.Warn("reload geoip database", xlog.FieldErr(err), xlog.String("path", path))
+					continue
+				}
+				r.config.logger.Info("reload geoip database", xlog.String("path", path))
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.config.logger.Error("watch geoip database", xlog.FieldErr(err), xlog.String("path", path))
+			}
+		}
+	})
+	return nil
+}
+
+// Lookup returns the location of ip
+func (r *Resolver) Lookup(ip net.IP) (Location, bool) {
+	location, ok := r.db.Load().(dbHolder).Lookup(ip)
+	location.IP = ip.String()
+	return location, ok
+}
+
+// Close stops watching and closes the database
+func (r *Resolver) Close() error {
+	if r.watcher != nil {
+		_ = r.watcher.Close()
+	}
+	return r.db.Load().(dbHolder).Close()
+}
+
+// resolve looks up the client, attaches the location to ctx and counts it
+func (r *Resolver) resolve(ctx context.Context, ip net.IP) context.Context {
+	if ip == nil {
+		return ctx
+	}
+	location, _ := r.Lookup(ip)
+	requestCounter.Inc(r.config.Name,
+		r.label("country", location.Country),
+		r.label("region", location.Region),
+		r.label("isp", location.ISP),
+	)
+	return NewContext(ctx, location)
+}
+
+// label bounds distinct values of a metric label to MaxLabelValues
+func (r *Resolver) label(name, value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen, ok := r.labels[name]
+	if !ok {
+		seen = make(map[string]struct{})
+		r.labels[name] = seen
+	}
+	if _, ok := seen[value]; ok {
+		return value
+	}
+	if len(seen) >= r.config.MaxLabelValues {
+		return "other"
+	}
+	seen[value] = struct{}{}
+	return value
+}
+
+// Handler attaches the location of clients to request context, see
+// FromContext. Echo users can apply it with echo.WrapMiddleware.
+func (r *Resolver) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var forwarded []string
+		for _, value := range req.Header.Values("X-Forwarded-For") {
+			forwarded = append(forwarded, strings.Split(value, ",")...)
+		}
+		ip := r.clientIP(req.RemoteAddr, forwarded, req.Header.Get("X-Real-IP"))
+		next.ServeHTTP(w, req.WithContext(r.resolve(req.Context(), ip)))
+	})
+}
+
+// clientIP returns the peer at remoteAddr, or the client it forwards for if
+// it's a trusted proxy. The rightmost untrusted address of forwarded is the
+// client, as proxies append peers to it, and the left part can be forged.
+func (r *Resolver) clientIP(remoteAddr string, forwarded []string, realIP string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !r.isTrusted(ip) {
+		return ip
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !r.isTrusted(hop) {
+			return hop
+		}
+	}
+	if len(forwarded) == 0 {
+		if real := net.ParseIP(strings.TrimSpace(realIP)); real != nil {
+			return real
+		}
+	}
+	return ip
+}
+
+func (r *Resolver) isTrusted(ip net.IP) bool {
+	for _, ipNet := range r.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testDatabase = `# start,end,country,region,city,isp
+1.0.0.0,1.0.0.255,CN,Hubei,Wuhan,Telecom
+1.0.2.0,1.0.3.255,CN,Beijing,Beijing,Unicom
+2001:db8::,2001:db8::ffff,US,California,San Jose,Example
+`
+
+func TestOpenCSV(t *testing.T) {
+	db, err := parseCSV(strings.NewReader(testDatabase))
+	assert.Nil(t, err)
+
+	location, ok := db.Lookup(net.ParseIP("1.0.3.1"))
+	assert.True(t, ok)
+	assert.Equal(t, Location{Country: "CN", Region: "Beijing", City: "Beijing", ISP: "Unicom"}, location)
+	location, ok = db.Lookup(net.ParseIP("2001:db8::1"))
+	assert.True(t, ok)
+	assert.Equal(t, "US", location.Country)
+	for _, ip := range []string{"1.0.1.1", "0.255.255.255", "1.0.4.0", "2001:db9::"} {
+		_, ok = db.Lookup(net.ParseIP(ip))
+		assert.False(t, ok, ip)
+	}
+
+	_, err = parseCSV(strings.NewReader("1.0.0.0,1.0.0.255,CN,,,\n1.0.0.128,1.0.1.0,CN,,,\n"))
+	assert.NotNil(t, err)
+	_, err = parseCSV(strings.NewReader("1.0.0.9,1.0.0.1,CN,,,\n"))
+	assert.NotNil(t, err)
+}
+
+func newTestResolver(t *testing.T, watch bool) (*Resolver, string) {
+	dir, err := ioutil.TempDir("", "geoip")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "geoip.csv")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(testDatabase), 0644))
+
+	config := DefaultConfig()
+	config.Path = path
+	config.Watch = watch
+	config.TrustedProxies = []string{"10.0.0.0/8", "127.0.0.1"}
+	config.MaxLabelValues = 1
+	return config.Build(), dir
+}
+
+func TestResolver_Handler(t *testing.T) {
+	r, dir := newTestResolver(t, false)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+
+	serve := func(remoteAddr string, header map[string]string) Location {
+		var location Location
+		handler := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			location, _ = FromContext(req.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return location
+	}
+
+	assert.Equal(t, "Wuhan", serve("1.0.0.1:1234", nil).City)
+	// headers of untrusted peers are ignored
+	assert.Equal(t, "1.0.0.1", serve("1.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.0.2.1"}).IP)
+	// the rightmost untrusted hop is the client
+	assert.Equal(t, "1.0.2.1", serve("10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.0.0.1, 1.0.2.1, 10.0.0.2"}).IP)
+	assert.Equal(t, "1.0.2.1", serve("127.0.0.1:1234", map[string]string{"X-Real-IP": "1.0.2.1"}).IP)
+
+	// label values are bounded
+	assert.Equal(t, "CN", r.label("country", "CN"))
+	assert.Equal(t, "other", r.label("country", "US"))
+	assert.Equal(t, "unknown", r.label("country", ""))
+}
+
+func TestResolver_Reload(t *testing.T) {
+	r, dir := newTestResolver(t, true)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+
+	location, _ := r.Lookup(net.ParseIP("1.0.0.1"))
+	assert.Equal(t, "Telecom", location.ISP)
+
+	// a replaced file is reloaded
+	tmp := filepath.Join(dir, "geoip.csv.tmp")
+	assert.Nil(t, ioutil.WriteFile(tmp, []byte("1.0.0.0,1.0.0.255,CN,Hubei,Wuhan,Mobile\n"), 0644))
+	assert.Nil(t, os.Rename(tmp, filepath.Join(dir, "geoip.csv")))
+	assert.Eventually(t, func() bool {
+		location, _ := r.Lookup(net.ParseIP("1.0.0.1"))
+		return location.ISP == "Mobile"
+	}, time.Second*3, time.Millisecond*10)
+
+	// an invalid file keeps the current database
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "geoip.csv"), []byte("invalid"), 0644))
+	assert.NotNil(t, r.Reload())
+	location, _ = r.Lookup(net.ParseIP("1.0.0.1"))
+	assert.Equal(t, "Mobile", location.ISP)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package geoip
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// UnaryServerInterceptor attaches the location of clients to request
+// context, x-forwarded-for and x-real-ip metadata of trusted proxies are
+// respected like headers of Handler.
+func (r *Resolver) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(r.resolveGRPC(ctx), req)
+	}
+}
+
+// StreamServerInterceptor ...
+func (r *Resolver) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextedServerStream{ServerStream: ss, ctx: r.resolveGRPC(ss.Context())})
+	}
+}
+
+func (r *Resolver) resolveGRPC(ctx context.Context) context.Context {
+	pr, ok := peer.FromContext(ctx)
+	if !ok || pr.Addr == nil {
+		return ctx
+	}
+	var forwarded []string
+	var realIP string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, value := range md.Get("x-forwarded-for") {
+			forwarded = append(forwarded, strings.Split(value, ",")...)
+		}
+		if values := md.Get("x-real-ip"); len(values) > 0 {
+			realIP = values[0]
+		}
+	}
+	return r.resolve(ctx, r.clientIP(pr.Addr.String(), forwarded, realIP))
+}
+
+// contextedServerStream overrides the context of the wrapped stream
+type contextedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context ...
+func (ss *contextedServerStream) Context() context.Context {
+	return ss.ctx
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package governor
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// OperatorHeader identifies the operator of governor requests, basic auth
+// user is used if it's absent
+const OperatorHeader = "X-Operator"
+
+// AuditRecord is the record of a governor request mutating state, e.g.
+// switching maintenance mode
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	App        string    `json:"app"`
+	AppID      string    `json:"appId"`
+	Host       string    `json:"host"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Query      string    `json:"query"`
+	Operator   string    `json:"operator"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Status     int       `json:"status"`
+}
+
+// Auditor saves audit records, e.g. etcd registry with Audit enabled
+type Auditor interface {
+	Audit(ctx context.Context, record *AuditRecord) error
+}
+
+var (
+	auditors     sync.Map
+	auditTimeout = 3 * time.Second
+	auditLogger  = xlog.JupiterLogger.With(xlog.FieldMod(ModName))
+)
+
+// RegisterAuditor adds auditor to which records are pushed
+func RegisterAuditor(auditor Auditor) {
+	auditors.Store(auditor, struct{}{})
+}
+
+// UnregisterAuditor ...
+func UnregisterAuditor(auditor Auditor) {
+	auditors.Delete(auditor)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// audit records requests other than GET, HEAD and OPTIONS after they're served
+func audit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			handler(w, r)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		handler(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		operator := r.Header.Get(OperatorHeader)
+		if operator == "" {
+			operator, _, _ = r.BasicAuth()
+		}
+		record := &AuditRecord{
+			Time:       time.Now(),
+			App:        pkg.Name(),
+			AppID:      pkg.AppID(),
+			Host:       pkg.HostName(),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.RawQuery,
+			Operator:   operator,
+			RemoteAddr: r.RemoteAddr,
+			Status:     sw.status,
+		}
+		auditLogger.Info("governor audit",
+			xlog.String("method", record.Method), xlog.String("path", record.Path),
+			xlog.String("query", record.Query), xlog.String("operator", record.Operator),
+			xlog.String("remoteAddr", record.RemoteAddr), xlog.Int("status", record.Status),
+		)
+		auditors.Range(func(key, _ interface{}) bool {
+			auditor := key.(Auditor)
+			xgo.Go(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+				defer cancel()
+				if err := auditor.Audit(ctx, record); err != nil {
+					auditLogger.Warn("push governor audit", xlog.FieldErr(err), xlog.String("path", record.Path))
+				}
+			})
+			return true
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package governor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type chanAuditor chan *AuditRecord
+
+func (c chanAuditor) Audit(ctx context.Context, record *AuditRecord) error {
+	c <- record
+	return nil
+}
+
+func TestAudit(t *testing.T) {
+	auditor := make(chanAuditor, 1)
+	RegisterAuditor(auditor)
+	defer UnregisterAuditor(auditor)
+
+	handler := audit(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusAccepted)
+		}
+	})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/maintenance", nil))
+	assert.Len(t, auditor, 0, "reads are not audited")
+
+	req := httptest.NewRequest(http.MethodPost, "/maintenance?enable=true", nil)
+	req.Header.Set(OperatorHeader, "alice")
+	handler(httptest.NewRecorder(), req)
+	record := <-auditor
+	assert.Equal(t, "/maintenance", record.Path)
+	assert.Equal(t, "enable=true", record.Query)
+	assert.Equal(t, "alice", record.Operator)
+	assert.Equal(t, http.StatusAccepted, record.Status)
+
+	req = httptest.NewRequest(http.MethodDelete, "/maintenance", nil)
+	req.SetBasicAuth("bob", "secret")
+	handler(httptest.NewRecorder(), req)
+	record = <-auditor
+	assert.Equal(t, "bob", record.Operator)
+	assert.Equal(t, http.StatusOK, record.Status)
+}
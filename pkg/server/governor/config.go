@@ -6,8 +6,13 @@ import (
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/util/xnet"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 )
 
+func init() {
+	xschema.RegisterConfig("server.governor", "jupiter.server.*", "governor server of metrics, pprof and control endpoints", DefaultConfig)
+}
+
 //ModName ..
 const ModName = "govern"
 
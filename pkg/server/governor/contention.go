@@ -0,0 +1,231 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package governor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultContentionSeconds = 10
+	maxContentionSeconds     = 60
+	defaultContentionTop     = 20
+)
+
+// contentionRunning guards that only one contention profiling runs at a time
+var contentionRunning int32
+
+// ContentionRecord is a contended call site within the profiling window
+type ContentionRecord struct {
+	Site  string `json:"site"`
+	Count int64  `json:"count"`
+	// Delay is the total waiting time in nanoseconds
+	Delay time.Duration `json:"delay"`
+	Stack []string      `json:"stack"`
+}
+
+// ContentionReport ...
+type ContentionReport struct {
+	Type    string             `json:"type"`
+	Rate    int                `json:"rate"`
+	Seconds int                `json:"seconds"`
+	Records []ContentionRecord `json:"records"`
+}
+
+func init() {
+	// 在限定时间内开启mutex/block采样, 返回竞争最激烈的调用点
+	// e.g. /debug/contention?type=mutex&rate=5&seconds=10&top=20
+	HandleFunc("/debug/contention", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		typ := query.Get("type")
+		if typ == "" {
+			typ = "mutex"
+		}
+		if typ != "mutex" && typ != "block" {
+			http.Error(w, "type must be mutex or block", http.StatusBadRequest)
+			return
+		}
+		rate := queryInt(query.Get("rate"), 1)
+		seconds := queryInt(query.Get("seconds"), defaultContentionSeconds)
+		if seconds <= 0 || seconds > maxContentionSeconds {
+			http.Error(w, fmt.Sprintf("seconds must be in (0, %d]", maxContentionSeconds), http.StatusBadRequest)
+			return
+		}
+		if rate <= 0 {
+			http.Error(w, "rate must be positive", http.StatusBadRequest)
+			return
+		}
+
+		if !atomic.CompareAndSwapInt32(&contentionRunning, 0, 1) {
+			http.Error(w, "contention profiling is running", http.StatusConflict)
+			return
+		}
+		defer atomic.StoreInt32(&contentionRunning, 0)
+
+		records := profileContention(typ, rate, time.Duration(seconds)*time.Second, r.Context().Done())
+		top := queryInt(query.Get("top"), defaultContentionTop)
+		if top > 0 && len(records) > top {
+			records = records[:top]
+		}
+
+		encoder := json.NewEncoder(w)
+		if query.Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(ContentionReport{
+			Type:    typ,
+			Rate:    rate,
+			Seconds: seconds,
+			Records: records,
+		})
+	})
+}
+
+// profileContention enables sampling for duration, returns contended call sites
+// within the window sorted by delay
+func profileContention(typ string, rate int, duration time.Duration, done <-chan struct{}) []ContentionRecord {
+	var read func() []runtime.BlockProfileRecord
+	switch typ {
+	case "mutex":
+		read = readProfile(runtime.MutexProfile)
+		prev := runtime.SetMutexProfileFraction(rate)
+		defer runtime.SetMutexProfileFraction(prev)
+	default:
+		read = readProfile(runtime.BlockProfile)
+		runtime.SetBlockProfileRate(rate)
+		defer runtime.SetBlockProfileRate(0)
+	}
+
+	before := read()
+	select {
+	case <-time.After(duration):
+	case <-done:
+	}
+	return diffContention(before, read())
+}
+
+func readProfile(fn func([]runtime.BlockProfileRecord) (int, bool)) func() []runtime.BlockProfileRecord {
+	return func() []runtime.BlockProfileRecord {
+		n, _ := fn(nil)
+		for {
+			// leave room for records added in between
+			records := make([]runtime.BlockProfileRecord, n+50)
+			if n, ok := fn(records); ok {
+				return records[:n]
+			}
+			n, _ = fn(nil)
+		}
+	}
+}
+
+func diffContention(before, after []runtime.BlockProfileRecord) []ContentionRecord {
+	type base struct{ count, cycles int64 }
+	var bases = make(map[[32]uintptr]base, len(before))
+	for _, record := range before {
+		bases[record.Stack0] = base{count: record.Count, cycles: record.Cycles}
+	}
+
+	cps := cyclesPerSecond()
+	var records = make([]ContentionRecord, 0)
+	for _, record := range after {
+		b := bases[record.Stack0]
+		count, cycles := record.Count-b.count, record.Cycles-b.cycles
+		if count <= 0 {
+			continue
+		}
+		stack := frames(record.Stack())
+		records = append(records, ContentionRecord{
+			Site:  site(stack),
+			Count: count,
+			Delay: time.Duration(float64(cycles) / cps * float64(time.Second)),
+			Stack: stack,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Delay > records[j].Delay
+	})
+	return records
+}
+
+func frames(pcs []uintptr) []string {
+	var ret = make([]string, 0, len(pcs))
+	iter := runtime.CallersFrames(pcs)
+	for {
+		frame, more := iter.Next()
+		ret = append(ret, fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line))
+		if !more {
+			return ret
+		}
+	}
+}
+
+// site returns the first frame outside runtime and sync packages
+func site(stack []string) string {
+	for _, frame := range stack {
+		if !strings.HasPrefix(frame, "runtime.") && !strings.HasPrefix(frame, "sync.") {
+			return frame
+		}
+	}
+	if len(stack) > 0 {
+		return stack[0]
+	}
+	return ""
+}
+
+var (
+	cpsOnce sync.Once
+	cps     float64
+)
+
+// cyclesPerSecond reads the cycles rate of contention profiles from the
+// text format of mutex profile, which starts with "cycles/second=..."
+func cyclesPerSecond() float64 {
+	cpsOnce.Do(func() {
+		cps = 1e9
+		var buf bytes.Buffer
+		_ = pprof.Lookup("mutex").WriteTo(&buf, 1)
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(line, "cycles/second=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(line, "cycles/second="), 64); err == nil && v > 0 {
+					cps = v
+				}
+				return
+			}
+		}
+	})
+	return cps
+}
+
+func queryInt(val string, def int) int {
+	if val == "" {
+		return def
+	}
+	v, err := strconv.Atoi(val)
+	if err != nil {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package governor
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//go:noinline
+func contend(mu *sync.Mutex, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		mu.Lock()
+		time.Sleep(time.Microsecond * 100)
+		mu.Unlock()
+	}
+}
+
+func TestProfileContention(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		stop = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			contend(&mu, stop)
+		}()
+	}
+
+	records := profileContention("mutex", 1, time.Millisecond*200, nil)
+	close(stop)
+	wg.Wait()
+
+	assert.NotEmpty(t, records)
+	var found bool
+	for _, record := range records {
+		if strings.Contains(record.Site, "contend") {
+			found = true
+			assert.True(t, record.Count > 0)
+			assert.True(t, record.Delay > 0)
+		}
+	}
+	assert.True(t, found)
+}
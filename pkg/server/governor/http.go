@@ -50,9 +50,9 @@ func init() {
 	}
 }
 
-// HandleFunc ...
+// HandleFunc registers handler of pattern, requests mutating state are audited
 func HandleFunc(pattern string, handler http.HandlerFunc) {
 	// todo: 增加安全管控
-	DefaultServeMux.HandleFunc(pattern, handler)
+	DefaultServeMux.HandleFunc(pattern, audit(handler))
 	routes = append(routes, pattern)
 }
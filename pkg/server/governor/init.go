@@ -18,9 +18,12 @@ import (
 	"encoding/json"
 	"github.com/douyu/jupiter/pkg"
 	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/xschema"
 	jsoniter "github.com/json-iterator/go"
+	"math"
 	"net/http"
 	"os"
+	"time"
 )
 
 func init() {
@@ -32,6 +35,69 @@ func init() {
 		encoder.Encode(conf.Traverse("."))
 	})
 
+	// e.g. POST /configs/overrides?key=jupiter.client.user.timeout&value="500ms"&ttl=10m
+	// value is parsed as json, falls back to a plain string
+	HandleFunc("/configs/overrides", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch r.Method {
+		case http.MethodPost, http.MethodPut:
+			ttl, err := time.ParseDuration(query.Get("ttl"))
+			if err != nil || query.Get("key") == "" {
+				http.Error(w, "key and ttl required", http.StatusBadRequest)
+				return
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(query.Get("value")), &value); err != nil {
+				value = query.Get("value")
+			}
+			// integers are int64 like those decoded from toml
+			if f, ok := value.(float64); ok && f == math.Trunc(f) {
+				value = int64(f)
+			}
+			if err := conf.Override(query.Get("key"), value, ttl); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			if !conf.Revert(query.Get("key")) {
+				http.Error(w, "override not found", http.StatusNotFound)
+				return
+			}
+		}
+		encoder := json.NewEncoder(w)
+		if query.Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(conf.Overrides())
+	})
+
+	// schema and sample config of components imported by the binary
+	HandleFunc("/configs/schema", func(w http.ResponseWriter, r *http.Request) {
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(xschema.JSONSchema(xschema.Components()))
+	})
+
+	HandleFunc("/configs/sample", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(xschema.Sample(xschema.Components())))
+	})
+
+	// unknown keys, deprecated keys and mistyped values of current config
+	HandleFunc("/configs/validate", func(w http.ResponseWriter, r *http.Request) {
+		var errs = make([]string, 0)
+		for _, err := range xschema.Validate(map[string]interface{}{"jupiter": conf.GetStringMap("jupiter")}) {
+			errs = append(errs, err.Error())
+		}
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(errs)
+	})
+
 	HandleFunc("/debug/env", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		_ = jsoniter.NewEncoder(w).Encode(os.Environ())
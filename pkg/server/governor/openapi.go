@@ -0,0 +1,43 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package governor
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// openAPI is the document set by SetOpenAPI
+var openAPI atomic.Value // []byte
+
+func init() {
+	// OpenAPI文档, jupiter client据此生成客户端
+	HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		spec, _ := openAPI.Load().([]byte)
+		if len(spec) == 0 {
+			http.Error(w, "no openapi document", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(spec)
+	})
+}
+
+// SetOpenAPI serves spec, an OpenAPI 3 or Swagger 2 document in JSON, e.g.
+// generated by swag, on /openapi.json, from which `jupiter client`
+// generates typed clients of the service
+func SetOpenAPI(spec []byte) {
+	openAPI.Store(spec)
+}
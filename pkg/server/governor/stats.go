@@ -0,0 +1,47 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package governor
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/xstat"
+)
+
+func init() {
+	// 最近10s各方法的QPS, 错误率和延迟分位数
+	// e.g. /stats?type=unary&method=Hello
+	HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var snapshots = make([]xstat.MethodSnapshot, 0)
+		for _, snapshot := range xstat.MethodSnapshots() {
+			if typ := query.Get("type"); typ != "" && snapshot.Type != typ {
+				continue
+			}
+			if method := query.Get("method"); method != "" && !strings.Contains(snapshot.Method, method) {
+				continue
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+
+		encoder := json.NewEncoder(w)
+		if query.Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(snapshots)
+	})
+}
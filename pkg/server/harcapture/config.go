@@ -0,0 +1,93 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package harcapture
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xdiag"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("server.harcapture", "jupiter.harcapture.*", "http traffic capture to HAR files", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Name of the recorder on governor /debug/har
+	Name string
+	// Enable starts capturing once built, otherwise capturing is started on
+	// governor POST /debug/har?name=<Name>&enable=true
+	Enable bool
+	// Duration bounds each capturing session, capturing stops after it
+	Duration time.Duration
+	// SampleRate of requests captured, in [0, 1]
+	SampleRate float64
+	// Paths are prefixes of captured paths, all paths if empty
+	Paths []string
+	// MaxEntries is the number of latest entries kept
+	MaxEntries int
+	// MaxBodySize truncates request and response bodies in bytes, truncated
+	// json and form bodies are omitted as they can't be redacted
+	MaxBodySize int
+	// RedactKeys are header, query, json and form keys whose values are
+	// masked, case insensitive
+	RedactKeys []string
+
+	logger *xlog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Duration:    time.Minute * 10,
+		SampleRate:  0.1,
+		MaxEntries:  200,
+		MaxBodySize: 64 * 1024,
+		RedactKeys:  xdiag.DefaultConfig().RedactKeys,
+		logger:      xlog.JupiterLogger.With(xlog.FieldMod("server.harcapture")),
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.harcapture." + name)
+	if config.Name == "" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("harcapture parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Recorder {
+	return newRecorder(config)
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package harcapture
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+)
+
+var recorders sync.Map
+
+func init() {
+	// e.g. POST /debug/har?name=api&enable=true&duration=5m to start, then
+	// GET /debug/har?name=api downloads the HAR file
+	governor.HandleFunc("/debug/har", handle)
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name := query.Get("name")
+	if name == "" {
+		var statuses = make([]Status, 0)
+		recorders.Range(func(_, value interface{}) bool {
+			statuses = append(statuses, value.(*Recorder).Status())
+			return true
+		})
+		sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+		_ = json.NewEncoder(w).Encode(statuses)
+		return
+	}
+
+	value, ok := recorders.Load(name)
+	if !ok {
+		http.Error(w, "recorder not found", http.StatusNotFound)
+		return
+	}
+	recorder := value.(*Recorder)
+	switch r.Method {
+	case http.MethodPost:
+		enable, err := strconv.ParseBool(query.Get("enable"))
+		if err != nil {
+			http.Error(w, "enable must be true or false", http.StatusBadRequest)
+			return
+		}
+		if !enable {
+			recorder.Stop()
+			break
+		}
+		duration := recorder.config.Duration
+		if v := query.Get("duration"); v != "" {
+			if duration, err = time.ParseDuration(v); err != nil || duration < 0 {
+				http.Error(w, "invalid duration", http.StatusBadRequest)
+				return
+			}
+		}
+		recorder.Start(duration)
+	case http.MethodDelete:
+		recorder.Reset()
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.har"`,
+			url.PathEscape(name), time.Now().Format("20060102150405")))
+		_ = json.NewEncoder(w).Encode(recorder.HAR())
+		return
+	}
+	_ = json.NewEncoder(w).Encode(recorder.Status())
+}
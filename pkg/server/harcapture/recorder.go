@@ -0,0 +1,425 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package harcapture captures sampled requests and responses of http servers
+// to HAR files, so that exact payloads can be replayed in browsers or tools
+// when debugging serialization issues. Capturing is bounded by Duration and
+// MaxEntries, values of sensitive keys are masked by RedactKeys. Echo users
+// can apply Recorder.Handler with echo.WrapMiddleware.
+package harcapture
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/douyu/jupiter/pkg/util/xrand"
+	"github.com/douyu/jupiter/pkg/xdiag"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// Recorder keeps the latest MaxEntries captured entries of a server
+type Recorder struct {
+	config *Config
+	redact *xdiag.Redactor
+
+	mu     sync.Mutex
+	active bool
+	// until is when capturing stops, zero means no limit
+	until   time.Time
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// Status is the state of a recorder on governor
+type Status struct {
+	Name    string     `json:"name"`
+	Active  bool       `json:"active"`
+	Until   *time.Time `json:"until,omitempty"`
+	Entries int        `json:"entries"`
+}
+
+func newRecorder(config *Config) *Recorder {
+	if config.Name == "" {
+		config.Name = "default"
+	}
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultConfig().MaxEntries
+	}
+	r := &Recorder{
+		config:  config,
+		redact:  xdiag.NewRedactor(config.RedactKeys),
+		entries: make([]Entry, config.MaxEntries),
+	}
+	if config.Enable {
+		r.Start(config.Duration)
+	}
+	recorders.Store(config.Name, r)
+	return r
+}
+
+// Start captures requests for d, 0 means until Stop
+func (r *Recorder) Start(d time.Duration) {
+	r.mu.Lock()
+	r.active = true
+	r.until = time.Time{}
+	if d > 0 {
+		r.until = time.Now().Add(d)
+	}
+	r.mu.Unlock()
+	r.config.logger.Warn("har capture started", xlog.FieldName(r.config.Name), xlog.Duration("duration", d))
+}
+
+// Stop stops capturing, captured entries are kept
+func (r *Recorder) Stop() {
+	r.mu.Lock()
+	active := r.active
+	r.active = false
+	r.mu.Unlock()
+	if active {
+		r.config.logger.Info("har capture stopped", xlog.FieldName(r.config.Name))
+	}
+}
+
+// Active reports whether requests are being captured
+func (r *Recorder) Active() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.activeLocked()
+}
+
+func (r *Recorder) activeLocked() bool {
+	if r.active && !r.until.IsZero() && !time.Now().Before(r.until) {
+		r.active = false
+		r.config.logger.Info("har capture expired", xlog.FieldName(r.config.Name))
+	}
+	return r.active
+}
+
+// Reset drops captured entries
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.entries = make([]Entry, len(r.entries))
+	r.next, r.full = 0, false
+	r.mu.Unlock()
+}
+
+// Entries returns captured entries oldest first
+func (r *Recorder) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]Entry{}, r.entries[:r.next]...)
+	}
+	return append(append([]Entry{}, r.entries[r.next:]...), r.entries[:r.next]...)
+}
+
+// HAR returns captured entries as a HAR file
+func (r *Recorder) HAR() *HAR {
+	return newHAR(r.Entries())
+}
+
+// Status ...
+func (r *Recorder) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := Status{Name: r.config.Name, Active: r.activeLocked(), Entries: r.next}
+	if r.full {
+		status.Entries = len(r.entries)
+	}
+	if status.Active && !r.until.IsZero() {
+		until := r.until
+		status.Until = &until
+	}
+	return status
+}
+
+func (r *Recorder) add(entry Entry) {
+	r.mu.Lock()
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+func (r *Recorder) selected(path string) bool {
+	if !r.Active() {
+		return false
+	}
+	if len(r.config.Paths) > 0 {
+		var matched bool
+		for _, prefix := range r.config.Paths {
+			if strings.HasPrefix(path, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return r.config.SampleRate >= 1 || xrand.Float64() < r.config.SampleRate
+}
+
+// Handler captures requests served by next
+func (r *Recorder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		exchange := r.Begin(req)
+		if exchange == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		rec := &recordingWriter{ResponseWriter: w, exchange: exchange}
+		next.ServeHTTP(rec, req)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		exchange.Finish(rec.status, w.Header())
+	})
+}
+
+// Exchange is a request being captured, see Recorder.Begin
+type Exchange struct {
+	recorder *Recorder
+	req      *http.Request
+	start    time.Time
+
+	reqBody      []byte
+	reqTruncated bool
+	body         bytes.Buffer
+	size         int64
+}
+
+// Begin starts capturing req if it's selected, nil otherwise. The body of
+// req is buffered up to MaxBodySize and replayed to handlers. Frameworks
+// not serving http.Handler call Exchange.Write with response bodies and
+// Exchange.Finish once served.
+func (r *Recorder) Begin(req *http.Request) *Exchange {
+	if !r.selected(req.URL.Path) {
+		return nil
+	}
+	e := &Exchange{recorder: r, req: req, start: time.Now()}
+	if req.Body != nil && req.Body != http.NoBody {
+		// read errors are left to handlers, which read the rest of body
+		buf, _ := ioutil.ReadAll(io.LimitReader(req.Body, int64(r.config.MaxBodySize)+1))
+		req.Body = replayedBody{Reader: io.MultiReader(bytes.NewReader(buf), req.Body), Closer: req.Body}
+		if len(buf) > r.config.MaxBodySize {
+			buf, e.reqTruncated = buf[:r.config.MaxBodySize], true
+		}
+		e.reqBody = buf
+	}
+	return e
+}
+
+// Wrap returns w teeing response bodies to e
+func (e *Exchange) Wrap(w http.ResponseWriter) http.ResponseWriter {
+	return &recordingWriter{ResponseWriter: w, exchange: e}
+}
+
+// Write records p as part of the response body
+func (e *Exchange) Write(p []byte) {
+	e.size += int64(len(p))
+	if room := e.recorder.config.MaxBodySize - e.body.Len(); room > 0 {
+		if len(p) > room {
+			p = p[:room]
+		}
+		e.body.Write(p)
+	}
+}
+
+// Finish records the exchange with the status and header of the response
+func (e *Exchange) Finish(status int, header http.Header) {
+	var r, req = e.recorder, e.req
+	elapsed := float64(time.Since(e.start)) / float64(time.Millisecond)
+	entry := Entry{
+		StartedDateTime: e.start,
+		Time:            elapsed,
+		Request: Request{
+			Method:      req.Method,
+			HTTPVersion: req.Proto,
+			Cookies:     []NameValue{},
+			Headers:     r.headers(req.Header),
+			HeadersSize: -1,
+			BodySize:    int64(len(e.reqBody)),
+		},
+		Response: Response{
+			Status:      status,
+			StatusText:  http.StatusText(status),
+			HTTPVersion: req.Proto,
+			Cookies:     []NameValue{},
+			Headers:     r.headers(header),
+			Content: Content{
+				Size:     e.size,
+				MimeType: header.Get("Content-Type"),
+			},
+			RedirectURL: header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    e.size,
+		},
+		Timings: Timings{Send: 0, Wait: elapsed, Receive: 0},
+		TraceID: trace.ExtractTraceID(req.Context()),
+	}
+	entry.Request.URL, entry.Request.QueryString = r.url(req)
+	if e.reqTruncated {
+		entry.Request.BodySize = req.ContentLength
+	}
+	if len(e.reqBody) > 0 {
+		mimeType := req.Header.Get("Content-Type")
+		text, comment := r.body(mimeType, e.reqBody, e.reqTruncated)
+		entry.Request.PostData = &PostData{MimeType: mimeType, Text: text, Comment: comment}
+	}
+	entry.Response.Content.Text, entry.Response.Content.Comment = r.body(entry.Response.Content.MimeType, e.body.Bytes(), e.size > int64(e.body.Len()))
+	r.add(entry)
+}
+
+// url returns the url of req and its query string, with values masked
+func (r *Recorder) url(req *http.Request) (string, []NameValue) {
+	var query = req.URL.Query()
+	var queryString = make([]NameValue, 0, len(query))
+	var masked = make(url.Values, len(query))
+	for _, key := range sortedKeys(query) {
+		for _, value := range query[key] {
+			value = r.redact.Mask(key, value)
+			queryString = append(queryString, NameValue{Name: key, Value: value})
+			masked.Add(key, value)
+		}
+	}
+	u := *req.URL
+	u.Scheme, u.Host, u.User = "http", req.Host, nil
+	if req.TLS != nil {
+		u.Scheme = "https"
+	}
+	u.RawQuery = masked.Encode()
+	return u.String(), queryString
+}
+
+func (r *Recorder) headers(header http.Header) []NameValue {
+	var ret = make([]NameValue, 0, len(header))
+	for _, name := range sortedKeys(header) {
+		// cookies set by responses are as sensitive as the ones sent
+		key := name
+		if strings.EqualFold(key, "Set-Cookie") {
+			key = "Cookie"
+		}
+		for _, value := range header[name] {
+			ret = append(ret, NameValue{Name: name, Value: r.redact.Mask(key, value)})
+		}
+	}
+	return ret
+}
+
+// body returns the text of a body with values masked, bodies which can't be
+// masked are omitted if they mention any of RedactKeys
+func (r *Recorder) body(mimeType string, data []byte, truncated bool) (string, string) {
+	if len(data) == 0 {
+		return "", ""
+	}
+	var comment string
+	if truncated {
+		comment = "truncated to MaxBodySize"
+	}
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if masked, ok := r.redact.JSON(data); ok && !truncated {
+			return string(masked), comment
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(data)); err == nil && !truncated {
+			for key, vals := range values {
+				for i := range vals {
+					vals[i] = r.redact.Mask(key, vals[i])
+				}
+			}
+			return values.Encode(), comment
+		}
+	case strings.HasPrefix(mediaType, "text/"), mediaType == "application/xml",
+		strings.HasSuffix(mediaType, "+xml"), mediaType == "application/javascript":
+	default:
+		return "", "binary body omitted"
+	}
+	if r.redact.Mentioned(string(data)) {
+		return "", "body mentioning redacted keys omitted"
+	}
+	return string(data), comment
+}
+
+func sortedKeys(m map[string][]string) []string {
+	var keys = make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// replayedBody replays the buffered part of a request body before the rest
+type replayedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// recordingWriter tees response bodies to the exchange
+type recordingWriter struct {
+	http.ResponseWriter
+	exchange *Exchange
+	status   int
+}
+
+// WriteHeader ...
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write ...
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.exchange.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush ...
+func (w *recordingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack ...
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	// the response is written to the connection by the hijacker, which is
+	// switching protocols mostly
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package harcapture
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRecorder(name string) *Recorder {
+	config := DefaultConfig()
+	config.Name = name
+	config.Enable = true
+	config.SampleRate = 1
+	config.MaxEntries = 2
+	config.MaxBodySize = 64
+	config.Paths = []string{"/api/"}
+	return config.Build()
+}
+
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+	w.Header().Set("Set-Cookie", "session=abc")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(body)
+})
+
+func serve(handler http.Handler, method, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRecorder_Handler(t *testing.T) {
+	recorder := newTestRecorder("handler")
+	handler := recorder.Handler(echoHandler)
+
+	// handlers read the whole body
+	rec := serve(handler, http.MethodPost, "/api/users?token=t1&page=1", "application/json", `{"name":"jupiter","password":"p","id":12345678901234567890}`)
+	assert.Equal(t, `{"name":"jupiter","password":"p","id":12345678901234567890}`, rec.Body.String())
+
+	entries := recorder.Entries()
+	assert.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, "http://example.com/api/users?page=1&token=%2A%2A%2A%2A%2A%2A", entry.Request.URL)
+	assert.Equal(t, []NameValue{{"page", "1"}, {"token", "******"}}, entry.Request.QueryString)
+	assert.Contains(t, entry.Request.Headers, NameValue{"Authorization", "******"})
+	assert.Equal(t, `{"id":12345678901234567890,"name":"jupiter","password":"******"}`, entry.Request.PostData.Text)
+	assert.Equal(t, http.StatusCreated, entry.Response.Status)
+	assert.Contains(t, entry.Response.Headers, NameValue{"Set-Cookie", "******"})
+	assert.Equal(t, entry.Request.PostData.Text, entry.Response.Content.Text)
+
+	// unmasked payloads are kept as is
+	serve(handler, http.MethodPost, "/api/users", "application/json", `{"b": 1, "a": 2}`)
+	assert.Equal(t, `{"b": 1, "a": 2}`, recorder.Entries()[1].Request.PostData.Text)
+
+	// truncated bodies can't be masked, omitted if they mention redacted keys
+	serve(handler, http.MethodPost, "/api/users", "application/json", `{"password":"`+strings.Repeat("p", 64)+`"}`)
+	entries = recorder.Entries()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "", entries[1].Request.PostData.Text)
+	assert.Equal(t, "body mentioning redacted keys omitted", entries[1].Request.PostData.Comment)
+	assert.Equal(t, int64(79), entries[1].Response.Content.Size)
+
+	serve(handler, http.MethodPost, "/api/users", "application/x-www-form-urlencoded", "name=jupiter&passwd=p")
+	assert.Equal(t, "name=jupiter&passwd=%2A%2A%2A%2A%2A%2A", recorder.Entries()[1].Request.PostData.Text)
+
+	serve(handler, http.MethodPost, "/api/users", "application/octet-stream", "\x00\x01")
+	assert.Equal(t, "binary body omitted", recorder.Entries()[1].Request.PostData.Comment)
+
+	// paths not selected and requests after stopping are not captured
+	recorder.Reset()
+	serve(handler, http.MethodGet, "/health", "", "")
+	recorder.Stop()
+	serve(handler, http.MethodGet, "/api/users", "", "")
+	assert.Len(t, recorder.Entries(), 0)
+
+	recorder.Start(time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+	assert.False(t, recorder.Active())
+}
+
+func TestRecorder_Hijack(t *testing.T) {
+	recorder := newTestRecorder("hijack")
+	ts := httptest.NewServer(recorder.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer conn.Close()
+		_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		_ = buf.Flush()
+	})))
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/ws", nil)
+	req.Header.Set("Upgrade", "test")
+	req.Header.Set("Connection", "Upgrade")
+	resp, err := http.DefaultClient.Do(req)
+	if assert.Nil(t, err) {
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	entries := recorder.Entries()
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, http.StatusSwitchingProtocols, entries[0].Response.Status)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	recorder := newTestRecorder("governor")
+	recorder.Stop()
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handle(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/debug/har?name=unknown").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/debug/har?name=governor&enable=true&duration=x").Code)
+
+	rec := do(http.MethodPost, "/debug/har?name=governor&enable=true&duration=1m")
+	var status Status
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.True(t, status.Active)
+	assert.NotNil(t, status.Until)
+
+	serve(recorder.Handler(echoHandler), http.MethodGet, "/api/users", "", "")
+	rec = do(http.MethodGet, "/debug/har?name=governor")
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), `filename="governor-`)
+	var har HAR
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &har))
+	assert.Equal(t, "1.2", har.Log.Version)
+	assert.Len(t, har.Log.Entries, 1)
+
+	do(http.MethodDelete, "/debug/har?name=governor")
+	assert.Len(t, recorder.Entries(), 0)
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpcache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+)
+
+// Backend stores encoded entries
+type Backend interface {
+	// Get returns false if key is missing or expired
+	Get(key string) ([]byte, bool, error)
+	Set(key string, val []byte, ttl time.Duration) error
+}
+
+type memoryItem struct {
+	key     string
+	val     []byte
+	expires time.Time
+}
+
+// MemoryBackend is a LRU backend in process
+type MemoryBackend struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	lru      *list.List
+	clock    xtime.Clock
+}
+
+// NewMemoryBackend ...
+func NewMemoryBackend(capacity int, clock xtime.Clock) *MemoryBackend {
+	if clock == nil {
+		clock = xtime.SystemClock
+	}
+	return &MemoryBackend{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+		clock:    clock,
+	}
+}
+
+// Get ...
+func (m *MemoryBackend) Get(key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	elem, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	item := elem.Value.(*memoryItem)
+	if !m.clock.Now().Before(item.expires) {
+		m.lru.Remove(elem)
+		delete(m.items, key)
+		return nil, false, nil
+	}
+	m.lru.MoveToFront(elem)
+	return item.val, true, nil
+}
+
+// Set ...
+func (m *MemoryBackend) Set(key string, val []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item := &memoryItem{key: key, val: val, expires: m.clock.Now().Add(ttl)}
+	if elem, ok := m.items[key]; ok {
+		elem.Value = item
+		m.lru.MoveToFront(elem)
+		return nil
+	}
+	m.items[key] = m.lru.PushFront(item)
+	for m.capacity > 0 && m.lru.Len() > m.capacity {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryItem).key)
+	}
+	return nil
+}
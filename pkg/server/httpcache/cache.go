@@ -0,0 +1,333 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpcache is a response cache middleware of net/http handlers
+// honoring Cache-Control and Vary, with stale-while-revalidate.
+// Echo users can apply it with echo.WrapMiddleware(cache.Handler), along
+// with Config.WithOrigin(e) since handlers wrapped by echo are bound to the
+// pooled context of the request.
+package httpcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	resultHit    = "hit"
+	resultStale  = "stale"
+	resultMiss   = "miss"
+	resultBypass = "bypass"
+)
+
+var cacheCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "server_http_cache_total",
+	Labels:    []string{"rule", "result"},
+}.Build()
+
+// entry is a cached response, or an index of variants if Vary is set
+type entry struct {
+	Index    bool          `json:"index,omitempty"`
+	Vary     []string      `json:"vary,omitempty"`
+	Status   int           `json:"status,omitempty"`
+	Header   http.Header   `json:"header,omitempty"`
+	Body     []byte        `json:"body,omitempty"`
+	Stored   time.Time     `json:"stored"`
+	TTL      time.Duration `json:"ttl"`
+	StaleTTL time.Duration `json:"staleTTL"`
+}
+
+// Cache ...
+type Cache struct {
+	config *Config
+	group  singleflight.Group
+}
+
+func newCache(config *Config) *Cache {
+	return &Cache{config: config}
+}
+
+// Handler wraps next with response caching
+func (c *Cache) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := c.config.rule(r.URL.Path)
+		reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+		if rule.Disable || (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+			reqCC.has("no-store") || r.Header.Get("Authorization") != "" {
+			cacheCounter.Inc(rule.Path, resultBypass)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		base := r.URL.RequestURI()
+		if !reqCC.has("no-cache") {
+			if e := c.lookup(base, r); e != nil {
+				age := c.config.clock.Since(e.Stored)
+				if age < e.TTL {
+					cacheCounter.Inc(rule.Path, resultHit)
+					c.serve(w, r, e, age, "HIT")
+					return
+				}
+				if age < e.TTL+e.StaleTTL {
+					cacheCounter.Inc(rule.Path, resultStale)
+					c.serve(w, r, e, age, "STALE")
+					c.revalidate(next, r, base, rule)
+					return
+				}
+			}
+		}
+
+		cacheCounter.Inc(rule.Path, resultMiss)
+		w.Header().Set("X-Cache", "MISS")
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK, max: c.config.MaxBodySize}
+		next.ServeHTTP(rec, r)
+		if r.Method == http.MethodGet {
+			c.store(base, r, rule, rec)
+		}
+	})
+}
+
+// revalidate refreshes the entry in background, at most once at a time per
+// key, with a copy of r sent to the origin as a fresh request if any, which
+// is stored by the middleware in the origin since it's sent with no-cache
+func (c *Cache) revalidate(next http.Handler, r *http.Request, base string, rule Rule) {
+	req := r.Clone(context.Background())
+	req.Method = http.MethodGet
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	xgo.Go(func() {
+		_, _, _ = c.group.Do(base, func() (interface{}, error) {
+			rec := &recorder{status: http.StatusOK, max: c.config.MaxBodySize}
+			if c.config.origin != nil {
+				req.Header.Set("Cache-Control", "no-cache")
+				c.config.origin.ServeHTTP(rec, req)
+				return nil, nil
+			}
+			next.ServeHTTP(rec, req)
+			c.store(base, req, rule, rec)
+			return nil, nil
+		})
+	})
+}
+
+func (c *Cache) lookup(base string, r *http.Request) *entry {
+	e := c.get(base)
+	if e != nil && e.Index {
+		e = c.get(variantKey(base, e.Vary, r))
+	}
+	return e
+}
+
+func (c *Cache) get(key string) *entry {
+	data, ok, err := c.config.backend.Get(key)
+	if err != nil {
+		c.config.logger.Error("get cache", xlog.FieldKey(key), xlog.FieldErr(err))
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil
+	}
+	return &e
+}
+
+func (c *Cache) set(key string, e *entry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if err := c.config.backend.Set(key, data, e.TTL+e.StaleTTL); err != nil {
+		c.config.logger.Error("set cache", xlog.FieldKey(key), xlog.FieldErr(err))
+	}
+}
+
+func (c *Cache) store(base string, r *http.Request, rule Rule, rec *recorder) {
+	header := rec.Header()
+	if rec.status != http.StatusOK || rec.overflow || header.Get("Set-Cookie") != "" {
+		return
+	}
+	respCC := parseCacheControl(header.Get("Cache-Control"))
+	if respCC.has("no-store") || respCC.has("private") || respCC.has("no-cache") {
+		return
+	}
+
+	ttl, staleTTL := rule.TTL, rule.StaleTTL
+	if v, ok := respCC.seconds("s-maxage"); ok {
+		ttl = v
+	} else if v, ok := respCC.seconds("max-age"); ok {
+		ttl = v
+	}
+	if v, ok := respCC.seconds("stale-while-revalidate"); ok {
+		staleTTL = v
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	var vary []string
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return
+			} else if name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(vary)
+
+	e := &entry{
+		Status:   rec.status,
+		Header:   header.Clone(),
+		Body:     rec.body.Bytes(),
+		Stored:   c.config.clock.Now(),
+		TTL:      ttl,
+		StaleTTL: staleTTL,
+	}
+	e.Header.Del("X-Cache")
+	if len(vary) == 0 {
+		c.set(base, e)
+		return
+	}
+	c.set(base, &entry{Index: true, Vary: vary, Stored: e.Stored, TTL: ttl, StaleTTL: staleTTL})
+	c.set(variantKey(base, vary, r), e)
+}
+
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, e *entry, age time.Duration, result string) {
+	for key, vals := range e.Header {
+		w.Header()[key] = vals
+	}
+	w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	w.Header().Set("X-Cache", result)
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.Body)
+	}
+}
+
+func variantKey(base string, vary []string, r *http.Request) string {
+	var sb strings.Builder
+	sb.WriteString(base)
+	for _, name := range vary {
+		sb.WriteString("\n")
+		sb.WriteString(name)
+		sb.WriteString(":")
+		sb.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return sb.String()
+}
+
+// recorder tees the response to the underlying writer if any, and buffers
+// the body up to max bytes
+type recorder struct {
+	http.ResponseWriter
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	max         int
+	overflow    bool
+}
+
+// Header ...
+func (rec *recorder) Header() http.Header {
+	if rec.ResponseWriter != nil {
+		return rec.ResponseWriter.Header()
+	}
+	if rec.header == nil {
+		rec.header = make(http.Header)
+	}
+	return rec.header
+}
+
+// WriteHeader ...
+func (rec *recorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.status = status
+	if rec.ResponseWriter != nil {
+		rec.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// Write ...
+func (rec *recorder) Write(p []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	if !rec.overflow {
+		if rec.max > 0 && rec.body.Len()+len(p) > rec.max {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(p)
+		}
+	}
+	if rec.ResponseWriter != nil {
+		return rec.ResponseWriter.Write(p)
+	}
+	return len(p), nil
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(val string) cacheControl {
+	var cc = make(cacheControl)
+	for _, part := range strings.Split(val, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if len(kv) == 2 {
+			cc[key] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		} else {
+			cc[key] = ""
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(key string) bool {
+	_, ok := cc[key]
+	return ok
+}
+
+func (cc cacheControl) seconds(key string) (time.Duration, bool) {
+	val, ok := cc[key]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
@@ -0,0 +1,230 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpcache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCache(clock *xtime.MockClock, rules ...Rule) *Cache {
+	config := DefaultConfig()
+	config.TTL = time.Minute
+	config.Rules = rules
+	config.clock = clock
+	return config.Build()
+}
+
+func do(h http.Handler, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestCache_HitAndExpire(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	var calls int32
+	h := newTestCache(clock).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=10")
+		fmt.Fprintf(w, "v%d", n)
+	}))
+
+	w := do(h, http.MethodGet, "/a", nil)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "v1", w.Body.String())
+
+	clock.Advance(3 * time.Second)
+	w = do(h, http.MethodGet, "/a", nil)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "3", w.Header().Get("Age"))
+	assert.Equal(t, "v1", w.Body.String())
+
+	w = do(h, http.MethodHead, "/a", nil)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Empty(t, w.Body.String())
+
+	// request no-cache skips lookup
+	w = do(h, http.MethodGet, "/a", http.Header{"Cache-Control": {"no-cache"}})
+	assert.Equal(t, "v2", w.Body.String())
+
+	clock.Advance(11 * time.Second)
+	w = do(h, http.MethodGet, "/a", nil)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "v3", w.Body.String())
+}
+
+func TestCache_NotCacheable(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	cases := []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) { w.Header().Set("Cache-Control", "no-store") },
+		func(w http.ResponseWriter) { w.Header().Set("Cache-Control", "private, max-age=10") },
+		func(w http.ResponseWriter) { w.Header().Set("Set-Cookie", "a=b") },
+		func(w http.ResponseWriter) { w.Header().Set("Vary", "*") },
+		func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) },
+	}
+	for i, setup := range cases {
+		var calls int32
+		h := newTestCache(clock).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			setup(w)
+			_, _ = w.Write([]byte("ok"))
+		}))
+		do(h, http.MethodGet, "/a", nil)
+		do(h, http.MethodGet, "/a", nil)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "case %d", i)
+	}
+}
+
+func TestCache_Bypass(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	var calls int32
+	h := newTestCache(clock, Rule{Path: "/nocache", Disable: true}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	do(h, http.MethodPost, "/a", nil)
+	do(h, http.MethodPost, "/a", nil)
+	do(h, http.MethodGet, "/b", http.Header{"Authorization": {"token"}})
+	do(h, http.MethodGet, "/b", http.Header{"Authorization": {"token"}})
+	do(h, http.MethodGet, "/nocache/x", nil)
+	do(h, http.MethodGet, "/nocache/x", nil)
+	assert.Equal(t, int32(6), atomic.LoadInt32(&calls))
+}
+
+func TestCache_Vary(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	var calls int32
+	h := newTestCache(clock).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+
+	zh := http.Header{"Accept-Language": {"zh"}}
+	en := http.Header{"Accept-Language": {"en"}}
+	assert.Equal(t, "zh", do(h, http.MethodGet, "/a", zh).Body.String())
+	assert.Equal(t, "en", do(h, http.MethodGet, "/a", en).Body.String())
+	assert.Equal(t, "zh", do(h, http.MethodGet, "/a", zh).Body.String())
+	assert.Equal(t, "en", do(h, http.MethodGet, "/a", en).Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestCache_StaleWhileRevalidate(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	var calls int32
+	revalidated := make(chan struct{}, 1)
+	h := newTestCache(clock, Rule{Path: "/swr", TTL: 10 * time.Second, StaleTTL: 30 * time.Second}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		fmt.Fprintf(w, "v%d", n)
+		if n > 1 {
+			revalidated <- struct{}{}
+		}
+	}))
+
+	assert.Equal(t, "v1", do(h, http.MethodGet, "/swr", nil).Body.String())
+	clock.Advance(20 * time.Second)
+	w := do(h, http.MethodGet, "/swr", nil)
+	assert.Equal(t, "STALE", w.Header().Get("X-Cache"))
+	assert.Equal(t, "v1", w.Body.String())
+
+	select {
+	case <-revalidated:
+	case <-time.After(time.Second):
+		t.Fatal("not revalidated")
+	}
+	// the store follows the handler, wait for it
+	assert.Eventually(t, func() bool {
+		w := do(h, http.MethodGet, "/swr", nil)
+		return w.Header().Get("X-Cache") == "HIT" && w.Body.String() == "v2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCache_RevalidateOrigin(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	config := DefaultConfig()
+	config.Rules = []Rule{{Path: "/swr", TTL: 10 * time.Second, StaleTTL: 30 * time.Second}}
+	config.clock = clock
+
+	e := echo.New()
+	var calls int32
+	revalidated := make(chan string, 1)
+	e.Use(echo.WrapMiddleware(config.WithOrigin(e).Build().Handler))
+	e.GET("/swr", func(c echo.Context) error {
+		n := atomic.AddInt32(&calls, 1)
+		if n > 1 {
+			revalidated <- c.Request().Header.Get("Cache-Control")
+		}
+		return c.String(http.StatusOK, fmt.Sprintf("v%d", n))
+	})
+
+	assert.Equal(t, "v1", do(e, http.MethodGet, "/swr", nil).Body.String())
+	clock.Advance(20 * time.Second)
+	assert.Equal(t, "STALE", do(e, http.MethodGet, "/swr", nil).Header().Get("X-Cache"))
+
+	// revalidated with a fresh request through echo
+	select {
+	case cc := <-revalidated:
+		assert.Equal(t, "no-cache", cc)
+	case <-time.After(time.Second):
+		t.Fatal("not revalidated")
+	}
+	assert.Eventually(t, func() bool {
+		w := do(e, http.MethodGet, "/swr", nil)
+		return w.Header().Get("X-Cache") == "HIT" && w.Body.String() == "v2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConfig_Rule(t *testing.T) {
+	config := DefaultConfig()
+	config.TTL = time.Minute
+	config.Rules = []Rule{{Path: "/api", TTL: time.Second}, {Path: "/api/user", Disable: true}}
+	assert.Equal(t, "default", config.rule("/").Path)
+	assert.Equal(t, time.Minute, config.rule("/").TTL)
+	assert.Equal(t, time.Second, config.rule("/api/list").TTL)
+	assert.True(t, config.rule("/api/user/1").Disable)
+}
+
+func TestMemoryBackend(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	m := NewMemoryBackend(2, clock)
+	_ = m.Set("a", []byte("1"), time.Second)
+	_ = m.Set("b", []byte("2"), time.Minute)
+	_, _, _ = m.Get("a")
+	_ = m.Set("c", []byte("3"), time.Minute)
+
+	_, ok, _ := m.Get("b")
+	assert.False(t, ok, "lru evicted")
+	val, ok, _ := m.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", string(val))
+
+	clock.Advance(time.Second)
+	_, ok, _ = m.Get("a")
+	assert.False(t, ok, "expired")
+}
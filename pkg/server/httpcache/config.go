@@ -0,0 +1,123 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpcache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("server.httpcache", "jupiter.httpcache.*", "http response cache", DefaultConfig)
+}
+
+// Rule overrides cache settings of the routes with path prefix
+type Rule struct {
+	Path string
+	// TTL of responses without max-age, not cached if zero
+	TTL time.Duration
+	// StaleTTL serves stale responses while revalidating in background
+	StaleTTL time.Duration
+	// Disable caching of the routes
+	Disable bool
+}
+
+// Config ...
+type Config struct {
+	// TTL of responses without max-age, not cached if zero
+	TTL time.Duration
+	// StaleTTL of responses without stale-while-revalidate
+	StaleTTL time.Duration
+	// Capacity of memory backend in entries
+	Capacity int
+	// MaxBodySize of responses cached in bytes
+	MaxBodySize int
+	// Rules are matched by the longest path prefix
+	Rules []Rule
+
+	backend Backend
+	origin  http.Handler
+	logger  *xlog.Logger
+	clock   xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Capacity:    10000,
+		MaxBodySize: 1 << 20,
+		logger:      xlog.JupiterLogger.With(xlog.FieldMod("server.httpcache")),
+		clock:       xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.httpcache." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("httpcache parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// WithBackend replaces the memory backend, e.g. with NewRedisBackend
+func (config *Config) WithBackend(backend Backend) *Config {
+	config.backend = backend
+	return config
+}
+
+// WithOrigin sends revalidations of stale responses to origin as fresh
+// requests, e.g. the echo instance, instead of the next handler, which is
+// required if next handlers are bound to the request they're called with
+func (config *Config) WithOrigin(origin http.Handler) *Config {
+	config.origin = origin
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Cache {
+	if config.backend == nil {
+		config.backend = NewMemoryBackend(config.Capacity, config.clock)
+	}
+	return newCache(config)
+}
+
+// rule returns the rule of the longest path prefix, or the default one
+func (config *Config) rule(path string) Rule {
+	var matched = Rule{Path: "default", TTL: config.TTL, StaleTTL: config.StaleTTL}
+	var length = -1
+	for _, rule := range config.Rules {
+		if strings.HasPrefix(path, rule.Path) && len(rule.Path) > length {
+			matched, length = rule, len(rule.Path)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpcache
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/client/redis"
+)
+
+// RedisBackend shares cached responses among instances
+type RedisBackend struct {
+	client *redis.Redis
+	prefix string
+}
+
+// NewRedisBackend ...
+func NewRedisBackend(client *redis.Redis, prefix string) *RedisBackend {
+	return &RedisBackend{client: client, prefix: prefix}
+}
+
+// Get ...
+func (r *RedisBackend) Get(key string) ([]byte, bool, error) {
+	val, err := r.client.GetRaw(r.prefix + key)
+	if err != nil {
+		return nil, false, err
+	}
+	return val, len(val) > 0, nil
+}
+
+// Set ...
+func (r *RedisBackend) Set(key string, val []byte, ttl time.Duration) error {
+	return r.client.SetWithErr(r.prefix+key, val, ttl)
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package maintenance is the switch of planned maintenance, under which
+// servers report NOT_SERVING to health checks and reject new requests
+// with 503/UNAVAILABLE and Retry-After, except the allowlisted ones.
+//
+// The switch is driven by config key "jupiter.maintenance" and governor
+// POST /maintenance?enable=true|false, the latest change wins.
+package maintenance
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+	"github.com/pkg/errors"
+)
+
+// ConfigKey ...
+const ConfigKey = "jupiter.maintenance"
+
+// Config ...
+type Config struct {
+	Enable bool
+	// RetryAfter is sent to rejected clients, 30s by default
+	RetryAfter time.Duration
+	// Allowlist of path or grpc method prefixes still served under maintenance
+	Allowlist []string
+	// Deregister unregisters services from registry under maintenance
+	Deregister bool
+}
+
+// DefaultConfig ...
+func DefaultConfig() Config {
+	return Config{
+		RetryAfter: time.Second * 30,
+	}
+}
+
+// alwaysAllowed are served under maintenance regardless of config
+var alwaysAllowed = []string{"/grpc.health.v1.Health/"}
+
+type state struct {
+	enabled bool
+	config  Config
+}
+
+var (
+	current   atomic.Value // *state
+	mu        sync.Mutex
+	listeners []func(enabled bool)
+	// configEnable is the last Enable read from config, so that unrelated
+	// config changes don't override the switch set on governor
+	configEnable bool
+
+	logger = xlog.JupiterLogger.With(xlog.FieldMod("maintenance"))
+)
+
+func init() {
+	current.Store(&state{config: DefaultConfig()})
+	xschema.RegisterConfig("maintenance", ConfigKey, "switch of planned maintenance", DefaultConfig)
+
+	governor.HandleFunc("/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			enable, err := strconv.ParseBool(r.URL.Query().Get("enable"))
+			if err != nil {
+				http.Error(w, "enable must be true or false", http.StatusBadRequest)
+				return
+			}
+			Set(enable)
+		}
+		s := load()
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled":    s.enabled,
+			"retryAfter": s.config.RetryAfter.String(),
+			"allowlist":  s.config.Allowlist,
+			"deregister": s.config.Deregister,
+		})
+	})
+}
+
+// Load reads the switch from config and watches config changes
+func Load() {
+	reload()
+	conf.OnChange(func(*conf.Configuration) { reload() })
+}
+
+func reload() {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(ConfigKey, &config); err != nil && errors.Cause(err) != conf.ErrInvalidKey {
+		logger.Error("parse maintenance config", xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err))
+		return
+	}
+
+	mu.Lock()
+	enabled := load().enabled
+	if config.Enable != configEnable {
+		configEnable = config.Enable
+		enabled = config.Enable
+	}
+	mu.Unlock()
+	update(enabled, config)
+}
+
+// Set turns maintenance on or off
+func Set(enabled bool) {
+	update(enabled, load().config)
+}
+
+func update(enabled bool, config Config) {
+	mu.Lock()
+	prev := load()
+	current.Store(&state{enabled: enabled, config: config})
+	fns := listeners
+	mu.Unlock()
+
+	if prev.enabled == enabled {
+		return
+	}
+	logger.Warn("maintenance switched", xlog.Any("enabled", enabled))
+	for _, fn := range fns {
+		fn(enabled)
+	}
+}
+
+func load() *state {
+	return current.Load().(*state)
+}
+
+// OnChange registers fn called with the new switch once it's flipped
+func OnChange(fn func(enabled bool)) {
+	mu.Lock()
+	listeners = append(listeners, fn)
+	mu.Unlock()
+}
+
+// Enabled ...
+func Enabled() bool {
+	return load().enabled
+}
+
+// Allowed reports whether the request of path or grpc method should be served
+func Allowed(path string) bool {
+	s := load()
+	if !s.enabled {
+		return true
+	}
+	for _, prefix := range alwaysAllowed {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	for _, prefix := range s.config.Allowlist {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryAfter returns the Retry-After header value in seconds
+func RetryAfter() string {
+	return strconv.Itoa(int(load().config.RetryAfter.Seconds()))
+}
+
+// Deregister reports whether services should be unregistered under maintenance
+func Deregister() bool {
+	return load().config.Deregister
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package maintenance
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenance(t *testing.T) {
+	defer conf.Reset()
+	defer Set(false)
+
+	var changes []bool
+	OnChange(func(enabled bool) { changes = append(changes, enabled) })
+
+	err := conf.LoadFromReader(bytes.NewBufferString(`
+[jupiter.maintenance]
+	enable = true
+	retryAfter = "1m"
+	allowlist = ["/admin/"]
+`), toml.Unmarshal)
+	assert.Nil(t, err)
+	reload()
+
+	assert.True(t, Enabled())
+	assert.Equal(t, "60", RetryAfter())
+	assert.True(t, Allowed("/admin/reload"))
+	assert.True(t, Allowed("/grpc.health.v1.Health/Check"))
+	assert.False(t, Allowed("/demo.Hello/Say"))
+
+	// switched off on governor, unrelated reload keeps it off
+	Set(false)
+	reload()
+	assert.False(t, Enabled())
+	assert.True(t, Allowed("/demo.Hello/Say"))
+	assert.Equal(t, []bool{true, false}, changes)
+}
@@ -0,0 +1,277 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package readiness
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+const (
+	// StatusUp means all dependencies are up
+	StatusUp = "up"
+	// StatusDegraded means some soft dependencies are down, the application
+	// is still ready but serves with reduced functionality
+	StatusDegraded = "degraded"
+	// StatusDown means some hard dependencies are down, the application is
+	// not ready
+	StatusDown = "down"
+)
+
+var dependencyGauge = metric.GaugeVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "dependency_up",
+	Labels:    []string{"name", "kind"},
+}.Build()
+
+// dependencies are checked dependencies keyed by name
+var dependencies sync.Map
+
+func init() {
+	governor.HandleFunc("/readiness/dependencies", func(w http.ResponseWriter, r *http.Request) {
+		status := DependencyStatus()
+		if status == StatusDown {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":       status,
+			"dependencies": Dependencies(),
+		})
+	})
+}
+
+// Dependency is checked periodically, e.g. a database or a downstream
+// service. The application is not ready while a hard dependency is down,
+// while soft ones only degrade it, which is exported as metrics and the
+// status of governor GET /readiness/dependencies.
+type Dependency struct {
+	Name string
+	// Hard dependencies are required to serve requests
+	Hard  bool
+	Check func(ctx context.Context) error
+	// Interval between checks, 10s by default
+	Interval time.Duration
+	// Timeout of a check, 3s by default
+	Timeout time.Duration
+	// FailThreshold is the number of consecutive failed checks marking the
+	// dependency down, and PassThreshold the number of consecutive passed
+	// ones marking it up again, which damp flapping, 1 by default. The first
+	// check marks it up or down immediately.
+	FailThreshold int
+	PassThreshold int
+	clock         xtime.Clock
+}
+
+// State is the state of a dependency
+type State struct {
+	Name string `json:"name"`
+	Hard bool   `json:"hard"`
+	Up   bool   `json:"up"`
+	// Error of the last failed check while it's down
+	Error string `json:"error,omitempty"`
+	// Since is when it went up or down
+	Since time.Time `json:"since"`
+}
+
+type dependency struct {
+	Dependency
+
+	mu      sync.Mutex
+	checked bool
+	up      bool
+	err     error
+	// streak is the number of consecutive checks contrary to up
+	streak int
+	since  time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Depend checks dep once and then every Interval, the returned func stops
+// checking and forgets dep. It panics if a dependency of the same name is
+// checked already.
+func Depend(dep Dependency) (stop func()) {
+	if dep.Interval <= 0 {
+		dep.Interval = 10 * time.Second
+	}
+	if dep.Timeout <= 0 {
+		dep.Timeout = 3 * time.Second
+	}
+	if dep.FailThreshold <= 0 {
+		dep.FailThreshold = 1
+	}
+	if dep.PassThreshold <= 0 {
+		dep.PassThreshold = 1
+	}
+	if dep.clock == nil {
+		dep.clock = xtime.SystemClock
+	}
+	if dep.Check == nil {
+		logger.Panic("dependency without check", xlog.FieldName(dep.Name))
+	}
+	d := &dependency{Dependency: dep, stop: make(chan struct{})}
+	if _, loaded := dependencies.LoadOrStore(dep.Name, d); loaded {
+		logger.Panic("dependency already checked", xlog.FieldName(dep.Name))
+	}
+
+	d.probe()
+	ticker := d.clock.NewTicker(d.Interval)
+	xgo.Go(func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C():
+			}
+			d.probe()
+		}
+	})
+	return d.close
+}
+
+func (d *dependency) probe() {
+	ctx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+	d.record(d.Check(ctx))
+}
+
+// record updates the state with the result of a check, the dependency goes
+// up or down after thresholds of consecutive results
+func (d *dependency) record(err error) {
+	ok := err == nil
+	d.mu.Lock()
+	var changed bool
+	switch {
+	case !d.checked:
+		d.checked, d.up, changed = true, ok, true
+	case ok == d.up:
+		d.streak = 0
+	default:
+		d.streak++
+		threshold := d.FailThreshold
+		if ok {
+			threshold = d.PassThreshold
+		}
+		if d.streak >= threshold {
+			d.up, d.streak, changed = ok, 0, true
+		}
+	}
+	if changed {
+		d.since = d.clock.Now()
+	}
+	if d.up {
+		d.err = nil
+	} else if err != nil {
+		d.err = err
+	}
+	up, downErr := d.up, d.err
+	d.mu.Unlock()
+
+	var kind = "soft"
+	if d.Hard {
+		kind = "hard"
+	}
+	if up {
+		dependencyGauge.Set(1, d.Name, kind)
+	} else {
+		dependencyGauge.Set(0, d.Name, kind)
+	}
+	if d.Hard {
+		if up {
+			Pass(d.check())
+		} else {
+			Fail(d.check(), downErr)
+		}
+		return
+	}
+	if changed && up {
+		logger.Info("soft dependency up", xlog.FieldName(d.Name))
+	} else if changed {
+		logger.Warn("soft dependency down", xlog.FieldName(d.Name), xlog.FieldErr(downErr))
+	}
+}
+
+// check is the name of readiness check of a hard dependency
+func (d *dependency) check() string {
+	return "dependency:" + d.Name
+}
+
+func (d *dependency) close() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		dependencies.Delete(d.Name)
+		if d.Hard {
+			Pass(d.check())
+		}
+	})
+}
+
+func (d *dependency) state() State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var state = State{Name: d.Name, Hard: d.Hard, Up: d.up, Since: d.since}
+	if d.err != nil {
+		state.Error = d.err.Error()
+	}
+	return state
+}
+
+// Dependencies returns states of checked dependencies ordered by name
+func Dependencies() []State {
+	var states = make([]State, 0)
+	dependencies.Range(func(key, value interface{}) bool {
+		states = append(states, value.(*dependency).state())
+		return true
+	})
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Lookup returns the state of the dependency of name
+func Lookup(name string) (State, bool) {
+	d, ok := dependencies.Load(name)
+	if !ok {
+		return State{}, false
+	}
+	return d.(*dependency).state(), true
+}
+
+// DependencyStatus rolls up states of dependencies, StatusDown if any hard
+// one is down, StatusDegraded if any soft one is down, or else StatusUp
+func DependencyStatus() string {
+	var status = StatusUp
+	for _, state := range Dependencies() {
+		if state.Up {
+			continue
+		}
+		if state.Hard {
+			return StatusDown
+		}
+		status = StatusDegraded
+	}
+	return status
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package readiness
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependency_record(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	d := &dependency{Dependency: Dependency{Name: "db", Hard: true, FailThreshold: 2, PassThreshold: 3, clock: clock}}
+	defer d.close()
+	d.stop = make(chan struct{})
+
+	d.record(nil)
+	assert.True(t, d.state().Up)
+	assert.True(t, Ready())
+
+	// a single failure is damped
+	clock.Advance(time.Second)
+	d.record(errors.New("refused"))
+	d.record(nil)
+	d.record(errors.New("refused"))
+	assert.True(t, d.state().Up)
+	d.record(errors.New("timeout"))
+	assert.Equal(t, State{Name: "db", Hard: true, Error: "timeout", Since: time.Unix(1, 0)}, d.state())
+	assert.Equal(t, map[string]string{"dependency:db": "timeout"}, Failures())
+
+	d.record(nil)
+	d.record(nil)
+	assert.False(t, Ready())
+	d.record(nil)
+	assert.True(t, d.state().Up)
+	assert.True(t, Ready())
+}
+
+func TestDepend(t *testing.T) {
+	var cacheUp int32
+	stopCache := Depend(Dependency{Name: "cache", Check: func(ctx context.Context) error {
+		if atomic.LoadInt32(&cacheUp) == 0 {
+			return errors.New("cache down")
+		}
+		return nil
+	}, Interval: 10 * time.Millisecond})
+	defer stopCache()
+	stopDB := Depend(Dependency{Name: "db", Hard: true, Check: func(ctx context.Context) error { return nil }})
+
+	// soft dependencies don't fail readiness
+	assert.True(t, Ready())
+	assert.Equal(t, StatusDegraded, DependencyStatus())
+	w := httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readiness/dependencies", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"degraded"`)
+	assert.Panics(t, func() { Depend(Dependency{Name: "db", Check: func(ctx context.Context) error { return nil }}) })
+
+	atomic.StoreInt32(&cacheUp, 1)
+	assert.Eventually(t, func() bool { return DependencyStatus() == StatusUp }, time.Second, 5*time.Millisecond)
+
+	stopDB()
+	assert.Len(t, Dependencies(), 1)
+	_, ok := Lookup("db")
+	assert.False(t, ok)
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package readiness aggregates checks deciding whether the application is
+// ready to serve, e.g. clock skew or hard dependencies, see Depend. It's not
+// ready once any check fails, under which servers report NOT_SERVING to
+// health checks and governor GET /readiness responds 503, while requests
+// are still served.
+package readiness
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+var (
+	mu        sync.Mutex
+	failures  = make(map[string]error)
+	listeners []func(ready bool)
+
+	logger = xlog.JupiterLogger.With(xlog.FieldMod("readiness"))
+)
+
+func init() {
+	governor.HandleFunc("/readiness", func(w http.ResponseWriter, r *http.Request) {
+		var failed = Failures()
+		if len(failed) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"ready":    len(failed) == 0,
+			"failures": failed,
+		})
+	})
+}
+
+// Fail marks check failed with err, the application is not ready until
+// all failed checks pass
+func Fail(check string, err error) {
+	mu.Lock()
+	prev := len(failures) == 0
+	if _, ok := failures[check]; !ok {
+		logger.Warn("readiness check failed", xlog.String("check", check), xlog.FieldErr(err))
+	}
+	failures[check] = err
+	update(prev)
+}
+
+// Pass marks check passed
+func Pass(check string) {
+	mu.Lock()
+	prev := len(failures) == 0
+	if _, ok := failures[check]; ok {
+		logger.Info("readiness check passed", xlog.String("check", check))
+	}
+	delete(failures, check)
+	update(prev)
+}
+
+// update notifies listeners if readiness changed from prev, it's called
+// with mu held and releases it
+func update(prev bool) {
+	ready := len(failures) == 0
+	fns := listeners
+	mu.Unlock()
+
+	if prev == ready {
+		return
+	}
+	for _, fn := range fns {
+		fn(ready)
+	}
+}
+
+// OnChange registers fn called with the new readiness once it's changed
+func OnChange(fn func(ready bool)) {
+	mu.Lock()
+	listeners = append(listeners, fn)
+	mu.Unlock()
+}
+
+// Ready ...
+func Ready() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(failures) == 0
+}
+
+// Failures returns errors of failed checks keyed by check
+func Failures() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+	var ret = make(map[string]string, len(failures))
+	for check, err := range failures {
+		ret[check] = err.Error()
+	}
+	return ret
+}
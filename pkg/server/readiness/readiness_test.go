@@ -0,0 +1,53 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package readiness
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadiness(t *testing.T) {
+	var changes []bool
+	OnChange(func(ready bool) { changes = append(changes, ready) })
+	assert.True(t, Ready())
+
+	Fail("skew", errors.New("clock skew 3s"))
+	Fail("skew", errors.New("clock skew 4s"))
+	Fail("warmup", errors.New("cache not loaded"))
+	assert.False(t, Ready())
+	assert.Equal(t, map[string]string{"skew": "clock skew 4s", "warmup": "cache not loaded"}, Failures())
+
+	w := httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"ready":false,"failures":{"skew":"clock skew 4s","warmup":"cache not loaded"}}`, w.Body.String())
+
+	Pass("skew")
+	assert.False(t, Ready())
+	Pass("warmup")
+	Pass("warmup")
+	assert.True(t, Ready())
+	assert.Equal(t, []bool{false, true}, changes)
+
+	w = httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rotation pulls the instance out of rotation of consumers at
+// runtime, services are registered again with the status set on governor
+// POST /rotation?status=DOWN, and put back with status UP.
+package rotation
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+var (
+	mu        sync.Mutex
+	status    = server.StatusUp
+	listeners []func(status string)
+
+	logger = xlog.JupiterLogger.With(xlog.FieldMod("rotation"))
+)
+
+func init() {
+	governor.HandleFunc("/rotation", handle)
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		switch s := r.URL.Query().Get("status"); s {
+		case server.StatusUp, server.StatusDown, server.StatusMaintenance:
+			Set(s)
+		default:
+			http.Error(w, "status must be UP, DOWN or MAINTENANCE", http.StatusBadRequest)
+			return
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": Status(),
+	})
+}
+
+// Set sets status of registered services
+func Set(s string) {
+	mu.Lock()
+	if s == status {
+		mu.Unlock()
+		return
+	}
+	status = s
+	fns := listeners
+	mu.Unlock()
+
+	logger.Warn("rotation status changed", xlog.String("status", s))
+	for _, fn := range fns {
+		fn(s)
+	}
+}
+
+// Status returns status of registered services, UP by default
+func Status() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return status
+}
+
+// OnChange registers fn called once status changes
+func OnChange(fn func(status string)) {
+	mu.Lock()
+	listeners = append(listeners, fn)
+	mu.Unlock()
+}
+
+// Apply returns a copy of info with the status if it's not UP, or else
+// info itself
+func Apply(info *server.ServiceInfo) *server.ServiceInfo {
+	s := Status()
+	if s == server.StatusUp {
+		return info
+	}
+	var copied = *info
+	copied.Status = s
+	return &copied
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rotation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotation(t *testing.T) {
+	defer Set(server.StatusUp)
+
+	var changes []string
+	OnChange(func(status string) { changes = append(changes, status) })
+
+	info := &server.ServiceInfo{Address: "127.0.0.1:9527"}
+	assert.Equal(t, info, Apply(info))
+
+	rec := httptest.NewRecorder()
+	handle(rec, httptest.NewRequest(http.MethodPost, "/rotation?status=OFF", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handle(rec, httptest.NewRequest(http.MethodPost, "/rotation?status=DOWN", nil))
+	assert.JSONEq(t, `{"status":"DOWN"}`, rec.Body.String())
+	assert.Equal(t, server.StatusDown, Apply(info).Status)
+	assert.Equal(t, "", info.Status)
+
+	Set(server.StatusDown)
+	Set(server.StatusUp)
+	assert.Equal(t, []string{server.StatusDown, server.StatusUp}, changes)
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scope is a request scoped store of typed keys, servers start a Store
+// for each request, which handlers and downstream hooks reach by the request
+// context, values are cleared once the request ends and dumped on panics.
+//
+//	var userKey = scope.NewKey("user")
+//
+//	userKey.Set(ctx, user)
+//	user, ok := userKey.Get(ctx)
+package scope
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Key of values in the Store, keys are compared by identity, so that values
+// of different packages never collide as string keys of maps in context do
+type Key struct {
+	name string
+	seq  uint64
+}
+
+var keySeq uint64
+
+// NewKey returns a key named name, the name is only used in dumps
+func NewKey(name string) *Key {
+	return &Key{name: name, seq: atomic.AddUint64(&keySeq, 1)}
+}
+
+// String ...
+func (k *Key) String() string {
+	return k.name
+}
+
+// Set sets the value of k in the store of ctx, it's false if ctx has no store
+// or the request has ended
+func (k *Key) Set(ctx context.Context, value interface{}) bool {
+	return FromContext(ctx).Set(k, value)
+}
+
+// Get returns the value of k in the store of ctx
+func (k *Key) Get(ctx context.Context) (interface{}, bool) {
+	return FromContext(ctx).Get(k)
+}
+
+// Delete deletes the value of k in the store of ctx
+func (k *Key) Delete(ctx context.Context) {
+	FromContext(ctx).Delete(k)
+}
+
+// Store is the values of a request, methods of a nil Store are no-ops
+type Store struct {
+	mu     sync.RWMutex
+	values map[*Key]interface{}
+	closed bool
+}
+
+// New ...
+func New() *Store {
+	return &Store{values: make(map[*Key]interface{})}
+}
+
+type storeKey struct{}
+
+// NewContext returns ctx carrying s
+func NewContext(ctx context.Context, s *Store) context.Context {
+	return context.WithValue(ctx, storeKey{}, s)
+}
+
+// FromContext returns the store of ctx, it's nil if ctx has none
+func FromContext(ctx context.Context) *Store {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(storeKey{}).(*Store)
+	return s
+}
+
+// Start returns ctx carrying a new store and the func ending it, it reuses
+// the store of ctx if any, so that nested servers end the store once
+func Start(ctx context.Context) (context.Context, *Store, func()) {
+	if s := FromContext(ctx); s != nil {
+		return ctx, s, func() {}
+	}
+	s := New()
+	return NewContext(ctx, s), s, s.Close
+}
+
+// Set ...
+func (s *Store) Set(k *Key, value interface{}) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.values[k] = value
+	return true
+}
+
+// Get ...
+func (s *Store) Get(k *Key) (interface{}, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.values[k]
+	return value, ok
+}
+
+// Delete ...
+func (s *Store) Delete(k *Key) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	delete(s.values, k)
+	s.mu.Unlock()
+}
+
+// Close clears the values so that they're not leaked by goroutines holding
+// the context, later Sets are dropped
+func (s *Store) Close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.values = make(map[*Key]interface{})
+	s.closed = true
+	s.mu.Unlock()
+}
+
+// Dump returns the values formatted by name, for logs of panics
+func (s *Store) Dump() map[string]string {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.values) == 0 {
+		return nil
+	}
+	var keys = make([]*Key, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].seq < keys[j].seq
+	})
+	var dump = make(map[string]string, len(keys))
+	for _, k := range keys {
+		name := k.name
+		// keys of the same name are told apart by suffix in order of creation
+		for i := 2; ; i++ {
+			if _, ok := dump[name]; !ok {
+				break
+			}
+			name = fmt.Sprintf("%s#%d", k.name, i)
+		}
+		dump[name] = fmt.Sprintf("%+v", s.values[k])
+	}
+	return dump
+}
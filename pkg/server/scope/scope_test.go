@@ -0,0 +1,61 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scope
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	user, other := NewKey("user"), NewKey("user")
+
+	ctx, s, end := Start(context.Background())
+	assert.True(t, user.Set(ctx, "alice"))
+	assert.True(t, other.Set(ctx, 42))
+
+	value, ok := user.Get(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", value)
+	assert.Equal(t, map[string]string{"user": "alice", "user#2": "42"}, s.Dump())
+
+	// nested servers share the store
+	nested, ns, nestedEnd := Start(ctx)
+	assert.Equal(t, s, ns)
+	nestedEnd()
+	_, ok = user.Get(nested)
+	assert.True(t, ok)
+
+	other.Delete(ctx)
+	_, ok = other.Get(ctx)
+	assert.False(t, ok)
+
+	end()
+	_, ok = user.Get(ctx)
+	assert.False(t, ok)
+	assert.False(t, user.Set(ctx, "bob"))
+	assert.Nil(t, s.Dump())
+}
+
+func TestStore_NoScope(t *testing.T) {
+	key := NewKey("user")
+	assert.False(t, key.Set(context.Background(), "alice"))
+	_, ok := key.Get(context.Background())
+	assert.False(t, ok)
+	key.Delete(context.Background())
+	assert.Nil(t, FromContext(nil))
+}
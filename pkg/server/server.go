@@ -48,6 +48,25 @@ type ServiceInfo struct {
 	// Group 流量组: 流量在Group之间进行负载均衡
 	Group    string              `json:"group"`
 	Services map[string]*Service `json:"services" toml:"services"`
+	// Labels are matched by label selectors of consumers, e.g. "env=prod"
+	Labels map[string]string `json:"labels,omitempty"`
+	// Status of the instance, UP if empty, consumers only watch UP ones
+	// unless they ask for others
+	Status string `json:"status,omitempty"`
+}
+
+const (
+	// StatusUp instances are in rotation of consumers
+	StatusUp = "UP"
+	// StatusDown instances are pulled out of rotation while still running
+	StatusDown = "DOWN"
+	// StatusMaintenance instances are out of rotation under maintenance
+	StatusMaintenance = "MAINTENANCE"
+)
+
+// Available reports whether the instance is in rotation, i.e. it's UP
+func (si ServiceInfo) Available() bool {
+	return si.Status == "" || si.Status == StatusUp
 }
 
 // Service ...
@@ -99,6 +118,31 @@ func WithMetaData(key, value string) Option {
 	}
 }
 
+// WithLabels ...
+func WithLabels(labels map[string]string) Option {
+	return func(c *ServiceInfo) {
+		if len(labels) == 0 {
+			return
+		}
+		if c.Labels == nil {
+			c.Labels = make(map[string]string, len(labels))
+		}
+		for key, value := range labels {
+			c.Labels[key] = value
+		}
+	}
+}
+
+// WithWeight sets weight of the service for weighted balancers, the
+// default one is kept if weight isn't positive
+func WithWeight(weight float64) Option {
+	return func(c *ServiceInfo) {
+		if weight > 0 {
+			c.Weight = weight
+		}
+	}
+}
+
 func WithScheme(scheme string) Option {
 	return func(c *ServiceInfo) {
 		c.Scheme = scheme
@@ -0,0 +1,266 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sizeguard measures sizes of responses of grpc methods and http
+// routes configured by key "jupiter.sizeguard". Responses above the threshold
+// are counted, logged once per LogInterval for each route, and served on
+// governor /debug/large_responses, so that teams paginate them before they
+// start timing out. Strict mode rejects them instead.
+package sizeguard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+	"github.com/pkg/errors"
+)
+
+// ConfigKey ...
+const ConfigKey = "jupiter.sizeguard"
+
+// maxRoutes caps routes tracked
+const maxRoutes = 1000
+
+// Limit is the threshold of responses of a grpc method or http route
+type Limit struct {
+	// Route is a grpc full method, e.g. "/helloworld.Greeter/SayHello", or
+	// an http route, e.g. "GET /v1/users" or "/v1/users" of all methods.
+	// A trailing "*" matches any suffix.
+	Route string
+	// Threshold in bytes, Config.Threshold if zero, unlimited if negative
+	Threshold int64
+	// Strict rejects responses above Threshold, so does Config.Strict
+	Strict bool
+}
+
+// Config ...
+type Config struct {
+	// Threshold in bytes of all routes, only routes listed are guarded if zero
+	Threshold int64
+	// Strict rejects responses above threshold of all routes
+	Strict bool
+	// Routes override Threshold and Strict
+	Routes []Limit
+	// LogInterval of logging each route, 10m by default
+	LogInterval time.Duration
+}
+
+// DefaultConfig ...
+func DefaultConfig() Config {
+	return Config{
+		LogInterval: time.Minute * 10,
+	}
+}
+
+// Error is returned by Observe for responses rejected in strict mode
+type Error struct {
+	Route     string
+	Size      int64
+	Threshold int64
+}
+
+// Error ...
+func (e *Error) Error() string {
+	return fmt.Sprintf("response of %s is %d bytes, exceeds %d bytes, paginate it", e.Route, e.Size, e.Threshold)
+}
+
+// Stat is the large responses of a route
+type Stat struct {
+	Route     string    `json:"route"`
+	Count     int64     `json:"count"`
+	Rejected  int64     `json:"rejected"`
+	Max       int64     `json:"max"`
+	Threshold int64     `json:"threshold"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	lastLogged time.Time
+}
+
+var (
+	sizeHistogram = metric.HistogramVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "response_size_bytes",
+		Labels:    []string{"route"},
+		// 1KB ~ 64MB
+		Buckets: []float64{1 << 10, 1 << 12, 1 << 14, 1 << 16, 1 << 18, 1 << 20, 1 << 22, 1 << 24, 1 << 26},
+	}.Build()
+
+	largeCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "large_response_total",
+		Labels:    []string{"route", "action"},
+	}.Build()
+)
+
+var (
+	current atomic.Value // Config
+	mu      sync.Mutex
+	stats   = make(map[string]*Stat)
+
+	clock  xtime.Clock = xtime.SystemClock
+	logger             = xlog.JupiterLogger.With(xlog.FieldMod("sizeguard"))
+)
+
+func init() {
+	current.Store(DefaultConfig())
+	xschema.RegisterConfig("sizeguard", ConfigKey, "response size thresholds pushing routes toward pagination", DefaultConfig)
+
+	governor.HandleFunc("/debug/large_responses", func(w http.ResponseWriter, r *http.Request) {
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(Stats())
+	})
+}
+
+// Load reads thresholds from config and watches config changes
+func Load() {
+	reload()
+	conf.OnChange(func(*conf.Configuration) { reload() })
+}
+
+func reload() {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(ConfigKey, &config); err != nil && errors.Cause(err) != conf.ErrInvalidKey {
+		logger.Error("parse sizeguard config", xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err))
+		return
+	}
+	Set(config)
+}
+
+// Set replaces thresholds with those of config
+func Set(config Config) {
+	current.Store(config)
+}
+
+// Lookup returns the limit of a grpc method, whose httpMethod is empty, or
+// an http route, false if responses of it are not guarded
+func Lookup(httpMethod, route string) (Limit, bool) {
+	config := current.Load().(Config)
+	var limit = Limit{Route: route, Threshold: config.Threshold, Strict: config.Strict}
+	for _, l := range config.Routes {
+		if match(l.Route, httpMethod, route) {
+			if l.Threshold != 0 {
+				limit.Threshold = l.Threshold
+			}
+			limit.Strict = limit.Strict || l.Strict
+			break
+		}
+	}
+	if httpMethod != "" {
+		limit.Route = httpMethod + " " + route
+	}
+	return limit, limit.Threshold > 0
+}
+
+func match(pattern, httpMethod, route string) bool {
+	if idx := strings.IndexByte(pattern, ' '); idx >= 0 {
+		if !strings.EqualFold(pattern[:idx], httpMethod) {
+			return false
+		}
+		pattern = strings.TrimSpace(pattern[idx+1:])
+	}
+	return pattern == route || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(route, strings.TrimSuffix(pattern, "*")))
+}
+
+// Exceeded reports whether size is above the threshold of limit
+func (limit Limit) Exceeded(size int64) bool {
+	return limit.Threshold > 0 && size > limit.Threshold
+}
+
+// Observe records the size of a response of limit, responses above threshold
+// are counted and logged once per LogInterval, and rejected with *Error in
+// strict mode
+func Observe(limit Limit, size int64) error {
+	sizeHistogram.Observe(float64(size), limit.Route)
+	if !limit.Exceeded(size) {
+		return nil
+	}
+	action := "log"
+	if limit.Strict {
+		action = "reject"
+	}
+	largeCounter.Inc(limit.Route, action)
+	now := clock.Now()
+
+	mu.Lock()
+	route := limit.Route
+	stat, ok := stats[route]
+	if !ok {
+		if len(stats) >= maxRoutes {
+			route = "other"
+			stat = stats[route]
+		}
+		if stat == nil {
+			stat = &Stat{Route: route}
+			stats[route] = stat
+		}
+	}
+	stat.Count++
+	if limit.Strict {
+		stat.Rejected++
+	}
+	if size > stat.Max {
+		stat.Max = size
+	}
+	stat.Threshold = limit.Threshold
+	stat.LastSeen = now
+	var log bool
+	if now.Sub(stat.lastLogged) >= current.Load().(Config).LogInterval {
+		stat.lastLogged = now
+		log = true
+	}
+	count := stat.Count
+	mu.Unlock()
+
+	if log {
+		logger.Warn("large response, paginate it",
+			xlog.String("route", limit.Route),
+			xlog.Int64("size", size),
+			xlog.Int64("threshold", limit.Threshold),
+			xlog.Int64("count", count),
+			xlog.String("action", action),
+		)
+	}
+	if limit.Strict {
+		return &Error{Route: limit.Route, Size: size, Threshold: limit.Threshold}
+	}
+	return nil
+}
+
+// Stats returns routes which responded above threshold, largest first
+func Stats() []Stat {
+	mu.Lock()
+	defer mu.Unlock()
+	var ret = make([]Stat, 0, len(stats))
+	for _, stat := range stats {
+		ret = append(ret, *stat)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Max > ret[j].Max })
+	return ret
+}
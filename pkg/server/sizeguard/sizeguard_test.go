@@ -0,0 +1,73 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sizeguard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	_, ok := Lookup("", "/helloworld.Greeter/SayHello")
+	assert.False(t, ok)
+
+	Set(Config{Threshold: 1 << 20, Routes: []Limit{
+		{Route: "/helloworld.Greeter/ListUsers", Threshold: 1 << 10, Strict: true},
+		{Route: "GET /v1/export/*", Threshold: -1},
+	}})
+	defer Set(DefaultConfig())
+
+	limit, ok := Lookup("", "/helloworld.Greeter/SayHello")
+	assert.True(t, ok)
+	assert.Equal(t, Limit{Route: "/helloworld.Greeter/SayHello", Threshold: 1 << 20}, limit)
+
+	limit, ok = Lookup("", "/helloworld.Greeter/ListUsers")
+	assert.True(t, ok)
+	assert.Equal(t, Limit{Route: "/helloworld.Greeter/ListUsers", Threshold: 1 << 10, Strict: true}, limit)
+
+	_, ok = Lookup("GET", "/v1/export/users")
+	assert.False(t, ok)
+	limit, ok = Lookup("POST", "/v1/export/users")
+	assert.True(t, ok)
+	assert.Equal(t, "POST /v1/export/users", limit.Route)
+}
+
+func TestObserve(t *testing.T) {
+	Set(DefaultConfig())
+	defer func() {
+		mu.Lock()
+		stats = make(map[string]*Stat)
+		mu.Unlock()
+	}()
+
+	limit := Limit{Route: "/helloworld.Greeter/ListUsers", Threshold: 100}
+	assert.NoError(t, Observe(limit, 100))
+	assert.Empty(t, Stats())
+	assert.NoError(t, Observe(limit, 200))
+
+	limit.Strict = true
+	err := Observe(limit, 150)
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, &Error{Route: limit.Route, Size: 150, Threshold: 100}, e)
+
+	stats := Stats()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, int64(2), stats[0].Count)
+	assert.Equal(t, int64(1), stats[0].Rejected)
+	assert.Equal(t, int64(200), stats[0].Max)
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package versiongate
+
+import (
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("server.versiongate", "jupiter.versiongate.*", "client version gating", DefaultConfig)
+}
+
+// Rule gates versions of clients of a platform
+type Rule struct {
+	// Platform of clients, e.g. "android", empty matches platforms without
+	// their own rules
+	Platform string
+	// MinVersion is the lowest version served, older ones are rejected
+	MinVersion string
+	// DeprecatedVersion is the lowest version served without warnings
+	DeprecatedVersion string
+	// Message guides users to upgrade
+	Message    string
+	UpgradeURL string
+}
+
+// Config ...
+type Config struct {
+	// Name labels metrics
+	Name string
+	// Header carrying the client version, e.g. "6.1.2"
+	Header string
+	// PlatformHeader carrying the client platform, the platform is guessed
+	// from User-Agent if it's missing
+	PlatformHeader string
+	// UserAgentProduct is the product token of User-Agent carrying the
+	// version if Header is missing, e.g. "DemoApp" of "DemoApp/6.1.2 (Android 10)"
+	UserAgentProduct string
+	// Rules are reloaded once config changes if built by StdConfig
+	Rules []Rule
+	// RejectMissing rejects requests without valid versions
+	RejectMissing bool
+	// Skip are path prefixes served without checks, e.g. health checks
+	Skip []string
+
+	key    string
+	logger *xlog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Header:         "X-Client-Version",
+		PlatformHeader: "X-Client-Platform",
+		logger:         xlog.JupiterLogger.With(xlog.FieldMod("server.versiongate")),
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.versiongate." + name)
+	if config.Name == "" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("versiongate parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	config.key = key
+	return config
+}
+
+// Build panics if versions of rules are invalid
+func (config *Config) Build() *Gate {
+	if config.Name == "" {
+		config.Name = "default"
+	}
+	rules, err := compile(config.Rules)
+	if err != nil {
+		config.logger.Panic("versiongate invalid rules", xlog.FieldErr(err), xlog.Any("rules", config.Rules))
+	}
+	g := &Gate{config: config}
+	g.rules.Store(rules)
+	if config.key != "" {
+		conf.OnChange(func(*conf.Configuration) { g.reload() })
+	}
+	return g
+}
@@ -0,0 +1,270 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package versiongate checks versions of clients, e.g. mobile apps, against
+// min and deprecated versions of config, rejecting the unsupported ones and
+// warning the deprecated ones with guidance to upgrade, so that old versions
+// are sunset centrally.
+package versiongate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// Results of checks
+const (
+	ResultOK         = "ok"
+	ResultDeprecated = "deprecated"
+	ResultRejected   = "rejected"
+	// ResultMissing is of requests without valid versions
+	ResultMissing = "missing"
+)
+
+// Codes of rejected responses
+const (
+	CodeUnsupported = "CLIENT_VERSION_UNSUPPORTED"
+	CodeMissing     = "CLIENT_VERSION_MISSING"
+)
+
+var requestCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "versiongate_request_total",
+	Labels:    []string{"name", "platform", "result"},
+}.Build()
+
+// Error is the JSON body of rejected responses
+type Error struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	MinVersion string `json:"minVersion,omitempty"`
+	UpgradeURL string `json:"upgradeUrl,omitempty"`
+}
+
+// Decision is the result of checking a client
+type Decision struct {
+	Result   string
+	Platform string
+	Version  Version
+	// Rule applied, nil if none matches the platform
+	Rule *Rule
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying decision
+func NewContext(ctx context.Context, decision Decision) context.Context {
+	return context.WithValue(ctx, contextKey{}, decision)
+}
+
+// FromContext returns the decision of the client made by Handler
+func FromContext(ctx context.Context) (Decision, bool) {
+	decision, ok := ctx.Value(contextKey{}).(Decision)
+	return decision, ok
+}
+
+type compiledRule struct {
+	Rule
+	min, deprecated Version
+}
+
+func compile(rules []Rule) ([]compiledRule, error) {
+	var ret = make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		compiled := compiledRule{Rule: rule}
+		compiled.Platform = strings.ToLower(rule.Platform)
+		var err error
+		if rule.MinVersion != "" {
+			if compiled.min, err = ParseVersion(rule.MinVersion); err != nil {
+				return nil, fmt.Errorf("min version of platform %q: %w", rule.Platform, err)
+			}
+		}
+		if rule.DeprecatedVersion != "" {
+			if compiled.deprecated, err = ParseVersion(rule.DeprecatedVersion); err != nil {
+				return nil, fmt.Errorf("deprecated version of platform %q: %w", rule.Platform, err)
+			}
+		}
+		ret = append(ret, compiled)
+	}
+	return ret, nil
+}
+
+// Gate checks versions of clients
+type Gate struct {
+	config *Config
+	rules  atomic.Value // []compiledRule
+}
+
+func (g *Gate) reload() {
+	var config struct{ Rules []Rule }
+	if err := conf.UnmarshalKey(g.config.key, &config); err != nil {
+		g.config.logger.Error("versiongate reload rules", xlog.FieldErr(err), xlog.FieldKey(g.config.key))
+		return
+	}
+	rules, err := compile(config.Rules)
+	if err != nil {
+		g.config.logger.Error("versiongate reload rules", xlog.FieldErr(err), xlog.FieldKey(g.config.key))
+		return
+	}
+	g.rules.Store(rules)
+}
+
+func (g *Gate) rule(platform string) *compiledRule {
+	rules := g.rules.Load().([]compiledRule)
+	var fallback *compiledRule
+	for i := range rules {
+		switch rules[i].Platform {
+		case platform:
+			return &rules[i]
+		case "":
+			if fallback == nil {
+				fallback = &rules[i]
+			}
+		}
+	}
+	return fallback
+}
+
+// Check returns the decision of version of a client on platform
+func (g *Gate) Check(platform, version string) Decision {
+	decision := Decision{Platform: strings.ToLower(platform), Result: ResultOK}
+	rule := g.rule(decision.Platform)
+	if rule != nil {
+		decision.Rule = &rule.Rule
+	}
+	v, err := ParseVersion(version)
+	if err != nil {
+		decision.Result = ResultMissing
+		return decision
+	}
+	decision.Version = v
+	switch {
+	case rule == nil:
+	case rule.min != nil && v.Compare(rule.min) < 0:
+		decision.Result = ResultRejected
+	case rule.deprecated != nil && v.Compare(rule.deprecated) < 0:
+		decision.Result = ResultDeprecated
+	}
+	return decision
+}
+
+// Handler checks clients of requests, rejecting unsupported versions with
+// 426 and missing ones with 400 if RejectMissing, and warning deprecated
+// versions with the Warning header. The decision is attached to request
+// context, see FromContext. Echo users can apply it with echo.WrapMiddleware.
+func (g *Gate) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range g.config.Skip {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		platform, version := g.extract(r)
+		decision := g.Check(platform, version)
+		requestCounter.Inc(g.config.Name, g.label(decision), decision.Result)
+		switch decision.Result {
+		case ResultRejected:
+			rule := decision.Rule
+			g.reject(w, http.StatusUpgradeRequired, Error{
+				Code:       CodeUnsupported,
+				Message:    message(rule.Message, "client version "+decision.Version.String()+" is no longer supported, please upgrade"),
+				MinVersion: rule.MinVersion,
+				UpgradeURL: rule.UpgradeURL,
+			})
+			return
+		case ResultMissing:
+			if g.config.RejectMissing {
+				g.reject(w, http.StatusBadRequest, Error{
+					Code:    CodeMissing,
+					Message: "client version is missing or invalid",
+				})
+				return
+			}
+		case ResultDeprecated:
+			rule := decision.Rule
+			msg := message(rule.Message, "client version "+decision.Version.String()+" is deprecated, please upgrade")
+			w.Header().Set("Warning", "299 - "+strconv.Quote(msg))
+			if rule.UpgradeURL != "" {
+				w.Header().Set("X-Upgrade-URL", rule.UpgradeURL)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), decision)))
+	})
+}
+
+func message(msg, fallback string) string {
+	if msg != "" {
+		return msg
+	}
+	return fallback
+}
+
+func (g *Gate) reject(w http.ResponseWriter, code int, body Error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// label bounds platforms of metrics to those of rules
+func (g *Gate) label(decision Decision) string {
+	if decision.Platform == "" {
+		return "unknown"
+	}
+	if rule := decision.Rule; rule != nil && strings.EqualFold(rule.Platform, decision.Platform) {
+		return decision.Platform
+	}
+	return "other"
+}
+
+var (
+	androidPattern = regexp.MustCompile(`(?i)android`)
+	iosPattern     = regexp.MustCompile(`(?i)iphone|ipad|ios|darwin`)
+)
+
+// extract returns the platform and version of the client of r
+func (g *Gate) extract(r *http.Request) (platform, version string) {
+	ua := r.Header.Get("User-Agent")
+	platform = r.Header.Get(g.config.PlatformHeader)
+	if platform == "" {
+		switch {
+		case androidPattern.MatchString(ua):
+			platform = "android"
+		case iosPattern.MatchString(ua):
+			platform = "ios"
+		}
+	}
+	version = r.Header.Get(g.config.Header)
+	if version == "" && g.config.UserAgentProduct != "" {
+		prefix := g.config.UserAgentProduct + "/"
+		for _, token := range strings.Fields(ua) {
+			if strings.HasPrefix(token, prefix) {
+				version = strings.TrimPrefix(token, prefix)
+				break
+			}
+		}
+	}
+	return platform, version
+}
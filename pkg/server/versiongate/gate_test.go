@@ -0,0 +1,118 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package versiongate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("v6.1.2-beta+1")
+	assert.Nil(t, err)
+	assert.Equal(t, Version{6, 1, 2}, v)
+	assert.Equal(t, "6.1.2", v.String())
+
+	assert.Equal(t, 0, Version{6, 1}.Compare(Version{6, 1, 0}))
+	assert.Equal(t, -1, Version{6, 1}.Compare(Version{6, 10}))
+	assert.Equal(t, 1, Version{7}.Compare(Version{6, 99, 99}))
+
+	for _, invalid := range []string{"", "6..1", "a.b", "6.-1"} {
+		_, err = ParseVersion(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func newTestGate(rejectMissing bool) *Gate {
+	config := DefaultConfig()
+	config.UserAgentProduct = "DemoApp"
+	config.RejectMissing = rejectMissing
+	config.Skip = []string{"/health"}
+	config.Rules = []Rule{
+		{Platform: "Android", MinVersion: "6.0", DeprecatedVersion: "6.2", UpgradeURL: "https://example.com/android"},
+		{MinVersion: "5.0", Message: "please upgrade"},
+	}
+	return config.Build()
+}
+
+func serve(g *Gate, path string, header map[string]string) (*httptest.ResponseRecorder, *Decision) {
+	var decision *Decision
+	handler := g.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d, ok := FromContext(r.Context()); ok {
+			decision = &d
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w, decision
+}
+
+func TestGate_Handler(t *testing.T) {
+	g := newTestGate(false)
+
+	w, decision := serve(g, "/", map[string]string{"X-Client-Version": "6.3", "X-Client-Platform": "android"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ResultOK, decision.Result)
+
+	// deprecated versions are served with warnings
+	w, decision = serve(g, "/", map[string]string{"User-Agent": "DemoApp/6.1.0 (Linux; Android 10)"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ResultDeprecated, decision.Result)
+	assert.Equal(t, "android", decision.Platform)
+	assert.Equal(t, `299 - "client version 6.1.0 is deprecated, please upgrade"`, w.Header().Get("Warning"))
+	assert.Equal(t, "https://example.com/android", w.Header().Get("X-Upgrade-URL"))
+
+	w, _ = serve(g, "/", map[string]string{"X-Client-Version": "5.9.9", "X-Client-Platform": "android"})
+	assert.Equal(t, http.StatusUpgradeRequired, w.Code)
+	var body Error
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, Error{
+		Code:       CodeUnsupported,
+		Message:    "client version 5.9.9 is no longer supported, please upgrade",
+		MinVersion: "6.0",
+		UpgradeURL: "https://example.com/android",
+	}, body)
+
+	// the rule without platform applies to the others
+	w, _ = serve(g, "/", map[string]string{"X-Client-Version": "4.0", "X-Client-Platform": "ios"})
+	assert.Equal(t, http.StatusUpgradeRequired, w.Code)
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "please upgrade", body.Message)
+
+	w, decision = serve(g, "/", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ResultMissing, decision.Result)
+
+	w, _ = serve(newTestGate(true), "/", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, decision = serve(newTestGate(true), "/health", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, decision)
+}
+
+func TestGate_label(t *testing.T) {
+	g := newTestGate(false)
+	assert.Equal(t, "android", g.label(g.Check("Android", "6.0")))
+	assert.Equal(t, "other", g.label(g.Check("symbian", "6.0")))
+	assert.Equal(t, "unknown", g.label(g.Check("", "6.0")))
+}
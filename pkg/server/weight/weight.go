@@ -0,0 +1,118 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package weight overrides weights of registered services at runtime, e.g.
+// lowering the weight of a warming up or overloaded instance, without
+// changing configs of servers. It's set on governor POST /weight?weight=50
+// and reset to configured weights on DELETE /weight.
+package weight
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+var (
+	mu sync.Mutex
+	// override is negative if it's not set
+	override  float64 = -1
+	listeners []func()
+
+	logger = xlog.JupiterLogger.With(xlog.FieldMod("weight"))
+)
+
+func init() {
+	governor.HandleFunc("/weight", handle)
+}
+
+func handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		weight, err := strconv.ParseFloat(r.URL.Query().Get("weight"), 64)
+		if err != nil || weight < 0 {
+			http.Error(w, "weight must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		Set(weight)
+	case http.MethodDelete:
+		Reset()
+	}
+	weight, ok := Get()
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"weight":     weight,
+		"overridden": ok,
+	})
+}
+
+// Set overrides weights of all services with weight, 0 drains the instance
+// while it's still registered
+func Set(weight float64) {
+	update(weight)
+}
+
+// Reset drops the override, services are registered with configured weights
+func Reset() {
+	update(-1)
+}
+
+func update(weight float64) {
+	mu.Lock()
+	if weight == override || weight < 0 && override < 0 {
+		mu.Unlock()
+		return
+	}
+	override = weight
+	fns := listeners
+	mu.Unlock()
+
+	logger.Warn("weight overridden", xlog.Any("weight", weight))
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// Get returns the overriding weight, false if it's not set
+func Get() (float64, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	if override < 0 {
+		return 0, false
+	}
+	return override, true
+}
+
+// OnChange registers fn called once the override is set or reset
+func OnChange(fn func()) {
+	mu.Lock()
+	listeners = append(listeners, fn)
+	mu.Unlock()
+}
+
+// Apply returns a copy of info with the overriding weight if it's set, or
+// else info itself
+func Apply(info *server.ServiceInfo) *server.ServiceInfo {
+	weight, ok := Get()
+	if !ok {
+		return info
+	}
+	var copied = *info
+	copied.Weight = weight
+	return &copied
+}
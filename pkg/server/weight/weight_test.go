@@ -0,0 +1,67 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package weight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeight(t *testing.T) {
+	defer Reset()
+
+	var changes int
+	OnChange(func() { changes++ })
+
+	info := &server.ServiceInfo{Address: "127.0.0.1:9527", Weight: 100}
+	assert.Equal(t, info, Apply(info))
+
+	Set(0)
+	w, ok := Get()
+	assert.True(t, ok)
+	assert.Equal(t, float64(0), w)
+	assert.Equal(t, float64(0), Apply(info).Weight)
+	assert.Equal(t, float64(100), info.Weight)
+
+	// unchanged weights don't notify
+	Set(0)
+	Reset()
+	Reset()
+	assert.Equal(t, info, Apply(info))
+	assert.Equal(t, 2, changes)
+}
+
+func TestHandler(t *testing.T) {
+	defer Reset()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/weight", handle)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/weight?weight=-1", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/weight?weight=50", nil))
+	assert.JSONEq(t, `{"weight":50,"overridden":true}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/weight", nil))
+	assert.JSONEq(t, `{"weight":0,"overridden":false}`, rec.Body.String())
+}
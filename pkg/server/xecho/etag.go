@@ -0,0 +1,197 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xecho
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Headers of conditional requests
+const (
+	// HeaderETag ...
+	HeaderETag = "ETag"
+	// HeaderIfNoneMatch ...
+	HeaderIfNoneMatch = "If-None-Match"
+	// HeaderLastModified ...
+	HeaderLastModified = "Last-Modified"
+	// HeaderIfModifiedSince ...
+	HeaderIfModifiedSince = "If-Modified-Since"
+)
+
+// ETag returns the strong or weak entity tag of body
+func ETag(body []byte, weak bool) string {
+	sum := sha1.Sum(body)
+	tag := `"` + hex.EncodeToString(sum[:]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// CheckNotModified sets ETag and Last-Modified if not empty, and responds 304
+// if the request is satisfied by them, so handlers can skip building the body.
+//
+//	if xecho.CheckNotModified(c, version, updatedAt) {
+//		return nil
+//	}
+func CheckNotModified(c echo.Context, etag string, modTime time.Time) bool {
+	header := c.Response().Header()
+	if etag != "" {
+		header.Set(HeaderETag, etag)
+	}
+	if !modTime.IsZero() {
+		header.Set(HeaderLastModified, modTime.UTC().Format(http.TimeFormat))
+	}
+	if !notModified(c.Request(), etag, modTime) {
+		return false
+	}
+	writeNotModified(c.Response())
+	return true
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since if the former is absent
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get(HeaderIfNoneMatch); inm != "" {
+		return etag != "" && matchETag(inm, etag)
+	}
+	ims := r.Header.Get(HeaderIfModifiedSince)
+	if ims == "" || modTime.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// Last-Modified has second precision
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// matchETag compares the If-None-Match list with etag weakly, as RFC 7232 requires
+func matchETag(inm string, etag string) bool {
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	header := w.Header()
+	header.Del(HeaderContentType)
+	header.Del(echo.HeaderContentLength)
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// etagMiddleware buffers successful JSON responses of GET and HEAD requests,
+// tags them with ETag unless the handler did, and responds 304 if the request
+// is satisfied by ETag or Last-Modified.
+func etagMiddleware(weak bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			if method != http.MethodGet && method != http.MethodHead {
+				return next(c)
+			}
+
+			resp := c.Response()
+			writer := &etagWriter{ResponseWriter: resp.Writer, status: http.StatusOK}
+			resp.Writer = writer
+			defer func() { resp.Writer = writer.ResponseWriter }()
+
+			if err := next(c); err != nil || !writer.wroteHeader {
+				// the error handler writes through the original writer
+				if writer.wroteHeader {
+					writer.flush()
+				}
+				return err
+			}
+			if writer.status != http.StatusOK || writer.passthrough {
+				writer.flush()
+				return nil
+			}
+
+			header := writer.Header()
+			etag := header.Get(HeaderETag)
+			if etag == "" {
+				etag = ETag(writer.body.Bytes(), weak)
+				header.Set(HeaderETag, etag)
+			}
+			var modTime time.Time
+			if lm := header.Get(HeaderLastModified); lm != "" {
+				modTime, _ = http.ParseTime(lm)
+			}
+			if notModified(c.Request(), etag, modTime) {
+				writeNotModified(writer.ResponseWriter)
+				return nil
+			}
+			writer.flush()
+			return nil
+		}
+	}
+}
+
+// etagWriter buffers JSON bodies of 200 responses, anything else passes through
+type etagWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	passthrough bool
+	body        bytes.Buffer
+}
+
+// WriteHeader ...
+func (w *etagWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	contentType := w.Header().Get(HeaderContentType)
+	if status != http.StatusOK || !strings.HasPrefix(contentType, MIMEApplicationJSON) {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// Write ...
+func (w *etagWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.body.Write(p)
+}
+
+// flush writes the buffered response through
+func (w *etagWriter) flush() {
+	if w.passthrough {
+		return
+	}
+	w.passthrough = true
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(w.body.Bytes())
+}
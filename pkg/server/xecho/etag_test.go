@@ -0,0 +1,111 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xecho
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newETagServer(weak bool) *echo.Echo {
+	e := echo.New()
+	e.Use(etagMiddleware(weak))
+	e.GET("/list", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, []string{"a", "b"})
+	})
+	e.GET("/text", func(c echo.Context) error {
+		return c.String(http.StatusOK, "text")
+	})
+	e.GET("/fail", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad")
+	})
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.GET("/cond", func(c echo.Context) error {
+		if CheckNotModified(c, `"v1"`, modTime) {
+			return nil
+		}
+		return c.JSON(http.StatusOK, "body")
+	})
+	return e
+}
+
+func serve(e *echo.Echo, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, req)
+	return w
+}
+
+func TestETagMiddleware(t *testing.T) {
+	e := newETagServer(false)
+	w := serve(e, "/list", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get(HeaderETag)
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, `["a","b"]`+"\n", w.Body.String())
+
+	w = serve(e, "/list", http.Header{HeaderIfNoneMatch: {`"other", ` + etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = serve(e, "/list", http.Header{HeaderIfNoneMatch: {`"other"`}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(e, "/text", nil)
+	assert.Empty(t, w.Header().Get(HeaderETag))
+	assert.Equal(t, "text", w.Body.String())
+
+	w = serve(e, "/fail", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get(HeaderETag))
+
+	weak := serve(newETagServer(true), "/list", nil).Header().Get(HeaderETag)
+	assert.Equal(t, "W/"+etag, weak)
+	// weak comparison
+	w = serve(e, "/list", http.Header{HeaderIfNoneMatch: {weak}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func TestCheckNotModified(t *testing.T) {
+	e := newETagServer(false)
+	w := serve(e, "/cond", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"v1"`, w.Header().Get(HeaderETag))
+	assert.Equal(t, "Wed, 01 Jan 2020 00:00:00 GMT", w.Header().Get(HeaderLastModified))
+
+	w = serve(e, "/cond", http.Header{HeaderIfNoneMatch: {`"v1"`}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = serve(e, "/cond", http.Header{HeaderIfModifiedSince: {"Thu, 02 Jan 2020 00:00:00 GMT"}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = serve(e, "/cond", http.Header{HeaderIfModifiedSince: {"Tue, 31 Dec 2019 00:00:00 GMT"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// If-None-Match takes precedence over If-Modified-Since
+	w = serve(e, "/cond", http.Header{
+		HeaderIfNoneMatch:     {`"v0"`},
+		HeaderIfModifiedSince: {"Thu, 02 Jan 2020 00:00:00 GMT"},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -18,12 +18,21 @@ import (
 	"fmt"
 	"net/http"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/deprecation"
+	"github.com/douyu/jupiter/pkg/server/harcapture"
+	"github.com/douyu/jupiter/pkg/server/maintenance"
+	"github.com/douyu/jupiter/pkg/server/scope"
 	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/douyu/jupiter/pkg/util/xwatchdog"
+	"github.com/douyu/jupiter/pkg/xdiag"
 
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xrecorder"
+	"github.com/douyu/jupiter/pkg/xstat"
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
@@ -38,6 +47,9 @@ func recoverMiddleware(logger *xlog.Logger, slowQueryThresholdInMilli int64) ech
 		return func(ctx echo.Context) (err error) {
 			var beg = time.Now()
 			var fields = make([]xlog.Field, 0, 8)
+			reqCtx, store, end := scope.Start(ctx.Request().Context())
+			ctx.SetRequest(ctx.Request().WithContext(reqCtx))
+			defer end()
 
 			defer func() {
 				fields = append(fields, zap.Float64("cost", time.Since(beg).Seconds()))
@@ -51,7 +63,7 @@ func recoverMiddleware(logger *xlog.Logger, slowQueryThresholdInMilli int64) ech
 
 					stack := make([]byte, 4096)
 					length := runtime.Stack(stack, true)
-					fields = append(fields, zap.ByteString("stack", stack[:length]))
+					fields = append(fields, zap.ByteString("stack", stack[:length]), zap.Any("scope", store.Dump()))
 				}
 				fields = append(fields,
 					zap.String("method", ctx.Request().Method),
@@ -89,6 +101,7 @@ func metricServerInterceptor() echo.MiddlewareFunc {
 			}
 			metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, method, peer)
 			metric.ServerHandleCounter.Inc(metric.TypeHTTP, method, peer, http.StatusText(c.Response().Status))
+			xstat.RecordMethod(metric.TypeHTTP, method, time.Since(beg), err != nil || c.Response().Status >= http.StatusInternalServerError)
 			return err
 		}
 	}
@@ -112,3 +125,108 @@ func traceServerInterceptor() echo.MiddlewareFunc {
 		}
 	}
 }
+
+func recorderServerInterceptor() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			beg := time.Now()
+			// errors are written here so that their statuses are recorded,
+			// echo's error handler skips committed responses later
+			if err = next(c); err != nil {
+				c.Error(err)
+			}
+			xrecorder.Add(xrecorder.Record{
+				Time:     beg,
+				Type:     metric.TypeHTTP,
+				Method:   c.Request().Method + " " + c.Path(),
+				Peer:     c.RealIP(),
+				Status:   strconv.Itoa(c.Response().Status),
+				Duration: time.Since(beg),
+				TraceID:  trace.ExtractTraceID(c.Request().Context()),
+			})
+			return err
+		}
+	}
+}
+
+func watchdogMiddleware(watchdog *xwatchdog.Watchdog) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx, done := watchdog.Watch(c.Request().Context(), c.Request().Method+" "+c.Path())
+			defer done()
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+func maintenanceMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !maintenance.Allowed(c.Request().URL.Path) {
+				c.Response().Header().Set("Retry-After", maintenance.RetryAfter())
+				return c.String(http.StatusServiceUnavailable, "server is under maintenance")
+			}
+			return next(c)
+		}
+	}
+}
+
+func deprecationMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if endpoint, ok := deprecation.Lookup(c.Request().Method, c.Path()); ok {
+				for key, value := range endpoint.Headers() {
+					c.Response().Header().Set(key, value)
+				}
+				caller := extractAID(c)
+				if caller == "" {
+					caller = c.RealIP()
+				}
+				deprecation.Observe(endpoint, caller)
+			}
+			return next(c)
+		}
+	}
+}
+
+func diagnosticsServerInterceptor(mirror *xdiag.Mirror) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			err = next(c)
+			// the status of error is written by echo's error handler later
+			status := c.Response().Status
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			} else if err != nil {
+				status = http.StatusInternalServerError
+			}
+			if code := strconv.Itoa(status); mirror.Selected(code) {
+				mirror.Capture(c.Request().Context(), c.Request().Method+" "+c.Path(), code, err, c.QueryParams(), c.Request().Header)
+			}
+			return err
+		}
+	}
+}
+
+func harCaptureMiddleware(recorder *harcapture.Recorder) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			exchange := recorder.Begin(c.Request())
+			if exchange == nil {
+				return next(c)
+			}
+			res := c.Response()
+			writer := res.Writer
+			res.Writer = exchange.Wrap(writer)
+			defer func() { res.Writer = writer }()
+			// errors are written here so that they're captured, echo's error
+			// handler skips committed responses later
+			if err = next(c); err != nil {
+				c.Error(err)
+			}
+			exchange.Finish(res.Status, res.Header())
+			return err
+		}
+	}
+}
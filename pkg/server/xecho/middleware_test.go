@@ -0,0 +1,84 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xecho
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server/scope"
+	"github.com/douyu/jupiter/pkg/util/xwatchdog"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xrecorder"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverMiddleware_Scope(t *testing.T) {
+	var user = scope.NewKey("user")
+	var reqCtx context.Context
+	e := echo.New()
+	e.Use(recoverMiddleware(xlog.JupiterLogger, 0))
+	e.GET("/user", func(c echo.Context) error {
+		reqCtx = c.Request().Context()
+		assert.True(t, user.Set(reqCtx, "alice"))
+		value, _ := user.Get(reqCtx)
+		return c.String(http.StatusOK, value.(string))
+	})
+	e.GET("/panic", func(c echo.Context) error {
+		user.Set(c.Request().Context(), "bob")
+		panic("boom")
+	})
+
+	w := serve(e, "/user", nil)
+	assert.Equal(t, "alice", w.Body.String())
+	// values are cleared once the request ends
+	_, ok := user.Get(reqCtx)
+	assert.False(t, ok)
+
+	w = serve(e, "/panic", nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestRecorderServerInterceptor_Error(t *testing.T) {
+	e := echo.New()
+	e.Use(recorderServerInterceptor())
+	e.GET("/missing", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusNotFound)
+	})
+
+	w := serve(e, "/missing", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	records := xrecorder.Default.Query(xrecorder.Filter{Method: "GET /missing", Limit: 1})
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "404", records[0].Status)
+	}
+}
+
+func TestWatchdogMiddleware(t *testing.T) {
+	var labeled bool
+	e := echo.New()
+	e.Use(watchdogMiddleware(xwatchdog.DefaultConfig().Build()))
+	e.GET("/slow", func(c echo.Context) error {
+		// handlers are labeled, so that their stacks can be found
+		_, labeled = pprof.Label(c.Request().Context(), xwatchdog.LabelKey)
+		return c.NoContent(http.StatusOK)
+	})
+
+	serve(e, "/slow", nil)
+	assert.True(t, labeled)
+}
@@ -23,7 +23,9 @@ import (
 
 	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
 	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xwatchdog"
 	"github.com/douyu/jupiter/pkg/xlog"
 	"github.com/labstack/echo/v4"
 )
@@ -33,6 +35,7 @@ type Server struct {
 	*echo.Echo
 	config   *Config
 	listener net.Listener
+	watchdog *xwatchdog.Watchdog
 }
 
 func newServer(config *Config) *Server {
@@ -41,8 +44,10 @@ func newServer(config *Config) *Server {
 		config.logger.Panic("new xecho server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
 	}
 	config.Port = listener.Addr().(*net.TCPAddr).Port
+	e := echo.New()
+	e.Server.ConnState = metric.ConnStateHook(config.Address())
 	return &Server{
-		Echo:     echo.New(),
+		Echo:     e,
 		config:   config,
 		listener: listener,
 	}
@@ -58,6 +63,10 @@ func (s *Server) Serve() error {
 		s.config.logger.Info("add route", xlog.FieldMethod(route.Method), xlog.String("path", route.Path))
 	}
 	s.Echo.Listener = s.listener
+	if s.watchdog != nil {
+		s.watchdog.Start()
+		defer s.watchdog.Stop()
+	}
 	err := s.Echo.Start("")
 	if err != http.ErrServerClosed {
 		return err
@@ -85,6 +94,8 @@ func (s *Server) Info() *server.ServiceInfo {
 		server.WithScheme("http"),
 		server.WithAddress(s.listener.Addr().String()),
 		server.WithKind(constant.ServiceProvider),
+		server.WithLabels(s.config.Labels),
+		server.WithWeight(s.config.Weight),
 	)
 	// info.Name = info.Name + "." + ModName
 	return &info
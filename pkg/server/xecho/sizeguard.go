@@ -0,0 +1,131 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xecho
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/douyu/jupiter/pkg/server/sizeguard"
+	"github.com/labstack/echo/v4"
+)
+
+// sizeguardMiddleware measures responses of routes guarded by sizeguard.
+// In strict mode, responses above the threshold are replaced by 500 if their
+// size is known before the header is written, i.e. written at once as
+// c.JSON does, or with Content-Length. Streamed responses are only reported.
+func sizeguardMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			limit, ok := sizeguard.Lookup(c.Request().Method, c.Path())
+			if !ok {
+				return next(c)
+			}
+
+			resp := c.Response()
+			writer := &sizeWriter{ResponseWriter: resp.Writer, resp: resp, limit: limit}
+			resp.Writer = writer
+			defer func() { resp.Writer = writer.ResponseWriter }()
+
+			err := next(c)
+			writer.commit()
+			if !writer.rejected {
+				// too late to reject
+				limit.Strict = false
+				_ = sizeguard.Observe(limit, writer.size)
+			}
+			return err
+		}
+	}
+}
+
+// sizeWriter counts bytes written, and holds the header until the first
+// write, so that the response can still be rejected
+type sizeWriter struct {
+	http.ResponseWriter
+	resp      *echo.Response
+	limit     sizeguard.Limit
+	status    int
+	committed bool
+	rejected  bool
+	size      int64
+}
+
+// WriteHeader ...
+func (w *sizeWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write ...
+func (w *sizeWriter) Write(p []byte) (int, error) {
+	if !w.committed && w.limit.Strict {
+		size := int64(len(p))
+		if n, err := strconv.ParseInt(w.Header().Get(echo.HeaderContentLength), 10, 64); err == nil && n > size {
+			size = n
+		}
+		if w.limit.Exceeded(size) {
+			w.reject(size)
+		}
+	}
+	if w.rejected {
+		return len(p), nil
+	}
+	w.commit()
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush ...
+func (w *sizeWriter) Flush() {
+	w.commit()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack ...
+func (w *sizeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func (w *sizeWriter) commit() {
+	if w.committed || w.status == 0 {
+		return
+	}
+	w.committed = true
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// reject responds 500 instead, the body is discarded
+func (w *sizeWriter) reject(size int64) {
+	err := sizeguard.Observe(w.limit, size)
+	if err == nil {
+		return
+	}
+	w.rejected = true
+	w.committed = true
+	w.resp.Status = http.StatusInternalServerError
+	header := w.Header()
+	header.Del(echo.HeaderContentLength)
+	header.Del(HeaderETag)
+	header.Set(HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	w.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+	_, _ = w.ResponseWriter.Write([]byte(err.Error()))
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xecho
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server/sizeguard"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeguardMiddleware(t *testing.T) {
+	sizeguard.Set(sizeguard.Config{Threshold: 16, Routes: []sizeguard.Limit{
+		{Route: "GET /strict", Strict: true},
+	}})
+	defer sizeguard.Set(sizeguard.DefaultConfig())
+
+	body := strings.Repeat("a", 32)
+	e := echo.New()
+	e.Use(sizeguardMiddleware())
+	e.GET("/lenient", func(c echo.Context) error {
+		return c.String(http.StatusOK, body)
+	})
+	e.GET("/strict", func(c echo.Context) error {
+		return c.String(http.StatusOK, body)
+	})
+	e.GET("/small", func(c echo.Context) error {
+		return c.String(http.StatusCreated, "ok")
+	})
+	e.GET("/stream", func(c echo.Context) error {
+		c.Response().WriteHeader(http.StatusOK)
+		for i := 0; i < 4; i++ {
+			_, _ = c.Response().Write([]byte(body[:8]))
+			c.Response().Flush()
+		}
+		return nil
+	})
+
+	w := serve(e, "/lenient", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, body, w.Body.String())
+
+	w = serve(e, "/strict", nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "paginate")
+
+	w = serve(e, "/small", nil)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+
+	sizeguard.Set(sizeguard.Config{Threshold: 16, Strict: true})
+	w = serve(e, "/stream", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, body, w.Body.String())
+}
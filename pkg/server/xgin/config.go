@@ -16,14 +16,23 @@ package xgin
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/server/harcapture"
+	"github.com/douyu/jupiter/pkg/util/xwatchdog"
+	"github.com/douyu/jupiter/pkg/xdiag"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
 )
 
+func init() {
+	xschema.RegisterConfig("server.gin", "jupiter.server.*", "gin http server", DefaultConfig)
+}
+
 //ModName ..
 const ModName = "server.gin"
 
@@ -36,9 +45,27 @@ type Config struct {
 	DisableMetric bool
 	DisableTrace  bool
 
-	SlowQueryThresholdInMilli int64
+	// DisableRecorder disable recording recent requests for governor
+	DisableRecorder bool
 
-	logger *xlog.Logger
+	// WatchdogThreshold logs stacks of requests running longer than it, disabled if zero
+	WatchdogThreshold time.Duration
+	// WatchdogDeadlineFactor logs stacks of requests running longer than factor × deadline, disabled if zero
+	WatchdogDeadlineFactor float64
+	// WatchdogCancelGrace logs stacks of requests running longer than it after
+	// clients gave up, i.e. handlers ignoring cancellation, disabled if zero.
+	// Routes are listed on governor /debug/grpc/cancellations along with grpc methods.
+	WatchdogCancelGrace time.Duration
+
+	SlowQueryThresholdInMilli int64
+	// Labels are registered with the service, matched by label selectors of consumers
+	Labels map[string]string
+	// Weight is registered with the service for weighted balancers, 100 if zero
+	Weight float64
+
+	logger      *xlog.Logger
+	diagnostics *xdiag.Mirror
+	har         *harcapture.Recorder
 }
 
 // DefaultConfig ...
@@ -85,10 +112,28 @@ func (config *Config) WithPort(port int) *Config {
 	return config
 }
 
+// WithDiagnostics mirrors failed requests to diagnostics
+func (config *Config) WithDiagnostics(mirror *xdiag.Mirror) *Config {
+	config.diagnostics = mirror
+	return config
+}
+
+// WithHARCapture captures sampled requests to HAR files with recorder
+func (config *Config) WithHARCapture(recorder *harcapture.Recorder) *Config {
+	config.har = recorder
+	return config
+}
+
 // Build create server instance, then initialize it with necessary interceptor
 func (config *Config) Build() *Server {
 	server := newServer(config)
+	// outermost, so that requests are watched as long as they're served
+	if server.watchdog = config.watchdog(); server.watchdog != nil {
+		server.Use(watchdogMiddleware(server.watchdog))
+	}
 	server.Use(recoverMiddleware(config.logger, config.SlowQueryThresholdInMilli))
+	server.Use(maintenanceMiddleware())
+	server.Use(deprecationMiddleware())
 
 	if !config.DisableMetric {
 		server.Use(metricServerInterceptor())
@@ -97,9 +142,36 @@ func (config *Config) Build() *Server {
 	if !config.DisableTrace {
 		server.Use(traceServerInterceptor())
 	}
+
+	if config.diagnostics != nil {
+		server.Use(diagnosticsServerInterceptor(config.diagnostics))
+	}
+
+	if config.har != nil {
+		server.Use(harCaptureMiddleware(config.har))
+	}
+
+	if !config.DisableRecorder {
+		server.Use(recorderServerInterceptor())
+	}
+
+	// inside metric and recorder, so that rejections are seen by them
+	server.Use(sizeguardMiddleware())
 	return server
 }
 
+// watchdog returns the watchdog of slow requests, nil if disabled
+func (config *Config) watchdog() *xwatchdog.Watchdog {
+	if config.WatchdogThreshold <= 0 && config.WatchdogDeadlineFactor <= 0 && config.WatchdogCancelGrace <= 0 {
+		return nil
+	}
+	wc := xwatchdog.DefaultConfig().WithLogger(config.logger)
+	wc.Threshold = config.WatchdogThreshold
+	wc.DeadlineFactor = config.WatchdogDeadlineFactor
+	wc.CancelGrace = config.WatchdogCancelGrace
+	return wc.Build()
+}
+
 // Address ...
 func (config *Config) Address() string {
 	return fmt.Sprintf("%s:%d", config.Host, config.Port)
@@ -15,6 +15,7 @@
 package xgin
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"io/ioutil"
@@ -22,14 +23,23 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/deprecation"
+	"github.com/douyu/jupiter/pkg/server/harcapture"
+	"github.com/douyu/jupiter/pkg/server/maintenance"
+	"github.com/douyu/jupiter/pkg/server/scope"
 	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/douyu/jupiter/pkg/util/xwatchdog"
+	"github.com/douyu/jupiter/pkg/xdiag"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xrecorder"
+	"github.com/douyu/jupiter/pkg/xstat"
 	"go.uber.org/zap"
 )
 
@@ -49,6 +59,9 @@ func recoverMiddleware(logger *xlog.Logger, slowQueryThresholdInMilli int64) gin
 		var beg = time.Now()
 		var fields = make([]xlog.Field, 0, 8)
 		var brokenPipe bool
+		reqCtx, store, end := scope.Start(c.Request.Context())
+		c.Request = c.Request.WithContext(reqCtx)
+		defer end()
 		defer func() {
 			//Latency
 			fields = append(fields, zap.Float64("cost", time.Since(beg).Seconds()))
@@ -66,7 +79,7 @@ func recoverMiddleware(logger *xlog.Logger, slowQueryThresholdInMilli int64) gin
 					}
 				}
 				var err = rec.(error)
-				fields = append(fields, zap.ByteString("stack", stack(3)))
+				fields = append(fields, zap.ByteString("stack", stack(3)), zap.Any("scope", store.Dump()))
 				fields = append(fields, zap.String("err", err.Error()))
 				logger.Error("access", fields...)
 				// If the connection is dead, we can't write a status to it.
@@ -167,6 +180,7 @@ func metricServerInterceptor() gin.HandlerFunc {
 		c.Next()
 		metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, c.Request.Method+"."+c.Request.URL.Path, extractAID(c))
 		metric.ServerHandleCounter.Inc(metric.TypeHTTP, c.Request.Method+"."+c.Request.URL.Path, extractAID(c), http.StatusText(c.Writer.Status()))
+		xstat.RecordMethod(metric.TypeHTTP, c.Request.Method+"."+c.Request.URL.Path, time.Since(beg), c.Writer.Status() >= http.StatusInternalServerError)
 		return
 	}
 }
@@ -188,3 +202,110 @@ func traceServerInterceptor() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+func recorderServerInterceptor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		beg := time.Now()
+		c.Next()
+		xrecorder.Add(xrecorder.Record{
+			Time:     beg,
+			Type:     metric.TypeHTTP,
+			Method:   c.Request.Method + " " + c.Request.URL.Path,
+			Peer:     c.ClientIP(),
+			Status:   strconv.Itoa(c.Writer.Status()),
+			Duration: time.Since(beg),
+			TraceID:  trace.ExtractTraceID(c.Request.Context()),
+		})
+	}
+}
+
+func watchdogMiddleware(watchdog *xwatchdog.Watchdog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, done := watchdog.Watch(c.Request.Context(), c.Request.Method+" "+c.FullPath())
+		defer done()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+func maintenanceMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !maintenance.Allowed(c.Request.URL.Path) {
+			c.Header("Retry-After", maintenance.RetryAfter())
+			c.String(http.StatusServiceUnavailable, "server is under maintenance")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func deprecationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if endpoint, ok := deprecation.Lookup(c.Request.Method, c.FullPath()); ok {
+			for key, value := range endpoint.Headers() {
+				c.Header(key, value)
+			}
+			caller := c.GetHeader("AID")
+			if caller == "" {
+				caller = c.ClientIP()
+			}
+			deprecation.Observe(endpoint, caller)
+		}
+		c.Next()
+	}
+}
+
+func diagnosticsServerInterceptor(mirror *xdiag.Mirror) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if code := strconv.Itoa(c.Writer.Status()); mirror.Selected(code) {
+			var err error
+			if last := c.Errors.Last(); last != nil {
+				err = last.Err
+			}
+			mirror.Capture(c.Request.Context(), c.Request.Method+" "+c.Request.URL.Path, code, err, c.Request.URL.Query(), c.Request.Header)
+		}
+	}
+}
+
+func harCaptureMiddleware(recorder *harcapture.Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		exchange := recorder.Begin(c.Request)
+		if exchange == nil {
+			c.Next()
+			return
+		}
+		c.Writer = &harWriter{ResponseWriter: c.Writer, exchange: exchange}
+		c.Next()
+		exchange.Finish(c.Writer.Status(), c.Writer.Header())
+	}
+}
+
+// harWriter tees response bodies to the exchange
+type harWriter struct {
+	gin.ResponseWriter
+	exchange *harcapture.Exchange
+}
+
+// Write ...
+func (w *harWriter) Write(p []byte) (int, error) {
+	w.exchange.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// WriteString ...
+func (w *harWriter) WriteString(s string) (int, error) {
+	w.exchange.Write([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Flush ...
+func (w *harWriter) Flush() {
+	w.ResponseWriter.Flush()
+}
+
+// Hijack ...
+func (w *harWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.Hijack()
+}
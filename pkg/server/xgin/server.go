@@ -22,7 +22,9 @@ import (
 
 	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
 	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xwatchdog"
 	"github.com/douyu/jupiter/pkg/xlog"
 	"github.com/gin-gonic/gin"
 )
@@ -33,6 +35,7 @@ type Server struct {
 	Server   *http.Server
 	config   *Config
 	listener net.Listener
+	watchdog *xwatchdog.Watchdog
 }
 
 func newServer(config *Config) *Server {
@@ -63,8 +66,13 @@ func (s *Server) Serve() error {
 		s.config.logger.Info("add route", xlog.FieldMethod(route.Method), xlog.String("path", route.Path))
 	}
 	s.Server = &http.Server{
-		Addr:    s.config.Address(),
-		Handler: s,
+		Addr:      s.config.Address(),
+		Handler:   s,
+		ConnState: metric.ConnStateHook(s.config.Address()),
+	}
+	if s.watchdog != nil {
+		s.watchdog.Start()
+		defer s.watchdog.Stop()
 	}
 	err := s.Server.Serve(s.listener)
 	if err == http.ErrServerClosed {
@@ -93,6 +101,8 @@ func (s *Server) Info() *server.ServiceInfo {
 		server.WithScheme("http"),
 		server.WithAddress(s.listener.Addr().String()),
 		server.WithKind(constant.ServiceProvider),
+		server.WithLabels(s.config.Labels),
+		server.WithWeight(s.config.Weight),
 	)
 	// info.Name = info.Name + "." + ModName
 	return &info
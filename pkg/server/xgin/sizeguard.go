@@ -0,0 +1,95 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/douyu/jupiter/pkg/server/sizeguard"
+	"github.com/gin-gonic/gin"
+)
+
+// sizeguardMiddleware measures responses of routes guarded by sizeguard.
+// In strict mode, responses above the threshold are replaced by 500 if their
+// size is known before the header is written, i.e. written at once as
+// c.JSON does, or with Content-Length. Streamed responses are only reported.
+func sizeguardMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, ok := sizeguard.Lookup(c.Request.Method, c.FullPath())
+		if !ok {
+			c.Next()
+			return
+		}
+
+		writer := &sizeWriter{ResponseWriter: c.Writer, limit: limit}
+		c.Writer = writer
+		defer func() { c.Writer = writer.ResponseWriter }()
+
+		c.Next()
+		if !writer.rejected {
+			// too late to reject
+			limit.Strict = false
+			_ = sizeguard.Observe(limit, writer.size)
+		}
+	}
+}
+
+// sizeWriter counts bytes written, gin holds the header until the first
+// write, so that the response can still be rejected
+type sizeWriter struct {
+	gin.ResponseWriter
+	limit    sizeguard.Limit
+	rejected bool
+	size     int64
+}
+
+// Write ...
+func (w *sizeWriter) Write(p []byte) (int, error) {
+	if !w.Written() && w.limit.Strict {
+		size := int64(len(p))
+		if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && n > size {
+			size = n
+		}
+		if w.limit.Exceeded(size) {
+			w.reject(size)
+		}
+	}
+	if w.rejected {
+		return len(p), nil
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// WriteString ...
+func (w *sizeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// reject responds 500 instead, the body is discarded
+func (w *sizeWriter) reject(size int64) {
+	err := sizeguard.Observe(w.limit, size)
+	if err == nil {
+		return
+	}
+	w.rejected = true
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	w.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+	_, _ = w.ResponseWriter.Write([]byte(err.Error()))
+}
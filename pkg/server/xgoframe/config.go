@@ -20,9 +20,14 @@ import (
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/ecode"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 	"github.com/pkg/errors"
 )
 
+func init() {
+	xschema.RegisterConfig("server.goframe", "jupiter.server.*", "goframe http server", DefaultConfig)
+}
+
 //ModName mod name
 const ModName = "server.goframe"
 
@@ -35,6 +40,10 @@ type Config struct {
 	DisableTrace  bool
 
 	SlowQueryThresholdInMilli int64
+	// Labels are registered with the service, matched by label selectors of consumers
+	Labels map[string]string
+	// Weight is registered with the service for weighted balancers, 100 if zero
+	Weight float64
 
 	logger *xlog.Logger
 }
@@ -73,6 +73,8 @@ func (s *Server) Info() *server.ServiceInfo {
 		server.WithScheme("http"),
 		server.WithAddress(s.config.Host+":"+strconv.Itoa(s.config.Port)),
 		server.WithKind(constant.ServiceProvider),
+		server.WithLabels(s.config.Labels),
+		server.WithWeight(s.config.Weight),
 	)
 	return &info
 }
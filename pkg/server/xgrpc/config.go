@@ -16,31 +16,60 @@ package xgrpc
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/douyu/jupiter/pkg/constant"
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xdiag"
 	"github.com/douyu/jupiter/pkg/xlog"
 
 	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/xschema"
 	"google.golang.org/grpc"
 )
 
+func init() {
+	xschema.RegisterConfig("server.grpc", "jupiter.server.*", "gRPC server", DefaultConfig)
+}
+
 // Config ...
 type Config struct {
 	Host       string
 	Port       int
 	Deployment string
+	// Labels are registered with the service, matched by label selectors of consumers
+	Labels map[string]string
+	// Weight is registered with the service for weighted balancers, 100 if zero
+	Weight float64
 	// Network network type, tcp4 by default
 	Network string `json:"network" toml:"network"`
 	// DisableTrace disbale Trace Interceptor, false by default
 	DisableTrace bool
 	// DisableMetric disable Metric Interceptor, false by default
 	DisableMetric bool
+	// DisableRecorder disable recording recent requests for governor, false by default
+	DisableRecorder bool
+	// EnableChannelz register channelz service and governor endpoints, false by default,
+	// pkg/xchannelz/service must be imported to collect the stats
+	EnableChannelz bool
+	// EnableHealthService register grpc health service, which reports NOT_SERVING under maintenance or not ready
+	EnableHealthService bool
+	// EnableReflection register grpc reflection service, used by jupiter call, false by default
+	EnableReflection bool
+	// WatchdogThreshold logs stacks of requests running longer than it, disabled if zero
+	WatchdogThreshold time.Duration
+	// WatchdogDeadlineFactor logs stacks of requests running longer than factor × deadline, disabled if zero
+	WatchdogDeadlineFactor float64
+	// WatchdogCancelGrace logs stacks of requests running longer than it after
+	// clients gave up, i.e. handlers ignoring cancellation, disabled if zero.
+	// Methods are listed on governor /debug/grpc/cancellations with the work wasted.
+	WatchdogCancelGrace time.Duration
 	// SlowQueryThresholdInMilli, request will be colored if cost over this threshold value
 	SlowQueryThresholdInMilli int64
 	serverOptions             []grpc.ServerOption
 	streamInterceptors        []grpc.StreamServerInterceptor
 	unaryInterceptors         []grpc.UnaryServerInterceptor
+	diagnostics               *xdiag.Mirror
 
 	logger *xlog.Logger
 }
@@ -114,6 +143,12 @@ func (config *Config) WithUnaryInterceptor(intes ...grpc.UnaryServerInterceptor)
 	return config
 }
 
+// WithDiagnostics mirrors failed requests to diagnostics
+func (config *Config) WithDiagnostics(mirror *xdiag.Mirror) *Config {
+	config.diagnostics = mirror
+	return config
+}
+
 // Build ...
 func (config *Config) Build() *Server {
 	if !config.DisableTrace {
@@ -126,6 +161,16 @@ func (config *Config) Build() *Server {
 		config.streamInterceptors = append(config.streamInterceptors, prometheusStreamServerInterceptor)
 	}
 
+	if config.diagnostics != nil {
+		config.unaryInterceptors = append(config.unaryInterceptors, diagnosticsUnaryServerInterceptor(config.diagnostics))
+		config.streamInterceptors = append(config.streamInterceptors, diagnosticsStreamServerInterceptor(config.diagnostics))
+	}
+
+	if !config.DisableRecorder {
+		config.unaryInterceptors = append(config.unaryInterceptors, recorderUnaryServerInterceptor)
+		config.streamInterceptors = append(config.streamInterceptors, recorderStreamServerInterceptor)
+	}
+
 	return newServer(config)
 }
 
@@ -0,0 +1,57 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/server/deprecation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func deprecationUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if endpoint, ok := deprecation.Lookup("", info.FullMethod); ok {
+		_ = grpc.SetHeader(ctx, deprecationMD(endpoint))
+		deprecation.Observe(endpoint, caller(ctx))
+	}
+	return handler(ctx, req)
+}
+
+func deprecationStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if endpoint, ok := deprecation.Lookup("", info.FullMethod); ok {
+		_ = ss.SetHeader(deprecationMD(endpoint))
+		deprecation.Observe(endpoint, caller(ss.Context()))
+	}
+	return handler(srv, ss)
+}
+
+func deprecationMD(endpoint *deprecation.Endpoint) metadata.MD {
+	var md = metadata.MD{}
+	for key, value := range endpoint.Headers() {
+		md.Set(strings.ToLower(key), value)
+	}
+	return md
+}
+
+// caller returns the app id of peers sent by jupiter clients, or the ip
+func caller(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if aid := md.Get("aid"); len(aid) > 0 && aid[0] != "" {
+		return aid[0]
+	}
+	return peerClientIP(ctx, md)
+}
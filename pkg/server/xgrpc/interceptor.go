@@ -20,11 +20,17 @@ import (
 	"net"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/server/scope"
 	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/douyu/jupiter/pkg/util/xwatchdog"
+	"github.com/douyu/jupiter/pkg/xdiag"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xrecorder"
+	"github.com/douyu/jupiter/pkg/xstat"
 	"github.com/opentracing/opentracing-go/ext"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
@@ -36,12 +42,40 @@ import (
 	"google.golang.org/grpc"
 )
 
+// methodMetrics holds the metric children curried with type and method,
+// so that per request only aid and code need to be resolved
+type methodMetrics struct {
+	counter   interface{ Inc(labels ...string) }
+	histogram interface {
+		Observe(v float64, labels ...string)
+	}
+}
+
+var (
+	unaryMethodMetrics  sync.Map
+	streamMethodMetrics sync.Map
+)
+
+func getMethodMetrics(cache *sync.Map, typ string, method string) *methodMetrics {
+	if mm, ok := cache.Load(method); ok {
+		return mm.(*methodMetrics)
+	}
+	mm, _ := cache.LoadOrStore(method, &methodMetrics{
+		counter:   metric.ServerHandleCounter.Curry(typ, method),
+		histogram: metric.ServerHandleHistogram.Curry(typ, method),
+	})
+	return mm.(*methodMetrics)
+}
+
 func prometheusUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	startTime := time.Now()
 	resp, err := handler(ctx, req)
 	code := ecode.ExtractCodes(err)
-	metric.ServerHandleHistogram.Observe(time.Since(startTime).Seconds(), metric.TypeGRPCUnary, info.FullMethod, extractAID(ctx))
-	metric.ServerHandleCounter.Inc(metric.TypeGRPCUnary, info.FullMethod, extractAID(ctx), code.GetMessage())
+	aid := extractAID(ctx)
+	mm := getMethodMetrics(&unaryMethodMetrics, metric.TypeGRPCUnary, info.FullMethod)
+	mm.histogram.Observe(time.Since(startTime).Seconds(), aid)
+	mm.counter.Inc(aid, code.GetMessage())
+	xstat.RecordMethod(metric.TypeGRPCUnary, info.FullMethod, time.Since(startTime), err != nil)
 	return resp, err
 }
 
@@ -49,8 +83,11 @@ func prometheusStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, in
 	startTime := time.Now()
 	err := handler(srv, ss)
 	code := ecode.ExtractCodes(err)
-	metric.ServerHandleHistogram.Observe(time.Since(startTime).Seconds(), metric.TypeGRPCStream, info.FullMethod, extractAID(ss.Context()))
-	metric.ServerHandleCounter.Inc(metric.TypeGRPCStream, info.FullMethod, extractAID(ss.Context()), code.GetMessage())
+	aid := extractAID(ss.Context())
+	mm := getMethodMetrics(&streamMethodMetrics, metric.TypeGRPCStream, info.FullMethod)
+	mm.histogram.Observe(time.Since(startTime).Seconds(), aid)
+	mm.counter.Inc(aid, code.GetMessage())
+	xstat.RecordMethod(metric.TypeGRPCStream, info.FullMethod, time.Since(startTime), err != nil)
 	return err
 }
 
@@ -108,7 +145,12 @@ func traceStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *g
 
 func extractAID(ctx context.Context) string {
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
-		return strings.Join(md.Get("aid"), ",")
+		if aid := md.Get("aid"); len(aid) == 1 {
+			return aid[0]
+		} else if len(aid) > 1 {
+			return strings.Join(aid, ",")
+		}
+		return ""
 	}
 	return "unknown"
 }
@@ -116,9 +158,13 @@ func extractAID(ctx context.Context) string {
 func defaultStreamServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMilli int64) grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
 		var beg = time.Now()
-		var fields = make([]xlog.Field, 0, 8)
-		var event = "normal"
+		ctx, store, end := scope.Start(stream.Context())
+		stream = contextedServerStream{ServerStream: stream, ctx: ctx}
+		defer end()
 		defer func() {
+			var fb = xlog.GetFieldBuilder()
+			defer fb.Release()
+			var event = "normal"
 			if slowQueryThresholdInMilli > 0 {
 				if int64(time.Since(beg))/1e6 > slowQueryThresholdInMilli {
 					event = "slow"
@@ -134,27 +180,24 @@ func defaultStreamServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMil
 				}
 				stack := make([]byte, 4096)
 				stack = stack[:runtime.Stack(stack, true)]
-				fields = append(fields, xlog.FieldStack(stack))
+				fb.Add(xlog.FieldStack(stack), zap.Any("scope", store.Dump()))
 				event = "recover"
 			}
 
-			fields = append(fields,
-				xlog.Any("grpc interceptor type", "unary"),
+			fb.Add(
+				fieldInterceptorTypeStream,
 				xlog.FieldMethod(info.FullMethod),
 				xlog.FieldCost(time.Since(beg)),
 				xlog.FieldEvent(event),
 			)
-
-			for key, val := range getPeer(stream.Context()) {
-				fields = append(fields, xlog.Any(key, val))
-			}
+			appendPeerFields(fb, stream.Context())
 
 			if err != nil {
-				fields = append(fields, zap.String("err", err.Error()))
-				logger.Error("access", fields...)
+				fb.Add(zap.String("err", err.Error()))
+				logger.Error("access", fb.Fields()...)
 				return
 			}
-			logger.Info("access", fields...)
+			logger.Info("access", fb.Fields()...)
 		}()
 		return handler(srv, stream)
 	}
@@ -163,9 +206,12 @@ func defaultStreamServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMil
 func defaultUnaryServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMilli int64) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 		var beg = time.Now()
-		var fields = make([]xlog.Field, 0, 8)
-		var event = "normal"
+		ctx, store, end := scope.Start(ctx)
+		defer end()
 		defer func() {
+			var fb = xlog.GetFieldBuilder()
+			defer fb.Release()
+			var event = "normal"
 			if slowQueryThresholdInMilli > 0 {
 				if int64(time.Since(beg))/1e6 > slowQueryThresholdInMilli {
 					event = "slow"
@@ -181,32 +227,34 @@ func defaultUnaryServerInterceptor(logger *xlog.Logger, slowQueryThresholdInMill
 
 				stack := make([]byte, 4096)
 				stack = stack[:runtime.Stack(stack, true)]
-				fields = append(fields, xlog.FieldStack(stack))
+				fb.Add(xlog.FieldStack(stack), zap.Any("scope", store.Dump()))
 				event = "recover"
 			}
 
-			fields = append(fields,
-				xlog.Any("grpc interceptor type", "unary"),
+			fb.Add(
+				fieldInterceptorTypeUnary,
 				xlog.FieldMethod(info.FullMethod),
 				xlog.FieldCost(time.Since(beg)),
 				xlog.FieldEvent(event),
 			)
-
-			for key, val := range getPeer(ctx) {
-				fields = append(fields, xlog.Any(key, val))
-			}
+			appendPeerFields(fb, ctx)
 
 			if err != nil {
-				fields = append(fields, zap.String("err", err.Error()))
-				logger.Error("access", fields...)
+				fb.Add(zap.String("err", err.Error()))
+				logger.Error("access", fb.Fields()...)
 				return
 			}
-			logger.Info("access", fields...)
+			logger.Info("access", fb.Fields()...)
 		}()
 		return handler(ctx, req)
 	}
 }
 
+var (
+	fieldInterceptorTypeUnary  = xlog.String("grpc interceptor type", "unary")
+	fieldInterceptorTypeStream = xlog.String("grpc interceptor type", "stream")
+)
+
 func getClientIP(ctx context.Context) (string, error) {
 	pr, ok := peer.FromContext(ctx)
 	if !ok {
@@ -219,26 +267,96 @@ func getClientIP(ctx context.Context) (string, error) {
 	return addSlice[0], nil
 }
 
-func getPeer(ctx context.Context) map[string]string {
-	var peerMeta = make(map[string]string)
-	if md, ok := metadata.FromIncomingContext(ctx); ok {
-		if val, ok := md["aid"]; ok {
-			peerMeta["aid"] = strings.Join(val, ";")
-		}
-		var clientIP string
-		if val, ok := md["client-ip"]; ok {
-			clientIP = strings.Join(val, ";")
-		} else {
-			ip, err := getClientIP(ctx)
-			if err == nil {
-				clientIP = ip
-			}
-		}
-		peerMeta["clientIP"] = clientIP
-		if val, ok := md["client-host"]; ok {
-			peerMeta["host"] = strings.Join(val, ";")
+// appendPeerFields appends peer info of the incoming metadata to access log fields
+func appendPeerFields(fb *xlog.FieldBuilder, ctx context.Context) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return
+	}
+	if val, ok := md["aid"]; ok {
+		fb.Add(xlog.String("aid", strings.Join(val, ";")))
+	}
+	fb.Add(xlog.String("clientIP", peerClientIP(ctx, md)))
+	if val, ok := md["client-host"]; ok {
+		fb.Add(xlog.String("host", strings.Join(val, ";")))
+	}
+}
+
+func peerClientIP(ctx context.Context, md metadata.MD) string {
+	if val, ok := md["client-ip"]; ok {
+		return strings.Join(val, ";")
+	}
+	ip, err := getClientIP(ctx)
+	if err != nil {
+		return ""
+	}
+	return ip
+}
+
+func watchdogUnaryServerInterceptor(watchdog *xwatchdog.Watchdog) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, done := watchdog.Watch(ctx, info.FullMethod)
+		defer done()
+		return handler(ctx, req)
+	}
+}
+
+func watchdogStreamServerInterceptor(watchdog *xwatchdog.Watchdog) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, done := watchdog.Watch(ss.Context(), info.FullMethod)
+		defer done()
+		return handler(srv, contextedServerStream{
+			ServerStream: ss,
+			ctx:          ctx,
+		})
+	}
+}
+
+func recorderUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	beg := time.Now()
+	resp, err := handler(ctx, req)
+	record(ctx, metric.TypeGRPCUnary, info.FullMethod, beg, err)
+	return resp, err
+}
+
+func recorderStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	beg := time.Now()
+	err := handler(srv, ss)
+	record(ss.Context(), metric.TypeGRPCStream, info.FullMethod, beg, err)
+	return err
+}
+
+func record(ctx context.Context, typ string, method string, beg time.Time, err error) {
+	addr, _ := getClientIP(ctx)
+	xrecorder.Add(xrecorder.Record{
+		Time:     beg,
+		Type:     typ,
+		Method:   method,
+		Peer:     addr,
+		Status:   ecode.ExtractCodes(err).GetMessage(),
+		Duration: time.Since(beg),
+		TraceID:  trace.ExtractTraceID(ctx),
+	})
+}
+
+func diagnosticsUnaryServerInterceptor(mirror *xdiag.Mirror) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if code := status.Code(err).String(); err != nil && mirror.Selected(code) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			mirror.Capture(ctx, info.FullMethod, code, err, req, md)
 		}
+		return resp, err
 	}
-	return peerMeta
+}
 
+func diagnosticsStreamServerInterceptor(mirror *xdiag.Mirror) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		if code := status.Code(err).String(); err != nil && mirror.Selected(code) {
+			md, _ := metadata.FromIncomingContext(ss.Context())
+			mirror.Capture(ss.Context(), info.FullMethod, code, err, nil, md)
+		}
+		return err
+	}
 }
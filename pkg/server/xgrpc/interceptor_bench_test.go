@@ -0,0 +1,95 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/xlog"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func newBenchLogger(b *testing.B) *xlog.Logger {
+	dir, err := ioutil.TempDir("", "xgrpc-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.RemoveAll(dir) })
+	config := xlog.DefaultConfig()
+	config.Dir = dir
+	config.Async = false
+	config.Core = zapcore.NewCore(zapcore.NewJSONEncoder(*config.EncoderConfig), zapcore.AddSync(ioutil.Discard), zapcore.InfoLevel)
+	return config.Build()
+}
+
+func benchIncomingContext() context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"aid", "bench",
+		"client-ip", "127.0.0.1",
+		"client-host", "localhost",
+	))
+}
+
+var benchUnaryInfo = &grpc.UnaryServerInfo{FullMethod: "/bench.Service/Call"}
+
+func benchUnaryHandler(ctx context.Context, req interface{}) (interface{}, error) {
+	return req, nil
+}
+
+func BenchmarkDefaultUnaryServerInterceptor(b *testing.B) {
+	interceptor := defaultUnaryServerInterceptor(newBenchLogger(b), 500)
+	ctx := benchIncomingContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = interceptor(ctx, nil, benchUnaryInfo, benchUnaryHandler)
+	}
+}
+
+func BenchmarkPrometheusUnaryServerInterceptor(b *testing.B) {
+	ctx := benchIncomingContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = prometheusUnaryServerInterceptor(ctx, nil, benchUnaryInfo, benchUnaryHandler)
+	}
+}
+
+func BenchmarkUnaryServerInterceptorChain(b *testing.B) {
+	chain := []grpc.UnaryServerInterceptor{
+		defaultUnaryServerInterceptor(newBenchLogger(b), 500),
+		prometheusUnaryServerInterceptor,
+	}
+	handler := benchUnaryHandler
+	for i := len(chain) - 1; i >= 0; i-- {
+		interceptor, next := chain[i], handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, req, benchUnaryInfo, next)
+		}
+	}
+	ctx := benchIncomingContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = handler(ctx, nil)
+		}
+	})
+}
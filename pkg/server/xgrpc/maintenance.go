@@ -0,0 +1,64 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"context"
+
+	"github.com/douyu/jupiter/pkg/server/maintenance"
+	"github.com/douyu/jupiter/pkg/server/readiness"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var errMaintenance = status.Error(codes.Unavailable, "server is under maintenance")
+
+func maintenanceUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !maintenance.Allowed(info.FullMethod) {
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", maintenance.RetryAfter()))
+		return nil, errMaintenance
+	}
+	return handler(ctx, req)
+}
+
+func maintenanceStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !maintenance.Allowed(info.FullMethod) {
+		_ = ss.SetHeader(metadata.Pairs("retry-after", maintenance.RetryAfter()))
+		return errMaintenance
+	}
+	return handler(srv, ss)
+}
+
+// registerHealthService registers grpc health service, which follows the
+// maintenance switch and readiness
+func registerHealthService(server *grpc.Server) {
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	setStatus := func(bool) {
+		if maintenance.Enabled() || !readiness.Ready() {
+			healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+			return
+		}
+		healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	}
+	setStatus(true)
+	maintenance.OnChange(setStatus)
+	readiness.OnChange(setStatus)
+}
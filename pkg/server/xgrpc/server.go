@@ -23,8 +23,11 @@ import (
 	"github.com/douyu/jupiter/pkg/ecode"
 
 	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xwatchdog"
+	"github.com/douyu/jupiter/pkg/xchannelz"
 	"github.com/douyu/jupiter/pkg/xlog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 )
 
 // Server ...
@@ -33,25 +36,62 @@ type Server struct {
 	listener net.Listener
 	*Config
 	serverInfo *server.ServiceInfo
+	watchdog   *xwatchdog.Watchdog
 }
 
 func newServer(config *Config) *Server {
 	var streamInterceptors = append(
-		[]grpc.StreamServerInterceptor{defaultStreamServerInterceptor(config.logger, config.SlowQueryThresholdInMilli)},
+		[]grpc.StreamServerInterceptor{
+			defaultStreamServerInterceptor(config.logger, config.SlowQueryThresholdInMilli),
+			maintenanceStreamServerInterceptor,
+			deprecationStreamServerInterceptor,
+		},
 		config.streamInterceptors...,
 	)
 
 	var unaryInterceptors = append(
-		[]grpc.UnaryServerInterceptor{defaultUnaryServerInterceptor(config.logger, config.SlowQueryThresholdInMilli)},
+		[]grpc.UnaryServerInterceptor{
+			defaultUnaryServerInterceptor(config.logger, config.SlowQueryThresholdInMilli),
+			maintenanceUnaryServerInterceptor,
+			deprecationUnaryServerInterceptor,
+		},
 		config.unaryInterceptors...,
 	)
 
+	// innermost, so that rejections are seen by other interceptors
+	streamInterceptors = append(streamInterceptors, sizeguardStreamServerInterceptor)
+	unaryInterceptors = append(unaryInterceptors, sizeguardUnaryServerInterceptor)
+
+	var watchdog *xwatchdog.Watchdog
+	if config.WatchdogThreshold > 0 || config.WatchdogDeadlineFactor > 0 || config.WatchdogCancelGrace > 0 {
+		wc := xwatchdog.DefaultConfig().WithLogger(config.logger)
+		wc.Threshold = config.WatchdogThreshold
+		wc.DeadlineFactor = config.WatchdogDeadlineFactor
+		wc.CancelGrace = config.WatchdogCancelGrace
+		watchdog = wc.Build()
+		streamInterceptors = append([]grpc.StreamServerInterceptor{watchdogStreamServerInterceptor(watchdog)}, streamInterceptors...)
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{watchdogUnaryServerInterceptor(watchdog)}, unaryInterceptors...)
+	}
+
 	config.serverOptions = append(config.serverOptions,
 		grpc.StreamInterceptor(StreamInterceptorChain(streamInterceptors...)),
 		grpc.UnaryInterceptor(UnaryInterceptorChain(unaryInterceptors...)),
 	)
 
+	if config.EnableChannelz {
+		// servers are tracked only if channelz is on once they're created
+		xchannelz.Enable()
+	}
 	newServer := grpc.NewServer(config.serverOptions...)
+	if config.EnableChannelz {
+		xchannelz.Register(newServer)
+	}
+	if config.EnableHealthService {
+		registerHealthService(newServer)
+	}
+	if config.EnableReflection {
+		reflection.Register(newServer)
+	}
 	listener, err := net.Listen(config.Network, config.Address())
 	if err != nil {
 		config.logger.Panic("new grpc server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
@@ -62,6 +102,8 @@ func newServer(config *Config) *Server {
 		server.WithScheme("grpc"),
 		server.WithAddress(listener.Addr().String()),
 		server.WithKind(constant.ServiceProvider),
+		server.WithLabels(config.Labels),
+		server.WithWeight(config.Weight),
 	)
 
 	return &Server{
@@ -69,11 +111,16 @@ func newServer(config *Config) *Server {
 		listener:   listener,
 		Config:     config,
 		serverInfo: &info,
+		watchdog:   watchdog,
 	}
 }
 
 // Server implements server.Server interface.
 func (s *Server) Serve() error {
+	if s.watchdog != nil {
+		s.watchdog.Start()
+		defer s.watchdog.Stop()
+	}
 	err := s.Server.Serve(s.listener)
 	return err
 }
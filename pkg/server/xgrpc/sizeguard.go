@@ -0,0 +1,68 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"context"
+
+	"github.com/douyu/jupiter/pkg/server/sizeguard"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sizeguardUnaryServerInterceptor measures responses, which are rejected
+// with ResourceExhausted above the threshold in strict mode
+func sizeguardUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	limit, ok := sizeguard.Lookup("", info.FullMethod)
+	if !ok {
+		return handler(ctx, req)
+	}
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	if msg, ok := resp.(proto.Message); ok {
+		if err := sizeguard.Observe(limit, int64(proto.Size(msg))); err != nil {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+	}
+	return resp, nil
+}
+
+// sizeguardStreamServerInterceptor measures each message sent
+func sizeguardStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	limit, ok := sizeguard.Lookup("", info.FullMethod)
+	if !ok {
+		return handler(srv, ss)
+	}
+	return handler(srv, sizeguardServerStream{ServerStream: ss, limit: limit})
+}
+
+type sizeguardServerStream struct {
+	grpc.ServerStream
+	limit sizeguard.Limit
+}
+
+// SendMsg ...
+func (ss sizeguardServerStream) SendMsg(m interface{}) error {
+	if msg, ok := m.(proto.Message); ok {
+		if err := sizeguard.Observe(ss.limit, int64(proto.Size(msg))); err != nil {
+			return status.Error(codes.ResourceExhausted, err.Error())
+		}
+	}
+	return ss.ServerStream.SendMsg(m)
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server/sizeguard"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSizeguardUnaryServerInterceptor(t *testing.T) {
+	sizeguard.Set(sizeguard.Config{Threshold: 16, Routes: []sizeguard.Limit{
+		{Route: "/helloworld.Greeter/ListUsers", Strict: true},
+	}})
+	defer sizeguard.Set(sizeguard.DefaultConfig())
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &wrappers.StringValue{Value: strings.Repeat("a", 32)}, nil
+	}
+	resp, err := sizeguardUnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/helloworld.Greeter/SayHello"}, handler)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	_, err = sizeguardUnaryServerInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/helloworld.Greeter/ListUsers"}, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgrpc
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xwatchdog"
+)
+
+func init() {
+	// methods which kept working after clients gave up, by WatchdogCancelGrace
+	governor.HandleFunc("/debug/grpc/cancellations", func(w http.ResponseWriter, r *http.Request) {
+		type stat struct {
+			Method string `json:"method"`
+			Count  int64  `json:"count"`
+			Total  string `json:"total"`
+			Max    string `json:"max"`
+		}
+		var stats = make([]stat, 0)
+		for _, s := range xwatchdog.Ignored() {
+			stats = append(stats, stat{Method: s.Name, Count: s.Count, Total: s.Total.String(), Max: s.Max.String()})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(stats)
+	})
+}
@@ -21,4 +21,14 @@ import (
 	"syscall"
 )
 
-var shutdownSignals = []os.Signal{syscall.SIGQUIT, os.Interrupt, syscall.SIGTERM}
+var eventSignals = map[Event][]os.Signal{
+	EventShutdown: {os.Interrupt, syscall.SIGTERM},
+	EventStop:     {syscall.SIGQUIT},
+	EventReload:   {syscall.SIGHUP},
+	EventRestart:  {syscall.SIGUSR2},
+}
+
+// ServeService is a no-op except on windows
+func ServeService(name string) (done func(), err error) {
+	return func() {}, nil
+}
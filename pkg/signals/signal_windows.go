@@ -19,6 +19,61 @@ package signals
 import (
 	"os"
 	"syscall"
+
+	"golang.org/x/sys/windows/svc"
 )
 
-var shutdownSignals = []os.Signal{syscall.SIGQUIT, os.Interrupt}
+// SIGTERM is sent on console close, logoff and shutdown events since go1.14,
+// SIGQUIT is never sent but kept for an explicit signal.Notify of users
+var eventSignals = map[Event][]os.Signal{
+	EventShutdown: {os.Interrupt, syscall.SIGTERM},
+	EventStop:     {syscall.SIGQUIT},
+}
+
+// ServeService runs the application as a windows service if it's started by
+// the service control manager, stop and shutdown requests are mapped to
+// EventShutdown and param change requests to EventReload. The returned done
+// func reports the service stopped, which should be called once the
+// application stops
+func ServeService(name string) (done func(), err error) {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil || interactive {
+		return func() {}, err
+	}
+	h := &serviceHandler{stopped: make(chan struct{}), exited: make(chan struct{})}
+	go func() {
+		defer close(h.exited)
+		_ = svc.Run(name, h)
+	}()
+	return func() {
+		close(h.stopped)
+		<-h.exited
+	}, nil
+}
+
+type serviceHandler struct {
+	stopped chan struct{}
+	exited  chan struct{}
+}
+
+// Execute ...
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange
+	changes <- svc.Status{State: svc.Running, Accepts: accepts}
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				dispatch(EventShutdown)
+			case svc.ParamChange:
+				dispatch(EventReload)
+			}
+		case <-h.stopped:
+			return false, 0
+		}
+	}
+}
@@ -12,25 +12,156 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package signals maps os signals, and service control requests on windows,
+// to portable events of the application
 package signals
 
 import (
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
-//Shutdown suport twice signal must exit
-func Shutdown(stop func(grace bool)) {
+// Event is a portable signal to the application
+type Event uint8
+
+const (
+	// EventShutdown asks for a graceful stop, by SIGTERM or interrupt, or by
+	// a stop or shutdown request of the service control manager on windows
+	EventShutdown Event = iota + 1
+	// EventStop asks for an immediate stop, by SIGQUIT
+	EventStop
+	// EventReload asks for reloading config, by SIGHUP, or by a param change
+	// request of the service control manager on windows
+	EventReload
+	// EventRestart asks for a graceful restart, by SIGUSR2, posix only
+	EventRestart
+)
+
+// String ...
+func (e Event) String() string {
+	switch e {
+	case EventShutdown:
+		return "shutdown"
+	case EventStop:
+		return "stop"
+	case EventReload:
+		return "reload"
+	case EventRestart:
+		return "restart"
+	default:
+		return "unknown"
+	}
+}
+
+// Signals returns os signals mapped to the event on this platform
+func (e Event) Signals() []os.Signal {
+	return eventSignals[e]
+}
+
+type subscriber struct {
+	events map[Event]bool
+	ch     chan notification
+}
+
+type notification struct {
+	event Event
+	// signal is nil for service control requests
+	signal os.Signal
+}
+
+// subscribers receive events not from os signals, e.g. service control
+// requests on windows
+var subscribers sync.Map
+
+// Notify calls fn with events in a separate goroutine until the returned
+// stop func is called, all events are notified if none is given
+func Notify(fn func(Event), events ...Event) (stop func()) {
+	return notify(func(n notification) { fn(n.event) }, events...)
+}
+
+func notify(fn func(notification), events ...Event) func() {
+	if len(events) == 0 {
+		events = []Event{EventShutdown, EventStop, EventReload, EventRestart}
+	}
+	sub := &subscriber{events: make(map[Event]bool), ch: make(chan notification, 2)}
+	var sigs []os.Signal
+	for _, e := range events {
+		sub.events[e] = true
+		sigs = append(sigs, e.Signals()...)
+	}
 	sig := make(chan os.Signal, 2)
-	signal.Notify(
-		sig,
-		shutdownSignals...,
-	)
+	if len(sigs) > 0 {
+		signal.Notify(sig, sigs...)
+	}
+	subscribers.Store(sub, struct{}{})
+
+	done := make(chan struct{})
 	go func() {
-		s := <-sig
-		go stop(s != syscall.SIGQUIT)
-		<-sig
-		os.Exit(128 + int(s.(syscall.Signal))) // second signal. Exit directly.
+		for {
+			select {
+			case s := <-sig:
+				fn(notification{event: toEvent(s), signal: s})
+			case n := <-sub.ch:
+				fn(n)
+			case <-done:
+				return
+			}
+		}
 	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sig)
+			subscribers.Delete(sub)
+			close(done)
+		})
+	}
+}
+
+// dispatch notifies subscribers of e not triggered by os signals, it
+// reports whether any subscriber is notified
+func dispatch(e Event) bool {
+	var notified bool
+	subscribers.Range(func(key, value interface{}) bool {
+		sub := key.(*subscriber)
+		if sub.events[e] {
+			select {
+			case sub.ch <- notification{event: e}:
+				notified = true
+			default:
+			}
+		}
+		return true
+	})
+	return notified
+}
+
+func toEvent(s os.Signal) Event {
+	for e, sigs := range eventSignals {
+		for _, sig := range sigs {
+			if sig == s {
+				return e
+			}
+		}
+	}
+	return 0
+}
+
+//Shutdown suport twice signal must exit
+func Shutdown(stop func(grace bool)) {
+	var first *notification
+	notify(func(n notification) {
+		if first == nil {
+			first = &n
+			go stop(n.event != EventStop)
+			return
+		}
+		// second signal. Exit directly.
+		if s, ok := first.signal.(syscall.Signal); ok {
+			os.Exit(128 + int(s))
+		}
+		os.Exit(1)
+	}, EventShutdown, EventStop)
 }
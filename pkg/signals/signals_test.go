@@ -51,3 +51,21 @@ func TestShutdownSIGQUIT(t *testing.T) {
 // 		<-quit
 // 	})
 // }
+
+func TestNotify(t *testing.T) {
+	Convey("test notify events of signals and service requests", t, func() {
+		events := make(chan Event, 2)
+		stop := Notify(func(e Event) { events <- e }, EventReload)
+		defer stop()
+
+		kill(syscall.SIGHUP)
+		So(<-events, ShouldEqual, EventReload)
+
+		So(dispatch(EventReload), ShouldBeTrue)
+		So(<-events, ShouldEqual, EventReload)
+		So(dispatch(EventRestart), ShouldBeFalse)
+
+		stop()
+		So(dispatch(EventReload), ShouldBeFalse)
+	})
+}
@@ -23,8 +23,13 @@ import (
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/util/xtime"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 )
 
+func init() {
+	xschema.RegisterConfig("mysql", "jupiter.mysql.*", "gorm mysql client", DefaultConfig)
+}
+
 // StdConfig 标准配置，规范配置文件头
 func StdConfig(name string) *Config {
 	return RawConfig("jupiter.mysql." + name)
@@ -22,11 +22,16 @@ import (
 	"github.com/douyu/jupiter/pkg/conf"
 	"github.com/douyu/jupiter/pkg/defers"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 	"github.com/opentracing/opentracing-go"
 	"github.com/uber/jaeger-client-go"
 	jconfig "github.com/uber/jaeger-client-go/config"
 )
 
+func init() {
+	xschema.RegisterConfig("trace.jaeger", "jupiter.trace.jaeger", "jaeger tracer", DefaultConfig)
+}
+
 // Config ...
 type Config struct {
 	ServiceName      string
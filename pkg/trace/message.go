@@ -0,0 +1,61 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// MessageInjector injects the span context of ctx into properties of an
+// async message, e.g. properties of a MQ message, by calling set
+func MessageInjector(ctx context.Context, set func(key, val string)) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	carrier := opentracing.TextMapCarrier{}
+	if err := opentracing.GlobalTracer().Inject(span.Context(), opentracing.TextMap, carrier); err != nil {
+		span.LogFields(log.String("event", "inject failed"), log.Error(err))
+		return
+	}
+	for key, val := range carrier {
+		set(key, val)
+	}
+}
+
+// MessageExtractor returns the option of spans handling an async message,
+// which follow from the producing span injected into props by
+// MessageInjector. Consumers start the root span of their work with it, the
+// reference links it to the producer without making it a child, as the
+// producer doesn't wait for it.
+func MessageExtractor(props map[string]string) opentracing.StartSpanOption {
+	sc, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, opentracing.TextMapCarrier(props))
+	if err != nil {
+		return NullStartSpanOption{}
+	}
+	return opentracing.FollowsFrom(sc)
+}
+
+// StartRootSpan starts a span of async work, e.g. a cron job or a message
+// consumption, which is not a child of any span in ctx. Pass the context
+// returned to client calls of the work, so that they are traced as its
+// children.
+func StartRootSpan(ctx context.Context, op string, opts ...opentracing.StartSpanOption) (opentracing.Span, context.Context) {
+	span := opentracing.GlobalTracer().StartSpan(op, opts...)
+	return span, opentracing.ContextWithSpan(ctx, span)
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessagePropagation(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	// no span, nothing injected
+	var props = map[string]string{}
+	MessageInjector(context.Background(), func(key, val string) { props[key] = val })
+	assert.Len(t, props, 0)
+	assert.Equal(t, NullStartSpanOption{}, MessageExtractor(props))
+
+	producer, ctx := StartSpanFromContext(context.Background(), "produce")
+	MessageInjector(ctx, func(key, val string) { props[key] = val })
+	producer.Finish()
+	assert.NotEmpty(t, props)
+
+	// a span in ctx of the consumer is not the parent
+	other, ctx := StartSpanFromContext(context.Background(), "poll")
+	other.Finish()
+	consumer, ctx := StartRootSpan(ctx, "consume", MessageExtractor(props))
+	child, _ := StartSpanFromContext(ctx, "call")
+	child.Finish()
+	consumer.Finish()
+
+	consumed := consumer.(*mocktracer.MockSpan)
+	assert.Equal(t, producer.(*mocktracer.MockSpan).SpanContext.TraceID, consumed.SpanContext.TraceID)
+	assert.Equal(t, producer.(*mocktracer.MockSpan).SpanContext.SpanID, consumed.ParentID)
+	assert.Equal(t, consumed.SpanContext.SpanID, child.(*mocktracer.MockSpan).ParentID)
+	assert.NotEqual(t, other.(*mocktracer.MockSpan).SpanContext.SpanID, consumed.ParentID)
+}
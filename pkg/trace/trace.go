@@ -16,6 +16,8 @@ package trace
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/douyu/jupiter/pkg/xlog"
 	"github.com/opentracing/opentracing-go"
@@ -42,3 +44,17 @@ func StartSpanFromContext(ctx context.Context, op string, opts ...opentracing.St
 func SpanFromContext(ctx context.Context) opentracing.Span {
 	return opentracing.SpanFromContext(ctx)
 }
+
+// ExtractTraceID returns trace id of the span in ctx, empty if no span or
+// the tracer doesn't expose it
+func ExtractTraceID(ctx context.Context) string {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	// jaeger span context is formatted as traceid:spanid:parentid:flags
+	if sc, ok := span.Context().(fmt.Stringer); ok {
+		return strings.SplitN(sc.String(), ":", 2)[0]
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xbackoff provides jittered exponential backoff, so that retries of
+// many instances restarting at the same time spread out instead of hitting
+// the server in lockstep.
+package xbackoff
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xrand"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+)
+
+// Config ...
+type Config struct {
+	// BaseDelay is the delay before the first retry
+	BaseDelay time.Duration
+	// MaxDelay is the upper bound of delay before jitter
+	MaxDelay time.Duration
+	// Multiplier is the factor the delay grows by after each retry
+	Multiplier float64
+	// Jitter randomizes each delay by up to ±Jitter of itself, in [0, 1]
+	Jitter float64
+	// MaxRetries is the max number of retries of Retry, negative means unlimited
+	MaxRetries int
+}
+
+// DefaultConfig ...
+func DefaultConfig() Config {
+	return Config{
+		BaseDelay:  100 * time.Millisecond,
+		MaxDelay:   10 * time.Second,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+		MaxRetries: 3,
+	}
+}
+
+// Backoff returns the delay before the retries-th retry, starting from 0
+func (config Config) Backoff(retries int) time.Duration {
+	if config.BaseDelay <= 0 {
+		return 0
+	}
+	delay := float64(config.BaseDelay)
+	if config.Multiplier > 1 {
+		delay *= math.Pow(config.Multiplier, float64(retries))
+	}
+	if max := float64(config.MaxDelay); max > 0 && delay > max {
+		delay = max
+	}
+	return config.Jittered(time.Duration(delay))
+}
+
+// Jittered randomizes d by up to ±Jitter of itself
+func (config Config) Jittered(d time.Duration) time.Duration {
+	jitter := math.Min(math.Max(config.Jitter, 0), 1)
+	if jitter == 0 || d <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + jitter*(2*xrand.Float64()-1)))
+}
+
+// Retry calls fn until it succeeds, ctx is done or MaxRetries is exhausted,
+// sleeping with backoff between calls. The last error is returned.
+func Retry(ctx context.Context, config Config, clock xtime.Clock, fn func() error) error {
+	var err error
+	for retries := 0; ; retries++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if config.MaxRetries >= 0 && retries >= config.MaxRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-clock.After(config.Backoff(retries)):
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xbackoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Backoff(t *testing.T) {
+	config := Config{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2}
+	assert.Equal(t, time.Second, config.Backoff(0))
+	assert.Equal(t, 4*time.Second, config.Backoff(2))
+	assert.Equal(t, 5*time.Second, config.Backoff(10))
+
+	config.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := config.Backoff(0)
+		assert.True(t, delay >= 500*time.Millisecond && delay <= 1500*time.Millisecond, delay)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	config := Config{BaseDelay: time.Second, Multiplier: 2, MaxRetries: 2}
+
+	var calls int
+	done := make(chan error)
+	go func() {
+		done <- Retry(context.Background(), config, clock, func() error {
+			calls++
+			return errors.New("unavailable")
+		})
+	}()
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	clock.Advance(2 * time.Second)
+	assert.EqualError(t, <-done, "unavailable")
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	assert.Nil(t, Retry(context.Background(), config, clock, func() error {
+		calls++
+		return nil
+	}))
+	assert.Equal(t, 1, calls)
+}
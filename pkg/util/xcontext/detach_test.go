@@ -0,0 +1,51 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcontext
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+type key struct{}
+
+func TestDetach(t *testing.T) {
+	span := mocktracer.New().StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.WithValue(context.Background(), key{}, "v"), span)
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	cancel()
+
+	detached := Detach(ctx)
+	assert.Equal(t, context.Canceled, ctx.Err())
+	assert.Nil(t, detached.Err())
+	assert.Nil(t, detached.Done())
+	_, ok := detached.Deadline()
+	assert.False(t, ok)
+	assert.Equal(t, "v", detached.Value(key{}))
+	assert.Equal(t, span, opentracing.SpanFromContext(detached))
+
+	bounded, cancel := DetachWithTimeout(ctx, time.Minute)
+	defer cancel()
+	assert.Nil(t, bounded.Err())
+	deadline, ok := bounded.Deadline()
+	assert.True(t, ok)
+	assert.True(t, deadline.After(time.Now()))
+	assert.Equal(t, "v", bounded.Value(key{}))
+}
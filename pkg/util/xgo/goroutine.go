@@ -89,7 +89,7 @@ func GoDirect(fn interface{}, args ...interface{}) {
 
 // Go goroutine
 func Go(fn func()) {
-	go try2(fn, nil)
+	go try2(track(fn), nil)
 }
 
 // DelayGo goroutine
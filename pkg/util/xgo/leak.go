@@ -0,0 +1,121 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgo
+
+import (
+	"context"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xstring"
+)
+
+// LabelComponent is the pprof label key of goroutines started by Go when tracking
+const LabelComponent = "jupiter_component"
+
+var (
+	tracking int32
+	trackSeq uint64
+	tracked  sync.Map // seq => *Leak
+)
+
+// Leak is a goroutine started by Go which hasn't exited yet
+type Leak struct {
+	// Component is the name of the function run by the goroutine
+	Component string
+	// Stack is where the goroutine was created
+	Stack   string
+	Created time.Time
+}
+
+// SetTracking enables or disables tracking goroutines started by Go,
+// only goroutines started while tracking are reported by Leaks.
+func SetTracking(on bool) {
+	if on {
+		atomic.StoreInt32(&tracking, 1)
+	} else {
+		atomic.StoreInt32(&tracking, 0)
+	}
+}
+
+func track(fn func()) func() {
+	if atomic.LoadInt32(&tracking) == 0 {
+		return fn
+	}
+	leak := &Leak{
+		Component: xstring.FunctionName(fn),
+		Stack:     callers(4), // skip callers, track and Go
+		Created:   time.Now(),
+	}
+	seq := atomic.AddUint64(&trackSeq, 1)
+	tracked.Store(seq, leak)
+	return func() {
+		defer tracked.Delete(seq)
+		pprof.Do(context.Background(), pprof.Labels(LabelComponent, leak.Component), func(context.Context) {
+			fn()
+		})
+	}
+}
+
+// Leaks returns tracked goroutines still running, oldest first
+func Leaks() []Leak {
+	var leaks = make([]Leak, 0)
+	tracked.Range(func(_, v interface{}) bool {
+		leaks = append(leaks, *v.(*Leak))
+		return true
+	})
+	sort.Slice(leaks, func(i, j int) bool {
+		return leaks[i].Created.Before(leaks[j].Created)
+	})
+	return leaks
+}
+
+// WaitLeaks waits tracked goroutines to exit within drain, returns those still running
+func WaitLeaks(drain time.Duration) []Leak {
+	deadline := time.Now().Add(drain)
+	for {
+		leaks := Leaks()
+		if len(leaks) == 0 || !time.Now().Before(deadline) {
+			return leaks
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func callers(skip int) string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var sb strings.Builder
+	for {
+		frame, more := frames.Next()
+		sb.WriteString(frame.Function)
+		sb.WriteString("\n\t")
+		sb.WriteString(frame.File)
+		sb.WriteString(":")
+		sb.WriteString(strconv.Itoa(frame.Line))
+		sb.WriteString("\n")
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xgo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaks(t *testing.T) {
+	SetTracking(true)
+	defer SetTracking(false)
+
+	var stop = make(chan struct{})
+	Go(func() { <-stop })
+	Go(func() {})
+
+	leaks := WaitLeaks(time.Millisecond * 50)
+	assert.Len(t, leaks, 1)
+	assert.Contains(t, leaks[0].Component, "TestLeaks")
+	assert.Contains(t, leaks[0].Stack, "TestLeaks")
+	assert.NotContains(t, leaks[0].Stack, "xgo.track")
+
+	close(stop)
+	assert.Len(t, WaitLeaks(time.Second), 0)
+}
@@ -0,0 +1,298 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xoutlier tracks errors of endpoints passively and ejects outliers,
+// so that balancers skip them for a while. Ejections of an endpoint last
+// BaseEjection, doubled by each ejection in a row up to MaxEjection, and
+// endpoints staying healthy for MaxEjection after return start over.
+package xoutlier
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xstat"
+)
+
+// windowBuckets is the number of buckets of error rate windows
+const windowBuckets = 10
+
+// Config of outlier detection, it's read from consumer configs of the
+// registry, durations are strings, e.g. "30s"
+type Config struct {
+	// Enable ejects outliers
+	Enable bool `json:"enable"`
+	// ConsecutiveErrors of an endpoint ejecting it, 5 by default
+	ConsecutiveErrors int `json:"consecutiveErrors"`
+	// ErrorRate of an endpoint in Interval ejecting it, once there're at
+	// least MinRequests, 0.5 and 20 by default
+	ErrorRate   float64 `json:"errorRate"`
+	MinRequests int     `json:"minRequests"`
+	// Interval of error rates, 10s by default
+	Interval time.Duration `json:"-"`
+	// BaseEjection is the time of the first ejection, 30s by default
+	BaseEjection time.Duration `json:"-"`
+	// MaxEjection caps the time of ejections, 5m by default
+	MaxEjection time.Duration `json:"-"`
+	// MaxEjectedPercent of endpoints ejected at the same time, 50 by
+	// default, at least one endpoint is kept anyway
+	MaxEjectedPercent int `json:"maxEjectedPercent"`
+
+	clock xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() Config {
+	return Config{
+		ConsecutiveErrors: 5,
+		ErrorRate:         0.5,
+		MinRequests:       20,
+		Interval:          10 * time.Second,
+		BaseEjection:      30 * time.Second,
+		MaxEjection:       5 * time.Minute,
+		MaxEjectedPercent: 50,
+		clock:             xtime.SystemClock,
+	}
+}
+
+// UnmarshalJSON fills unset fields with defaults, and parses durations
+func (config *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	var v struct {
+		*plain
+		Interval     string `json:"interval"`
+		BaseEjection string `json:"baseEjection"`
+		MaxEjection  string `json:"maxEjection"`
+	}
+	*config = DefaultConfig()
+	v.plain = (*plain)(config)
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	for _, d := range []struct {
+		value string
+		out   *time.Duration
+	}{
+		{v.Interval, &config.Interval},
+		{v.BaseEjection, &config.BaseEjection},
+		{v.MaxEjection, &config.MaxEjection},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return err
+		}
+		*d.out = parsed
+	}
+	return nil
+}
+
+// MarshalJSON formats durations as strings
+func (config Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	return json.Marshal(struct {
+		plain
+		Interval     string `json:"interval"`
+		BaseEjection string `json:"baseEjection"`
+		MaxEjection  string `json:"maxEjection"`
+	}{
+		plain:        plain(config),
+		Interval:     config.Interval.String(),
+		BaseEjection: config.BaseEjection.String(),
+		MaxEjection:  config.MaxEjection.String(),
+	})
+}
+
+// WithClock ...
+func (config Config) WithClock(clock xtime.Clock) Config {
+	config.clock = clock
+	return config
+}
+
+// Build ...
+func (config Config) Build() *Detector {
+	d := &Detector{hosts: make(map[string]*host)}
+	d.SetConfig(config)
+	return d
+}
+
+// Detector tracks endpoints by address
+type Detector struct {
+	mu     sync.Mutex
+	config Config
+	hosts  map[string]*host
+	gen    uint64
+}
+
+type host struct {
+	total       *xstat.RollingCounter
+	failures    *xstat.RollingCounter
+	consecutive int
+	// ejections in a row, the next ejection lasts BaseEjection<<ejections
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// SetConfig updates the config, tracked endpoints are kept
+func (d *Detector) SetConfig(config Config) {
+	defaults := DefaultConfig()
+	if config.ConsecutiveErrors <= 0 {
+		config.ConsecutiveErrors = defaults.ConsecutiveErrors
+	}
+	if config.ErrorRate <= 0 {
+		config.ErrorRate = defaults.ErrorRate
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = defaults.MinRequests
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.BaseEjection <= 0 {
+		config.BaseEjection = defaults.BaseEjection
+	}
+	if config.MaxEjection < config.BaseEjection {
+		config.MaxEjection = config.BaseEjection
+	}
+	if config.MaxEjectedPercent <= 0 {
+		config.MaxEjectedPercent = defaults.MaxEjectedPercent
+	}
+	if config.clock == nil {
+		config.clock = defaults.clock
+	}
+	d.mu.Lock()
+	if config.Interval != d.config.Interval {
+		// windows of the previous interval are dropped
+		d.hosts = make(map[string]*host)
+	}
+	d.config = config
+	d.mu.Unlock()
+}
+
+// Config returns the config in use
+func (d *Detector) Config() Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.config
+}
+
+// Record records a call of the endpoint at addr
+func (d *Detector) Record(addr string, failed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	h, ok := d.hosts[addr]
+	if !ok {
+		h = d.newHost()
+		d.hosts[addr] = h
+	}
+	now := d.config.clock.Now()
+	if now.Before(h.ejectedUntil) {
+		// calls picked before the ejection
+		return
+	}
+	if h.ejections > 0 && now.Sub(h.ejectedUntil) >= d.config.MaxEjection {
+		h.ejections = 0
+	}
+
+	h.total.Inc()
+	if !failed {
+		h.consecutive = 0
+		return
+	}
+	h.failures.Inc()
+	h.consecutive++
+
+	total := h.total.Sum()
+	if h.consecutive < d.config.ConsecutiveErrors &&
+		(total < int64(d.config.MinRequests) || float64(h.failures.Sum()) < d.config.ErrorRate*float64(total)) {
+		return
+	}
+	ejection := d.config.MaxEjection
+	if h.ejections < 32 {
+		if base := d.config.BaseEjection << uint(h.ejections); base > 0 && base < ejection {
+			ejection = base
+		}
+	}
+	h.ejections++
+	h.ejectedUntil = now.Add(ejection)
+	// the endpoint is judged by calls after its return
+	h.total, h.failures, h.consecutive = d.newCounters()
+	atomic.AddUint64(&d.gen, 1)
+}
+
+func (d *Detector) newHost() *host {
+	h := &host{}
+	h.total, h.failures, h.consecutive = d.newCounters()
+	return h
+}
+
+func (d *Detector) newCounters() (*xstat.RollingCounter, *xstat.RollingCounter, int) {
+	width := d.config.Interval / windowBuckets
+	return xstat.NewRollingCounter(windowBuckets, width), xstat.NewRollingCounter(windowBuckets, width), 0
+}
+
+// Snapshot is the endpoints ejected at a time
+type Snapshot struct {
+	gen     uint64
+	expiry  time.Time
+	Ejected map[string]time.Time // addr => ejected until
+}
+
+// Snapshot returns the endpoints ejected now
+func (d *Detector) Snapshot() Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.config.clock.Now()
+	s := Snapshot{gen: atomic.LoadUint64(&d.gen), Ejected: make(map[string]time.Time)}
+	for addr, h := range d.hosts {
+		if !now.Before(h.ejectedUntil) {
+			continue
+		}
+		s.Ejected[addr] = h.ejectedUntil
+		if s.expiry.IsZero() || h.ejectedUntil.Before(s.expiry) {
+			s.expiry = h.ejectedUntil
+		}
+	}
+	return s
+}
+
+// Changed reports whether endpoints were ejected or returned since s
+func (d *Detector) Changed(s Snapshot) bool {
+	if atomic.LoadUint64(&d.gen) != s.gen {
+		return true
+	}
+	if s.expiry.IsZero() {
+		return false
+	}
+	d.mu.Lock()
+	clock := d.config.clock
+	d.mu.Unlock()
+	return !clock.Now().Before(s.expiry)
+}
+
+// Forget stops tracking endpoints not in addrs, e.g. removed ones
+func (d *Detector) Forget(addrs map[string]struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for addr := range d.hosts {
+		if _, ok := addrs[addr]; !ok {
+			delete(d.hosts, addr)
+		}
+	}
+}
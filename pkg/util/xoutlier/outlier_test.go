@@ -0,0 +1,107 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xoutlier
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestDetector() (*Detector, *xtime.MockClock) {
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	config := DefaultConfig().WithClock(clock)
+	config.ConsecutiveErrors = 3
+	config.MinRequests = 10
+	config.BaseEjection = 10 * time.Second
+	config.MaxEjection = 30 * time.Second
+	return config.Build(), clock
+}
+
+func TestDetector_Consecutive(t *testing.T) {
+	d, clock := newTestDetector()
+	d.Record("a:1", true)
+	d.Record("a:1", true)
+	d.Record("a:1", false)
+	d.Record("a:1", true)
+	d.Record("a:1", true)
+	assert.Empty(t, d.Snapshot().Ejected)
+
+	s := d.Snapshot()
+	d.Record("a:1", true)
+	assert.True(t, d.Changed(s))
+	s = d.Snapshot()
+	assert.Equal(t, map[string]time.Time{"a:1": clock.Now().Add(10 * time.Second)}, s.Ejected)
+
+	// calls picked before the ejection are ignored
+	d.Record("a:1", true)
+	assert.False(t, d.Changed(s))
+
+	// ejections double in a row up to MaxEjection
+	for _, ejection := range []time.Duration{20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		clock.Advance(d.Snapshot().Ejected["a:1"].Sub(clock.Now()))
+		assert.True(t, d.Changed(s))
+		assert.Empty(t, d.Snapshot().Ejected)
+		for i := 0; i < 3; i++ {
+			d.Record("a:1", true)
+		}
+		s = d.Snapshot()
+		assert.Equal(t, clock.Now().Add(ejection), s.Ejected["a:1"])
+	}
+
+	// endpoints healthy for MaxEjection start over
+	clock.Advance(30*time.Second + 30*time.Second)
+	d.Record("a:1", false)
+	for i := 0; i < 3; i++ {
+		d.Record("a:1", true)
+	}
+	assert.Equal(t, clock.Now().Add(10*time.Second), d.Snapshot().Ejected["a:1"])
+}
+
+func TestDetector_ErrorRate(t *testing.T) {
+	d, _ := newTestDetector()
+	for i := 0; i < 8; i++ {
+		d.Record("b:1", i%2 == 0)
+	}
+	assert.Empty(t, d.Snapshot().Ejected)
+	d.Record("b:1", false)
+	d.Record("b:1", true)
+	assert.Contains(t, d.Snapshot().Ejected, "b:1")
+
+	d.Forget(map[string]struct{}{"c:1": {}})
+	assert.Empty(t, d.Snapshot().Ejected)
+}
+
+func TestConfig_JSON(t *testing.T) {
+	var config Config
+	assert.Nil(t, json.Unmarshal([]byte(`{"enable":true,"consecutiveErrors":3,"baseEjection":"1m"}`), &config))
+	assert.True(t, config.Enable)
+	assert.Equal(t, 3, config.ConsecutiveErrors)
+	assert.Equal(t, time.Minute, config.BaseEjection)
+	assert.Equal(t, 5*time.Minute, config.MaxEjection)
+	assert.Equal(t, 0.5, config.ErrorRate)
+
+	data, err := json.Marshal(config)
+	assert.Nil(t, err)
+	var decoded Config
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, config.BaseEjection, decoded.BaseEjection)
+	assert.Equal(t, config.Interval, decoded.Interval)
+
+	assert.NotNil(t, json.Unmarshal([]byte(`{"interval":"soon"}`), &config))
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ewma
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xp2c"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xstat"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// decayTime is the time constant of the latency average, samples older
+// than it weigh less than 1/e
+const decayTime = 10 * time.Second
+
+// errorPenalty is the least latency sample of failed requests, so that
+// nodes failing fast, e.g. refusing connections, get less traffic rather
+// than more
+const errorPenalty = time.Second
+
+type ewmaNode struct {
+	item     interface{}
+	inflight int64
+	// latency is the moving average in nanoseconds
+	latency *xstat.EWMA
+}
+
+func newEWMANode(item interface{}) *ewmaNode {
+	return &ewmaNode{item: item, latency: xstat.NewEWMA(decayTime)}
+}
+
+// load is the expected latency of a new request, which queues behind the
+// inflight ones, nodes without samples are tried first
+func (n *ewmaNode) load() float64 {
+	return n.latency.Value() * float64(atomic.LoadInt64(&n.inflight)+1)
+}
+
+// observe adds a latency sample
+func (n *ewmaNode) observe(now time.Time, latency time.Duration) {
+	n.latency.Observe(now, float64(latency))
+}
+
+type ewma struct {
+	items   []*ewmaNode
+	tracker *Tracker
+	clock   xtime.Clock
+	mu      sync.Mutex
+	rand    *rand.Rand
+}
+
+// New returns a p2c picking the item of lower load of two random ones, the
+// load is the EWMA latency times inflight requests of the item
+func New() xp2c.P2c {
+	return NewTracker().New()
+}
+
+func (p *ewma) Add(item interface{}) {
+	p.items = append(p.items, p.tracker.node(item))
+}
+
+// Tracker keeps EWMA state of items across p2cs created by it, so that
+// latencies survive rebuilding p2cs once items change, e.g. pickers of a
+// ClientConn, p2cs must be created one after another
+type Tracker struct {
+	mu    sync.Mutex
+	nodes map[interface{}]*ewmaNode
+	added map[interface{}]bool
+	clock xtime.Clock
+}
+
+// NewTracker ...
+func NewTracker() *Tracker {
+	return &Tracker{
+		nodes: make(map[interface{}]*ewmaNode),
+		added: make(map[interface{}]bool),
+		clock: xtime.SystemClock,
+	}
+}
+
+// New returns a p2c of items added to it, with the state of items added to
+// the last one kept and state of others dropped
+func (t *Tracker) New() xp2c.P2c {
+	t.mu.Lock()
+	for item := range t.nodes {
+		if !t.added[item] {
+			delete(t.nodes, item)
+		}
+	}
+	t.added = make(map[interface{}]bool)
+	t.mu.Unlock()
+	return &ewma{
+		items:   make([]*ewmaNode, 0),
+		tracker: t,
+		clock:   t.clock,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (t *Tracker) node(item interface{}) *ewmaNode {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.added[item] = true
+	n, ok := t.nodes[item]
+	if !ok {
+		n = newEWMANode(item)
+		t.nodes[item] = n
+	}
+	return n
+}
+
+func (p *ewma) Next() (interface{}, func(balancer.DoneInfo)) {
+	var sc *ewmaNode
+
+	switch len(p.items) {
+	case 0:
+		return nil, func(balancer.DoneInfo) {}
+	case 1:
+		sc = p.items[0]
+	default:
+		// rand needs lock
+		p.mu.Lock()
+		a := p.rand.Intn(len(p.items))
+		b := p.rand.Intn(len(p.items) - 1)
+		p.mu.Unlock()
+
+		if b >= a {
+			b = b + 1
+		}
+		sc = p.items[a]
+		if backsc := p.items[b]; backsc.load() < sc.load() {
+			sc = backsc
+		}
+	}
+
+	atomic.AddInt64(&sc.inflight, 1)
+	start := p.clock.Now()
+
+	return sc.item, func(info balancer.DoneInfo) {
+		atomic.AddInt64(&sc.inflight, -1)
+		now := p.clock.Now()
+		latency := now.Sub(start)
+		if failed(info.Err) && latency < errorPenalty {
+			latency = errorPenalty
+		}
+		sc.observe(now, latency)
+	}
+}
+
+// failed reports whether err is a failure of the node rather than of the
+// request, e.g. unavailable rather than not found or canceled by the caller
+func failed(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	}
+	return false
+}
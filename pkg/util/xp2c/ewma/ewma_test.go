@@ -0,0 +1,130 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ewma
+
+import (
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEWMA(t *testing.T) {
+	t.Run("0 item", func(t *testing.T) {
+		p := New()
+		item, done := p.Next()
+		done(balancer.DoneInfo{})
+		assert.Nil(t, item)
+	})
+
+	t.Run("prefers lower latency", func(t *testing.T) {
+		clock := xtime.NewMockClock(time.Unix(0, 0))
+		p := New().(*ewma)
+		p.clock = clock
+		p.Add(1)
+		p.Add(2)
+
+		// item 1 is slow, item 2 is fast
+		p.items[0].observe(clock.Now(), 100*time.Millisecond)
+		p.items[1].observe(clock.Now(), time.Millisecond)
+
+		for i := 0; i < 100; i++ {
+			item, done := p.Next()
+			assert.Equal(t, 2, item)
+			clock.Advance(time.Millisecond)
+			done(balancer.DoneInfo{})
+		}
+	})
+
+	t.Run("penalizes inflight", func(t *testing.T) {
+		p := New().(*ewma)
+		p.Add(1)
+		p.Add(2)
+		p.items[0].observe(time.Unix(0, 0), 10*time.Millisecond)
+		p.items[1].observe(time.Unix(0, 0), time.Millisecond)
+		p.items[1].inflight = 20
+
+		item, done := p.Next()
+		done(balancer.DoneInfo{})
+		assert.Equal(t, 1, item)
+	})
+
+	t.Run("spreads unsampled items", func(t *testing.T) {
+		p := New()
+		p.Add(1)
+		p.Add(2)
+		p.Add(3)
+		countMap := make(map[interface{}]int)
+		for i := 0; i < 3000; i++ {
+			item, _ := p.Next()
+			countMap[item]++
+		}
+		assert.Len(t, countMap, 3)
+	})
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker()
+	p := tracker.New().(*ewma)
+	p.Add(1)
+	p.Add(2)
+	p.items[0].observe(time.Unix(0, 0), 100*time.Millisecond)
+
+	// state of items still added is kept, others are dropped
+	p = tracker.New().(*ewma)
+	p.Add(1)
+	assert.Equal(t, float64(100*time.Millisecond), p.items[0].latency.Value())
+	assert.Len(t, tracker.nodes, 2)
+	tracker.New()
+	assert.Len(t, tracker.nodes, 1)
+}
+
+func TestPenalizeErrors(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	p := New().(*ewma)
+	p.clock = clock
+	p.Add(1)
+
+	_, done := p.Next()
+	done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "connection refused")})
+	assert.Equal(t, float64(errorPenalty), p.items[0].latency.Value())
+
+	p = New().(*ewma)
+	p.clock = clock
+	p.Add(1)
+	_, done = p.Next()
+	clock.Advance(time.Millisecond)
+	done(balancer.DoneInfo{Err: status.Error(codes.NotFound, "no such user")})
+	assert.Equal(t, float64(time.Millisecond), p.items[0].latency.Value())
+}
+
+func TestObserve(t *testing.T) {
+	n := newEWMANode(1)
+	start := time.Unix(0, 0)
+	n.observe(start, 100*time.Millisecond)
+	assert.Equal(t, float64(100*time.Millisecond), n.latency.Value())
+
+	// samples right after the last one barely move the average
+	n.observe(start, time.Millisecond)
+	assert.Equal(t, float64(100*time.Millisecond), n.latency.Value())
+
+	// samples long after the last one mostly replace it
+	n.observe(start.Add(time.Minute), time.Millisecond)
+	assert.InDelta(t, float64(time.Millisecond), n.latency.Value(), float64(time.Millisecond))
+}
@@ -0,0 +1,204 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xretry is the retry and hedging policy of grpc calls, which is
+// configured by clients and overridden by consumer configs of the registry,
+// and the budgets bounding retries of each method.
+package xretry
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/xstat"
+	"google.golang.org/grpc/codes"
+)
+
+// budgetBuckets and budgetWidth make the 10s window of budgets
+const (
+	budgetBuckets = 10
+	budgetWidth   = time.Second
+)
+
+// Policy of retries and hedges, durations are strings in JSON, e.g. "100ms"
+type Policy struct {
+	// MaxRetries of a call besides the first attempt, hedges included,
+	// retries are off if zero
+	MaxRetries int `json:"maxRetries"`
+	// Codes retried, e.g. "Unavailable" or "UNAVAILABLE", Unavailable by default
+	Codes []string `json:"codes"`
+	// Backoff between retries, its MaxRetries is ignored
+	Backoff xbackoff.Config `json:"-"`
+	// HedgeDelay sends another attempt once the previous ones haven't
+	// responded in it, the first response wins, only for idempotent methods,
+	// hedging is off if zero
+	HedgeDelay time.Duration `json:"-"`
+	// BudgetRatio caps retries of a method to the ratio of its calls in 10s,
+	// BudgetMin retries are always allowed, 0.2 and 10 by default
+	BudgetRatio float64 `json:"budgetRatio"`
+	BudgetMin   int     `json:"budgetMin"`
+	// Methods override the policy of full methods, e.g.
+	// "/helloworld.Greeter/SayHello", or services, e.g. "/helloworld.Greeter/*"
+	Methods map[string]Policy `json:"methods"`
+}
+
+// DefaultPolicy ...
+func DefaultPolicy() Policy {
+	return Policy{
+		Codes:       []string{codes.Unavailable.String()},
+		Backoff:     xbackoff.DefaultConfig(),
+		BudgetRatio: 0.2,
+		BudgetMin:   10,
+	}
+}
+
+type jsonPolicy struct {
+	BaseDelay  string  `json:"baseDelay"`
+	MaxDelay   string  `json:"maxDelay"`
+	Multiplier float64 `json:"multiplier"`
+	Jitter     float64 `json:"jitter"`
+	HedgeDelay string  `json:"hedgeDelay"`
+}
+
+// UnmarshalJSON fills unset fields with defaults, and parses durations
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	type plain Policy
+	var v struct {
+		*plain
+		jsonPolicy
+	}
+	*p = DefaultPolicy()
+	v.plain = (*plain)(p)
+	v.Multiplier, v.Jitter = p.Backoff.Multiplier, p.Backoff.Jitter
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	p.Backoff.Multiplier, p.Backoff.Jitter = v.Multiplier, v.Jitter
+	for _, d := range []struct {
+		value string
+		out   *time.Duration
+	}{
+		{v.BaseDelay, &p.Backoff.BaseDelay},
+		{v.MaxDelay, &p.Backoff.MaxDelay},
+		{v.jsonPolicy.HedgeDelay, &p.HedgeDelay},
+	} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return err
+		}
+		*d.out = parsed
+	}
+	return nil
+}
+
+// MarshalJSON formats durations as strings
+func (p Policy) MarshalJSON() ([]byte, error) {
+	type plain Policy
+	return json.Marshal(struct {
+		plain
+		jsonPolicy
+	}{
+		plain: plain(p),
+		jsonPolicy: jsonPolicy{
+			BaseDelay:  p.Backoff.BaseDelay.String(),
+			MaxDelay:   p.Backoff.MaxDelay.String(),
+			Multiplier: p.Backoff.Multiplier,
+			Jitter:     p.Backoff.Jitter,
+			HedgeDelay: p.HedgeDelay.String(),
+		},
+	})
+}
+
+// ForMethod returns the policy of the full method, overrides of the method
+// take precedence over those of its service
+func (p Policy) ForMethod(method string) Policy {
+	if override, ok := p.Methods[method]; ok {
+		return override
+	}
+	if idx := strings.LastIndexByte(method, '/'); idx > 0 {
+		if override, ok := p.Methods[method[:idx+1]+"*"]; ok {
+			return override
+		}
+	}
+	return p
+}
+
+// Enabled reports whether calls are retried or hedged
+func (p Policy) Enabled() bool {
+	return p.MaxRetries > 0
+}
+
+// Retryable reports whether calls failing with code are retried
+func (p Policy) Retryable(code codes.Code) bool {
+	if len(p.Codes) == 0 {
+		return code == codes.Unavailable
+	}
+	for _, c := range p.Codes {
+		if strings.EqualFold(strings.ReplaceAll(c, "_", ""), code.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Budget bounds retries of a method to a ratio of its calls
+type Budget struct {
+	calls   *xstat.RollingCounter
+	retries *xstat.RollingCounter
+	// ratio in millionths and min are updated with policies
+	ratio uint64
+	min   int64
+}
+
+// NewBudget ...
+func NewBudget(p Policy) *Budget {
+	b := &Budget{
+		calls:   xstat.NewRollingCounter(budgetBuckets, budgetWidth),
+		retries: xstat.NewRollingCounter(budgetBuckets, budgetWidth),
+	}
+	b.Update(p)
+	return b
+}
+
+// Update applies the budget of p
+func (b *Budget) Update(p Policy) {
+	ratio := p.BudgetRatio
+	if ratio <= 0 {
+		ratio = DefaultPolicy().BudgetRatio
+	}
+	atomic.StoreUint64(&b.ratio, uint64(ratio*1e6))
+	atomic.StoreInt64(&b.min, int64(p.BudgetMin))
+}
+
+// Call records a call
+func (b *Budget) Call() {
+	b.calls.Inc()
+}
+
+// Retry reports whether a retry is allowed and records it if so
+func (b *Budget) Retry() bool {
+	retries := b.retries.Sum()
+	ratio := float64(atomic.LoadUint64(&b.ratio)) / 1e6
+	if retries >= atomic.LoadInt64(&b.min) && float64(retries) >= ratio*float64(b.calls.Sum()) {
+		return false
+	}
+	b.retries.Inc()
+	return true
+}
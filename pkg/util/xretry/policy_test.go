@@ -0,0 +1,75 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xretry
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func TestPolicyJSON(t *testing.T) {
+	var p Policy
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"maxRetries": 2,
+		"codes": ["UNAVAILABLE", "DeadlineExceeded"],
+		"baseDelay": "10ms",
+		"hedgeDelay": "50ms",
+		"methods": {"/hello.Greeter/*": {"maxRetries": 1}}
+	}`), &p))
+	assert.Equal(t, 2, p.MaxRetries)
+	assert.Equal(t, 10*time.Millisecond, p.Backoff.BaseDelay)
+	assert.Equal(t, DefaultPolicy().Backoff.MaxDelay, p.Backoff.MaxDelay)
+	assert.Equal(t, 50*time.Millisecond, p.HedgeDelay)
+	assert.Equal(t, 0.2, p.BudgetRatio)
+
+	data, err := json.Marshal(p)
+	assert.Nil(t, err)
+	var decoded Policy
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, p, decoded)
+
+	assert.True(t, p.Retryable(codes.Unavailable))
+	assert.True(t, p.Retryable(codes.DeadlineExceeded))
+	assert.False(t, p.Retryable(codes.InvalidArgument))
+	assert.True(t, DefaultPolicy().Retryable(codes.Unavailable))
+	assert.False(t, DefaultPolicy().Enabled())
+}
+
+func TestPolicyForMethod(t *testing.T) {
+	p := Policy{MaxRetries: 3, Methods: map[string]Policy{
+		"/hello.Greeter/*":        {MaxRetries: 1},
+		"/hello.Greeter/SayHello": {MaxRetries: 2},
+	}}
+	assert.Equal(t, 2, p.ForMethod("/hello.Greeter/SayHello").MaxRetries)
+	assert.Equal(t, 1, p.ForMethod("/hello.Greeter/SayBye").MaxRetries)
+	assert.Equal(t, 3, p.ForMethod("/other.Service/Call").MaxRetries)
+}
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(Policy{BudgetRatio: 0.5, BudgetMin: 1})
+	for i := 0; i < 4; i++ {
+		b.Call()
+	}
+	assert.True(t, b.Retry())
+	assert.True(t, b.Retry())
+	assert.False(t, b.Retry())
+
+	b.Update(Policy{BudgetRatio: 0.5, BudgetMin: 5})
+	assert.True(t, b.Retry())
+}
@@ -0,0 +1,279 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xconf provides golden-config helpers for tests: it loads every
+// config file under a testdata directory, constructs the declared components
+// in validation-only mode (config parsing without Build), and fuzzes
+// duration/size/ratio values to catch panics from unvalidated config.
+package xconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/douyu/jupiter/pkg/conf"
+)
+
+// Validator constructs the component declared under key in validation-only
+// mode, e.g. by calling the component's RawConfig without Build.
+// Returning an error means the config was rejected, which is fine;
+// panicking is reported as a test failure.
+type Validator func(key string) error
+
+// Components maps a key pattern to its validator. A '*' segment in the
+// pattern matches exactly one key segment, e.g. "jupiter.client.*".
+type Components map[string]Validator
+
+// Fixture is a parsed golden config file.
+type Fixture struct {
+	Name string
+	Data map[string]interface{}
+}
+
+var unmarshallers = map[string]conf.Unmarshaller{
+	".toml": toml.Unmarshal,
+	".json": json.Unmarshal,
+}
+
+// LoadFixtures loads every toml/json config file under dir.
+func LoadFixtures(dir string) ([]Fixture, error) {
+	var fixtures = make([]Fixture, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		unmarshal, ok := unmarshallers[filepath.Ext(path)]
+		if info.IsDir() || !ok {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var data = make(map[string]interface{})
+		if err := unmarshal(content, &data); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fixtures = append(fixtures, Fixture{Name: path, Data: data})
+		return nil
+	})
+	return fixtures, err
+}
+
+// CheckGolden loads every config file under dir and validates all declared
+// components of each file.
+func CheckGolden(t *testing.T, dir string, components Components) {
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatalf("load golden fixtures: %v", err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatalf("no golden fixtures under %s", dir)
+	}
+	defer conf.Reset()
+	for _, fixture := range fixtures {
+		fixture := fixture
+		t.Run(fixture.Name, func(t *testing.T) {
+			for key, err := range validate(fixture.Data, components) {
+				if panicked, ok := err.(*panicError); ok {
+					t.Errorf("%s: %s", key, panicked)
+				} else {
+					t.Errorf("%s: %v", key, err)
+				}
+			}
+		})
+	}
+}
+
+// FuzzGolden loads every config file under dir, replaces each duration,
+// size and ratio value in turn with edge values, and reports the components that panic.
+// Errors returned by validators are tolerated since rejecting a bad value is
+// the expected behavior.
+func FuzzGolden(t *testing.T, dir string, components Components) {
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatalf("load golden fixtures: %v", err)
+	}
+	defer conf.Reset()
+	for _, fixture := range fixtures {
+		fixture := fixture
+		t.Run(fixture.Name, func(t *testing.T) {
+			for _, leaf := range leaves(fixture.Data) {
+				for _, edge := range edgeValues(leaf.value) {
+					data := deepCopy(fixture.Data)
+					setPath(data, leaf.path, edge)
+					for key, err := range validate(data, components) {
+						if panicked, ok := err.(*panicError); ok {
+							t.Errorf("%s=%v: %s: %s", strings.Join(leaf.path, "."), edge, key, panicked)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// durationEdges are fed to every value parsable as a time.Duration.
+var durationEdges = []interface{}{"0s", "-1s", "1ns", "2562047h", "-2562047h", "not-a-duration"}
+
+// sizeEdges are fed to every integer value, including integral numbers of
+// json files, which are decoded as float64.
+var sizeEdges = []interface{}{int64(0), int64(-1), int64(1<<31 - 1), int64(1<<63 - 1), int64(-1 << 63)}
+
+// ratioEdges are fed to every fractional value, e.g. sample rates and factors.
+var ratioEdges = []interface{}{float64(0), float64(-1), float64(2), math.MaxFloat64, math.Inf(1), math.NaN()}
+
+func edgeValues(val interface{}) []interface{} {
+	switch v := val.(type) {
+	case string:
+		if isDuration(v) {
+			return durationEdges
+		}
+	case int, int32, int64, uint, uint32, uint64:
+		return sizeEdges
+	case float64:
+		if v == math.Trunc(v) {
+			return sizeEdges
+		}
+		return ratioEdges
+	}
+	return nil
+}
+
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.value, e.stack)
+}
+
+// validate applies data as the default configuration and runs every
+// validator whose pattern matches a declared key.
+func validate(data map[string]interface{}, components Components) map[string]error {
+	conf.Reset()
+	_ = conf.Apply(deepCopy(data))
+
+	var errs = make(map[string]error)
+	for pattern, validator := range components {
+		for _, key := range matchKeys(data, strings.Split(pattern, ".")) {
+			if err := guard(validator, key); err != nil {
+				errs[key] = err
+			}
+		}
+	}
+	return errs
+}
+
+func guard(validator Validator, key string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{value: r, stack: debug.Stack()}
+		}
+	}()
+	return validator(key)
+}
+
+// matchKeys returns the declared keys matching the pattern segments.
+func matchKeys(data map[string]interface{}, segments []string) []string {
+	if len(segments) == 0 {
+		return nil
+	}
+	var keys = make([]string, 0)
+	for k, v := range data {
+		if segments[0] != "*" && segments[0] != k {
+			continue
+		}
+		if len(segments) == 1 {
+			keys = append(keys, k)
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			for _, key := range matchKeys(sub, segments[1:]) {
+				keys = append(keys, k+"."+key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type leaf struct {
+	path  []string
+	value interface{}
+}
+
+func leaves(data map[string]interface{}) []leaf {
+	var ret = make([]leaf, 0)
+	var walk func(prefix []string, m map[string]interface{})
+	walk = func(prefix []string, m map[string]interface{}) {
+		for k, v := range m {
+			path := append(append([]string{}, prefix...), k)
+			if sub, ok := v.(map[string]interface{}); ok {
+				walk(path, sub)
+				continue
+			}
+			ret = append(ret, leaf{path: path, value: v})
+		}
+	}
+	walk(nil, data)
+	sort.Slice(ret, func(i, j int) bool {
+		return strings.Join(ret[i].path, ".") < strings.Join(ret[j].path, ".")
+	})
+	return ret
+}
+
+func setPath(data map[string]interface{}, path []string, val interface{}) {
+	m := data
+	for _, k := range path[:len(path)-1] {
+		m = m[k].(map[string]interface{})
+	}
+	m[path[len(path)-1]] = val
+}
+
+func deepCopy(src map[string]interface{}) map[string]interface{} {
+	dst := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			dst[k] = deepCopy(sub)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+func isDuration(s string) bool {
+	if s == "" {
+		return false
+	}
+	// only treat strings ending with a unit as durations, so "1" or "tcp4" are skipped
+	last := s[len(s)-1]
+	if last != 's' && last != 'm' && last != 'h' {
+		return false
+	}
+	_, err := time.ParseDuration(s)
+	return err == nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xconf
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/stretchr/testify/assert"
+)
+
+const goldenDir = "../../../../test/testdata/golden"
+
+func TestCheckGolden(t *testing.T) {
+	CheckGolden(t, goldenDir, Components{
+		"jupiter.logger.*": func(key string) error {
+			xlog.RawConfig(key)
+			return nil
+		},
+	})
+}
+
+func TestFuzzGolden(t *testing.T) {
+	FuzzGolden(t, goldenDir, Components{
+		"jupiter.client.*": func(key string) error {
+			if conf.GetDuration(key+".readTimeout") < 0 {
+				return errors.New("negative read timeout")
+			}
+			return nil
+		},
+	})
+}
+
+func TestValidateRecoversPanic(t *testing.T) {
+	data := map[string]interface{}{
+		"jupiter": map[string]interface{}{
+			"client": map[string]interface{}{
+				"demo": map[string]interface{}{"readTimeout": "-1s"},
+			},
+		},
+	}
+	errs := validate(data, Components{
+		"jupiter.client.*": func(key string) error {
+			if conf.GetDuration(key+".readTimeout") < 0 {
+				panic("negative read timeout")
+			}
+			return nil
+		},
+	})
+	assert.Len(t, errs, 1)
+	_, ok := errs["jupiter.client.demo"].(*panicError)
+	assert.True(t, ok)
+}
+
+func TestMatchKeys(t *testing.T) {
+	fixtures, err := LoadFixtures(goldenDir)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, fixtures)
+	assert.Equal(t, []string{"jupiter.client.demo"}, matchKeys(fixtures[0].Data, []string{"jupiter", "client", "*"}))
+	assert.Equal(t, []string{"jupiter.logger.default"}, matchKeys(fixtures[0].Data, []string{"*", "logger", "default"}))
+}
+
+func TestEdgeValues(t *testing.T) {
+	assert.Equal(t, durationEdges, edgeValues("3s"))
+	assert.Equal(t, sizeEdges, edgeValues(int64(500)))
+	// numbers of json files
+	assert.Equal(t, sizeEdges, edgeValues(float64(500)))
+	assert.Equal(t, ratioEdges, edgeValues(0.5))
+	assert.Nil(t, edgeValues("tcp4"))
+	assert.Nil(t, edgeValues("1"))
+	assert.Nil(t, edgeValues(true))
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtime
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of time-dependent components, e.g. registry
+// keepalive, breakers, limiters and cron. Components default to SystemClock,
+// tests can inject a MockClock and advance virtual time deterministically.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) ClockTimer
+	NewTicker(d time.Duration) ClockTicker
+}
+
+// ClockTimer is a timer created by Clock.AfterFunc
+type ClockTimer interface {
+	// Stop prevents the timer from firing, returns false if the timer
+	// has already fired or been stopped
+	Stop() bool
+}
+
+// ClockTicker is a ticker created by Clock.NewTicker
+type ClockTicker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the clock backed by package time
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+// Now ...
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Since ...
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// Sleep ...
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// After ...
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// AfterFunc ...
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer { return time.AfterFunc(d, f) }
+
+// NewTicker ...
+func (systemClock) NewTicker(d time.Duration) ClockTicker {
+	return &systemTicker{Ticker: time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+// C ...
+func (t *systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// MockClock is a manually driven clock for tests. Time only moves forward
+// on Advance or Set, which fire due timers and tickers in deadline order
+// before returning.
+type MockClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*mockWaiter
+}
+
+type mockWaiter struct {
+	clock    *MockClock
+	deadline time.Time
+	period   time.Duration
+	c        chan time.Time
+	f        func()
+}
+
+// NewMockClock returns a mock clock starting at t
+func NewMockClock(t time.Time) *MockClock {
+	mc := &MockClock{now: t}
+	mc.cond = sync.NewCond(&mc.mu)
+	return mc
+}
+
+// Now ...
+func (mc *MockClock) Now() time.Time {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.now
+}
+
+// Since ...
+func (mc *MockClock) Since(t time.Time) time.Duration {
+	return mc.Now().Sub(t)
+}
+
+// Sleep blocks until the clock is advanced by d
+func (mc *MockClock) Sleep(d time.Duration) {
+	<-mc.After(d)
+}
+
+// After ...
+func (mc *MockClock) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	mc.add(&mockWaiter{deadline: mc.Now().Add(d), c: c})
+	return c
+}
+
+// AfterFunc calls f in the goroutine calling Advance once the clock reaches d
+func (mc *MockClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	w := &mockWaiter{deadline: mc.Now().Add(d), f: f}
+	mc.add(w)
+	return mockTimer{w}
+}
+
+// NewTicker ...
+func (mc *MockClock) NewTicker(d time.Duration) ClockTicker {
+	if d <= 0 {
+		panic("xtime: non-positive interval for NewTicker")
+	}
+	w := &mockWaiter{deadline: mc.Now().Add(d), period: d, c: make(chan time.Time, 1)}
+	mc.add(w)
+	return mockTicker{w}
+}
+
+// Advance moves the clock forward by d
+func (mc *MockClock) Advance(d time.Duration) {
+	mc.Set(mc.Now().Add(d))
+}
+
+// Set moves the clock to t, t before current time is ignored
+func (mc *MockClock) Set(t time.Time) {
+	for {
+		mc.mu.Lock()
+		if len(mc.waiters) == 0 || mc.waiters[0].deadline.After(t) {
+			if t.After(mc.now) {
+				mc.now = t
+			}
+			mc.mu.Unlock()
+			return
+		}
+		w := mc.waiters[0]
+		if w.deadline.After(mc.now) {
+			mc.now = w.deadline
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+			mc.sort()
+		} else {
+			mc.waiters = mc.waiters[1:]
+		}
+		now := mc.now
+		mc.mu.Unlock()
+
+		if w.f != nil {
+			w.f()
+			continue
+		}
+		// drop ticks for slow receivers like time.Ticker does
+		select {
+		case w.c <- now:
+		default:
+		}
+	}
+}
+
+// Waiters returns the number of pending timers, tickers and sleepers
+func (mc *MockClock) Waiters() int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return len(mc.waiters)
+}
+
+// BlockUntil blocks until there are at least n pending waiters, it is used
+// to make sure a goroutine is sleeping on the clock before advancing it.
+func (mc *MockClock) BlockUntil(n int) {
+	mc.mu.Lock()
+	for len(mc.waiters) < n {
+		mc.cond.Wait()
+	}
+	mc.mu.Unlock()
+}
+
+func (mc *MockClock) add(w *mockWaiter) {
+	w.clock = mc
+	mc.mu.Lock()
+	mc.waiters = append(mc.waiters, w)
+	mc.sort()
+	mc.cond.Broadcast()
+	mc.mu.Unlock()
+}
+
+func (mc *MockClock) sort() {
+	sort.SliceStable(mc.waiters, func(i, j int) bool {
+		return mc.waiters[i].deadline.Before(mc.waiters[j].deadline)
+	})
+}
+
+func (w *mockWaiter) stop() bool {
+	mc := w.clock
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	for i, waiter := range mc.waiters {
+		if waiter == w {
+			mc.waiters = append(mc.waiters[:i], mc.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type mockTimer struct{ *mockWaiter }
+
+// Stop ...
+func (t mockTimer) Stop() bool { return t.stop() }
+
+type mockTicker struct{ *mockWaiter }
+
+// C ...
+func (t mockTicker) C() <-chan time.Time { return t.c }
+
+// Stop ...
+func (t mockTicker) Stop() { t.stop() }
@@ -0,0 +1,81 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtime
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMockClock(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	mc := NewMockClock(start)
+	assert.Equal(t, start, mc.Now())
+
+	after := mc.After(time.Second)
+	var fired int32
+	timer := mc.AfterFunc(2*time.Second, func() { atomic.AddInt32(&fired, 1) })
+	ticker := mc.NewTicker(500 * time.Millisecond)
+	assert.Equal(t, 3, mc.Waiters())
+
+	mc.Advance(999 * time.Millisecond)
+	select {
+	case <-after:
+		t.Fatal("fired too early")
+	default:
+	}
+	assert.Equal(t, start.Add(500*time.Millisecond), <-ticker.C())
+
+	mc.Advance(time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-after)
+	assert.Equal(t, start.Add(time.Second), <-ticker.C())
+	assert.Equal(t, time.Second, mc.Since(start))
+
+	mc.Advance(time.Second)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fired))
+	assert.False(t, timer.Stop())
+
+	ticker.Stop()
+	assert.Equal(t, 0, mc.Waiters())
+}
+
+func TestMockClock_Sleep(t *testing.T) {
+	mc := NewMockClock(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		mc.Sleep(time.Minute)
+		close(done)
+	}()
+
+	mc.BlockUntil(1)
+	mc.Advance(time.Minute)
+	<-done
+	assert.Equal(t, time.Unix(60, 0), mc.Now())
+}
+
+func TestSystemClock(t *testing.T) {
+	var clock Clock = SystemClock
+	beg := clock.Now()
+	<-clock.After(time.Millisecond)
+	assert.True(t, clock.Since(beg) >= time.Millisecond)
+
+	ticker := clock.NewTicker(time.Millisecond)
+	<-ticker.C()
+	ticker.Stop()
+	assert.True(t, clock.AfterFunc(time.Hour, func() {}).Stop())
+}
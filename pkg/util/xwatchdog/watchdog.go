@@ -0,0 +1,318 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xwatchdog reports requests running far longer than expected,
+// along with stacks of the goroutines handling them.
+//
+// With CancelGrace, it also audits requests still running long after their
+// contexts were done, i.e. handlers ignoring cancellation and doing work no
+// one waits for, and keeps the wasted durations by method, see Ignored.
+//
+// Each watched request is tagged with a pprof label, so that its goroutines,
+// including those spawned by the handler, can be found in the goroutine profile.
+package xwatchdog
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// LabelKey is the pprof label key of watched requests
+const LabelKey = "jupiter_watchdog"
+
+// Config ...
+type Config struct {
+	// Threshold reports requests running longer than it, disabled if zero
+	Threshold time.Duration
+	// DeadlineFactor reports requests running longer than DeadlineFactor × their deadline budget, disabled if zero
+	DeadlineFactor float64
+	// CancelGrace reports requests running longer than it after their
+	// contexts were canceled or timed out, disabled if zero
+	CancelGrace time.Duration
+	// Interval of checking running requests
+	Interval time.Duration
+
+	logger *xlog.Logger
+	clock  xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Threshold:      time.Second * 10,
+		DeadlineFactor: 2,
+		Interval:       time.Second,
+		logger:         xlog.JupiterLogger.With(xlog.FieldMod("watchdog")),
+		clock:          xtime.SystemClock,
+	}
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *xlog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Watchdog {
+	return &Watchdog{
+		config:   config,
+		requests: make(map[string]*request),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Report is a slow request found by watchdog
+type Report struct {
+	Name    string
+	TraceID string
+	Elapsed time.Duration
+	// Canceled is the time since the context of the request was done, zero
+	// unless the request is reported for ignoring cancellation
+	Canceled time.Duration
+	// Stack of the goroutines labeled with the request
+	Stack string
+}
+
+type request struct {
+	id       string
+	name     string
+	traceID  string
+	start    time.Time
+	deadline time.Time
+	reported bool
+
+	// canceled is when the context was done, finished stops waiting for it
+	canceled       time.Time
+	cancelReported bool
+	finished       chan struct{}
+}
+
+// IgnoredStat is the work of a method done after cancellation
+type IgnoredStat struct {
+	Name  string        `json:"name"`
+	Count int64         `json:"count"`
+	Total time.Duration `json:"total"`
+	Max   time.Duration `json:"max"`
+}
+
+var (
+	ignoredMu sync.Mutex
+	ignored   = make(map[string]*IgnoredStat)
+)
+
+// Ignored returns stats of methods finished longer than CancelGrace after
+// cancellation, sorted by the total wasted duration
+func Ignored() []IgnoredStat {
+	ignoredMu.Lock()
+	var stats = make([]IgnoredStat, 0, len(ignored))
+	for _, stat := range ignored {
+		stats = append(stats, *stat)
+	}
+	ignoredMu.Unlock()
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Total > stats[j].Total })
+	return stats
+}
+
+func recordIgnored(name string, wasted time.Duration) {
+	ignoredMu.Lock()
+	defer ignoredMu.Unlock()
+	stat, ok := ignored[name]
+	if !ok {
+		stat = &IgnoredStat{Name: name}
+		ignored[name] = stat
+	}
+	stat.Count++
+	stat.Total += wasted
+	if wasted > stat.Max {
+		stat.Max = wasted
+	}
+}
+
+// Watchdog ...
+type Watchdog struct {
+	config   *Config
+	seq      uint64
+	mu       sync.Mutex
+	requests map[string]*request
+	once     sync.Once
+	stop     chan struct{}
+}
+
+// Start checks running requests every Interval in background
+func (w *Watchdog) Start() {
+	xgo.Go(func() {
+		ticker := w.config.clock.NewTicker(w.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				for _, report := range w.check() {
+					if report.Canceled > 0 {
+						w.config.logger.Warn("request ignoring cancellation",
+							xlog.FieldName(report.Name),
+							xlog.String("tid", report.TraceID),
+							xlog.FieldCost(report.Elapsed),
+							xlog.Duration("canceled", report.Canceled),
+							xlog.FieldStack([]byte(report.Stack)),
+						)
+						continue
+					}
+					w.config.logger.Warn("slow request",
+						xlog.FieldName(report.Name),
+						xlog.String("tid", report.TraceID),
+						xlog.FieldCost(report.Elapsed),
+						xlog.FieldStack([]byte(report.Stack)),
+					)
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	})
+}
+
+// Stop ...
+func (w *Watchdog) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+// Watch labels the current goroutine with the request and starts watching it,
+// done must be called on the same goroutine once the request finished.
+func (w *Watchdog) Watch(ctx context.Context, name string) (context.Context, func()) {
+	req := &request{
+		id:      strconv.FormatUint(atomic.AddUint64(&w.seq, 1), 10),
+		name:    name,
+		traceID: trace.ExtractTraceID(ctx),
+		start:   w.config.clock.Now(),
+	}
+	req.deadline, _ = ctx.Deadline()
+
+	w.mu.Lock()
+	w.requests[req.id] = req
+	w.mu.Unlock()
+	if w.config.CancelGrace > 0 && ctx.Done() != nil {
+		// started before labeling, so that it's not taken as the request's
+		req.finished = make(chan struct{})
+		go w.waitCanceled(ctx, req)
+	}
+
+	labeled := pprof.WithLabels(ctx, pprof.Labels(LabelKey, req.id))
+	pprof.SetGoroutineLabels(labeled)
+	return labeled, func() {
+		w.mu.Lock()
+		delete(w.requests, req.id)
+		canceled := req.canceled
+		w.mu.Unlock()
+		if req.finished != nil {
+			close(req.finished)
+		}
+		if !canceled.IsZero() {
+			if wasted := w.config.clock.Since(canceled); wasted > w.config.CancelGrace {
+				recordIgnored(req.name, wasted)
+			}
+		}
+		pprof.SetGoroutineLabels(ctx)
+	}
+}
+
+// waitCanceled marks req canceled once ctx is done before it finished
+func (w *Watchdog) waitCanceled(ctx context.Context, req *request) {
+	select {
+	case <-ctx.Done():
+		w.mu.Lock()
+		req.canceled = w.config.clock.Now()
+		w.mu.Unlock()
+	case <-req.finished:
+	}
+}
+
+// check reports each slow request, and each request ignoring cancellation,
+// once
+func (w *Watchdog) check() []Report {
+	var (
+		now      = w.config.clock.Now()
+		slows    = make([]*request, 0)
+		canceled = make(map[*request]time.Duration)
+	)
+	w.mu.Lock()
+	for _, req := range w.requests {
+		if !req.canceled.IsZero() && !req.cancelReported && now.Sub(req.canceled) > w.config.CancelGrace {
+			req.cancelReported = true
+			canceled[req] = now.Sub(req.canceled)
+			slows = append(slows, req)
+			continue
+		}
+		if req.reported {
+			continue
+		}
+		if limit := w.limit(req); limit > 0 && now.Sub(req.start) > limit {
+			req.reported = true
+			slows = append(slows, req)
+		}
+	}
+	w.mu.Unlock()
+
+	if len(slows) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	// debug=1 groups goroutines by stack and prints their labels
+	_ = pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	blocks := strings.Split(buf.String(), "\n\n")
+
+	var reports = make([]Report, 0, len(slows))
+	for _, req := range slows {
+		var stack []string
+		label := strconv.Quote(LabelKey) + ":" + strconv.Quote(req.id)
+		for _, block := range blocks {
+			if strings.Contains(block, label) {
+				stack = append(stack, block)
+			}
+		}
+		reports = append(reports, Report{
+			Name:     req.name,
+			TraceID:  req.traceID,
+			Elapsed:  now.Sub(req.start),
+			Canceled: canceled[req],
+			Stack:    strings.Join(stack, "\n\n"),
+		})
+	}
+	return reports
+}
+
+// limit returns the smaller one of Threshold and DeadlineFactor × deadline budget
+func (w *Watchdog) limit(req *request) time.Duration {
+	limit := w.config.Threshold
+	if w.config.DeadlineFactor > 0 && !req.deadline.IsZero() {
+		budget := time.Duration(float64(req.deadline.Sub(req.start)) * w.config.DeadlineFactor)
+		if budget > 0 && (limit <= 0 || budget < limit) {
+			limit = budget
+		}
+	}
+	return limit
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xwatchdog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+//go:noinline
+func stuckHandler(release, started chan struct{}) {
+	close(started)
+	<-release
+}
+
+func TestWatchdog(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	config := DefaultConfig()
+	config.Threshold = time.Second
+	config.clock = clock
+	w := config.Build()
+
+	release, started, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		_, done := w.Watch(context.Background(), "/demo.Stuck")
+		defer done()
+		stuckHandler(release, started)
+	}()
+	<-started
+
+	assert.Len(t, w.check(), 0)
+
+	clock.Advance(time.Second * 2)
+	reports := w.check()
+	assert.Len(t, reports, 1)
+	assert.Equal(t, "/demo.Stuck", reports[0].Name)
+	assert.Contains(t, reports[0].Stack, "stuckHandler")
+	// reported only once
+	assert.Len(t, w.check(), 0)
+
+	close(release)
+	<-finished
+	assert.Len(t, w.requests, 0)
+}
+
+func TestWatchdogDeadline(t *testing.T) {
+	clock := xtime.NewMockClock(time.Now())
+	config := DefaultConfig()
+	config.clock = clock
+	w := config.Build()
+
+	ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Millisecond*100))
+	defer cancel()
+	_, done := w.Watch(ctx, "/demo.Deadline")
+	defer done()
+
+	clock.Advance(time.Millisecond * 150)
+	assert.Len(t, w.check(), 0)
+	clock.Advance(time.Millisecond * 100)
+	assert.Len(t, w.check(), 1)
+}
+
+func TestWatchdogCancelGrace(t *testing.T) {
+	t.Cleanup(func() {
+		ignoredMu.Lock()
+		delete(ignored, "/demo.IgnoreCancel")
+		ignoredMu.Unlock()
+	})
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	config := DefaultConfig()
+	config.Threshold = 0
+	config.DeadlineFactor = 0
+	config.CancelGrace = time.Second
+	config.clock = clock
+	w := config.Build()
+
+	release, started, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer close(finished)
+		_, done := w.Watch(ctx, "/demo.IgnoreCancel")
+		defer done()
+		stuckHandler(release, started)
+	}()
+	<-started
+	clock.Advance(time.Minute)
+	assert.Len(t, w.check(), 0, "neither slow nor canceled")
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for _, req := range w.requests {
+			return !req.canceled.IsZero()
+		}
+		return false
+	}, time.Second, time.Millisecond)
+	assert.Len(t, w.check(), 0)
+	clock.Advance(time.Second * 2)
+	reports := w.check()
+	assert.Len(t, reports, 1)
+	assert.Equal(t, time.Second*2, reports[0].Canceled)
+	assert.Contains(t, reports[0].Stack, "stuckHandler")
+	assert.NotContains(t, reports[0].Stack, "waitCanceled")
+	assert.Len(t, w.check(), 0)
+
+	clock.Advance(time.Second)
+	close(release)
+	<-finished
+	assert.Contains(t, Ignored(), IgnoredStat{Name: "/demo.IgnoreCancel", Count: 1, Total: time.Second * 3, Max: time.Second * 3})
+
+	// finished in time after cancellation
+	ctx, cancel = context.WithCancel(context.Background())
+	_, done := w.Watch(ctx, "/demo.Cancel")
+	cancel()
+	done()
+	for _, stat := range Ignored() {
+		assert.NotEqual(t, "/demo.Cancel", stat.Name)
+	}
+}
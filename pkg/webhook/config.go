@@ -0,0 +1,124 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("webhook", "jupiter.webhook.*", "webhook sender", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Name of the sender on governor
+	Name string
+	// Secret signs payloads with HMAC-SHA256, unsigned if empty
+	Secret string
+	// Timeout of each attempt
+	Timeout time.Duration
+	// MaxAttempts before dead-lettering, including the first one
+	MaxAttempts int
+	// Backoff between attempts, MaxRetries is ignored
+	Backoff xbackoff.Config
+	// Workers deliver concurrently
+	Workers int
+	// QueueSize of pending deliveries, Send fails with ErrQueueFull if exceeded
+	QueueSize int
+	// LogSize is the number of recent deliveries kept for governor
+	LogSize int
+
+	logger     *xlog.Logger
+	client     *http.Client
+	deadLetter DeadLetter
+	clock      xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	backoff := xbackoff.DefaultConfig()
+	backoff.BaseDelay = time.Second
+	backoff.MaxDelay = 5 * time.Minute
+	backoff.Multiplier = 2
+	return &Config{
+		Name:        "default",
+		Timeout:     5 * time.Second,
+		MaxAttempts: 8,
+		Backoff:     backoff,
+		Workers:     4,
+		QueueSize:   1024,
+		LogSize:     1024,
+		logger:      xlog.JupiterLogger.With(xlog.FieldMod("webhook")),
+		clock:       xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.webhook." + name)
+	if config.Name == "default" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("webhook parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// WithHTTPClient replaces the default http client
+func (config *Config) WithHTTPClient(client *http.Client) *Config {
+	config.client = client
+	return config
+}
+
+// WithDeadLetter saves deliveries failed after all attempts, e.g. to NewGormDeadLetter,
+// otherwise they are only logged
+func (config *Config) WithDeadLetter(deadLetter DeadLetter) *Config {
+	config.deadLetter = deadLetter
+	return config
+}
+
+// Build creates the sender and starts its workers
+func (config *Config) Build() *Sender {
+	if config.client == nil {
+		config.client = &http.Client{Timeout: config.Timeout}
+	}
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 1
+	}
+	return newSender(config)
+}
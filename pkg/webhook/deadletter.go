@@ -0,0 +1,76 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/store/gorm"
+)
+
+// DeadLetter saves deliveries failed after all attempts, for inspection and redelivery
+type DeadLetter interface {
+	Save(ctx context.Context, d *Delivery) error
+}
+
+// DeadLetterRecord is a row of the dead letter table
+type DeadLetterRecord struct {
+	ID         uint      `gorm:"primary_key"`
+	DeliveryID string    `gorm:"type:varchar(32);unique_index"`
+	URL        string    `gorm:"type:varchar(1024)"`
+	Event      string    `gorm:"type:varchar(128);index"`
+	Payload    []byte    `gorm:"type:mediumblob"`
+	Attempts   int       `gorm:"type:int"`
+	LastCode   int       `gorm:"type:int"`
+	LastError  string    `gorm:"type:text"`
+	CreatedAt  time.Time // when the delivery was sent
+	DeadAt     time.Time `gorm:"index"`
+}
+
+// TableName ...
+func (DeadLetterRecord) TableName() string {
+	return "webhook_dead_letters"
+}
+
+// GormDeadLetter saves deliveries to table webhook_dead_letters
+type GormDeadLetter struct {
+	db *gorm.DB
+}
+
+// NewGormDeadLetter ...
+func NewGormDeadLetter(db *gorm.DB) *GormDeadLetter {
+	return &GormDeadLetter{db: db}
+}
+
+// Migrate creates or updates the table
+func (g *GormDeadLetter) Migrate() error {
+	return g.db.AutoMigrate(&DeadLetterRecord{}).Error
+}
+
+// Save ...
+func (g *GormDeadLetter) Save(ctx context.Context, d *Delivery) error {
+	return gorm.WithContext(ctx, g.db).Create(&DeadLetterRecord{
+		DeliveryID: d.ID,
+		URL:        d.URL,
+		Event:      d.Event,
+		Payload:    d.Payload,
+		Attempts:   d.Attempts,
+		LastCode:   d.LastCode,
+		LastError:  d.LastError,
+		CreatedAt:  d.CreatedAt,
+		DeadAt:     d.UpdatedAt,
+	}).Error
+}
@@ -0,0 +1,264 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	xschema.RegisterConfig("webhook.inbound", "jupiter.webhook.inbound.*", "inbound webhook verifier", DefaultInboundConfig)
+}
+
+// Providers of inbound webhooks
+const (
+	// ProviderHMAC signs body with hex HMAC in Header, optionally prefixed with "sha256="
+	ProviderHMAC = "hmac"
+	// ProviderGitHub signs body in X-Hub-Signature-256 as "sha256=<hex>"
+	ProviderGitHub = "github"
+	// ProviderStripe signs "t.body" in Stripe-Signature as "t=<unix>,v1=<hex>",
+	// which is also the format of Sender with Header X-Webhook-Signature
+	ProviderStripe = "stripe"
+)
+
+// ErrReplayed is the error of requests seen before
+var ErrReplayed = errors.New("webhook replayed")
+
+// Rule verifies inbound webhooks of routes with path prefix
+type Rule struct {
+	Path     string
+	Provider string
+	// Secrets are all accepted, so that secrets can be rotated
+	Secrets []string
+	// Header of signature, defaults by provider
+	Header string
+	// Algorithm of ProviderHMAC, sha256 or sha1
+	Algorithm string
+	// Tolerance of timestamp age of ProviderStripe, and the window in which
+	// replayed requests, i.e. of the same signed content, are rejected
+	Tolerance time.Duration
+}
+
+// InboundConfig ...
+type InboundConfig struct {
+	Rules []Rule
+	// MaxBodySize of verified requests in bytes
+	MaxBodySize int64
+	// MaxSeen caps requests remembered against replay
+	MaxSeen int
+
+	logger *xlog.Logger
+	clock  xtime.Clock
+}
+
+// DefaultInboundConfig ...
+func DefaultInboundConfig() *InboundConfig {
+	return &InboundConfig{
+		MaxBodySize: 1 << 20,
+		MaxSeen:     100000,
+		logger:      xlog.JupiterLogger.With(xlog.FieldMod("webhook.inbound")),
+		clock:       xtime.SystemClock,
+	}
+}
+
+// StdInboundConfig ...
+func StdInboundConfig(name string) *InboundConfig {
+	return RawInboundConfig("jupiter.webhook.inbound." + name)
+}
+
+// RawInboundConfig ...
+func RawInboundConfig(key string) *InboundConfig {
+	var config = DefaultInboundConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("webhook inbound parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// Build ...
+func (config *InboundConfig) Build() *Verifier {
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Header == "" {
+			rule.Header = map[string]string{
+				ProviderHMAC:   "X-Signature",
+				ProviderGitHub: "X-Hub-Signature-256",
+				ProviderStripe: "Stripe-Signature",
+			}[rule.Provider]
+		}
+		if rule.Tolerance <= 0 {
+			rule.Tolerance = 5 * time.Minute
+		}
+		if rule.Provider != ProviderHMAC && rule.Provider != ProviderGitHub && rule.Provider != ProviderStripe {
+			config.logger.Panic("unknown webhook provider", xlog.String("path", rule.Path), xlog.String("provider", rule.Provider))
+		}
+		if len(rule.Secrets) == 0 {
+			config.logger.Panic("webhook secrets required", xlog.String("path", rule.Path))
+		}
+	}
+	return &Verifier{config: config, seen: make(map[string]time.Time)}
+}
+
+// Verifier verifies signatures of inbound webhooks
+type Verifier struct {
+	config *InboundConfig
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// Handler verifies requests of routes with rules, rejecting invalid ones with
+// 401 and replayed ones with 409. Echo users can apply it with echo.WrapMiddleware.
+func (v *Verifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := v.rule(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, v.config.MaxBodySize))
+		if err != nil {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		if err := v.Verify(rule, r.Header, body); err != nil {
+			v.config.logger.Warn("webhook rejected", xlog.String("path", r.URL.Path), xlog.String("provider", rule.Provider), xlog.FieldErr(err))
+			if errors.Cause(err) == ErrReplayed {
+				http.Error(w, "webhook replayed", http.StatusConflict)
+				return
+			}
+			http.Error(w, "invalid webhook signature", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rule returns the rule of the longest path prefix
+func (v *Verifier) rule(path string) (Rule, bool) {
+	var matched Rule
+	var length = -1
+	for _, rule := range v.config.Rules {
+		if strings.HasPrefix(path, rule.Path) && len(rule.Path) > length {
+			matched, length = rule, len(rule.Path)
+		}
+	}
+	return matched, length >= 0
+}
+
+// Verify checks the signature of body by rule, then rejects replays
+func (v *Verifier) Verify(rule Rule, header http.Header, body []byte) error {
+	sig := header.Get(rule.Header)
+	if sig == "" {
+		return errors.Wrapf(ErrInvalidSignature, "missing %s", rule.Header)
+	}
+	var err error
+	switch rule.Provider {
+	case ProviderStripe:
+		for _, secret := range rule.Secrets {
+			if err = Verify(secret, sig, body, v.config.clock.Now(), rule.Tolerance); err == nil {
+				break
+			}
+		}
+	case ProviderGitHub:
+		if !strings.HasPrefix(sig, "sha256=") {
+			return errors.Wrap(ErrInvalidSignature, "malformed signature")
+		}
+		err = verifyHMAC(rule.Secrets, sha256.New, strings.TrimPrefix(sig, "sha256="), body)
+	default:
+		newHash, prefix := sha256.New, "sha256="
+		if rule.Algorithm == "sha1" {
+			newHash, prefix = sha1.New, "sha1="
+		}
+		err = verifyHMAC(rule.Secrets, newHash, strings.TrimPrefix(sig, prefix), body)
+	}
+	if err != nil {
+		return err
+	}
+
+	// deliveries are deduplicated by the signed content, since headers out
+	// of it, e.g. delivery ids and extra signatures, are forged freely
+	h := sha256.New()
+	if rule.Provider == ProviderStripe {
+		ts, _ := parseSignature(sig)
+		h.Write([]byte(ts + "."))
+	}
+	h.Write(body)
+	key := rule.Path + "\x00" + hex.EncodeToString(h.Sum(nil))
+	if !v.remember(key, rule.Tolerance) {
+		return errors.Wrapf(ErrReplayed, "within %s", rule.Tolerance)
+	}
+	return nil
+}
+
+func verifyHMAC(secrets []string, newHash func() hash.Hash, sig string, body []byte) error {
+	for _, secret := range secrets {
+		h := hmac.New(newHash, []byte(secret))
+		h.Write(body)
+		if hmac.Equal([]byte(sig), []byte(hex.EncodeToString(h.Sum(nil)))) {
+			return nil
+		}
+	}
+	return errors.Wrap(ErrInvalidSignature, "signature mismatch")
+}
+
+// remember returns false if key was seen within window
+func (v *Verifier) remember(key string, window time.Duration) bool {
+	now := v.config.clock.Now()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if expires, ok := v.seen[key]; ok && now.Before(expires) {
+		return false
+	}
+	if len(v.seen) >= v.config.MaxSeen {
+		for k, expires := range v.seen {
+			if !now.Before(expires) {
+				delete(v.seen, k)
+			}
+		}
+		// still full of fresh keys, forget arbitrary ones rather than grow unbounded
+		for k := range v.seen {
+			if len(v.seen) < v.config.MaxSeen {
+				break
+			}
+			delete(v.seen, k)
+		}
+	}
+	v.seen[key] = now.Add(window)
+	return true
+}
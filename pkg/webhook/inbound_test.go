@@ -0,0 +1,103 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func hexHMAC(secret, body string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func TestVerifier_Handler(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(1600000000, 0))
+	config := DefaultInboundConfig()
+	config.clock = clock
+	config.Rules = []Rule{
+		{Path: "/hooks/github", Provider: ProviderGitHub, Secrets: []string{"old", "gh"}},
+		{Path: "/hooks/stripe", Provider: ProviderStripe, Secrets: []string{"st"}, Tolerance: time.Minute},
+		{Path: "/hooks/generic", Provider: ProviderHMAC, Secrets: []string{"hm"}},
+	}
+	h := config.Build().Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	do := func(path string, header http.Header, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	body := `{"action":"opened"}`
+
+	github := http.Header{
+		"X-Hub-Signature-256": {"sha256=" + hexHMAC("gh", body)},
+		"X-Github-Delivery":   {"d1"},
+	}
+	w := do("/hooks/github", github, body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, body, w.Body.String(), "body is readable by handlers")
+	assert.Equal(t, http.StatusConflict, do("/hooks/github", github, body).Code)
+	github.Set("X-Github-Delivery", "d2")
+	assert.Equal(t, http.StatusConflict, do("/hooks/github", github, body).Code, "ids aren't signed")
+	assert.Equal(t, http.StatusUnauthorized, do("/hooks/github", github, `{"action":"closed"}`).Code)
+
+	stripe := http.Header{"Stripe-Signature": {Sign("st", clock.Now(), []byte(body))}}
+	assert.Equal(t, http.StatusOK, do("/hooks/stripe", stripe, body).Code)
+	assert.Equal(t, http.StatusConflict, do("/hooks/stripe", stripe, body).Code)
+	stripe.Set("Stripe-Signature", stripe.Get("Stripe-Signature")+",v1=forged")
+	assert.Equal(t, http.StatusConflict, do("/hooks/stripe", stripe, body).Code, "extra signatures aren't signed")
+	clock.Advance(2 * time.Minute)
+	assert.Equal(t, http.StatusUnauthorized, do("/hooks/stripe", stripe, body).Code, "too old")
+
+	generic := http.Header{"X-Signature": {hexHMAC("hm", body)}}
+	assert.Equal(t, http.StatusOK, do("/hooks/generic/a", generic, body).Code)
+	assert.Equal(t, http.StatusUnauthorized, do("/hooks/generic/a", nil, body).Code)
+
+	assert.Equal(t, http.StatusOK, do("/other", nil, body).Code, "routes without rules")
+}
+
+func TestVerifier_Remember(t *testing.T) {
+	clock := xtime.NewMockClock(time.Unix(1600000000, 0))
+	config := DefaultInboundConfig()
+	config.clock = clock
+	config.MaxSeen = 2
+	v := config.Build()
+
+	assert.True(t, v.remember("a", time.Minute))
+	assert.False(t, v.remember("a", time.Minute))
+	assert.True(t, v.remember("b", time.Minute))
+	clock.Advance(time.Minute)
+	assert.True(t, v.remember("a", time.Minute), "expired")
+	assert.True(t, v.remember("c", time.Minute))
+	assert.LessOrEqual(t, len(v.seen), 2)
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+)
+
+// Filter selects deliveries, zero value fields match all
+type Filter struct {
+	ID     string
+	Event  string
+	Status string
+	// Limit caps the number of deliveries returned
+	Limit int
+}
+
+func (f Filter) match(d *Delivery) bool {
+	return (f.ID == "" || d.ID == f.ID) &&
+		(f.Event == "" || d.Event == f.Event) &&
+		(f.Status == "" || d.Status == f.Status)
+}
+
+// deliveryLog is a ring of recent deliveries, which are updated in place
+type deliveryLog struct {
+	mu         sync.Mutex
+	deliveries []*Delivery
+	next       int
+}
+
+func newDeliveryLog(size int) *deliveryLog {
+	if size <= 0 {
+		size = 1024
+	}
+	return &deliveryLog{deliveries: make([]*Delivery, size)}
+}
+
+func (l *deliveryLog) add(d *Delivery) {
+	l.mu.Lock()
+	l.deliveries[l.next] = d
+	l.next = (l.next + 1) % len(l.deliveries)
+	l.mu.Unlock()
+}
+
+// update mutates deliveries under the lock of log
+func (l *deliveryLog) update(fn func()) {
+	l.mu.Lock()
+	fn()
+	l.mu.Unlock()
+}
+
+func (l *deliveryLog) snapshot(d *Delivery) Delivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return *d
+}
+
+func (l *deliveryLog) query(filter Filter) []Delivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ret = make([]Delivery, 0)
+	for i := 1; i <= len(l.deliveries); i++ {
+		d := l.deliveries[(l.next-i+len(l.deliveries))%len(l.deliveries)]
+		if d == nil {
+			break
+		}
+		if !filter.match(d) {
+			continue
+		}
+		ret = append(ret, *d)
+		if filter.Limit > 0 && len(ret) >= filter.Limit {
+			break
+		}
+	}
+	return ret
+}
+
+var senders sync.Map
+
+func register(name string, s *Sender) {
+	senders.Store(name, s)
+}
+
+func unregister(name string, s *Sender) {
+	if v, ok := senders.Load(name); ok && v == s {
+		senders.Delete(name)
+	}
+}
+
+func init() {
+	// e.g. /debug/webhooks?name=order&status=dead&event=order.paid&limit=50
+	governor.HandleFunc("/debug/webhooks", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := Filter{
+			ID:     query.Get("id"),
+			Event:  query.Get("event"),
+			Status: query.Get("status"),
+		}
+		filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+		var ret = make(map[string][]Delivery)
+		senders.Range(func(key, value interface{}) bool {
+			if name := query.Get("name"); name == "" || name == key.(string) {
+				ret[key.(string)] = value.(*Sender).Query(filter)
+			}
+			return true
+		})
+		encoder := json.NewEncoder(w)
+		if query.Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(ret)
+	})
+}
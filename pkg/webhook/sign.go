@@ -0,0 +1,90 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Headers of deliveries
+const (
+	// HeaderSignature is "t=<unix seconds>,v1=<hex hmac-sha256 of 't.body'>"
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderID        = "X-Webhook-ID"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderAttempt   = "X-Webhook-Attempt"
+)
+
+// ErrInvalidSignature is returned by Verify
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the signature header of body at t
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+// Verify checks the signature header of body for receivers, signatures older
+// than tolerance are rejected against replay, no check of age if tolerance is zero
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	ts, sigs := parseSignature(header)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return errors.Wrap(ErrInvalidSignature, "malformed header")
+	}
+	if age := now.Sub(time.Unix(sec, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return errors.Wrapf(ErrInvalidSignature, "timestamp out of tolerance %s", tolerance)
+	}
+	expected := mac(secret, ts, body)
+	for _, sig := range sigs {
+		// a header may carry signatures of several secrets during rotation
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return errors.Wrap(ErrInvalidSignature, "signature mismatch")
+}
+
+// parseSignature returns the timestamp and signatures of the header
+func parseSignature(header string) (ts string, sigs []string) {
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			sigs = append(sigs, kv[1])
+		}
+	}
+	return ts, sigs
+}
+
+func mac(secret, ts string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
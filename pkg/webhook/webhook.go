@@ -0,0 +1,318 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook delivers outbound webhooks reliably: payloads are signed
+// with HMAC-SHA256, failed attempts are retried with exponential backoff up
+// to MaxAttempts, then saved to the dead letter. Recent deliveries can be
+// queried on governor /debug/webhooks. Verifier checks signatures of
+// inbound webhooks from common providers.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrQueueFull is returned by Send if QueueSize deliveries are pending
+	ErrQueueFull = errors.New("webhook queue full")
+	// ErrClosed is returned by Send after Close
+	ErrClosed = errors.New("webhook sender closed")
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusRetrying  = "retrying"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+var deliveryCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "webhook_delivery_total",
+	Labels:    []string{"name", "event", "status"},
+}.Build()
+
+var attemptHistogram = metric.HistogramVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "webhook_attempt_seconds",
+	Labels:    []string{"name", "event"},
+}.Build()
+
+// Delivery is a webhook to deliver
+type Delivery struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Event     string    `json:"event"`
+	Payload   []byte    `json:"-"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastCode  int       `json:"lastCode,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type retry struct {
+	timer    xtime.ClockTimer
+	delivery *Delivery
+}
+
+// Sender ...
+type Sender struct {
+	config *Config
+	logger *xlog.Logger
+	queue  chan *Delivery
+	log    *deliveryLog
+
+	mu      sync.Mutex
+	closed  bool
+	retries map[string]*retry
+	// inflight counts deliveries queued or being attempted
+	inflight sync.WaitGroup
+	workers  sync.WaitGroup
+}
+
+func newSender(config *Config) *Sender {
+	s := &Sender{
+		config:  config,
+		logger:  config.logger.With(xlog.FieldName(config.Name)),
+		queue:   make(chan *Delivery, config.QueueSize),
+		log:     newDeliveryLog(config.LogSize),
+		retries: make(map[string]*retry),
+	}
+	for i := 0; i < config.Workers; i++ {
+		s.workers.Add(1)
+		xgo.Go(func() {
+			defer s.workers.Done()
+			for d := range s.queue {
+				s.attempt(d)
+				s.inflight.Done()
+			}
+		})
+	}
+	register(config.Name, s)
+	return s
+}
+
+// Send queues payload of event to url and returns the delivery id
+func (s *Sender) Send(url, event string, payload []byte) (string, error) {
+	now := s.config.clock.Now()
+	d := &Delivery{
+		ID:        newID(),
+		URL:       url,
+		Event:     event,
+		Payload:   payload,
+		Status:    StatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.enqueue(d); err != nil {
+		return "", err
+	}
+	s.log.add(d)
+	return d.ID, nil
+}
+
+func (s *Sender) enqueue(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.inflight.Add(1)
+	select {
+	case s.queue <- d:
+		return nil
+	default:
+		s.inflight.Done()
+		return ErrQueueFull
+	}
+}
+
+// attempt delivers d once, and schedules a retry or dead-letters it on failure
+func (s *Sender) attempt(d *Delivery) {
+	beg := s.config.clock.Now()
+	code, err := s.post(d)
+	attemptHistogram.Observe(s.config.clock.Since(beg).Seconds(), s.config.Name, d.Event)
+
+	var status string
+	var retry bool
+	s.log.update(func() {
+		d.Attempts++
+		d.LastCode = code
+		d.LastError = ""
+		if err != nil {
+			d.LastError = err.Error()
+		}
+		retry = err != nil && retryable(code) && d.Attempts < s.config.MaxAttempts
+		switch {
+		case err == nil:
+			d.Status = StatusSucceeded
+		case retry:
+			d.Status = StatusRetrying
+		default:
+			d.Status = StatusDead
+		}
+		d.UpdatedAt = s.config.clock.Now()
+		status = d.Status
+	})
+
+	switch status {
+	case StatusSucceeded:
+		deliveryCounter.Inc(s.config.Name, d.Event, StatusSucceeded)
+	case StatusRetrying:
+		s.scheduleRetry(d)
+	case StatusDead:
+		s.dead(d)
+	}
+}
+
+func (s *Sender) scheduleRetry(d *Delivery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		s.markDead(d, ErrClosed)
+		return
+	}
+	delay := s.config.Backoff.Backoff(d.Attempts - 1)
+	s.inflight.Add(1)
+	s.retries[d.ID] = &retry{delivery: d}
+	s.retries[d.ID].timer = s.config.clock.AfterFunc(delay, func() {
+		s.mu.Lock()
+		delete(s.retries, d.ID)
+		s.mu.Unlock()
+		// the queue may be full, block rather than drop
+		s.queue <- d
+	})
+}
+
+// markDead dead-letters d which won't be retried because of err
+func (s *Sender) markDead(d *Delivery, err error) {
+	s.log.update(func() {
+		d.Status = StatusDead
+		d.LastError = err.Error()
+		d.UpdatedAt = s.config.clock.Now()
+	})
+	xgo.Go(func() { s.dead(d) })
+}
+
+func (s *Sender) dead(d *Delivery) {
+	deliveryCounter.Inc(s.config.Name, d.Event, StatusDead)
+	snapshot := s.log.snapshot(d)
+	snapshot.Payload = d.Payload
+	s.logger.Error("webhook dead",
+		xlog.String("id", snapshot.ID), xlog.String("url", snapshot.URL), xlog.String("event", snapshot.Event),
+		xlog.Int("attempts", snapshot.Attempts), xlog.String("err", snapshot.LastError),
+	)
+	if s.config.deadLetter == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+	defer cancel()
+	if err := s.config.deadLetter.Save(ctx, &snapshot); err != nil {
+		s.logger.Error("save dead letter", xlog.String("id", snapshot.ID), xlog.FieldErr(err))
+	}
+}
+
+func (s *Sender) post(d *Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderID, d.ID)
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderAttempt, strconv.Itoa(d.Attempts+1))
+	if s.config.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(s.config.Secret, s.config.clock.Now(), d.Payload))
+	}
+
+	resp, err := s.config.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain for connection reuse
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether failures of code may succeed later, 0 means network errors
+func retryable(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// Query returns recent deliveries matching the filter, newest first
+func (s *Sender) Query(filter Filter) []Delivery {
+	return s.log.query(filter)
+}
+
+// Close stops accepting deliveries and waits for queued ones until ctx is
+// done, pending retries are dead-lettered immediately
+func (s *Sender) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for id, r := range s.retries {
+		if r.timer.Stop() {
+			delete(s.retries, id)
+			s.markDead(r.delivery, ErrClosed)
+			s.inflight.Done()
+		}
+	}
+	s.mu.Unlock()
+	unregister(s.config.Name, s)
+
+	done := make(chan struct{})
+	xgo.Go(func() {
+		s.inflight.Wait()
+		close(s.queue)
+		s.workers.Wait()
+		close(done)
+	})
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func newID() string {
+	var id = make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryDeadLetter struct {
+	mu         sync.Mutex
+	deliveries []*Delivery
+}
+
+func (m *memoryDeadLetter) Save(ctx context.Context, d *Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries = append(m.deliveries, d)
+	return nil
+}
+
+func (m *memoryDeadLetter) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.deliveries)
+}
+
+func newTestSender(clock xtime.Clock, deadLetter DeadLetter) *Sender {
+	config := DefaultConfig()
+	config.Name = "test"
+	config.Secret = "secret"
+	config.MaxAttempts = 3
+	config.Backoff.Jitter = 0
+	config.clock = clock
+	return config.WithDeadLetter(deadLetter).Build()
+}
+
+// waitStatus waits for the delivery to reach status
+func waitStatus(t *testing.T, s *Sender, id, status string) Delivery {
+	var d Delivery
+	assert.Eventually(t, func() bool {
+		ds := s.Query(Filter{ID: id})
+		if len(ds) == 1 {
+			d = ds[0]
+		}
+		return d.Status == status
+	}, time.Second, time.Millisecond, "want %s", status)
+	return d
+}
+
+func TestSender_Deliver(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Nil(t, Verify("secret", r.Header.Get(HeaderSignature), body, time.Now(), time.Minute))
+		assert.Equal(t, "order.paid", r.Header.Get(HeaderEvent))
+		assert.Equal(t, `{"id":1}`, string(body))
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	clock := xtime.NewMockClock(time.Now())
+	deadLetter := &memoryDeadLetter{}
+	s := newTestSender(clock, deadLetter)
+	id, err := s.Send(ts.URL, "order.paid", []byte(`{"id":1}`))
+	assert.Nil(t, err)
+
+	d := waitStatus(t, s, id, StatusRetrying)
+	assert.Equal(t, http.StatusServiceUnavailable, d.LastCode)
+	clock.Advance(time.Second)
+	d = waitStatus(t, s, id, StatusSucceeded)
+	assert.Equal(t, 2, d.Attempts)
+	assert.Equal(t, 0, deadLetter.len())
+	assert.Nil(t, s.Close(context.Background()))
+
+	_, err = s.Send(ts.URL, "order.paid", nil)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestSender_DeadLetter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderEvent) == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	clock := xtime.NewMockClock(time.Now())
+	deadLetter := &memoryDeadLetter{}
+	s := newTestSender(clock, deadLetter)
+
+	// not retried on client errors
+	id, _ := s.Send(ts.URL, "bad", nil)
+	d := waitStatus(t, s, id, StatusDead)
+	assert.Equal(t, 1, d.Attempts)
+
+	id, _ = s.Send(ts.URL, "flaky", []byte("x"))
+	waitStatus(t, s, id, StatusRetrying)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return s.Query(Filter{ID: id})[0].Attempts == 2 }, time.Second, time.Millisecond)
+	clock.Advance(2 * time.Second)
+	d = waitStatus(t, s, id, StatusDead)
+	assert.Equal(t, 3, d.Attempts)
+
+	assert.Eventually(t, func() bool { return deadLetter.len() == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []byte("x"), deadLetter.deliveries[1].Payload)
+
+	// pending retries are dead-lettered on close
+	id, _ = s.Send(ts.URL, "flaky", nil)
+	waitStatus(t, s, id, StatusRetrying)
+	assert.Nil(t, s.Close(context.Background()))
+	d = waitStatus(t, s, id, StatusDead)
+	assert.Equal(t, ErrClosed.Error(), d.LastError)
+	assert.Eventually(t, func() bool { return deadLetter.len() == 3 }, time.Second, time.Millisecond)
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	body := []byte(`{"id":1}`)
+	header := Sign("secret", now, body)
+
+	assert.Nil(t, Verify("secret", header, body, now.Add(time.Minute), 5*time.Minute))
+	// signatures of rotated secrets
+	assert.Nil(t, Verify("secret", Sign("old", now, body)+","+header[len("t=1600000000,"):], body, now, 0))
+
+	for _, err := range []error{
+		Verify("other", header, body, now, 0),
+		Verify("secret", header, []byte(`{"id":2}`), now, 0),
+		Verify("secret", header, body, now.Add(time.Hour), 5*time.Minute),
+		Verify("secret", "v1=abc", body, now, 0),
+	} {
+		assert.Equal(t, ErrInvalidSignature, errors.Cause(err))
+	}
+}
@@ -15,6 +15,7 @@
 package xcron
 
 import (
+	"context"
 	"fmt"
 	"runtime"
 	"time"
@@ -24,13 +25,21 @@ import (
 	"github.com/douyu/jupiter/pkg/ecode"
 
 	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/opentracing/opentracing-go/ext"
 	"go.uber.org/zap"
 
 	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/util/xtime"
 	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
 	"github.com/robfig/cron/v3"
 )
 
+func init() {
+	xschema.RegisterConfig("cron", "jupiter.cron.*", "cron worker", DefaultConfig)
+}
+
 // StdConfig ...
 func StdConfig(name string) Config {
 	return RawConfig("jupiter.cron." + name)
@@ -54,6 +63,7 @@ func RawConfig(key string) Config {
 func DefaultConfig() Config {
 	return Config{
 		logger:          xlog.JupiterLogger,
+		clock:           xtime.SystemClock,
 		wrappers:        []JobWrapper{},
 		WithSeconds:     false,
 		ImmediatelyRun:  false,
@@ -70,6 +80,7 @@ type Config struct {
 	wrappers []JobWrapper
 	logger   *xlog.Logger
 	parser   cron.Parser
+	clock    xtime.Clock
 
 	// Distributed task
 	DistributedTask bool
@@ -94,6 +105,12 @@ func (config *Config) WithLogger(logger *xlog.Logger) Config {
 	return *config
 }
 
+// WithClock sets the clock driving the schedules and timing jobs and delays
+func (config *Config) WithClock(clock xtime.Clock) Config {
+	config.clock = clock
+	return *config
+}
+
 // WithParser ...
 func (config *Config) WithParser(parser Parser) Config {
 	config.parser = parser
@@ -106,8 +123,12 @@ func (config Config) Build() *Cron {
 		config.parser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
 	}
 
+	if config.clock == nil {
+		config.clock = xtime.SystemClock
+	}
+
 	if config.ConcurrentDelay > 0 { // 延迟
-		config.wrappers = append(config.wrappers, delayIfStillRunning(config.logger))
+		config.wrappers = append(config.wrappers, delayIfStillRunning(config.logger, config.clock))
 	} else if config.ConcurrentDelay < 0 { // 跳过
 		config.wrappers = append(config.wrappers, skipIfStillRunning(config.logger))
 	} else {
@@ -154,6 +175,7 @@ func (wl *wrappedLogger) Error(err error, msg string, keysAndValues ...interface
 type wrappedJob struct {
 	NamedJob
 	logger *xlog.Logger
+	clock  xtime.Clock
 
 	distributedTask bool
 	waitLockTime    time.Duration
@@ -193,7 +215,18 @@ func (wj wrappedJob) Run() {
 func (wj wrappedJob) run() (err error) {
 	metric.JobHandleCounter.Inc("cron", wj.Name(), "begin")
 	var fields = []xlog.Field{zap.String("name", wj.Name())}
-	var beg = time.Now()
+	var beg = wj.clock.Now()
+	// each run is the root of a trace, client calls of ContextJob are its children
+	span, ctx := trace.StartRootSpan(context.Background(), "cron "+wj.Name(),
+		trace.TagComponent("cron"),
+		trace.CustomTag("job", wj.Name()),
+	)
+	defer func() {
+		if err != nil {
+			ext.Error.Set(span, true)
+		}
+		span.Finish()
+	}()
 	defer func() {
 		if rec := recover(); rec != nil {
 			switch rec := rec.(type) {
@@ -208,13 +241,16 @@ func (wj wrappedJob) run() (err error) {
 			fields = append(fields, zap.ByteString("stack", stack[:length]))
 		}
 		if err != nil {
-			fields = append(fields, xlog.String("err", err.Error()), xlog.Duration("cost", time.Since(beg)))
+			fields = append(fields, xlog.String("err", err.Error()), xlog.Duration("cost", wj.clock.Since(beg)))
 			wj.logger.Error("run", fields...)
 		} else {
 			wj.logger.Info("run", fields...)
 		}
-		metric.JobHandleHistogram.Observe(time.Since(beg).Seconds(), "cron", wj.Name())
+		metric.JobHandleHistogram.Observe(wj.clock.Since(beg).Seconds(), "cron", wj.Name())
 	}()
 
+	if job, ok := wj.NamedJob.(ContextJob); ok {
+		return job.RunContext(ctx)
+	}
 	return wj.NamedJob.Run()
 }
@@ -15,10 +15,12 @@
 package xcron
 
 import (
+	"context"
 	"sync/atomic"
 	"time"
 
 	"github.com/douyu/jupiter/pkg/util/xstring"
+	"github.com/douyu/jupiter/pkg/util/xtime"
 	"github.com/douyu/jupiter/pkg/xlog"
 	"github.com/robfig/cron/v3"
 )
@@ -58,6 +60,13 @@ type (
 		Run() error
 		Name() string
 	}
+	// ContextJob is run with the context of the root span of each run
+	// instead of Run, pass ctx to client calls so that they're traced as
+	// children of the run
+	ContextJob interface {
+		NamedJob
+		RunContext(ctx context.Context) error
+	}
 )
 
 // FuncJob ...
@@ -69,10 +78,23 @@ func (f FuncJob) Run() error { return f() }
 // Name ...
 func (f FuncJob) Name() string { return xstring.FunctionName(f) }
 
-// Cron ...
+// ContextFuncJob ...
+type ContextFuncJob func(ctx context.Context) error
+
+// Run ...
+func (f ContextFuncJob) Run() error { return f(context.Background()) }
+
+// RunContext ...
+func (f ContextFuncJob) RunContext(ctx context.Context) error { return f(ctx) }
+
+// Name ...
+func (f ContextFuncJob) Name() string { return xstring.FunctionName(f) }
+
+// Cron runs jobs on their schedules, timers and the current time of the
+// schedules come from the clock of the config
 type Cron struct {
 	*Config
-	*cron.Cron
+	*scheduler
 	entries map[string]EntryID
 }
 
@@ -80,14 +102,13 @@ func newCron(config *Config) *Cron {
 	if config.logger == nil {
 		config.logger = xlog.JupiterLogger
 	}
+	if config.clock == nil {
+		config.clock = xtime.SystemClock
+	}
 	config.logger = config.logger.With(xlog.FieldMod("worker.cron"))
 	cron := &Cron{
-		Config: config,
-		Cron: cron.New(
-			cron.WithParser(config.parser),
-			cron.WithChain(config.wrappers...),
-			cron.WithLogger(&wrappedLogger{config.logger}),
-		),
+		Config:    config,
+		scheduler: newScheduler(config.clock, &wrappedLogger{config.logger}, config.wrappers...),
 	}
 	return cron
 }
@@ -102,6 +123,7 @@ func (c *Cron) Schedule(schedule Schedule, job NamedJob) EntryID {
 	innnerJob := &wrappedJob{
 		NamedJob: job,
 		logger:   c.logger,
+		clock:    c.clock,
 
 		distributedTask: c.DistributedTask,
 		waitLockTime:    c.WaitLockTime,
@@ -110,7 +132,7 @@ func (c *Cron) Schedule(schedule Schedule, job NamedJob) EntryID {
 	}
 	// xdebug.PrintKVWithPrefix("worker", "add job", job.Name())
 	c.logger.Info("add job", xlog.String("name", job.Name()))
-	return c.Cron.Schedule(schedule, innnerJob)
+	return c.scheduler.Schedule(schedule, innnerJob)
 }
 
 // GetEntryByName ...
@@ -133,17 +155,22 @@ func (c *Cron) AddFunc(spec string, cmd func() error) (EntryID, error) {
 	return c.AddJob(spec, FuncJob(cmd))
 }
 
+// AddContextFunc adds cmd run with the context of the root span of each run
+func (c *Cron) AddContextFunc(spec string, cmd func(ctx context.Context) error) (EntryID, error) {
+	return c.AddJob(spec, ContextFuncJob(cmd))
+}
+
 // Run ...
 func (c *Cron) Run() error {
-	// xdebug.PrintKVWithPrefix("worker", "run worker", fmt.Sprintf("%d job scheduled", len(c.Cron.Entries())))
-	c.logger.Info("run worker", xlog.Int("number of scheduled jobs", len(c.Cron.Entries())))
-	c.Cron.Run()
+	// xdebug.PrintKVWithPrefix("worker", "run worker", fmt.Sprintf("%d job scheduled", len(c.scheduler.Entries())))
+	c.logger.Info("run worker", xlog.Int("number of scheduled jobs", len(c.scheduler.Entries())))
+	c.scheduler.Run()
 	return nil
 }
 
 // Stop ...
 func (c *Cron) Stop() error {
-	_ = c.Cron.Stop()
+	_ = c.scheduler.Stop()
 	return nil
 }
 
@@ -0,0 +1,89 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcron
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+type namedJob struct {
+	name string
+	ran  bool
+}
+
+func (j *namedJob) Run() error   { j.ran = true; return nil }
+func (j *namedJob) Name() string { return j.name }
+
+func TestWrappedJobTrace(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	plain := &namedJob{name: "plain"}
+	assert.Nil(t, wrappedJob{NamedJob: plain, logger: xlog.JupiterLogger, clock: xtime.SystemClock}.run())
+	assert.True(t, plain.ran)
+	spans := tracer.FinishedSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "cron plain", spans[0].OperationName)
+	assert.Equal(t, 0, spans[0].ParentID)
+	assert.Equal(t, "plain", spans[0].Tag("job"))
+
+	tracer.Reset()
+	var jobCtx context.Context
+	job := ContextFuncJob(func(ctx context.Context) error {
+		jobCtx = ctx
+		span, _ := opentracing.StartSpanFromContext(ctx, "call")
+		span.Finish()
+		return errors.New("failed")
+	})
+	err := wrappedJob{NamedJob: job, logger: xlog.JupiterLogger, clock: xtime.SystemClock}.run()
+	assert.EqualError(t, err, "failed")
+	spans = tracer.FinishedSpans()
+	assert.Len(t, spans, 2)
+	call, root := spans[0], spans[1]
+	assert.Equal(t, 0, root.ParentID)
+	assert.Equal(t, true, root.Tag("error"))
+	assert.Equal(t, root, opentracing.SpanFromContext(jobCtx))
+	assert.Equal(t, root.SpanContext.SpanID, call.ParentID)
+}
+
+func TestCron_Clock(t *testing.T) {
+	clock := xtime.NewMockClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local))
+	config := DefaultConfig()
+	cron := config.WithClock(clock).Build()
+	var runs = make(chan time.Time, 10)
+	cron.Schedule(Every(time.Minute), FuncJob(func() error {
+		runs <- clock.Now()
+		return nil
+	}))
+	go func() { _ = cron.Run() }()
+	defer cron.Stop()
+
+	for i := 1; i <= 3; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+		assert.Equal(t, time.Date(2020, 1, 1, 0, i, 0, 0, time.Local), <-runs)
+	}
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 4, 0, 0, time.Local), cron.Entries()[0].Next)
+}
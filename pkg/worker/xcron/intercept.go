@@ -18,6 +18,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/douyu/jupiter/pkg/util/xtime"
 	"github.com/douyu/jupiter/pkg/xlog"
 	"github.com/robfig/cron/v3"
 )
@@ -25,14 +26,14 @@ import (
 // delayIfStillRunning serializes jobs, delaying subsequent runs until the
 // previous one is complete. Jobs running after a delay of more than a minute
 // have the delay logged at Info.
-func delayIfStillRunning(logger *xlog.Logger) JobWrapper {
+func delayIfStillRunning(logger *xlog.Logger, clock xtime.Clock) JobWrapper {
 	return func(j Job) Job {
 		var mu sync.Mutex
 		return cron.FuncJob(func() {
-			start := time.Now()
+			start := clock.Now()
 			mu.Lock()
 			defer mu.Unlock()
-			if dur := time.Since(start); dur > time.Minute {
+			if dur := clock.Since(start); dur > time.Minute {
 				logger.Info("cron delay", xlog.String("duration", dur.String()))
 			}
 			j.Run()
@@ -0,0 +1,267 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcron
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/robfig/cron/v3"
+)
+
+// scheduler runs jobs on their schedules like cron.Cron, but timers and the
+// current time come from clock, so that tests can advance a MockClock to run
+// jobs deterministically
+type scheduler struct {
+	timeSource xtime.Clock
+	chain      cron.Chain
+	cronLogger cron.Logger
+	location   *time.Location
+
+	entries  []*cron.Entry
+	stop     chan struct{}
+	add      chan *cron.Entry
+	remove   chan EntryID
+	snapshot chan chan []Entry
+
+	runningMu sync.Mutex
+	running   bool
+	nextID    EntryID
+	jobWaiter sync.WaitGroup
+}
+
+func newScheduler(clock xtime.Clock, logger cron.Logger, wrappers ...JobWrapper) *scheduler {
+	return &scheduler{
+		timeSource: clock,
+		chain:      cron.NewChain(wrappers...),
+		cronLogger: logger,
+		location:   time.Local,
+		stop:       make(chan struct{}),
+		add:        make(chan *cron.Entry),
+		remove:     make(chan EntryID),
+		snapshot:   make(chan chan []Entry),
+	}
+}
+
+// Schedule adds job run on schedule, it's run from the next time of schedule
+// after now if the scheduler is running
+func (s *scheduler) Schedule(schedule Schedule, job Job) EntryID {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	s.nextID++
+	entry := &cron.Entry{
+		ID:         s.nextID,
+		Schedule:   schedule,
+		WrappedJob: s.chain.Then(job),
+		Job:        job,
+	}
+	if !s.running {
+		s.entries = append(s.entries, entry)
+	} else {
+		s.add <- entry
+	}
+	return entry.ID
+}
+
+// Entries returns a snapshot of the entries
+func (s *scheduler) Entries() []Entry {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	if s.running {
+		reply := make(chan []Entry, 1)
+		s.snapshot <- reply
+		return <-reply
+	}
+	return s.entrySnapshot()
+}
+
+// Location returns the time zone of schedules
+func (s *scheduler) Location() *time.Location {
+	return s.location
+}
+
+// Entry returns a snapshot of the entry of id, it's invalid if it's not found
+func (s *scheduler) Entry(id EntryID) Entry {
+	for _, entry := range s.Entries() {
+		if id == entry.ID {
+			return entry
+		}
+	}
+	return Entry{}
+}
+
+// Remove removes the entry of id from being run in the future
+func (s *scheduler) Remove(id EntryID) {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	if s.running {
+		s.remove <- id
+	} else {
+		s.removeEntry(id)
+	}
+}
+
+// Start runs the scheduler in its own goroutine, it's no-op if it's running
+func (s *scheduler) Start() {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	if s.running {
+		return
+	}
+	s.running = true
+	go s.run()
+}
+
+// Run runs the scheduler, it's no-op if it's running
+func (s *scheduler) Run() {
+	s.runningMu.Lock()
+	if s.running {
+		s.runningMu.Unlock()
+		return
+	}
+	s.running = true
+	s.runningMu.Unlock()
+	s.run()
+}
+
+// Stop stops the scheduler if it's running, running jobs aren't stopped, the
+// returned context is done once they complete
+func (s *scheduler) Stop() context.Context {
+	s.runningMu.Lock()
+	defer s.runningMu.Unlock()
+	if s.running {
+		s.stop <- struct{}{}
+		s.running = false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		s.jobWaiter.Wait()
+		cancel()
+	}()
+	return ctx
+}
+
+func (s *scheduler) run() {
+	s.cronLogger.Info("start")
+
+	now := s.now()
+	for _, entry := range s.entries {
+		entry.Next = entry.Schedule.Next(now)
+		s.cronLogger.Info("schedule", "now", now, "entry", entry.ID, "next", entry.Next)
+	}
+
+	for {
+		sort.Slice(s.entries, func(i, j int) bool {
+			if s.entries[i].Next.IsZero() {
+				return false
+			}
+			if s.entries[j].Next.IsZero() {
+				return true
+			}
+			return s.entries[i].Next.Before(s.entries[j].Next)
+		})
+
+		// nil if nothing is scheduled
+		var fired chan struct{}
+		var timer xtime.ClockTimer
+		if len(s.entries) > 0 && !s.entries[0].Next.IsZero() {
+			fired = make(chan struct{})
+			if d := s.entries[0].Next.Sub(now); d > 0 {
+				timer = s.timeSource.AfterFunc(d, func() { close(fired) })
+			} else {
+				close(fired)
+			}
+		}
+		stopTimer := func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+
+		for {
+			select {
+			case <-fired:
+				now = s.now()
+				s.cronLogger.Info("wake", "now", now)
+				for _, e := range s.entries {
+					if e.Next.After(now) || e.Next.IsZero() {
+						break
+					}
+					s.startJob(e.WrappedJob)
+					e.Prev = e.Next
+					e.Next = e.Schedule.Next(now)
+					s.cronLogger.Info("run", "now", now, "entry", e.ID, "next", e.Next)
+				}
+
+			case entry := <-s.add:
+				stopTimer()
+				now = s.now()
+				entry.Next = entry.Schedule.Next(now)
+				s.entries = append(s.entries, entry)
+				s.cronLogger.Info("added", "now", now, "entry", entry.ID, "next", entry.Next)
+
+			case reply := <-s.snapshot:
+				reply <- s.entrySnapshot()
+				continue
+
+			case <-s.stop:
+				stopTimer()
+				s.cronLogger.Info("stop")
+				return
+
+			case id := <-s.remove:
+				stopTimer()
+				now = s.now()
+				s.removeEntry(id)
+				s.cronLogger.Info("removed", "entry", id)
+			}
+
+			break
+		}
+	}
+}
+
+func (s *scheduler) startJob(job Job) {
+	s.jobWaiter.Add(1)
+	go func() {
+		defer s.jobWaiter.Done()
+		job.Run()
+	}()
+}
+
+func (s *scheduler) now() time.Time {
+	return s.timeSource.Now().In(s.location)
+}
+
+func (s *scheduler) entrySnapshot() []Entry {
+	var entries = make([]Entry, len(s.entries))
+	for i, e := range s.entries {
+		entries[i] = *e
+	}
+	return entries
+}
+
+func (s *scheduler) removeEntry(id EntryID) {
+	var entries []*cron.Entry
+	for _, e := range s.entries {
+		if e.ID != id {
+			entries = append(entries, e)
+		}
+	}
+	s.entries = entries
+}
@@ -1,7 +1,10 @@
 package job
 
 import (
+	"context"
+
 	"github.com/douyu/jupiter/pkg/flag"
+	"github.com/douyu/jupiter/pkg/trace"
 )
 
 func init() {
@@ -18,3 +21,23 @@ func init() {
 type Runner interface {
 	Run()
 }
+
+// ContextRunner is run with the context of the root span of the job
+// instead of Run, pass ctx to client calls so that they're traced as
+// children of the job
+type ContextRunner interface {
+	Runner
+	RunContext(ctx context.Context)
+}
+
+// Run runs runner in the root span of job name, which is the root of a
+// trace, client calls of ContextRunner are its children
+func Run(name string, runner Runner) {
+	span, ctx := trace.StartRootSpan(context.Background(), "job "+name, trace.TagComponent("job"))
+	defer span.Finish()
+	if r, ok := runner.(ContextRunner); ok {
+		r.RunContext(ctx)
+		return
+	}
+	runner.Run()
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRunner struct {
+	ran bool
+}
+
+func (r *testRunner) Run() { r.ran = true }
+
+type testContextRunner struct {
+	testRunner
+	ctx context.Context
+}
+
+func (r *testContextRunner) RunContext(ctx context.Context) {
+	r.ctx = ctx
+	span, _ := opentracing.StartSpanFromContext(ctx, "call")
+	span.Finish()
+}
+
+func TestRun(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	runner := &testRunner{}
+	Run("plain", runner)
+	assert.True(t, runner.ran)
+	spans := tracer.FinishedSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "job plain", spans[0].OperationName)
+	assert.Equal(t, 0, spans[0].ParentID)
+
+	tracer.Reset()
+	ctxRunner := &testContextRunner{}
+	Run("traced", ctxRunner)
+	assert.False(t, ctxRunner.ran)
+	spans = tracer.FinishedSpans()
+	assert.Len(t, spans, 2)
+	call, root := spans[0], spans[1]
+	assert.Equal(t, "job traced", root.OperationName)
+	assert.Equal(t, 0, root.ParentID)
+	assert.Equal(t, root, opentracing.SpanFromContext(ctxRunner.ctx))
+	assert.Equal(t, root.SpanContext.SpanID, call.ParentID)
+	assert.Equal(t, root.SpanContext.TraceID, call.SpanContext.TraceID)
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xsupervisor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+// ConfigKey ...
+const ConfigKey = "jupiter.supervisor"
+
+func init() {
+	xschema.RegisterConfig("supervisor", ConfigKey, "supervisor of auxiliary processes", DefaultConfig)
+}
+
+// restart policies
+const (
+	// RestartAlways restarts processes whenever they exit
+	RestartAlways = "always"
+	// RestartOnFailure restarts processes exiting with errors
+	RestartOnFailure = "on-failure"
+	// RestartNever leaves exited processes
+	RestartNever = "never"
+)
+
+// Config ...
+type Config struct {
+	// Processes keyed by name
+	Processes map[string]Process
+	// StopTimeout is the time processes have to exit after SIGTERM before
+	// they're killed
+	StopTimeout time.Duration
+	// Backoff of restarts
+	Backoff xbackoff.Config
+	// ResetAfter resets the backoff of processes running for so long
+	ResetAfter time.Duration
+
+	name   string
+	logger *xlog.Logger
+	clock  xtime.Clock
+}
+
+// Process is an auxiliary process, e.g. a local proxy or exporter
+type Process struct {
+	Command string
+	Args    []string
+	// Env are "KEY=VALUE" pairs added to the environment of the application
+	Env []string
+	// Dir is the working directory, the one of the application if empty
+	Dir string
+	// Restart policy, "always", "on-failure" or "never"
+	Restart string
+	Disable bool
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Processes:   make(map[string]Process),
+		StopTimeout: time.Second * 10,
+		Backoff:     xbackoff.DefaultConfig(),
+		ResetAfter:  time.Minute,
+		name:        ConfigKey,
+		logger:      xlog.JupiterLogger.With(xlog.FieldMod("supervisor")),
+		clock:       xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig() *Config {
+	return RawConfig(ConfigKey)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("supervisor parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	config.name = key
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *xlog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Supervisor {
+	for name, process := range config.Processes {
+		if err := process.validate(); err != nil {
+			config.logger.Panic("supervisor process", xlog.FieldErr(err), xlog.FieldName(name))
+		}
+	}
+	return newSupervisor(config)
+}
+
+func (process Process) validate() error {
+	if process.Command == "" {
+		return fmt.Errorf("no command")
+	}
+	switch process.Restart {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+		return nil
+	default:
+		return fmt.Errorf("unknown restart policy %q", process.Restart)
+	}
+}
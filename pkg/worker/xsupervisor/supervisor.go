@@ -0,0 +1,314 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xsupervisor runs auxiliary processes declared in config along with
+// the application, e.g. a local proxy or exporter, for deployments without
+// sidecars. Processes are restarted with backoff once they exit, their
+// stdout and stderr are logged line by line, and they're terminated once
+// the application stops.
+package xsupervisor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+var restartCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "supervisor_restart_total",
+	Labels:    []string{"process"},
+}.Build()
+
+// supervisors are running supervisors keyed by config key
+var supervisors sync.Map
+
+func init() {
+	governor.HandleFunc("/debug/supervisor", func(w http.ResponseWriter, r *http.Request) {
+		var ret = make(map[string]interface{})
+		supervisors.Range(func(key, value interface{}) bool {
+			ret[key.(string)] = value.(*Supervisor).States()
+			return true
+		})
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(ret)
+	})
+}
+
+// State of a process
+type State struct {
+	Name      string    `json:"name"`
+	Pid       int       `json:"pid"`
+	Running   bool      `json:"running"`
+	Restarts  int       `json:"restarts"`
+	StartedAt time.Time `json:"startedAt"`
+	// LastExit is the error of the last exit, e.g. "exit status 1"
+	LastExit   string    `json:"lastExit,omitempty"`
+	LastExitAt time.Time `json:"lastExitAt,omitempty"`
+}
+
+// Supervisor is the worker running processes
+type Supervisor struct {
+	*Config
+	processes []*process
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type process struct {
+	Process
+	sup *Supervisor
+
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	done  chan struct{}
+	state State
+}
+
+func newSupervisor(config *Config) *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	sup := &Supervisor{Config: config, ctx: ctx, cancel: cancel}
+	var names = make([]string, 0, len(config.Processes))
+	for name := range config.Processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if config.Processes[name].Disable {
+			continue
+		}
+		sup.processes = append(sup.processes, &process{
+			Process: config.Processes[name],
+			sup:     sup,
+			state:   State{Name: name},
+		})
+	}
+	return sup
+}
+
+// Run starts processes and blocks until Stop
+func (sup *Supervisor) Run() error {
+	supervisors.Store(sup.name, sup)
+	defer supervisors.Delete(sup.name)
+	for _, p := range sup.processes {
+		p := p
+		sup.wg.Add(1)
+		xgo.Go(func() {
+			defer sup.wg.Done()
+			p.run()
+		})
+	}
+	<-sup.ctx.Done()
+	sup.wg.Wait()
+	return nil
+}
+
+// Stop terminates processes with SIGTERM, processes not exited within
+// StopTimeout are killed
+func (sup *Supervisor) Stop() error {
+	sup.cancel()
+	var wg sync.WaitGroup
+	for _, p := range sup.processes {
+		wg.Add(1)
+		go func(p *process) {
+			defer wg.Done()
+			p.terminate()
+		}(p)
+	}
+	wg.Wait()
+	return nil
+}
+
+// States returns states of processes
+func (sup *Supervisor) States() []State {
+	var states = make([]State, 0, len(sup.processes))
+	for _, p := range sup.processes {
+		p.mu.Lock()
+		states = append(states, p.state)
+		p.mu.Unlock()
+	}
+	return states
+}
+
+// run runs the process until the supervisor stops or it's not restarted
+func (p *process) run() {
+	var logger = p.sup.logger.With(xlog.FieldName(p.state.Name))
+	var retries int
+	for {
+		started := p.sup.clock.Now()
+		err := p.start(logger)
+		if err == nil {
+			err = p.wait()
+		}
+		if p.sup.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Error("process exited", xlog.FieldErr(err))
+		} else {
+			logger.Info("process exited")
+		}
+		if !p.restart(err) {
+			return
+		}
+		if p.sup.clock.Since(started) >= p.sup.ResetAfter {
+			retries = 0
+		}
+		select {
+		case <-p.sup.clock.After(p.sup.Backoff.Backoff(retries)):
+		case <-p.sup.ctx.Done():
+			return
+		}
+		retries++
+		restartCounter.Inc(p.state.Name)
+		p.mu.Lock()
+		p.state.Restarts++
+		p.mu.Unlock()
+	}
+}
+
+// restart reports whether the process exited with err should be restarted
+func (p *process) restart(err error) bool {
+	switch p.Restart {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		return err != nil
+	default:
+		return true
+	}
+}
+
+func (p *process) start(logger *xlog.Logger) error {
+	cmd := exec.Command(p.Command, p.Args...)
+	cmd.Dir = p.Dir
+	cmd.Env = append(os.Environ(), p.Env...)
+	cmd.Stdout = &lineWriter{logger: logger, stream: "stdout"}
+	cmd.Stderr = &lineWriter{logger: logger, stream: "stderr"}
+	setProcAttr(cmd)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// don't start once the supervisor is stopping
+	if err := p.sup.ctx.Err(); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		p.state.LastExit, p.state.LastExitAt = err.Error(), p.sup.clock.Now()
+		return err
+	}
+	p.cmd, p.done = cmd, make(chan struct{})
+	p.state.Pid, p.state.Running, p.state.StartedAt = cmd.Process.Pid, true, p.sup.clock.Now()
+	logger.Info("process started", xlog.Int("pid", cmd.Process.Pid), xlog.String("command", p.Command), xlog.Any("args", p.Args))
+	return nil
+}
+
+func (p *process) wait() error {
+	p.mu.Lock()
+	cmd, done := p.cmd, p.done
+	p.mu.Unlock()
+
+	err := cmd.Wait()
+	cmd.Stdout.(*lineWriter).flush()
+	cmd.Stderr.(*lineWriter).flush()
+
+	p.mu.Lock()
+	p.state.Running = false
+	p.state.LastExit, p.state.LastExitAt = "exit status 0", p.sup.clock.Now()
+	if err != nil {
+		p.state.LastExit = err.Error()
+	}
+	p.cmd = nil
+	p.mu.Unlock()
+	close(done)
+	return err
+}
+
+// terminate sends SIGTERM to the running process and kills it if it's not
+// exited within StopTimeout
+func (p *process) terminate() {
+	p.mu.Lock()
+	cmd, done := p.cmd, p.done
+	p.mu.Unlock()
+	if cmd == nil {
+		return
+	}
+	// SIGTERM isn't supported on windows
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		_ = cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-p.sup.clock.After(p.sup.StopTimeout):
+		p.sup.logger.Warn("kill process not exited in time", xlog.FieldName(p.state.Name), xlog.Int("pid", cmd.Process.Pid))
+		_ = cmd.Process.Kill()
+		<-done
+	}
+}
+
+// maxLine is the max length of lines buffered, longer lines are split
+const maxLine = 64 << 10
+
+// lineWriter logs output of processes line by line, it's called from a
+// single goroutine copying output of cmd
+type lineWriter struct {
+	logger *xlog.Logger
+	stream string
+	buf    []byte
+}
+
+// Write ...
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLine {
+		w.flush()
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.log(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *lineWriter) log(line []byte) {
+	w.logger.Info(string(bytes.TrimRight(line, "\r")), xlog.String("stream", w.stream))
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xsupervisor
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestSupervisor(buf *syncBuffer, clock xtime.Clock, processes map[string]Process) *Supervisor {
+	logConfig := xlog.DefaultConfig()
+	logConfig.Core = zapcore.NewCore(zapcore.NewJSONEncoder(*xlog.DefaultZapConfig()), zapcore.AddSync(buf), xlog.InfoLevel)
+	config := DefaultConfig()
+	config.Processes = processes
+	config.StopTimeout = time.Second
+	config.Backoff = xbackoff.Config{BaseDelay: time.Second}
+	config.clock = clock
+	return config.WithLogger(logConfig.Build()).Build()
+}
+
+func runSupervisor(sup *Supervisor) chan error {
+	errs := make(chan error, 1)
+	go func() { errs <- sup.Run() }()
+	return errs
+}
+
+func TestSupervisor_Output(t *testing.T) {
+	var buf syncBuffer
+	sup := newTestSupervisor(&buf, xtime.SystemClock, map[string]Process{
+		"echo": {Command: "sh", Args: []string{"-c", "echo hello; printf partial >&2"}, Restart: RestartNever},
+	})
+	errs := runSupervisor(sup)
+	assert.Eventually(t, func() bool {
+		states := sup.States()
+		return states[0].LastExit == "exit status 0" && !states[0].Running
+	}, time.Second*5, time.Millisecond*10)
+	assert.Contains(t, buf.String(), `"msg":"hello","name":"echo","stream":"stdout"`)
+	assert.Contains(t, buf.String(), `"msg":"partial","name":"echo","stream":"stderr"`)
+
+	assert.Nil(t, sup.Stop())
+	assert.Nil(t, <-errs)
+	assert.Equal(t, 0, sup.States()[0].Restarts)
+}
+
+func TestSupervisor_Restart(t *testing.T) {
+	var buf syncBuffer
+	clock := xtime.NewMockClock(time.Now())
+	sup := newTestSupervisor(&buf, clock, map[string]Process{
+		"fail":     {Command: "sh", Args: []string{"-c", "exit 1"}, Restart: RestartOnFailure},
+		"succeed":  {Command: "sh", Args: []string{"-c", "exit 0"}, Restart: RestartOnFailure},
+		"disabled": {Command: "sh", Disable: true},
+	})
+	errs := runSupervisor(sup)
+
+	// only the failed one waits for backoff
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return sup.States()[0].Restarts == 1 }, time.Second*5, time.Millisecond*10)
+	clock.BlockUntil(1)
+
+	states := sup.States()
+	assert.Len(t, states, 2)
+	assert.Equal(t, "fail", states[0].Name)
+	assert.Equal(t, "exit status 1", states[0].LastExit)
+	assert.Equal(t, "succeed", states[1].Name)
+	assert.Equal(t, 0, states[1].Restarts)
+
+	assert.Nil(t, sup.Stop())
+	assert.Nil(t, <-errs)
+}
+
+func TestSupervisor_Stop(t *testing.T) {
+	var buf syncBuffer
+	sup := newTestSupervisor(&buf, xtime.SystemClock, map[string]Process{
+		"sleep": {Command: "sleep", Args: []string{"30"}},
+	})
+	errs := runSupervisor(sup)
+	assert.Eventually(t, func() bool { return sup.States()[0].Running }, time.Second*5, time.Millisecond*10)
+
+	start := time.Now()
+	assert.Nil(t, sup.Stop())
+	assert.Nil(t, <-errs)
+	assert.True(t, time.Since(start) < time.Second*5)
+	state := sup.States()[0]
+	assert.False(t, state.Running)
+	assert.Equal(t, "signal: terminated", state.LastExit)
+	assert.Equal(t, 0, state.Restarts)
+}
+
+func TestProcess_Validate(t *testing.T) {
+	assert.NotNil(t, Process{}.validate())
+	assert.NotNil(t, Process{Command: "sh", Restart: "sometimes"}.validate())
+	assert.Nil(t, Process{Command: "sh", Restart: RestartAlways}.validate())
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xbatch runs the items of batch endpoints as bounded-concurrency
+// sub-calls under an overall deadline, and aggregates partial results:
+//
+//	results, err := batcher.Do(ctx, len(req.Ids), func(ctx context.Context, i int) (interface{}, error) {
+//		return getUser(ctx, req.Ids[i])
+//	})
+//
+// err is only returned if the whole batch is rejected, failures of items are
+// kept in their results.
+package xbatch
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrTooLarge is returned if the batch exceeds Config.MaxSize
+	ErrTooLarge = errors.New("batch too large")
+	// ErrNotRun is the error of items not run before the deadline
+	ErrNotRun = errors.New("batch item not run before deadline")
+)
+
+var (
+	batchSizeHistogram = metric.HistogramVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "batch_size",
+		Labels:    []string{"name"},
+		Buckets:   []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+	}.Build()
+
+	batchItemCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "batch_item_total",
+		Labels:    []string{"name", "result"},
+	}.Build()
+)
+
+// Result of an item
+type Result struct {
+	Index int
+	Value interface{}
+	Err   error
+}
+
+// MarshalJSON renders the result as {"index":0,"data":...} or {"index":0,"error":"..."}
+func (r Result) MarshalJSON() ([]byte, error) {
+	var out = struct {
+		Index int         `json:"index"`
+		Data  interface{} `json:"data,omitempty"`
+		Error string      `json:"error,omitempty"`
+	}{Index: r.Index, Data: r.Value}
+	if r.Err != nil {
+		out.Error = r.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+// Results in the order of items
+type Results []Result
+
+// Failed returns the failed results
+func (rs Results) Failed() Results {
+	var failed = make(Results, 0)
+	for _, r := range rs {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// Batcher ...
+type Batcher struct {
+	config *Config
+}
+
+// Do runs fn for items [0, n) with bounded concurrency, and returns at the
+// deadline at the latest. Items still running at the deadline get ctx.Err(),
+// items not started get ErrNotRun.
+func (b *Batcher) Do(ctx context.Context, n int, fn func(ctx context.Context, i int) (interface{}, error)) (Results, error) {
+	batchSizeHistogram.Observe(float64(n), b.config.Name)
+	if b.config.MaxSize > 0 && n > b.config.MaxSize {
+		return nil, errors.Wrapf(ErrTooLarge, "%d > %d", n, b.config.MaxSize)
+	}
+	if b.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.config.Timeout)
+		defer cancel()
+	}
+
+	var (
+		mu      sync.Mutex
+		results = make(Results, n)
+		started = make([]bool, n)
+		done    = make([]bool, n)
+		left    = n
+		closed  bool
+		finish  = make(chan struct{})
+		sem     = make(chan struct{}, b.config.Concurrency)
+	)
+	for i := range results {
+		results[i] = Result{Index: i, Err: ErrNotRun}
+	}
+	if n == 0 {
+		close(finish)
+	}
+	set := func(i int, value interface{}, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		done[i] = true
+		results[i] = Result{Index: i, Value: value, Err: err}
+		if left--; left == 0 {
+			close(finish)
+		}
+	}
+
+	xgo.Go(func() {
+		for i := 0; i < n; i++ {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			mu.Lock()
+			if closed {
+				mu.Unlock()
+				return
+			}
+			started[i] = true
+			mu.Unlock()
+
+			i := i
+			xgo.Go(func() {
+				defer func() { <-sem }()
+				value, err := call(ctx, i, fn)
+				set(i, value, err)
+			})
+		}
+	})
+
+	select {
+	case <-finish:
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	closed = true
+	for i := range results {
+		if started[i] && !done[i] {
+			results[i].Err = ctx.Err()
+		}
+	}
+	mu.Unlock()
+	b.report(results)
+	return results, nil
+}
+
+// call runs fn, turning panics into errors
+func call(ctx context.Context, i int, fn func(ctx context.Context, i int) (interface{}, error)) (value interface{}, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = errors.Errorf("panic: %v", rec)
+		}
+	}()
+	return fn(ctx, i)
+}
+
+func (b *Batcher) report(results Results) {
+	for _, r := range results {
+		switch {
+		case r.Err == nil:
+			batchItemCounter.Inc(b.config.Name, "ok")
+		case r.Err == ErrNotRun || r.Err == context.DeadlineExceeded || r.Err == context.Canceled:
+			batchItemCounter.Inc(b.config.Name, "timeout")
+		default:
+			batchItemCounter.Inc(b.config.Name, "error")
+		}
+	}
+}
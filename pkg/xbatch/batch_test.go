@@ -0,0 +1,103 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xbatch
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher_Do(t *testing.T) {
+	config := DefaultConfig()
+	config.Concurrency = 2
+	b := config.Build()
+
+	var running, peak int32
+	results, err := b.Do(context.Background(), 5, func(ctx context.Context, i int) (interface{}, error) {
+		cur := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if cur <= old || atomic.CompareAndSwapInt32(&peak, old, cur) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if i == 3 {
+			return nil, errors.New("not found")
+		}
+		if i == 4 {
+			panic("oops")
+		}
+		return i * 10, nil
+	})
+	assert.Nil(t, err)
+	assert.Len(t, results, 5)
+	assert.LessOrEqual(t, atomic.LoadInt32(&peak), int32(2))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, Result{Index: i, Value: i * 10}, results[i])
+	}
+	assert.EqualError(t, results[3].Err, "not found")
+	assert.EqualError(t, results[4].Err, "panic: oops")
+	assert.Len(t, results.Failed(), 2)
+
+	data, _ := json.Marshal(results[:1])
+	assert.Equal(t, `[{"index":0,"data":0}]`, string(data))
+	data, _ = json.Marshal(results[3])
+	assert.Equal(t, `{"index":3,"error":"not found"}`, string(data))
+}
+
+func TestBatcher_Deadline(t *testing.T) {
+	config := DefaultConfig()
+	config.Concurrency = 1
+	config.Timeout = 50 * time.Millisecond
+	b := config.Build()
+
+	block := make(chan struct{})
+	defer close(block)
+	start := time.Now()
+	results, err := b.Do(context.Background(), 3, func(ctx context.Context, i int) (interface{}, error) {
+		if i == 0 {
+			return "ok", nil
+		}
+		// ignores ctx, the batch returns at the deadline anyway
+		<-block
+		return "late", nil
+	})
+	assert.Nil(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, context.DeadlineExceeded, results[1].Err)
+	assert.Equal(t, ErrNotRun, results[2].Err)
+}
+
+func TestBatcher_TooLarge(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxSize = 2
+	_, err := config.Build().Do(context.Background(), 3, func(ctx context.Context, i int) (interface{}, error) {
+		return nil, nil
+	})
+	assert.Equal(t, ErrTooLarge, errors.Cause(err))
+
+	results, err := config.Build().Do(context.Background(), 0, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, results)
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xbatch
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("batch", "jupiter.batch.*", "batch executor", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Name labels metrics of the batch endpoint
+	Name string
+	// Concurrency of sub-calls
+	Concurrency int
+	// MaxSize rejects larger batches, unlimited if zero
+	MaxSize int
+	// Timeout of the whole batch, on top of the deadline of the request
+	Timeout time.Duration
+
+	logger *xlog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Name:        "default",
+		Concurrency: 8,
+		MaxSize:     100,
+		Timeout:     5 * time.Second,
+		logger:      xlog.JupiterLogger.With(xlog.FieldMod("xbatch")),
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.batch." + name)
+	if config.Name == "default" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("batch parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Batcher {
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	return &Batcher{config: config}
+}
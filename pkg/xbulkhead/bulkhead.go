@@ -0,0 +1,213 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xbulkhead caps concurrent outbound calls per dependency, so that
+// one slow dependency can't take all goroutines and connections of the
+// process. Calls beyond MaxConcurrent wait in a bounded queue, and are
+// rejected once the queue is full or they waited for QueueTimeout.
+package xbulkhead
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+)
+
+// Reasons of rejected calls
+const (
+	RejectFull    = "full"
+	RejectTimeout = "timeout"
+)
+
+// ErrRejected matches errors of calls rejected by bulkheads with errors.Is
+var ErrRejected = errors.New("bulkhead rejected")
+
+// Error is returned for calls rejected by a bulkhead
+type Error struct {
+	Name   string
+	Reason string
+}
+
+// Error ...
+func (e *Error) Error() string {
+	return "bulkhead " + e.Name + " rejected: " + e.Reason
+}
+
+// Is ...
+func (e *Error) Is(target error) bool {
+	return target == ErrRejected
+}
+
+var (
+	bulkheads sync.Map // name => *Bulkhead
+
+	activeGauge = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "bulkhead_active",
+		Labels:    []string{"name"},
+	}.Build()
+	queuedGauge = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "bulkhead_queued",
+		Labels:    []string{"name"},
+	}.Build()
+	capacityGauge = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "bulkhead_capacity",
+		Labels:    []string{"name"},
+	}.Build()
+	rejectedCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "bulkhead_rejected_total",
+		Labels:    []string{"name", "reason"},
+	}.Build()
+	waitHistogram = metric.HistogramVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "bulkhead_wait_seconds",
+		Labels:    []string{"name"},
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+	}.Build()
+)
+
+func init() {
+	governor.HandleFunc("/debug/bulkheads", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(All())
+	})
+}
+
+// Bulkhead is an isolated budget of concurrent calls to a dependency
+type Bulkhead struct {
+	config *Config
+	slots  chan struct{}
+
+	queued   int64
+	rejected int64
+}
+
+// Stats is a snapshot of a bulkhead
+type Stats struct {
+	Name          string `json:"name"`
+	MaxConcurrent int    `json:"maxConcurrent"`
+	MaxQueue      int    `json:"maxQueue"`
+	Active        int    `json:"active"`
+	Queued        int    `json:"queued"`
+	Rejected      int64  `json:"rejected"`
+	// Saturation is the ratio of active calls to MaxConcurrent
+	Saturation float64 `json:"saturation"`
+}
+
+func newBulkhead(config *Config) *Bulkhead {
+	return &Bulkhead{
+		config: config,
+		slots:  make(chan struct{}, config.MaxConcurrent),
+	}
+}
+
+// Acquire takes a slot of b, waiting in the queue if all slots are taken,
+// release must be called once the call is done
+func (b *Bulkhead) Acquire(ctx context.Context) (release func(), err error) {
+	select {
+	case b.slots <- struct{}{}:
+		return b.acquired(), nil
+	default:
+	}
+
+	if queued := atomic.AddInt64(&b.queued, 1); queued > int64(b.config.MaxQueue) {
+		atomic.AddInt64(&b.queued, -1)
+		return nil, b.reject(RejectFull)
+	}
+	queuedGauge.Inc(b.config.Name)
+	defer func() {
+		atomic.AddInt64(&b.queued, -1)
+		queuedGauge.Add(-1, b.config.Name)
+	}()
+
+	var timeout <-chan struct{}
+	if b.config.QueueTimeout > 0 {
+		expired := make(chan struct{})
+		timer := b.config.clock.AfterFunc(b.config.QueueTimeout, func() { close(expired) })
+		defer timer.Stop()
+		timeout = expired
+	}
+	start := b.config.clock.Now()
+	select {
+	case b.slots <- struct{}{}:
+		waitHistogram.Observe(b.config.clock.Since(start).Seconds(), b.config.Name)
+		return b.acquired(), nil
+	case <-timeout:
+		return nil, b.reject(RejectTimeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Do calls fn within a slot of b
+func (b *Bulkhead) Do(ctx context.Context, fn func(context.Context) error) error {
+	release, err := b.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(ctx)
+}
+
+func (b *Bulkhead) acquired() func() {
+	activeGauge.Inc(b.config.Name)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-b.slots
+			activeGauge.Add(-1, b.config.Name)
+		})
+	}
+}
+
+func (b *Bulkhead) reject(reason string) error {
+	atomic.AddInt64(&b.rejected, 1)
+	rejectedCounter.Inc(b.config.Name, reason)
+	return &Error{Name: b.config.Name, Reason: reason}
+}
+
+// Stats returns a snapshot of b
+func (b *Bulkhead) Stats() Stats {
+	active := len(b.slots)
+	return Stats{
+		Name:          b.config.Name,
+		MaxConcurrent: b.config.MaxConcurrent,
+		MaxQueue:      b.config.MaxQueue,
+		Active:        active,
+		Queued:        int(atomic.LoadInt64(&b.queued)),
+		Rejected:      atomic.LoadInt64(&b.rejected),
+		Saturation:    float64(active) / float64(b.config.MaxConcurrent),
+	}
+}
+
+// All returns stats of all bulkheads sorted by name
+func All() []Stats {
+	var all = make([]Stats, 0)
+	bulkheads.Range(func(_, value interface{}) bool {
+		all = append(all, value.(*Bulkhead).Stats())
+		return true
+	})
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
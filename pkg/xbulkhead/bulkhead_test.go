@@ -0,0 +1,117 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xbulkhead
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBulkhead(t *testing.T, name string, maxConcurrent, maxQueue int, timeout time.Duration) *Bulkhead {
+	t.Cleanup(func() { bulkheads.Delete(name) })
+	config := DefaultConfig()
+	config.Name = name
+	config.MaxConcurrent = maxConcurrent
+	config.MaxQueue = maxQueue
+	config.QueueTimeout = timeout
+	return config.Build()
+}
+
+func TestBulkhead(t *testing.T) {
+	b := newTestBulkhead(t, "test", 2, 1, 50*time.Millisecond)
+	assert.Same(t, b, newTestBulkhead(t, "test", 8, 8, 0), "shared by name")
+	clock := xtime.NewMockClock(time.Unix(0, 0))
+	b.config.clock = clock
+
+	ctx := context.Background()
+	r1, err := b.Acquire(ctx)
+	assert.Nil(t, err)
+	r2, err := b.Acquire(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, Stats{Name: "test", MaxConcurrent: 2, MaxQueue: 1, Active: 2, Saturation: 1}, b.Stats())
+
+	// waits in the queue for a released slot
+	var acquired = make(chan error)
+	go func() {
+		release, err := b.Acquire(ctx)
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	assert.Eventually(t, func() bool { return b.Stats().Queued == 1 }, time.Second, time.Millisecond)
+
+	// rejected at once with the queue full
+	_, err = b.Acquire(ctx)
+	assert.True(t, errors.Is(err, ErrRejected))
+	assert.Equal(t, RejectFull, err.(*Error).Reason)
+
+	r1()
+	r1()
+	assert.Nil(t, <-acquired)
+	assert.Equal(t, 1, b.Stats().Active, "released once")
+
+	// rejected after waiting for QueueTimeout
+	r3, err := b.Acquire(ctx)
+	assert.Nil(t, err)
+	go func() {
+		acquired <- b.Do(ctx, func(context.Context) error { return nil })
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(50 * time.Millisecond)
+	assert.Equal(t, &Error{Name: "test", Reason: RejectTimeout}, <-acquired)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = b.Acquire(cctx)
+	assert.Equal(t, context.Canceled, err)
+
+	r2()
+	r3()
+	assert.Equal(t, Stats{Name: "test", MaxConcurrent: 2, MaxQueue: 1, Rejected: 2}, b.Stats())
+	assert.Contains(t, All(), b.Stats())
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	b := newTestBulkhead(t, "transport", 1, 0, 0)
+	client := &http.Client{Transport: NewTransport(b, nil)}
+	resp, err := client.Get(srv.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, b.Stats().Active, "held until the body is closed")
+
+	_, err = client.Get(srv.URL)
+	assert.True(t, errors.Is(err, ErrRejected))
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "ok", string(body))
+	assert.Nil(t, resp.Body.Close())
+	assert.Equal(t, 0, b.Stats().Active)
+	resp, err = client.Get(srv.URL)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xbulkhead
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("bulkhead", "jupiter.bulkhead.*", "bulkhead of outbound calls", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Name of the dependency, bulkheads of the same name are shared
+	Name string
+	// MaxConcurrent calls in flight to the dependency
+	MaxConcurrent int
+	// MaxQueue calls waiting for a slot, the others are rejected at once
+	MaxQueue int
+	// QueueTimeout rejects calls waiting for a slot for so long
+	QueueTimeout time.Duration
+
+	logger *xlog.Logger
+	clock  xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Name:          "default",
+		MaxConcurrent: 64,
+		MaxQueue:      64,
+		QueueTimeout:  100 * time.Millisecond,
+		logger:        xlog.JupiterLogger.With(xlog.FieldMod("xbulkhead")),
+		clock:         xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.bulkhead." + name)
+	if config.Name == "default" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("bulkhead parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// Build returns the bulkhead of Name, it's created once and shared by all
+// clients of the dependency
+func (config *Config) Build() *Bulkhead {
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = 1
+	}
+	if config.clock == nil {
+		config.clock = xtime.SystemClock
+	}
+	if value, ok := bulkheads.Load(config.Name); ok {
+		return value.(*Bulkhead)
+	}
+	value, loaded := bulkheads.LoadOrStore(config.Name, newBulkhead(config))
+	if !loaded {
+		capacityGauge.Set(float64(config.MaxConcurrent), config.Name)
+	}
+	return value.(*Bulkhead)
+}
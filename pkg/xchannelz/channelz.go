@@ -0,0 +1,89 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xchannelz turns on grpc channelz if it's enabled by the configs of
+// grpc servers and clients. Collecting stats is provided by importing
+// pkg/xchannelz/service, which turns on channelz for the whole process once
+// it's linked, so that it's kept out of applications not using channelz:
+//
+//	import _ "github.com/douyu/jupiter/pkg/xchannelz/service"
+package xchannelz
+
+import (
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/xlog"
+	"google.golang.org/grpc"
+)
+
+// Provider serves channelz stats, it's registered by pkg/xchannelz/service
+type Provider interface {
+	// Enable registers the channelz governor endpoints, it's called once
+	Enable()
+	// Register registers the channelz service to a grpc server
+	Register(server *grpc.Server)
+}
+
+var (
+	mu       sync.Mutex
+	provider Provider
+	enabled  bool
+	warned   bool
+)
+
+// SetProvider sets the provider of channelz stats
+func SetProvider(p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	provider = p
+}
+
+// Enabled reports whether channelz stats are served on governor
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// Register registers the channelz service to grpc server, so that the stats
+// can be queried by external tools as well.
+func Register(server *grpc.Server) {
+	if p := enable(); p != nil {
+		p.Register(server)
+	}
+}
+
+// Enable registers the channelz governor endpoints, it's safe to call it more
+// than once. Nothing is served unless pkg/xchannelz/service is imported.
+func Enable() {
+	_ = enable()
+}
+
+func enable() Provider {
+	mu.Lock()
+	defer mu.Unlock()
+	if provider == nil {
+		if warned {
+			return nil
+		}
+		warned = true
+		xlog.JupiterLogger.Warn("channelz is enabled but not linked, import pkg/xchannelz/service", xlog.FieldMod("xchannelz"))
+		return nil
+	}
+	if !enabled {
+		enabled = true
+		provider.Enable()
+	}
+	return provider
+}
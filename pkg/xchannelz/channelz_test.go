@@ -0,0 +1,43 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xchannelz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+type fakeProvider struct {
+	enabled int
+}
+
+func (p *fakeProvider) Enable() { p.enabled++ }
+
+func (p *fakeProvider) Register(*grpc.Server) {}
+
+func TestEnable(t *testing.T) {
+	// nothing is served unless the service is linked
+	Enable()
+	assert.False(t, Enabled())
+
+	p := &fakeProvider{}
+	SetProvider(p)
+	Enable()
+	Enable()
+	assert.True(t, Enabled())
+	assert.Equal(t, 1, p.enabled)
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package service serves stats of grpc channelz, i.e. channels, subchannels,
+// servers and sockets, on governor in JSON. Importing it turns on channelz
+// for the whole process, stats of every grpc server and client conn created
+// afterwards are collected from then on, see pkg/xchannelz.
+package service
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xchannelz"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	channelzpb "google.golang.org/grpc/channelz/grpc_channelz_v1"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+)
+
+const maxResults = 100
+
+var (
+	proxyOnce   sync.Once
+	proxyClient channelzpb.ChannelzClient
+	proxyErr    error
+)
+
+func init() {
+	// channelz is turned on by the init of the channelz service of grpc
+	xchannelz.SetProvider(provider{})
+}
+
+type provider struct{}
+
+// Register ...
+func (provider) Register(server *grpc.Server) {
+	channelzsvc.RegisterChannelzServiceToServer(server)
+}
+
+// Enable ...
+func (provider) Enable() {
+	governor.HandleFunc("/debug/channelz/channels", handle(func(ctx context.Context, client channelzpb.ChannelzClient, r *http.Request) (proto.Message, error) {
+		return client.GetTopChannels(ctx, &channelzpb.GetTopChannelsRequest{
+			StartChannelId: queryInt(r, "start"),
+			MaxResults:     maxResults,
+		})
+	}))
+	governor.HandleFunc("/debug/channelz/channel", handle(func(ctx context.Context, client channelzpb.ChannelzClient, r *http.Request) (proto.Message, error) {
+		return client.GetChannel(ctx, &channelzpb.GetChannelRequest{ChannelId: queryInt(r, "id")})
+	}))
+	governor.HandleFunc("/debug/channelz/subchannel", handle(func(ctx context.Context, client channelzpb.ChannelzClient, r *http.Request) (proto.Message, error) {
+		return client.GetSubchannel(ctx, &channelzpb.GetSubchannelRequest{SubchannelId: queryInt(r, "id")})
+	}))
+	governor.HandleFunc("/debug/channelz/servers", handle(func(ctx context.Context, client channelzpb.ChannelzClient, r *http.Request) (proto.Message, error) {
+		return client.GetServers(ctx, &channelzpb.GetServersRequest{
+			StartServerId: queryInt(r, "start"),
+			MaxResults:    maxResults,
+		})
+	}))
+	governor.HandleFunc("/debug/channelz/serversockets", handle(func(ctx context.Context, client channelzpb.ChannelzClient, r *http.Request) (proto.Message, error) {
+		return client.GetServerSockets(ctx, &channelzpb.GetServerSocketsRequest{
+			ServerId:      queryInt(r, "id"),
+			StartSocketId: queryInt(r, "start"),
+			MaxResults:    maxResults,
+		})
+	}))
+	governor.HandleFunc("/debug/channelz/socket", handle(func(ctx context.Context, client channelzpb.ChannelzClient, r *http.Request) (proto.Message, error) {
+		return client.GetSocket(ctx, &channelzpb.GetSocketRequest{SocketId: queryInt(r, "id")})
+	}))
+}
+
+type query func(ctx context.Context, client channelzpb.ChannelzClient, r *http.Request) (proto.Message, error)
+
+func handle(fn query) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, err := proxy()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		msg, err := fn(r.Context(), client, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var marshaler = jsonpb.Marshaler{OrigName: true}
+		if r.URL.Query().Get("pretty") == "true" {
+			marshaler.Indent = "    "
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = marshaler.Marshal(w, msg)
+	}
+}
+
+// proxy serves the channelz service in process on a loopback listener, so
+// that stats are queried through the public service rather than internals
+// of grpc. The proxy itself shows up as one server and one channel in the
+// stats.
+func proxy() (channelzpb.ChannelzClient, error) {
+	proxyOnce.Do(func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			proxyErr = err
+			return
+		}
+		server := grpc.NewServer()
+		channelzsvc.RegisterChannelzServiceToServer(server)
+		xgo.Go(func() {
+			if err := server.Serve(listener); err != nil {
+				xlog.JupiterLogger.Error("channelz proxy serve", xlog.FieldMod("xchannelz"), xlog.FieldErr(err))
+			}
+		})
+
+		cc, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+		if err != nil {
+			proxyErr = err
+			return
+		}
+		proxyClient = channelzpb.NewChannelzClient(cc)
+	})
+	return proxyClient, proxyErr
+}
+
+func queryInt(r *http.Request, key string) int64 {
+	v, _ := strconv.ParseInt(r.URL.Query().Get(key), 10, 64)
+	return v
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/xchannelz"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestEnable(t *testing.T) {
+	// governor endpoints are registered once it's enabled
+	assert.False(t, xchannelz.Enabled())
+	w := httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/channelz/channels", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	xchannelz.Enable()
+	assert.True(t, xchannelz.Enabled())
+	cc, err := grpc.Dial("127.0.0.1:1", grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer cc.Close()
+
+	w = httptest.NewRecorder()
+	governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/channelz/channels", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"target":"127.0.0.1:1"`)
+}
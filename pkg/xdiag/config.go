@@ -0,0 +1,85 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xdiag
+
+import (
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("diagnostics", "jupiter.diagnostics.*", "diagnostic snapshots", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Codes selects failed requests to capture, grpc code names such as
+	// "Internal" or http status such as "500"
+	Codes []string
+	// SampleRate of selected requests captured, in [0, 1]
+	SampleRate float64
+	// MaxPayload truncates sanitized payload in bytes
+	MaxPayload int
+	// RedactKeys are payload and metadata keys whose values are masked, case insensitive
+	RedactKeys []string
+	// Capacity of the local store
+	Capacity int
+
+	sink   Sink
+	logger *xlog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Codes:      []string{"Unknown", "Internal", "DataLoss", "500"},
+		SampleRate: 0.1,
+		MaxPayload: 4096,
+		RedactKeys: []string{"password", "passwd", "token", "secret", "authorization", "cookie"},
+		Capacity:   256,
+		logger:     xlog.JupiterLogger.With(xlog.FieldMod("diagnostics")),
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.diagnostics." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("diagnostics parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// WithSink replaces the local store with sink, e.g. a kafka producer
+func (config *Config) WithSink(sink Sink) *Config {
+	config.sink = sink
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Mirror {
+	return newMirror(config)
+}
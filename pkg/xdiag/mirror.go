@@ -0,0 +1,131 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xdiag mirrors failed requests with selected codes to a
+// diagnostics sink for offline repro, payloads are sanitized and sampled.
+package xdiag
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/trace"
+	"github.com/douyu/jupiter/pkg/util/xrand"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// Capture is a sanitized failed request
+type Capture struct {
+	Time     time.Time         `json:"time"`
+	Method   string            `json:"method"`
+	Code     string            `json:"code"`
+	Error    string            `json:"error,omitempty"`
+	TraceID  string            `json:"traceId,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Payload  string            `json:"payload,omitempty"`
+	// Truncated is true if payload exceeded MaxPayload
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Sink receives captures, Write should not block request handling for long
+type Sink interface {
+	Write(capture Capture) error
+}
+
+// Mirror ...
+type Mirror struct {
+	config *Config
+	codes  map[string]bool
+	redact *Redactor
+	sink   Sink
+}
+
+func newMirror(config *Config) *Mirror {
+	m := &Mirror{
+		config: config,
+		codes:  make(map[string]bool, len(config.Codes)),
+		redact: NewRedactor(config.RedactKeys),
+		sink:   config.sink,
+	}
+	for _, code := range config.Codes {
+		m.codes[code] = true
+	}
+	if m.sink == nil {
+		m.sink = NewStore(config.Capacity)
+	}
+	return m
+}
+
+// Sink ...
+func (m *Mirror) Sink() Sink {
+	return m.sink
+}
+
+// Selected reports whether a request failed with code should be captured, sampling applied
+func (m *Mirror) Selected(code string) bool {
+	if !m.codes[code] {
+		return false
+	}
+	return m.config.SampleRate >= 1 || xrand.Float64() < m.config.SampleRate
+}
+
+// Capture sanitizes and writes the request to sink, callers should check Selected first
+func (m *Mirror) Capture(ctx context.Context, method, code string, err error, payload interface{}, md map[string][]string) {
+	capture := Capture{
+		Time:     time.Now(),
+		Method:   method,
+		Code:     code,
+		TraceID:  trace.ExtractTraceID(ctx),
+		Metadata: m.sanitizeMetadata(md),
+	}
+	if err != nil {
+		capture.Error = err.Error()
+	}
+	capture.Payload, capture.Truncated = m.sanitizePayload(payload)
+
+	if err := m.sink.Write(capture); err != nil {
+		m.config.logger.Error("write diagnostics", xlog.FieldMethod(method), xlog.FieldErr(err))
+	}
+}
+
+func (m *Mirror) sanitizeMetadata(md map[string][]string) map[string]string {
+	if len(md) == 0 {
+		return nil
+	}
+	var ret = make(map[string]string, len(md))
+	for key, vals := range md {
+		ret[key] = m.redact.Mask(key, strings.Join(vals, ","))
+	}
+	return ret
+}
+
+func (m *Mirror) sanitizePayload(payload interface{}) (string, bool) {
+	if payload == nil {
+		return "", false
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", false
+	}
+	// round trip through generic values, so that keys can be masked at any depth
+	if masked, ok := m.redact.JSON(data); ok {
+		data = masked
+	}
+	if m.config.MaxPayload > 0 && len(data) > m.config.MaxPayload {
+		return string(data[:m.config.MaxPayload]), true
+	}
+	return string(data), false
+}
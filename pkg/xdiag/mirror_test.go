@@ -0,0 +1,73 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xdiag
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type loginRequest struct {
+	User     string            `json:"user"`
+	Password string            `json:"password"`
+	Extra    map[string]string `json:"extra"`
+}
+
+func TestMirror(t *testing.T) {
+	config := DefaultConfig()
+	config.SampleRate = 1
+	m := config.Build()
+
+	assert.True(t, m.Selected("Internal"))
+	assert.False(t, m.Selected("NotFound"))
+
+	m.Capture(context.Background(), "/demo.User/Login", "Internal", errors.New("db down"),
+		&loginRequest{User: "jupiter", Password: "123456", Extra: map[string]string{"Token": "abc"}},
+		map[string][]string{"authorization": {"Bearer abc"}, "aid": {"1"}},
+	)
+
+	captures := m.Sink().(*Store).Captures()
+	assert.Len(t, captures, 1)
+	assert.Equal(t, "db down", captures[0].Error)
+	assert.Equal(t, `{"extra":{"Token":"******"},"password":"******","user":"jupiter"}`, captures[0].Payload)
+	assert.Equal(t, map[string]string{"authorization": "******", "aid": "1"}, captures[0].Metadata)
+}
+
+func TestMirrorSamplingAndTruncate(t *testing.T) {
+	config := DefaultConfig()
+	config.SampleRate = 0
+	config.MaxPayload = 8
+	m := config.Build()
+	assert.False(t, m.Selected("Internal"))
+
+	m.Capture(context.Background(), "/demo", "Internal", nil, map[string]string{"data": "0123456789"}, nil)
+	captures := m.Sink().(*Store).Captures()
+	assert.True(t, captures[0].Truncated)
+	assert.Len(t, captures[0].Payload, 8)
+}
+
+func TestStore(t *testing.T) {
+	store := NewStore(2)
+	for _, method := range []string{"a", "b", "c"} {
+		_ = store.Write(Capture{Method: method})
+	}
+	captures := store.Captures()
+	assert.Len(t, captures, 2)
+	assert.Equal(t, "c", captures[0].Method)
+	assert.Equal(t, "b", captures[1].Method)
+}
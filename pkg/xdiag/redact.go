@@ -0,0 +1,112 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xdiag
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+const redacted = "******"
+
+// Redactor masks values of sensitive keys, case insensitive
+type Redactor struct {
+	keys map[string]bool
+}
+
+// NewRedactor ...
+func NewRedactor(keys []string) *Redactor {
+	r := &Redactor{keys: make(map[string]bool, len(keys))}
+	for _, key := range keys {
+		r.keys[strings.ToLower(key)] = true
+	}
+	return r
+}
+
+// Redacted reports whether values of key are masked
+func (r *Redactor) Redacted(key string) bool {
+	return r.keys[strings.ToLower(key)]
+}
+
+// Mask returns the masked value of key, or else value itself
+func (r *Redactor) Mask(key, value string) string {
+	if r.Redacted(key) {
+		return redacted
+	}
+	return value
+}
+
+// Mentioned reports whether any key appears in text, e.g. bodies which
+// can't be parsed are kept only if they don't mention sensitive keys
+func (r *Redactor) Mentioned(text string) bool {
+	text = strings.ToLower(text)
+	for key := range r.keys {
+		if strings.Contains(text, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Value masks generic json values at any depth in place
+func (r *Redactor) Value(val interface{}) interface{} {
+	r.mask(val)
+	return val
+}
+
+// mask masks val in place, true if anything is masked
+func (r *Redactor) mask(val interface{}) bool {
+	var masked bool
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for key, sub := range v {
+			if r.Redacted(key) {
+				v[key] = redacted
+				masked = true
+				continue
+			}
+			masked = r.mask(sub) || masked
+		}
+	case []interface{}:
+		for i := range v {
+			masked = r.mask(v[i]) || masked
+		}
+	}
+	return masked
+}
+
+// JSON masks the json document data, false if it's not valid json. data is
+// returned as is if nothing is masked, numbers are kept exact otherwise
+func (r *Redactor) JSON(data []byte) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var val interface{}
+	if err := decoder.Decode(&val); err != nil {
+		return nil, false
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, false
+	}
+	if !r.mask(val) {
+		return data, true
+	}
+	ret, err := json.Marshal(val)
+	if err != nil {
+		return nil, false
+	}
+	return ret, true
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xdiag
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+)
+
+var (
+	storesMu sync.Mutex
+	stores   []*Store
+)
+
+// Store is a bounded local sink keeping the latest captures,
+// all stores are listed on governor /debug/diagnostics
+type Store struct {
+	mu       sync.Mutex
+	captures []Capture
+	next     int
+	full     bool
+}
+
+// NewStore ...
+func NewStore(capacity int) *Store {
+	if capacity <= 0 {
+		capacity = DefaultConfig().Capacity
+	}
+	store := &Store{captures: make([]Capture, capacity)}
+	storesMu.Lock()
+	stores = append(stores, store)
+	storesMu.Unlock()
+	return store
+}
+
+// Write implements Sink, overwriting the oldest capture if full
+func (s *Store) Write(capture Capture) error {
+	s.mu.Lock()
+	s.captures[s.next] = capture
+	s.next = (s.next + 1) % len(s.captures)
+	if s.next == 0 {
+		s.full = true
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Captures returns captures newest first
+func (s *Store) Captures() []Capture {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n = s.next
+	if s.full {
+		n = len(s.captures)
+	}
+	var ret = make([]Capture, 0, n)
+	for i := 1; i <= n; i++ {
+		ret = append(ret, s.captures[(s.next-i+len(s.captures))%len(s.captures)])
+	}
+	return ret
+}
+
+func init() {
+	governor.HandleFunc("/debug/diagnostics", func(w http.ResponseWriter, r *http.Request) {
+		var captures = make([]Capture, 0)
+		storesMu.Lock()
+		for _, store := range stores {
+			captures = append(captures, store.Captures()...)
+		}
+		storesMu.Unlock()
+
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(captures)
+	})
+}
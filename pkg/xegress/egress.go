@@ -0,0 +1,383 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xegress enforces the egress policy configured by key
+// "jupiter.egress" on outbound calls of jupiter clients. Destinations out of
+// the allowlist are rejected in block mode, or logged in audit mode, they're
+// counted and served on governor /debug/egress either way.
+package xegress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+	"github.com/pkg/errors"
+)
+
+// ConfigKey ...
+const ConfigKey = "jupiter.egress"
+
+// maxDestinations caps violating destinations tracked
+const maxDestinations = 1000
+
+const (
+	// ModeOff doesn't check destinations
+	ModeOff = ""
+	// ModeAudit logs and counts violations, calls go on
+	ModeAudit = "audit"
+	// ModeBlock rejects violations
+	ModeBlock = "block"
+)
+
+// Config ...
+type Config struct {
+	// Mode is ModeBlock, ModeAudit or ModeOff
+	Mode string
+	// Allow lists destinations, which are hosts, e.g. "api.example.com" or
+	// "*.example.com" of its subdomains, IPs or CIDRs, e.g. "10.0.0.0/8",
+	// with optional ports, e.g. "api.example.com:443" or "[::1]:80". Hosts
+	// of names are allowed by CIDRs once all their addresses are in them.
+	Allow []string
+	// LogInterval of logging each violating destination, 1m by default
+	LogInterval time.Duration
+}
+
+// DefaultConfig ...
+func DefaultConfig() Config {
+	return Config{
+		LogInterval: time.Minute,
+	}
+}
+
+// Error is returned for destinations rejected in block mode
+type Error struct {
+	Addr string
+}
+
+// Error ...
+func (e *Error) Error() string {
+	return fmt.Sprintf("egress to %s is not allowed", e.Addr)
+}
+
+// Violation is the calls to a destination out of the allowlist
+type Violation struct {
+	Addr     string    `json:"addr"`
+	Count    int64     `json:"count"`
+	Blocked  int64     `json:"blocked"`
+	LastSeen time.Time `json:"lastSeen"`
+
+	lastLogged time.Time
+}
+
+type rule struct {
+	host  string // lower case, "*.example.com" matches subdomains
+	ipNet *net.IPNet
+	port  string // any port if empty
+}
+
+type policy struct {
+	config Config
+	rules  []rule
+}
+
+var violationCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "egress_violation_total",
+	Labels:    []string{"addr", "action"},
+}.Build()
+
+var (
+	current    atomic.Value // *policy
+	mu         sync.Mutex
+	violations = make(map[string]*Violation)
+
+	// lookup resolves hosts of names matched with CIDRs
+	lookup = net.DefaultResolver.LookupIPAddr
+
+	clock  xtime.Clock = xtime.SystemClock
+	logger             = xlog.JupiterLogger.With(xlog.FieldMod("xegress"))
+)
+
+func init() {
+	current.Store(&policy{config: DefaultConfig()})
+	xschema.RegisterConfig("egress", ConfigKey, "allowlist of outbound destinations of clients", DefaultConfig)
+
+	governor.HandleFunc("/debug/egress", func(w http.ResponseWriter, r *http.Request) {
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(Violations())
+	})
+}
+
+// Load reads the policy from config and watches config changes
+func Load() {
+	reload()
+	conf.OnChange(func(*conf.Configuration) { reload() })
+}
+
+func reload() {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(ConfigKey, &config); err != nil && errors.Cause(err) != conf.ErrInvalidKey {
+		logger.Error("parse egress config", xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err))
+		return
+	}
+	if err := Set(config); err != nil {
+		logger.Error("parse egress allowlist", xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), xlog.FieldErr(err))
+	}
+}
+
+// Set replaces the policy with config, the current one is kept if the
+// allowlist is invalid
+func Set(config Config) error {
+	switch config.Mode {
+	case ModeOff, ModeAudit, ModeBlock:
+	default:
+		return errors.Errorf("invalid egress mode %q", config.Mode)
+	}
+	var rules = make([]rule, 0, len(config.Allow))
+	for _, allow := range config.Allow {
+		r, err := parseRule(allow)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+	}
+	current.Store(&policy{config: config, rules: rules})
+	return nil
+}
+
+func parseRule(allow string) (rule, error) {
+	var r rule
+	host := strings.TrimSpace(allow)
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		host, r.port = h, port
+	}
+	if strings.Contains(host, "/") {
+		_, ipNet, err := net.ParseCIDR(host)
+		if err != nil {
+			return r, errors.Wrapf(err, "egress rule %q", allow)
+		}
+		r.ipNet = ipNet
+		return r, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		r.ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return r, nil
+	}
+	if host == "" {
+		return r, errors.Errorf("egress rule %q without host", allow)
+	}
+	r.host = strings.ToLower(host)
+	return r, nil
+}
+
+func (r rule) matchPort(port string) bool {
+	return r.port == "" || r.port == port
+}
+
+func (r rule) matchHost(host string) bool {
+	if strings.HasPrefix(r.host, "*.") {
+		return strings.HasSuffix(host, r.host[1:])
+	}
+	return r.host == host
+}
+
+// Check checks the destination addr, e.g. "api.example.com:443", and returns
+// the address to dial, which is the resolved one if the host is allowed by
+// CIDRs, so that the dial can't be steered by DNS changes in between
+func Check(ctx context.Context, addr string) (string, error) {
+	p := current.Load().(*policy)
+	if p.config.Mode == ModeOff {
+		return addr, nil
+	}
+	dial, ok := p.allow(ctx, addr)
+	if ok {
+		return dial, nil
+	}
+	blocked := p.config.Mode == ModeBlock
+	violate(addr, blocked, p.config.LogInterval)
+	if blocked {
+		return "", &Error{Addr: addr}
+	}
+	return addr, nil
+}
+
+func (p *policy) allow(ctx context.Context, addr string) (string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		return addr, p.allowIP(ip, port)
+	}
+
+	var cidr bool
+	for _, r := range p.rules {
+		if r.ipNet != nil {
+			cidr = cidr || r.matchPort(port)
+			continue
+		}
+		if r.matchPort(port) && r.matchHost(host) {
+			return addr, true
+		}
+	}
+	if !cidr {
+		return "", false
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return "", false
+	}
+	for _, a := range addrs {
+		if !p.allowIP(a.IP, port) {
+			return "", false
+		}
+	}
+	if port == "" {
+		return addrs[0].IP.String(), true
+	}
+	return net.JoinHostPort(addrs[0].IP.String(), port), true
+}
+
+func (p *policy) allowIP(ip net.IP, port string) bool {
+	for _, r := range p.rules {
+		if r.ipNet != nil && r.matchPort(port) && r.ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func violate(addr string, blocked bool, logInterval time.Duration) {
+	action := "audit"
+	if blocked {
+		action = "block"
+	}
+	violationCounter.Inc(addr, action)
+	now := clock.Now()
+
+	mu.Lock()
+	key := addr
+	v, ok := violations[key]
+	if !ok {
+		if len(violations) >= maxDestinations {
+			key = "other"
+			v = violations[key]
+		}
+		if v == nil {
+			v = &Violation{Addr: key}
+			violations[key] = v
+		}
+	}
+	v.Count++
+	if blocked {
+		v.Blocked++
+	}
+	v.LastSeen = now
+	var log bool
+	if now.Sub(v.lastLogged) >= logInterval {
+		v.lastLogged = now
+		log = true
+	}
+	count := v.Count
+	mu.Unlock()
+
+	if log {
+		logger.Warn("egress out of allowlist",
+			xlog.String("addr", addr),
+			xlog.Int64("count", count),
+			xlog.String("action", action),
+		)
+	}
+}
+
+// Violations returns destinations out of the allowlist, most called first
+func Violations() []Violation {
+	mu.Lock()
+	var list = make([]Violation, 0, len(violations))
+	for _, v := range violations {
+		list = append(list, *v)
+	}
+	mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Addr < list[j].Addr
+	})
+	return list
+}
+
+// Dial dials addr once the policy allows it, for dialers of clients, e.g.
+// grpc.WithContextDialer
+func Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial, err := Check(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, dial)
+}
+
+// NewTransport checks hosts of requests, with the default ports of their
+// schemes, before they're sent by next. Requests through proxies are
+// checked with their hosts rather than the proxies.
+func NewTransport(next http.RoundTripper) http.RoundTripper {
+	return roundTripper{next: next}
+}
+
+type roundTripper struct {
+	next http.RoundTripper
+}
+
+// RoundTrip ...
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		port := "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(req.URL.Hostname(), port)
+	}
+	if _, err := Check(req.Context(), addr); err != nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, err
+	}
+	return rt.next.RoundTrip(req)
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xegress
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setPolicy(t *testing.T, mode string, allow ...string) {
+	config := DefaultConfig()
+	config.Mode = mode
+	config.Allow = allow
+	assert.Nil(t, Set(config))
+}
+
+func TestCheck(t *testing.T) {
+	defer Set(DefaultConfig()) // nolint: errcheck
+	lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "internal.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("10.1.2.3")}}, nil
+		case "mixed.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("10.1.2.4")}, {IP: net.ParseIP("8.8.8.8")}}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host}
+	}
+	defer func() { lookup = net.DefaultResolver.LookupIPAddr }()
+
+	setPolicy(t, ModeBlock, "api.example.com:443", "*.pay.example.com", "10.0.0.0/8", "192.168.1.1:6379", "[::1]:80")
+	for addr, dial := range map[string]string{
+		"api.example.com:443":      "api.example.com:443",
+		"API.example.com.:443":     "API.example.com.:443",
+		"a.pay.example.com:8443":   "a.pay.example.com:8443",
+		"10.9.9.9:22":              "10.9.9.9:22",
+		"192.168.1.1:6379":         "192.168.1.1:6379",
+		"[::1]:80":                 "[::1]:80",
+		"internal.example.com:443": "10.1.2.3:443",
+	} {
+		got, err := Check(context.Background(), addr)
+		assert.Nil(t, err, addr)
+		assert.Equal(t, dial, got, addr)
+	}
+	for _, addr := range []string{
+		"api.example.com:80",
+		"pay.example.com:443",
+		"evil.com:443",
+		"192.168.1.1:22",
+		"[::1]:81",
+		"mixed.example.com:443",
+		"unknown.example.com:443",
+	} {
+		_, err := Check(context.Background(), addr)
+		assert.Equal(t, &Error{Addr: addr}, err, addr)
+	}
+	violations := Violations()
+	assert.Len(t, violations, 7)
+	assert.Equal(t, int64(1), violations[0].Blocked)
+
+	// audit mode lets violations go on
+	setPolicy(t, ModeAudit, "10.0.0.0/8")
+	got, err := Check(context.Background(), "evil.com:443")
+	assert.Nil(t, err)
+	assert.Equal(t, "evil.com:443", got)
+
+	// invalid policies are rejected, the current one is kept
+	assert.NotNil(t, Set(Config{Mode: "deny"}))
+	assert.NotNil(t, Set(Config{Mode: ModeBlock, Allow: []string{"10.0.0.0/33"}}))
+	assert.Equal(t, ModeAudit, current.Load().(*policy).config.Mode)
+}
+
+func TestNewTransport(t *testing.T) {
+	defer Set(DefaultConfig()) // nolint: errcheck
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+
+	setPolicy(t, ModeBlock, "10.0.0.0/8")
+	_, err := client.Get(server.URL)
+	assert.NotNil(t, err)
+
+	setPolicy(t, ModeBlock, "127.0.0.1")
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	resp.Body.Close()
+
+	conn, err := Dial(context.Background(), "tcp", server.Listener.Addr().String())
+	assert.Nil(t, err)
+	conn.Close()
+	setPolicy(t, ModeBlock)
+	_, err = Dial(context.Background(), "tcp", server.Listener.Addr().String())
+	assert.NotNil(t, err)
+}
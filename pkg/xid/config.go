@@ -0,0 +1,173 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xid
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/client/etcdv3"
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("xid", "jupiter.xid.*", "snowflake id generator", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Epoch of timestamps of ids in unix milliseconds, ids of generators
+	// sharing worker ids must be of the same Epoch, WorkerBits and
+	// SequenceBits
+	Epoch int64
+	// WorkerBits is the number of bits of worker ids
+	WorkerBits uint
+	// SequenceBits is the number of bits of sequences in a millisecond
+	SequenceBits uint
+	// WorkerID is the static worker id used if worker ids are not leased
+	// from etcd
+	WorkerID int64
+	// Registry is the name of the etcd registry, e.g. "wh01" of
+	// "jupiter.registry.wh01", whose etcd client config is reused to lease
+	// worker ids
+	Registry string
+	// ConfigKey is the key of the etcd client config to lease worker ids,
+	// e.g. "jupiter.etcdv3.default", it overrides Registry
+	ConfigKey string
+	// Prefix of worker keys in etcd, generators of the same prefix share
+	// worker ids so that their ids never conflict
+	Prefix string
+	// LeaseTTL of worker keys, ids aren't generated once the lease may have
+	// expired without being kept alive
+	LeaseTTL time.Duration
+	// SaveInterval is the interval of saving the time of the last id, which
+	// the next holder of the worker id starts after if the generator exits
+	// without releasing it, saving is off if zero
+	SaveInterval time.Duration
+	// MaxBackwards is the max clock rollback waited out, ids fail to be
+	// generated on longer rollbacks
+	MaxBackwards time.Duration
+	// Backoff of leasing a worker id again once it's lost
+	Backoff xbackoff.Config
+
+	name      string
+	logger    *xlog.Logger
+	clock     xtime.Clock
+	client    *etcdv3.Client
+	allocator WorkerAllocator
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		// 2020-01-01T00:00:00Z
+		Epoch:        1577836800000,
+		WorkerBits:   10,
+		SequenceBits: 12,
+		Prefix:       "/jupiter/xid",
+		LeaseTTL:     time.Second * 10,
+		SaveInterval: time.Second,
+		MaxBackwards: time.Millisecond * 10,
+		Backoff:      xbackoff.DefaultConfig(),
+		name:         "jupiter.xid.default",
+		logger:       xlog.JupiterLogger.With(xlog.FieldMod("xid")),
+		clock:        xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("jupiter.xid." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("xid parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	config.name = key
+	return config
+}
+
+// WithClient sets the etcd client leasing worker ids, e.g. the one of the
+// registry
+func (config *Config) WithClient(client *etcdv3.Client) *Config {
+	config.client = client
+	return config
+}
+
+// WithAllocator sets the allocator of worker ids
+func (config *Config) WithAllocator(allocator WorkerAllocator) *Config {
+	config.allocator = allocator
+	return config
+}
+
+// Build allocates a worker id and returns the generator of it
+func (config *Config) Build() *Generator {
+	if err := config.validate(); err != nil {
+		config.logger.Panic("xid build", xlog.FieldErr(err), xlog.FieldName(config.name))
+	}
+	if config.allocator == nil {
+		config.allocator = config.defaultAllocator()
+	}
+	g, err := newGenerator(config)
+	if err != nil {
+		config.logger.Panic("xid allocate worker id", xlog.FieldErr(err), xlog.FieldName(config.name))
+	}
+	return g
+}
+
+func (config *Config) defaultAllocator() WorkerAllocator {
+	switch {
+	case config.client != nil:
+	case config.ConfigKey != "":
+		config.client = etcdv3.RawConfig(config.ConfigKey).Build()
+	case config.Registry != "":
+		key := "jupiter.registry." + config.Registry
+		// the registry may refer to the etcd client config by key
+		if ref := conf.GetString(key + ".configKey"); ref != "" {
+			key = ref
+		}
+		config.client = etcdv3.RawConfig(key).Build()
+	default:
+		return StaticAllocator(config.WorkerID)
+	}
+	return &etcdAllocator{
+		client:   config.client,
+		prefix:   config.Prefix,
+		leaseTTL: config.LeaseTTL,
+		logger:   config.logger,
+		clock:    config.clock,
+	}
+}
+
+func (config *Config) validate() error {
+	if config.WorkerBits+config.SequenceBits > 22 {
+		return errBits
+	}
+	if config.WorkerID < 0 || config.WorkerID >= 1<<config.WorkerBits {
+		return errWorkerID
+	}
+	return nil
+}
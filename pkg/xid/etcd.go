@@ -0,0 +1,181 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xid
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/douyu/jupiter/pkg/client/etcdv3"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// etcdAllocator leases worker ids from etcd, <prefix>/workers/<id> is put
+// with a lease while the worker id is held, and <prefix>/last/<id> keeps the
+// time of the last id generated with it, which is saved periodically and
+// once it's released
+type etcdAllocator struct {
+	client   *etcdv3.Client
+	prefix   string
+	leaseTTL time.Duration
+	logger   *xlog.Logger
+	clock    xtime.Clock
+
+	mu     sync.Mutex
+	leases map[int64]leaseHolder
+}
+
+type leaseHolder struct {
+	id     clientv3.LeaseID
+	cancel context.CancelFunc
+}
+
+func (a *etcdAllocator) workerKey(id int64) string {
+	return fmt.Sprintf("%s/workers/%d", a.prefix, id)
+}
+
+func (a *etcdAllocator) lastKey(id int64) string {
+	return fmt.Sprintf("%s/last/%d", a.prefix, id)
+}
+
+// Allocate puts the first free worker key with a lease in a transaction
+func (a *etcdAllocator) Allocate(ctx context.Context, max int64) (*Worker, error) {
+	resp, err := a.client.Get(ctx, a.prefix+"/workers/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	var taken = make(map[string]bool, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		taken[string(kv.Key)] = true
+	}
+
+	granted := a.clock.Now()
+	lease, err := a.client.Grant(ctx, int64(a.leaseTTL/time.Second))
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	for id := int64(0); id < max; id++ {
+		key := a.workerKey(id)
+		if taken[key] {
+			continue
+		}
+		txn, err := a.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, holder, clientv3.WithLease(lease.ID)), clientv3.OpGet(a.lastKey(id))).
+			Commit()
+		if err != nil {
+			_, _ = a.client.Revoke(context.Background(), lease.ID)
+			return nil, err
+		}
+		if !txn.Succeeded {
+			continue
+		}
+		worker := &Worker{ID: id}
+		if kvs := txn.Responses[1].GetResponseRange().Kvs; len(kvs) > 0 {
+			if ms, err := strconv.ParseInt(string(kvs[0].Value), 10, 64); err == nil {
+				worker.LastUsed = time.Unix(0, ms*int64(time.Millisecond))
+			}
+		}
+		// the lease may expire in TTL since the grant was sent
+		worker.Renew(granted.Add(time.Duration(lease.TTL) * time.Second))
+		a.keepAlive(worker, lease.ID)
+		return worker, nil
+	}
+	_, _ = a.client.Revoke(context.Background(), lease.ID)
+	return nil, ErrNoWorkerID
+}
+
+// keepAlive keeps the lease alive until it's released, Expiry of worker is
+// extended to TTL since each keepalive request was sent, so that it never
+// outlives the lease, and Lost is closed once the lease is lost or expires
+func (a *etcdAllocator) keepAlive(worker *Worker, lease clientv3.LeaseID) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.mu.Lock()
+	if a.leases == nil {
+		a.leases = make(map[int64]leaseHolder)
+	}
+	a.leases[worker.ID] = leaseHolder{id: lease, cancel: cancel}
+	a.mu.Unlock()
+
+	lost := make(chan struct{})
+	worker.Lost = lost
+	interval := a.leaseTTL / 3
+	xgo.Go(func() {
+		defer close(lost)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.clock.After(interval):
+			}
+			sent := a.clock.Now()
+			kaCtx, kaCancel := context.WithTimeout(ctx, interval)
+			resp, err := a.client.KeepAliveOnce(kaCtx, lease)
+			kaCancel()
+			switch {
+			case ctx.Err() != nil:
+				return
+			case err == rpctypes.ErrLeaseNotFound:
+				a.logger.Error("xid lease not found", xlog.Int64("workerID", worker.ID))
+				return
+			case err != nil:
+				a.logger.Warn("xid keepalive", xlog.FieldErr(err), xlog.Int64("workerID", worker.ID))
+			default:
+				worker.Renew(sent.Add(time.Duration(resp.TTL) * time.Second))
+			}
+			if !a.clock.Now().Before(worker.Expiry()) {
+				a.logger.Error("xid lease expired", xlog.Int64("workerID", worker.ID))
+				return
+			}
+		}
+	})
+}
+
+// Save puts the time of the last id of the worker id
+func (a *etcdAllocator) Save(ctx context.Context, worker *Worker, lastUsed time.Time) error {
+	ms := lastUsed.UnixNano() / int64(time.Millisecond)
+	_, err := a.client.Put(ctx, a.lastKey(worker.ID), strconv.FormatInt(ms, 10))
+	return err
+}
+
+// Release saves the time of the last id and deletes the worker key by
+// revoking its lease
+func (a *etcdAllocator) Release(ctx context.Context, worker *Worker, lastUsed time.Time) error {
+	a.mu.Lock()
+	holder, ok := a.leases[worker.ID]
+	delete(a.leases, worker.ID)
+	a.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	holder.cancel()
+	if !lastUsed.IsZero() {
+		if err := a.Save(ctx, worker, lastUsed); err != nil {
+			a.logger.Warn("xid save last used", xlog.FieldErr(err), xlog.Int64("workerID", worker.ID))
+		}
+	}
+	_, err := a.client.Revoke(ctx, holder.id)
+	return err
+}
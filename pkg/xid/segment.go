@@ -0,0 +1,169 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xid
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+var errStep = errors.New("xid: non-positive segment step")
+
+var segmentCounter = metric.CounterVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "xid_segment_total",
+	Labels:    []string{"key", "code"},
+}.Build()
+
+// SegmentStore reserves segments of sequences, e.g. in etcd or redis
+type SegmentStore interface {
+	// Reserve reserves the next step sequences of key and returns the last
+	// of them, the first sequence of a key is 1
+	Reserve(ctx context.Context, key string, step int64) (int64, error)
+}
+
+// SegmentConfig ...
+type SegmentConfig struct {
+	// Step is the number of sequences reserved at a time
+	Step int64
+	// Prefetch is the ratio of the current segment consumed, after which
+	// the next segment is reserved in background
+	Prefetch float64
+	// Timeout of reserving a segment
+	Timeout time.Duration
+
+	logger *xlog.Logger
+}
+
+// DefaultSegmentConfig ...
+func DefaultSegmentConfig() SegmentConfig {
+	return SegmentConfig{
+		Step:     1000,
+		Prefetch: 0.1,
+		Timeout:  time.Second * 3,
+		logger:   xlog.JupiterLogger.With(xlog.FieldMod("xid")),
+	}
+}
+
+// Build returns the sequence of key reserved from store
+func (config SegmentConfig) Build(store SegmentStore, key string) *Sequence {
+	if config.logger == nil {
+		config.logger = xlog.JupiterLogger.With(xlog.FieldMod("xid"))
+	}
+	if config.Step <= 0 {
+		config.logger.Panic("xid segment", xlog.FieldErr(errStep), xlog.FieldKey(key))
+	}
+	return &Sequence{config: config, store: store, key: key}
+}
+
+// Sequence hands out monotonically increasing sequences of a key from
+// segments reserved from the store, instances of the same key share the
+// store and never hand out the same sequence, but sequences of different
+// instances interleave, and the rest of segments is skipped on restarts
+type Sequence struct {
+	config SegmentConfig
+	store  SegmentStore
+	key    string
+
+	mu sync.Mutex
+	// cur is the next sequence of the current segment ending at end
+	cur, end int64
+	// next is the prefetched segment
+	next    *segment
+	loading *loading
+}
+
+type segment struct {
+	start, end int64
+}
+
+type loading struct {
+	done chan struct{}
+	err  error
+}
+
+// Next returns the next sequence, it blocks if the current segment runs out
+// before the next one is reserved
+func (s *Sequence) Next(ctx context.Context) (int64, error) {
+	for {
+		s.mu.Lock()
+		if s.cur > 0 && s.cur <= s.end {
+			seq := s.cur
+			s.cur++
+			if s.next == nil && s.loading == nil && s.consumed() >= s.config.Prefetch {
+				s.load()
+			}
+			s.mu.Unlock()
+			return seq, nil
+		}
+		if s.next != nil {
+			s.cur, s.end = s.next.start, s.next.end
+			s.next = nil
+			s.mu.Unlock()
+			continue
+		}
+		l := s.loading
+		if l == nil {
+			l = s.load()
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		if l.err != nil {
+			return 0, l.err
+		}
+	}
+}
+
+// consumed returns the ratio of the current segment consumed, s.mu must be
+// held
+func (s *Sequence) consumed() float64 {
+	return 1 - float64(s.end-s.cur+1)/float64(s.config.Step)
+}
+
+// load reserves the next segment in background, s.mu must be held
+func (s *Sequence) load() *loading {
+	l := &loading{done: make(chan struct{})}
+	s.loading = l
+	xgo.Go(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.Timeout)
+		end, err := s.store.Reserve(ctx, s.key, s.config.Step)
+		cancel()
+
+		s.mu.Lock()
+		if err == nil {
+			s.next = &segment{start: end - s.config.Step + 1, end: end}
+			segmentCounter.Inc(s.key, "OK")
+		} else {
+			s.config.logger.Error("reserve segment", xlog.FieldErr(err), xlog.FieldKey(s.key))
+			segmentCounter.Inc(s.key, "Error")
+		}
+		l.err = err
+		s.loading = nil
+		s.mu.Unlock()
+		close(l.done)
+	})
+	return l
+}
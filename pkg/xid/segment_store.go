@@ -0,0 +1,72 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xid
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/douyu/jupiter/pkg/client/etcdv3"
+	"github.com/douyu/jupiter/pkg/client/redis"
+)
+
+// EtcdSegmentStore reserves segments by compare-and-swap of <Prefix><key>
+type EtcdSegmentStore struct {
+	Client *etcdv3.Client
+	// Prefix of keys, e.g. "/jupiter/xid/segment/"
+	Prefix string
+}
+
+// Reserve ...
+func (store EtcdSegmentStore) Reserve(ctx context.Context, key string, step int64) (int64, error) {
+	key = store.Prefix + key
+	for {
+		resp, err := store.Client.Get(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		var last int64
+		var cmp = clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+		if len(resp.Kvs) > 0 {
+			if last, err = strconv.ParseInt(string(resp.Kvs[0].Value), 10, 64); err != nil {
+				return 0, err
+			}
+			cmp = clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)
+		}
+		end := last + step
+		txn, err := store.Client.Txn(ctx).If(cmp).Then(clientv3.OpPut(key, strconv.FormatInt(end, 10))).Commit()
+		if err != nil {
+			return 0, err
+		}
+		// retries once others reserved in the meantime
+		if txn.Succeeded {
+			return end, nil
+		}
+	}
+}
+
+// RedisSegmentStore reserves segments by INCRBY of <Prefix><key>, the redis
+// should be persistent, or sequences may go backwards after failovers
+type RedisSegmentStore struct {
+	Redis *redis.Redis
+	// Prefix of keys, e.g. "xid:segment:"
+	Prefix string
+}
+
+// Reserve ...
+func (store RedisSegmentStore) Reserve(ctx context.Context, key string, step int64) (int64, error) {
+	return store.Redis.IncrBy(store.Prefix+key, step)
+}
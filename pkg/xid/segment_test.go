@@ -0,0 +1,150 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xid
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memorySegmentStore struct {
+	mu       sync.Mutex
+	last     map[string]int64
+	reserved int
+	err      error
+	block    chan struct{}
+}
+
+func (store *memorySegmentStore) Reserve(ctx context.Context, key string, step int64) (int64, error) {
+	if store.block != nil {
+		<-store.block
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if store.err != nil {
+		return 0, store.err
+	}
+	if store.last == nil {
+		store.last = make(map[string]int64)
+	}
+	store.last[key] += step
+	store.reserved++
+	return store.last[key], nil
+}
+
+func (store *memorySegmentStore) count() int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.reserved
+}
+
+func newTestSequence(store SegmentStore) *Sequence {
+	config := DefaultSegmentConfig()
+	config.Step = 10
+	config.Prefetch = 0.5
+	return config.Build(store, "order")
+}
+
+func TestSequence_Next(t *testing.T) {
+	store := &memorySegmentStore{}
+	a, b := newTestSequence(store), newTestSequence(store)
+
+	for i := int64(1); i <= 5; i++ {
+		seq, err := a.Next(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, i, seq)
+	}
+	// the next segment is prefetched once half of the current one is used
+	assert.Eventually(t, func() bool { return store.count() == 2 }, time.Second, time.Millisecond)
+
+	// the other instance reserves after the prefetched one
+	seq, err := b.Next(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(21), seq)
+
+	for i := int64(6); i <= 20; i++ {
+		seq, err := a.Next(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, i, seq)
+	}
+	seq, err = a.Next(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(31), seq)
+}
+
+func TestSequence_Concurrent(t *testing.T) {
+	store := &memorySegmentStore{}
+	s := newTestSequence(store)
+	var mu sync.Mutex
+	var seen = make(map[int64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last int64
+			for j := 0; j < 100; j++ {
+				seq, err := s.Next(context.Background())
+				assert.Nil(t, err)
+				assert.True(t, seq > last)
+				last = seq
+				mu.Lock()
+				assert.False(t, seen[seq], seq)
+				seen[seq] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, seen, 800)
+}
+
+func TestSequence_Error(t *testing.T) {
+	store := &memorySegmentStore{err: errors.New("unavailable")}
+	s := newTestSequence(store)
+	_, err := s.Next(context.Background())
+	assert.EqualError(t, err, "unavailable")
+
+	store.mu.Lock()
+	store.err = nil
+	store.block = make(chan struct{})
+	store.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err = s.Next(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(store.block)
+	seq, err := s.Next(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), seq)
+}
+
+func TestSegmentConfig_Build(t *testing.T) {
+	// the zero config has no logger, it panics with the default one
+	defer func() {
+		r := recover()
+		assert.NotNil(t, r)
+		_, crashed := r.(runtime.Error)
+		assert.False(t, crashed, r)
+	}()
+	SegmentConfig{}.Build(&memorySegmentStore{}, "order")
+}
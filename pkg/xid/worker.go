@@ -0,0 +1,85 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xid
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Worker is a worker id leased by a generator
+type Worker struct {
+	ID int64
+	// Lost is closed once the worker id is lost, e.g. the lease expired,
+	// nil if it's never lost
+	Lost <-chan struct{}
+	// LastUsed is the time of the last id generated by the previous holder
+	// of the worker id, ids are generated after it
+	LastUsed time.Time
+
+	// expiry is unix nanoseconds the worker id may be lost at, 0 if never
+	expiry int64
+}
+
+// Expiry returns the time the worker id may be lost at, e.g. the lease
+// expires without being kept alive, ids aren't generated with it since
+// then, zero if it's never lost
+func (w *Worker) Expiry() time.Time {
+	if expiry := atomic.LoadInt64(&w.expiry); expiry > 0 {
+		return time.Unix(0, expiry)
+	}
+	return time.Time{}
+}
+
+// Renew extends Expiry of the worker id, e.g. once its lease is kept alive
+func (w *Worker) Renew(expiry time.Time) {
+	atomic.StoreInt64(&w.expiry, expiry.UnixNano())
+}
+
+// WorkerAllocator allocates worker ids to generators
+type WorkerAllocator interface {
+	// Allocate leases a worker id below max
+	Allocate(ctx context.Context, max int64) (*Worker, error)
+	// Save saves the time of the last id generated with the worker id while
+	// it's held, which is LastUsed of the next holder if the generator exits
+	// without releasing it
+	Save(ctx context.Context, worker *Worker, lastUsed time.Time) error
+	// Release releases the worker id, lastUsed is the time of the last id
+	// generated with it
+	Release(ctx context.Context, worker *Worker, lastUsed time.Time) error
+}
+
+// StaticAllocator allocates the worker id itself, which should be unique
+// among instances, e.g. the ordinal of a statefulset
+type StaticAllocator int64
+
+// Allocate ...
+func (id StaticAllocator) Allocate(ctx context.Context, max int64) (*Worker, error) {
+	if int64(id) < 0 || int64(id) >= max {
+		return nil, errWorkerID
+	}
+	return &Worker{ID: int64(id)}, nil
+}
+
+// Save ...
+func (id StaticAllocator) Save(context.Context, *Worker, time.Time) error {
+	return nil
+}
+
+// Release ...
+func (id StaticAllocator) Release(context.Context, *Worker, time.Time) error {
+	return nil
+}
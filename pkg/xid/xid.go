@@ -0,0 +1,285 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xid generates snowflake-style unique ids of 63 bits, which are
+// composed of milliseconds since Epoch, the worker id and a sequence in the
+// millisecond. Worker ids are static or leased from etcd, so that instances
+// never generate ids with the same worker id at the same time.
+package xid
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+var (
+	// ErrClockBackwards is returned if the clock moved backwards longer than
+	// MaxBackwards
+	ErrClockBackwards = errors.New("xid: clock moved backwards")
+	// ErrWorkerLost is returned once the lease of the worker id is lost or
+	// may have expired, until a new worker id is leased
+	ErrWorkerLost = errors.New("xid: worker id lost")
+	// ErrNoWorkerID is returned if all worker ids are leased
+	ErrNoWorkerID = errors.New("xid: no worker id available")
+	// ErrClosed is returned once the generator is closed
+	ErrClosed = errors.New("xid: generator closed")
+
+	errBits     = errors.New("xid: more than 22 bits of worker ids and sequences")
+	errWorkerID = errors.New("xid: worker id out of range")
+)
+
+var (
+	generateCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "xid_generate_total",
+		Labels:    []string{"name"},
+	}.Build()
+	backwardsCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "xid_clock_backwards_total",
+		Labels:    []string{"name"},
+	}.Build()
+	workerGauge = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "xid_worker_id",
+		Labels:    []string{"name"},
+	}.Build()
+)
+
+// Parts of an id
+type Parts struct {
+	Time     time.Time
+	WorkerID int64
+	Sequence int64
+}
+
+// Generator ...
+type Generator struct {
+	*Config
+
+	mu       sync.Mutex
+	worker   *Worker
+	lastTime int64
+	sequence int64
+	closed   bool
+
+	stop chan struct{}
+}
+
+func newGenerator(config *Config) (*Generator, error) {
+	g := &Generator{Config: config, stop: make(chan struct{})}
+	worker, err := g.allocator.Allocate(context.Background(), 1<<g.WorkerBits)
+	if err != nil {
+		return nil, err
+	}
+	g.use(worker)
+	xgo.Go(g.watch)
+	if g.SaveInterval > 0 {
+		xgo.Go(g.persist)
+	}
+	return g, nil
+}
+
+// NextID returns the next id, it blocks if ids of the current millisecond
+// run out, or the clock moved backwards within MaxBackwards
+func (g *Generator) NextID() (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return 0, ErrClosed
+	}
+	if g.worker == nil {
+		return 0, ErrWorkerLost
+	}
+	// ids may conflict with those of the next holder once the lease expires
+	if expiry := g.worker.Expiry(); !expiry.IsZero() && !g.clock.Now().Before(expiry) {
+		return 0, ErrWorkerLost
+	}
+
+	now := g.now()
+	if now < g.lastTime {
+		backwardsCounter.Inc(g.name)
+		backwards := time.Duration(g.lastTime-now) * time.Millisecond
+		if backwards > g.MaxBackwards {
+			g.logger.Error("clock moved backwards", xlog.FieldName(g.name), xlog.Duration("backwards", backwards))
+			return 0, ErrClockBackwards
+		}
+		now = g.waitUntil(g.lastTime)
+	}
+	if now == g.lastTime {
+		g.sequence = (g.sequence + 1) & (1<<g.SequenceBits - 1)
+		// ids of this millisecond run out
+		if g.sequence == 0 {
+			now = g.waitUntil(g.lastTime + 1)
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastTime = now
+	generateCounter.Inc(g.name)
+	return now<<(g.WorkerBits+g.SequenceBits) | g.worker.ID<<g.SequenceBits | g.sequence, nil
+}
+
+// Parse returns parts of id generated by g
+func (g *Generator) Parse(id int64) Parts {
+	return Parts{
+		Time:     time.Unix(0, (id>>(g.WorkerBits+g.SequenceBits)+g.Epoch)*int64(time.Millisecond)),
+		WorkerID: id >> g.SequenceBits & (1<<g.WorkerBits - 1),
+		Sequence: id & (1<<g.SequenceBits - 1),
+	}
+}
+
+// WorkerID returns the current worker id, -1 if it's lost
+func (g *Generator) WorkerID() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.worker == nil {
+		return -1
+	}
+	return g.worker.ID
+}
+
+// Close stops generating ids and releases the worker id
+func (g *Generator) Close() error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return nil
+	}
+	g.closed = true
+	worker, lastTime := g.worker, g.lastTime
+	g.worker = nil
+	g.mu.Unlock()
+
+	close(g.stop)
+	if worker == nil {
+		return nil
+	}
+	return g.allocator.Release(context.Background(), worker, g.toTime(lastTime))
+}
+
+// now returns milliseconds since Epoch
+func (g *Generator) now() int64 {
+	return g.clock.Now().UnixNano()/int64(time.Millisecond) - g.Epoch
+}
+
+func (g *Generator) toTime(ms int64) time.Time {
+	return time.Unix(0, (ms+g.Epoch)*int64(time.Millisecond))
+}
+
+// waitUntil sleeps until ms and returns now
+func (g *Generator) waitUntil(ms int64) int64 {
+	now := g.now()
+	for now < ms {
+		g.clock.Sleep(time.Duration(ms-now) * time.Millisecond)
+		now = g.now()
+	}
+	return now
+}
+
+// use starts generating ids with worker, ids are generated after the last
+// id of the previous holder of the worker id
+func (g *Generator) use(worker *Worker) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.worker = worker
+	if last := worker.LastUsed.UnixNano()/int64(time.Millisecond) - g.Epoch; last > g.lastTime {
+		g.lastTime, g.sequence = last, 1<<g.SequenceBits-1
+	}
+	workerGauge.Set(float64(worker.ID), g.name)
+	g.logger.Info("xid worker id", xlog.FieldName(g.name), xlog.Int64("workerID", worker.ID))
+}
+
+// persist saves the time of the last id periodically, so that the next
+// holder of the worker id starts after it even if g exits without Close
+func (g *Generator) persist() {
+	var saved int64
+	var savedWorker *Worker
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-g.clock.After(g.SaveInterval):
+		}
+		g.mu.Lock()
+		worker, lastTime := g.worker, g.lastTime
+		g.mu.Unlock()
+		if worker == nil || worker == savedWorker && lastTime == saved {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), g.SaveInterval)
+		err := g.allocator.Save(ctx, worker, g.toTime(lastTime))
+		cancel()
+		if err != nil {
+			g.logger.Warn("xid save last used", xlog.FieldErr(err), xlog.FieldName(g.name), xlog.Int64("workerID", worker.ID))
+			continue
+		}
+		saved, savedWorker = lastTime, worker
+	}
+}
+
+// watch leases a new worker id once the current one is lost
+func (g *Generator) watch() {
+	for {
+		g.mu.Lock()
+		worker := g.worker
+		g.mu.Unlock()
+		if worker == nil {
+			return
+		}
+		select {
+		case <-g.stop:
+			return
+		case <-worker.Lost:
+		}
+
+		g.mu.Lock()
+		if g.closed {
+			g.mu.Unlock()
+			return
+		}
+		g.worker = nil
+		g.mu.Unlock()
+		workerGauge.Set(-1, g.name)
+		g.logger.Error("xid worker id lost", xlog.FieldName(g.name), xlog.Int64("workerID", worker.ID))
+
+		for retries := 0; ; retries++ {
+			select {
+			case <-g.stop:
+				return
+			case <-g.clock.After(g.Backoff.Backoff(retries)):
+			}
+			next, err := g.allocator.Allocate(context.Background(), 1<<g.WorkerBits)
+			if err != nil {
+				g.logger.Error("xid allocate worker id", xlog.FieldErr(err), xlog.FieldName(g.name), xlog.Int("retries", retries))
+				continue
+			}
+			g.mu.Lock()
+			closed := g.closed
+			g.mu.Unlock()
+			if closed {
+				_ = g.allocator.Release(context.Background(), next, time.Time{})
+				return
+			}
+			g.use(next)
+			break
+		}
+	}
+}
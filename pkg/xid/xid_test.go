@@ -0,0 +1,226 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xid
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xbackoff"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+var epoch = time.Unix(1577836800, 0)
+
+func newTestGenerator(clock xtime.Clock, allocator WorkerAllocator) *Generator {
+	config := DefaultConfig()
+	config.SequenceBits = 2
+	config.Backoff = xbackoff.Config{BaseDelay: time.Second}
+	config.SaveInterval = 0
+	config.clock = clock
+	return config.WithAllocator(allocator).Build()
+}
+
+func TestGenerator_NextID(t *testing.T) {
+	clock := xtime.NewMockClock(epoch.Add(time.Hour))
+	g := newTestGenerator(clock, StaticAllocator(5))
+	defer g.Close()
+
+	var ids []int64
+	for i := 0; i < 4; i++ {
+		id, err := g.NextID()
+		assert.Nil(t, err)
+		ids = append(ids, id)
+	}
+	assert.Equal(t, Parts{Time: clock.Now(), WorkerID: 5, Sequence: 3}, g.Parse(ids[3]))
+
+	// sequences of the millisecond run out
+	next := make(chan int64)
+	go func() {
+		id, _ := g.NextID()
+		next <- id
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Millisecond)
+	id := <-next
+	assert.True(t, id > ids[3])
+	assert.Equal(t, Parts{Time: clock.Now(), WorkerID: 5, Sequence: 0}, g.Parse(id))
+}
+
+// rollbackClock is a mock clock which can be moved backwards
+type rollbackClock struct {
+	*xtime.MockClock
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *rollbackClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.MockClock.Now().Add(c.offset)
+}
+
+func (c *rollbackClock) rollback(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset -= d
+}
+
+func TestGenerator_ClockBackwards(t *testing.T) {
+	clock := &rollbackClock{MockClock: xtime.NewMockClock(epoch.Add(time.Hour))}
+	g := newTestGenerator(clock, StaticAllocator(0))
+	defer g.Close()
+
+	last, err := g.NextID()
+	assert.Nil(t, err)
+
+	// waited out within MaxBackwards
+	clock.rollback(time.Millisecond * 5)
+	next := make(chan int64)
+	go func() {
+		id, _ := g.NextID()
+		next <- id
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Millisecond * 5)
+	assert.True(t, <-next > last)
+
+	clock.rollback(time.Second)
+	_, err = g.NextID()
+	assert.Equal(t, ErrClockBackwards, err)
+}
+
+type fakeAllocator struct {
+	mu       sync.Mutex
+	next     int64
+	lost     chan struct{}
+	lastUsed time.Time
+	expiry   time.Time
+	saved    time.Time
+	released []int64
+}
+
+func (a *fakeAllocator) Allocate(ctx context.Context, max int64) (*Worker, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lost = make(chan struct{})
+	a.next++
+	worker := &Worker{ID: a.next, Lost: a.lost, LastUsed: a.lastUsed}
+	if !a.expiry.IsZero() {
+		worker.Renew(a.expiry)
+	}
+	return worker, nil
+}
+
+func (a *fakeAllocator) Save(ctx context.Context, worker *Worker, lastUsed time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.saved = lastUsed
+	return nil
+}
+
+func (a *fakeAllocator) Release(ctx context.Context, worker *Worker, lastUsed time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.released = append(a.released, worker.ID)
+	return nil
+}
+
+func (a *fakeAllocator) lose() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	close(a.lost)
+}
+
+func TestGenerator_WorkerLost(t *testing.T) {
+	clock := xtime.NewMockClock(epoch.Add(time.Hour))
+	allocator := &fakeAllocator{}
+	g := newTestGenerator(clock, allocator)
+	assert.Equal(t, int64(1), g.WorkerID())
+
+	allocator.mu.Lock()
+	allocator.lastUsed = clock.Now().Add(time.Second + time.Millisecond*3)
+	allocator.mu.Unlock()
+	allocator.lose()
+	assert.Eventually(t, func() bool { return g.WorkerID() == -1 }, time.Second, time.Millisecond)
+	_, err := g.NextID()
+	assert.Equal(t, ErrWorkerLost, err)
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return g.WorkerID() == 2 }, time.Second, time.Millisecond)
+
+	// ids are generated after the last one of the previous holder
+	next := make(chan int64)
+	go func() {
+		id, _ := g.NextID()
+		next <- id
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Millisecond * 4)
+	parts := g.Parse(<-next)
+	assert.Equal(t, clock.Now(), parts.Time)
+	assert.Equal(t, int64(2), parts.WorkerID)
+
+	assert.Nil(t, g.Close())
+	assert.Equal(t, []int64{2}, allocator.released)
+	_, err = g.NextID()
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestGenerator_LeaseExpiry(t *testing.T) {
+	clock := xtime.NewMockClock(epoch.Add(time.Hour))
+	allocator := &fakeAllocator{expiry: epoch.Add(time.Hour + time.Second)}
+	g := newTestGenerator(clock, allocator)
+	defer g.Close()
+
+	_, err := g.NextID()
+	assert.Nil(t, err)
+	// not renewed
+	clock.Advance(time.Second)
+	_, err = g.NextID()
+	assert.Equal(t, ErrWorkerLost, err)
+}
+
+func TestGenerator_SaveLastUsed(t *testing.T) {
+	clock := xtime.NewMockClock(epoch.Add(time.Hour))
+	allocator := &fakeAllocator{}
+	config := DefaultConfig()
+	config.clock = clock
+	g := config.WithAllocator(allocator).Build()
+	defer g.Close()
+
+	id, err := g.NextID()
+	assert.Nil(t, err)
+	clock.BlockUntil(1)
+	clock.Advance(config.SaveInterval)
+	assert.Eventually(t, func() bool {
+		allocator.mu.Lock()
+		defer allocator.mu.Unlock()
+		return allocator.saved.Equal(g.Parse(id).Time)
+	}, time.Second, time.Millisecond)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	config := DefaultConfig()
+	assert.Nil(t, config.validate())
+	config.WorkerBits = 12
+	assert.Equal(t, errBits, config.validate())
+	config.WorkerBits, config.WorkerID = 10, 1024
+	assert.Equal(t, errWorkerID, config.validate())
+}
@@ -19,10 +19,15 @@ import (
 	"time"
 
 	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/xschema"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+func init() {
+	xschema.RegisterConfig("logger", "jupiter.logger.*", "logger", DefaultConfig)
+}
+
 // Config ...
 type Config struct {
 	// Dir 日志输出目录
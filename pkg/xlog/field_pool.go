@@ -0,0 +1,66 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xlog
+
+import "sync"
+
+const defaultFieldBuilderCap = 16
+
+var fieldBuilderPool = sync.Pool{
+	New: func() interface{} {
+		return &FieldBuilder{fields: make([]Field, 0, defaultFieldBuilderCap)}
+	},
+}
+
+// FieldBuilder is a reusable field slice for hot paths like interceptors,
+// acquire it by GetFieldBuilder and give it back by Release once the log
+// line has been written.
+type FieldBuilder struct {
+	fields []Field
+}
+
+// GetFieldBuilder returns an empty FieldBuilder from pool
+func GetFieldBuilder() *FieldBuilder {
+	return fieldBuilderPool.Get().(*FieldBuilder)
+}
+
+// Add appends fields
+func (fb *FieldBuilder) Add(fields ...Field) *FieldBuilder {
+	fb.fields = append(fb.fields, fields...)
+	return fb
+}
+
+// Fields returns the fields added so far, the slice is only valid until Release
+func (fb *FieldBuilder) Fields() []Field {
+	return fb.fields
+}
+
+// Len ...
+func (fb *FieldBuilder) Len() int {
+	return len(fb.fields)
+}
+
+// Release resets the builder and puts it back to pool
+func (fb *FieldBuilder) Release() {
+	// drop oversized builders so one huge log line doesn't pin memory forever
+	if cap(fb.fields) > 4*defaultFieldBuilderCap {
+		return
+	}
+	for i := range fb.fields {
+		fb.fields[i] = Field{}
+	}
+	fb.fields = fb.fields[:0]
+	fieldBuilderPool.Put(fb)
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldBuilder(t *testing.T) {
+	fb := GetFieldBuilder()
+	fb.Add(String("a", "b")).Add(Int("c", 1), FieldCost(time.Second))
+	assert.Equal(t, 3, fb.Len())
+	assert.Equal(t, "a", fb.Fields()[0].Key)
+	fb.Release()
+
+	fb = GetFieldBuilder()
+	assert.Equal(t, 0, fb.Len())
+	fb.Release()
+}
+
+func BenchmarkFields(b *testing.B) {
+	b.Run("make slice", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var fields = make([]Field, 0, 8)
+			for j := 0; j < 10; j++ {
+				fields = append(fields, String("key", "value"))
+			}
+			_ = fields
+		}
+	})
+	b.Run("field builder", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fb := GetFieldBuilder()
+			for j := 0; j < 10; j++ {
+				fb.Add(String("key", "value"))
+			}
+			fb.Release()
+		}
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xlog
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// CheckedEntry ...
+type CheckedEntry = zapcore.CheckedEntry
+
+// FieldsFunc builds log fields lazily, it's called only if the level is enabled
+type FieldsFunc func() []Field
+
+type stringerFunc func() string
+
+// String ...
+func (fn stringerFunc) String() string { return fn() }
+
+// LazyString returns a field whose value is computed only when the entry is encoded
+func LazyString(key string, fn func() string) Field {
+	return zap.Stringer(key, stringerFunc(fn))
+}
+
+// Check returns a CheckedEntry if logging a message at the specified level
+// is enabled, nil otherwise. Typical usage:
+//
+//	if ce := logger.Check(xlog.DebugLevel, "msg"); ce != nil {
+//		ce.Write(xlog.Any("req", req))
+//	}
+func (logger *Logger) Check(lv Level, msg string) *CheckedEntry {
+	if !logger.Enabled(lv) {
+		return nil
+	}
+	if logger.IsDebugMode() {
+		msg = normalizeMessage(msg)
+	}
+	return logger.desugar.Check(lv, msg)
+}
+
+// DebugFn logs at debug level, fields are built only if debug level is enabled
+func (logger *Logger) DebugFn(msg string, fn FieldsFunc) {
+	// desugar is checked right here rather than by a helper, so that the
+	// caller is skipped as many frames as by Debug, so are the others
+	if !logger.Enabled(DebugLevel) {
+		return
+	}
+	if logger.IsDebugMode() {
+		msg = normalizeMessage(msg)
+	}
+	if ce := logger.desugar.Check(DebugLevel, msg); ce != nil {
+		ce.Write(fn()...)
+	}
+}
+
+// InfoFn logs at info level, fields are built only if info level is enabled
+func (logger *Logger) InfoFn(msg string, fn FieldsFunc) {
+	if !logger.Enabled(InfoLevel) {
+		return
+	}
+	if logger.IsDebugMode() {
+		msg = normalizeMessage(msg)
+	}
+	if ce := logger.desugar.Check(InfoLevel, msg); ce != nil {
+		ce.Write(fn()...)
+	}
+}
+
+// WarnFn logs at warn level, fields are built only if warn level is enabled
+func (logger *Logger) WarnFn(msg string, fn FieldsFunc) {
+	if !logger.Enabled(WarnLevel) {
+		return
+	}
+	if logger.IsDebugMode() {
+		msg = normalizeMessage(msg)
+	}
+	if ce := logger.desugar.Check(WarnLevel, msg); ce != nil {
+		ce.Write(fn()...)
+	}
+}
+
+// ErrorFn logs at error level, fields are built only if error level is enabled
+func (logger *Logger) ErrorFn(msg string, fn FieldsFunc) {
+	if !logger.Enabled(ErrorLevel) {
+		return
+	}
+	if logger.IsDebugMode() {
+		msg = normalizeMessage(msg)
+	}
+	if ce := logger.desugar.Check(ErrorLevel, msg); ce != nil {
+		ce.Write(fn()...)
+	}
+}
+
+// Check ...
+func Check(lv Level, msg string) *CheckedEntry {
+	return DefaultLogger.Check(lv, msg)
+}
+
+// DebugFn ...
+func DebugFn(msg string, fn FieldsFunc) {
+	DefaultLogger.DebugFn(msg, fn)
+}
+
+// InfoFn ...
+func InfoFn(msg string, fn FieldsFunc) {
+	DefaultLogger.InfoFn(msg, fn)
+}
+
+// WarnFn ...
+func WarnFn(msg string, fn FieldsFunc) {
+	DefaultLogger.WarnFn(msg, fn)
+}
+
+// ErrorFn ...
+func ErrorFn(msg string, fn FieldsFunc) {
+	DefaultLogger.ErrorFn(msg, fn)
+}
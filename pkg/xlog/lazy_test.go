@@ -0,0 +1,105 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xlog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+)
+
+func newTestLogger(buf *bytes.Buffer, lv Level) *Logger {
+	config := DefaultConfig()
+	config.Debug = true
+	config.Core = zapcore.NewCore(zapcore.NewJSONEncoder(*DefaultZapConfig()), zapcore.AddSync(buf), lv)
+	return config.Build()
+}
+
+func TestLazyFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newTestLogger(&buf, InfoLevel)
+
+	var called int
+	logger.DebugFn("debug", func() []Field {
+		called++
+		return []Field{String("k", "v")}
+	})
+	logger.Debug("debug", LazyString("k", func() string {
+		called++
+		return "v"
+	}))
+	assert.Equal(t, 0, called)
+	assert.Nil(t, logger.Check(DebugLevel, "debug"))
+	assert.Equal(t, 0, buf.Len())
+
+	logger.InfoFn("info", func() []Field {
+		called++
+		return []Field{String("k", "v")}
+	})
+	logger.Info("info", LazyString("lazy", func() string {
+		called++
+		return "lazy value"
+	}))
+	assert.Equal(t, 2, called)
+	assert.Contains(t, buf.String(), `"k":"v"`)
+	assert.Contains(t, buf.String(), `"lazy":"lazy value"`)
+}
+
+func TestLazyFields_Caller(t *testing.T) {
+	var buf bytes.Buffer
+	config := DefaultConfig()
+	config.AddCaller = true
+	config.Core = zapcore.NewCore(zapcore.NewJSONEncoder(*DefaultZapConfig()), zapcore.AddSync(&buf), DebugLevel)
+	logger := config.Build()
+
+	logger.InfoFn("info", func() []Field { return nil })
+	assert.Contains(t, buf.String(), `"caller":"xlog/lazy_test.go:`)
+	buf.Reset()
+	if ce := logger.Check(InfoLevel, "info"); ce != nil {
+		ce.Write()
+	}
+	assert.Contains(t, buf.String(), `"caller":"xlog/lazy_test.go:`)
+}
+
+func BenchmarkDisabledLevel(b *testing.B) {
+	var buf bytes.Buffer
+	logger := newTestLogger(&buf, InfoLevel)
+	req := map[string]string{"hello": "world"}
+
+	b.Run("Debugf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.Debugf("req %v", req)
+		}
+	})
+	b.Run("Check", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if ce := logger.Check(DebugLevel, "req"); ce != nil {
+				ce.Write(Any("req", req))
+			}
+		}
+	})
+	b.Run("DebugFn", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			logger.DebugFn("req", func() []Field {
+				return []Field{Any("req", req)}
+			})
+		}
+	})
+}
@@ -208,6 +208,12 @@ func timeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 	enc.AppendInt64(t.Unix())
 }
 
+// Enabled reports whether the given level is enabled,
+// guard expensive log arguments with it
+func (logger *Logger) Enabled(lv Level) bool {
+	return logger.desugar.Core().Enabled(lv)
+}
+
 // IsDebugMode ...
 func (logger *Logger) IsDebugMode() bool {
 	return logger.config.Debug
@@ -219,6 +225,9 @@ func normalizeMessage(msg string) string {
 
 // Debug ...
 func (logger *Logger) Debug(msg string, fields ...Field) {
+	if !logger.Enabled(DebugLevel) {
+		return
+	}
 	if logger.IsDebugMode() {
 		msg = normalizeMessage(msg)
 	}
@@ -227,6 +236,9 @@ func (logger *Logger) Debug(msg string, fields ...Field) {
 
 // Debugw ...
 func (logger *Logger) Debugw(msg string, keysAndValues ...interface{}) {
+	if !logger.Enabled(DebugLevel) {
+		return
+	}
 	if logger.IsDebugMode() {
 		msg = normalizeMessage(msg)
 	}
@@ -250,11 +262,17 @@ func (logger *Logger) StdLog() *log.Logger {
 
 // Debugf ...
 func (logger *Logger) Debugf(template string, args ...interface{}) {
+	if !logger.Enabled(DebugLevel) {
+		return
+	}
 	logger.sugar.Debugw(sprintf(template, args...))
 }
 
 // Info ...
 func (logger *Logger) Info(msg string, fields ...Field) {
+	if !logger.Enabled(InfoLevel) {
+		return
+	}
 	if logger.IsDebugMode() {
 		msg = normalizeMessage(msg)
 	}
@@ -263,6 +281,9 @@ func (logger *Logger) Info(msg string, fields ...Field) {
 
 // Infow ...
 func (logger *Logger) Infow(msg string, keysAndValues ...interface{}) {
+	if !logger.Enabled(InfoLevel) {
+		return
+	}
 	if logger.IsDebugMode() {
 		msg = normalizeMessage(msg)
 	}
@@ -271,11 +292,17 @@ func (logger *Logger) Infow(msg string, keysAndValues ...interface{}) {
 
 // Infof ...
 func (logger *Logger) Infof(template string, args ...interface{}) {
+	if !logger.Enabled(InfoLevel) {
+		return
+	}
 	logger.sugar.Infof(sprintf(template, args...))
 }
 
 // Warn ...
 func (logger *Logger) Warn(msg string, fields ...Field) {
+	if !logger.Enabled(WarnLevel) {
+		return
+	}
 	if logger.IsDebugMode() {
 		msg = normalizeMessage(msg)
 	}
@@ -284,6 +311,9 @@ func (logger *Logger) Warn(msg string, fields ...Field) {
 
 // Warnw ...
 func (logger *Logger) Warnw(msg string, keysAndValues ...interface{}) {
+	if !logger.Enabled(WarnLevel) {
+		return
+	}
 	if logger.IsDebugMode() {
 		msg = normalizeMessage(msg)
 	}
@@ -292,6 +322,9 @@ func (logger *Logger) Warnw(msg string, keysAndValues ...interface{}) {
 
 // Warnf ...
 func (logger *Logger) Warnf(template string, args ...interface{}) {
+	if !logger.Enabled(WarnLevel) {
+		return
+	}
 	logger.sugar.Warnf(sprintf(template, args...))
 }
 
@@ -0,0 +1,73 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xquery
+
+import (
+	"regexp"
+)
+
+// M is a Mongo document, assignable to bson.M
+type M = map[string]interface{}
+
+// D is an ordered Mongo document, mirroring bson.D
+type D = []E
+
+// E is an element of D, convertible to bson.E
+type E struct {
+	Key   string
+	Value interface{}
+}
+
+var mongoOps = map[Op]string{
+	OpEq:  "$eq",
+	OpNe:  "$ne",
+	OpGt:  "$gt",
+	OpGte: "$gte",
+	OpLt:  "$lt",
+	OpLte: "$lte",
+	OpIn:  "$in",
+}
+
+// MongoFilter returns the filter document of filters,
+// e.g. {"age": {"$gte": 18}, "name": {"$regex": "jack"}}
+func (q *Query) MongoFilter() M {
+	var filter = make(M)
+	for _, f := range q.Filters {
+		cond, ok := filter[f.Column].(M)
+		if !ok {
+			cond = make(M)
+			filter[f.Column] = cond
+		}
+		if f.Op == OpLike {
+			cond["$regex"] = regexp.QuoteMeta(f.Value.(string))
+			continue
+		}
+		cond[mongoOps[f.Op]] = f.Value
+	}
+	return filter
+}
+
+// MongoSort returns the ordered sort document, e.g. [{created_at -1} {name 1}]
+func (q *Query) MongoSort() D {
+	var sort = make(D, 0, len(q.Sorts))
+	for _, s := range q.Sorts {
+		if s.Desc {
+			sort = append(sort, E{Key: s.Column, Value: -1})
+		} else {
+			sort = append(sort, E{Key: s.Column, Value: 1})
+		}
+	}
+	return sort
+}
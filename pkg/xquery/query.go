@@ -0,0 +1,278 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xquery parses the pagination, sorting and filtering conventions of
+// HTTP APIs into typed queries, restricted to allowlisted fields:
+//
+//	GET /users?page=2&page_size=20&sort=-created_at,name&age[gte]=18&status[in]=1,2
+//
+// Parsed queries can be turned into SQL clauses or Mongo filters.
+package xquery
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidQuery is the cause of all errors returned by Parse
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Reserved query params
+const (
+	ParamPage     = "page"
+	ParamPageSize = "page_size"
+	ParamSort     = "sort"
+)
+
+// Type of field values
+type Type int
+
+// Field types
+const (
+	String Type = iota
+	Int
+	Float
+	Bool
+	Time
+)
+
+// Op is a filter operator
+type Op string
+
+// Filter operators
+const (
+	OpEq   Op = "eq"
+	OpNe   Op = "ne"
+	OpGt   Op = "gt"
+	OpGte  Op = "gte"
+	OpLt   Op = "lt"
+	OpLte  Op = "lte"
+	OpIn   Op = "in"
+	OpLike Op = "like"
+)
+
+// Field is an allowlisted field of queries
+type Field struct {
+	// Name in query params
+	Name string
+	// Column in storage, defaults to Name
+	Column string
+	Type   Type
+	// Sortable allows sorting by the field
+	Sortable bool
+	// Ops allowed to filter by the field, not filterable if empty
+	Ops []Op
+}
+
+func (f Field) allow(op Op) bool {
+	for _, o := range f.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Schema ...
+type Schema struct {
+	fields          map[string]Field
+	defaultSort     []Sort
+	defaultPageSize int
+	maxPageSize     int
+}
+
+// NewSchema ...
+func NewSchema(fields ...Field) *Schema {
+	schema := &Schema{
+		fields:          make(map[string]Field, len(fields)),
+		defaultPageSize: 20,
+		maxPageSize:     100,
+	}
+	for _, field := range fields {
+		if field.Column == "" {
+			field.Column = field.Name
+		}
+		schema.fields[field.Name] = field
+	}
+	return schema
+}
+
+// WithPageSize sets the default and max page size
+func (schema *Schema) WithPageSize(defaultSize, maxSize int) *Schema {
+	schema.defaultPageSize = defaultSize
+	schema.maxPageSize = maxSize
+	return schema
+}
+
+// WithDefaultSort sets the sorting of queries without sort param, e.g. "-id"
+func (schema *Schema) WithDefaultSort(sort string) *Schema {
+	sorts, err := schema.parseSort(sort)
+	if err != nil {
+		panic(err)
+	}
+	schema.defaultSort = sorts
+	return schema
+}
+
+// Sort ...
+type Sort struct {
+	Column string
+	Desc   bool
+}
+
+// Filter ...
+type Filter struct {
+	Column string
+	Op     Op
+	// Value is typed by Field.Type, a slice of them for OpIn
+	Value interface{}
+}
+
+// Query is a parsed query
+type Query struct {
+	Page     int
+	PageSize int
+	Sorts    []Sort
+	Filters  []Filter
+}
+
+// Offset ...
+func (q *Query) Offset() int {
+	return (q.Page - 1) * q.PageSize
+}
+
+// Limit ...
+func (q *Query) Limit() int {
+	return q.PageSize
+}
+
+// Parse parses query params, params of unknown fields are ignored
+func (schema *Schema) Parse(values url.Values) (*Query, error) {
+	var q = &Query{Page: 1, PageSize: schema.defaultPageSize, Sorts: schema.defaultSort}
+	var err error
+	if v := values.Get(ParamPage); v != "" {
+		if q.Page, err = strconv.Atoi(v); err != nil || q.Page < 1 {
+			return nil, errors.Wrapf(ErrInvalidQuery, "page %q", v)
+		}
+	}
+	if v := values.Get(ParamPageSize); v != "" {
+		if q.PageSize, err = strconv.Atoi(v); err != nil || q.PageSize < 1 {
+			return nil, errors.Wrapf(ErrInvalidQuery, "page_size %q", v)
+		}
+		if schema.maxPageSize > 0 && q.PageSize > schema.maxPageSize {
+			q.PageSize = schema.maxPageSize
+		}
+	}
+	if v := values.Get(ParamSort); v != "" {
+		if q.Sorts, err = schema.parseSort(v); err != nil {
+			return nil, err
+		}
+	}
+
+	// sort keys for stable filter order
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name, op := splitKey(key)
+		field, ok := schema.fields[name]
+		if !ok {
+			continue
+		}
+		if !field.allow(op) {
+			return nil, errors.Wrapf(ErrInvalidQuery, "filter %s by %s", name, op)
+		}
+		for _, raw := range values[key] {
+			value, err := parseValue(field, op, raw)
+			if err != nil {
+				return nil, err
+			}
+			q.Filters = append(q.Filters, Filter{Column: field.Column, Op: op, Value: value})
+		}
+	}
+	return q, nil
+}
+
+func (schema *Schema) parseSort(val string) ([]Sort, error) {
+	var sorts = make([]Sort, 0)
+	for _, name := range strings.Split(val, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimLeft(name, "+-")
+		field, ok := schema.fields[name]
+		if !ok || !field.Sortable {
+			return nil, errors.Wrapf(ErrInvalidQuery, "sort by %s", name)
+		}
+		sorts = append(sorts, Sort{Column: field.Column, Desc: desc})
+	}
+	return sorts, nil
+}
+
+// splitKey splits "age[gte]" into "age" and OpGte, OpEq if no op
+func splitKey(key string) (string, Op) {
+	if i := strings.IndexByte(key, '['); i > 0 && strings.HasSuffix(key, "]") {
+		return key[:i], Op(key[i+1 : len(key)-1])
+	}
+	return key, OpEq
+}
+
+func parseValue(field Field, op Op, raw string) (interface{}, error) {
+	if op == OpLike {
+		if field.Type != String {
+			return nil, errors.Wrapf(ErrInvalidQuery, "like on non-string field %s", field.Name)
+		}
+		return raw, nil
+	}
+	if op != OpIn {
+		return parseScalar(field, raw)
+	}
+	var values = make([]interface{}, 0)
+	for _, item := range strings.Split(raw, ",") {
+		value, err := parseScalar(field, item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func parseScalar(field Field, raw string) (value interface{}, err error) {
+	switch field.Type {
+	case Int:
+		value, err = strconv.ParseInt(raw, 10, 64)
+	case Float:
+		value, err = strconv.ParseFloat(raw, 64)
+	case Bool:
+		value, err = strconv.ParseBool(raw)
+	case Time:
+		value, err = time.Parse(time.RFC3339, raw)
+	default:
+		value = raw
+	}
+	if err != nil {
+		return nil, errors.Wrapf(ErrInvalidQuery, "value %q of %s", raw, field.Name)
+	}
+	return value, nil
+}
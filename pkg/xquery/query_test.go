@@ -0,0 +1,90 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xquery
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var userSchema = NewSchema(
+	Field{Name: "id", Type: Int, Sortable: true, Ops: []Op{OpEq, OpIn}},
+	Field{Name: "name", Type: String, Ops: []Op{OpEq, OpLike}},
+	Field{Name: "age", Type: Int, Sortable: true, Ops: []Op{OpGte, OpLte}},
+	Field{Name: "created", Column: "created_at", Type: Time, Sortable: true},
+).WithPageSize(10, 50).WithDefaultSort("-id")
+
+func TestSchema_Parse(t *testing.T) {
+	values, _ := url.ParseQuery("page=3&page_size=20&sort=-created,age&age[gte]=18&name[like]=50%25_off&id[in]=1,2&unknown=1")
+	q, err := userSchema.Parse(values)
+	assert.Nil(t, err)
+	assert.Equal(t, 40, q.Offset())
+	assert.Equal(t, 20, q.Limit())
+	assert.Equal(t, []Sort{{Column: "created_at", Desc: true}, {Column: "age"}}, q.Sorts)
+	assert.Equal(t, []Filter{
+		{Column: "age", Op: OpGte, Value: int64(18)},
+		{Column: "id", Op: OpIn, Value: []interface{}{int64(1), int64(2)}},
+		{Column: "name", Op: OpLike, Value: "50%_off"},
+	}, q.Filters)
+
+	where, args := q.Where()
+	assert.Equal(t, "age >= ? AND id IN (?,?) AND name LIKE ?", where)
+	assert.Equal(t, []interface{}{int64(18), int64(1), int64(2), `%50\%\_off%`}, args)
+	assert.Equal(t, "created_at DESC, age ASC", q.OrderBy())
+
+	sql, args := q.SQL()
+	assert.Equal(t, "WHERE age >= ? AND id IN (?,?) AND name LIKE ? ORDER BY created_at DESC, age ASC LIMIT ? OFFSET ?", sql)
+	assert.Equal(t, []interface{}{20, 40}, args[len(args)-2:])
+
+	assert.Equal(t, M{
+		"age":  M{"$gte": int64(18)},
+		"id":   M{"$in": []interface{}{int64(1), int64(2)}},
+		"name": M{"$regex": `50%_off`},
+	}, q.MongoFilter())
+	assert.Equal(t, D{{Key: "created_at", Value: -1}, {Key: "age", Value: 1}}, q.MongoSort())
+}
+
+func TestSchema_ParseDefaults(t *testing.T) {
+	q, err := userSchema.Parse(url.Values{"page_size": {"1000"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, q.Page)
+	assert.Equal(t, 50, q.PageSize, "capped by max page size")
+	assert.Equal(t, []Sort{{Column: "id", Desc: true}}, q.Sorts)
+
+	sql, args := q.SQL()
+	assert.Equal(t, "ORDER BY id DESC LIMIT ? OFFSET ?", sql)
+	assert.Equal(t, []interface{}{50, 0}, args)
+}
+
+func TestSchema_ParseInvalid(t *testing.T) {
+	for _, raw := range []string{
+		"page=0",
+		"page_size=x",
+		"sort=name",
+		"sort=password",
+		"age=18",
+		"age[gte]=old",
+		"id[in]=1,x",
+		"name[drop]=1",
+		"created=yesterday",
+	} {
+		values, _ := url.ParseQuery(raw)
+		_, err := userSchema.Parse(values)
+		assert.Equal(t, ErrInvalidQuery, errors.Cause(err), raw)
+	}
+}
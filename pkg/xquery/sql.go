@@ -0,0 +1,86 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xquery
+
+import (
+	"strings"
+)
+
+var sqlOps = map[Op]string{
+	OpEq:   "=",
+	OpNe:   "<>",
+	OpGt:   ">",
+	OpGte:  ">=",
+	OpLt:   "<",
+	OpLte:  "<=",
+	OpLike: "LIKE",
+}
+
+// likeEscaper escapes wildcards of like patterns, with '\' as the default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Where returns the placeholder condition and args of filters, which can be
+// used as gorm db.Where(where, args...). Columns come from the schema only,
+// values are always bound as args.
+func (q *Query) Where() (string, []interface{}) {
+	var conds = make([]string, 0, len(q.Filters))
+	var args = make([]interface{}, 0, len(q.Filters))
+	for _, filter := range q.Filters {
+		switch filter.Op {
+		case OpIn:
+			values := filter.Value.([]interface{})
+			conds = append(conds, filter.Column+" IN ("+strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")+")")
+			args = append(args, values...)
+		case OpLike:
+			conds = append(conds, filter.Column+" LIKE ?")
+			args = append(args, "%"+likeEscaper.Replace(filter.Value.(string))+"%")
+		default:
+			conds = append(conds, filter.Column+" "+sqlOps[filter.Op]+" ?")
+			args = append(args, filter.Value)
+		}
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// OrderBy returns the order clause, e.g. "created_at DESC, name ASC"
+func (q *Query) OrderBy() string {
+	var parts = make([]string, 0, len(q.Sorts))
+	for _, sort := range q.Sorts {
+		if sort.Desc {
+			parts = append(parts, sort.Column+" DESC")
+		} else {
+			parts = append(parts, sort.Column+" ASC")
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SQL returns the clauses after SELECT ... FROM table, and the args
+func (q *Query) SQL() (string, []interface{}) {
+	var sb strings.Builder
+	where, args := q.Where()
+	if where != "" {
+		sb.WriteString("WHERE ")
+		sb.WriteString(where)
+		sb.WriteString(" ")
+	}
+	if order := q.OrderBy(); order != "" {
+		sb.WriteString("ORDER BY ")
+		sb.WriteString(order)
+		sb.WriteString(" ")
+	}
+	sb.WriteString("LIMIT ? OFFSET ?")
+	return sb.String(), append(args, q.Limit(), q.Offset())
+}
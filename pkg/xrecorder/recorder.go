@@ -0,0 +1,153 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xrecorder keeps summaries of recent requests in a bounded ring,
+// which can be queried on governor /debug/requests before logs are shipped.
+package xrecorder
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server/governor"
+)
+
+// DefaultSize is the capacity of Default recorder
+const DefaultSize = 1024
+
+// Default is the recorder used by servers
+var Default = New(DefaultSize)
+
+// Record is the summary of a request
+type Record struct {
+	Time     time.Time     `json:"time"`
+	Type     string        `json:"type"`
+	Method   string        `json:"method"`
+	Peer     string        `json:"peer"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+	TraceID  string        `json:"traceId,omitempty"`
+}
+
+// Filter selects records, zero value fields match all
+type Filter struct {
+	// Method matches records whose method contains it
+	Method string
+	Peer   string
+	Status string
+	// MinDuration matches records cost not less than it
+	MinDuration time.Duration
+	// Limit caps the number of records returned
+	Limit int
+}
+
+func (f Filter) match(record *Record) bool {
+	if f.Method != "" && !strings.Contains(record.Method, f.Method) {
+		return false
+	}
+	if f.Peer != "" && !strings.HasPrefix(record.Peer, f.Peer) {
+		return false
+	}
+	if f.Status != "" && record.Status != f.Status {
+		return false
+	}
+	return record.Duration >= f.MinDuration
+}
+
+// Recorder is a ring of recent records
+type Recorder struct {
+	mu      sync.Mutex
+	records []Record
+	next    int
+	full    bool
+}
+
+// New ...
+func New(size int) *Recorder {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Recorder{records: make([]Record, size)}
+}
+
+// Add records a request, overwriting the oldest one if full
+func (r *Recorder) Add(record Record) {
+	r.mu.Lock()
+	r.records[r.next] = record
+	r.next++
+	if r.next == len(r.records) {
+		r.next = 0
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// Query returns records matching the filter, newest first
+func (r *Recorder) Query(filter Filter) []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n = r.next
+	if r.full {
+		n = len(r.records)
+	}
+	var ret = make([]Record, 0)
+	for i := 1; i <= n; i++ {
+		record := &r.records[(r.next-i+len(r.records))%len(r.records)]
+		if !filter.match(record) {
+			continue
+		}
+		ret = append(ret, *record)
+		if filter.Limit > 0 && len(ret) >= filter.Limit {
+			break
+		}
+	}
+	return ret
+}
+
+// Add records a request to Default recorder
+func Add(record Record) {
+	Default.Add(record)
+}
+
+func init() {
+	// e.g. /debug/requests?method=Hello&status=Unavailable&min=100ms&limit=50
+	governor.HandleFunc("/debug/requests", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := Filter{
+			Method: query.Get("method"),
+			Peer:   query.Get("peer"),
+			Status: query.Get("status"),
+		}
+		if min := query.Get("min"); min != "" {
+			d, err := time.ParseDuration(min)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			filter.MinDuration = d
+		}
+		filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+		encoder := json.NewEncoder(w)
+		if query.Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(Default.Query(filter))
+	})
+}
@@ -0,0 +1,46 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xrecorder
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	r := New(3)
+	assert.Len(t, r.Query(Filter{}), 0)
+
+	for i := 0; i < 5; i++ {
+		r.Add(Record{
+			Method:   "/demo.Hello/" + strconv.Itoa(i),
+			Status:   "OK",
+			Duration: time.Duration(i) * time.Millisecond,
+		})
+	}
+
+	records := r.Query(Filter{})
+	assert.Len(t, records, 3)
+	assert.Equal(t, "/demo.Hello/4", records[0].Method)
+	assert.Equal(t, "/demo.Hello/2", records[2].Method)
+
+	assert.Len(t, r.Query(Filter{MinDuration: time.Millisecond * 3}), 2)
+	assert.Len(t, r.Query(Filter{Method: "Hello/3"}), 1)
+	assert.Len(t, r.Query(Filter{Status: "Unavailable"}), 0)
+	assert.Len(t, r.Query(Filter{Limit: 1}), 1)
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xschema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Deprecation is a deprecated config key
+type Deprecation struct {
+	// Key of config, '*' matches any segment, e.g. "jupiter.server.*.slowQueryThresholdInMilli"
+	Key string
+	// Replacement of key, segments matched by '*' of Key substitute its
+	// '*' in order, e.g. "jupiter.server.*.slowQueryThreshold". Empty
+	// means the key is no longer used.
+	Replacement string
+}
+
+var deprecations = make(map[string]Deprecation)
+
+// Deprecate marks keys as deprecated, it's usually called in init along
+// with Register
+func Deprecate(ds ...Deprecation) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, d := range ds {
+		deprecations[d.Key] = d
+	}
+}
+
+// Deprecations returns deprecated keys sorted by key
+func Deprecations() []Deprecation {
+	mu.RLock()
+	defer mu.RUnlock()
+	var ret = make([]Deprecation, 0, len(deprecations))
+	for _, d := range deprecations {
+		ret = append(ret, d)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret
+}
+
+// DeprecatedKeyError is reported for deprecated keys in config
+type DeprecatedKeyError struct {
+	Key         string
+	Replacement string
+}
+
+// Error ...
+func (e *DeprecatedKeyError) Error() string {
+	if e.Replacement == "" {
+		return e.Key + ": deprecated key, no longer used"
+	}
+	return fmt.Sprintf("%s: deprecated key, use %s instead", e.Key, e.Replacement)
+}
+
+// deprecated returns errors of deprecated keys in tree
+func deprecated(tree map[string]interface{}) []*DeprecatedKeyError {
+	var errs []*DeprecatedKeyError
+	for _, d := range Deprecations() {
+		for path := range match(tree, strings.Split(d.Key, "."), "") {
+			errs = append(errs, &DeprecatedKeyError{Key: path, Replacement: replace(d, path)})
+		}
+	}
+	return errs
+}
+
+// replace substitutes '*' of the replacement of d with segments of path
+// matched by '*' of its key
+func replace(d Deprecation, path string) string {
+	var wildcards []string
+	segments := strings.Split(path, ".")
+	for i, segment := range strings.Split(d.Key, ".") {
+		if segment == "*" && i < len(segments) {
+			wildcards = append(wildcards, segments[i])
+		}
+	}
+	var replacement = strings.Split(d.Replacement, ".")
+	for i, segment := range replacement {
+		if segment == "*" && len(wildcards) > 0 {
+			replacement[i], wildcards = wildcards[0], wildcards[1:]
+		}
+	}
+	return strings.Join(replacement, ".")
+}
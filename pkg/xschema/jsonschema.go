@@ -0,0 +1,107 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xschema
+
+import "strings"
+
+// durationPattern matches durations of time.ParseDuration
+const durationPattern = `^[-+]?([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$|^0$`
+
+// JSONSchema returns the JSON Schema (draft-07) of configs of components
+func JSONSchema(components []Component) map[string]interface{} {
+	var root = map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type":    "object",
+	}
+	for _, component := range components {
+		schema := component.describe().schema()
+		schema["title"] = component.Name
+
+		parent := root
+		segments := strings.Split(component.Key, ".")
+		for i, segment := range segments {
+			last := i == len(segments)-1
+			if segment == "*" {
+				parent["additionalProperties"] = merge(parent["additionalProperties"], schema, last)
+				if !last {
+					parent = parent["additionalProperties"].(map[string]interface{})
+				}
+				continue
+			}
+			properties, ok := parent["properties"].(map[string]interface{})
+			if !ok {
+				properties = make(map[string]interface{})
+				parent["properties"] = properties
+			}
+			properties[segment] = merge(properties[segment], schema, last)
+			if !last {
+				parent = properties[segment].(map[string]interface{})
+			}
+		}
+	}
+	return root
+}
+
+// merge returns the schema at a segment of key, which is schema of
+// component for the last segment, components of the same key are combined
+// with anyOf
+func merge(prev interface{}, schema map[string]interface{}, last bool) map[string]interface{} {
+	existing, ok := prev.(map[string]interface{})
+	if !last {
+		if !ok {
+			existing = map[string]interface{}{"type": "object"}
+		}
+		return existing
+	}
+	if !ok {
+		return schema
+	}
+	if anyOf, ok := existing["anyOf"].([]interface{}); ok {
+		existing["anyOf"] = append(anyOf, schema)
+		return existing
+	}
+	return map[string]interface{}{"anyOf": []interface{}{existing, schema}}
+}
+
+func (n *node) schema() map[string]interface{} {
+	var schema = make(map[string]interface{})
+	if n.description != "" {
+		schema["description"] = n.description
+	}
+	switch n.kind {
+	case kindDuration:
+		schema["type"] = []string{"string", "integer"}
+		schema["pattern"] = durationPattern
+	case kindArray:
+		schema["type"] = "array"
+		schema["items"] = n.elem.schema()
+	case kindMap:
+		schema["type"] = "object"
+		schema["additionalProperties"] = n.elem.schema()
+	case kindObject:
+		schema["type"] = "object"
+		var properties = make(map[string]interface{}, len(n.fields))
+		for _, field := range n.fields {
+			properties[field.key] = field.schema()
+		}
+		schema["properties"] = properties
+	default:
+		schema["type"] = n.kind
+	}
+	if n.kind != kindObject {
+		schema["default"] = n.value
+	}
+	return schema
+}
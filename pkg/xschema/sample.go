@@ -0,0 +1,104 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xschema
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sample returns a sample toml config of components with default values,
+// documented by descriptions. Instances are named by the last part of
+// component names, e.g. [jupiter.httpcache.httpcache].
+func Sample(components []Component) string {
+	var buf strings.Builder
+	for i, component := range components {
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		n := component.describe()
+		name := component.Name[strings.LastIndex(component.Name, ".")+1:]
+		key := strings.Replace(component.Key, "*", name, -1)
+		comment(&buf, "", fmt.Sprintf("%s: %s", component.Name, component.Description))
+		writeTable(&buf, key, n, "")
+	}
+	return buf.String()
+}
+
+func comment(buf *strings.Builder, prefix, text string) {
+	text = strings.TrimSuffix(text, ": ")
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(text, "\n") {
+		buf.WriteString(prefix + "# " + line + "\n")
+	}
+}
+
+// writeTable writes object n as table key, values before sub tables as toml
+// requires. prefix is "# " for tables of examples.
+func writeTable(buf *strings.Builder, key string, n *node, prefix string) {
+	fmt.Fprintf(buf, "%s[%s]\n", prefix, key)
+	var tables []*node
+	for _, field := range n.fields {
+		if field.kind == kindObject || (field.kind == kindArray && field.elem.kind == kindObject) {
+			tables = append(tables, field)
+			continue
+		}
+		comment(buf, prefix, field.description)
+		fmt.Fprintf(buf, "%s%s = %s\n", prefix, field.key, literal(field.plain()))
+	}
+	for _, field := range tables {
+		buf.WriteString("\n")
+		comment(buf, prefix, field.description)
+		if field.kind == kindObject {
+			writeTable(buf, key+"."+field.key, field, prefix)
+			continue
+		}
+		// arrays of tables are written as a commented example
+		var example strings.Builder
+		writeTable(&example, key+"."+field.key, field.elem, "# ")
+		buf.WriteString(strings.Replace(example.String(), "# ["+key+"."+field.key+"]", "# [["+key+"."+field.key+"]]", 1))
+	}
+}
+
+// literal returns the toml literal of a plain value
+func literal(v interface{}) string {
+	switch vv := v.(type) {
+	case string:
+		return strconv.Quote(vv)
+	case []interface{}:
+		var items = make([]string, len(vv))
+		for i, item := range vv {
+			items[i] = literal(item)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		var keys = make([]string, 0, len(vv))
+		for k := range vv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var items = make([]string, len(keys))
+		for i, k := range keys {
+			items[i] = strconv.Quote(k) + " = " + literal(vv[k])
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	default:
+		return fmt.Sprint(vv)
+	}
+}
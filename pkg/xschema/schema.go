@@ -0,0 +1,288 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xschema describes configs of components, components register
+// their default configs in init, so that a binary can emit the JSON Schema
+// and a documented sample config of exactly the components it imports, and
+// validate loaded configs against them.
+package xschema
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Component is the config schema of a component
+type Component struct {
+	// Name of component, e.g. "server.httpcache"
+	Name string
+	// Key of config, '*' matches the name of any instance, e.g. "jupiter.httpcache.*"
+	Key         string
+	Description string
+	// Default returns the default config, usually DefaultConfig, whose
+	// exported fields describe the keys, types and defaults. It's called
+	// lazily since default configs may be costly, e.g. probing local ip.
+	Default func() interface{}
+	// Fields describes keys relative to Key, e.g. "backoff.baseDelay"
+	Fields map[string]string
+}
+
+var (
+	mu         sync.RWMutex
+	components = make(map[string]Component)
+)
+
+// Register registers the schema of component, it's usually called in init
+func Register(component Component) {
+	mu.Lock()
+	defer mu.Unlock()
+	components[component.Name] = component
+}
+
+// RegisterConfig registers the component whose configs under key default
+// to the one returned by defaultConfig, a func without arguments returning
+// the config, e.g. DefaultConfig of the package
+//
+//	func init() {
+//		xschema.RegisterConfig("server.echo", "jupiter.server.*", "echo http server", DefaultConfig)
+//	}
+func RegisterConfig(name, key, description string, defaultConfig interface{}) {
+	fn := reflect.ValueOf(defaultConfig)
+	if fn.Kind() != reflect.Func || fn.Type().NumIn() != 0 || fn.Type().NumOut() != 1 {
+		panic("xschema: default config of " + name + " must be a func returning the config")
+	}
+	Register(Component{
+		Name:        name,
+		Key:         key,
+		Description: description,
+		Default:     func() interface{} { return fn.Call(nil)[0].Interface() },
+	})
+}
+
+// Components returns registered components sorted by key and name
+func Components() []Component {
+	mu.RLock()
+	defer mu.RUnlock()
+	var ret = make([]Component, 0, len(components))
+	for _, component := range components {
+		ret = append(ret, component)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Key != ret[j].Key {
+			return ret[i].Key < ret[j].Key
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// kinds of values
+const (
+	kindString   = "string"
+	kindInteger  = "integer"
+	kindNumber   = "number"
+	kindBoolean  = "boolean"
+	kindDuration = "duration"
+	kindArray    = "array"
+	kindObject   = "object"
+	kindMap      = "map"
+)
+
+// node describes a value of config
+type node struct {
+	key         string
+	kind        string
+	value       interface{}
+	description string
+	fields      []*node // of objects
+	elem        *node   // of arrays and maps
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// describe returns the node of component config
+func (c Component) describe() *node {
+	var root *node
+	if c.Default != nil {
+		root = describe(reflect.ValueOf(c.Default()), c.Fields, "")
+	}
+	if root == nil {
+		root = &node{kind: kindObject}
+	}
+	root.description = c.Description
+	return root
+}
+
+// maxDepth bounds nesting of configs, in case of recursive types
+const maxDepth = 16
+
+// describe returns nil for values which can't be configured, e.g. funcs
+func describe(v reflect.Value, descs map[string]string, path string) *node {
+	if strings.Count(path, ".") >= maxDepth {
+		return nil
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			v = reflect.Zero(elemType(v.Type()))
+			continue
+		}
+		v = v.Elem()
+	}
+
+	var n = &node{description: descs[path]}
+	switch {
+	case !v.IsValid():
+		return nil
+	case v.Type() == durationType:
+		n.kind, n.value = kindDuration, time.Duration(v.Int()).String()
+		return n
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		n.kind, n.value = kindString, v.String()
+	case reflect.Bool:
+		n.kind, n.value = kindBoolean, v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n.kind, n.value = kindInteger, v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n.kind, n.value = kindInteger, v.Uint()
+	case reflect.Float32, reflect.Float64:
+		n.kind, n.value = kindNumber, v.Float()
+	case reflect.Slice, reflect.Array:
+		n.kind = kindArray
+		// fields of elements are described relative to the array, e.g. "rules.path"
+		if n.elem = describe(reflect.Zero(v.Type().Elem()), descs, path); n.elem == nil {
+			return nil
+		}
+		n.elem.description = ""
+		var values = make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if elem := describe(v.Index(i), nil, ""); elem != nil {
+				values = append(values, elem.plain())
+			}
+		}
+		n.value = values
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		n.kind = kindMap
+		if n.elem = describe(reflect.Zero(v.Type().Elem()), descs, path); n.elem == nil {
+			return nil
+		}
+		n.elem.description = ""
+		var values = make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			if elem := describe(v.MapIndex(k), nil, ""); elem != nil {
+				values[k.String()] = elem.plain()
+			}
+		}
+		n.value = values
+	case reflect.Struct:
+		n.kind = kindObject
+		n.fields = describeFields(v, descs, path)
+	default:
+		return nil
+	}
+	return n
+}
+
+func elemType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	// nil interfaces can't be configured
+	return reflect.TypeOf(struct{}{})
+}
+
+// describeFields returns exported fields of struct v, fields of embedded
+// structs are flattened since they're configured under the same key
+func describeFields(v reflect.Value, descs map[string]string, path string) []*node {
+	var fields = make([]*node, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		key := KeyOf(field)
+		if key == "-" {
+			continue
+		}
+		if field.Anonymous {
+			fv := v.Field(i)
+			for fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					fv = reflect.Zero(fv.Type().Elem())
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				fields = append(fields, describeFields(fv, descs, path)...)
+			}
+			continue
+		}
+		if n := describe(v.Field(i), descs, join(path, key)); n != nil {
+			n.key = key
+			fields = append(fields, n)
+		}
+	}
+	return fields
+}
+
+// plain returns the default value of n as a plain value
+func (n *node) plain() interface{} {
+	if n.kind != kindObject {
+		return n.value
+	}
+	var m = make(map[string]interface{}, len(n.fields))
+	for _, field := range n.fields {
+		m[field.key] = field.plain()
+	}
+	return m
+}
+
+// KeyOf returns the config key of field, which is its mapstructure tag or
+// its name in lower camel case
+func KeyOf(field reflect.StructField) string {
+	if tag := strings.Split(field.Tag.Get("mapstructure"), ",")[0]; tag != "" {
+		return tag
+	}
+	return lowerCamel(field.Name)
+}
+
+// lowerCamel lowers the leading upper case run of s, e.g. "TTL" to "ttl",
+// "HTTPClient" to "httpClient"
+func lowerCamel(s string) string {
+	runes := []rune(s)
+	for i := 0; i < len(runes) && unicode.IsUpper(runes[i]); i++ {
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,245 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xschema
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+type testBackoff struct {
+	BaseDelay time.Duration
+	Jitter    float64
+}
+
+type testRule struct {
+	Path string
+	TTL  time.Duration
+}
+
+type testBase struct {
+	Endpoints []string
+}
+
+type testConfig struct {
+	*testBase
+	Name      string
+	Port      int
+	Enable    bool
+	Timeout   time.Duration
+	Labels    map[string]string
+	Rules     []testRule
+	Backoff   testBackoff
+	HTTPProxy string `mapstructure:"proxy"`
+
+	logger interface{}
+	Hook   func()
+}
+
+func defaultTestConfig() *testConfig {
+	return &testConfig{
+		testBase: &testBase{Endpoints: []string{"127.0.0.1:2379"}},
+		Name:     "demo",
+		Port:     9091,
+		Timeout:  time.Second,
+		Labels:   map[string]string{"zone": "z1"},
+		Backoff:  testBackoff{BaseDelay: 100 * time.Millisecond, Jitter: 0.2},
+	}
+}
+
+type testOtherConfig struct {
+	Address string
+}
+
+func registerTestComponents(t *testing.T) {
+	reset := func() {
+		mu.Lock()
+		components = make(map[string]Component)
+		deprecations = make(map[string]Deprecation)
+		mu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+	Register(Component{
+		Name:        "test.demo",
+		Key:         "jupiter.test.*",
+		Description: "demo component",
+		Default:     func() interface{} { return defaultTestConfig() },
+		Fields:      map[string]string{"timeout": "timeout of requests", "rules.ttl": "ttl of rule"},
+	})
+	RegisterConfig("test.other", "jupiter.test.*", "", func() testOtherConfig { return testOtherConfig{} })
+}
+
+func TestRegisterConfig(t *testing.T) {
+	assert.Panics(t, func() { RegisterConfig("test.invalid", "jupiter.test.*", "", testOtherConfig{}) })
+}
+
+func TestJSONSchema(t *testing.T) {
+	registerTestComponents(t)
+	schema := JSONSchema(Components())
+	test := schema["properties"].(map[string]interface{})["jupiter"].(map[string]interface{})["properties"].(map[string]interface{})["test"].(map[string]interface{})
+	anyOf := test["additionalProperties"].(map[string]interface{})["anyOf"].([]interface{})
+	assert.Len(t, anyOf, 2)
+
+	demo := anyOf[0].(map[string]interface{})
+	assert.Equal(t, "test.demo", demo["title"])
+	assert.Equal(t, "demo component", demo["description"])
+	properties := demo["properties"].(map[string]interface{})
+	assert.ElementsMatch(t, []string{"endpoints", "name", "port", "enable", "timeout", "labels", "rules", "backoff", "proxy"}, keys(properties))
+	assert.Equal(t, map[string]interface{}{
+		"type":        []string{"string", "integer"},
+		"pattern":     durationPattern,
+		"default":     "1s",
+		"description": "timeout of requests",
+	}, properties["timeout"])
+	assert.Equal(t, "ttl of rule", properties["rules"].(map[string]interface{})["items"].(map[string]interface{})["properties"].(map[string]interface{})["ttl"].(map[string]interface{})["description"])
+	assert.Equal(t, int64(9091), properties["port"].(map[string]interface{})["default"])
+}
+
+func TestSample(t *testing.T) {
+	registerTestComponents(t)
+	sample := Sample(Components())
+	assert.Contains(t, sample, "# test.demo: demo component\n[jupiter.test.demo]\n")
+	assert.Contains(t, sample, "# timeout of requests\ntimeout = \"1s\"\n")
+	assert.Contains(t, sample, "# [[jupiter.test.demo.rules]]\n# path = \"\"\n")
+	assert.Contains(t, sample, "[jupiter.test.other]\naddress = \"\"\n")
+
+	// the sample decodes to the default config
+	c := conf.New()
+	assert.Nil(t, c.LoadFromReader(bytes.NewBufferString(sample), toml.Unmarshal))
+	var config = &testConfig{testBase: &testBase{}}
+	assert.Nil(t, c.UnmarshalKey("jupiter.test.demo", &config))
+	assert.Nil(t, c.UnmarshalKey("jupiter.test.demo", &config.testBase))
+	assert.Equal(t, defaultTestConfig(), config)
+
+	assert.Empty(t, Validate(map[string]interface{}{"jupiter": c.GetStringMap("jupiter")}))
+}
+
+func TestValidate(t *testing.T) {
+	registerTestComponents(t)
+	c := conf.New()
+	assert.Nil(t, c.LoadFromReader(bytes.NewBufferString(`
+[jupiter.test.a]
+	Port = 80
+	timeout = "1x"
+	enabled = true
+	labels = { zone = 1 }
+	[[jupiter.test.a.rules]]
+		path = "/"
+		ttl = 10
+[jupiter.test.b]
+	address = "127.0.0.1"
+[jupiter.other]
+	anything = 1
+`), toml.Unmarshal))
+
+	var errs []string
+	for _, err := range Validate(map[string]interface{}{"jupiter": c.GetStringMap("jupiter")}) {
+		errs = append(errs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"jupiter.other: unknown key",
+		"jupiter.test.a.enabled: unknown key, did you mean jupiter.test.a.enable?",
+		"jupiter.test.a.labels.zone: expect string, got int64 1",
+		"jupiter.test.a.timeout: expect duration, got string 1x",
+	}, errs)
+}
+
+func TestValidateKeys(t *testing.T) {
+	registerTestComponents(t)
+	Register(Component{
+		Name:    "test.demo.stub",
+		Key:     "jupiter.test.*.stub",
+		Default: func() interface{} { return testOtherConfig{} },
+	})
+	Register(Component{
+		Name:    "test.inbound",
+		Key:     "jupiter.test.inbound.*",
+		Default: func() interface{} { return testBackoff{} },
+	})
+	Deprecate(Deprecation{
+		Key:         "jupiter.test.*.httpProxy",
+		Replacement: "jupiter.test.*.proxy",
+	}, Deprecation{
+		Key: "jupiter.legacy",
+	})
+
+	c := conf.New()
+	assert.Nil(t, c.LoadFromReader(bytes.NewBufferString(`
+[app]
+	anything = 1
+[jupiter]
+	legacy = true
+[jupiter.tests.a]
+	port = 80
+[jupiter.test.a]
+	httpProxy = "127.0.0.1:3128"
+	[jupiter.test.a.stub]
+		address = "127.0.0.1"
+	[jupiter.test.a.stubb]
+		address = "127.0.0.1"
+[jupiter.test.inbound.b]
+	baseDelay = "1s"
+	jitter = 0.1
+`), toml.Unmarshal))
+
+	tree := map[string]interface{}{"app": c.GetStringMap("app"), "jupiter": c.GetStringMap("jupiter")}
+	var errs []string
+	for _, err := range Validate(tree) {
+		errs = append(errs, err.Error())
+	}
+	assert.Equal(t, []string{
+		"jupiter.legacy: deprecated key, no longer used",
+		"jupiter.test.a.httpProxy: deprecated key, use jupiter.test.a.proxy instead",
+		"jupiter.test.a.stubb: unknown key",
+		"jupiter.tests: unknown key, did you mean jupiter.test?",
+	}, errs)
+
+	errors := Validate(tree)
+	assert.Equal(t, &DeprecatedKeyError{Key: "jupiter.legacy"}, errors[0])
+	assert.Equal(t, &UnknownKeyError{Key: "jupiter.tests", Suggestion: "jupiter.test"}, errors[3])
+}
+
+func TestDistance(t *testing.T) {
+	assert.Equal(t, 0, distance("server", "server"))
+	assert.Equal(t, 1, distance("servers", "server"))
+	assert.Equal(t, 2, distance("sevrer", "server"))
+	assert.Equal(t, 6, distance("", "server"))
+}
+
+func TestLowerCamel(t *testing.T) {
+	for in, out := range map[string]string{
+		"TTL":         "ttl",
+		"MaxBodySize": "maxBodySize",
+		"HTTPClient":  "httpClient",
+		"EnableTLS":   "enableTLS",
+		"A":           "a",
+	} {
+		assert.Equal(t, out, lowerCamel(in))
+	}
+}
+
+func keys(m map[string]interface{}) []string {
+	var ret []string
+	for k := range m {
+		ret = append(ret, k)
+	}
+	return ret
+}
@@ -0,0 +1,347 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xschema
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Validate checks configs of components in tree, e.g. the whole config
+// loaded by conf, and returns errors of mistyped values, deprecated keys
+// and unknown keys, i.e. keys consumed by no component in namespaces of
+// components. Keys are matched case insensitively like conf.UnmarshalKey
+// does. A config matching the key of several components, e.g. registries
+// of etcd and consul, is valid if any of them accepts it.
+func Validate(tree map[string]interface{}) []error {
+	var groups = make(map[string][]Component)
+	var keys []string
+	for _, component := range Components() {
+		if _, ok := groups[component.Key]; !ok {
+			keys = append(keys, component.Key)
+		}
+		groups[component.Key] = append(groups[component.Key], component)
+	}
+	var patterns = make([][]string, 0, len(keys))
+	for _, key := range keys {
+		patterns = append(patterns, strings.Split(key, "."))
+	}
+
+	var errs []error
+	var deprecatedKeys []string
+	for _, err := range deprecated(tree) {
+		errs = append(errs, err)
+		deprecatedKeys = append(deprecatedKeys, err.Key)
+	}
+	checked := unconsumed(tree, patterns, "")
+	for i, key := range keys {
+		for path, val := range match(tree, patterns[i], "") {
+			if shadowed(path, patterns[i], patterns) {
+				continue
+			}
+			var best []error
+			for j, component := range groups[key] {
+				found := check(component.describe(), val, path)
+				if j == 0 || len(found) < len(best) {
+					best = found
+				}
+			}
+			checked = append(checked, best...)
+		}
+	}
+	for _, err := range checked {
+		// keys of deprecated or more specific components are reported by them
+		if unknown, ok := err.(*UnknownKeyError); ok && (under(unknown.Key, deprecatedKeys) || claimed(unknown.Key, patterns)) {
+			continue
+		}
+		errs = append(errs, err)
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errs
+}
+
+// UnknownKeyError is reported for keys consumed by no component
+type UnknownKeyError struct {
+	Key string
+	// Suggestion is the most similar known key, empty if none
+	Suggestion string
+}
+
+// Error ...
+func (e *UnknownKeyError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("%s: unknown key, did you mean %s?", e.Key, e.Suggestion)
+	}
+	return e.Key + ": unknown key"
+}
+
+// unconsumed returns errors of keys in tree matching no pattern, keys out
+// of namespaces of components, e.g. configs of applications, are ignored
+func unconsumed(tree map[string]interface{}, patterns [][]string, prefix string) []error {
+	var errs []error
+	for k, v := range tree {
+		var consumed bool
+		var tails [][]string
+		for _, pattern := range patterns {
+			if pattern[0] != "*" && !strings.EqualFold(pattern[0], k) {
+				continue
+			}
+			if len(pattern) == 1 {
+				consumed = true
+				break
+			}
+			tails = append(tails, pattern[1:])
+		}
+		switch {
+		case consumed:
+		case len(tails) > 0:
+			if sub, ok := v.(map[string]interface{}); ok {
+				errs = append(errs, unconsumed(sub, tails, join(prefix, k))...)
+			}
+		case prefix != "":
+			var candidates []string
+			for _, pattern := range patterns {
+				candidates = append(candidates, pattern[0])
+			}
+			errs = append(errs, unknown(prefix, k, candidates))
+		}
+	}
+	return errs
+}
+
+// shadowed reports whether path matching pattern also matches a more
+// specific pattern, e.g. "jupiter.webhook.inbound" of "jupiter.webhook.*"
+// and "jupiter.webhook.inbound.*"
+func shadowed(path string, pattern []string, patterns [][]string) bool {
+	segments := strings.Split(path, ".")
+	for _, other := range patterns {
+		if len(other) < len(pattern) || strings.Join(other, ".") == strings.Join(pattern, ".") {
+			continue
+		}
+		if matches(other[:len(pattern)], segments) && wildcards(other[:len(pattern)]) < wildcards(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// claimed reports whether path is the key or namespace of a pattern, e.g.
+// "jupiter.redis.demo.stub" of "jupiter.redis.*.stub"
+func claimed(path string, patterns [][]string) bool {
+	segments := strings.Split(path, ".")
+	for _, pattern := range patterns {
+		if len(pattern) >= len(segments) && matches(pattern[:len(segments)], segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// under reports whether path is one of keys or under one of them
+func under(path string, keys []string) bool {
+	for _, key := range keys {
+		if strings.EqualFold(path, key) || strings.HasPrefix(strings.ToLower(path), strings.ToLower(key)+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(pattern []string, segments []string) bool {
+	if len(pattern) != len(segments) {
+		return false
+	}
+	for i, segment := range pattern {
+		if segment != "*" && !strings.EqualFold(segment, segments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func wildcards(pattern []string) int {
+	var n int
+	for _, segment := range pattern {
+		if segment == "*" {
+			n++
+		}
+	}
+	return n
+}
+
+// unknown returns the error of unknown key under prefix, suggesting the
+// most similar candidate within edit distance of a third of its length,
+// which catches typos like "servers" of "server"
+func unknown(prefix, key string, candidates []string) *UnknownKeyError {
+	var err = &UnknownKeyError{Key: join(prefix, key)}
+	var best = len(key)/3 + 1
+	for _, candidate := range candidates {
+		if candidate == "*" {
+			continue
+		}
+		if d := distance(strings.ToLower(key), strings.ToLower(candidate)); d < best {
+			best, err.Suggestion = d, join(prefix, candidate)
+		}
+	}
+	return err
+}
+
+// distance returns the levenshtein distance between a and b
+func distance(a, b string) int {
+	var prev = make([]int, len(b)+1)
+	var cur = make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min(values ...int) int {
+	var ret = values[0]
+	for _, v := range values[1:] {
+		if v < ret {
+			ret = v
+		}
+	}
+	return ret
+}
+
+// match returns values of tree whose paths match segments
+func match(tree map[string]interface{}, segments []string, prefix string) map[string]interface{} {
+	var ret = make(map[string]interface{})
+	for k, v := range tree {
+		if segments[0] != "*" && !strings.EqualFold(segments[0], k) {
+			continue
+		}
+		if len(segments) == 1 {
+			ret[join(prefix, k)] = v
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			for path, val := range match(sub, segments[1:], join(prefix, k)) {
+				ret[path] = val
+			}
+		}
+	}
+	return ret
+}
+
+// check returns errors of val against n
+func check(n *node, val interface{}, path string) []error {
+	var mismatch = []error{fmt.Errorf("%s: expect %s, got %T %v", path, n.kind, val, val)}
+	switch n.kind {
+	case kindString:
+		if _, ok := val.(string); !ok {
+			return mismatch
+		}
+	case kindBoolean:
+		if _, ok := val.(bool); !ok {
+			return mismatch
+		}
+	case kindInteger:
+		switch v := val.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		case float64:
+			if v != float64(int64(v)) {
+				return mismatch
+			}
+		default:
+			return mismatch
+		}
+	case kindNumber:
+		switch val.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		default:
+			return mismatch
+		}
+	case kindDuration:
+		switch v := val.(type) {
+		case int, int64:
+		case string:
+			if _, err := time.ParseDuration(v); err != nil {
+				return mismatch
+			}
+		default:
+			return mismatch
+		}
+	case kindArray:
+		var errs []error
+		switch v := val.(type) {
+		case []interface{}:
+			for i, item := range v {
+				errs = append(errs, check(n.elem, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		case []map[string]interface{}:
+			for i, item := range v {
+				errs = append(errs, check(n.elem, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		default:
+			return mismatch
+		}
+		return errs
+	case kindMap:
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		var errs []error
+		for k, item := range m {
+			errs = append(errs, check(n.elem, item, join(path, k))...)
+		}
+		return errs
+	case kindObject:
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return mismatch
+		}
+		var errs []error
+		for k, item := range m {
+			field := n.field(k)
+			if field == nil {
+				var candidates = make([]string, 0, len(n.fields))
+				for _, field := range n.fields {
+					candidates = append(candidates, field.key)
+				}
+				errs = append(errs, unknown(path, k, candidates))
+				continue
+			}
+			errs = append(errs, check(field, item, join(path, k))...)
+		}
+		return errs
+	}
+	return nil
+}
+
+func (n *node) field(key string) *node {
+	for _, field := range n.fields {
+		if strings.EqualFold(field.key, key) {
+			return field
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xskew
+
+import (
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+// ConfigKey ...
+const ConfigKey = "jupiter.skew"
+
+func init() {
+	xschema.Register(xschema.Component{
+		Name:        "skew",
+		Key:         ConfigKey,
+		Description: "clock skew detection",
+		Default:     func() interface{} { return DefaultConfig() },
+		Fields: map[string]string{
+			"sources": `reference clocks, "ntp://host[:port]" or http urls whose Date header is the server time, e.g. etcd "http://127.0.0.1:2379/version"`,
+		},
+	})
+}
+
+// Config ...
+type Config struct {
+	// Sources of reference time, "ntp://host[:port]" or http(s) urls whose
+	// Date header is the server time, e.g. "http://127.0.0.1:2379/version"
+	// of etcd. Date headers are of second precision, so http sources only
+	// detect skew of seconds.
+	Sources []string
+	// Interval of periodic checks
+	Interval time.Duration
+	// Timeout of querying a source
+	Timeout time.Duration
+	// WarnThreshold logs warnings once skew exceeds it
+	WarnThreshold time.Duration
+	// FailThreshold fails readiness once skew exceeds it, disabled if zero
+	FailThreshold time.Duration
+
+	name   string
+	logger *xlog.Logger
+	clock  xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Sources:       []string{"ntp://pool.ntp.org"},
+		Interval:      time.Minute,
+		Timeout:       time.Second * 3,
+		WarnThreshold: time.Second,
+		name:          ConfigKey,
+		logger:        xlog.JupiterLogger.With(xlog.FieldMod("skew")),
+		clock:         xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig() *Config {
+	return RawConfig(ConfigKey)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("skew parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	config.name = key
+	return config
+}
+
+// WithClock sets the local clock, used by tests
+func (config *Config) WithClock(clock xtime.Clock) *Config {
+	config.clock = clock
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Checker {
+	checker, err := newChecker(config)
+	if err != nil {
+		config.logger.Panic("skew build", xlog.FieldErr(err), xlog.FieldKey(config.name))
+	}
+	return checker
+}
@@ -0,0 +1,238 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xskew detects skew of the local clock against reference clocks,
+// e.g. etcd or ntp servers, since skew breaks assumptions of TTLs, leases
+// and trace timelines. Skew is checked on start and periodically, large
+// skew is logged, exported as metrics and optionally fails readiness.
+package xskew
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/server/governor"
+	"github.com/douyu/jupiter/pkg/server/readiness"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/pkg/errors"
+)
+
+// ErrNoSource is returned by Check if no source answered
+var ErrNoSource = errors.New("no clock source available")
+
+var skewGauge = metric.GaugeVecOpts{
+	Namespace: metric.DefaultNamespace,
+	Name:      "clock_skew_seconds",
+	Labels:    []string{"source"},
+}.Build()
+
+// checkers are started checkers keyed by config key
+var checkers sync.Map
+
+func init() {
+	governor.HandleFunc("/debug/skew", func(w http.ResponseWriter, r *http.Request) {
+		var ret = make(map[string]interface{})
+		checkers.Range(func(key, value interface{}) bool {
+			ret[key.(string)] = value.(*Checker).Results()
+			return true
+		})
+		encoder := json.NewEncoder(w)
+		if r.URL.Query().Get("pretty") == "true" {
+			encoder.SetIndent("", "    ")
+		}
+		_ = encoder.Encode(ret)
+	})
+}
+
+// Result is the last check of a source
+type Result struct {
+	Source string        `json:"source"`
+	Offset time.Duration `json:"offset"`
+	Error  string        `json:"error,omitempty"`
+	At     time.Time     `json:"at"`
+}
+
+// Checker checks skew of the local clock
+type Checker struct {
+	*Config
+	sources []Source
+
+	mu      sync.Mutex
+	results map[string]Result
+	skew    time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newChecker(config *Config) (*Checker, error) {
+	if len(config.Sources) == 0 {
+		return nil, errors.New("no clock source configured")
+	}
+	if config.Interval <= 0 {
+		return nil, fmt.Errorf("invalid interval %s", config.Interval)
+	}
+	var sources = make([]Source, 0, len(config.Sources))
+	for _, addr := range config.Sources {
+		source, err := NewSource(addr)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return &Checker{
+		Config:  config,
+		sources: sources,
+		results: make(map[string]Result),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// Check queries all sources concurrently and returns the skew, which is the
+// median of offsets of sources answered, positive if the local clock is behind
+func (c *Checker) Check(ctx context.Context) (time.Duration, error) {
+	var results = make([]Result, len(c.sources))
+	var wg sync.WaitGroup
+	for i, source := range c.sources {
+		wg.Add(1)
+		go func(i int, source Source) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+			offset, err := source.Offset(ctx, c.clock)
+			results[i] = Result{Source: source.String(), Offset: offset, At: c.clock.Now()}
+			if err != nil {
+				results[i].Error = err.Error()
+				c.logger.Warn("query clock source", xlog.String("source", source.String()), xlog.FieldErr(err))
+				return
+			}
+			skewGauge.Set(offset.Seconds(), source.String())
+		}(i, source)
+	}
+	wg.Wait()
+
+	var offsets []time.Duration
+	c.mu.Lock()
+	for _, result := range results {
+		c.results[result.Source] = result
+		if result.Error == "" {
+			offsets = append(offsets, result.Offset)
+		}
+	}
+	c.mu.Unlock()
+	if len(offsets) == 0 {
+		return 0, ErrNoSource
+	}
+	skew := median(offsets)
+	c.mu.Lock()
+	c.skew = skew
+	c.mu.Unlock()
+	c.judge(skew)
+	return skew, nil
+}
+
+// judge warns about skew and fails readiness if it's over thresholds
+func (c *Checker) judge(skew time.Duration) {
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	if c.WarnThreshold > 0 && abs > c.WarnThreshold {
+		c.logger.Warn("clock skew", xlog.Duration("skew", skew), xlog.Duration("threshold", c.WarnThreshold))
+	}
+	if c.FailThreshold <= 0 {
+		return
+	}
+	if abs > c.FailThreshold {
+		readiness.Fail(c.check(), fmt.Errorf("clock skew %s exceeds %s", skew, c.FailThreshold))
+	} else {
+		readiness.Pass(c.check())
+	}
+}
+
+// check is the name of readiness check
+func (c *Checker) check() string {
+	return "skew:" + c.name
+}
+
+// Start checks skew once and then every Interval until Stop
+func (c *Checker) Start() {
+	if _, loaded := checkers.LoadOrStore(c.name, c); loaded {
+		c.logger.Panic("skew checker already started", xlog.FieldKey(c.name))
+	}
+	if _, err := c.Check(context.Background()); err != nil {
+		c.logger.Error("check clock skew", xlog.FieldErr(err))
+	}
+	ticker := c.clock.NewTicker(c.Interval)
+	xgo.Go(func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C():
+			}
+			if _, err := c.Check(context.Background()); err != nil {
+				c.logger.Error("check clock skew", xlog.FieldErr(err))
+			}
+		}
+	})
+}
+
+// Stop stops periodic checks and passes the readiness check
+func (c *Checker) Stop() error {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		checkers.Delete(c.name)
+		if c.FailThreshold > 0 {
+			readiness.Pass(c.check())
+		}
+	})
+	return nil
+}
+
+// Skew returns the skew of the last successful check
+func (c *Checker) Skew() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skew
+}
+
+// Results returns the last checks of sources
+func (c *Checker) Results() []Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret = make([]Result, 0, len(c.results))
+	for _, result := range c.results {
+		ret = append(ret, result)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Source < ret[j].Source })
+	return ret
+}
+
+func median(values []time.Duration) time.Duration {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xskew
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server/readiness"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+// serveNTP answers sntp requests with the time of now
+func serveNTP(t *testing.T, now func() time.Time) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		var buf = make([]byte, 48)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var resp = make([]byte, 48)
+			resp[0], resp[1] = 0x24, 1
+			copy(resp[24:32], buf[40:48])
+			binary.BigEndian.PutUint64(resp[32:], toNTPTime(now()))
+			binary.BigEndian.PutUint64(resp[40:], toNTPTime(now()))
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}()
+	return "ntp://" + conn.LocalAddr().String()
+}
+
+// serveDate responds with Date header of now
+func serveDate(t *testing.T, now func() time.Time) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", now().UTC().Format(http.TimeFormat))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/version"
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Unix(1600000000, 123456789)
+	assert.InDelta(t, 0, float64(fromNTPTime(toNTPTime(now)).Sub(now)), 1)
+}
+
+func TestSources(t *testing.T) {
+	local := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	clock := xtime.NewMockClock(local)
+	reference := func() time.Time { return local.Add(3 * time.Second) }
+
+	source, err := NewSource(serveNTP(t, reference))
+	assert.Nil(t, err)
+	offset, err := source.Offset(context.Background(), clock)
+	assert.Nil(t, err)
+	assert.InDelta(t, float64(3*time.Second), float64(offset), float64(time.Microsecond))
+
+	source, err = NewSource(serveDate(t, reference))
+	assert.Nil(t, err)
+	offset, err = source.Offset(context.Background(), clock)
+	assert.Nil(t, err)
+	assert.Equal(t, 3500*time.Millisecond, offset)
+
+	_, err = NewSource("tcp://127.0.0.1")
+	assert.NotNil(t, err)
+}
+
+func TestChecker(t *testing.T) {
+	local := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	clock := xtime.NewMockClock(local)
+	var skew = int64(10 * time.Second)
+	reference := func() time.Time { return clock.Now().Add(time.Duration(atomic.LoadInt64(&skew))) }
+
+	config := DefaultConfig().WithClock(clock)
+	config.Sources = []string{serveNTP(t, reference), serveNTP(t, reference), "ntp://127.0.0.1:1"}
+	config.Timeout = time.Second
+	config.FailThreshold = 5 * time.Second
+	checker := config.Build()
+
+	var changes = make(chan bool, 10)
+	readiness.OnChange(func(ready bool) { changes <- ready })
+	checker.Start()
+	defer checker.Stop()
+	assert.InDelta(t, float64(10*time.Second), float64(checker.Skew()), float64(time.Microsecond))
+	assert.False(t, <-changes)
+	assert.Contains(t, readiness.Failures(), "skew:jupiter.skew")
+
+	results := checker.Results()
+	assert.Len(t, results, 3)
+	assert.Equal(t, "ntp://127.0.0.1:1", results[0].Source)
+	assert.NotEmpty(t, results[0].Error)
+
+	// clock is synchronized
+	atomic.StoreInt64(&skew, int64(time.Second))
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute)
+	assert.True(t, <-changes)
+	assert.True(t, readiness.Ready())
+	assert.InDelta(t, float64(time.Second), float64(checker.Skew()), float64(time.Microsecond))
+
+	assert.Equal(t, 2*time.Second, median([]time.Duration{3 * time.Second, time.Second}))
+	assert.Equal(t, time.Second, median([]time.Duration{3 * time.Second, -time.Second, time.Second}))
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xskew
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/pkg/errors"
+)
+
+// Source is a reference clock
+type Source interface {
+	// Offset returns the offset of the reference clock to clock, positive
+	// if clock is behind
+	Offset(ctx context.Context, clock xtime.Clock) (time.Duration, error)
+	String() string
+}
+
+// NewSource returns the source of addr, "ntp://host[:port]" or an http(s)
+// url whose Date header is the server time
+func NewSource(addr string) (Source, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ntp":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "123")
+		}
+		return &ntpSource{addr: addr, host: host}, nil
+	case "http", "https":
+		return &httpSource{url: addr, client: http.DefaultClient}, nil
+	default:
+		return nil, fmt.Errorf("unknown clock source %q", addr)
+	}
+}
+
+// ntpEpochOffset is seconds from 1900, the ntp epoch, to 1970
+const ntpEpochOffset = 2208988800
+
+// ntpSource queries an ntp server with sntp (RFC 4330)
+type ntpSource struct {
+	addr string
+	host string
+}
+
+func (s *ntpSource) String() string { return s.addr }
+
+// Offset ...
+func (s *ntpSource) Offset(ctx context.Context, clock xtime.Clock) (time.Duration, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", s.host)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	var req = make([]byte, 48)
+	// leap indicator 0, version 4, mode 3 (client)
+	req[0] = 0x23
+	t1 := clock.Now()
+	// the transmit timestamp is echoed as the originate timestamp
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(t1))
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	var resp = make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	t4 := clock.Now()
+	switch {
+	case n < 48:
+		return 0, errors.New("ntp: short response")
+	case resp[0]&0x07 != 4:
+		return 0, errors.New("ntp: not a server response")
+	case resp[1] == 0:
+		return 0, errors.New("ntp: kiss of death")
+	case binary.BigEndian.Uint64(resp[24:]) != binary.BigEndian.Uint64(req[40:]):
+		return 0, errors.New("ntp: originate timestamp mismatch")
+	}
+	t2 := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	t3 := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	sec := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return sec<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	sec := int64(v>>32) - ntpEpochOffset
+	nsec := int64((v & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(sec, nsec)
+}
+
+// httpSource reads the server time from Date header, e.g. of etcd
+type httpSource struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSource) String() string { return s.url }
+
+// Offset ...
+func (s *httpSource) Offset(ctx context.Context, clock xtime.Clock) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	t1 := clock.Now()
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	t4 := clock.Now()
+	resp.Body.Close()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.Wrap(err, "parse Date header")
+	}
+	// Date is truncated to seconds, so the server time is half a second
+	// later on average
+	server := date.Add(time.Second / 2)
+	return server.Sub(t1.Add(t4.Sub(t1) / 2)), nil
+}
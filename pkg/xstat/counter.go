@@ -0,0 +1,82 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xstat
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	f64bits     = math.Float64bits
+	f64frombits = math.Float64frombits
+)
+
+// RollingCounter counts events in a sliding window
+type RollingCounter struct {
+	window
+	counts []int64
+}
+
+// NewRollingCounter returns a counter of size buckets, each covers width
+func NewRollingCounter(size int, width time.Duration) *RollingCounter {
+	return &RollingCounter{
+		window: newWindow(size, width),
+		counts: make([]int64, size),
+	}
+}
+
+// Add adds delta to the bucket of now
+func (rc *RollingCounter) Add(delta int64) {
+	idx, _ := rc.current(func(i int) { atomic.StoreInt64(&rc.counts[i], 0) })
+	atomic.AddInt64(&rc.counts[idx], delta)
+}
+
+// Inc ...
+func (rc *RollingCounter) Inc() {
+	rc.Add(1)
+}
+
+// Sum returns the total count inside the window
+func (rc *RollingCounter) Sum() int64 {
+	epoch := clock.Now().UnixNano() / rc.width
+	var sum int64
+	for i := range rc.counts {
+		if rc.valid(i, epoch) {
+			sum += atomic.LoadInt64(&rc.counts[i])
+		}
+	}
+	return sum
+}
+
+// Rate returns the count per second inside the window
+func (rc *RollingCounter) Rate() float64 {
+	return float64(rc.Sum()) / rc.Span().Seconds()
+}
+
+// Buckets returns counts of each bucket inside the window, oldest first
+func (rc *RollingCounter) Buckets() []int64 {
+	epoch := clock.Now().UnixNano() / rc.width
+	var buckets = make([]int64, rc.size)
+	for i := 0; i < rc.size; i++ {
+		e := epoch - int64(rc.size-1-i)
+		idx := int(e % int64(rc.size))
+		if atomic.LoadInt64(&rc.epochs[idx]) == e {
+			buckets[i] = atomic.LoadInt64(&rc.counts[idx])
+		}
+	}
+	return buckets
+}
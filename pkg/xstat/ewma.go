@@ -0,0 +1,65 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xstat
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// EWMA is a moving average whose weight of samples decays exponentially
+// with time, e.g. latencies of endpoints picked by balancers
+type EWMA struct {
+	decay time.Duration
+
+	mu    sync.Mutex
+	value float64
+	// stamp is the time of the last sample, zero before the first one
+	stamp time.Time
+}
+
+// NewEWMA returns an average whose samples older than decay weigh less than 1/e
+func NewEWMA(decay time.Duration) *EWMA {
+	if decay <= 0 {
+		panic("xstat: ewma decay must be positive")
+	}
+	return &EWMA{decay: decay}
+}
+
+// Observe adds sample v at now, the weight of the average decays with the
+// time since the last sample, so that idle averages follow new samples quickly
+func (e *EWMA) Observe(now time.Time, v float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stamp.IsZero() {
+		e.value, e.stamp = v, now
+		return
+	}
+	elapsed := now.Sub(e.stamp)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	w := math.Exp(-float64(elapsed) / float64(e.decay))
+	e.value = e.value*w + v*(1-w)
+	e.stamp = now
+}
+
+// Value returns the average, zero before the first sample
+func (e *EWMA) Value() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.value
+}
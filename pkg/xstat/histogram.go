@@ -0,0 +1,155 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xstat
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBounds are upper bounds in seconds, suitable for request latency
+var DefaultLatencyBounds = []float64{.001, .002, .005, .01, .02, .05, .1, .2, .5, 1, 2, 5, 10}
+
+type histogramBucket struct {
+	count  int64
+	sum    uint64 // float64 bits
+	max    uint64 // float64 bits
+	counts []int64
+}
+
+// RollingHistogram records value distribution in a sliding window
+type RollingHistogram struct {
+	window
+	bounds  []float64
+	buckets []histogramBucket
+}
+
+// NewRollingHistogram returns a histogram of size buckets, each covers width,
+// values are counted by the sorted upper bounds, DefaultLatencyBounds if nil
+func NewRollingHistogram(size int, width time.Duration, bounds []float64) *RollingHistogram {
+	if bounds == nil {
+		bounds = DefaultLatencyBounds
+	}
+	if !sort.Float64sAreSorted(bounds) {
+		panic("xstat: histogram bounds must be sorted")
+	}
+	rh := &RollingHistogram{
+		window:  newWindow(size, width),
+		bounds:  bounds,
+		buckets: make([]histogramBucket, size),
+	}
+	for i := range rh.buckets {
+		rh.buckets[i].counts = make([]int64, len(bounds)+1)
+	}
+	return rh
+}
+
+// Observe records v into the bucket of now
+func (rh *RollingHistogram) Observe(v float64) {
+	idx, _ := rh.current(rh.reset)
+	bucket := &rh.buckets[idx]
+	atomic.AddInt64(&bucket.counts[sort.SearchFloat64s(rh.bounds, v)], 1)
+	addFloat64(&bucket.sum, v)
+	maxFloat64(&bucket.max, v)
+	atomic.AddInt64(&bucket.count, 1)
+}
+
+// ObserveDuration records d in seconds
+func (rh *RollingHistogram) ObserveDuration(d time.Duration) {
+	rh.Observe(d.Seconds())
+}
+
+func (rh *RollingHistogram) reset(i int) {
+	bucket := &rh.buckets[i]
+	atomic.StoreInt64(&bucket.count, 0)
+	atomic.StoreUint64(&bucket.sum, 0)
+	atomic.StoreUint64(&bucket.max, 0)
+	for j := range bucket.counts {
+		atomic.StoreInt64(&bucket.counts[j], 0)
+	}
+}
+
+// Snapshot is the aggregated histogram inside the window
+type Snapshot struct {
+	Count  int64
+	Sum    float64
+	Max    float64
+	Bounds []float64
+	Counts []int64
+}
+
+// Snapshot aggregates buckets inside the window
+func (rh *RollingHistogram) Snapshot() Snapshot {
+	epoch := clock.Now().UnixNano() / rh.width
+	snapshot := Snapshot{
+		Bounds: rh.bounds,
+		Counts: make([]int64, len(rh.bounds)+1),
+	}
+	for i := range rh.buckets {
+		if !rh.valid(i, epoch) {
+			continue
+		}
+		bucket := &rh.buckets[i]
+		snapshot.Count += atomic.LoadInt64(&bucket.count)
+		snapshot.Sum += f64frombits(atomic.LoadUint64(&bucket.sum))
+		snapshot.Max = math.Max(snapshot.Max, f64frombits(atomic.LoadUint64(&bucket.max)))
+		for j := range bucket.counts {
+			snapshot.Counts[j] += atomic.LoadInt64(&bucket.counts[j])
+		}
+	}
+	return snapshot
+}
+
+// Mean ...
+func (s Snapshot) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) with linear interpolation
+// inside the matched bound, values over the largest bound are capped by Max
+func (s Snapshot) Quantile(q float64) float64 {
+	var total int64
+	for _, c := range s.Counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := q * float64(total)
+	var cumulative int64
+	for i, c := range s.Counts {
+		if c == 0 || float64(cumulative+c) < rank {
+			cumulative += c
+			continue
+		}
+		lower, upper := 0.0, s.Max
+		if i > 0 {
+			lower = s.Bounds[i-1]
+		}
+		if i < len(s.Bounds) && s.Bounds[i] < upper {
+			upper = s.Bounds[i]
+		}
+		if upper < lower {
+			return upper
+		}
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(c)
+	}
+	return s.Max
+}
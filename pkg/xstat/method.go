@@ -0,0 +1,129 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xstat
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	methodWindowSize  = 10
+	methodWindowWidth = time.Second
+	// maxMethods caps the number of methods tracked, the rest are
+	// aggregated into OtherMethod, e.g. http paths with ids inside
+	maxMethods = 1024
+)
+
+// OtherMethod aggregates methods beyond the cap
+const OtherMethod = "other"
+
+type methodStat struct {
+	typ      string
+	method   string
+	requests *RollingCounter
+	errors   *RollingCounter
+	latency  *RollingHistogram
+}
+
+var (
+	methodStats sync.Map // type+" "+method => *methodStat
+	methodCount int32
+)
+
+func getMethodStat(typ, method string) *methodStat {
+	if ms, ok := methodStats.Load(typ + " " + method); ok {
+		return ms.(*methodStat)
+	}
+	// the cap may be exceeded slightly by concurrent first requests, which is fine
+	if atomic.LoadInt32(&methodCount) >= maxMethods {
+		method = OtherMethod
+	}
+	ms, loaded := methodStats.LoadOrStore(typ+" "+method, &methodStat{
+		typ:      typ,
+		method:   method,
+		requests: NewRollingCounter(methodWindowSize, methodWindowWidth),
+		errors:   NewRollingCounter(methodWindowSize, methodWindowWidth),
+		latency:  NewRollingHistogram(methodWindowSize, methodWindowWidth, nil),
+	})
+	if !loaded && method != OtherMethod {
+		atomic.AddInt32(&methodCount, 1)
+	}
+	return ms.(*methodStat)
+}
+
+// RecordMethod records a request of method into the rolling 10s window
+func RecordMethod(typ, method string, cost time.Duration, failed bool) {
+	ms := getMethodStat(typ, method)
+	ms.requests.Inc()
+	if failed {
+		ms.errors.Inc()
+	}
+	ms.latency.ObserveDuration(cost)
+}
+
+// LatencySnapshot latency in seconds
+type LatencySnapshot struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// MethodSnapshot is the rolling stat of a method
+type MethodSnapshot struct {
+	Type      string          `json:"type"`
+	Method    string          `json:"method"`
+	QPS       float64         `json:"qps"`
+	ErrorRate float64         `json:"errorRate"`
+	Latency   LatencySnapshot `json:"latency"`
+}
+
+// MethodSnapshots returns stats of methods requested inside the window, busiest first
+func MethodSnapshots() []MethodSnapshot {
+	var snapshots = make([]MethodSnapshot, 0)
+	methodStats.Range(func(_, v interface{}) bool {
+		ms := v.(*methodStat)
+		requests := ms.requests.Sum()
+		if requests == 0 {
+			return true
+		}
+		latency := ms.latency.Snapshot()
+		snapshots = append(snapshots, MethodSnapshot{
+			Type:      ms.typ,
+			Method:    ms.method,
+			QPS:       float64(requests) / ms.requests.Span().Seconds(),
+			ErrorRate: float64(ms.errors.Sum()) / float64(requests),
+			Latency: LatencySnapshot{
+				Mean: latency.Mean(),
+				P50:  latency.Quantile(0.5),
+				P90:  latency.Quantile(0.9),
+				P99:  latency.Quantile(0.99),
+				Max:  latency.Max,
+			},
+		})
+		return true
+	})
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].QPS != snapshots[j].QPS {
+			return snapshots[i].QPS > snapshots[j].QPS
+		}
+		return snapshots[i].Method < snapshots[j].Method
+	})
+	return snapshots
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xstat provides lock-light sliding window statistics shared by
+// breakers, adaptive limiters and balancers.
+//
+// A window is a ring of buckets, each bucket covers a fixed width of time.
+// Writers only touch the bucket of current time with atomic operations,
+// a bucket is reset lazily when it's reused for a later time span.
+// Readers aggregate all buckets still inside the window. EWMA averages
+// samples whose weight decays with time instead.
+package xstat
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+)
+
+// clock is replaced by a mock clock in tests
+var clock xtime.Clock = xtime.SystemClock
+
+type window struct {
+	size  int
+	width int64 // bucket width in nanoseconds
+	mu    sync.Mutex
+	// epochs[i] is the index of time span which bucket i currently records
+	epochs []int64
+}
+
+func newWindow(size int, width time.Duration) window {
+	if size <= 0 {
+		panic("xstat: window size must be positive")
+	}
+	if width <= 0 {
+		panic("xstat: bucket width must be positive")
+	}
+	return window{
+		size:   size,
+		width:  int64(width),
+		epochs: make([]int64, size),
+	}
+}
+
+// current returns the bucket index of now, reset is called with the
+// index under lock if the bucket holds data of an expired time span.
+func (w *window) current(reset func(int)) (int, int64) {
+	epoch := clock.Now().UnixNano() / w.width
+	idx := int(epoch % int64(w.size))
+	if atomic.LoadInt64(&w.epochs[idx]) != epoch {
+		w.mu.Lock()
+		if atomic.LoadInt64(&w.epochs[idx]) != epoch {
+			reset(idx)
+			atomic.StoreInt64(&w.epochs[idx], epoch)
+		}
+		w.mu.Unlock()
+	}
+	return idx, epoch
+}
+
+// valid reports whether bucket idx is inside the window ending at epoch
+func (w *window) valid(idx int, epoch int64) bool {
+	return epoch-atomic.LoadInt64(&w.epochs[idx]) < int64(w.size)
+}
+
+// Span returns the time span covered by the window
+func (w *window) Span() time.Duration {
+	return time.Duration(int64(w.size) * w.width)
+}
+
+func addFloat64(addr *uint64, delta float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if atomic.CompareAndSwapUint64(addr, old, f64bits(f64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func maxFloat64(addr *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if f64frombits(old) >= v {
+			return
+		}
+		if atomic.CompareAndSwapUint64(addr, old, f64bits(v)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xstat
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func withMockClock(t *testing.T) *xtime.MockClock {
+	mc := xtime.NewMockClock(time.Unix(1600000000, 0))
+	clock = mc
+	t.Cleanup(func() { clock = xtime.SystemClock })
+	return mc
+}
+
+func TestRollingCounter(t *testing.T) {
+	mc := withMockClock(t)
+	rc := NewRollingCounter(10, 100*time.Millisecond)
+
+	rc.Add(3)
+	mc.Advance(100 * time.Millisecond)
+	rc.Inc()
+	assert.Equal(t, int64(4), rc.Sum())
+	assert.Equal(t, float64(4), rc.Rate())
+	assert.Equal(t, []int64{0, 0, 0, 0, 0, 0, 0, 0, 3, 1}, rc.Buckets())
+
+	// the first bucket expires
+	mc.Advance(900 * time.Millisecond)
+	assert.Equal(t, int64(1), rc.Sum())
+
+	// bucket reused by a later span is reset
+	rc.Add(5)
+	assert.Equal(t, int64(6), rc.Sum())
+
+	mc.Advance(time.Hour)
+	assert.Equal(t, int64(0), rc.Sum())
+}
+
+func TestRollingCounterConcurrent(t *testing.T) {
+	withMockClock(t)
+	rc := NewRollingCounter(10, time.Second)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				rc.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(8000), rc.Sum())
+}
+
+func TestRollingHistogram(t *testing.T) {
+	mc := withMockClock(t)
+	rh := NewRollingHistogram(5, time.Second, []float64{1, 2, 5, 10})
+
+	for i := 1; i <= 10; i++ {
+		rh.Observe(float64(i))
+	}
+	snapshot := rh.Snapshot()
+	assert.Equal(t, int64(10), snapshot.Count)
+	assert.Equal(t, float64(55), snapshot.Sum)
+	assert.Equal(t, 5.5, snapshot.Mean())
+	assert.Equal(t, float64(10), snapshot.Max)
+	assert.Equal(t, []int64{1, 1, 3, 5, 0}, snapshot.Counts)
+	assert.Equal(t, float64(5), snapshot.Quantile(0.5))
+	assert.Equal(t, float64(10), snapshot.Quantile(1))
+	assert.InDelta(t, 9, snapshot.Quantile(0.9), 0.001)
+
+	mc.Advance(time.Second)
+	rh.ObserveDuration(20 * time.Second)
+	snapshot = rh.Snapshot()
+	assert.Equal(t, int64(11), snapshot.Count)
+	assert.Equal(t, float64(20), snapshot.Max)
+	assert.Equal(t, float64(20), snapshot.Quantile(1))
+
+	mc.Advance(4 * time.Second)
+	assert.Equal(t, int64(1), rh.Snapshot().Count)
+	mc.Advance(time.Second)
+	assert.Equal(t, Snapshot{Bounds: rh.bounds, Counts: make([]int64, 5)}, rh.Snapshot())
+	assert.Equal(t, float64(0), rh.Snapshot().Quantile(0.99))
+}
+
+func BenchmarkRollingCounter(b *testing.B) {
+	rc := NewRollingCounter(10, 100*time.Millisecond)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rc.Inc()
+		}
+	})
+}
+
+func BenchmarkRollingHistogram(b *testing.B) {
+	rh := NewRollingHistogram(10, 100*time.Millisecond, nil)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rh.Observe(0.015)
+		}
+	})
+}
+
+func TestEWMA(t *testing.T) {
+	e := NewEWMA(10 * time.Second)
+	start := time.Unix(0, 0)
+	e.Observe(start, 100)
+	assert.Equal(t, float64(100), e.Value())
+
+	// samples right after the last one barely move the average
+	e.Observe(start, 1)
+	assert.Equal(t, float64(100), e.Value())
+
+	// samples long after the last one mostly replace it
+	e.Observe(start.Add(time.Minute), 1)
+	assert.InDelta(t, float64(1), e.Value(), 1)
+}
+
+func TestMethodSnapshots(t *testing.T) {
+	mc := withMockClock(t)
+	for i := 0; i < 20; i++ {
+		RecordMethod("unary", "/demo.Hello/Busy", 10*time.Millisecond, i%4 == 0)
+	}
+	RecordMethod("unary", "/demo.Hello/Idle", time.Second, false)
+
+	snapshots := MethodSnapshots()
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, "/demo.Hello/Busy", snapshots[0].Method)
+	assert.Equal(t, float64(2), snapshots[0].QPS)
+	assert.Equal(t, 0.25, snapshots[0].ErrorRate)
+	assert.InDelta(t, 0.01, snapshots[0].Latency.Max, 1e-9)
+	assert.Equal(t, float64(1), snapshots[1].Latency.Max)
+
+	// all requests are out of the window
+	mc.Advance(10 * time.Second)
+	assert.Len(t, MethodSnapshots(), 0)
+}
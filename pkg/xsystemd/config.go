@@ -0,0 +1,77 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xsystemd
+
+import (
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+// ConfigKey ...
+const ConfigKey = "jupiter.systemd"
+
+func init() {
+	xschema.RegisterConfig("systemd", ConfigKey, "systemd notify and watchdog", DefaultConfig)
+}
+
+// Config ...
+type Config struct {
+	// Watchdog sends heartbeats if WatchdogSec of the unit is set
+	Watchdog bool
+	// WatchdogChecks are patterns of readiness checks, e.g. "skew:*",
+	// heartbeats stop while any matching check fails so that systemd
+	// restarts the application
+	WatchdogChecks []string
+
+	logger *xlog.Logger
+	clock  xtime.Clock
+	notify func(states ...string) (bool, error)
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Watchdog: true,
+		logger:   xlog.JupiterLogger.With(xlog.FieldMod("systemd")),
+		clock:    xtime.SystemClock,
+		notify:   Notify,
+	}
+}
+
+// StdConfig ...
+func StdConfig() *Config {
+	return RawConfig(ConfigKey)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("systemd parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Notifier {
+	return newNotifier(config)
+}
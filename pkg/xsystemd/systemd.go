@@ -0,0 +1,150 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xsystemd integrates applications managed by systemd: READY=1 is
+// sent once the application is started and ready, STOPPING=1 once it's
+// stopping, and watchdog heartbeats are sent while it's healthy if
+// WatchdogSec of the unit is set, so that systemd restarts it otherwise.
+package xsystemd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/douyu/jupiter/pkg/server/readiness"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+)
+
+// Notifier notifies systemd of states of the application
+type Notifier struct {
+	*Config
+
+	mu      sync.Mutex
+	started bool
+	ready   bool
+	stopped bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func newNotifier(config *Config) *Notifier {
+	n := &Notifier{Config: config, stop: make(chan struct{})}
+	readiness.OnChange(n.onReadiness)
+	return n
+}
+
+// Start sends watchdog heartbeats if it's enabled, it should be called as
+// early as possible for slow initialization
+func (n *Notifier) Start() {
+	interval, ok := WatchdogInterval()
+	if !n.Watchdog || !ok {
+		return
+	}
+	// heartbeats are sent twice per interval as sd_watchdog_enabled suggests
+	ticker := n.clock.NewTicker(interval / 2)
+	xgo.Go(func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.stop:
+				return
+			case <-ticker.C():
+			}
+			if err := n.healthy(); err != nil {
+				n.logger.Warn("skip watchdog heartbeat", xlog.FieldErr(err))
+				continue
+			}
+			n.send(StateWatchdog)
+		}
+	})
+}
+
+// Ready marks the application started, READY=1 is sent once it's ready
+func (n *Notifier) Ready() {
+	n.mu.Lock()
+	n.started = true
+	n.mu.Unlock()
+	n.onReadiness(readiness.Ready())
+}
+
+// Stopping sends STOPPING=1
+func (n *Notifier) Stopping() error {
+	n.mu.Lock()
+	n.stopped = true
+	n.mu.Unlock()
+	n.send(StateStopping)
+	return nil
+}
+
+// Stop stops watchdog heartbeats
+func (n *Notifier) Stop() error {
+	n.stopOnce.Do(func() { close(n.stop) })
+	return nil
+}
+
+func (n *Notifier) onReadiness(ready bool) {
+	n.mu.Lock()
+	if !n.started || n.stopped {
+		n.mu.Unlock()
+		return
+	}
+	// READY=1 is sent once, later changes only update the status
+	first := ready && !n.ready
+	if ready {
+		n.ready = true
+	}
+	n.mu.Unlock()
+
+	if ready {
+		var states = []string{"STATUS=ready"}
+		if first {
+			states = append([]string{StateReady}, states...)
+		}
+		n.send(states...)
+		return
+	}
+	n.send("STATUS=not ready: " + failures(readiness.Failures()))
+}
+
+// healthy returns the failure of readiness checks matching WatchdogChecks
+func (n *Notifier) healthy() error {
+	for check, msg := range readiness.Failures() {
+		for _, pattern := range n.WatchdogChecks {
+			if ok, _ := path.Match(pattern, check); ok {
+				return fmt.Errorf("%s: %s", check, msg)
+			}
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) send(states ...string) {
+	if _, err := n.notify(states...); err != nil {
+		n.logger.Error("systemd notify", xlog.FieldErr(err), xlog.Any("states", states))
+	}
+}
+
+func failures(m map[string]string) string {
+	var ret = make([]string, 0, len(m))
+	for check, msg := range m {
+		ret = append(ret, check+": "+msg)
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ", ")
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xsystemd
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/server/readiness"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "systemd")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	os.Unsetenv("NOTIFY_SOCKET")
+	ok, err := Notify(StateReady)
+	assert.False(t, ok)
+	assert.Nil(t, err)
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	ok, err = Notify(StateReady, "STATUS=ready")
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	var buf = make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "READY=1\nSTATUS=ready", string(buf[:n]))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+	_, ok := WatchdogInterval()
+	assert.False(t, ok)
+
+	os.Setenv("WATCHDOG_USEC", "3000000")
+	interval, ok := WatchdogInterval()
+	assert.True(t, ok)
+	assert.Equal(t, time.Second*3, interval)
+
+	// the watchdog is of another process
+	os.Setenv("WATCHDOG_PID", "1")
+	_, ok = WatchdogInterval()
+	assert.False(t, ok)
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	states []string
+}
+
+func (r *recorder) notify(states ...string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states = append(r.states, states...)
+	return true, nil
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.states...)
+}
+
+func TestNotifier(t *testing.T) {
+	var r recorder
+	clock := xtime.NewMockClock(time.Now())
+	config := DefaultConfig()
+	config.WatchdogChecks = []string{"systemd-test:*"}
+	config.clock, config.notify = clock, r.notify
+	n := config.Build()
+
+	os.Setenv("WATCHDOG_USEC", "2000000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	n.Start()
+	defer n.Stop()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return len(r.get()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, []string{StateWatchdog}, r.get())
+
+	// not ready until failed checks pass
+	readiness.Fail("systemd-test:db", errors.New("unreachable"))
+	defer readiness.Pass("systemd-test:db")
+	n.Ready()
+	assert.Equal(t, "STATUS=not ready: systemd-test:db: unreachable", r.get()[1])
+
+	// and no heartbeats
+	clock.Advance(time.Second)
+	assert.Never(t, func() bool { return len(r.get()) > 2 }, time.Millisecond*50, time.Millisecond)
+
+	readiness.Pass("systemd-test:db")
+	assert.Equal(t, []string{StateReady, "STATUS=ready"}, r.get()[2:])
+
+	assert.Nil(t, n.Stopping())
+	assert.Equal(t, StateStopping, r.get()[4])
+}
@@ -0,0 +1,285 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xtls keeps CA bundles trusted by outbound HTTPS and gRPC clients,
+// which are reloaded once their files change, e.g. on rotations of corporate
+// CAs, so that new connections trust them without restarts.
+package xtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/metric"
+	"github.com/douyu/jupiter/pkg/util/xgo"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	ageGauge = metric.GaugeVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "tls_bundle_age_seconds",
+		Help:      "seconds since the CA bundle was loaded",
+		Labels:    []string{"name"},
+	}.Build()
+	parseFailureCounter = metric.CounterVecOpts{
+		Namespace: metric.DefaultNamespace,
+		Name:      "tls_bundle_parse_failure_total",
+		Help:      "failures of parsing the CA bundle, the previous one is kept",
+		Labels:    []string{"name"},
+	}.Build()
+)
+
+// Bundle is a reloadable pool of CA certificates
+type Bundle struct {
+	config  *Config
+	pool    atomic.Value // *x509.CertPool
+	loaded  int64        // unix nano
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+func newBundle(config *Config) (*Bundle, error) {
+	b := &Bundle{
+		config: config,
+		done:   make(chan struct{}),
+	}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	if config.Watch {
+		if err := b.watch(); err != nil {
+			return nil, err
+		}
+	}
+	xgo.Go(b.updateAge)
+	return b, nil
+}
+
+// Pool returns the certificates of the bundle
+func (b *Bundle) Pool() *x509.CertPool {
+	return b.pool.Load().(*x509.CertPool)
+}
+
+// Age returns the time since the bundle was loaded
+func (b *Bundle) Age() time.Duration {
+	return b.config.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&b.loaded)))
+}
+
+// Reload parses the bundle again, the current one is kept if it fails
+func (b *Bundle) Reload() error {
+	pool, err := loadPool(b.config.Path, b.config.System)
+	if err != nil {
+		parseFailureCounter.Inc(b.config.Name)
+		return err
+	}
+	b.pool.Store(pool)
+	atomic.StoreInt64(&b.loaded, b.config.clock.Now().UnixNano())
+	ageGauge.Set(0, b.config.Name)
+	return nil
+}
+
+// loadPool parses PEM certificates of the file at path, a bundle without
+// certificates is an error, as it's likely truncated
+func loadPool(path string, system bool) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pool = x509.NewCertPool()
+	if system {
+		if pool, err = x509.SystemCertPool(); err != nil {
+			return nil, err
+		}
+	}
+	var count int
+	for len(data) > 0 {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificate %d of %s: %w", count+1, path, err)
+		}
+		pool.AddCert(cert)
+		count++
+	}
+	if count == 0 {
+		return nil, errors.New("no certificate in " + path)
+	}
+	return pool, nil
+}
+
+// TLSConfig returns a client config trusting the current bundle
+func (b *Bundle) TLSConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		RootCAs:    b.Pool(),
+		ServerName: serverName,
+	}
+}
+
+// Transport returns a clone of http.DefaultTransport whose connections trust
+// the bundle of the time they're dialed. HTTPS requests through proxies
+// trust the bundle of the time Transport was called.
+func (b *Bundle) Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = b.TLSConfig("")
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, b.TLSConfig(host))
+		deadline := time.Now().Add(transport.TLSHandshakeTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		_ = conn.SetDeadline(deadline)
+		if err := tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return tlsConn, nil
+	}
+	return transport
+}
+
+// Credentials returns grpc transport credentials whose handshakes trust the
+// bundle of the time they're made
+func (b *Bundle) Credentials() credentials.TransportCredentials {
+	return &bundleCredentials{bundle: b}
+}
+
+// Close stops watching the file
+func (b *Bundle) Close() {
+	select {
+	case <-b.done:
+		return
+	default:
+		close(b.done)
+	}
+	if b.watcher != nil {
+		_ = b.watcher.Close()
+	}
+}
+
+func (b *Bundle) updateAge() {
+	for {
+		select {
+		case <-b.config.clock.After(b.config.AgeInterval):
+			ageGauge.Set(b.Age().Seconds(), b.config.Name)
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func (b *Bundle) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// watch the directory, so that files replaced by rename are noticed
+	path, _ := filepath.Abs(b.config.Path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+	b.watcher = watcher
+	xgo.Go(func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				const writeOrCreateMask = fsnotify.Write | fsnotify.Create
+				if event.Op&writeOrCreateMask == 0 || filepath.Clean(event.Name) != path {
+					continue
+				}
+				// a file being written may be incomplete, the next event reloads it again
+				if err := b.Reload(); err != nil {
+					b.config.logger.Warn("reload tls bundle", xlog.FieldErr(err), xlog.FieldName(b.config.Name), xlog.String("path", path))
+					continue
+				}
+				b.config.logger.Info("reload tls bundle", xlog.FieldName(b.config.Name), xlog.String("path", path))
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				b.config.logger.Error("watch tls bundle", xlog.FieldErr(err), xlog.FieldName(b.config.Name), xlog.String("path", path))
+			}
+		}
+	})
+	return nil
+}
+
+type bundleCredentials struct {
+	bundle     *Bundle
+	serverName string
+}
+
+// ClientHandshake ...
+func (c *bundleCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return credentials.NewTLS(c.bundle.TLSConfig(c.serverName)).ClientHandshake(ctx, authority, conn)
+}
+
+// ServerHandshake ...
+func (c *bundleCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("xtls: bundle credentials are client only")
+}
+
+// Info ...
+func (c *bundleCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+		ServerName:       c.serverName,
+	}
+}
+
+// Clone ...
+func (c *bundleCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+// OverrideServerName ...
+func (c *bundleCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtls
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a server certificate of 127.0.0.1 signed by ca
+func (ca *testCA) issue(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.Nil(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newTestBundle(t *testing.T, data []byte, watch bool) (*Bundle, string) {
+	dir, err := ioutil.TempDir("", "xtls")
+	assert.Nil(t, err)
+	path := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(path, data, 0644))
+	config := DefaultConfig()
+	config.Name = t.Name()
+	config.Path = path
+	config.Watch = watch
+	return config.Build(), dir
+}
+
+func TestBundle_Transport(t *testing.T) {
+	ca, rotated := newTestCA(t, "ca"), newTestCA(t, "rotated")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{rotated.issue(t)}}
+	server.StartTLS()
+	defer server.Close()
+
+	bundle, dir := newTestBundle(t, ca.pem, false)
+	defer os.RemoveAll(dir)
+	defer bundle.Close()
+	client := &http.Client{Transport: bundle.Transport()}
+
+	_, err := client.Get(server.URL)
+	assert.NotNil(t, err)
+
+	// broken bundles are rejected, the previous one is kept
+	assert.Nil(t, ioutil.WriteFile(bundle.config.Path, []byte("-----BEGIN CERTIFICATE-----\nbroken"), 0644))
+	assert.NotNil(t, bundle.Reload())
+	assert.Nil(t, ioutil.WriteFile(bundle.config.Path, append(ca.pem[:len(ca.pem):len(ca.pem)], []byte("-----BEGIN CERTIFICATE-----\nYnJva2Vu\n-----END CERTIFICATE-----\n")...), 0644))
+	assert.NotNil(t, bundle.Reload())
+
+	// new connections trust the rotated bundle
+	assert.Nil(t, ioutil.WriteFile(bundle.config.Path, append(ca.pem, rotated.pem...), 0644))
+	assert.Nil(t, bundle.Reload())
+	resp, err := client.Get(server.URL)
+	assert.Nil(t, err)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+func TestBundle_Watch(t *testing.T) {
+	ca, rotated := newTestCA(t, "ca"), newTestCA(t, "rotated")
+	bundle, dir := newTestBundle(t, ca.pem, true)
+	defer os.RemoveAll(dir)
+	defer bundle.Close()
+	assert.Len(t, bundle.Pool().Subjects(), 1)
+
+	// files are replaced by rename on rotations
+	tmp := filepath.Join(dir, "ca.pem.tmp")
+	assert.Nil(t, ioutil.WriteFile(tmp, append(ca.pem, rotated.pem...), 0644))
+	assert.Nil(t, os.Rename(tmp, bundle.config.Path))
+	assert.Eventually(t, func() bool {
+		return len(bundle.Pool().Subjects()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// bundles of the same name are shared
+	config := DefaultConfig()
+	config.Name = t.Name()
+	assert.Equal(t, bundle, config.Build())
+}
+
+func TestBundle_Credentials(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	bundle, dir := newTestBundle(t, ca.pem, false)
+	defer os.RemoveAll(dir)
+	defer bundle.Close()
+
+	client, server := net.Pipe()
+	serverCert := ca.issue(t)
+	go func() {
+		conn := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{serverCert}})
+		_, _ = io.Copy(ioutil.Discard, conn)
+	}()
+	creds := bundle.Credentials()
+	assert.Nil(t, creds.OverrideServerName("127.0.0.1"))
+	conn, info, err := creds.ClientHandshake(context.Background(), "127.0.0.1:443", client)
+	assert.Nil(t, err)
+	assert.Equal(t, "tls", info.AuthType())
+	conn.Close()
+}
+
+func TestBundle_Age(t *testing.T) {
+	ca := newTestCA(t, "ca")
+	dir, err := ioutil.TempDir("", "xtls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(path, ca.pem, 0644))
+
+	clock := xtime.NewMockClock(time.Unix(1000, 0))
+	config := DefaultConfig()
+	config.Path = path
+	config.Watch = false
+	config.clock = clock
+	bundle, err := newBundle(config)
+	assert.Nil(t, err)
+	defer bundle.Close()
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, time.Minute, bundle.Age())
+	assert.Nil(t, bundle.Reload())
+	assert.Equal(t, time.Duration(0), bundle.Age())
+}
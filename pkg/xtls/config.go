@@ -0,0 +1,102 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xtls
+
+import (
+	"sync"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/conf"
+	"github.com/douyu/jupiter/pkg/ecode"
+	"github.com/douyu/jupiter/pkg/util/xtime"
+	"github.com/douyu/jupiter/pkg/xlog"
+	"github.com/douyu/jupiter/pkg/xschema"
+)
+
+func init() {
+	xschema.RegisterConfig("tls.bundle", "jupiter.tls.*", "reloadable CA bundle trusted by outbound HTTPS and gRPC clients", DefaultConfig)
+}
+
+var bundles sync.Map // name => *Bundle
+
+// Config ...
+type Config struct {
+	// Name of the bundle, bundles of the same name are shared
+	Name string
+	// Path of the PEM file of CA certificates
+	Path string
+	// System trusts the system roots besides the bundle
+	System bool
+	// Watch reloads the bundle once the file changes
+	Watch bool
+	// AgeInterval of updating the age metric
+	AgeInterval time.Duration
+
+	logger *xlog.Logger
+	clock  xtime.Clock
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Name:        "default",
+		Watch:       true,
+		AgeInterval: 30 * time.Second,
+		logger:      xlog.JupiterLogger.With(xlog.FieldMod("xtls")),
+		clock:       xtime.SystemClock,
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	config := RawConfig("jupiter.tls." + name)
+	if config.Name == "default" {
+		config.Name = name
+	}
+	return config
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("tls bundle parse config panic",
+			xlog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr),
+			xlog.FieldErr(err), xlog.FieldKey(key),
+			xlog.FieldValueAny(config),
+		)
+	}
+	return config
+}
+
+// Build returns the bundle of Name, it's loaded once and shared by all
+// clients, panics if it fails to load
+func (config *Config) Build() *Bundle {
+	if value, ok := bundles.Load(config.Name); ok {
+		return value.(*Bundle)
+	}
+	if config.AgeInterval <= 0 {
+		config.AgeInterval = 30 * time.Second
+	}
+	bundle, err := newBundle(config)
+	if err != nil {
+		config.logger.Panic("load tls bundle", xlog.FieldErr(err), xlog.FieldName(config.Name), xlog.String("path", config.Path))
+	}
+	if value, loaded := bundles.LoadOrStore(config.Name, bundle); loaded {
+		bundle.Close()
+		return value.(*Bundle)
+	}
+	return bundle
+}
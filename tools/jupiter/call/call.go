@@ -0,0 +1,277 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package call
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/registry/etcdv3"
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/douyu/jupiter/pkg/util/xcolor"
+	"github.com/golang/protobuf/proto"
+	"github.com/jhump/protoreflect/desc"
+	"github.com/jhump/protoreflect/dynamic"
+	"github.com/jhump/protoreflect/dynamic/grpcdynamic"
+	"github.com/jhump/protoreflect/grpcreflect"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// Run lists services or methods of the instance, or invokes a method
+func Run(c *cli.Context) error {
+	option.etcd = c.StringSlice("etcd")
+	option.headers = c.StringSlice("header")
+
+	ctx, cancel := context.WithTimeout(context.Background(), option.timeout)
+	defer cancel()
+
+	addr, err := resolveAddr(ctx)
+	if err != nil {
+		fmt.Println(xcolor.Red(err.Error()))
+		return nil
+	}
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return errors.Wrapf(err, "dial %s", addr)
+	}
+	defer conn.Close()
+
+	refClient := grpcreflect.NewClient(ctx, rpb.NewServerReflectionClient(conn))
+	defer refClient.Reset()
+
+	service, method := splitMethod(c.Args().First())
+	if service == "" {
+		return listServices(refClient)
+	}
+	sd, err := refClient.ResolveService(service)
+	if err != nil {
+		return errors.Wrapf(err, "resolve service %s", service)
+	}
+	if method == "" {
+		listMethods(sd)
+		return nil
+	}
+	md := sd.FindMethodByName(method)
+	if md == nil {
+		return errors.Errorf("method %s not found in %s", method, service)
+	}
+	if md.IsClientStreaming() {
+		return errors.Errorf("client streaming method %s is not supported", method)
+	}
+
+	data, err := readData(option.data, os.Stdin)
+	if err != nil {
+		return err
+	}
+	req := dynamic.NewMessage(md.GetInputType())
+	if err := req.UnmarshalJSON(data); err != nil {
+		return errors.Wrapf(err, "unmarshal request to %s", md.GetInputType().GetFullyQualifiedName())
+	}
+
+	outMD, err := outgoingMD(option.aid, option.headers)
+	if err != nil {
+		return err
+	}
+	ctx = metadata.NewOutgoingContext(ctx, outMD)
+	return invoke(ctx, grpcdynamic.NewStub(conn), md, req)
+}
+
+// resolveAddr returns --addr, or a random enabled instance of --service in registry
+func resolveAddr(ctx context.Context) (string, error) {
+	if option.addr != "" {
+		return option.addr, nil
+	}
+	if option.service == "" || len(option.etcd) == 0 {
+		return "", errors.New("no instance address, please use jupiter call -h for details")
+	}
+	config := etcdv3.DefaultConfig()
+	config.Endpoints = option.etcd
+	config.Prefix = option.prefix
+	config.Tenant = option.tenant
+	reg := config.Build()
+	defer reg.Close()
+
+	services, err := reg.ListServices(ctx, option.service, "grpc")
+	if err != nil {
+		return "", errors.Wrapf(err, "list instances of %s", option.service)
+	}
+	return pickAddr(services)
+}
+
+func pickAddr(services []*server.ServiceInfo) (string, error) {
+	var addrs = make([]string, 0, len(services))
+	for _, service := range services {
+		if service.Enable {
+			addrs = append(addrs, service.Address)
+		}
+	}
+	if len(addrs) == 0 {
+		return "", errors.Errorf("no enabled instance of %s", option.service)
+	}
+	return addrs[rand.Intn(len(addrs))], nil
+}
+
+func listServices(refClient *grpcreflect.Client) error {
+	services, err := refClient.ListServices()
+	if err != nil {
+		return errors.Wrap(err, "list services")
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		fmt.Println(service)
+	}
+	return nil
+}
+
+func listMethods(sd *desc.ServiceDescriptor) {
+	for _, md := range sd.GetMethods() {
+		fmt.Printf("%s/%s(%s%s) returns (%s%s)\n",
+			sd.GetFullyQualifiedName(), md.GetName(),
+			streamPrefix(md.IsClientStreaming()), md.GetInputType().GetFullyQualifiedName(),
+			streamPrefix(md.IsServerStreaming()), md.GetOutputType().GetFullyQualifiedName(),
+		)
+	}
+}
+
+func streamPrefix(streaming bool) string {
+	if streaming {
+		return "stream "
+	}
+	return ""
+}
+
+func invoke(ctx context.Context, stub grpcdynamic.Stub, md *desc.MethodDescriptor, req proto.Message) error {
+	var header, trailer metadata.MD
+	opts := []grpc.CallOption{grpc.Header(&header), grpc.Trailer(&trailer)}
+	defer func() {
+		if option.verbose {
+			printMD("header", header)
+			printMD("trailer", trailer)
+		}
+	}()
+
+	if !md.IsServerStreaming() {
+		resp, err := stub.InvokeRpc(ctx, md, req, opts...)
+		if err != nil {
+			printStatus(err)
+			return nil
+		}
+		return printMessage(resp)
+	}
+
+	stream, err := stub.InvokeRpcServerStream(ctx, md, req, opts...)
+	if err != nil {
+		printStatus(err)
+		return nil
+	}
+	for {
+		resp, err := stream.RecvMsg()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			printStatus(err)
+			return nil
+		}
+		if err := printMessage(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func printMessage(msg proto.Message) error {
+	dm, ok := msg.(*dynamic.Message)
+	if !ok {
+		fmt.Println(proto.MarshalTextString(msg))
+		return nil
+	}
+	data, err := dm.MarshalJSONIndent()
+	if err != nil {
+		return errors.Wrap(err, "marshal response")
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func printStatus(err error) {
+	st := status.Convert(err)
+	fmt.Println(xcolor.Red(fmt.Sprintf("code: %s(%d)", st.Code(), st.Code())))
+	fmt.Println(xcolor.Red("message: " + st.Message()))
+	for _, detail := range st.Details() {
+		fmt.Println(xcolor.Red(fmt.Sprintf("detail: %v", detail)))
+	}
+}
+
+func printMD(name string, md metadata.MD) {
+	keys := make([]string, 0, len(md))
+	for key := range md {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s %s: %s\n", name, key, strings.Join(md[key], ", "))
+	}
+}
+
+// splitMethod splits "pkg.Service/Method" into service and method
+func splitMethod(symbol string) (string, string) {
+	symbol = strings.TrimPrefix(symbol, "/")
+	if i := strings.LastIndexByte(symbol, '/'); i >= 0 {
+		return symbol[:i], symbol[i+1:]
+	}
+	return symbol, ""
+}
+
+// readData reads the request from the file of @file, or stdin if @-
+func readData(data string, stdin io.Reader) ([]byte, error) {
+	if !strings.HasPrefix(data, "@") {
+		return []byte(data), nil
+	}
+	if data == "@-" {
+		return ioutil.ReadAll(stdin)
+	}
+	content, err := ioutil.ReadFile(data[1:])
+	if err != nil {
+		return nil, errors.Wrap(err, "read request")
+	}
+	return content, nil
+}
+
+// outgoingMD builds metadata from 'key: value' headers, with aid as jupiter clients do
+func outgoingMD(aid string, headers []string) (metadata.MD, error) {
+	md := metadata.MD{}
+	if aid != "" {
+		md.Set("aid", aid)
+	}
+	for _, header := range headers {
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.Errorf("invalid header %q, want 'key: value'", header)
+		}
+		md.Append(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return md, nil
+}
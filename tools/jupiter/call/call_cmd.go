@@ -0,0 +1,80 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package call
+
+import "github.com/urfave/cli"
+
+var Cmd = cli.Command{
+	Name:            "call",
+	Aliases:         []string{"c"},
+	Usage:           "invoke gRPC methods with JSON via server reflection",
+	Action:          Run,
+	SkipFlagParsing: false,
+	UsageText:       CallHelpTemplate,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "addr,a",
+			Usage:       "gRPC address of the instance, e.g. 127.0.0.1:9091",
+			Destination: &option.addr,
+		},
+		&cli.StringFlag{
+			Name:        "service,s",
+			Usage:       "app name to discover the instance via registry",
+			Destination: &option.service,
+		},
+		&cli.StringSliceFlag{
+			Name:  "etcd,e",
+			Usage: "etcd endpoints of registry",
+		},
+		&cli.StringFlag{
+			Name:        "prefix",
+			Usage:       "key prefix of registry",
+			Value:       defaultPrefix,
+			Destination: &option.prefix,
+		},
+		&cli.StringFlag{
+			Name:        "tenant",
+			Usage:       "tenant of services in registry",
+			Destination: &option.tenant,
+		},
+		&cli.StringFlag{
+			Name:        "data,d",
+			Usage:       "request in JSON, @file to read from file, @- from stdin",
+			Value:       "{}",
+			Destination: &option.data,
+		},
+		&cli.StringSliceFlag{
+			Name:  "header,H",
+			Usage: "request metadata, e.g. 'token: xxx', repeatable",
+		},
+		&cli.StringFlag{
+			Name:        "aid",
+			Usage:       "app id sent as aid metadata",
+			Value:       defaultAID,
+			Destination: &option.aid,
+		},
+		&cli.DurationFlag{
+			Name:        "timeout,t",
+			Usage:       "timeout of the call",
+			Value:       defaultTimeout,
+			Destination: &option.timeout,
+		},
+		&cli.BoolFlag{
+			Name:        "verbose,v",
+			Usage:       "print response headers and trailers",
+			Destination: &option.verbose,
+		},
+	},
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package call
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/douyu/jupiter/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestSplitMethod(t *testing.T) {
+	cases := []struct{ symbol, service, method string }{
+		{"", "", ""},
+		{"helloworld.Greeter", "helloworld.Greeter", ""},
+		{"helloworld.Greeter/SayHello", "helloworld.Greeter", "SayHello"},
+		{"/helloworld.Greeter/SayHello", "helloworld.Greeter", "SayHello"},
+	}
+	for _, c := range cases {
+		service, method := splitMethod(c.symbol)
+		assert.Equal(t, c.service, service, c.symbol)
+		assert.Equal(t, c.method, method, c.symbol)
+	}
+}
+
+func TestReadData(t *testing.T) {
+	data, err := readData(`{"name":"a"}`, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"a"}`, string(data))
+
+	data, err = readData("@-", strings.NewReader(`{"name":"b"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"name":"b"}`, string(data))
+
+	_, err = readData("@/not/exist.json", nil)
+	assert.NotNil(t, err)
+}
+
+func TestOutgoingMD(t *testing.T) {
+	md, err := outgoingMD("cli", []string{"Token: abc", "x-tag: 1", "x-tag:2"})
+	assert.Nil(t, err)
+	assert.Equal(t, metadata.MD{"aid": {"cli"}, "token": {"abc"}, "x-tag": {"1", "2"}}, md)
+
+	_, err = outgoingMD("", []string{"token"})
+	assert.NotNil(t, err)
+}
+
+func TestPickAddr(t *testing.T) {
+	addr, err := pickAddr([]*server.ServiceInfo{
+		{Address: "10.0.0.1:9091"},
+		{Address: "10.0.0.2:9091", Enable: true},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.2:9091", addr)
+
+	_, err = pickAddr([]*server.ServiceInfo{{Address: "10.0.0.1:9091"}})
+	assert.NotNil(t, err)
+}
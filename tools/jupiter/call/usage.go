@@ -0,0 +1,40 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package call
+
+// CallHelpTemplate ...
+const CallHelpTemplate = `
+jupiter call [flags] [service[/method]]
+
+Lists services without service, lists methods without method,
+otherwise invokes the method with JSON request.
+
+The flags are:
+  -a,--addr       gRPC address of the instance
+  -s,--service    app name to discover the instance via registry
+  -e,--etcd       etcd endpoints of registry, repeatable
+  --prefix        key prefix of registry, jupiter by default
+  --tenant        tenant of services in registry, none by default
+  -d,--data       request in JSON, @file to read from file, @- from stdin
+  -H,--header     request metadata 'key: value', repeatable
+  --aid           app id sent as aid metadata, jupiter-cli by default
+  -t,--timeout    timeout of the call, 10s by default
+  -v,--verbose    print response headers and trailers
+Examples:
+   # List services of an instance
+   jupiter call -a 127.0.0.1:9091
+   # Invoke a method of an instance discovered via registry
+   jupiter call -s demo -e 127.0.0.1:2379 -d '{"name":"jupiter"}' helloworld.Greeter/SayHello
+`
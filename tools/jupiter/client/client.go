@@ -0,0 +1,152 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/douyu/jupiter/pkg/client/etcdv3"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// Run fetches the OpenAPI document of the service and generates its client
+func Run(c *cli.Context) error {
+	option.etcd = c.StringSlice("etcd")
+
+	ctx, cancel := context.WithTimeout(context.Background(), option.timeout)
+	defer cancel()
+
+	source, err := resolveSpec(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := readSpec(ctx, source)
+	if err != nil {
+		return err
+	}
+	doc, err := parse(data)
+	if err != nil {
+		return errors.Wrapf(err, "parse %s", source)
+	}
+	service := option.service
+	if service == "" {
+		service = option.pkg
+	}
+	src, err := generate(doc, option.pkg, service, source)
+	if err != nil {
+		return err
+	}
+	if option.output == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(option.output), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(option.output, src, 0644)
+}
+
+// resolveSpec returns --spec, or the url of the document served by the
+// governor at --addr, or a random governor of --service in registry
+func resolveSpec(ctx context.Context) (string, error) {
+	if option.spec != "" {
+		return option.spec, nil
+	}
+	if option.addr != "" {
+		return specURL(option.addr, option.path), nil
+	}
+	if option.service == "" || len(option.etcd) == 0 {
+		return "", errors.New("no OpenAPI document, please use jupiter client -h for details")
+	}
+	config := etcdv3.DefaultConfig()
+	config.Endpoints = option.etcd
+	client := config.Build()
+	defer client.Close()
+
+	kvs, err := client.GetPrefix(ctx, governorPrefix(option.prefix, option.tenant, option.service))
+	if err != nil {
+		return "", errors.Wrapf(err, "list governors of %s", option.service)
+	}
+	addr, err := pickGovernor(kvs)
+	if err != nil {
+		return "", err
+	}
+	return specURL(addr, option.path), nil
+}
+
+// governorPrefix is the key prefix of governors of service registered by
+// registry/etcdv3, e.g. /jupiter/demo/governors/
+func governorPrefix(prefix, tenant, service string) string {
+	if tenant != "" {
+		prefix += "/" + tenant
+	}
+	return fmt.Sprintf("/%s/%s/governors/", prefix, service)
+}
+
+// pickGovernor returns the url of a random governor, keys end with their
+// urls, e.g. /jupiter/demo/governors/http://127.0.0.1:9990
+func pickGovernor(kvs map[string]string) (string, error) {
+	var addrs = make([]string, 0, len(kvs))
+	for key := range kvs {
+		if idx := strings.Index(key, "://"); idx >= 0 {
+			addrs = append(addrs, key[strings.LastIndexByte(key[:idx], '/')+1:])
+		}
+	}
+	if len(addrs) == 0 {
+		return "", errors.Errorf("no governor of %s", option.service)
+	}
+	sort.Strings(addrs)
+	return addrs[rand.Intn(len(addrs))], nil
+}
+
+func specURL(addr, path string) string {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// readSpec reads the document from the file or the url source
+func readSpec(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return ioutil.ReadFile(source)
+	}
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch %s", source)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetch %s", source)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fetch %s: %s", source, resp.Status)
+	}
+	return data, nil
+}
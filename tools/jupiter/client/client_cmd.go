@@ -0,0 +1,81 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import "github.com/urfave/cli"
+
+var Cmd = cli.Command{
+	Name:            "client",
+	Aliases:         []string{"cl"},
+	Usage:           "generate typed rest clients from OpenAPI documents",
+	Action:          Run,
+	SkipFlagParsing: false,
+	UsageText:       ClientHelpTemplate,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "addr,a",
+			Usage:       "governor address of the instance, e.g. 127.0.0.1:9990",
+			Destination: &option.addr,
+		},
+		&cli.StringFlag{
+			Name:        "spec",
+			Usage:       "OpenAPI document, a file or an url",
+			Destination: &option.spec,
+		},
+		&cli.StringFlag{
+			Name:        "service,s",
+			Usage:       "app name to discover the governor via registry",
+			Destination: &option.service,
+		},
+		&cli.StringSliceFlag{
+			Name:  "etcd,e",
+			Usage: "etcd endpoints of registry",
+		},
+		&cli.StringFlag{
+			Name:        "prefix",
+			Usage:       "key prefix of registry",
+			Value:       defaultPrefix,
+			Destination: &option.prefix,
+		},
+		&cli.StringFlag{
+			Name:        "tenant",
+			Usage:       "tenant of services in registry",
+			Destination: &option.tenant,
+		},
+		&cli.StringFlag{
+			Name:        "path",
+			Usage:       "path of the document on governor",
+			Value:       defaultPath,
+			Destination: &option.path,
+		},
+		&cli.StringFlag{
+			Name:        "package,p",
+			Usage:       "package of the generated client",
+			Value:       defaultPackage,
+			Destination: &option.pkg,
+		},
+		&cli.StringFlag{
+			Name:        "output,o",
+			Usage:       "file of the generated client, stdout by default",
+			Destination: &option.output,
+		},
+		&cli.DurationFlag{
+			Name:        "timeout,t",
+			Usage:       "timeout of fetching the document",
+			Value:       defaultTimeout,
+			Destination: &option.timeout,
+		},
+	},
+}
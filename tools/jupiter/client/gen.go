@@ -0,0 +1,457 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// restImport is the package called by generated clients
+const restImport = "github.com/douyu/jupiter/pkg/client/rest"
+
+var httpMethods = map[string]string{
+	"GET":     "http.MethodGet",
+	"HEAD":    "http.MethodHead",
+	"POST":    "http.MethodPost",
+	"PUT":     "http.MethodPut",
+	"PATCH":   "http.MethodPatch",
+	"DELETE":  "http.MethodDelete",
+	"OPTIONS": "http.MethodOptions",
+}
+
+// generator writes the typed client of a document
+type generator struct {
+	doc     *document
+	pkg     string
+	service string
+	source  string
+
+	buf     bytes.Buffer
+	imports map[string]bool
+	// names of generated types and methods
+	names map[string]bool
+}
+
+// generate returns the formatted source of the client of doc in package pkg
+func generate(doc *document, pkg, service, source string) ([]byte, error) {
+	g := &generator{
+		doc:     doc,
+		pkg:     pkg,
+		service: service,
+		source:  source,
+		imports: map[string]bool{"context": true, restImport: true},
+		names:   map[string]bool{"Client": true, "NewClient": true},
+	}
+	g.genClient()
+	g.genSchemas()
+	for _, op := range doc.operations {
+		g.genOperation(op)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by jupiter client from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&out, "package %s\n\nimport (\n", pkg)
+	var imports = make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	for _, path := range imports {
+		// the standard library goes first
+		if path == restImport {
+			continue
+		}
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+	fmt.Fprintf(&out, "\n\t%q\n", restImport)
+	out.WriteString(")\n")
+	out.Write(g.buf.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return out.Bytes(), errors.Wrap(err, "format generated client")
+	}
+	return src, nil
+}
+
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+	g.buf.WriteByte('\n')
+}
+
+func (g *generator) genClient() {
+	g.p("")
+	g.p("// Client of %s", g.service)
+	g.p("type Client struct {")
+	g.p("rest *rest.Client")
+	g.p("}")
+	g.p("")
+	g.p("// NewClient returns a client calling the service with config, e.g.")
+	g.p("// rest.StdConfig(%q), requests are governed by it", g.service)
+	g.p("func NewClient(config *rest.Config) *Client {")
+	g.p("return &Client{rest: config.Build()}")
+	g.p("}")
+}
+
+func (g *generator) genSchemas() {
+	var names = make([]string, 0, len(g.doc.Components.Schemas))
+	for name := range g.doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := g.doc.Components.Schemas[name]
+		typeName := exported(name)
+		g.names[typeName] = true
+		g.p("")
+		g.comment(typeName, s.Description)
+		if isStruct(s) {
+			g.p("type %s %s", typeName, g.structType(s))
+		} else {
+			g.p("type %s %s", typeName, g.goType(s))
+		}
+	}
+}
+
+func (g *generator) comment(name, description string) {
+	if description == "" {
+		g.p("// %s ...", name)
+		return
+	}
+	for idx, line := range strings.Split(strings.TrimSpace(description), "\n") {
+		if idx == 0 {
+			line = name + " " + line
+		}
+		g.p("// %s", strings.TrimSpace(line))
+	}
+}
+
+func isStruct(s *schema) bool {
+	return s != nil && s.Ref == "" && (s.Type == "object" || s.Type == "") && len(s.Properties) > 0
+}
+
+func (g *generator) structType(s *schema) string {
+	var required = make(map[string]bool, len(s.Required))
+	for _, name := range s.Required {
+		required[name] = true
+	}
+	var props = make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		props = append(props, name)
+	}
+	sort.Strings(props)
+
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	for _, name := range props {
+		prop := s.Properties[name]
+		if prop.Description != "" {
+			fmt.Fprintf(&b, "// %s\n", strings.Join(strings.Fields(prop.Description), " "))
+		}
+		tag := name
+		if !required[name] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&b, "%s %s `json:%q`\n", exported(name), g.goType(prop), tag)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// goType returns the go type of s, named schemas are referred by name
+func (g *generator) goType(s *schema) string {
+	if s == nil {
+		return "interface{}"
+	}
+	if s.Ref != "" {
+		return exported(s.Ref[strings.LastIndexByte(s.Ref, '/')+1:])
+	}
+	switch s.Type {
+	case "string":
+		if s.Format == "binary" || s.Format == "byte" {
+			return "[]byte"
+		}
+		return "string"
+	case "integer":
+		if s.Format == "int32" {
+			return "int32"
+		}
+		return "int64"
+	case "number":
+		if s.Format == "float" {
+			return "float32"
+		}
+		return "float64"
+	case "boolean":
+		return "bool"
+	case "array":
+		return "[]" + g.goType(s.Items)
+	}
+	if isStruct(s) {
+		return g.structType(s)
+	}
+	if len(s.AdditionalProperties) > 0 && string(s.AdditionalProperties) != "true" && string(s.AdditionalProperties) != "false" {
+		var value schema
+		if err := json.Unmarshal(s.AdditionalProperties, &value); err == nil {
+			return "map[string]" + g.goType(&value)
+		}
+	}
+	if s.Type == "object" {
+		return "map[string]interface{}"
+	}
+	return "interface{}"
+}
+
+// refType returns the type of values of s passed as arguments or results,
+// named structs are passed by pointer
+func (g *generator) refType(s *schema) (typ string, pointer bool) {
+	typ = g.goType(s)
+	if s.Ref == "" {
+		return typ, false
+	}
+	target := g.doc.Components.Schemas[s.Ref[strings.LastIndexByte(s.Ref, '/')+1:]]
+	if isStruct(target) {
+		return "*" + typ, true
+	}
+	return typ, false
+}
+
+func (g *generator) genOperation(op *operation) {
+	name := g.unique(operationName(op))
+	var (
+		args      = []string{"ctx context.Context"}
+		pathExpr  = strconv.Quote(op.path)
+		optionals []*parameter
+	)
+	for _, param := range op.Parameters {
+		switch param.In {
+		case "path":
+			arg := ident(param.Name)
+			args = append(args, arg+" "+g.goType(param.Schema))
+			pathExpr = strings.Replace(pathExpr, "{"+param.Name+"}", `" + url.PathEscape(fmt.Sprint(`+arg+`)) + "`, 1)
+			g.imports["fmt"], g.imports["net/url"] = true, true
+		case "query", "header":
+			optionals = append(optionals, param)
+		}
+	}
+	pathExpr = strings.Replace(pathExpr, ` + ""`, "", -1)
+	pathExpr = strings.Replace(pathExpr, `"" + `, "", -1)
+
+	var paramsType string
+	if len(optionals) > 0 {
+		paramsType = g.unique(name + "Params")
+		g.genParams(paramsType, op, optionals)
+		args = append(args, "params *"+paramsType)
+	}
+	var bodySchema = op.RequestBody.jsonSchema()
+	if bodySchema != nil {
+		typ, _ := g.refType(bodySchema)
+		args = append(args, "body "+typ)
+	}
+	var result = op.result()
+	var resultType, zero string
+	var pointer bool
+	if result != nil {
+		resultType, pointer = g.refType(result)
+		zero = "out"
+		if pointer {
+			zero = "&out"
+		}
+	}
+
+	g.imports["net/http"] = true
+	g.p("")
+	g.p("// %s %s %s", name, op.method, op.path)
+	if op.Summary != "" {
+		g.p("//")
+		g.p("// %s", strings.Join(strings.Fields(op.Summary), " "))
+	}
+	if result != nil {
+		g.p("func (c *Client) %s(%s) (%s, error) {", name, strings.Join(args, ", "), resultType)
+	} else {
+		g.p("func (c *Client) %s(%s) error {", name, strings.Join(args, ", "))
+	}
+	g.p("var req = rest.Request{")
+	g.p("Method: %s,", httpMethods[op.method])
+	g.p("Route: %q,", op.path)
+	if pathExpr != strconv.Quote(op.path) {
+		g.p("Path: %s,", pathExpr)
+	}
+	if bodySchema != nil {
+		g.p("Body: body,")
+	}
+	g.p("}")
+	if paramsType != "" {
+		g.p("if params != nil {")
+		g.p("params.apply(&req)")
+		g.p("}")
+	}
+	if result == nil {
+		g.p("return c.rest.Do(ctx, req, nil)")
+		g.p("}")
+		return
+	}
+	g.p("var out %s", strings.TrimPrefix(resultType, "*"))
+	g.p("if err := c.rest.Do(ctx, req, &out); err != nil {")
+	if pointer {
+		g.p("return nil, err")
+	} else {
+		g.p("return out, err")
+	}
+	g.p("}")
+	g.p("return %s, nil", zero)
+	g.p("}")
+}
+
+// genParams writes the struct of query and header parameters of op, zero
+// values are not sent unless required
+func (g *generator) genParams(typeName string, op *operation, params []*parameter) {
+	g.imports["fmt"] = true
+	g.p("")
+	g.p("// %s are query and header parameters of %s %s,", typeName, op.method, op.path)
+	g.p("// zero values are not sent unless required")
+	g.p("type %s struct {", typeName)
+	for _, param := range params {
+		g.p("%s %s", exported(param.Name), g.goType(param.Schema))
+	}
+	g.p("}")
+	g.p("")
+	g.p("func (params *%s) apply(req *rest.Request) {", typeName)
+	var in = make(map[string]bool)
+	for _, param := range params {
+		in[param.In] = true
+	}
+	if in["query"] {
+		g.imports["net/url"] = true
+		g.p("if req.Query == nil {")
+		g.p("req.Query = url.Values{}")
+		g.p("}")
+	}
+	if in["header"] {
+		g.p("if req.Header == nil {")
+		g.p("req.Header = http.Header{}")
+		g.p("}")
+	}
+	for _, param := range params {
+		field := "params." + exported(param.Name)
+		typ := g.goType(param.Schema)
+		var set string
+		switch param.In {
+		case "query":
+			set = fmt.Sprintf("req.Query.Add(%q, fmt.Sprint(v))", param.Name)
+		case "header":
+			set = fmt.Sprintf("req.Header.Add(%q, fmt.Sprint(v))", param.Name)
+		}
+		switch {
+		case strings.HasPrefix(typ, "[]") && typ != "[]byte":
+			g.p("for _, v := range %s {", field)
+			g.p(set)
+			g.p("}")
+		case param.Required:
+			g.p("v := %s", field)
+			g.p(set)
+		default:
+			g.p("if v := %s; %s {", field, nonZero("v", typ))
+			g.p(set)
+			g.p("}")
+		}
+	}
+	g.p("}")
+}
+
+func nonZero(v, typ string) string {
+	switch typ {
+	case "string":
+		return v + ` != ""`
+	case "bool":
+		return v
+	case "int32", "int64", "float32", "float64":
+		return v + " != 0"
+	default:
+		return v + " != nil"
+	}
+}
+
+// operationName is the exported operationId, or else method and path
+func operationName(op *operation) string {
+	if op.OperationID != "" {
+		return exported(op.OperationID)
+	}
+	return exported(strings.ToLower(op.method) + " " + op.path)
+}
+
+func (g *generator) unique(name string) string {
+	candidate := name
+	for i := 2; g.names[candidate]; i++ {
+		candidate = name + strconv.Itoa(i)
+	}
+	g.names[candidate] = true
+	return candidate
+}
+
+// exported converts s, e.g. "user_id", "model.User" or "get /v1/users/{id}",
+// to an exported go identifier, "UserID", "ModelUser" and "GetV1UsersID"
+func exported(s string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if upper := strings.ToUpper(word); commonInitialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// ident converts s to an unexported go identifier which isn't a keyword
+func ident(s string) string {
+	name := []rune(exported(s))
+	for i := 0; i < len(name) && unicode.IsUpper(name[i]); i++ {
+		// lower the leading initialism, e.g. "ID" to "id", "URLPath" to "urlPath"
+		if i > 0 && i+1 < len(name) && unicode.IsLower(name[i+1]) {
+			break
+		}
+		name[i] = unicode.ToLower(name[i])
+	}
+	ret := string(name)
+	if token.IsKeyword(ret) || ret == "ctx" || ret == "params" || ret == "body" || ret == "req" || ret == "out" {
+		ret += "Param"
+	}
+	return ret
+}
+
+// commonInitialisms are spelled in upper case as golint suggests
+var commonInitialisms = map[string]bool{
+	"API": true, "HTTP": true, "ID": true, "IP": true, "JSON": true,
+	"UID": true, "URI": true, "URL": true, "UUID": true,
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const oas3 = `{
+  "openapi": "3.0.0",
+  "servers": [{"url": "http://127.0.0.1:9090/api/"}],
+  "paths": {
+    "/users/{user_id}": {
+      "parameters": [{"name": "user_id", "in": "path", "required": true, "schema": {"type": "integer", "format": "int64"}}],
+      "get": {
+        "operationId": "getUser",
+        "summary": "returns the user",
+        "parameters": [{"name": "fields", "in": "query", "schema": {"type": "array", "items": {"type": "string"}}}],
+        "responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}}}
+      },
+      "delete": {
+        "parameters": [{"name": "X-Token", "in": "header", "required": true, "schema": {"type": "string"}}],
+        "responses": {"204": {}}
+      }
+    },
+    "/users": {
+      "post": {
+        "operationId": "create_user",
+        "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+        "responses": {"200": {"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/User"}}}}}}
+      }
+    }
+  },
+  "components": {"schemas": {
+    "User": {
+      "type": "object",
+      "description": "is a member",
+      "required": ["id"],
+      "properties": {
+        "id": {"type": "integer", "format": "int64"},
+        "name": {"type": "string"},
+        "tags": {"type": "object", "additionalProperties": {"type": "string"}}
+      }
+    }
+  }}
+}`
+
+const swagger2 = `{
+  "swagger": "2.0",
+  "basePath": "/v1",
+  "paths": {
+    "/orders/{id}": {
+      "put": {
+        "parameters": [
+          {"name": "id", "in": "path", "required": true, "type": "string"},
+          {"name": "dry_run", "in": "query", "type": "boolean"},
+          {"name": "order", "in": "body", "schema": {"$ref": "#/definitions/Order"}}
+        ],
+        "responses": {"200": {"schema": {"$ref": "#/definitions/Order"}}}
+      }
+    }
+  },
+  "definitions": {
+    "Order": {"type": "object", "properties": {"price": {"type": "number", "format": "float"}}}
+  }
+}`
+
+func TestParse(t *testing.T) {
+	doc, err := parse([]byte(oas3))
+	assert.Nil(t, err)
+	assert.Len(t, doc.operations, 3)
+	assert.Equal(t, "POST", doc.operations[0].method)
+	assert.Equal(t, "/api/users", doc.operations[0].path)
+	assert.Equal(t, "GET", doc.operations[1].method)
+	assert.Equal(t, "DELETE", doc.operations[2].method)
+	// parameters of the path are merged into operations
+	assert.Len(t, doc.operations[1].Parameters, 2)
+	assert.Equal(t, "#/components/schemas/User", doc.operations[1].result().Ref)
+	assert.Nil(t, doc.operations[2].result())
+
+	doc, err = parse([]byte(swagger2))
+	assert.Nil(t, err)
+	assert.Len(t, doc.operations, 1)
+	op := doc.operations[0]
+	assert.Equal(t, "/v1/orders/{id}", op.path)
+	assert.Len(t, op.Parameters, 2)
+	assert.Equal(t, "boolean", op.Parameters[1].Schema.Type)
+	assert.Equal(t, "#/definitions/Order", op.RequestBody.jsonSchema().Ref)
+	assert.Equal(t, "#/definitions/Order", op.result().Ref)
+	assert.Contains(t, doc.Components.Schemas, "Order")
+
+	_, err = parse([]byte(`{"paths": {}}`))
+	assert.NotNil(t, err)
+}
+
+func TestGenerate(t *testing.T) {
+	doc, err := parse([]byte(oas3))
+	assert.Nil(t, err)
+	src, err := generate(doc, "userclient", "user", "openapi.json")
+	assert.Nil(t, err)
+	_, err = parser.ParseFile(token.NewFileSet(), "client.go", src, 0)
+	assert.Nil(t, err)
+
+	code := string(src)
+	assert.Contains(t, code, "// Code generated by jupiter client from openapi.json. DO NOT EDIT.")
+	assert.Contains(t, code, "package userclient")
+	assert.Contains(t, code, "// User is a member\ntype User struct {")
+	assert.Contains(t, code, "ID   int64             `json:\"id\"`")
+	assert.Contains(t, code, "Tags map[string]string `json:\"tags,omitempty\"`")
+	assert.Contains(t, code, "func (c *Client) GetUser(ctx context.Context, userID int64, params *GetUserParams) (*User, error) {")
+	assert.Contains(t, code, `Path:   "/api/users/" + url.PathEscape(fmt.Sprint(userID)),`)
+	assert.Contains(t, code, "func (c *Client) CreateUser(ctx context.Context, body *User) ([]User, error) {")
+	assert.Contains(t, code, "func (c *Client) DeleteAPIUsersUserID(ctx context.Context, userID int64, params *DeleteAPIUsersUserIDParams) error {")
+	assert.Contains(t, code, `req.Header.Add("X-Token", fmt.Sprint(v))`)
+
+	doc, err = parse([]byte(swagger2))
+	assert.Nil(t, err)
+	src, err = generate(doc, "orderclient", "order", "swagger.json")
+	assert.Nil(t, err)
+	code = string(src)
+	assert.Contains(t, code, "Price float32 `json:\"price,omitempty\"`")
+	assert.Contains(t, code, "func (c *Client) PutV1OrdersID(ctx context.Context, id string, params *PutV1OrdersIDParams, body *Order) (*Order, error) {")
+	assert.Contains(t, code, "if v := params.DryRun; v {")
+}
+
+func TestIdentifiers(t *testing.T) {
+	assert.Equal(t, "UserID", exported("user_id"))
+	assert.Equal(t, "ModelUser", exported("model.User"))
+	assert.Equal(t, "GetV1UsersID", exported("get /v1/users/{id}"))
+	assert.Equal(t, "X2fa", exported("2fa"))
+	assert.Equal(t, "id", ident("id"))
+	assert.Equal(t, "userID", ident("user_id"))
+	assert.Equal(t, "urlPath", ident("url_path"))
+	assert.Equal(t, "typeParam", ident("type"))
+}
+
+func TestPickGovernor(t *testing.T) {
+	assert.Equal(t, "/jupiter/demo/governors/", governorPrefix("jupiter", "", "demo"))
+	assert.Equal(t, "/jupiter/t1/demo/governors/", governorPrefix("jupiter", "t1", "demo"))
+
+	addr, err := pickGovernor(map[string]string{"/jupiter/demo/governors/http://127.0.0.1:9990": "{}"})
+	assert.Nil(t, err)
+	assert.Equal(t, "http://127.0.0.1:9990", addr)
+	assert.Equal(t, "http://127.0.0.1:9990/openapi.json", specURL(addr, "/openapi.json"))
+	assert.Equal(t, "http://127.0.0.1:9990/openapi.json", specURL("127.0.0.1:9990", "openapi.json"))
+
+	_, err = pickGovernor(map[string]string{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,222 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// document is the subset of OpenAPI 3 and Swagger 2 documents used by the
+// generator, Swagger 2 documents are normalized to OpenAPI 3 by parse
+type document struct {
+	Swagger  string `json:"swagger"`
+	OpenAPI  string `json:"openapi"`
+	BasePath string `json:"basePath"`
+	Servers  []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths       map[string]map[string]json.RawMessage `json:"paths"`
+	Definitions map[string]*schema                    `json:"definitions"`
+	Components  struct {
+		Schemas map[string]*schema `json:"schemas"`
+	} `json:"components"`
+
+	// operations parsed from Paths
+	operations []*operation
+}
+
+type operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Parameters  []*parameter         `json:"parameters"`
+	RequestBody *body                `json:"requestBody"`
+	Responses   map[string]*response `json:"responses"`
+
+	method string
+	path   string
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *schema `json:"schema"`
+	// type of Swagger 2 parameters other than body
+	Type   string  `json:"type"`
+	Format string  `json:"format"`
+	Items  *schema `json:"items"`
+}
+
+type body struct {
+	Content map[string]struct {
+		Schema *schema `json:"schema"`
+	} `json:"content"`
+}
+
+type response struct {
+	body
+	// schema of Swagger 2 responses
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Description          string             `json:"description"`
+	Items                *schema            `json:"items"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+}
+
+// methods of path items in the order operations are generated
+var methods = []string{"get", "head", "post", "put", "patch", "delete", "options"}
+
+// parse decodes an OpenAPI 3 or Swagger 2 document in JSON
+func parse(data []byte) (*document, error) {
+	var doc document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "decode openapi document")
+	}
+	if doc.OpenAPI == "" && doc.Swagger == "" {
+		return nil, errors.New("neither openapi nor swagger document")
+	}
+	if doc.Components.Schemas == nil {
+		doc.Components.Schemas = doc.Definitions
+	}
+
+	var paths = make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		item := doc.Paths[path]
+		// parameters shared by operations of the path
+		var shared []*parameter
+		if raw, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(raw, &shared); err != nil {
+				return nil, errors.Wrapf(err, "decode parameters of %s", path)
+			}
+		}
+		for _, method := range methods {
+			raw, ok := item[method]
+			if !ok {
+				continue
+			}
+			var op operation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return nil, errors.Wrapf(err, "decode %s %s", method, path)
+			}
+			op.method, op.path = strings.ToUpper(method), doc.basePath()+path
+			op.Parameters = mergeParameters(shared, op.Parameters)
+			op.normalize()
+			doc.operations = append(doc.operations, &op)
+		}
+	}
+	return &doc, nil
+}
+
+// basePath is the path prefix of all operations
+func (doc *document) basePath() string {
+	var base = doc.BasePath
+	if len(doc.Servers) > 0 {
+		if u, err := url.Parse(doc.Servers[0].URL); err == nil {
+			base = u.Path
+		}
+	}
+	return strings.TrimSuffix(base, "/")
+}
+
+// mergeParameters returns parameters of the path overridden by those of the operation
+func mergeParameters(shared, own []*parameter) []*parameter {
+	var ret = make([]*parameter, 0, len(shared)+len(own))
+	for _, p := range shared {
+		var overridden bool
+		for _, o := range own {
+			overridden = overridden || (o.Name == p.Name && o.In == p.In)
+		}
+		if !overridden {
+			ret = append(ret, p)
+		}
+	}
+	return append(ret, own...)
+}
+
+// normalize converts Swagger 2 body parameters, parameter types and
+// response schemas to those of OpenAPI 3
+func (op *operation) normalize() {
+	var params = op.Parameters[:0]
+	for _, p := range op.Parameters {
+		switch {
+		case p.In == "body":
+			op.RequestBody = jsonBody(p.Schema)
+			continue
+		case p.Schema == nil:
+			p.Schema = &schema{Type: p.Type, Format: p.Format, Items: p.Items}
+		}
+		params = append(params, p)
+	}
+	op.Parameters = params
+	for _, resp := range op.Responses {
+		if resp.Schema != nil && resp.Content == nil {
+			resp.body = *jsonBody(resp.Schema)
+		}
+	}
+}
+
+func jsonBody(s *schema) *body {
+	var b body
+	b.Content = map[string]struct {
+		Schema *schema `json:"schema"`
+	}{"application/json": {Schema: s}}
+	return &b
+}
+
+// jsonSchema returns the schema of JSON content of b
+func (b *body) jsonSchema() *schema {
+	if b == nil {
+		return nil
+	}
+	for contentType, content := range b.Content {
+		if strings.HasPrefix(contentType, "application/json") || strings.HasSuffix(contentType, "+json") {
+			return content.Schema
+		}
+	}
+	return nil
+}
+
+// result returns the schema of the JSON response of the first 2xx status
+func (op *operation) result() *schema {
+	var codes = make([]string, 0, len(op.Responses))
+	for code := range op.Responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		if s := op.Responses[code].jsonSchema(); s != nil {
+			return s
+		}
+	}
+	return nil
+}
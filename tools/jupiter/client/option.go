@@ -0,0 +1,42 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import "time"
+
+const (
+	defaultPrefix  = "jupiter"
+	defaultPath    = "/openapi.json"
+	defaultPackage = "client"
+	defaultTimeout = 10 * time.Second
+)
+
+// Option ...
+type Option struct {
+	addr    string
+	spec    string
+	service string
+	etcd    []string
+	prefix  string
+	tenant  string
+	path    string
+	pkg     string
+	output  string
+	timeout time.Duration
+}
+
+var (
+	option Option
+)
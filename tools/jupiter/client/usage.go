@@ -0,0 +1,41 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+// ClientHelpTemplate ...
+const ClientHelpTemplate = `
+jupiter client [flags]
+
+Generates a typed client calling the service via pkg/client/rest from its
+OpenAPI document, which is read from --spec, or served by the governor of
+the instance at --addr or discovered via registry.
+
+The flags are:
+  -a,--addr       governor address of the instance
+  --spec          OpenAPI document, a file or an url
+  -s,--service    app name to discover the governor via registry
+  -e,--etcd       etcd endpoints of registry, repeatable
+  --prefix        key prefix of registry, jupiter by default
+  --tenant        tenant of services in registry, none by default
+  --path          path of the document on governor, /openapi.json by default
+  -p,--package    package of the generated client, client by default
+  -o,--output     file of the generated client, stdout by default
+  -t,--timeout    timeout of fetching the document, 10s by default
+Examples:
+   # Generate the client of a service discovered via registry
+   jupiter client -s demo -e 127.0.0.1:2379 -p democlient -o democlient/client.go
+   # Generate the client from a document
+   jupiter client --spec openapi.json -o client.go
+`
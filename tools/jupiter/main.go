@@ -15,8 +15,11 @@
 package main
 
 import (
+	"github.com/douyu/jupiter/tools/jupiter/call"
+	"github.com/douyu/jupiter/tools/jupiter/client"
 	"github.com/douyu/jupiter/tools/jupiter/new"
 	"github.com/douyu/jupiter/tools/jupiter/protoc"
+	"github.com/douyu/jupiter/tools/jupiter/top"
 	"log"
 	"os"
 
@@ -33,6 +36,9 @@ func main() {
 	app.Commands = []cli.Command{
 		new.Cmd,
 		protoc.Cmd,
+		top.Cmd,
+		call.Cmd,
+		client.Cmd,
 	}
 
 	err := app.Run(os.Args)
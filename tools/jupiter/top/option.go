@@ -0,0 +1,40 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package top
+
+import "time"
+
+const (
+	defaultInterval = 2 * time.Second
+	defaultLimit    = 30
+
+	sortByQPS     = "qps"
+	sortByErrors  = "errors"
+	sortByLatency = "latency"
+)
+
+// Option ...
+type Option struct {
+	addrs    []string
+	interval time.Duration
+	sortBy   string
+	method   string
+	limit    int
+	once     bool
+}
+
+var (
+	option Option
+)
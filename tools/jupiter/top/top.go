@@ -0,0 +1,207 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package top
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/douyu/jupiter/pkg/util/xcolor"
+	"github.com/urfave/cli"
+)
+
+// methodStat mirrors the items of governor /stats, latency in seconds
+type methodStat struct {
+	Type      string  `json:"type"`
+	Method    string  `json:"method"`
+	QPS       float64 `json:"qps"`
+	ErrorRate float64 `json:"errorRate"`
+	Latency   struct {
+		Mean float64 `json:"mean"`
+		P50  float64 `json:"p50"`
+		P90  float64 `json:"p90"`
+		P99  float64 `json:"p99"`
+		Max  float64 `json:"max"`
+	} `json:"latency"`
+}
+
+// row is a method aggregated over instances
+type row struct {
+	Type      string
+	Method    string
+	Instances int
+	QPS       float64
+	ErrorRate float64
+	Mean      float64
+	// P99 is the worst p99 of instances, quantiles can't be merged
+	P99 float64
+	Max float64
+}
+
+// Run polls /stats of instances and renders the aggregated view
+func Run(c *cli.Context) error {
+	option.addrs = c.StringSlice("addr")
+	if len(option.addrs) == 0 {
+		fmt.Println(xcolor.Red("no instance address, please use jupiter top -h for details"))
+		return nil
+	}
+
+	client := &http.Client{Timeout: option.interval}
+	for {
+		stats, errs := poll(client, option.addrs)
+		rows := aggregate(stats, option.method)
+		sortRows(rows, option.sortBy)
+		if option.limit > 0 && len(rows) > option.limit {
+			rows = rows[:option.limit]
+		}
+		if !option.once {
+			// clear screen
+			fmt.Print("\033[H\033[2J")
+		}
+		render(os.Stdout, rows, len(option.addrs)-len(errs), len(option.addrs))
+		for addr, err := range errs {
+			fmt.Println(xcolor.Red(fmt.Sprintf("%s: %v", addr, err)))
+		}
+		if option.once {
+			return nil
+		}
+		time.Sleep(option.interval)
+	}
+}
+
+func poll(client *http.Client, addrs []string) (map[string][]methodStat, map[string]error) {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		stats = make(map[string][]methodStat, len(addrs))
+		errs  = make(map[string]error)
+	)
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			items, err := fetch(client, addr)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[addr] = err
+				return
+			}
+			stats[addr] = items
+		}(addr)
+	}
+	wg.Wait()
+	return stats, errs
+}
+
+func fetch(client *http.Client, addr string) ([]methodStat, error) {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	resp, err := client.Get(strings.TrimSuffix(addr, "/") + "/stats")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var items []methodStat
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// aggregate merges methods over instances, error rate and mean are weighted by qps
+func aggregate(stats map[string][]methodStat, method string) []*row {
+	var rows = make(map[string]*row)
+	for _, items := range stats {
+		for _, item := range items {
+			if method != "" && !strings.Contains(item.Method, method) {
+				continue
+			}
+			key := item.Type + " " + item.Method
+			r, ok := rows[key]
+			if !ok {
+				r = &row{Type: item.Type, Method: item.Method}
+				rows[key] = r
+			}
+			r.Instances++
+			r.ErrorRate += item.ErrorRate * item.QPS
+			r.Mean += item.Latency.Mean * item.QPS
+			r.QPS += item.QPS
+			r.P99 = math.Max(r.P99, item.Latency.P99)
+			r.Max = math.Max(r.Max, item.Latency.Max)
+		}
+	}
+
+	var ret = make([]*row, 0, len(rows))
+	for _, r := range rows {
+		if r.QPS > 0 {
+			r.ErrorRate /= r.QPS
+			r.Mean /= r.QPS
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+func sortRows(rows []*row, sortBy string) {
+	var less func(a, b *row) bool
+	switch sortBy {
+	case sortByErrors:
+		less = func(a, b *row) bool { return a.ErrorRate*a.QPS > b.ErrorRate*b.QPS }
+	case sortByLatency:
+		less = func(a, b *row) bool { return a.P99 > b.P99 }
+	default:
+		less = func(a, b *row) bool { return a.QPS > b.QPS }
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if less(rows[i], rows[j]) {
+			return true
+		}
+		if less(rows[j], rows[i]) {
+			return false
+		}
+		return rows[i].Type+rows[i].Method < rows[j].Type+rows[j].Method
+	})
+}
+
+func render(w io.Writer, rows []*row, up, total int) {
+	fmt.Fprintf(w, "jupiter top - %s, instances %d/%d up\n\n", time.Now().Format("15:04:05"), up, total)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tMETHOD\tINST\tQPS\tERR%\tMEAN\tP99\tMAX")
+	for _, r := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f\t%.2f\t%s\t%s\t%s\n",
+			r.Type, r.Method, r.Instances, r.QPS, r.ErrorRate*100,
+			seconds(r.Mean), seconds(r.P99), seconds(r.Max),
+		)
+	}
+	_ = tw.Flush()
+}
+
+func seconds(v float64) time.Duration {
+	return time.Duration(v * float64(time.Second)).Round(time.Microsecond)
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package top
+
+import "github.com/urfave/cli"
+
+var Cmd = cli.Command{
+	Name:            "top",
+	Aliases:         []string{"t"},
+	Usage:           "live metrics of jupiter instances",
+	Action:          Run,
+	SkipFlagParsing: false,
+	UsageText:       TopHelpTemplate,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "addr,a",
+			Usage: "governor address of instances, e.g. 127.0.0.1:9990",
+		},
+		&cli.DurationFlag{
+			Name:        "interval,i",
+			Usage:       "polling interval",
+			Value:       defaultInterval,
+			Destination: &option.interval,
+		},
+		&cli.StringFlag{
+			Name:        "sort,s",
+			Usage:       "sort by qps|errors|latency",
+			Value:       sortByQPS,
+			Destination: &option.sortBy,
+		},
+		&cli.StringFlag{
+			Name:        "method,m",
+			Usage:       "only show methods containing it",
+			Destination: &option.method,
+		},
+		&cli.IntFlag{
+			Name:        "limit,n",
+			Usage:       "max methods to show",
+			Value:       defaultLimit,
+			Destination: &option.limit,
+		},
+		&cli.BoolFlag{
+			Name:        "once",
+			Usage:       "print once and exit",
+			Destination: &option.once,
+		},
+	},
+}
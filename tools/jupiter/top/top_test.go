@@ -0,0 +1,57 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package top
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func stat(typ, method string, qps, errorRate, mean, p99 float64) methodStat {
+	var ms = methodStat{Type: typ, Method: method, QPS: qps, ErrorRate: errorRate}
+	ms.Latency.Mean, ms.Latency.P99, ms.Latency.Max = mean, p99, p99
+	return ms
+}
+
+func TestAggregate(t *testing.T) {
+	stats := map[string][]methodStat{
+		"a": {stat("unary", "/demo.Hello/Say", 30, 0, 0.01, 0.05), stat("http", "GET./ping", 5, 0, 0.001, 0.002)},
+		"b": {stat("unary", "/demo.Hello/Say", 10, 0.4, 0.03, 0.2)},
+	}
+
+	rows := aggregate(stats, "")
+	sortRows(rows, sortByQPS)
+	if len(rows) != 2 || rows[0].Method != "/demo.Hello/Say" {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+	say := rows[0]
+	if say.Instances != 2 || say.QPS != 40 || say.ErrorRate != 0.1 || say.P99 != 0.2 {
+		t.Errorf("unexpected row: %+v", say)
+	}
+	if say.Mean < 0.0149 || say.Mean > 0.0151 {
+		t.Errorf("mean = %v, want 0.015", say.Mean)
+	}
+
+	if rows := aggregate(stats, "ping"); len(rows) != 1 {
+		t.Errorf("filtered rows = %d, want 1", len(rows))
+	}
+
+	var buf bytes.Buffer
+	render(&buf, rows, 2, 2)
+	if !strings.Contains(buf.String(), "/demo.Hello/Say") || !strings.Contains(buf.String(), "2/2 up") {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 Douyu
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package top
+
+// TopHelpTemplate ...
+const TopHelpTemplate = `
+jupiter top [flags]
+
+The flags are:
+  -a,--addr       governor address of instances, repeatable
+  -i,--interval   polling interval, 2s by default
+  -s,--sort       sort by qps|errors|latency, qps by default
+  -m,--method     only show methods containing it
+  -n,--limit      max methods to show, 30 by default
+  --once          print once and exit
+Examples:
+   # Watch two instances sorted by p99 latency
+   jupiter top -a 10.0.0.1:9990 -a 10.0.0.2:9990 -s latency
+`